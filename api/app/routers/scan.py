@@ -1,7 +1,12 @@
 import os
+import hmac
+import asyncio
+import json
+import hashlib
 import httpx
+from collections import OrderedDict
 from datetime import datetime
-from fastapi import APIRouter, Depends, HTTPException
+from fastapi import APIRouter, Depends, HTTPException, Request
 from sqlalchemy import select, func, cast
 from sqlalchemy.ext.asyncio import AsyncSession
 from sqlalchemy.dialects.postgresql import insert, INET
@@ -13,17 +18,99 @@ from app.schemas import ScanResults, DashboardStats
 router = APIRouter(tags=["scan"])
 
 SCANNER_URL = os.getenv("SCANNER_URL", "http://scanner:8081")
+SCANNER_CONTROL_TOKEN = os.getenv("SCANNER_CONTROL_TOKEN", "")
+RESULT_HMAC_KEY = os.getenv("RESULT_HMAC_KEY", "")
+
+# Responses to recent submissions by Idempotency-Key, so a batch the scanner
+# sends again is acknowledged without being applied twice, and the endpoints
+# recently applied for each host by its idempotency_key, so a host resent in
+# another batch is skipped. Per process and lost on restart; submission_lock
+# makes each check and the apply that follows it one step.
+IDEMPOTENCY_CACHE_SIZE = 10000
+recent_submissions: "OrderedDict[str, dict]" = OrderedDict()
+recent_hosts: "OrderedDict[str, set]" = OrderedDict()
+submission_lock = asyncio.Lock()
+
+
+def scanner_headers() -> dict:
+    """Auth headers for the scanner control server, if a token is configured."""
+    if SCANNER_CONTROL_TOKEN:
+        return {"Authorization": f"Bearer {SCANNER_CONTROL_TOKEN}"}
+    return {}
+
+
+def verify_payload(raw: bytes) -> None:
+    """Check payload_sha256 (and signature when RESULT_HMAC_KEY is set).
+
+    Must match the scanner's db.CanonicalJSON: sorted keys, no whitespace,
+    signature fields removed.
+    """
+    try:
+        payload = json.loads(raw)
+    except ValueError:
+        raise HTTPException(status_code=400, detail="Invalid JSON")
+
+    digest = payload.pop("payload_sha256", None)
+    signature = payload.pop("signature", None)
+    if not digest and not RESULT_HMAC_KEY:
+        return  # unsigned submissions are accepted without a key
+
+    canonical = json.dumps(payload, sort_keys=True, separators=(",", ":"), ensure_ascii=False).encode()
+    if not digest or not hmac.compare_digest(digest, hashlib.sha256(canonical).hexdigest()):
+        raise HTTPException(status_code=400, detail="payload_sha256 does not match payload")
+    if RESULT_HMAC_KEY:
+        expected = hmac.new(RESULT_HMAC_KEY.encode(), canonical, hashlib.sha256).hexdigest()
+        if not signature or not hmac.compare_digest(signature, expected):
+            raise HTTPException(status_code=401, detail="Invalid result signature")
 
 
 @router.post("/scan/results")
-async def receive_scan_results(results: ScanResults, db: AsyncSession = Depends(get_db)):
+async def receive_scan_results(results: ScanResults, request: Request, db: AsyncSession = Depends(get_db)):
     """Receive scan results from the scanner service."""
-    scan_id = results.scan_id
+    verify_payload(await request.body())
+    idempotency_key = request.headers.get("Idempotency-Key")
+    # A retry arriving while its first attempt is still being applied waits
+    # for it here, then finds it recorded
+    async with submission_lock:
+        if idempotency_key and idempotency_key in recent_submissions:
+            return {**recent_submissions[idempotency_key], "duplicate": True}
+
+        hosts = [h for h in results.hosts if not host_applied(h)]
+        response = await apply_scan_results(results.scan_id, hosts, db)
+        response["hosts_skipped"] = len(results.hosts) - len(hosts)
+
+        for host_data in hosts:
+            if host_data.idempotency_key:
+                applied = recent_hosts.setdefault(host_data.idempotency_key, set())
+                applied.update(host_endpoints(host_data))
+                recent_hosts.move_to_end(host_data.idempotency_key)
+                if len(recent_hosts) > IDEMPOTENCY_CACHE_SIZE:
+                    recent_hosts.popitem(last=False)
+        if idempotency_key:
+            recent_submissions[idempotency_key] = response
+            if len(recent_submissions) > IDEMPOTENCY_CACHE_SIZE:
+                recent_submissions.popitem(last=False)
+        return response
+
+
+def host_endpoints(host_data) -> set:
+    """The (port, protocol, state) entries a host result reports."""
+    return {(p.port_number, p.protocol, p.state) for p in host_data.ports}
+
+
+def host_applied(host_data) -> bool:
+    """Whether everything a keyed host result reports was applied already."""
+    applied = recent_hosts.get(host_data.idempotency_key) if host_data.idempotency_key else None
+    return applied is not None and host_endpoints(host_data) <= applied
+
+
+async def apply_scan_results(scan_id, hosts, db: AsyncSession) -> dict:
+    """Upsert hosts, ports and services and record the events they cause."""
     events_created = 0
     hosts_processed = 0
     ports_processed = 0
 
-    for host_data in results.hosts:
+    for host_data in hosts:
         # Upsert host
         host_stmt = insert(Host).values(
             ip_address=host_data.ip_address,
@@ -65,8 +152,6 @@ async def receive_scan_results(results: ScanResults, db: AsyncSession = Depends(
         # Process ports for this host
         seen_ports = set()
         for port_data in host_data.ports:
-            seen_ports.add((port_data.port_number, port_data.protocol))
-
             # Check if port already exists
             port_query = select(Port).where(
                 Port.host_id == host.id,
@@ -76,6 +161,28 @@ async def receive_scan_results(results: ScanResults, db: AsyncSession = Depends(
             port_result = await db.execute(port_query)
             existing_port = port_result.scalar_one_or_none()
 
+            # Verify re-scans report ports that stopped answering explicitly
+            if port_data.state == "closed":
+                if existing_port and existing_port.is_active:
+                    existing_port.is_active = False
+                    existing_port.state = "closed"
+                    event = ScanEvent(
+                        scan_id=scan_id,
+                        event_type="port_closed",
+                        host_id=host.id,
+                        port_id=existing_port.id,
+                        details={
+                            "port_number": existing_port.port_number,
+                            "protocol": existing_port.protocol,
+                        },
+                    )
+                    db.add(event)
+                    events_created += 1
+                ports_processed += 1
+                continue
+
+            seen_ports.add((port_data.port_number, port_data.protocol))
+
             if existing_port:
                 # Update existing port
                 was_inactive = not existing_port.is_active
@@ -195,7 +302,7 @@ async def trigger_scan():
     """Trigger a manual network scan."""
     try:
         async with httpx.AsyncClient(timeout=10.0) as client:
-            response = await client.post(f"{SCANNER_URL}/trigger")
+            response = await client.post(f"{SCANNER_URL}/trigger", headers=scanner_headers())
             return response.json()
     except httpx.RequestError as e:
         raise HTTPException(status_code=503, detail=f"Scanner unavailable: {str(e)}")
@@ -206,7 +313,7 @@ async def get_scan_status():
     """Get current scanner status."""
     try:
         async with httpx.AsyncClient(timeout=10.0) as client:
-            response = await client.get(f"{SCANNER_URL}/status")
+            response = await client.get(f"{SCANNER_URL}/status", headers=scanner_headers())
             return response.json()
     except httpx.RequestError as e:
         raise HTTPException(status_code=503, detail=f"Scanner unavailable: {str(e)}")
@@ -155,14 +155,22 @@ class ScanResultPort(BaseModel):
 
 class ScanResultHost(BaseModel):
     ip_address: str
+    address_family: Optional[str] = None  # "ipv4" or "ipv6"
     hostname: Optional[str] = None
     mac_address: Optional[str] = None
     ports: list[ScanResultPort] = []
+    idempotency_key: Optional[str] = None  # scan ID + IP, the same on retries
 
 
 class ScanResults(BaseModel):
     scan_id: UUID
     hosts: list[ScanResultHost]
+    tags: Optional[dict[str, str]] = None
+    started_at: Optional[datetime] = None
+    completed_at: Optional[datetime] = None
+    expires_at: Optional[datetime] = None
+    payload_sha256: Optional[str] = None
+    signature: Optional[str] = None
 
 
 # Stats schema
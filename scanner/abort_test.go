@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"network-scanner/config"
+)
+
+// resetScanState restores the scan globals beginScan sets once t finishes
+func resetScanState(t *testing.T) {
+	t.Helper()
+	t.Cleanup(func() {
+		scanMutex.Lock()
+		isScanning = false
+		cancelScan = nil
+		lastScanTime = time.Time{}
+		scanMutex.Unlock()
+	})
+}
+
+func TestAbortAll(t *testing.T) {
+	resetScanState(t)
+	healthy := true
+	s := newTestServer(t, &config.Config{}, stubAPI(t, &healthy).URL)
+	started := make(chan struct{}, 2)
+	s.runScan = func(map[string]string) { started <- struct{}{} }
+	handler := s.routes()
+
+	var resp abortResponse
+	if code := post(t, handler, "/scan/abort-all", &resp); code != http.StatusOK || resp.Status != "not_running" || resp.Aborted == nil || len(resp.Aborted) != 0 {
+		t.Fatalf("abort with no scan = %d %+v, want not_running and nothing aborted", code, resp)
+	}
+
+	ctx, endScan, ok := beginScan(time.Hour)
+	if !ok {
+		t.Fatal("beginScan refused with no scan running")
+	}
+	if _, _, ok := beginScan(time.Hour); ok {
+		t.Fatal("second beginScan started while a scan runs")
+	}
+	// A trigger during the scan is refused, so there is nothing queued
+	if code, trig := postTrigger(t, handler, ""); code != http.StatusOK || trig.Status != "already_running" {
+		t.Fatalf("trigger during a scan = %d %+v, want already_running", code, trig)
+	}
+
+	if code := post(t, handler, "/scan/abort-all", &resp); code != http.StatusOK || resp.Status != "aborted" || len(resp.Aborted) != 1 || resp.Aborted[0] != "running_scan" {
+		t.Fatalf("abort = %d %+v, want the running scan aborted", code, resp)
+	}
+	select {
+	case <-ctx.Done():
+	default:
+		t.Fatal("scan context not cancelled by the abort")
+	}
+
+	// The scan is still unwinding; aborting again is harmless
+	if code := post(t, handler, "/scan/abort-all", &resp); code != http.StatusOK || resp.Status != "aborted" {
+		t.Errorf("second abort while unwinding = %d %+v", code, resp)
+	}
+	endScan()
+	if code := post(t, handler, "/scan/abort-all", &resp); code != http.StatusOK || resp.Status != "not_running" {
+		t.Errorf("abort after the scan ended = %d %+v, want not_running", code, resp)
+	}
+
+	// Aborting leaves nothing behind that stops the next scan
+	if code, trig := postTrigger(t, handler, ""); code != http.StatusOK || trig.Status != "started" {
+		t.Fatalf("trigger after the abort = %d %+v, want started", code, trig)
+	}
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("triggered scan never ran")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if len(started) != 0 {
+		t.Error("the refused trigger was queued and ran as well")
+	}
+}
+
+func TestAbortAllRequiresAuth(t *testing.T) {
+	resetScanState(t)
+	healthy := true
+	s := newTestServer(t, &config.Config{ControlAuthToken: "secret"}, stubAPI(t, &healthy).URL)
+	ctx, endScan, _ := beginScan(time.Hour)
+	defer endScan()
+
+	rec := httptest.NewRecorder()
+	s.routes().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/scan/abort-all", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("abort without a token = %d, want 401", rec.Code)
+	}
+	if ctx.Err() != nil {
+		t.Error("unauthenticated abort cancelled the scan")
+	}
+}
+
+func TestAbortAllRacesScanEnd(t *testing.T) {
+	resetScanState(t)
+	healthy := true
+	handler := newTestServer(t, &config.Config{}, stubAPI(t, &healthy).URL).routes()
+
+	// Scans start and finish back to back while aborts arrive. Run with
+	// -race; every scan cancelled before it ended was cancelled by an abort
+	// that said so.
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	var mu sync.Mutex
+	aborted, scans, cancelled := 0, 0, 0
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			ctx, endScan, ok := beginScan(time.Hour)
+			if !ok {
+				t.Error("beginScan refused with no scan running")
+				return
+			}
+			time.Sleep(50 * time.Microsecond)
+			// Cancelled before end is called, so by an abort
+			wasCancelled := ctx.Err() != nil
+			endScan()
+			mu.Lock()
+			scans++
+			if wasCancelled {
+				cancelled++
+			}
+			mu.Unlock()
+		}
+		close(done)
+	}()
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/scan/abort-all", nil))
+				var resp abortResponse
+				if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+					t.Errorf("decoding %q: %v", rec.Body.String(), err)
+					return
+				}
+				if resp.Status == "aborted" {
+					mu.Lock()
+					aborted++
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if cancelled > scans || aborted < cancelled {
+		t.Errorf("%d aborts reported, %d of %d scans cancelled", aborted, cancelled, scans)
+	}
+	var resp abortResponse
+	if post(t, handler, "/scan/abort-all", &resp); resp.Status != "not_running" {
+		t.Errorf("abort after every scan ended = %+v, want not_running", resp)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"network-scanner/scanner"
+)
+
+// Ad-hoc fingerprint limits: ports per request and how long the request
+// may take
+const (
+	maxAdhocPorts = 100
+	adhocTimeout  = 2 * time.Minute
+)
+
+type fingerprintRequest struct {
+	IP    string `json:"ip"`
+	Ports []int  `json:"ports"`
+}
+
+type fingerprintResponse struct {
+	IP       string                         `json:"ip"`
+	Services map[string]scanner.ServiceInfo `json:"services"` // keyed by port number
+}
+
+// handleFingerprint fingerprints the requested ports of one IP with the
+// active fingerprinter and returns the result. Nothing is recorded or
+// submitted, and the scan schedule is untouched; targets outside the scan
+// scope are refused.
+func (s *controlServer) handleFingerprint(w http.ResponseWriter, r *http.Request) {
+	var req fingerprintRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, actionResponse{Status: "invalid_request", Message: "invalid JSON body: " + err.Error()})
+		return
+	}
+	ip := net.ParseIP(req.IP)
+	if ip == nil {
+		writeJSON(w, http.StatusBadRequest, actionResponse{Status: "invalid_request", Message: fmt.Sprintf("invalid ip %q", req.IP)})
+		return
+	}
+	if len(req.Ports) == 0 || len(req.Ports) > maxAdhocPorts {
+		writeJSON(w, http.StatusBadRequest, actionResponse{Status: "invalid_request", Message: fmt.Sprintf("ports must list 1 to %d ports", maxAdhocPorts)})
+		return
+	}
+	seen := make(map[int]bool, len(req.Ports))
+	ports := make([]int, 0, len(req.Ports))
+	for _, port := range req.Ports {
+		if port < 1 || port > 65535 {
+			writeJSON(w, http.StatusBadRequest, actionResponse{Status: "invalid_request", Message: fmt.Sprintf("invalid port %d", port)})
+			return
+		}
+		if !seen[port] {
+			seen[port] = true
+			ports = append(ports, port)
+		}
+	}
+	if !s.scope.Contains(ip) {
+		writeJSON(w, http.StatusForbidden, actionResponse{Status: "out_of_scope", Message: fmt.Sprintf("%s is outside the scan scope", ip)})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), adhocTimeout)
+	defer cancel()
+
+	log.Printf("Ad-hoc fingerprint of %s ports %v", ip, ports)
+	results := s.fingerprinter.FingerprintHost(ctx, ip.String(), ports)
+
+	resp := fingerprintResponse{IP: ip.String(), Services: make(map[string]scanner.ServiceInfo, len(results))}
+	for port, info := range results {
+		resp.Services[fmt.Sprint(port)] = info
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"network-scanner/config"
+	"network-scanner/scanner"
+)
+
+// countingFingerprinter fingerprints nothing and counts its calls
+type countingFingerprinter struct{ calls int }
+
+func (f *countingFingerprinter) FingerprintHost(ctx context.Context, ip string, ports []int) map[int]scanner.ServiceInfo {
+	f.calls++
+	return nil
+}
+
+// postFingerprint sends body to POST /fingerprint with auth, and returns
+// the response code and body
+func postFingerprint(t *testing.T, handler http.Handler, body, auth string) (int, string) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/fingerprint", strings.NewReader(body))
+	if auth != "" {
+		req.Header.Set("Authorization", "Bearer "+auth)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec.Code, rec.Body.String()
+}
+
+func TestFingerprintEndpoint(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte("220 mail.example ESMTP Postfix\r\n"))
+			conn.Close()
+		}
+	}()
+	port := listener.Addr().(*net.TCPAddr).Port
+	portText := strconv.Itoa(port)
+
+	healthy := true
+	s := newTestServer(t, &config.Config{ControlAuthToken: "secret"}, stubAPI(t, &healthy).URL)
+	f := scanner.NewFingerprinter()
+	f.Timeout = 2 * time.Second
+	s.fingerprinter = f
+	started := false
+	s.runScan = func(map[string]string) { started = true }
+	s.results = &lastResults{}
+	handler := s.routes()
+
+	body := fmt.Sprintf(`{"ip": "127.0.0.1", "ports": [%d, %d]}`, port, port)
+	if code, _ := postFingerprint(t, handler, body, ""); code != http.StatusUnauthorized {
+		t.Fatalf("POST /fingerprint without a token = %d, want 401", code)
+	}
+	code, raw := postFingerprint(t, handler, body, "secret")
+	if code != http.StatusOK {
+		t.Fatalf("POST /fingerprint = %d %s, want 200", code, raw)
+	}
+	var resp fingerprintResponse
+	if err := json.Unmarshal([]byte(raw), &resp); err != nil {
+		t.Fatalf("decoding %q: %v", raw, err)
+	}
+	info, ok := resp.Services[portText]
+	if resp.IP != "127.0.0.1" || len(resp.Services) != 1 || !ok {
+		t.Fatalf("response = %+v, want one entry for port %d", resp, port)
+	}
+	if info.Banner != "220 mail.example ESMTP Postfix" {
+		t.Errorf("service info = %+v, want the listener's banner", info)
+	}
+
+	// Nothing was recorded or scheduled
+	if started || s.results.spool != nil {
+		t.Errorf("ad-hoc fingerprint started a scan (%v) or recorded results", started)
+	}
+}
+
+func TestFingerprintEndpointRejects(t *testing.T) {
+	healthy := true
+	s := newTestServer(t, &config.Config{}, stubAPI(t, &healthy).URL)
+	f := &countingFingerprinter{}
+	s.fingerprinter = f
+	scope, err := scanner.NewScope(nil, []string{"10.9.0.0/16"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.scope = scope
+	handler := s.routes()
+
+	tooMany := make([]string, maxAdhocPorts+1)
+	for i := range tooMany {
+		tooMany[i] = strconv.Itoa(i + 1)
+	}
+	for body, want := range map[string]int{
+		`not json`:                                                          http.StatusBadRequest,
+		`{"ip": "host.example", "ports": [22]}`:                             http.StatusBadRequest,
+		`{"ip": "10.0.0.1", "ports": []}`:                                   http.StatusBadRequest,
+		`{"ip": "10.0.0.1", "ports": [0]}`:                                  http.StatusBadRequest,
+		`{"ip": "10.0.0.1", "ports": [22, 70000]}`:                          http.StatusBadRequest,
+		`{"ip": "10.0.0.1", "ports": [` + strings.Join(tooMany, ",") + `]}`: http.StatusBadRequest,
+		`{"ip": "10.9.1.1", "ports": [22]}`:                                 http.StatusForbidden,
+	} {
+		if code, resp := postFingerprint(t, handler, body, ""); code != want {
+			t.Errorf("POST /fingerprint %.60s = %d %s, want %d", body, code, resp, want)
+		}
+	}
+	if f.calls != 0 {
+		t.Errorf("fingerprinter called %d times for rejected requests", f.calls)
+	}
+}
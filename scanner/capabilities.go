@@ -0,0 +1,26 @@
+package main
+
+import (
+	"net/http"
+
+	"network-scanner/scanner"
+)
+
+type capabilitiesResponse struct {
+	ScanMode        string                      `json:"scan_mode"`        // e.g. "tcp", "zmap" or "tcp+zmap"
+	FingerprintMode string                      `json:"fingerprint_mode"` // "zgrab2" or "native"
+	Native          []scanner.ServiceCapability `json:"native"`
+	Zgrab2          []scanner.ServiceCapability `json:"zgrab2"`
+}
+
+// handleCapabilities lists the services each fingerprinter identifies and
+// the ports routed to them, taken from the probe routing tables. Ports
+// listed nowhere get a generic banner grab.
+func (s *controlServer) handleCapabilities(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, capabilitiesResponse{
+		ScanMode:        s.scanMode,
+		FingerprintMode: s.fingerprintMode,
+		Native:          scanner.NativeCapabilities(),
+		Zgrab2:          scanner.ZgrabCapabilities(),
+	})
+}
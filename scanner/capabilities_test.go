@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"network-scanner/config"
+	"network-scanner/scanner"
+)
+
+// capabilityPorts maps each service to its ports
+func capabilityPorts(capabilities []scanner.ServiceCapability) map[string][]int {
+	ports := make(map[string][]int, len(capabilities))
+	for _, c := range capabilities {
+		ports[c.Service] = c.Ports
+	}
+	return ports
+}
+
+func TestCapabilitiesEndpoint(t *testing.T) {
+	healthy := true
+	s := newTestServer(t, &config.Config{}, stubAPI(t, &healthy).URL)
+	s.scanMode = "tcp+zmap"
+	s.fingerprintMode = "native"
+
+	var resp capabilitiesResponse
+	if code := get(t, s.routes(), "/capabilities", &resp); code != http.StatusOK {
+		t.Fatalf("GET /capabilities = %d", code)
+	}
+	if resp.ScanMode != "tcp+zmap" || resp.FingerprintMode != "native" {
+		t.Errorf("modes = %q, %q, want tcp+zmap and native", resp.ScanMode, resp.FingerprintMode)
+	}
+
+	native := capabilityPorts(resp.Native)
+	for service, want := range map[string][]int{
+		"ssh":        {22},
+		"http":       {80, 8000, 8080, 8888},
+		"https":      {443, 8443},
+		"mysql":      {3306},
+		"redis":      {6379},
+		"mongodb":    {27017},
+		"smtp":       {25, 587},
+		"kubelet":    {10255},
+		"postgresql": {5432},
+	} {
+		if got := native[service]; !reflect.DeepEqual(got, want) {
+			t.Errorf("native %s ports = %v, want %v", service, got, want)
+		}
+	}
+	// Implicit-TLS mail ports only get a native banner grab
+	if _, ok := native["imaps"]; ok {
+		t.Errorf("native lists imaps, which has no native probe: %v", native["imaps"])
+	}
+
+	zgrab := capabilityPorts(resp.Zgrab2)
+	for module, want := range map[string][]int{
+		"ssh":      {22},
+		"http":     {80, 443, 3128, 8000, 8080, 8443, 8888},
+		"mysql":    {3306},
+		"imap":     {143, 993},
+		"postgres": {5432},
+	} {
+		if got := zgrab[module]; !reflect.DeepEqual(got, want) {
+			t.Errorf("zgrab2 %s ports = %v, want %v", module, got, want)
+		}
+	}
+	// These have no zgrab2 module and always use the native probe
+	for _, service := range []string{"socks", "docker", "tacacs"} {
+		if _, ok := zgrab[service]; ok {
+			t.Errorf("zgrab2 lists %s", service)
+		}
+	}
+}
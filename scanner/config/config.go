@@ -1,21 +1,432 @@
 package config
 
 import (
+	"fmt"
+	"net"
+	"net/url"
 	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/robfig/cron/v3"
 	"gopkg.in/yaml.v3"
+
+	"network-scanner/db"
 )
 
+// DefaultMaxResultsInMemory is the max_results_in_memory default
+const DefaultMaxResultsInMemory = 50000
+
+// DefaultScanTimeBudget is the port scan duration auto_rate aims for
+const DefaultScanTimeBudget = 10 * time.Minute
+
+// Bounds for re-running scans that find nothing
+const (
+	DefaultRetryEmptyDelay = 30 * time.Second
+	MaxRetryEmptyScans     = 5
+)
+
+// MaxMultiProbes caps the probes multi_probe runs at once on one port
+const MaxMultiProbes = 4
+
 type Config struct {
-	Networks     []string `yaml:"networks"`
-	ScanAllPorts bool     `yaml:"scan_all_ports"`
-	Ports        []int    `yaml:"ports"`
-	Schedule     string   `yaml:"schedule"`
-	ScannerMode  string   `yaml:"scanner_mode"` // "zmap" or "tcp"
-	Rate         int      `yaml:"rate"`
-	Timeout      int      `yaml:"timeout"`
-	Interface    string   `yaml:"interface"`
-	APIURL       string   `yaml:"api_url"`
+	Networks     []Network `yaml:"networks"`
+	ScanAllPorts bool      `yaml:"scan_all_ports"`
+	Ports        PortList  `yaml:"ports"` // numbers or specs like "1-1024,!135"
+	Schedule     string    `yaml:"schedule"`
+	ScannerMode  string    `yaml:"scanner_mode"` // "zmap" or "tcp"
+	Rate         int       `yaml:"rate"`
+	Timeout      int       `yaml:"timeout"`
+	Interface    string    `yaml:"interface"`
+	APIURL       string    `yaml:"api_url"`
+	ListenAddr   string    `yaml:"listen_addr"` // control server bind address, e.g. ":8081" or "127.0.0.1:8081"
+
+	// auto_rate picks each scanner's rate from its target count (IPs x
+	// ports) so the port scan fits scan_time_budget, capped at max_rate
+	// (0 = rate)
+	AutoRate       bool          `yaml:"auto_rate"`
+	ScanTimeBudget time.Duration `yaml:"scan_time_budget"`
+	MaxRate        int           `yaml:"max_rate"`
+
+	// Random delay of up to schedule_jitter before each scheduled scan, so
+	// scanners sharing a schedule don't all start at once. Each delay is
+	// measured from its own cron tick, so delays never add up to drift.
+	ScheduleJitter time.Duration `yaml:"schedule_jitter"`
+
+	// Daily window scans may run in, e.g. "22:00-06:00" (crossing midnight is
+	// fine), in scan_window_timezone (an IANA zone; default local time).
+	// Outside it scheduled scans are skipped and /trigger refuses without
+	// force=true.
+	ScanWindow         string `yaml:"scan_window"`
+	ScanWindowTimezone string `yaml:"scan_window_timezone"`
+
+	// Watch mode: a few endpoints checked on their own fast schedule, with
+	// state changes posted to a webhook (see Watch)
+	Watch Watch `yaml:"watch"`
+
+	// Scan scoping: when allow_networks is set only IPs inside it are scanned,
+	// even if a listed network is wider; exclude_networks then carves out IPs
+	AllowNetworks   []string `yaml:"allow_networks"`
+	ExcludeNetworks []string `yaml:"exclude_networks"`
+
+	// File of explicit "ip:port" / "ip:start-end" endpoints. When set, network
+	// expansion and port lists are bypassed and each endpoint is fingerprinted.
+	TargetsFile string `yaml:"targets_file"`
+
+	// TCP mode: random delay in milliseconds before each connection attempt
+	ScanJitterMS JitterRange `yaml:"scan_jitter_ms"`
+
+	// UDP ports to probe; only ports with a protocol probe are scanned
+	UDPPorts PortList `yaml:"udp_ports"`
+
+	// Number of hosts fingerprinted in parallel
+	FingerprintConcurrency int `yaml:"fingerprint_concurrency"`
+
+	// Most fingerprint probes in flight against any one IP (0 = no limit)
+	PerHostProbeConcurrency int `yaml:"per_host_probe_concurrency"`
+
+	// Most sockets open at once across scanning and fingerprinting; new
+	// connections wait for a free one (0 = derived from the open file limit)
+	MaxOpenSockets int `yaml:"max_open_sockets"`
+
+	// Response body bytes one scan may read and keep across all probes
+	// (HTTP bodies, stored raw zgrab2 output); past it bodies are skipped
+	// but headers and banners still captured (0 = unlimited)
+	MaxTotalBodyBytes int64 `yaml:"max_total_body_bytes"`
+
+	// Scan the N most common ports instead of the ports list (0 disables)
+	TopPorts int `yaml:"top_ports"`
+
+	// zmap mode: write results to a temp file in this directory and tail it
+	// instead of reading a pipe, so slow processing never stalls zmap
+	ZmapOutputDir string `yaml:"zmap_output_dir"`
+
+	// zmap mode: hand open ports to fingerprinting at this interval while a
+	// port is still being scanned, instead of once per port (0 disables)
+	ZmapFlushInterval time.Duration `yaml:"zmap_flush_interval"`
+
+	// zmap mode: re-run each port scan up to this many more times and keep
+	// the union of hosts found, for lossy links (0 disables)
+	ZmapRetries int `yaml:"zmap_retries"`
+
+	// IPv6 networks: zmap sends from ipv6_source_ip, and no IPv6 network may
+	// expand to more than max_ipv6_targets addresses (default 65536)
+	IPv6SourceIP   string `yaml:"ipv6_source_ip"`
+	MaxIPv6Targets int    `yaml:"max_ipv6_targets"`
+
+	// Local address scans and probes are sent from, for multi-homed hosts.
+	// Must be assigned to an interface; zmap uses it for IPv4 networks (-S).
+	SourceIP string `yaml:"source_ip"`
+
+	// Local port or min-max range scans are sent from, e.g. "40000-40099",
+	// for testing firewalls that treat source ports differently. TCP mode
+	// cycles through the range, skipping ports still in use.
+	SourcePorts string `yaml:"source_ports"`
+
+	// Socket options for scan, confirmation and probe connections: close
+	// them with a reset (SO_LINGER 0) to skip TIME_WAIT, and turn keepalive off
+	ScanLingerZero       bool `yaml:"scan_linger_zero"`
+	ScanDisableKeepAlive bool `yaml:"scan_disable_keepalive"`
+
+	// TCP mode: scan IP×port pairs concurrently instead of one port at a time
+	ParallelPorts bool `yaml:"parallel_ports"`
+
+	// TCP mode: probe networks round-robin so all of them progress together
+	// and report each network's hosts per port as soon as it is done
+	InterleaveNetworks bool `yaml:"interleave_networks"`
+
+	// TCP mode with scan_all_ports: ports scanned concurrently per batch
+	// (0 = 1000)
+	AllPortsBatchSize int `yaml:"all_ports_batch_size"`
+
+	// Reconnect to every port reported open before recording it; with
+	// confirm_banner the service must also send data for "high" confidence
+	ConfirmOpen   bool `yaml:"confirm_open"`
+	ConfirmBanner bool `yaml:"confirm_banner"`
+
+	// Only submit endpoints that are new or changed since the previous scan,
+	// with a full submission every full_resync_interval (e.g. "6h"; 0 = never)
+	SubmitOnlyChanges  bool          `yaml:"submit_only_changes"`
+	FullResyncInterval time.Duration `yaml:"full_resync_interval"`
+
+	// Re-run a scan that found no hosts at all up to retry_empty_scans times
+	// (max 5), retry_empty_delay apart (default 30s)
+	RetryEmptyScans int           `yaml:"retry_empty_scans"`
+	RetryEmptyDelay time.Duration `yaml:"retry_empty_delay"`
+
+	// Time each scan's discovery, fingerprint and submit phases, logging the
+	// breakdown and reporting it in /status
+	ScanTiming bool `yaml:"scan_timing"`
+
+	// Port fields submitted to the API, by JSON name (e.g. service_name,
+	// banner, fingerprint_data.title); empty submits everything
+	SubmitFields []string `yaml:"submit_fields"`
+
+	// Labels attached to every submission, e.g. {team: netsec, env: prod};
+	// a /trigger request may override them for its scan
+	Tags map[string]string `yaml:"tags"`
+
+	// How long submitted results stay fresh: each submission carries an
+	// expires_at of its completed_at plus result_ttl (0 = no expiry)
+	ResultTTL time.Duration `yaml:"result_ttl"`
+
+	// Markdown summary rewritten after each completed scan: counts, top
+	// services, changes since the previous scan, policy violations and
+	// notable findings
+	ReportFile string `yaml:"report_file"`
+
+	// state_file records the open endpoints of the last completed scan. With
+	// verify_only, scans just re-check those endpoints and submit the ones
+	// that have closed.
+	StateFile  string `yaml:"state_file"`
+	VerifyOnly bool   `yaml:"verify_only"`
+
+	// refingerprint_only re-runs just the fingerprinting phase against the
+	// TCP endpoints in state_file, refreshing banners and versions without
+	// rediscovering ports
+	RefingerprintOnly bool `yaml:"refingerprint_only"`
+
+	// full_scan_interval makes only every Nth run a full sweep; the runs in
+	// between just re-check the endpoints in state_file, as with
+	// verify_only. 0 or 1 sweeps every run.
+	FullScanInterval int `yaml:"full_scan_interval"`
+
+	// state_compress gzips the state file (either form is read back).
+	// state_retention_days keeps hosts a scan no longer finds in the state
+	// until they have been missing that many days; 0 keeps only the last
+	// scan's hosts.
+	StateCompress      bool `yaml:"state_compress"`
+	StateRetentionDays int  `yaml:"state_retention_days"`
+
+	// Results of the running scan kept in memory before the rest spill to
+	// an NDJSON file in spill_dir ("" = system temp directory)
+	MaxResultsInMemory int    `yaml:"max_results_in_memory"`
+	SpillDir           string `yaml:"spill_dir"`
+
+	// Allowed-exposure policy (YAML) checked after each scan; open ports it
+	// doesn't allow are logged and served at /violations
+	PolicyFile string `yaml:"policy_file"`
+
+	// Record each scan's TCP packets to this pcap file, overwritten every
+	// scan. Needs root or CAP_NET_RAW; capped at capture_max_mb (default 100).
+	CapturePcap  string `yaml:"capture_pcap"`
+	CaptureMaxMB int    `yaml:"capture_max_mb"`
+
+	// Save every native probe connection's raw bytes, per IP:port, into
+	// record_fixtures; or fingerprint the endpoints recorded in
+	// replay_fixtures from those bytes without touching the network
+	RecordFixtures string `yaml:"record_fixtures"`
+	ReplayFixtures string `yaml:"replay_fixtures"`
+
+	// Results API connection reuse (0 takes the client defaults)
+	APIMaxIdleConns   int           `yaml:"api_max_idle_conns"`
+	APIIdleTimeout    time.Duration `yaml:"api_idle_timeout"`
+	APIRequestTimeout time.Duration `yaml:"api_request_timeout"`
+
+	// Write each completed scan's results to this S3 bucket as one JSON
+	// object instead of submitting them to the API. Credentials come from
+	// the standard AWS environment; s3_endpoint points at an S3-compatible
+	// store such as MinIO.
+	S3Bucket   string `yaml:"s3_bucket"`
+	S3Endpoint string `yaml:"s3_endpoint"`
+
+	// Also send every result batch to Elasticsearch in bulk-index format,
+	// one document per host port: POSTed to an http(s) _bulk URL or
+	// appended to a file:// path. es_index defaults to db.DefaultESIndex.
+	ESBulkURL string `yaml:"es_bulk_url"`
+	ESIndex   string `yaml:"es_index"`
+
+	// Shared key for HMAC-SHA256 signatures on submitted results. Every
+	// submission carries a SHA-256 of its canonical JSON regardless.
+	ResultHMACKey string `yaml:"result_hmac_key"`
+
+	// Control server security (all optional)
+	ControlTLSCert   string `yaml:"control_tls_cert"`
+	ControlTLSKey    string `yaml:"control_tls_key"`
+	ControlAuthToken string `yaml:"control_auth_token"`
+
+	// GET /events: events buffered per client before a slow client is
+	// dropped, and how many clients may connect (0 = 64 and 16)
+	EventsBuffer     int `yaml:"events_buffer"`
+	EventsMaxClients int `yaml:"events_max_clients"`
+
+	// Geo/ASN enrichment for public IPs: "maxmind" (offline DB), "whois", or "" to disable
+	GeoIPSource      string `yaml:"geoip_source"`
+	GeoIPDB          string `yaml:"geoip_db"`
+	GeoIPWhoisServer string `yaml:"geoip_whois_server"`
+
+	// Built-in processors run, in order, over each batch of results before
+	// it is recorded or submitted: "redact" (needs banner_redactions), "geo"
+	// (needs geoip_source) and "policy" (needs policy_file). Unset runs geo
+	// when geoip_source is set. redact runs first unless listed elsewhere.
+	ResultProcessors []string `yaml:"result_processors"`
+
+	// Regex rules applied to banners and fingerprint strings before results
+	// are stored or submitted, each match replaced by replace ("[REDACTED]"
+	// when empty)
+	BannerRedactions []Redaction `yaml:"banner_redactions"`
+
+	// DNS-over-HTTPS endpoint (RFC 8484) for hostname targets and reverse
+	// lookups, falling back to the system resolver; empty uses the system
+	// resolver only. reverse_dns fills each host's hostname from its PTR.
+	DoHURL     string `yaml:"doh_url"`
+	ReverseDNS bool   `yaml:"reverse_dns"`
+
+	// Fill each on-link host's MAC address from the kernel ARP table and
+	// flag IPs whose MAC changed since the previous scans
+	ResolveMAC bool `yaml:"resolve_mac"`
+
+	// Open proxy detection: host:port that proxies are asked to tunnel to.
+	// Only the tunnel handshake is performed; empty disables the check.
+	ProxyTestTarget string `yaml:"proxy_test_target"`
+
+	// Open relay check on SMTP ports 25/587: MAIL FROM and RCPT TO external
+	// addresses, then RSET. No message is sent. Off unless explicitly enabled.
+	SMTPRelayTest bool `yaml:"smtp_relay_test"`
+
+	// Anonymous login check on FTP services: USER anonymous / PASS anonymous@,
+	// then a count of the root listing. Nothing is downloaded or written.
+	FTPAnonTest bool `yaml:"ftp_anon_test"`
+
+	// Zone transfer check on TCP 53: AXFR of the zones above the server's
+	// reverse lookup name. Only the record count is kept.
+	DNSAXFRTest bool `yaml:"dns_axfr_test"`
+
+	// Cloud metadata check on HTTP(S) services: request the metadata index
+	// (169.254.169.254) through the service as a proxy and by Host header
+	MetadataTest bool `yaml:"metadata_test"`
+
+	// NTLM check on HTTP(S) services that offer NTLM or Negotiate: send a
+	// negotiate message and decode the challenge's domain and host names
+	NTLMInfo bool `yaml:"ntlm_info"`
+
+	// Ports whose services sit behind a PROXY protocol load balancer: native
+	// probes send a v1 or v2 header (proxy_protocol_version, default 1)
+	// before anything else
+	ProxyProtocolPorts   PortList `yaml:"proxy_protocol_ports"`
+	ProxyProtocolVersion int      `yaml:"proxy_protocol_version"`
+
+	// Send QUIT (LOGOUT for IMAP) before closing FTP/SMTP/POP3/IMAP/Redis
+	// probe connections, for servers that log or rate-limit abrupt closes
+	GracefulClose bool `yaml:"graceful_close"`
+
+	// Requests made to each HTTP(S) service to spot load balancers by
+	// differing Server headers or certificate CNs (0 disables)
+	LBDetectSamples int `yaml:"lb_detect_samples"`
+
+	// WebSocket detection on HTTP(S) ports: an upgrade handshake is sent to
+	// each of websocket_paths (default /, /ws, /websocket, socket.io) until
+	// one returns 101
+	WebSocketDetect bool     `yaml:"websocket_detect"`
+	WebSocketPaths  []string `yaml:"websocket_paths"`
+
+	// Two-phase fingerprinting: a quick banner read (fast_fingerprint_timeout,
+	// default 1s) first, escalating to the full probe only when it is
+	// inconclusive on a port with a protocol probe
+	FastFingerprint        bool          `yaml:"fast_fingerprint"`
+	FastFingerprintTimeout time.Duration `yaml:"fast_fingerprint_timeout"`
+
+	// Ordered native probes to try on a port until one identifies the
+	// service, e.g. 8080: [http, http-proxy, socks, banner], for ports that
+	// may host several protocols. Ports not listed keep their single probe.
+	ProbeSequence map[int][]string `yaml:"probe_sequence"`
+
+	// Native probes to run at once on a port, e.g. 8443: [https, http],
+	// merging their results into the most informative. At most
+	// MaxMultiProbes per port; a port can't also have a probe_sequence.
+	MultiProbe map[int][]string `yaml:"multi_probe"`
+
+	// Attach each port's raw zgrab2 output (capped) to fingerprint_data as
+	// _raw_zgrab, for re-parsing offline
+	StoreRawZgrab bool `yaml:"store_raw_zgrab"`
+
+	// Caps on the headers and body kept from each zgrab2 module's result,
+	// by module name (only http returns either today)
+	ZgrabLimits map[string]ZgrabLimit `yaml:"zgrab_limits"`
+}
+
+// ZgrabLimit is one zgrab_limits entry
+type ZgrabLimit struct {
+	MaxHeaders   int      `yaml:"max_headers"`    // 0 keeps all
+	MaxBodyBytes int      `yaml:"max_body_bytes"` // body kept as fingerprint_data.body; 0 keeps none
+	DropHeaders  []string `yaml:"drop_headers"`   // e.g. set-cookie
+}
+
+// Network is one networks entry: a CIDR, optionally with its own scanner
+// mode. In YAML it is either a plain CIDR string or {cidr, mode}.
+type Network struct {
+	CIDR string `yaml:"cidr"`
+	Mode string `yaml:"mode"` // "zmap", "tcp", or "" for scanner_mode
+
+	// Hostname a single-address network was resolved from by ReadNetworks
+	Hostname string `yaml:"-"`
+}
+
+// UnmarshalYAML accepts both the plain string and the mapping form
+func (n *Network) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		n.CIDR = value.Value
+		n.Mode = ""
+		return nil
+	}
+	type plain Network
+	return value.Decode((*plain)(n))
+}
+
+// String formats the entry for logs
+func (n Network) String() string {
+	if n.Mode == "" {
+		return n.CIDR
+	}
+	return n.CIDR + " (" + n.Mode + ")"
+}
+
+// ModeOrDefault returns scanner_mode, or "tcp" when unset
+func (c *Config) ModeOrDefault() string {
+	if c.ScannerMode == "" {
+		return "tcp"
+	}
+	return c.ScannerMode
+}
+
+// ModeNetworks groups network CIDRs by effective scanner mode, falling back
+// to scanner_mode for entries without one
+func (c *Config) ModeNetworks() map[string][]string {
+	groups := make(map[string][]string)
+	for _, n := range c.Networks {
+		mode := n.Mode
+		if mode == "" {
+			mode = c.ModeOrDefault()
+		}
+		groups[mode] = append(groups[mode], n.CIDR)
+	}
+	return groups
+}
+
+// NetworkCIDRs returns every network CIDR regardless of mode
+func (c *Config) NetworkCIDRs() []string {
+	cidrs := make([]string, 0, len(c.Networks))
+	for _, n := range c.Networks {
+		cidrs = append(cidrs, n.CIDR)
+	}
+	return cidrs
+}
+
+// MaxAutoRate returns the ceiling for auto_rate: max_rate, or rate when
+// max_rate is unset
+func (c *Config) MaxAutoRate() int {
+	if c.MaxRate > 0 {
+		return c.MaxRate
+	}
+	return c.Rate
+}
+
+// JitterRange is an inclusive min/max range in milliseconds
+type JitterRange struct {
+	Min int `yaml:"min"`
+	Max int `yaml:"max"`
 }
 
 func Load(path string) (*Config, error) {
@@ -26,10 +437,18 @@ func Load(path string) (*Config, error) {
 
 	cfg := &Config{
 		// Defaults
-		Schedule: "*/15 * * * *",
-		Rate:     10000,
-		Timeout:  5,
-		APIURL:   "http://127.0.0.1:8000",
+		Schedule:   "*/15 * * * *",
+		Rate:       10000,
+		Timeout:    5,
+		APIURL:     "http://127.0.0.1:8000",
+		ListenAddr: ":8081",
+
+		FingerprintConcurrency: 1,
+		MaxIPv6Targets:         1 << 16,
+		MaxResultsInMemory:     DefaultMaxResultsInMemory,
+		FastFingerprintTimeout: time.Second,
+		RetryEmptyDelay:        DefaultRetryEmptyDelay,
+		ScanTimeBudget:         DefaultScanTimeBudget,
 	}
 
 	if err := yaml.Unmarshal(data, cfg); err != nil {
@@ -41,7 +460,7 @@ func Load(path string) (*Config, error) {
 
 func Default() *Config {
 	return &Config{
-		Networks:     []string{"192.168.1.0/24"},
+		Networks:     []Network{{CIDR: "192.168.1.0/24"}},
 		ScanAllPorts: false,
 		Ports:        []int{21, 22, 23, 25, 53, 80, 110, 143, 443, 445, 993, 995, 1433, 1521, 3306, 3389, 5432, 5900, 6379, 8080, 8443, 27017},
 		Schedule:     "*/15 * * * *",
@@ -49,5 +468,415 @@ func Default() *Config {
 		Rate:         100,
 		Timeout:      5,
 		APIURL:       "http://127.0.0.1:8000",
+		ListenAddr:   ":8081",
+
+		FingerprintConcurrency: 1,
+		MaxIPv6Targets:         1 << 16,
+		MaxResultsInMemory:     DefaultMaxResultsInMemory,
+		FastFingerprintTimeout: time.Second,
+		RetryEmptyDelay:        DefaultRetryEmptyDelay,
+		ScanTimeBudget:         DefaultScanTimeBudget,
+	}
+}
+
+// Validate checks that the configuration can drive a scan
+func (c *Config) Validate() error {
+	if len(c.Networks) == 0 && c.TargetsFile == "" {
+		return fmt.Errorf("no networks or targets_file configured")
+	}
+	if c.MaxResultsInMemory < 1 {
+		return fmt.Errorf("invalid max_results_in_memory %d: must be at least 1", c.MaxResultsInMemory)
+	}
+	if c.MaxIPv6Targets < 1 {
+		return fmt.Errorf("invalid max_ipv6_targets %d: must be at least 1", c.MaxIPv6Targets)
+	}
+	if c.RetryEmptyScans < 0 || c.RetryEmptyScans > MaxRetryEmptyScans {
+		return fmt.Errorf("invalid retry_empty_scans %d: must be 0-%d", c.RetryEmptyScans, MaxRetryEmptyScans)
+	}
+	if c.RetryEmptyScans > 0 && c.RetryEmptyDelay <= 0 {
+		return fmt.Errorf("invalid retry_empty_delay %s: must be positive", c.RetryEmptyDelay)
+	}
+
+	for key := range c.Tags {
+		if strings.TrimSpace(key) == "" {
+			return fmt.Errorf("invalid tags: empty tag name")
+		}
+	}
+	if len(c.SubmitFields) > 0 {
+		if _, err := db.NewFieldFilter(c.SubmitFields); err != nil {
+			return fmt.Errorf("invalid submit_fields: %w", err)
+		}
+	}
+
+	if c.RecordFixtures != "" && c.ReplayFixtures != "" {
+		return fmt.Errorf("record_fixtures and replay_fixtures cannot both be set")
+	}
+
+	if c.CaptureMaxMB < 0 {
+		return fmt.Errorf("invalid capture_max_mb %d: must not be negative", c.CaptureMaxMB)
+	}
+
+	if c.ZmapFlushInterval < 0 {
+		return fmt.Errorf("invalid zmap_flush_interval %s: must not be negative", c.ZmapFlushInterval)
+	}
+	if c.ZmapRetries < 0 {
+		return fmt.Errorf("invalid zmap_retries %d: must not be negative", c.ZmapRetries)
+	}
+
+	if c.IPv6SourceIP != "" && net.ParseIP(c.IPv6SourceIP) == nil {
+		return fmt.Errorf("invalid ipv6_source_ip %q", c.IPv6SourceIP)
+	}
+	if c.SourceIP != "" {
+		ip := net.ParseIP(c.SourceIP)
+		if ip == nil {
+			return fmt.Errorf("invalid source_ip %q", c.SourceIP)
+		}
+		if !localAddress(ip) {
+			return fmt.Errorf("source_ip %s is not assigned to any interface", c.SourceIP)
+		}
+	}
+	if c.SourcePorts != "" {
+		if _, _, err := c.SourcePortRange(); err != nil {
+			return fmt.Errorf("invalid source_ports: %w", err)
+		}
+	}
+
+	for _, network := range c.Networks {
+		ip, ipnet, err := net.ParseCIDR(network.CIDR)
+		if err != nil {
+			return fmt.Errorf("invalid network %q: %w", network.CIDR, err)
+		}
+		switch network.Mode {
+		case "", "tcp", "zmap":
+		default:
+			return fmt.Errorf("invalid mode %q for network %s: must be \"tcp\" or \"zmap\"", network.Mode, network.CIDR)
+		}
+
+		if ip.To4() == nil {
+			// IPv6 ranges are expanded address by address
+			ones, bits := ipnet.Mask.Size()
+			if hostBits := bits - ones; hostBits >= 62 || 1<<hostBits > c.MaxIPv6Targets {
+				return fmt.Errorf("IPv6 network %s is larger than max_ipv6_targets (%d addresses)", network.CIDR, c.MaxIPv6Targets)
+			}
+			mode := network.Mode
+			if mode == "" {
+				mode = c.ModeOrDefault()
+			}
+			if mode == "zmap" && c.IPv6SourceIP == "" {
+				return fmt.Errorf("IPv6 network %s in zmap mode requires ipv6_source_ip", network.CIDR)
+			}
+		}
+	}
+
+	switch c.ScannerMode {
+	case "", "tcp", "zmap":
+	default:
+		return fmt.Errorf("invalid scanner_mode %q: must be \"tcp\" or \"zmap\"", c.ScannerMode)
+	}
+
+	if c.FastFingerprintTimeout < 0 {
+		return fmt.Errorf("invalid fast_fingerprint_timeout %s: must not be negative", c.FastFingerprintTimeout)
+	}
+	for port, probes := range c.ProbeSequence {
+		if port < 1 || port > 65535 {
+			return fmt.Errorf("invalid probe_sequence port %d", port)
+		}
+		if len(probes) == 0 {
+			return fmt.Errorf("probe_sequence for port %d lists no probes", port)
+		}
+	}
+	for port, probes := range c.MultiProbe {
+		if port < 1 || port > 65535 {
+			return fmt.Errorf("invalid multi_probe port %d", port)
+		}
+		if len(probes) == 0 || len(probes) > MaxMultiProbes {
+			return fmt.Errorf("multi_probe for port %d lists %d probes: must list 1 to %d", port, len(probes), MaxMultiProbes)
+		}
+		if _, ok := c.ProbeSequence[port]; ok {
+			return fmt.Errorf("port %d is in both multi_probe and probe_sequence", port)
+		}
+	}
+	for module, limit := range c.ZgrabLimits {
+		if limit.MaxHeaders < 0 {
+			return fmt.Errorf("invalid zgrab_limits.%s.max_headers %d: must not be negative", module, limit.MaxHeaders)
+		}
+		if limit.MaxBodyBytes < 0 {
+			return fmt.Errorf("invalid zgrab_limits.%s.max_body_bytes %d: must not be negative", module, limit.MaxBodyBytes)
+		}
+	}
+	for _, path := range c.WebSocketPaths {
+		if !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("invalid websocket_paths entry %q: must start with /", path)
+		}
+	}
+
+	if c.LBDetectSamples < 0 || c.LBDetectSamples > 10 {
+		return fmt.Errorf("invalid lb_detect_samples %d: must be between 0 and 10", c.LBDetectSamples)
+	}
+
+	for _, port := range c.Ports {
+		if port < 1 || port > 65535 {
+			return fmt.Errorf("invalid port %d", port)
+		}
+	}
+	for _, port := range c.UDPPorts {
+		if port < 1 || port > 65535 {
+			return fmt.Errorf("invalid UDP port %d", port)
+		}
+	}
+	for _, port := range c.ProxyProtocolPorts {
+		if port < 1 || port > 65535 {
+			return fmt.Errorf("invalid proxy_protocol_ports port %d", port)
+		}
+	}
+	if c.ProxyProtocolVersion != 0 && c.ProxyProtocolVersion != 1 && c.ProxyProtocolVersion != 2 {
+		return fmt.Errorf("invalid proxy_protocol_version %d: must be 1 or 2", c.ProxyProtocolVersion)
+	}
+
+	if c.ScanJitterMS.Min < 0 || c.ScanJitterMS.Max < c.ScanJitterMS.Min {
+		return fmt.Errorf("invalid scan_jitter_ms: need 0 <= min <= max, got %d-%d", c.ScanJitterMS.Min, c.ScanJitterMS.Max)
+	}
+
+	if c.FingerprintConcurrency < 1 {
+		return fmt.Errorf("invalid fingerprint_concurrency %d: must be at least 1", c.FingerprintConcurrency)
+	}
+	if c.PerHostProbeConcurrency < 0 {
+		return fmt.Errorf("invalid per_host_probe_concurrency %d: must not be negative", c.PerHostProbeConcurrency)
+	}
+	if c.AllPortsBatchSize < 0 {
+		return fmt.Errorf("invalid all_ports_batch_size %d: must not be negative", c.AllPortsBatchSize)
+	}
+	if c.MaxOpenSockets < 0 {
+		return fmt.Errorf("invalid max_open_sockets %d: must not be negative", c.MaxOpenSockets)
+	}
+	if c.MaxTotalBodyBytes < 0 {
+		return fmt.Errorf("invalid max_total_body_bytes %d: must not be negative", c.MaxTotalBodyBytes)
+	}
+
+	if c.StateRetentionDays < 0 {
+		return fmt.Errorf("invalid state_retention_days %d: must not be negative", c.StateRetentionDays)
+	}
+	if c.RefingerprintOnly && c.StateFile == "" {
+		return fmt.Errorf("refingerprint_only requires state_file")
+	}
+	if c.RefingerprintOnly && c.VerifyOnly {
+		return fmt.Errorf("refingerprint_only and verify_only are mutually exclusive")
+	}
+	if c.VerifyOnly && c.StateFile == "" {
+		return fmt.Errorf("verify_only requires state_file")
+	}
+	if c.FullScanInterval < 0 {
+		return fmt.Errorf("invalid full_scan_interval %d: must not be negative", c.FullScanInterval)
+	}
+	if c.FullScanInterval > 1 {
+		if c.StateFile == "" {
+			return fmt.Errorf("full_scan_interval requires state_file")
+		}
+		if c.VerifyOnly || c.RefingerprintOnly {
+			return fmt.Errorf("full_scan_interval can't be combined with verify_only or refingerprint_only")
+		}
+	}
+
+	if c.TopPorts < 0 {
+		return fmt.Errorf("invalid top_ports %d", c.TopPorts)
+	}
+
+	if c.FullResyncInterval < 0 {
+		return fmt.Errorf("invalid full_resync_interval %s", c.FullResyncInterval)
+	}
+
+	if _, err := cron.ParseStandard(c.Schedule); err != nil {
+		return fmt.Errorf("invalid schedule %q: %w", c.Schedule, err)
+	}
+	if c.AutoRate && c.ScanTimeBudget <= 0 {
+		return fmt.Errorf("invalid scan_time_budget %s: must be positive with auto_rate", c.ScanTimeBudget)
+	}
+	if c.MaxRate < 0 {
+		return fmt.Errorf("invalid max_rate %d: must not be negative", c.MaxRate)
+	}
+	if c.ScheduleJitter < 0 {
+		return fmt.Errorf("invalid schedule_jitter %s: must not be negative", c.ScheduleJitter)
+	}
+	if c.EventsBuffer < 0 {
+		return fmt.Errorf("invalid events_buffer %d: must not be negative", c.EventsBuffer)
+	}
+	if c.EventsMaxClients < 0 {
+		return fmt.Errorf("invalid events_max_clients %d: must not be negative", c.EventsMaxClients)
+	}
+	if c.ResultTTL < 0 {
+		return fmt.Errorf("invalid result_ttl %s: must not be negative", c.ResultTTL)
+	}
+	if _, err := c.ScanTimeWindow(); err != nil {
+		return err
+	}
+	if err := c.Watch.validate(); err != nil {
+		return err
+	}
+
+	if c.APIURL == "" {
+		return fmt.Errorf("api_url is required")
+	}
+	if c.APIMaxIdleConns < 0 || c.APIIdleTimeout < 0 || c.APIRequestTimeout < 0 {
+		return fmt.Errorf("api_max_idle_conns, api_idle_timeout and api_request_timeout must not be negative")
+	}
+	if c.S3Endpoint != "" && c.S3Bucket == "" {
+		return fmt.Errorf("s3_endpoint requires s3_bucket")
+	}
+	if c.ESBulkURL != "" {
+		if _, err := db.NewESBulkSink(c.ESBulkURL, c.ESIndex); err != nil {
+			return err
+		}
+	} else if c.ESIndex != "" {
+		return fmt.Errorf("es_index requires es_bulk_url")
+	}
+	if c.ESIndex != "" && (c.ESIndex != strings.ToLower(c.ESIndex) || strings.ContainsAny(c.ESIndex, ` "*\\<|,>/?#:`)) {
+		return fmt.Errorf("invalid es_index %q: must be lowercase without spaces or any of \"*\\<|,>/?#:", c.ESIndex)
+	}
+
+	if err := ValidateListenAddr(c.ListenAddr); err != nil {
+		return err
+	}
+
+	if (c.ControlTLSCert == "") != (c.ControlTLSKey == "") {
+		return fmt.Errorf("control_tls_cert and control_tls_key must be set together")
+	}
+
+	if c.ProxyTestTarget != "" {
+		if _, _, err := net.SplitHostPort(c.ProxyTestTarget); err != nil {
+			return fmt.Errorf("invalid proxy_test_target %q: %w", c.ProxyTestTarget, err)
+		}
+	}
+
+	switch c.GeoIPSource {
+	case "", "whois":
+	case "maxmind":
+		if c.GeoIPDB == "" {
+			return fmt.Errorf("geoip_source \"maxmind\" requires geoip_db")
+		}
+	default:
+		return fmt.Errorf("invalid geoip_source %q: must be \"maxmind\" or \"whois\"", c.GeoIPSource)
+	}
+
+	seenProcessors := make(map[string]bool)
+	for _, name := range c.ResultProcessors {
+		if seenProcessors[name] {
+			return fmt.Errorf("invalid result_processors: %q listed twice", name)
+		}
+		seenProcessors[name] = true
+		switch name {
+		case "geo":
+			if c.GeoIPSource == "" {
+				return fmt.Errorf("result processor \"geo\" requires geoip_source")
+			}
+		case "policy":
+			if c.PolicyFile == "" {
+				return fmt.Errorf("result processor \"policy\" requires policy_file")
+			}
+		case "redact":
+			if len(c.BannerRedactions) == 0 {
+				return fmt.Errorf("result processor \"redact\" requires banner_redactions")
+			}
+		default:
+			return fmt.Errorf("invalid result processor %q: must be \"redact\", \"geo\" or \"policy\"", name)
+		}
+	}
+	if _, err := c.RedactionRules(); err != nil {
+		return err
+	}
+
+	if c.DoHURL != "" {
+		u, err := url.Parse(c.DoHURL)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return fmt.Errorf("invalid doh_url %q: must be an https URL", c.DoHURL)
+		}
+	}
+
+	return nil
+}
+
+// ResultProcessorNames returns the result processors to run: those listed
+// in result_processors, or the geo processor alone when geoip_source is set.
+// With banner_redactions, redact runs first unless the list places it.
+func (c *Config) ResultProcessorNames() []string {
+	names := c.ResultProcessors
+	if len(names) == 0 && c.GeoIPSource != "" {
+		names = []string{"geo"}
+	}
+	if len(c.BannerRedactions) == 0 {
+		return names
+	}
+	for _, name := range names {
+		if name == "redact" {
+			return names
+		}
+	}
+	return append([]string{"redact"}, names...)
+}
+
+// Redaction is one banner_redactions rule
+type Redaction struct {
+	Pattern string `yaml:"pattern"`
+	Replace string `yaml:"replace"`
+}
+
+// RedactionRules compiles banner_redactions
+func (c *Config) RedactionRules() ([]db.RedactionRule, error) {
+	var rules []db.RedactionRule
+	for i, r := range c.BannerRedactions {
+		if r.Pattern == "" {
+			return nil, fmt.Errorf("invalid banner_redactions[%d]: empty pattern", i)
+		}
+		pattern, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid banner_redactions[%d] pattern %q: %w", i, r.Pattern, err)
+		}
+		rules = append(rules, db.RedactionRule{Pattern: pattern, Replace: r.Replace})
+	}
+	return rules, nil
+}
+
+// ScanTimeWindow parses scan_window, returning nil when it is unset
+func (c *Config) ScanTimeWindow() (*TimeWindow, error) {
+	if c.ScanWindow == "" {
+		if c.ScanWindowTimezone != "" {
+			return nil, fmt.Errorf("scan_window_timezone requires scan_window")
+		}
+		return nil, nil
+	}
+	window, err := ParseTimeWindow(c.ScanWindow, c.ScanWindowTimezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid scan_window %q: %w", c.ScanWindow, err)
+	}
+	return window, nil
+}
+
+// ValidateListenAddr checks that addr is a bindable host:port. The host may be
+// empty (all interfaces) or an IP address such as 127.0.0.1.
+func ValidateListenAddr(addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid listen_addr %q: %w", addr, err)
+	}
+	if host != "" && net.ParseIP(host) == nil {
+		return fmt.Errorf("invalid listen_addr %q: host must be an IP address", addr)
+	}
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 0 || n > 65535 {
+		return fmt.Errorf("invalid listen_addr %q: bad port %q", addr, port)
+	}
+	return nil
+}
+
+// localAddress reports whether ip is assigned to one of this host's interfaces
+func localAddress(ip net.IP) bool {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.Equal(ip) {
+			return true
+		}
 	}
+	return false
 }
@@ -0,0 +1,386 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"network-scanner/db"
+)
+
+func TestValidateListenAddr(t *testing.T) {
+	tests := []struct {
+		addr    string
+		wantErr bool
+	}{
+		{":8081", false},
+		{"127.0.0.1:8081", false},
+		{"[::1]:8081", false},
+		{"127.0.0.1:0", false},
+		{"8081", true},
+		{"localhost:8081", true},
+		{"127.0.0.1:http", true},
+		{"127.0.0.1:65536", true},
+	}
+	for _, tt := range tests {
+		if err := ValidateListenAddr(tt.addr); (err != nil) != tt.wantErr {
+			t.Errorf("ValidateListenAddr(%q) = %v, want error %v", tt.addr, err, tt.wantErr)
+		}
+	}
+}
+
+// load parses yaml through Load and Validate
+func load(t *testing.T, yaml string) (*Config, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(yaml), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+	return cfg, cfg.Validate()
+}
+
+func TestNetworksMixedModes(t *testing.T) {
+	cfg, err := load(t, `
+scanner_mode: zmap
+networks:
+  - 192.168.1.0/24
+  - {cidr: 10.20.0.0/16, mode: tcp}
+  - cidr: 10.30.0.0/16
+    mode: zmap
+  - cidr: 10.40.0.0/16
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Network{
+		{CIDR: "192.168.1.0/24"},
+		{CIDR: "10.20.0.0/16", Mode: "tcp"},
+		{CIDR: "10.30.0.0/16", Mode: "zmap"},
+		{CIDR: "10.40.0.0/16"},
+	}
+	if !reflect.DeepEqual(cfg.Networks, want) {
+		t.Errorf("Networks = %+v, want %+v", cfg.Networks, want)
+	}
+	groups := cfg.ModeNetworks()
+	wantGroups := map[string][]string{
+		"zmap": {"192.168.1.0/24", "10.30.0.0/16", "10.40.0.0/16"},
+		"tcp":  {"10.20.0.0/16"},
+	}
+	if !reflect.DeepEqual(groups, wantGroups) {
+		t.Errorf("ModeNetworks() = %v, want %v", groups, wantGroups)
+	}
+	if got := cfg.NetworkCIDRs(); len(got) != 4 || got[1] != "10.20.0.0/16" {
+		t.Errorf("NetworkCIDRs() = %v", got)
+	}
+	if got := cfg.Networks[1].String(); got != "10.20.0.0/16 (tcp)" {
+		t.Errorf("String() = %q", got)
+	}
+}
+
+func TestNetworksModeDefaultsToTCP(t *testing.T) {
+	cfg, err := load(t, "networks: [10.0.0.0/24, {cidr: 10.0.1.0/24, mode: zmap}]\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{"tcp": {"10.0.0.0/24"}, "zmap": {"10.0.1.0/24"}}
+	if got := cfg.ModeNetworks(); !reflect.DeepEqual(got, want) {
+		t.Errorf("ModeNetworks() = %v, want %v", got, want)
+	}
+}
+
+func TestNetworksInvalidMode(t *testing.T) {
+	_, err := load(t, "networks: [{cidr: 10.0.0.0/24, mode: syn}]\n")
+	if err == nil || !strings.Contains(err.Error(), `invalid mode "syn" for network 10.0.0.0/24`) {
+		t.Errorf("Validate() = %v, want an invalid mode error", err)
+	}
+}
+
+func TestAPITransportOptions(t *testing.T) {
+	cfg, err := load(t, "networks: [10.0.0.0/24]\napi_max_idle_conns: 4\napi_idle_timeout: 30s\napi_request_timeout: 10s\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.APIMaxIdleConns != 4 || cfg.APIIdleTimeout != 30*time.Second || cfg.APIRequestTimeout != 10*time.Second {
+		t.Errorf("transport options = %d, %v, %v", cfg.APIMaxIdleConns, cfg.APIIdleTimeout, cfg.APIRequestTimeout)
+	}
+	if _, err := load(t, "networks: [10.0.0.0/24]\napi_max_idle_conns: -1\n"); err == nil {
+		t.Error("negative api_max_idle_conns accepted")
+	}
+}
+
+func TestValidateSourceIP(t *testing.T) {
+	for source, wantErr := range map[string]string{
+		"127.0.0.1":  "",
+		"192.0.2.77": "not assigned to any interface",
+		"not-an-ip":  "invalid source_ip",
+	} {
+		_, err := load(t, "networks: [10.0.0.0/24]\nsource_ip: "+source+"\n")
+		if wantErr == "" && err != nil || wantErr != "" && (err == nil || !strings.Contains(err.Error(), wantErr)) {
+			t.Errorf("source_ip %s: %v, want %q", source, err, wantErr)
+		}
+	}
+}
+
+func TestValidateSubmitFields(t *testing.T) {
+	if _, err := load(t, "networks: [10.0.0.0/24]\nsubmit_fields: [service_name, service_version, fingerprint_data.title]\n"); err != nil {
+		t.Errorf("valid submit_fields: %v", err)
+	}
+	_, err := load(t, "networks: [10.0.0.0/24]\nsubmit_fields: [service_name, product]\n")
+	if err == nil || !strings.Contains(err.Error(), `unknown result field "product"`) {
+		t.Errorf("Validate() = %v, want an unknown field error", err)
+	}
+}
+
+func TestValidateRetryEmptyScans(t *testing.T) {
+	cfg, err := load(t, "networks: [10.0.0.0/24]\nretry_empty_scans: 2\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.RetryEmptyScans != 2 || cfg.RetryEmptyDelay != DefaultRetryEmptyDelay {
+		t.Errorf("retry_empty_scans = %d, delay %s, want 2 and the default delay", cfg.RetryEmptyScans, cfg.RetryEmptyDelay)
+	}
+	for _, bad := range []string{"retry_empty_scans: -1\n", "retry_empty_scans: 6\n", "retry_empty_scans: 1\nretry_empty_delay: 0s\n"} {
+		if _, err := load(t, "networks: [10.0.0.0/24]\n"+bad); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+}
+
+func TestSourcePortRange(t *testing.T) {
+	for spec, want := range map[string][2]int{"40000": {40000, 40000}, "40000-40100": {40000, 40100}, " 50000-50001 ": {50000, 50001}} {
+		cfg, err := load(t, "networks: [10.0.0.0/24]\nsource_ports: \""+spec+"\"\n")
+		if err != nil {
+			t.Errorf("source_ports %q: %v", spec, err)
+			continue
+		}
+		if min, max, err := cfg.SourcePortRange(); err != nil || [2]int{min, max} != want {
+			t.Errorf("source_ports %q = %d-%d, %v, want %v", spec, min, max, err, want)
+		}
+	}
+	for _, bad := range []string{"0", "40100-40000", "40000-70000", "22,23"} {
+		if _, err := load(t, "networks: [10.0.0.0/24]\nsource_ports: \""+bad+"\"\n"); err == nil {
+			t.Errorf("source_ports %q accepted", bad)
+		}
+	}
+}
+
+func TestTags(t *testing.T) {
+	cfg, err := load(t, "networks: [10.0.0.0/24]\ntags: {team: netsec, env: prod}\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"team": "netsec", "env": "prod"}; !reflect.DeepEqual(cfg.Tags, want) {
+		t.Errorf("Tags = %v, want %v", cfg.Tags, want)
+	}
+	if _, err := load(t, "networks: [10.0.0.0/24]\ntags: {\"\": x}\n"); err == nil {
+		t.Error("empty tag name accepted")
+	}
+}
+
+func TestScheduleJitter(t *testing.T) {
+	cfg, err := load(t, "networks: [10.0.0.0/24]\nschedule_jitter: 2m\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ScheduleJitter != 2*time.Minute {
+		t.Errorf("ScheduleJitter = %s, want 2m", cfg.ScheduleJitter)
+	}
+	if _, err := load(t, "networks: [10.0.0.0/24]\nschedule_jitter: -1s\n"); err == nil {
+		t.Error("negative schedule_jitter accepted")
+	}
+}
+
+func TestValidateRefingerprintOnly(t *testing.T) {
+	if _, err := load(t, "networks: [10.0.0.0/24]\nstate_file: /tmp/state.json\nrefingerprint_only: true\n"); err != nil {
+		t.Errorf("refingerprint_only with state_file: %v", err)
+	}
+	for _, bad := range []string{"refingerprint_only: true\n", "state_file: /tmp/state.json\nrefingerprint_only: true\nverify_only: true\n"} {
+		if _, err := load(t, "networks: [10.0.0.0/24]\n"+bad); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+}
+
+func TestValidateFullScanInterval(t *testing.T) {
+	for _, ok := range []string{"full_scan_interval: 1\n", "state_file: /tmp/state.json\nfull_scan_interval: 6\n"} {
+		if _, err := load(t, "networks: [10.0.0.0/24]\n"+ok); err != nil {
+			t.Errorf("%q: %v", ok, err)
+		}
+	}
+	for _, bad := range []string{
+		"state_file: /tmp/state.json\nfull_scan_interval: -1\n",
+		"full_scan_interval: 6\n",
+		"state_file: /tmp/state.json\nfull_scan_interval: 6\nverify_only: true\n",
+		"state_file: /tmp/state.json\nfull_scan_interval: 6\nrefingerprint_only: true\n",
+	} {
+		if _, err := load(t, "networks: [10.0.0.0/24]\n"+bad); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+}
+
+func TestValidateProxyProtocol(t *testing.T) {
+	if _, err := load(t, "networks: [10.0.0.0/24]\nproxy_protocol_ports: [22, 443]\nproxy_protocol_version: 2\n"); err != nil {
+		t.Errorf("PROXY protocol v2 on 22 and 443: %v", err)
+	}
+	for _, bad := range []string{"proxy_protocol_ports: [0]\n", "proxy_protocol_ports: [22]\nproxy_protocol_version: 3\n"} {
+		if _, err := load(t, "networks: [10.0.0.0/24]\n"+bad); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+}
+
+func TestValidateAutoRate(t *testing.T) {
+	cfg, err := load(t, "networks: [10.0.0.0/24]\nauto_rate: true\nrate: 200\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ScanTimeBudget != DefaultScanTimeBudget || cfg.MaxAutoRate() != 200 {
+		t.Errorf("budget %s, ceiling %d, want the default budget and rate as the ceiling", cfg.ScanTimeBudget, cfg.MaxAutoRate())
+	}
+	for _, bad := range []string{"auto_rate: true\nscan_time_budget: -1m\n", "max_rate: -5\n"} {
+		if _, err := load(t, "networks: [10.0.0.0/24]\n"+bad); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+}
+
+func TestBannerRedactions(t *testing.T) {
+	cfg, err := load(t, `networks: [10.0.0.0/24]
+banner_redactions:
+  - pattern: '[a-z0-9-]+\.corp\.internal'
+  - {pattern: 'user=\w+', replace: 'user=[USER]'}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules, err := cfg.RedactionRules()
+	if err != nil || len(rules) != 2 {
+		t.Fatalf("RedactionRules() = %v, %v", rules, err)
+	}
+	banner := "220 mx01.corp.internal ESMTP user=jsmith"
+	if got := db.NewRedactor(rules).Redact(banner); got != "220 [REDACTED] ESMTP user=[USER]" {
+		t.Errorf("redacted banner = %q", got)
+	}
+	// Redaction runs before the other processors unless placed
+	if got := cfg.ResultProcessorNames(); !reflect.DeepEqual(got, []string{"redact"}) {
+		t.Errorf("ResultProcessorNames() = %v, want [redact]", got)
+	}
+	cfg.GeoIPSource = "whois"
+	cfg.ResultProcessors = []string{"geo", "redact"}
+	if got := cfg.ResultProcessorNames(); !reflect.DeepEqual(got, []string{"geo", "redact"}) {
+		t.Errorf("ResultProcessorNames() = %v, want the listed order", got)
+	}
+
+	for _, bad := range []string{
+		"banner_redactions: [{pattern: '(unclosed'}]\n",
+		"banner_redactions: [{replace: x}]\n",
+		"result_processors: [redact]\n",
+	} {
+		if _, err := load(t, "networks: [10.0.0.0/24]\n"+bad); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+}
+
+func TestValidateESBulk(t *testing.T) {
+	for _, good := range []string{"es_bulk_url: https://elastic:changeme@es:9200/_bulk\n", "es_bulk_url: file:///var/lib/scanner/results.ndjson\nes_index: scans-2026\n"} {
+		if _, err := load(t, "networks: [10.0.0.0/24]\n"+good); err != nil {
+			t.Errorf("%q: %v", good, err)
+		}
+	}
+	for _, bad := range []string{
+		"es_bulk_url: es:9200\n",
+		"es_bulk_url: file://\n",
+		"es_index: scans\n",
+		"es_bulk_url: http://es:9200/_bulk\nes_index: Scans\n",
+		"es_bulk_url: http://es:9200/_bulk\nes_index: 'scans,other'\n",
+	} {
+		if _, err := load(t, "networks: [10.0.0.0/24]\n"+bad); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+}
+
+func TestValidateProbeSequence(t *testing.T) {
+	cfg, err := load(t, "networks: [10.0.0.0/24]\nprobe_sequence:\n  8080: [http, http-proxy, socks, banner]\n  2222: [ssh, telnet]\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"ssh", "telnet"}; !reflect.DeepEqual(cfg.ProbeSequence[2222], want) {
+		t.Errorf("probe_sequence[2222] = %v, want %v", cfg.ProbeSequence[2222], want)
+	}
+	for _, bad := range []string{"probe_sequence: {0: [http]}\n", "probe_sequence: {70000: [http]}\n", "probe_sequence: {8080: []}\n"} {
+		if _, err := load(t, "networks: [10.0.0.0/24]\n"+bad); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+}
+
+func TestValidateResultTTL(t *testing.T) {
+	cfg, err := load(t, "networks: [10.0.0.0/24]\nresult_ttl: 48h\n")
+	if err != nil || cfg.ResultTTL != 48*time.Hour {
+		t.Errorf("result_ttl 48h = %v, %v", cfg, err)
+	}
+	if _, err := load(t, "networks: [10.0.0.0/24]\nresult_ttl: -1h\n"); err == nil {
+		t.Error("negative result_ttl accepted")
+	}
+}
+
+func TestValidateEvents(t *testing.T) {
+	cfg, err := load(t, "networks: [10.0.0.0/24]\nevents_buffer: 8\nevents_max_clients: 2\n")
+	if err != nil || cfg.EventsBuffer != 8 || cfg.EventsMaxClients != 2 {
+		t.Errorf("events_buffer 8, events_max_clients 2 = %v, %v", cfg, err)
+	}
+	for _, bad := range []string{"events_buffer: -1\n", "events_max_clients: -1\n"} {
+		if _, err := load(t, "networks: [10.0.0.0/24]\n"+bad); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+}
+
+func TestValidateZgrabLimits(t *testing.T) {
+	cfg, err := load(t, "networks: [10.0.0.0/24]\nzgrab_limits:\n  http: {max_headers: 20, max_body_bytes: 4096, drop_headers: [set-cookie]}\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ZgrabLimit{MaxHeaders: 20, MaxBodyBytes: 4096, DropHeaders: []string{"set-cookie"}}
+	if !reflect.DeepEqual(cfg.ZgrabLimits["http"], want) {
+		t.Errorf("zgrab_limits.http = %+v, want %+v", cfg.ZgrabLimits["http"], want)
+	}
+	for _, bad := range []string{
+		"zgrab_limits: {http: {max_headers: -1}}\n",
+		"zgrab_limits: {http: {max_body_bytes: -1}}\n",
+	} {
+		if _, err := load(t, "networks: [10.0.0.0/24]\n"+bad); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+}
+
+func TestValidateMultiProbe(t *testing.T) {
+	cfg, err := load(t, "networks: [10.0.0.0/24]\nmulti_probe:\n  8443: [https, http]\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"https", "http"}; !reflect.DeepEqual(cfg.MultiProbe[8443], want) {
+		t.Errorf("multi_probe[8443] = %v, want %v", cfg.MultiProbe[8443], want)
+	}
+	for _, bad := range []string{
+		"multi_probe: {0: [http]}\n",
+		"multi_probe: {8443: []}\n",
+		"multi_probe: {8443: [https, http, http-proxy, banner, ssh]}\n",
+		"multi_probe: {8443: [https, http]}\nprobe_sequence: {8443: [http]}\n",
+	} {
+		if _, err := load(t, "networks: [10.0.0.0/24]\n"+bad); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Policy is an allowed-exposure policy: which ports may be open on which
+// networks. Any other open port on a covered host is a violation.
+type Policy struct {
+	Rules []PolicyRule `yaml:"rules"`
+
+	// Ports allowed on hosts no rule covers. Hosts outside every rule are
+	// not checked unless one of these is set.
+	DefaultAllowedPorts    *PortList `yaml:"default_allowed_ports"`
+	DefaultAllowedUDPPorts *PortList `yaml:"default_allowed_udp_ports"`
+}
+
+// PolicyRule allows ports on the hosts in its networks. Name identifies the
+// rule (e.g. "servers") in violations. A host in several rules may have any
+// port one of them allows.
+type PolicyRule struct {
+	Name            string   `yaml:"name"`
+	Networks        []string `yaml:"networks"`
+	AllowedPorts    PortList `yaml:"allowed_ports"`
+	AllowedUDPPorts PortList `yaml:"allowed_udp_ports"`
+
+	nets []*net.IPNet
+}
+
+// LoadPolicy reads and validates a policy file
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := &Policy{}
+	if err := yaml.Unmarshal(data, policy); err != nil {
+		return nil, err
+	}
+
+	for i := range policy.Rules {
+		rule := &policy.Rules[i]
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule %d", i+1)
+		}
+		if len(rule.Networks) == 0 {
+			return nil, fmt.Errorf("%s: no networks", rule.Name)
+		}
+		for _, network := range rule.Networks {
+			ipnet, err := parseNetwork(network)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", rule.Name, err)
+			}
+			rule.nets = append(rule.nets, ipnet)
+		}
+	}
+
+	return policy, nil
+}
+
+// parseNetwork parses a CIDR or a single address
+func parseNetwork(network string) (*net.IPNet, error) {
+	if ip := net.ParseIP(network); ip != nil {
+		bits := 8 * len(ip.To16())
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 32
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, ipnet, err := net.ParseCIDR(network)
+	if err != nil {
+		return nil, fmt.Errorf("invalid network %q: %w", network, err)
+	}
+	return ipnet, nil
+}
+
+// Check reports whether port/protocol may be open on ip. rule names the
+// first rule covering ip, "default" for a host only the defaults cover, or
+// "" for a host the policy doesn't cover, which is always allowed.
+func (p *Policy) Check(ip net.IP, port int, protocol string) (rule string, allowed bool) {
+	for _, r := range p.Rules {
+		if !r.covers(ip) {
+			continue
+		}
+		if rule == "" {
+			rule = r.Name
+		}
+		ports := r.AllowedPorts
+		if protocol == "udp" {
+			ports = r.AllowedUDPPorts
+		}
+		if containsPort(ports, port) {
+			return r.Name, true
+		}
+	}
+	if rule != "" {
+		return rule, false
+	}
+
+	defaults := p.DefaultAllowedPorts
+	if protocol == "udp" {
+		defaults = p.DefaultAllowedUDPPorts
+	}
+	if p.DefaultAllowedPorts == nil && p.DefaultAllowedUDPPorts == nil {
+		return "", true
+	}
+	return "default", defaults != nil && containsPort(*defaults, port)
+}
+
+func (r *PolicyRule) covers(ip net.IP) bool {
+	for _, ipnet := range r.nets {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsPort(ports []int, port int) bool {
+	for _, p := range ports {
+		if p == port {
+			return true
+		}
+	}
+	return false
+}
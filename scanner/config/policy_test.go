@@ -0,0 +1,104 @@
+package config
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// loadPolicy writes yaml to a policy file and loads it
+func loadPolicy(t *testing.T, yaml string) (*Policy, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	if err := os.WriteFile(path, []byte(yaml), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return LoadPolicy(path)
+}
+
+const testPolicy = `
+rules:
+  - name: servers
+    networks: [10.0.0.0/29]
+    allowed_ports: [22, 443]
+    allowed_udp_ports: [53]
+  - name: bastion
+    networks: [10.0.0.5]
+    allowed_ports: "2200-2299"
+  - networks: [10.0.1.0/24, "2001:db8::/64"]
+    allowed_ports: [80]
+`
+
+func TestPolicyCheck(t *testing.T) {
+	policy, err := loadPolicy(t, testPolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		ip       string
+		port     int
+		protocol string
+		rule     string
+		allowed  bool
+	}{
+		{"10.0.0.1", 22, "tcp", "servers", true},
+		{"10.0.0.1", 3306, "tcp", "servers", false},
+		{"10.0.0.1", 53, "udp", "servers", true},
+		{"10.0.0.1", 22, "udp", "servers", false},
+		// A host in two rules may have what either allows
+		{"10.0.0.5", 2222, "tcp", "bastion", true},
+		{"10.0.0.5", 443, "tcp", "servers", true},
+		{"10.0.0.5", 8080, "tcp", "servers", false},
+		// Unnamed rules are numbered
+		{"10.0.1.9", 80, "tcp", "rule 3", true},
+		{"2001:db8::9", 22, "tcp", "rule 3", false},
+		// Without defaults, uncovered hosts are not checked
+		{"192.168.1.1", 3306, "tcp", "", true},
+	}
+	for _, tt := range tests {
+		rule, allowed := policy.Check(net.ParseIP(tt.ip), tt.port, tt.protocol)
+		if rule != tt.rule || allowed != tt.allowed {
+			t.Errorf("Check(%s, %d/%s) = %q, %v, want %q, %v", tt.ip, tt.port, tt.protocol, rule, allowed, tt.rule, tt.allowed)
+		}
+	}
+}
+
+func TestPolicyDefaults(t *testing.T) {
+	policy, err := loadPolicy(t, testPolicy+"default_allowed_ports: [22]\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		port     int
+		protocol string
+		allowed  bool
+	}{
+		{22, "tcp", true},
+		{443, "tcp", false},
+		// Only TCP defaults are set, so no UDP port is allowed
+		{53, "udp", false},
+	} {
+		rule, allowed := policy.Check(net.ParseIP("192.168.1.1"), tt.port, tt.protocol)
+		if rule != "default" || allowed != tt.allowed {
+			t.Errorf("Check(192.168.1.1, %d/%s) = %q, %v, want \"default\", %v", tt.port, tt.protocol, rule, allowed, tt.allowed)
+		}
+	}
+}
+
+func TestLoadPolicyErrors(t *testing.T) {
+	for _, bad := range []string{
+		"rules:\n  - name: empty\n    allowed_ports: [22]\n",
+		"rules:\n  - networks: [10.0.0.0/33]\n",
+		"rules:\n  - networks: [not-a-network]\n",
+		"rules:\n  - networks: [10.0.0.0/24]\n    allowed_ports: [0]\n",
+		"rules: {}\n",
+	} {
+		if _, err := loadPolicy(t, bad); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+	if _, err := LoadPolicy(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("missing policy file accepted")
+	}
+}
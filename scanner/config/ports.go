@@ -0,0 +1,128 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// PortList is a list of ports. In YAML it is either a sequence whose items
+// are port numbers or port specs, or a single spec string such as
+// "1-1024,3306,!135".
+type PortList []int
+
+// UnmarshalYAML expands port specs into the port list
+func (p *PortList) UnmarshalYAML(value *yaml.Node) error {
+	var spec string
+	switch value.Kind {
+	case yaml.ScalarNode:
+		spec = value.Value
+	case yaml.SequenceNode:
+		items := make([]string, 0, len(value.Content))
+		for _, item := range value.Content {
+			if item.Kind != yaml.ScalarNode {
+				return fmt.Errorf("line %d: port entries must be numbers or strings", item.Line)
+			}
+			items = append(items, item.Value)
+		}
+		spec = strings.Join(items, ",")
+	default:
+		return fmt.Errorf("line %d: ports must be a list or a port spec string", value.Line)
+	}
+
+	ports, err := ParsePortSpec(spec)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", value.Line, err)
+	}
+	*p = ports
+	return nil
+}
+
+// ParsePortSpec expands a comma-separated port spec. Each term is a port
+// ("443"), an inclusive range ("8000-9000"), or either prefixed with "!"
+// to exclude it. Exclusions apply after every inclusion regardless of
+// position; a spec with only exclusions starts from all ports 1-65535.
+// Ports keep the order they first appear in, without duplicates.
+func ParsePortSpec(spec string) ([]int, error) {
+	var include, exclude [][2]int
+	for _, term := range strings.Split(spec, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+		negate := strings.HasPrefix(term, "!")
+		r, err := parsePortRange(strings.TrimSpace(strings.TrimPrefix(term, "!")))
+		if err != nil {
+			return nil, err
+		}
+		if negate {
+			exclude = append(exclude, r)
+		} else {
+			include = append(include, r)
+		}
+	}
+	if len(include) == 0 && len(exclude) > 0 {
+		include = append(include, [2]int{1, 65535})
+	}
+
+	excluded := make(map[int]bool)
+	for _, r := range exclude {
+		for port := r[0]; port <= r[1]; port++ {
+			excluded[port] = true
+		}
+	}
+
+	seen := make(map[int]bool)
+	ports := []int{}
+	for _, r := range include {
+		for port := r[0]; port <= r[1]; port++ {
+			if !excluded[port] && !seen[port] {
+				seen[port] = true
+				ports = append(ports, port)
+			}
+		}
+	}
+	return ports, nil
+}
+
+// SourcePortRange returns the bounds of source_ports
+func (c *Config) SourcePortRange() (min, max int, err error) {
+	bounds, err := parsePortRange(strings.TrimSpace(c.SourcePorts))
+	if err != nil {
+		return 0, 0, err
+	}
+	return bounds[0], bounds[1], nil
+}
+
+// parsePortRange parses "N" or "N-M" into an inclusive range
+func parsePortRange(term string) ([2]int, error) {
+	lowStr, highStr, isRange := strings.Cut(term, "-")
+	low, err := parsePort(lowStr)
+	if err != nil {
+		return [2]int{}, fmt.Errorf("invalid port spec %q: %w", term, err)
+	}
+	if !isRange {
+		return [2]int{low, low}, nil
+	}
+	high, err := parsePort(highStr)
+	if err != nil {
+		return [2]int{}, fmt.Errorf("invalid port spec %q: %w", term, err)
+	}
+	if high < low {
+		return [2]int{}, fmt.Errorf("invalid port spec %q: range end is below its start", term)
+	}
+	return [2]int{low, high}, nil
+}
+
+func parsePort(s string) (int, error) {
+	port, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a port number", s)
+	}
+	if port < 1 || port > 65535 {
+		return 0, fmt.Errorf("port %d out of range 1-65535", port)
+	}
+	return port, nil
+}
@@ -0,0 +1,126 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// portRange returns low through high
+func portRange(low, high int) []int {
+	ports := make([]int, 0, high-low+1)
+	for port := low; port <= high; port++ {
+		ports = append(ports, port)
+	}
+	return ports
+}
+
+func TestParsePortSpec(t *testing.T) {
+	without := func(ports []int, drop ...int) []int {
+		var kept []int
+	next:
+		for _, port := range ports {
+			for _, d := range drop {
+				if port == d {
+					continue next
+				}
+			}
+			kept = append(kept, port)
+		}
+		return kept
+	}
+
+	tests := []struct {
+		spec string
+		want []int
+	}{
+		{"443", []int{443}},
+		{"22,80,443", []int{22, 80, 443}},
+		{" 22 , 80 ,", []int{22, 80}},
+		{"", []int{}},
+		{"8000-8003", []int{8000, 8001, 8002, 8003}},
+		{"8080-8080", []int{8080}},
+		{"1-1024", portRange(1, 1024)},
+		{"1-1024,3306,!135", append(without(portRange(1, 1024), 135), 3306)},
+		{"!135,1-1024,3306", append(without(portRange(1, 1024), 135), 3306)},
+		{"8000-9000,!8080-8090", append(portRange(8000, 8079), portRange(8091, 9000)...)},
+		{"!1-65534", []int{65535}},
+		{"! 22", without(portRange(1, 65535), 22)},
+		// Overlaps keep first-seen order without duplicates
+		{"80,70-90,85", append([]int{80}, without(portRange(70, 90), 80)...)},
+		{"443,22,443,22-23", []int{443, 22, 23}},
+		{"100-110,105-115", portRange(100, 115)},
+		// Excluding the same port twice, or something never included
+		{"22,80,!80,!80,!9999", []int{22}},
+		{"22,!22", []int{}},
+	}
+	for _, tt := range tests {
+		got, err := ParsePortSpec(tt.spec)
+		if err != nil {
+			t.Errorf("ParsePortSpec(%q): %v", tt.spec, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParsePortSpec(%q) = %v (%d ports), want %d ports", tt.spec, summarize(got), len(got), len(tt.want))
+		}
+	}
+}
+
+// summarize shortens long port lists for failure messages
+func summarize(ports []int) []int {
+	if len(ports) > 10 {
+		return append(append([]int{}, ports[:5]...), ports[len(ports)-5:]...)
+	}
+	return ports
+}
+
+func TestParsePortSpecErrors(t *testing.T) {
+	tests := []struct {
+		spec string
+		want string
+	}{
+		{"0", "out of range"},
+		{"65536", "out of range"},
+		{"-1", "is not a port number"},
+		{"80-", "is not a port number"},
+		{"http", "is not a port number"},
+		{"1024-1", "range end is below its start"},
+		{"1-65536", "out of range"},
+		{"!0-10", "out of range"},
+		{"22,,x", `invalid port spec "x"`},
+		{"1-2-3", "is not a port number"},
+	}
+	for _, tt := range tests {
+		if _, err := ParsePortSpec(tt.spec); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("ParsePortSpec(%q) = %v, want an error containing %q", tt.spec, err, tt.want)
+		}
+	}
+}
+
+func TestPortListYAML(t *testing.T) {
+	tests := []struct {
+		yaml string
+		want []int
+	}{
+		{"ports: [22, 80, 443]\n", []int{22, 80, 443}},
+		{"ports: [22, \"8000-8002\", \"!8001\"]\n", []int{22, 8000, 8002}},
+		{"ports: \"1-5,!3\"\n", []int{1, 2, 4, 5}},
+		{"ports:\n  - 22\n  - 3306-3307\n", []int{22, 3306, 3307}},
+	}
+	for _, tt := range tests {
+		cfg, err := load(t, "networks: [10.0.0.0/24]\n"+tt.yaml)
+		if err != nil {
+			t.Errorf("%q: %v", tt.yaml, err)
+			continue
+		}
+		if !reflect.DeepEqual([]int(cfg.Ports), tt.want) {
+			t.Errorf("%q: ports = %v, want %v", tt.yaml, cfg.Ports, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"ports: [22, 0]\n", "ports: \"90-80\"\n", "ports: [[22]]\n", "ports: {a: 1}\n"} {
+		if _, err := load(t, "networks: [10.0.0.0/24]\n"+bad); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+}
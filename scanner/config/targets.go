@@ -0,0 +1,94 @@
+package config
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+// LookupFunc resolves a hostname to its addresses
+type LookupFunc func(ctx context.Context, host string) ([]string, error)
+
+// ReadNetworks reads networks entries from r, one target per line: a CIDR,
+// an IP (scanned as a single host) or a hostname, resolved with lookup to
+// one entry per address with Hostname set. Blank lines and lines starting
+// with # are ignored and duplicates dropped; an address several hostnames
+// resolve to keeps the first. Malformed or unresolvable lines don't stop
+// the read; they are returned in skipped with the reason.
+func ReadNetworks(ctx context.Context, r io.Reader, lookup LookupFunc) (networks []Network, skipped []string, err error) {
+	seen := make(map[string]bool)
+	add := func(cidr, hostname string) {
+		if !seen[cidr] {
+			seen[cidr] = true
+			networks = append(networks, Network{CIDR: cidr, Hostname: hostname})
+		}
+	}
+
+	lines := bufio.NewScanner(r)
+	lineNum := 0
+	for lines.Scan() {
+		lineNum++
+		line := strings.TrimSpace(lines.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if _, ipnet, err := net.ParseCIDR(line); err == nil {
+			add(ipnet.String(), "")
+			continue
+		}
+		if ip := net.ParseIP(line); ip != nil {
+			add(hostCIDR(ip), "")
+			continue
+		}
+		if !validHostname(line) {
+			skipped = append(skipped, fmt.Sprintf("line %d: invalid target %q", lineNum, line))
+			continue
+		}
+		addrs, err := lookup(ctx, line)
+		if err != nil {
+			skipped = append(skipped, fmt.Sprintf("line %d: failed to resolve %s: %v", lineNum, line, err))
+			continue
+		}
+		for _, addr := range addrs {
+			if ip := net.ParseIP(addr); ip != nil {
+				add(hostCIDR(ip), strings.TrimSuffix(line, "."))
+			}
+		}
+	}
+	if err := lines.Err(); err != nil {
+		return networks, skipped, err
+	}
+	return networks, skipped, nil
+}
+
+// hostCIDR returns the single-address network of ip
+func hostCIDR(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.String() + "/32"
+	}
+	return ip.String() + "/128"
+}
+
+// validHostname reports whether name is made of DNS labels: letters,
+// digits and inner hyphens, dot separated
+func validHostname(name string) bool {
+	name = strings.TrimSuffix(name, ".")
+	if name == "" || len(name) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
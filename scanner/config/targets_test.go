@@ -0,0 +1,90 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// stubLookup resolves the hosts in addrs and fails for any other
+func stubLookup(addrs map[string][]string) LookupFunc {
+	return func(ctx context.Context, host string) ([]string, error) {
+		if a, ok := addrs[host]; ok {
+			return a, nil
+		}
+		return nil, errors.New("no such host")
+	}
+}
+
+func TestReadNetworksMixedTargets(t *testing.T) {
+	input := `# targets from the CI inventory
+10.0.0.0/24
+192.168.1.77
+
+  10.0.1.5/16
+2001:db8::1
+db.internal
+web.internal.
+10.0.0.0/24
+not a target
+gone.internal
+300.1.2.3
+-bad-.example
+192.168.1.77
+`
+	lookup := stubLookup(map[string][]string{
+		"db.internal":   {"10.2.0.5", "2001:db8::5"},
+		"web.internal.": {"10.3.0.9", "10.2.0.5"},
+	})
+	networks, skipped, err := ReadNetworks(context.Background(), strings.NewReader(input), lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Network{
+		{CIDR: "10.0.0.0/24"},
+		{CIDR: "192.168.1.77/32"},
+		{CIDR: "10.0.0.0/16"},
+		{CIDR: "2001:db8::1/128"},
+		{CIDR: "10.2.0.5/32", Hostname: "db.internal"},
+		{CIDR: "2001:db8::5/128", Hostname: "db.internal"},
+		{CIDR: "10.3.0.9/32", Hostname: "web.internal"},
+	}
+	if !reflect.DeepEqual(networks, want) {
+		t.Errorf("networks = %v, want %v", networks, want)
+	}
+
+	wantSkipped := []string{
+		`line 10: invalid target "not a target"`,
+		"line 11: failed to resolve gone.internal: no such host",
+		// Not an IP, but made of valid labels, so it is looked up
+		"line 12: failed to resolve 300.1.2.3: no such host",
+		`line 13: invalid target "-bad-.example"`,
+	}
+	if !reflect.DeepEqual(skipped, wantSkipped) {
+		t.Errorf("skipped = %q, want %q", skipped, wantSkipped)
+	}
+}
+
+func TestReadNetworksEmpty(t *testing.T) {
+	for _, input := range []string{"", "\n\n", "# nothing yet\n   \n"} {
+		networks, skipped, err := ReadNetworks(context.Background(), strings.NewReader(input), stubLookup(nil))
+		if err != nil || len(networks) != 0 || len(skipped) != 0 {
+			t.Errorf("ReadNetworks(%q) = %v, %v, %v, want nothing", input, networks, skipped, err)
+		}
+	}
+}
+
+func TestReadNetworksReadError(t *testing.T) {
+	r := iotest.TimeoutReader(strings.NewReader("10.0.0.1\n10.0.0.2\n"))
+	networks, _, err := ReadNetworks(context.Background(), r, stubLookup(nil))
+	if err == nil {
+		t.Fatal("read error not returned")
+	}
+	if len(networks) > 2 {
+		t.Errorf("networks = %v", networks)
+	}
+}
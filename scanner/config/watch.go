@@ -0,0 +1,61 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+
+	"github.com/robfig/cron/v3"
+)
+
+// MaxWatchEndpoints caps watch.targets x watch.ports; watch mode is for a
+// handful of critical endpoints checked often, not a second full scan
+const MaxWatchEndpoints = 256
+
+// Watch is watch mode: a small set of TCP endpoints re-checked on their own
+// fast schedule, independent of the full scan, with each open/closed flip
+// posted to a webhook
+type Watch struct {
+	Schedule   string   `yaml:"schedule"` // cron format, e.g. "* * * * *"
+	Targets    []string `yaml:"targets"`  // IP addresses
+	Ports      PortList `yaml:"ports"`
+	WebhookURL string   `yaml:"webhook_url"`
+}
+
+// Enabled reports whether watch mode is configured
+func (w Watch) Enabled() bool {
+	return len(w.Targets) > 0
+}
+
+func (w Watch) validate() error {
+	if !w.Enabled() {
+		if len(w.Ports) > 0 || w.WebhookURL != "" {
+			return fmt.Errorf("watch needs targets")
+		}
+		return nil
+	}
+	if _, err := cron.ParseStandard(w.Schedule); err != nil {
+		return fmt.Errorf("invalid watch.schedule %q: %w", w.Schedule, err)
+	}
+	for _, target := range w.Targets {
+		if net.ParseIP(target) == nil {
+			return fmt.Errorf("invalid watch.targets entry %q: must be an IP address", target)
+		}
+	}
+	if len(w.Ports) == 0 {
+		return fmt.Errorf("watch needs ports")
+	}
+	for _, port := range w.Ports {
+		if port < 1 || port > 65535 {
+			return fmt.Errorf("invalid watch.ports port %d", port)
+		}
+	}
+	if n := len(w.Targets) * len(w.Ports); n > MaxWatchEndpoints {
+		return fmt.Errorf("watch covers %d endpoints: at most %d are allowed", n, MaxWatchEndpoints)
+	}
+	u, err := url.Parse(w.WebhookURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid watch.webhook_url %q: must be an http(s) URL", w.WebhookURL)
+	}
+	return nil
+}
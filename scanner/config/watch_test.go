@@ -0,0 +1,32 @@
+package config
+
+import "testing"
+
+func TestValidateWatch(t *testing.T) {
+	const watch = "watch:\n  schedule: '* * * * *'\n  targets: [10.0.0.5, 10.0.0.6]\n  ports: [22, 443]\n  webhook_url: https://hooks.example.com/scanner\n"
+	cfg, err := load(t, "networks: [10.0.0.0/24]\n"+watch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.Watch.Enabled() || len(cfg.Watch.Ports) != 2 {
+		t.Errorf("watch = %+v", cfg.Watch)
+	}
+	if cfg, err := load(t, "networks: [10.0.0.0/24]\n"); err != nil || cfg.Watch.Enabled() {
+		t.Errorf("without watch: enabled %v, %v", cfg != nil && cfg.Watch.Enabled(), err)
+	}
+
+	for _, bad := range []string{
+		"watch:\n  ports: [22]\n",
+		"watch:\n  schedule: often\n  targets: [10.0.0.5]\n  ports: [22]\n  webhook_url: https://hooks.example.com\n",
+		"watch:\n  schedule: '* * * * *'\n  targets: [db.internal]\n  ports: [22]\n  webhook_url: https://hooks.example.com\n",
+		"watch:\n  schedule: '* * * * *'\n  targets: [10.0.0.5]\n  webhook_url: https://hooks.example.com\n",
+		"watch:\n  schedule: '* * * * *'\n  targets: [10.0.0.5]\n  ports: [0]\n  webhook_url: https://hooks.example.com\n",
+		"watch:\n  schedule: '* * * * *'\n  targets: [10.0.0.5]\n  ports: [22]\n",
+		"watch:\n  schedule: '* * * * *'\n  targets: [10.0.0.5]\n  ports: [22]\n  webhook_url: ftp://hooks.example.com\n",
+		"watch:\n  schedule: '* * * * *'\n  targets: [10.0.0.5, 10.0.0.6]\n  ports: 1-200\n  webhook_url: https://hooks.example.com\n",
+	} {
+		if _, err := load(t, "networks: [10.0.0.0/24]\n"+bad); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+}
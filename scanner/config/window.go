@@ -0,0 +1,71 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TimeWindow is a daily time range scans may run in. A window whose end is
+// before its start crosses midnight, e.g. 22:00-06:00.
+type TimeWindow struct {
+	Start, End time.Duration // offsets from midnight, End exclusive
+	Location   *time.Location
+	spec       string
+}
+
+// ParseTimeWindow parses "HH:MM-HH:MM" in the named IANA time zone, or
+// local time when zone is empty
+func ParseTimeWindow(spec, zone string) (*TimeWindow, error) {
+	startStr, endStr, ok := strings.Cut(spec, "-")
+	if !ok {
+		return nil, fmt.Errorf("expected HH:MM-HH:MM")
+	}
+	start, err := parseClock(startStr)
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseClock(endStr)
+	if err != nil {
+		return nil, err
+	}
+	if start == end {
+		return nil, fmt.Errorf("start and end are the same")
+	}
+
+	loc := time.Local
+	if zone != "" {
+		if loc, err = time.LoadLocation(zone); err != nil {
+			return nil, fmt.Errorf("invalid time zone: %w", err)
+		}
+	}
+	return &TimeWindow{Start: start, End: end, Location: loc, spec: strings.TrimSpace(spec)}, nil
+}
+
+// parseClock parses HH:MM as an offset from midnight
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q: expected HH:MM", strings.TrimSpace(s))
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Contains reports whether t falls inside the window. A nil window is
+// always open.
+func (w *TimeWindow) Contains(t time.Time) bool {
+	if w == nil {
+		return true
+	}
+	t = t.In(w.Location)
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	if w.Start < w.End {
+		return offset >= w.Start && offset < w.End
+	}
+	return offset >= w.Start || offset < w.End
+}
+
+// String formats the window with its time zone, e.g. "22:00-06:00 Europe/Berlin"
+func (w *TimeWindow) String() string {
+	return w.spec + " " + w.Location.String()
+}
@@ -0,0 +1,100 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+// at is 2026-03-10 (a Tuesday) at hh:mm:ss in loc
+func at(loc *time.Location, hh, mm, ss int) time.Time {
+	return time.Date(2026, 3, 10, hh, mm, ss, 0, loc)
+}
+
+func TestTimeWindowContains(t *testing.T) {
+	day, err := ParseTimeWindow("09:00-17:30", "UTC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	night, err := ParseTimeWindow(" 22:00 - 06:00 ", "UTC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		window *TimeWindow
+		at     time.Time
+		want   bool
+	}{
+		{day, at(time.UTC, 8, 59, 59), false},
+		{day, at(time.UTC, 9, 0, 0), true},
+		{day, at(time.UTC, 13, 0, 0), true},
+		{day, at(time.UTC, 17, 29, 59), true},
+		{day, at(time.UTC, 17, 30, 0), false},
+		{day, at(time.UTC, 23, 0, 0), false},
+
+		// Crossing midnight: open late evening and early morning only
+		{night, at(time.UTC, 21, 59, 59), false},
+		{night, at(time.UTC, 22, 0, 0), true},
+		{night, at(time.UTC, 23, 59, 59), true},
+		{night, at(time.UTC, 0, 0, 0), true},
+		{night, at(time.UTC, 5, 59, 59), true},
+		{night, at(time.UTC, 6, 0, 0), false},
+		{night, at(time.UTC, 12, 0, 0), false},
+
+		{nil, at(time.UTC, 12, 0, 0), true},
+	}
+	for _, tt := range tests {
+		if got := tt.window.Contains(tt.at); got != tt.want {
+			t.Errorf("%v contains %s = %v, want %v", tt.window, tt.at.Format(time.TimeOnly), got, tt.want)
+		}
+	}
+	if night.String() != "22:00 - 06:00 UTC" {
+		t.Errorf("String() = %q", night.String())
+	}
+}
+
+func TestTimeWindowTimezone(t *testing.T) {
+	// 22:00-06:00 in Tokyo (UTC+9, no DST) is 13:00-21:00 UTC
+	window, err := ParseTimeWindow("22:00-06:00", "Asia/Tokyo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for hour, want := range map[int]bool{12: false, 13: true, 17: true, 20: true, 21: false, 2: false} {
+		if got := window.Contains(at(time.UTC, hour, 30, 0)); got != want {
+			t.Errorf("%02d:30 UTC: contains = %v, want %v", hour, got, want)
+		}
+	}
+}
+
+func TestParseTimeWindowInvalid(t *testing.T) {
+	for _, tt := range []struct{ spec, zone string }{
+		{"22:00", ""},
+		{"22:00-", ""},
+		{"10pm-6am", ""},
+		{"24:00-06:00", ""},
+		{"22:00-06:60", ""},
+		{"08:00-08:00", ""},
+		{"22:00-06:00", "Mars/Olympus_Mons"},
+	} {
+		if _, err := ParseTimeWindow(tt.spec, tt.zone); err == nil {
+			t.Errorf("ParseTimeWindow(%q, %q) succeeded", tt.spec, tt.zone)
+		}
+	}
+}
+
+func TestValidateScanWindow(t *testing.T) {
+	cfg, err := load(t, "networks: [10.0.0.0/24]\nscan_window: 22:00-06:00\nscan_window_timezone: Europe/Berlin\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if window, err := cfg.ScanTimeWindow(); err != nil || window.Location.String() != "Europe/Berlin" {
+		t.Errorf("ScanTimeWindow() = %v, %v", window, err)
+	}
+	if window, err := (&Config{}).ScanTimeWindow(); window != nil || err != nil {
+		t.Errorf("ScanTimeWindow() unset = %v, %v, want none", window, err)
+	}
+	for _, bad := range []string{"scan_window: nightly\n", "scan_window_timezone: UTC\n", "scan_window: 22:00-06:00\nscan_window_timezone: Nowhere\n"} {
+		if _, err := load(t, "networks: [10.0.0.0/24]\n"+bad); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+}
@@ -2,9 +2,14 @@ package db
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"sort"
 	"time"
 
 	"github.com/google/uuid"
@@ -14,18 +19,82 @@ import (
 type APIClient struct {
 	BaseURL    string
 	HTTPClient *http.Client
+	HMACKey    []byte // signs submitted results when set
+
+	// SubmitAttempts bounds how often SubmitResults sends a batch when the
+	// API is unreachable or answers 429 or 5xx (default
+	// DefaultSubmitAttempts). RetryDelay is the wait before the first
+	// retry, doubling after each (default DefaultRetryDelay).
+	SubmitAttempts int
+	RetryDelay     time.Duration
+}
+
+// Defaults for APIClient.SubmitAttempts and RetryDelay
+const (
+	DefaultSubmitAttempts = 3
+	DefaultRetryDelay     = time.Second
+)
+
+// TransportOptions tunes connection reuse for API requests. Zero fields
+// take the defaults below.
+type TransportOptions struct {
+	MaxIdleConnsPerHost int           // idle keep-alive connections kept open (default 10)
+	IdleConnTimeout     time.Duration // idle connections are closed after this (default 90s)
+	RequestTimeout      time.Duration // whole request, including reading the body (default 30s)
 }
 
-// NewAPIClient creates a new API client
+// NewAPIClient creates a new API client with default transport options
 func NewAPIClient(baseURL string) *APIClient {
+	return NewAPIClientWithOptions(baseURL, TransportOptions{})
+}
+
+// NewAPIClientWithOptions creates an API client whose transport keeps
+// connections alive between submissions, so bursts of batches reuse a few
+// sockets instead of opening one each
+func NewAPIClientWithOptions(baseURL string, opts TransportOptions) *APIClient {
+	if opts.MaxIdleConnsPerHost <= 0 {
+		opts.MaxIdleConnsPerHost = 10
+	}
+	if opts.IdleConnTimeout <= 0 {
+		opts.IdleConnTimeout = 90 * time.Second
+	}
+	if opts.RequestTimeout <= 0 {
+		opts.RequestTimeout = 30 * time.Second
+	}
+
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   10 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          opts.MaxIdleConnsPerHost,
+		MaxIdleConnsPerHost:   opts.MaxIdleConnsPerHost,
+		IdleConnTimeout:       opts.IdleConnTimeout,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+
 	return &APIClient{
 		BaseURL: baseURL,
 		HTTPClient: &http.Client{
-			Timeout: 30 * time.Second,
+			Transport: transport,
+			Timeout:   opts.RequestTimeout,
 		},
 	}
 }
 
+// Close closes idle keep-alive connections
+func (c *APIClient) Close() {
+	c.HTTPClient.CloseIdleConnections()
+}
+
+// drainAndClose reads any unread body so the connection can be reused
+func drainAndClose(body io.ReadCloser) {
+	io.Copy(io.Discard, io.LimitReader(body, 64<<10))
+	body.Close()
+}
+
 // ScanResultPort represents a port in scan results
 type ScanResultPort struct {
 	PortNumber      int                    `json:"port_number"`
@@ -39,37 +108,152 @@ type ScanResultPort struct {
 
 // ScanResultHost represents a host in scan results
 type ScanResultHost struct {
-	IPAddress  string           `json:"ip_address"`
-	Hostname   string           `json:"hostname,omitempty"`
-	MACAddress string           `json:"mac_address,omitempty"`
-	Ports      []ScanResultPort `json:"ports"`
+	IPAddress     string           `json:"ip_address"`
+	AddressFamily string           `json:"address_family,omitempty"` // "ipv4" or "ipv6", see AddressFamily
+	Hostname      string           `json:"hostname,omitempty"`
+	MACAddress    string           `json:"mac_address,omitempty"`
+	Ports         []ScanResultPort `json:"ports"`
+
+	// IdempotencyKey is HostIdempotencyKey for the scan, set by
+	// SubmitResults so the API can tell a resent host from a new one
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+}
+
+// AddressFamily returns "ipv4" or "ipv6" for ip, or "" when it doesn't
+// parse. IPv4-mapped IPv6 addresses count as IPv4.
+func AddressFamily(ip string) string {
+	parsed := net.ParseIP(ip)
+	switch {
+	case parsed == nil:
+		return ""
+	case parsed.To4() != nil:
+		return "ipv4"
+	default:
+		return "ipv6"
+	}
 }
 
 // ScanResults represents the complete scan results
 type ScanResults struct {
-	ScanID uuid.UUID        `json:"scan_id"`
-	Hosts  []ScanResultHost `json:"hosts"`
+	ScanID uuid.UUID         `json:"scan_id"`
+	Hosts  []ScanResultHost  `json:"hosts"`
+	Tags   map[string]string `json:"tags,omitempty"` // e.g. team/env, for routing in the API
+
+	// When the scan started and when these results were complete: the
+	// batch's completion for results submitted while the scan runs. With
+	// result_ttl, ExpiresAt is when downstream should treat them as stale.
+	StartedAt   time.Time  `json:"started_at"`
+	CompletedAt time.Time  `json:"completed_at"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+
+	// Integrity fields, set by Sign at submission time
+	PayloadSHA256 string `json:"payload_sha256,omitempty"`
+	Signature     string `json:"signature,omitempty"` // HMAC-SHA256, only with a key
 }
 
-// SubmitResults sends scan results to the API
+// SetTimes records the scan's start, the results' completion and, with a
+// positive ttl, their expiry. Times are kept in UTC to the microsecond,
+// the precision the API parses.
+func (r *ScanResults) SetTimes(startedAt, completedAt time.Time, ttl time.Duration) {
+	r.StartedAt = startedAt.UTC().Truncate(time.Microsecond)
+	r.CompletedAt = completedAt.UTC().Truncate(time.Microsecond)
+	r.ExpiresAt = nil
+	if ttl > 0 {
+		expires := r.CompletedAt.Add(ttl)
+		r.ExpiresAt = &expires
+	}
+}
+
+// SubmitResults sends scan results to the API. A batch the API didn't
+// take is resent up to SubmitAttempts times with the same body, so every
+// attempt carries the same idempotency keys.
 func (c *APIClient) SubmitResults(results *ScanResults) error {
+	for i := range results.Hosts {
+		results.Hosts[i].IdempotencyKey = HostIdempotencyKey(results.ScanID, results.Hosts[i].IPAddress)
+	}
+	if err := results.Sign(c.HMACKey); err != nil {
+		return err
+	}
+
 	data, err := json.Marshal(results)
 	if err != nil {
 		return fmt.Errorf("failed to marshal results: %w", err)
 	}
+	key := results.IdempotencyKey()
+
+	attempts := c.SubmitAttempts
+	if attempts <= 0 {
+		attempts = DefaultSubmitAttempts
+	}
+	delay := c.RetryDelay
+	if delay <= 0 {
+		delay = DefaultRetryDelay
+	}
+	for attempt := 1; ; attempt++ {
+		retry, err := c.submit(data, key)
+		if err == nil || !retry || attempt == attempts {
+			return err
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
 
+// submit posts one attempt at a batch and reports whether a failure is
+// worth retrying
+func (c *APIClient) submit(data []byte, key string) (retry bool, err error) {
 	url := fmt.Sprintf("%s/api/scan/results", c.BaseURL)
-	resp, err := c.HTTPClient.Post(url, "application/json", bytes.NewBuffer(data))
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(data))
 	if err != nil {
-		return fmt.Errorf("failed to submit results: %w", err)
+		return false, fmt.Errorf("failed to submit results: %w", err)
 	}
-	defer resp.Body.Close()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", key)
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("failed to submit results: %w", err)
+	}
+	defer drainAndClose(resp.Body)
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("API returned status %d", resp.StatusCode)
+		retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		return retry, fmt.Errorf("API returned status %d", resp.StatusCode)
 	}
 
-	return nil
+	return false, nil
+}
+
+// HostIdempotencyKey identifies one host's results in a scan. It depends
+// only on the scan ID and the host's IP, so a host resent on retry keeps
+// its key and every other host gets another.
+func HostIdempotencyKey(scanID uuid.UUID, ip string) string {
+	sum := sha256.Sum256([]byte(scanID.String() + "\n" + ip))
+	return hex.EncodeToString(sum[:])
+}
+
+// IdempotencyKey identifies a submission by the host keys and endpoints
+// it reports, in any order, so resubmitting a batch sends the same key and
+// the API can drop the duplicate while other batches of the same hosts,
+// which cover other ports, keep distinct keys
+func (r *ScanResults) IdempotencyKey() string {
+	var endpoints []string
+	for _, host := range r.Hosts {
+		hostKey := HostIdempotencyKey(r.ScanID, host.IPAddress)
+		for _, port := range host.Ports {
+			endpoints = append(endpoints, fmt.Sprintf("%s/%d/%s", hostKey, port.PortNumber, port.Protocol))
+		}
+		if len(host.Ports) == 0 {
+			endpoints = append(endpoints, hostKey)
+		}
+	}
+	sort.Strings(endpoints)
+
+	h := sha256.New()
+	for _, endpoint := range endpoints {
+		h.Write([]byte(endpoint + "\n"))
+	}
+	return hex.EncodeToString(h.Sum(nil))
 }
 
 // HealthCheck checks if the API is available
@@ -79,7 +263,7 @@ func (c *APIClient) HealthCheck() error {
 	if err != nil {
 		return fmt.Errorf("health check failed: %w", err)
 	}
-	defer resp.Body.Close()
+	defer drainAndClose(resp.Body)
 
 	if resp.StatusCode != http.StatusOK {
 		return fmt.Errorf("API unhealthy: status %d", resp.StatusCode)
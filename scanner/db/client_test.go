@@ -0,0 +1,351 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestAddressFamily(t *testing.T) {
+	for ip, want := range map[string]string{
+		"10.0.0.1":        "ipv4",
+		"::ffff:10.0.0.1": "ipv4",
+		"2001:db8::1":     "ipv6",
+		"::1":             "ipv6",
+		"":                "",
+		"not-an-ip":       "",
+	} {
+		if got := AddressFamily(ip); got != want {
+			t.Errorf("AddressFamily(%q) = %q, want %q", ip, got, want)
+		}
+	}
+}
+
+// countingAPI accepts results and counts the connections opened to it and
+// still open
+type countingAPI struct {
+	*httptest.Server
+	opened, open atomic.Int64
+}
+
+func newCountingAPI(t *testing.T) *countingAPI {
+	t.Helper()
+	api := &countingAPI{}
+	api.Server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	api.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		switch state {
+		case http.StateNew:
+			api.opened.Add(1)
+			api.open.Add(1)
+		case http.StateClosed, http.StateHijacked:
+			api.open.Add(-1)
+		}
+	}
+	api.Start()
+	t.Cleanup(api.Close)
+	return api
+}
+
+func TestSubmitResultsReusesConnections(t *testing.T) {
+	api := newCountingAPI(t)
+	client := NewAPIClient(api.URL)
+
+	for i := 0; i < 100; i++ {
+		results := &ScanResults{ScanID: uuid.New(), Hosts: []ScanResultHost{{IPAddress: "10.0.0.1", Ports: []ScanResultPort{{PortNumber: 22, Protocol: "tcp", State: "open"}}}}}
+		if err := client.SubmitResults(results); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if opened := api.opened.Load(); opened != 1 {
+		t.Errorf("100 sequential submissions opened %d connections, want 1", opened)
+	}
+
+	// Close reaps the idle connection
+	client.Close()
+	deadline := time.Now().Add(time.Second)
+	for api.open.Load() != 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if open := api.open.Load(); open != 0 {
+		t.Errorf("%d connections still open after Close", open)
+	}
+}
+
+func TestSubmitResultsBurstBoundedByIdlePool(t *testing.T) {
+	api := newCountingAPI(t)
+	client := NewAPIClientWithOptions(api.URL, TransportOptions{MaxIdleConnsPerHost: 4})
+	defer client.Close()
+
+	// Bursts of four concurrent submissions keep reusing the same four
+	for burst := 0; burst < 10; burst++ {
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := client.SubmitResults(&ScanResults{ScanID: uuid.New()}); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+	}
+	if opened := api.opened.Load(); opened > 4 {
+		t.Errorf("40 submissions in bursts of 4 opened %d connections, want at most 4", opened)
+	}
+}
+
+// keyedAPI records the Idempotency-Key and body of each submission and
+// answers the first fail of them with status, as a flaky API would
+type keyedAPI struct {
+	*httptest.Server
+	mu     sync.Mutex
+	keys   []string
+	bodies [][]byte
+	fail   int
+	status int
+}
+
+func newKeyedAPI(t *testing.T, fail int, status int) *keyedAPI {
+	t.Helper()
+	api := &keyedAPI{fail: fail, status: status}
+	api.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		api.mu.Lock()
+		defer api.mu.Unlock()
+		api.keys = append(api.keys, r.Header.Get("Idempotency-Key"))
+		api.bodies = append(api.bodies, body)
+		if len(api.keys) <= api.fail {
+			w.WriteHeader(api.status)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(api.Close)
+	return api
+}
+
+// newRetryingClient is a client for api that retries without waiting long
+func newRetryingClient(api *keyedAPI) *APIClient {
+	client := NewAPIClient(api.URL)
+	client.RetryDelay = time.Millisecond
+	return client
+}
+
+// keyedResults is a batch of scanID with each IP open on ports
+func keyedResults(scanID uuid.UUID, ports []int, ips ...string) *ScanResults {
+	results := &ScanResults{ScanID: scanID}
+	for _, ip := range ips {
+		host := ScanResultHost{IPAddress: ip}
+		for _, port := range ports {
+			host.Ports = append(host.Ports, ScanResultPort{PortNumber: port, Protocol: "tcp", State: "open"})
+		}
+		results.Hosts = append(results.Hosts, host)
+	}
+	return results
+}
+
+func TestSubmitResultsRetrySendsSameKey(t *testing.T) {
+	api := newKeyedAPI(t, 2, http.StatusServiceUnavailable)
+	client := newRetryingClient(api)
+	client.HMACKey = []byte("secret")
+	scanID := uuid.New()
+
+	// Two failed attempts, then the third gets through
+	if err := client.SubmitResults(keyedResults(scanID, []int{22}, "10.0.0.1", "10.0.0.2")); err != nil {
+		t.Fatalf("SubmitResults = %v, want it to get through on the third attempt", err)
+	}
+	if len(api.keys) != 3 || api.keys[0] == "" || api.keys[1] != api.keys[0] || api.keys[2] != api.keys[0] {
+		t.Errorf("keys = %q, want the same key on all 3 attempts", api.keys)
+	}
+	if len(api.keys[0]) != 64 {
+		t.Errorf("key %q, want a hex SHA-256", api.keys[0])
+	}
+
+	// Each host carries its own key, the same on every attempt
+	for attempt, body := range api.bodies {
+		var sent ScanResults
+		if err := json.Unmarshal(body, &sent); err != nil {
+			t.Fatal(err)
+		}
+		for _, host := range sent.Hosts {
+			if want := HostIdempotencyKey(scanID, host.IPAddress); host.IdempotencyKey != want {
+				t.Errorf("attempt %d: %s sent key %q, want %s", attempt, host.IPAddress, host.IdempotencyKey, want)
+			}
+		}
+		if !bytes.Equal(body, api.bodies[0]) {
+			t.Errorf("attempt %d resent a different body", attempt)
+		}
+	}
+
+	// The same endpoints in another order are the same batch
+	reordered := keyedResults(scanID, []int{443, 22}, "10.0.0.2", "10.0.0.1")
+	if got, want := reordered.IdempotencyKey(), keyedResults(scanID, []int{22, 443}, "10.0.0.1", "10.0.0.2").IdempotencyKey(); got != want {
+		t.Errorf("reordered batch key %s, want %s", got, want)
+	}
+}
+
+func TestSubmitResultsRetryBounded(t *testing.T) {
+	api := newKeyedAPI(t, 10, http.StatusBadGateway)
+	client := newRetryingClient(api)
+	client.SubmitAttempts = 4
+	if err := client.SubmitResults(keyedResults(uuid.New(), []int{22}, "10.0.0.1")); err == nil || !strings.Contains(err.Error(), "502") {
+		t.Errorf("SubmitResults = %v, want the last 502", err)
+	}
+	if len(api.keys) != 4 {
+		t.Errorf("%d attempts, want 4", len(api.keys))
+	}
+
+	// A rejected batch is not resent
+	for _, status := range []int{http.StatusBadRequest, http.StatusUnauthorized} {
+		api := newKeyedAPI(t, 1, status)
+		if err := newRetryingClient(api).SubmitResults(keyedResults(uuid.New(), []int{22}, "10.0.0.1")); err == nil {
+			t.Errorf("%d: SubmitResults succeeded", status)
+		}
+		if len(api.keys) != 1 {
+			t.Errorf("%d: %d attempts, want 1", status, len(api.keys))
+		}
+	}
+}
+
+func TestHostIdempotencyKey(t *testing.T) {
+	scanID := uuid.New()
+	key := HostIdempotencyKey(scanID, "10.0.0.1")
+	if len(key) != 64 || HostIdempotencyKey(scanID, "10.0.0.1") != key {
+		t.Fatalf("key %q, want a stable hex SHA-256", key)
+	}
+	for name, other := range map[string]string{
+		"another host": HostIdempotencyKey(scanID, "10.0.0.2"),
+		"another scan": HostIdempotencyKey(uuid.New(), "10.0.0.1"),
+	} {
+		if other == key {
+			t.Errorf("%s shares key %s", name, key)
+		}
+	}
+}
+
+func TestIdempotencyKeyDiffers(t *testing.T) {
+	scanID := uuid.New()
+	batches := map[string]*ScanResults{
+		"10.0.0.1:22":              keyedResults(scanID, []int{22}, "10.0.0.1"),
+		"10.0.0.2:22":              keyedResults(scanID, []int{22}, "10.0.0.2"),
+		"10.0.0.1:80":              keyedResults(scanID, []int{80}, "10.0.0.1"),
+		"10.0.0.1:22, 10.0.0.2:22": keyedResults(scanID, []int{22}, "10.0.0.1", "10.0.0.2"),
+		"10.0.0.1 without ports":   keyedResults(scanID, nil, "10.0.0.1"),
+		"10.0.0.1:22, next scan":   keyedResults(uuid.New(), []int{22}, "10.0.0.1"),
+	}
+	udp := keyedResults(scanID, []int{22}, "10.0.0.1")
+	udp.Hosts[0].Ports[0].Protocol = "udp"
+	batches["10.0.0.1:22/udp"] = udp
+
+	seen := make(map[string]string)
+	for name, results := range batches {
+		key := results.IdempotencyKey()
+		if other, ok := seen[key]; ok {
+			t.Errorf("%s and %s share key %s", name, other, key)
+		}
+		seen[key] = name
+	}
+}
+
+func TestHealthCheckSharesConnection(t *testing.T) {
+	api := newCountingAPI(t)
+	client := NewAPIClient(api.URL)
+	defer client.Close()
+
+	for i := 0; i < 5; i++ {
+		if err := client.HealthCheck(); err != nil {
+			t.Fatal(err)
+		}
+		if err := client.SubmitResults(&ScanResults{ScanID: uuid.New()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if opened := api.opened.Load(); opened != 1 {
+		t.Errorf("health checks and submissions opened %d connections, want 1", opened)
+	}
+}
+
+func TestNewAPIClientWithOptions(t *testing.T) {
+	client := NewAPIClientWithOptions("http://api", TransportOptions{MaxIdleConnsPerHost: 3, IdleConnTimeout: time.Minute, RequestTimeout: 5 * time.Second})
+	transport := client.HTTPClient.Transport.(*http.Transport)
+	if transport.MaxIdleConnsPerHost != 3 || transport.IdleConnTimeout != time.Minute || client.HTTPClient.Timeout != 5*time.Second {
+		t.Errorf("transport = %d idle, %v idle timeout, %v request timeout", transport.MaxIdleConnsPerHost, transport.IdleConnTimeout, client.HTTPClient.Timeout)
+	}
+	defaults := NewAPIClient("http://api")
+	transport = defaults.HTTPClient.Transport.(*http.Transport)
+	if transport.MaxIdleConnsPerHost != 10 || transport.IdleConnTimeout != 90*time.Second || defaults.HTTPClient.Timeout != 30*time.Second {
+		t.Errorf("default transport = %d idle, %v idle timeout, %v request timeout", transport.MaxIdleConnsPerHost, transport.IdleConnTimeout, defaults.HTTPClient.Timeout)
+	}
+}
+
+func TestSetTimes(t *testing.T) {
+	berlin := time.FixedZone("CEST", 2*3600)
+	started := time.Date(2026, 10, 14, 11, 0, 0, 123456789, berlin)
+	completed := started.Add(90 * time.Second)
+
+	var results ScanResults
+	results.SetTimes(started, completed, 48*time.Hour)
+	if !results.StartedAt.Equal(started.Truncate(time.Microsecond)) || results.StartedAt.Location() != time.UTC || results.StartedAt.Nanosecond() != 123456000 {
+		t.Errorf("started_at = %v, want %v in UTC to the microsecond", results.StartedAt, started)
+	}
+	if !results.CompletedAt.Equal(completed.Truncate(time.Microsecond)) || results.CompletedAt.Location() != time.UTC {
+		t.Errorf("completed_at = %v, want %v in UTC", results.CompletedAt, completed)
+	}
+	if results.ExpiresAt == nil || !results.ExpiresAt.Equal(results.CompletedAt.Add(48*time.Hour)) {
+		t.Errorf("expires_at = %v, want completed_at + 48h", results.ExpiresAt)
+	}
+
+	// Reused without a TTL, the old expiry goes
+	results.SetTimes(started, completed, 0)
+	if results.ExpiresAt != nil {
+		t.Errorf("expires_at = %v without a TTL", *results.ExpiresAt)
+	}
+}
+
+func TestSubmitResultsTimes(t *testing.T) {
+	started := time.Date(2026, 10, 14, 9, 0, 0, 250000000, time.UTC)
+	for ttl, wantExpires := range map[time.Duration]string{0: "", time.Hour: "2026-10-14T10:01:30.25Z"} {
+		var data []byte
+		api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			data, _ = io.ReadAll(r.Body)
+		}))
+		results := keyedResults(uuid.New(), []int{22}, "10.0.0.1")
+		results.SetTimes(started, started.Add(90*time.Second), ttl)
+		err := NewAPIClient(api.URL).SubmitResults(results)
+		api.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// As submitted
+		var payload map[string]interface{}
+		if err := json.Unmarshal(data, &payload); err != nil {
+			t.Fatal(err)
+		}
+		if payload["started_at"] != "2026-10-14T09:00:00.25Z" || payload["completed_at"] != "2026-10-14T09:01:30.25Z" {
+			t.Errorf("ttl %s: started_at %v, completed_at %v", ttl, payload["started_at"], payload["completed_at"])
+		}
+		if got, ok := payload["expires_at"]; wantExpires == "" && ok || wantExpires != "" && got != wantExpires {
+			t.Errorf("ttl %s: expires_at = %v, want %q", ttl, got, wantExpires)
+		}
+
+		var back ScanResults
+		if err := json.Unmarshal(data, &back); err != nil || !back.StartedAt.Equal(results.StartedAt) || !back.CompletedAt.Equal(results.CompletedAt) || (back.ExpiresAt == nil) != (results.ExpiresAt == nil) {
+			t.Errorf("ttl %s: round trip %+v, %v", ttl, back, err)
+		}
+	}
+}
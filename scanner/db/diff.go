@@ -0,0 +1,191 @@
+package db
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// EndpointKey identifies a port on a host
+type EndpointKey struct {
+	IP       string
+	Port     int
+	Protocol string
+}
+
+// Snapshot is the set of ports observed in one scan, keyed by endpoint
+type Snapshot map[EndpointKey]ScanResultPort
+
+// Add records every port of host
+func (s Snapshot) Add(host ScanResultHost) {
+	for _, port := range host.Ports {
+		s[EndpointKey{IP: host.IPAddress, Port: port.PortNumber, Protocol: port.Protocol}] = port
+	}
+}
+
+// Hosts returns the distinct IPs in the snapshot, sorted
+func (s Snapshot) Hosts() []string {
+	seen := make(map[string]bool)
+	var hosts []string
+	for key := range s {
+		if !seen[key.IP] {
+			seen[key.IP] = true
+			hosts = append(hosts, key.IP)
+		}
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// Diff describes how one scan differs from the previous one
+type Diff struct {
+	NewPorts     []EndpointKey // open now, absent before
+	ChangedPorts []EndpointKey // open in both, but the service looks different
+	RemovedPorts []EndpointKey // open before, absent now
+	NewHosts     []string      // IPs with no ports in the previous scan
+	RemovedHosts []string      // IPs with no ports in the current scan
+}
+
+// Empty reports whether nothing changed
+func (d Diff) Empty() bool {
+	return len(d.NewPorts) == 0 && len(d.ChangedPorts) == 0 && len(d.RemovedPorts) == 0
+}
+
+// DiffSnapshots compares cur against prev
+func DiffSnapshots(prev, cur Snapshot) Diff {
+	var d Diff
+	for key, port := range cur {
+		old, ok := prev[key]
+		switch {
+		case !ok:
+			d.NewPorts = append(d.NewPorts, key)
+		case PortChanged(old, port):
+			d.ChangedPorts = append(d.ChangedPorts, key)
+		}
+	}
+	for key := range prev {
+		if _, ok := cur[key]; !ok {
+			d.RemovedPorts = append(d.RemovedPorts, key)
+		}
+	}
+
+	prevHosts := make(map[string]bool)
+	for _, ip := range prev.Hosts() {
+		prevHosts[ip] = true
+	}
+	curHosts := make(map[string]bool)
+	for _, ip := range cur.Hosts() {
+		curHosts[ip] = true
+		if !prevHosts[ip] {
+			d.NewHosts = append(d.NewHosts, ip)
+		}
+	}
+	for ip := range prevHosts {
+		if !curHosts[ip] {
+			d.RemovedHosts = append(d.RemovedHosts, ip)
+		}
+	}
+
+	sortKeys(d.NewPorts)
+	sortKeys(d.ChangedPorts)
+	sortKeys(d.RemovedPorts)
+	sort.Strings(d.RemovedHosts)
+	return d
+}
+
+// PortChanged reports whether two observations of the same endpoint differ
+// in state or service identity. Volatile fingerprint data (timings, headers)
+// is ignored.
+func PortChanged(a, b ScanResultPort) bool {
+	return a.State != b.State ||
+		a.ServiceName != b.ServiceName ||
+		a.ServiceVersion != b.ServiceVersion ||
+		a.Banner != b.Banner
+}
+
+func sortKeys(keys []EndpointKey) {
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].IP != keys[j].IP {
+			return keys[i].IP < keys[j].IP
+		}
+		if keys[i].Port != keys[j].Port {
+			return keys[i].Port < keys[j].Port
+		}
+		return keys[i].Protocol < keys[j].Protocol
+	})
+}
+
+// ChangeFilter suppresses submissions of endpoints that are unchanged since
+// the previous scan. Every ResyncInterval a scan submits everything so the
+// API's last-seen times self-heal.
+type ChangeFilter struct {
+	ResyncInterval time.Duration
+
+	mu       sync.Mutex
+	baseline Snapshot
+	current  Snapshot
+	lastFull time.Time
+	full     bool
+}
+
+// NewChangeFilter creates a ChangeFilter with no baseline; the first scan
+// is always a full submission
+func NewChangeFilter(resyncInterval time.Duration) *ChangeFilter {
+	return &ChangeFilter{
+		ResyncInterval: resyncInterval,
+		baseline:       make(Snapshot),
+		current:        make(Snapshot),
+	}
+}
+
+// BeginScan starts tracking a new scan and reports whether it is a full resync
+func (f *ChangeFilter) BeginScan() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.current = make(Snapshot)
+	f.full = len(f.baseline) == 0 ||
+		(f.ResyncInterval > 0 && time.Since(f.lastFull) >= f.ResyncInterval)
+	return f.full
+}
+
+// Filter records host in the current scan and returns the host with only
+// the ports that should be submitted. It returns false if none remain.
+func (f *ChangeFilter) Filter(host ScanResultHost) (ScanResultHost, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.current.Add(host)
+	if f.full {
+		return host, len(host.Ports) > 0
+	}
+
+	var changed []ScanResultPort
+	for _, port := range host.Ports {
+		old, ok := f.baseline[EndpointKey{IP: host.IPAddress, Port: port.PortNumber, Protocol: port.Protocol}]
+		if !ok || PortChanged(old, port) || port.FingerprintData["mac_changed"] != nil {
+			changed = append(changed, port)
+		}
+	}
+	host.Ports = changed
+	return host, len(changed) > 0
+}
+
+// EndScan promotes the current scan to the baseline. An incomplete scan is
+// merged into the old baseline rather than replacing it.
+func (f *ChangeFilter) EndScan(completed bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if completed {
+		f.baseline = f.current
+		if f.full {
+			f.lastFull = time.Now()
+		}
+	} else {
+		for key, port := range f.current {
+			f.baseline[key] = port
+		}
+	}
+	f.current = make(Snapshot)
+}
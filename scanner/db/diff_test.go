@@ -0,0 +1,123 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testHost(ip string, ports ...ScanResultPort) ScanResultHost {
+	return ScanResultHost{IPAddress: ip, Ports: ports}
+}
+
+func testPort(port int, service, version string) ScanResultPort {
+	return ScanResultPort{PortNumber: port, Protocol: "tcp", State: "open", ServiceName: service, ServiceVersion: version}
+}
+
+// filterScan runs one scan of hosts through f and returns the hosts it
+// would submit
+func filterScan(f *ChangeFilter, hosts ...ScanResultHost) (bool, []ScanResultHost) {
+	full := f.BeginScan()
+	var submitted []ScanResultHost
+	for _, host := range hosts {
+		if host, ok := f.Filter(host); ok {
+			submitted = append(submitted, host)
+		}
+	}
+	f.EndScan(true)
+	return full, submitted
+}
+
+func TestChangeFilter(t *testing.T) {
+	f := NewChangeFilter(0)
+	ssh := testPort(22, "ssh", "OpenSSH 9.6")
+	web := testPort(80, "http", "nginx 1.24")
+
+	full, submitted := filterScan(f, testHost("10.0.0.1", ssh, web), testHost("10.0.0.2", ssh))
+	if !full || len(submitted) != 2 {
+		t.Fatalf("first scan: full %v, submitted %v; want everything", full, submitted)
+	}
+
+	// Unchanged hosts are skipped; only the changed port of a host is sent
+	upgraded := testPort(80, "http", "nginx 1.26")
+	upgraded.FingerprintData = map[string]interface{}{"connect_ms": 0.4}
+	newPort := testPort(443, "https", "")
+	full, submitted = filterScan(f,
+		testHost("10.0.0.1", ssh, upgraded),
+		testHost("10.0.0.2", ssh, newPort),
+		testHost("10.0.0.3", ssh),
+	)
+	want := []ScanResultHost{
+		testHost("10.0.0.1", upgraded),
+		testHost("10.0.0.2", newPort),
+		testHost("10.0.0.3", ssh),
+	}
+	if full || !reflect.DeepEqual(submitted, want) {
+		t.Errorf("second scan: full %v, submitted %v, want %v", full, submitted, want)
+	}
+
+	// Volatile fingerprint data alone is not a change
+	jittered := upgraded
+	jittered.FingerprintData = map[string]interface{}{"connect_ms": 0.9}
+	if _, submitted = filterScan(f, testHost("10.0.0.1", ssh, jittered)); len(submitted) != 0 {
+		t.Errorf("third scan submitted %v, want nothing", submitted)
+	}
+
+	// A changed MAC is always sent
+	flagged := ssh
+	flagged.FingerprintData = map[string]interface{}{"mac_changed": map[string]interface{}{"old": "a", "new": "b"}}
+	if _, submitted = filterScan(f, testHost("10.0.0.1", flagged)); len(submitted) != 1 {
+		t.Errorf("scan with a MAC change submitted %v", submitted)
+	}
+}
+
+func TestChangeFilterResync(t *testing.T) {
+	f := NewChangeFilter(time.Hour)
+	host := testHost("10.0.0.1", testPort(22, "ssh", ""))
+	filterScan(f, host)
+	if full, submitted := filterScan(f, host); full || len(submitted) != 0 {
+		t.Fatalf("scan within the resync interval: full %v, submitted %v", full, submitted)
+	}
+	f.lastFull = time.Now().Add(-2 * time.Hour)
+	if full, submitted := filterScan(f, host); !full || len(submitted) != 1 {
+		t.Errorf("scan past the resync interval: full %v, submitted %v; want a full resync", full, submitted)
+	}
+}
+
+func TestChangeFilterIncompleteScanKeepsBaseline(t *testing.T) {
+	f := NewChangeFilter(0)
+	filterScan(f, testHost("10.0.0.1", testPort(22, "ssh", "")), testHost("10.0.0.2", testPort(22, "ssh", "")))
+
+	// A cancelled scan that only reached 10.0.0.1 must not forget 10.0.0.2
+	f.BeginScan()
+	f.Filter(testHost("10.0.0.1", testPort(22, "ssh", "")))
+	f.EndScan(false)
+
+	if _, submitted := filterScan(f, testHost("10.0.0.2", testPort(22, "ssh", ""))); len(submitted) != 0 {
+		t.Errorf("unchanged host resubmitted after an incomplete scan: %v", submitted)
+	}
+}
+
+func TestDiffSnapshots(t *testing.T) {
+	prev, cur := make(Snapshot), make(Snapshot)
+	prev.Add(testHost("10.0.0.1", testPort(22, "ssh", "1"), testPort(80, "http", "")))
+	prev.Add(testHost("10.0.0.2", testPort(22, "ssh", "")))
+	cur.Add(testHost("10.0.0.1", testPort(22, "ssh", "2"), testPort(443, "https", "")))
+	cur.Add(testHost("10.0.0.3", testPort(22, "ssh", "")))
+
+	d := DiffSnapshots(prev, cur)
+	key := func(ip string, port int) EndpointKey { return EndpointKey{IP: ip, Port: port, Protocol: "tcp"} }
+	want := Diff{
+		NewPorts:     []EndpointKey{key("10.0.0.1", 443), key("10.0.0.3", 22)},
+		ChangedPorts: []EndpointKey{key("10.0.0.1", 22)},
+		RemovedPorts: []EndpointKey{key("10.0.0.1", 80), key("10.0.0.2", 22)},
+		NewHosts:     []string{"10.0.0.3"},
+		RemovedHosts: []string{"10.0.0.2"},
+	}
+	if !reflect.DeepEqual(d, want) {
+		t.Errorf("DiffSnapshots = %+v, want %+v", d, want)
+	}
+	if d.Empty() || !DiffSnapshots(cur, cur).Empty() {
+		t.Error("Empty() is wrong")
+	}
+}
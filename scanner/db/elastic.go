@@ -0,0 +1,196 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultESIndex is the index documents go to when es_index is unset
+const DefaultESIndex = "network-scanner"
+
+// ESDocument is one host port flattened for Elasticsearch. Host fields are
+// repeated on every port so each document stands alone in searches.
+type ESDocument struct {
+	Timestamp      time.Time              `json:"@timestamp"`
+	ScanID         string                 `json:"scan_id"`
+	IP             string                 `json:"ip"`
+	Hostname       string                 `json:"hostname,omitempty"`
+	MACAddress     string                 `json:"mac_address,omitempty"`
+	Port           int                    `json:"port"`
+	Protocol       string                 `json:"protocol"`
+	State          string                 `json:"state"`
+	ServiceName    string                 `json:"service_name,omitempty"`
+	ServiceVersion string                 `json:"service_version,omitempty"`
+	Banner         string                 `json:"banner,omitempty"`
+	Fingerprint    map[string]interface{} `json:"fingerprint,omitempty"`
+	Tags           map[string]string      `json:"tags,omitempty"`
+}
+
+// esAction is the bulk action line preceding each document
+type esAction struct {
+	Index struct {
+		Index string `json:"_index"`
+		ID    string `json:"_id"`
+	} `json:"index"`
+}
+
+// ESDocumentID identifies a port's document within a scan, so a batch sent
+// twice overwrites its documents instead of duplicating them
+func ESDocumentID(scanID, ip string, port int, protocol string) string {
+	return fmt.Sprintf("%s-%s-%d-%s", scanID, ip, port, protocol)
+}
+
+// ESBulkBody encodes every port of results as bulk-index NDJSON for index:
+// an {"index": {...}} action line followed by the document, each ending in
+// a newline as the _bulk API requires
+func ESBulkBody(index string, results *ScanResults, at time.Time) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf) // Encode ends each value with "\n"
+	enc.SetEscapeHTML(false)
+	scanID := results.ScanID.String()
+	for _, host := range results.Hosts {
+		for _, port := range host.Ports {
+			var action esAction
+			action.Index.Index = index
+			action.Index.ID = ESDocumentID(scanID, host.IPAddress, port.PortNumber, port.Protocol)
+			if err := enc.Encode(action); err != nil {
+				return nil, err
+			}
+			doc := ESDocument{
+				Timestamp:      at.UTC(),
+				ScanID:         scanID,
+				IP:             host.IPAddress,
+				Hostname:       host.Hostname,
+				MACAddress:     host.MACAddress,
+				Port:           port.PortNumber,
+				Protocol:       port.Protocol,
+				State:          port.State,
+				ServiceName:    port.ServiceName,
+				ServiceVersion: port.ServiceVersion,
+				Banner:         port.Banner,
+				Fingerprint:    port.FingerprintData,
+				Tags:           results.Tags,
+			}
+			if err := enc.Encode(doc); err != nil {
+				return nil, fmt.Errorf("failed to encode document for %s:%d: %w", host.IPAddress, port.PortNumber, err)
+			}
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// ESBulkSink sends results to Elasticsearch in bulk-index format, either
+// POSTed to a _bulk endpoint or appended to an NDJSON file for a shipper
+// to load
+type ESBulkSink struct {
+	URL        string // http(s) _bulk endpoint; credentials may be in the userinfo
+	Path       string // file to append to instead of URL
+	Index      string
+	HTTPClient *http.Client
+
+	mu sync.Mutex // serializes appends to Path
+}
+
+// NewESBulkSink creates a sink for target, an http(s) _bulk URL such as
+// "http://es:9200/_bulk" or a file:// URL, writing to index (default
+// DefaultESIndex)
+func NewESBulkSink(target, index string) (*ESBulkSink, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid es_bulk_url: %w", err)
+	}
+	if index == "" {
+		index = DefaultESIndex
+	}
+	s := &ESBulkSink{Index: index}
+	switch u.Scheme {
+	case "http", "https":
+		s.URL = target
+		s.HTTPClient = &http.Client{Timeout: 30 * time.Second}
+	case "file":
+		if u.Path == "" {
+			return nil, fmt.Errorf("invalid es_bulk_url %q: file URL needs a path", target)
+		}
+		s.Path = u.Path
+	default:
+		return nil, fmt.Errorf("invalid es_bulk_url %q: must be an http(s) or file URL", target)
+	}
+	return s, nil
+}
+
+// Write sends every port of results as one bulk request, or one append
+func (s *ESBulkSink) Write(results *ScanResults, at time.Time) error {
+	body, err := ESBulkBody(s.Index, results, at)
+	if err != nil || len(body) == 0 {
+		return err
+	}
+	if s.Path != "" {
+		return s.appendFile(body)
+	}
+	return s.post(body)
+}
+
+func (s *ESBulkSink) appendFile(body []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := os.OpenFile(s.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", s.Path, err)
+	}
+	if _, err := f.Write(body); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write %s: %w", s.Path, err)
+	}
+	return f.Close()
+}
+
+// esBulkResponse is the part of a _bulk response that reports per-item
+// failures, which come back with status 200
+type esBulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		ID     string          `json:"_id"`
+		Status int             `json:"status"`
+		Error  json.RawMessage `json:"error"`
+	} `json:"items"`
+}
+
+func (s *ESBulkSink) post(body []byte) error {
+	resp, err := s.HTTPClient.Post(s.URL, "application/x-ndjson", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to post bulk request: %w", err)
+	}
+	defer drainAndClose(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("elasticsearch returned status %d", resp.StatusCode)
+	}
+
+	var bulk esBulkResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 16<<20)).Decode(&bulk); err != nil {
+		return fmt.Errorf("failed to decode bulk response: %w", err)
+	}
+	if !bulk.Errors {
+		return nil
+	}
+	failed := 0
+	var first string
+	for _, item := range bulk.Items {
+		for _, result := range item {
+			if result.Status < 300 {
+				continue
+			}
+			if failed == 0 {
+				first = fmt.Sprintf("%s: status %d: %s", result.ID, result.Status, result.Error)
+			}
+			failed++
+		}
+	}
+	return fmt.Errorf("elasticsearch rejected %d of %d documents, first %s", failed, len(bulk.Items), first)
+}
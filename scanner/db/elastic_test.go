@@ -0,0 +1,309 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// bulkItem is one operation of a bulk request
+type bulkItem struct {
+	op, index, id string
+	doc           ESDocument
+}
+
+// parseBulk reads body the way the _bulk API does: newline-terminated
+// lines, each an action naming exactly one operation, followed for all but
+// delete by the document line. Documents are decoded strictly so any field
+// ESDocument doesn't map fails.
+func parseBulk(body []byte) ([]bulkItem, error) {
+	if len(body) > 0 && body[len(body)-1] != '\n' {
+		return nil, fmt.Errorf("bulk body does not end in a newline")
+	}
+	lines := strings.Split(strings.TrimSuffix(string(body), "\n"), "\n")
+	if len(body) == 0 {
+		lines = nil
+	}
+	var items []bulkItem
+	for i := 0; i < len(lines); i++ {
+		var action map[string]struct {
+			Index string `json:"_index"`
+			ID    string `json:"_id"`
+		}
+		if err := json.Unmarshal([]byte(lines[i]), &action); err != nil {
+			return nil, fmt.Errorf("line %d: malformed action: %w", i+1, err)
+		}
+		if len(action) != 1 {
+			return nil, fmt.Errorf("line %d: action names %d operations, want 1", i+1, len(action))
+		}
+		var item bulkItem
+		for op, meta := range action {
+			item.op, item.index, item.id = op, meta.Index, meta.ID
+		}
+		switch item.op {
+		case "delete":
+			items = append(items, item)
+			continue
+		case "index", "create", "update":
+		default:
+			return nil, fmt.Errorf("line %d: unknown operation %q", i+1, item.op)
+		}
+		i++
+		if i == len(lines) {
+			return nil, fmt.Errorf("%s action with no document", item.op)
+		}
+		dec := json.NewDecoder(strings.NewReader(lines[i]))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&item.doc); err != nil {
+			return nil, fmt.Errorf("line %d: malformed document: %w", i+1, err)
+		}
+		if dec.More() {
+			return nil, fmt.Errorf("line %d: more than one document", i+1)
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// esResults is two hosts, one with a banner that needs escaping to stay on
+// its line
+func esResults() *ScanResults {
+	return &ScanResults{
+		ScanID: uuid.MustParse("6f1c2a4e-8a53-4d6e-9c1b-2f0e5d7a9b31"),
+		Tags:   map[string]string{"env": "prod"},
+		Hosts: []ScanResultHost{
+			{IPAddress: "10.0.0.1", Hostname: "web1", MACAddress: "00:11:22:33:44:55", Ports: []ScanResultPort{
+				{PortNumber: 80, Protocol: "tcp", State: "open", ServiceName: "http", ServiceVersion: "nginx/1.24.0",
+					Banner:          "HTTP/1.1 200 OK\r\nServer: nginx\r\n\r\n<html>",
+					FingerprintData: map[string]interface{}{"title": "Router & Login", "product": map[string]interface{}{"vendor": "f5", "product": "nginx"}}},
+				{PortNumber: 53, Protocol: "udp", State: "open", ServiceName: "dns"},
+			}},
+			{IPAddress: "2001:db8::7", Ports: []ScanResultPort{{PortNumber: 22, Protocol: "tcp", State: "open"}}},
+		},
+	}
+}
+
+var esTime = time.Date(2026, 10, 14, 16, 5, 9, 0, time.FixedZone("CEST", 2*3600))
+
+func TestESBulkBody(t *testing.T) {
+	results := esResults()
+	body, err := ESBulkBody("scans", results, esTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(body), "\n"); lines != 6 {
+		t.Fatalf("%d lines for 3 ports, want an action and a document each:\n%s", lines, body)
+	}
+	if !bytes.Contains(body, []byte(`"@timestamp":"2026-10-14T14:05:09Z"`)) || !bytes.Contains(body, []byte("<html>")) {
+		t.Errorf("body not UTC-stamped or HTML-escaped:\n%s", body)
+	}
+
+	items, err := parseBulk(body)
+	if err != nil {
+		t.Fatalf("bulk parser rejected the body: %v\n%s", err, body)
+	}
+	scanID := results.ScanID.String()
+	var i int
+	for _, host := range results.Hosts {
+		for _, port := range host.Ports {
+			item := items[i]
+			i++
+			if item.op != "index" || item.index != "scans" || item.id != ESDocumentID(scanID, host.IPAddress, port.PortNumber, port.Protocol) {
+				t.Errorf("action %s %s %s for %s:%d", item.op, item.index, item.id, host.IPAddress, port.PortNumber)
+			}
+			want := ESDocument{
+				Timestamp: esTime.UTC(), ScanID: scanID,
+				IP: host.IPAddress, Hostname: host.Hostname, MACAddress: host.MACAddress,
+				Port: port.PortNumber, Protocol: port.Protocol, State: port.State,
+				ServiceName: port.ServiceName, ServiceVersion: port.ServiceVersion,
+				Banner: port.Banner, Fingerprint: port.FingerprintData, Tags: results.Tags,
+			}
+			if !reflect.DeepEqual(item.doc, want) {
+				t.Errorf("document for %s:%d round-tripped as\n%+v, want\n%+v", host.IPAddress, port.PortNumber, item.doc, want)
+			}
+		}
+	}
+
+	// The same port on another protocol or scan is another document
+	if ESDocumentID(scanID, "10.0.0.1", 53, "udp") == ESDocumentID(scanID, "10.0.0.1", 53, "tcp") ||
+		ESDocumentID(scanID, "10.0.0.1", 53, "udp") == ESDocumentID(uuid.NewString(), "10.0.0.1", 53, "udp") {
+		t.Error("document IDs collide")
+	}
+
+	if body, err := ESBulkBody("scans", &ScanResults{ScanID: results.ScanID}, esTime); err != nil || len(body) != 0 {
+		t.Errorf("no hosts: body %q, %v, want empty", body, err)
+	}
+}
+
+func TestParseBulkRejects(t *testing.T) {
+	for _, body := range []string{
+		`{"index":{"_index":"scans"}}` + "\n" + `{"ip":"10.0.0.1"}`, // no final newline
+		`{"index":{"_index":"scans"}}` + "\n",
+		`{"index":{},"create":{}}` + "\n" + `{}` + "\n",
+		`{"upsert":{}}` + "\n" + `{}` + "\n",
+		`{"index":{}}` + "\n" + `{"ip":"10.0.0.1","unmapped":1}` + "\n",
+	} {
+		if _, err := parseBulk([]byte(body)); err == nil {
+			t.Errorf("parseBulk accepted %q", body)
+		}
+	}
+}
+
+// bulkAPI is a _bulk endpoint that parses each request and answers with
+// the status of each item, rejecting documents for ports in reject
+type bulkAPI struct {
+	*httptest.Server
+	items  [][]bulkItem
+	reject map[int]bool
+}
+
+func newBulkAPI(t *testing.T, reject ...int) *bulkAPI {
+	t.Helper()
+	api := &bulkAPI{reject: make(map[int]bool)}
+	for _, port := range reject {
+		api.reject[port] = true
+	}
+	api.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/_bulk" {
+			t.Errorf("%s %s, want POST /_bulk", r.Method, r.URL.Path)
+		}
+		if user, pass, _ := r.BasicAuth(); user != "elastic" || pass != "changeme" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if ct := r.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+			t.Errorf("Content-Type = %q", ct)
+		}
+		body, _ := io.ReadAll(r.Body)
+		items, err := parseBulk(body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, `{"error":{"type":"parse_exception","reason":%q},"status":400}`, err)
+			return
+		}
+		api.items = append(api.items, items)
+
+		type result struct {
+			ID     string          `json:"_id"`
+			Status int             `json:"status"`
+			Error  json.RawMessage `json:"error,omitempty"`
+		}
+		resp := struct {
+			Took   int                 `json:"took"`
+			Errors bool                `json:"errors"`
+			Items  []map[string]result `json:"items"`
+		}{Took: 3}
+		for _, item := range items {
+			res := result{ID: item.id, Status: http.StatusCreated}
+			if api.reject[item.doc.Port] {
+				resp.Errors = true
+				res.Status = http.StatusBadRequest
+				res.Error = json.RawMessage(`{"type":"mapper_parsing_exception","reason":"failed to parse field [fingerprint]"}`)
+			}
+			resp.Items = append(resp.Items, map[string]result{item.op: res})
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(api.Close)
+	return api
+}
+
+// bulkURL is the api's _bulk endpoint with credentials
+func (api *bulkAPI) bulkURL() string {
+	return strings.Replace(api.URL, "http://", "http://elastic:changeme@", 1) + "/_bulk"
+}
+
+func TestESBulkSinkPost(t *testing.T) {
+	api := newBulkAPI(t)
+	sink, err := NewESBulkSink(api.bulkURL(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Write(esResults(), esTime); err != nil {
+		t.Fatal(err)
+	}
+	if len(api.items) != 1 || len(api.items[0]) != 3 {
+		t.Fatalf("requests %v, want one with 3 documents", api.items)
+	}
+	if item := api.items[0][0]; item.index != DefaultESIndex || item.doc.IP != "10.0.0.1" || item.doc.Port != 80 {
+		t.Errorf("first item %+v", item)
+	}
+
+	// Nothing to send, nothing sent
+	if err := sink.Write(&ScanResults{}, esTime); err != nil || len(api.items) != 1 {
+		t.Errorf("empty batch: %v, %d requests", err, len(api.items))
+	}
+}
+
+func TestESBulkSinkErrors(t *testing.T) {
+	// Per-item failures come back with status 200
+	api := newBulkAPI(t, 53)
+	sink, _ := NewESBulkSink(api.bulkURL(), "scans")
+	err := sink.Write(esResults(), esTime)
+	id := ESDocumentID(esResults().ScanID.String(), "10.0.0.1", 53, "udp")
+	if err == nil || !strings.Contains(err.Error(), "rejected 1 of 3") || !strings.Contains(err.Error(), id) || !strings.Contains(err.Error(), "mapper_parsing_exception") {
+		t.Errorf("partial failure: %v", err)
+	}
+
+	// Wrong credentials
+	sink, _ = NewESBulkSink(api.URL+"/_bulk", "scans")
+	if err := sink.Write(esResults(), esTime); err == nil || !strings.Contains(err.Error(), "status 401") {
+		t.Errorf("unauthorized: %v", err)
+	}
+}
+
+func TestESBulkSinkFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.ndjson")
+	sink, err := NewESBulkSink("file://"+path, "scans")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A batch sent twice appends twice, under the same IDs
+	for i := 0; i < 2; i++ {
+		if err := sink.Write(esResults(), esTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	body, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, err := parseBulk(body)
+	if err != nil {
+		t.Fatalf("bulk parser rejected the file: %v", err)
+	}
+	if len(items) != 6 {
+		t.Fatalf("%d documents, want 3 per write", len(items))
+	}
+	for i := 0; i < 3; i++ {
+		if items[i].id != items[i+3].id || !reflect.DeepEqual(items[i].doc, items[i+3].doc) {
+			t.Errorf("resent document %d differs: %s vs %s", i, items[i].id, items[i+3].id)
+		}
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("file mode %v, %v", info.Mode(), err)
+	}
+}
+
+func TestNewESBulkSink(t *testing.T) {
+	sink, err := NewESBulkSink("https://es.example.com:9200/_bulk", "")
+	if err != nil || sink.URL == "" || sink.Path != "" || sink.Index != DefaultESIndex || sink.HTTPClient == nil {
+		t.Errorf("https sink = %+v, %v", sink, err)
+	}
+	for _, bad := range []string{"es.example.com:9200", "ftp://es.example.com/_bulk", "file://", "://"} {
+		if _, err := NewESBulkSink(bad, ""); err == nil {
+			t.Errorf("NewESBulkSink(%q) succeeded", bad)
+		}
+	}
+}
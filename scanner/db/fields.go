@@ -0,0 +1,103 @@
+package db
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// FieldFilter strips ScanResultPort fields that aren't on an allowlist
+// before submission. Fields the API requires (those serialized without
+// omitempty: port_number, protocol, state) are always kept. Fingerprint
+// keys starting with "_", such as _raw_zgrab, hold bulky debugging data and
+// are kept only when listed as "fingerprint_data.<key>", even when all of
+// fingerprint_data is.
+type FieldFilter struct {
+	keep            map[string]bool // JSON field names
+	fingerprintKeys map[string]bool // "fingerprint_data.<key>" entries
+}
+
+// NewFieldFilter builds a filter from JSON field names of ScanResultPort.
+// "fingerprint_data.<key>" keeps just that key of fingerprint_data.
+func NewFieldFilter(fields []string) (*FieldFilter, error) {
+	f := &FieldFilter{keep: make(map[string]bool), fingerprintKeys: make(map[string]bool)}
+	optional := optionalPortFields()
+	for _, field := range fields {
+		if key, ok := strings.CutPrefix(field, "fingerprint_data."); ok && key != "" {
+			f.fingerprintKeys[key] = true
+			continue
+		}
+		if _, ok := optional[field]; !ok {
+			if requiredPortField(field) {
+				continue // always kept
+			}
+			return nil, fmt.Errorf("unknown result field %q", field)
+		}
+		f.keep[field] = true
+	}
+	return f, nil
+}
+
+// Apply returns port with only the allowed fields set
+func (f *FieldFilter) Apply(port ScanResultPort) ScanResultPort {
+	fingerprint := port.FingerprintData
+	v := reflect.ValueOf(&port).Elem()
+	for name, index := range optionalPortFields() {
+		if !f.keep[name] {
+			field := v.Field(index)
+			field.Set(reflect.Zero(field.Type()))
+		}
+	}
+
+	if f.keep["fingerprint_data"] || len(f.fingerprintKeys) > 0 {
+		var data map[string]interface{}
+		for key, value := range fingerprint {
+			if f.fingerprintKeys[key] || f.keep["fingerprint_data"] && !strings.HasPrefix(key, "_") {
+				if data == nil {
+					data = make(map[string]interface{})
+				}
+				data[key] = value
+			}
+		}
+		port.FingerprintData = data
+	}
+	return port
+}
+
+// ApplyHosts filters every port of hosts into new host entries
+func (f *FieldFilter) ApplyHosts(hosts []ScanResultHost) []ScanResultHost {
+	filtered := make([]ScanResultHost, len(hosts))
+	for i, host := range hosts {
+		ports := make([]ScanResultPort, len(host.Ports))
+		for j, port := range host.Ports {
+			ports[j] = f.Apply(port)
+		}
+		host.Ports = ports
+		filtered[i] = host
+	}
+	return filtered
+}
+
+// optionalPortFields maps the JSON names of ScanResultPort's omitempty
+// fields to their struct index
+func optionalPortFields() map[string]int {
+	fields := make(map[string]int)
+	t := reflect.TypeOf(ScanResultPort{})
+	for i := 0; i < t.NumField(); i++ {
+		name, opts, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if strings.Contains(opts, "omitempty") {
+			fields[name] = i
+		}
+	}
+	return fields
+}
+
+func requiredPortField(name string) bool {
+	t := reflect.TypeOf(ScanResultPort{})
+	for i := 0; i < t.NumField(); i++ {
+		if tag, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ","); tag == name {
+			return true
+		}
+	}
+	return false
+}
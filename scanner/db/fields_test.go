@@ -0,0 +1,140 @@
+package db
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// fullPort has every ScanResultPort field set
+func fullPort() ScanResultPort {
+	return ScanResultPort{
+		PortNumber:     443,
+		Protocol:       "tcp",
+		State:          "open",
+		ServiceName:    "https",
+		ServiceVersion: "1.25.3",
+		Banner:         "HTTP/1.1 200 OK",
+		FingerprintData: map[string]interface{}{
+			"title":      "Welcome",
+			"headers":    map[string]interface{}{"Server": "nginx/1.25.3"},
+			"_raw_zgrab": "{...}",
+		},
+	}
+}
+
+// serializedFields marshals port and returns its top-level JSON keys, and
+// the fingerprint_data keys if any, sorted
+func serializedFields(t *testing.T, port ScanResultPort) ([]string, []string) {
+	t.Helper()
+	data, err := json.Marshal(port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	var fields, fingerprint []string
+	for key := range decoded {
+		fields = append(fields, key)
+	}
+	if fp, ok := decoded["fingerprint_data"].(map[string]interface{}); ok {
+		for key := range fp {
+			fingerprint = append(fingerprint, key)
+		}
+	}
+	sort.Strings(fields)
+	sort.Strings(fingerprint)
+	return fields, fingerprint
+}
+
+func TestFieldFilterSerialization(t *testing.T) {
+	tests := []struct {
+		allow       []string
+		fields      []string
+		fingerprint []string
+	}{
+		{
+			[]string{"service_name", "service_version", "banner"},
+			[]string{"banner", "port_number", "protocol", "service_name", "service_version", "state"},
+			nil,
+		},
+		{
+			[]string{"service_name"},
+			[]string{"port_number", "protocol", "service_name", "state"},
+			nil,
+		},
+		// Required fields may be listed and are kept either way
+		{
+			[]string{"port_number", "state"},
+			[]string{"port_number", "protocol", "state"},
+			nil,
+		},
+		// All of fingerprint_data still leaves out the "_" debugging keys
+		{
+			[]string{"fingerprint_data"},
+			[]string{"fingerprint_data", "port_number", "protocol", "state"},
+			[]string{"headers", "title"},
+		},
+		{
+			[]string{"service_name", "fingerprint_data.title"},
+			[]string{"fingerprint_data", "port_number", "protocol", "service_name", "state"},
+			[]string{"title"},
+		},
+		{
+			[]string{"fingerprint_data", "fingerprint_data._raw_zgrab"},
+			[]string{"fingerprint_data", "port_number", "protocol", "state"},
+			[]string{"_raw_zgrab", "headers", "title"},
+		},
+		// A listed key the port doesn't have leaves fingerprint_data out
+		{
+			[]string{"fingerprint_data.cert_subject"},
+			[]string{"port_number", "protocol", "state"},
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		filter, err := NewFieldFilter(tt.allow)
+		if err != nil {
+			t.Fatalf("%v: %v", tt.allow, err)
+		}
+		original := fullPort()
+		fields, fingerprint := serializedFields(t, filter.Apply(original))
+		if !reflect.DeepEqual(fields, tt.fields) || !reflect.DeepEqual(fingerprint, tt.fingerprint) {
+			t.Errorf("%v: serialized %v with fingerprint_data %v, want %v with %v", tt.allow, fields, fingerprint, tt.fields, tt.fingerprint)
+		}
+		if !reflect.DeepEqual(original, fullPort()) {
+			t.Errorf("%v: Apply modified the original port", tt.allow)
+		}
+	}
+}
+
+func TestFieldFilterUnknownField(t *testing.T) {
+	for _, fields := range [][]string{{"service"}, {"ServiceName"}, {"banner", "hostname"}, {"fingerprint_data."}} {
+		if _, err := NewFieldFilter(fields); err == nil {
+			t.Errorf("NewFieldFilter(%v) accepted an unknown field", fields)
+		}
+	}
+}
+
+func TestFieldFilterApplyHosts(t *testing.T) {
+	filter, err := NewFieldFilter([]string{"service_name"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts := []ScanResultHost{{IPAddress: "10.0.0.1", Hostname: "web", Ports: []ScanResultPort{fullPort(), fullPort()}}}
+	filtered := filter.ApplyHosts(hosts)
+	if len(filtered) != 1 || filtered[0].Hostname != "web" || len(filtered[0].Ports) != 2 {
+		t.Fatalf("ApplyHosts = %+v, want the host with both ports", filtered)
+	}
+	for _, port := range filtered[0].Ports {
+		if port.ServiceName != "https" || port.Banner != "" || port.FingerprintData != nil {
+			t.Errorf("filtered port = %+v, want only service_name kept", port)
+		}
+	}
+	if hosts[0].Ports[0].Banner == "" {
+		t.Error("ApplyHosts modified the original hosts")
+	}
+}
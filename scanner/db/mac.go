@@ -0,0 +1,78 @@
+package db
+
+import (
+	"sort"
+	"sync"
+)
+
+// MACChange is an IP whose MAC address differs from the one last seen
+type MACChange struct {
+	IP     string `json:"ip_address"`
+	OldMAC string `json:"old"`
+	NewMAC string `json:"new"`
+}
+
+// MACTracker remembers each IP's MAC address across scans to flag IPs that
+// moved to different hardware, a sign of ARP spoofing or address takeover.
+// IPs without a MAC in a scan keep the one last seen.
+type MACTracker struct {
+	mu       sync.Mutex
+	baseline map[string]string // IP -> MAC as of the previous scans
+	current  map[string]string
+	changes  map[string]MACChange
+}
+
+// NewMACTracker creates a MACTracker with no history
+func NewMACTracker() *MACTracker {
+	return &MACTracker{
+		baseline: make(map[string]string),
+		current:  make(map[string]string),
+		changes:  make(map[string]MACChange),
+	}
+}
+
+// BeginScan starts a new scan's observations
+func (t *MACTracker) BeginScan() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.current = make(map[string]string)
+	t.changes = make(map[string]MACChange)
+}
+
+// Observe records ip's MAC in this scan and returns the change from the
+// previous scans, if any. A first-seen IP or an empty mac is never a change.
+func (t *MACTracker) Observe(ip, mac string) (MACChange, bool) {
+	if mac == "" {
+		return MACChange{}, false
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.current[ip] = mac
+	old, seen := t.baseline[ip]
+	if !seen || old == mac {
+		return MACChange{}, false
+	}
+	change := MACChange{IP: ip, OldMAC: old, NewMAC: mac}
+	t.changes[ip] = change
+	return change, true
+}
+
+// EndScan merges this scan's MACs into the history and returns the changes
+// it found, sorted by IP
+func (t *MACTracker) EndScan() []MACChange {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for ip, mac := range t.current {
+		t.baseline[ip] = mac
+	}
+	t.current = make(map[string]string)
+
+	changes := make([]MACChange, 0, len(t.changes))
+	for _, change := range t.changes {
+		changes = append(changes, change)
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].IP < changes[j].IP })
+	return changes
+}
@@ -0,0 +1,61 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMACTrackerTwoScans(t *testing.T) {
+	tracker := NewMACTracker()
+
+	// First scan: every IP is first seen, so nothing changes
+	tracker.BeginScan()
+	for ip, mac := range map[string]string{
+		"10.0.0.1": "aa:aa:aa:aa:aa:01",
+		"10.0.0.2": "aa:aa:aa:aa:aa:02",
+		"10.0.0.3": "aa:aa:aa:aa:aa:03",
+	} {
+		if change, changed := tracker.Observe(ip, mac); changed {
+			t.Errorf("first scan flagged %+v", change)
+		}
+	}
+	if changes := tracker.EndScan(); len(changes) != 0 {
+		t.Errorf("first scan changes = %+v, want none", changes)
+	}
+
+	// Second scan: 10.0.0.2 flips, 10.0.0.3 has no MAC this time, and
+	// 10.0.0.4 is new
+	tracker.BeginScan()
+	if _, changed := tracker.Observe("10.0.0.1", "aa:aa:aa:aa:aa:01"); changed {
+		t.Error("unchanged MAC flagged")
+	}
+	change, changed := tracker.Observe("10.0.0.2", "bb:bb:bb:bb:bb:02")
+	want := MACChange{IP: "10.0.0.2", OldMAC: "aa:aa:aa:aa:aa:02", NewMAC: "bb:bb:bb:bb:bb:02"}
+	if !changed || change != want {
+		t.Errorf("Observe(10.0.0.2) = %+v, %v, want %+v", change, changed, want)
+	}
+	// Seen on several ports in one scan, the change is reported once
+	tracker.Observe("10.0.0.2", "bb:bb:bb:bb:bb:02")
+	if _, changed := tracker.Observe("10.0.0.3", ""); changed {
+		t.Error("missing MAC flagged as a change")
+	}
+	if _, changed := tracker.Observe("10.0.0.4", "cc:cc:cc:cc:cc:04"); changed {
+		t.Error("first-seen IP flagged as a change")
+	}
+	if changes := tracker.EndScan(); !reflect.DeepEqual(changes, []MACChange{want}) {
+		t.Errorf("second scan changes = %+v, want %+v", changes, []MACChange{want})
+	}
+
+	// Third scan: the new MAC is now the baseline, and 10.0.0.3 kept the
+	// MAC it had before the scan that missed it
+	tracker.BeginScan()
+	if _, changed := tracker.Observe("10.0.0.2", "bb:bb:bb:bb:bb:02"); changed {
+		t.Error("MAC flagged again after becoming the baseline")
+	}
+	if change, changed := tracker.Observe("10.0.0.3", "dd:dd:dd:dd:dd:03"); !changed || change.OldMAC != "aa:aa:aa:aa:aa:03" {
+		t.Errorf("Observe(10.0.0.3) = %+v, %v, want a change from aa:aa:aa:aa:aa:03", change, changed)
+	}
+	if changes := tracker.EndScan(); len(changes) != 1 || changes[0].IP != "10.0.0.3" {
+		t.Errorf("third scan changes = %+v, want only 10.0.0.3", changes)
+	}
+}
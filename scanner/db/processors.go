@@ -0,0 +1,50 @@
+package db
+
+import "fmt"
+
+// ResultProcessor enriches or rewrites a batch of results before they are
+// recorded and submitted
+type ResultProcessor interface {
+	Process(results *ScanResults) error
+}
+
+// ResultProcessorFunc adapts a function to ResultProcessor
+type ResultProcessorFunc func(results *ScanResults) error
+
+// Process calls f(results)
+func (f ResultProcessorFunc) Process(results *ScanResults) error {
+	return f(results)
+}
+
+// ProcessorChain runs named processors in the order they were added
+type ProcessorChain struct {
+	names      []string
+	processors []ResultProcessor
+}
+
+// Add appends p to the chain under name, used in its errors
+func (c *ProcessorChain) Add(name string, p ResultProcessor) {
+	c.names = append(c.names, name)
+	c.processors = append(c.processors, p)
+}
+
+// Names returns the processors' names in run order
+func (c *ProcessorChain) Names() []string {
+	return append([]string(nil), c.names...)
+}
+
+// Len returns the number of processors in the chain
+func (c *ProcessorChain) Len() int {
+	return len(c.processors)
+}
+
+// Process runs each processor over results in turn. The first error stops
+// the chain, so later processors never see a half-processed batch.
+func (c *ProcessorChain) Process(results *ScanResults) error {
+	for i, p := range c.processors {
+		if err := p.Process(results); err != nil {
+			return fmt.Errorf("result processor %s: %w", c.names[i], err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package db
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// recorder is a processor that appends its name to ran and to every
+// host's tags in the batch, failing with err (nil: succeeds)
+func recorder(name string, ran *[]string, err error) ResultProcessor {
+	return ResultProcessorFunc(func(results *ScanResults) error {
+		*ran = append(*ran, name)
+		if err != nil {
+			return err
+		}
+		results.Tags["seen"] += name
+		return nil
+	})
+}
+
+func TestProcessorChainOrder(t *testing.T) {
+	var ran []string
+	chain := &ProcessorChain{}
+	for _, name := range []string{"a", "b", "c"} {
+		chain.Add(name, recorder(name, &ran, nil))
+	}
+
+	results := &ScanResults{Tags: map[string]string{}}
+	if err := chain.Process(results); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a", "b", "c"}; !reflect.DeepEqual(ran, want) || !reflect.DeepEqual(chain.Names(), want) {
+		t.Errorf("ran %v, names %v, want %v", ran, chain.Names(), want)
+	}
+	// Each processor sees the batch as the one before left it
+	if results.Tags["seen"] != "abc" || chain.Len() != 3 {
+		t.Errorf("seen %q with %d processors, want \"abc\" and 3", results.Tags["seen"], chain.Len())
+	}
+}
+
+func TestProcessorChainStopsAtError(t *testing.T) {
+	var ran []string
+	failed := errors.New("lookup failed")
+	chain := &ProcessorChain{}
+	chain.Add("a", recorder("a", &ran, nil))
+	chain.Add("b", recorder("b", &ran, failed))
+	chain.Add("c", recorder("c", &ran, nil))
+
+	results := &ScanResults{Tags: map[string]string{}}
+	err := chain.Process(results)
+	if !errors.Is(err, failed) || !strings.Contains(err.Error(), "result processor b") {
+		t.Errorf("Process = %v, want the error wrapped with processor b's name", err)
+	}
+	if want := []string{"a", "b"}; !reflect.DeepEqual(ran, want) {
+		t.Errorf("ran %v, want %v with c never run", ran, want)
+	}
+}
+
+func TestProcessorChainEmpty(t *testing.T) {
+	chain := &ProcessorChain{}
+	results := &ScanResults{Hosts: []ScanResultHost{{IPAddress: "10.0.0.1"}}}
+	if err := chain.Process(results); err != nil || chain.Len() != 0 || len(chain.Names()) != 0 {
+		t.Errorf("empty chain: %v, %d processors, names %v", err, chain.Len(), chain.Names())
+	}
+}
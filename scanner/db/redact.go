@@ -0,0 +1,75 @@
+package db
+
+import "regexp"
+
+// DefaultRedaction replaces matches of a rule without its own replacement
+const DefaultRedaction = "[REDACTED]"
+
+// RedactionRule replaces every match of Pattern with Replace, which may
+// refer to submatches as $1 or ${name}
+type RedactionRule struct {
+	Pattern *regexp.Regexp
+	Replace string
+}
+
+// Redactor applies redaction rules, in order, to the banner and the string
+// fingerprint values of each port
+type Redactor struct {
+	rules []RedactionRule
+}
+
+// NewRedactor creates a Redactor; an empty Replace uses DefaultRedaction
+func NewRedactor(rules []RedactionRule) *Redactor {
+	r := &Redactor{}
+	for _, rule := range rules {
+		if rule.Replace == "" {
+			rule.Replace = DefaultRedaction
+		}
+		r.rules = append(r.rules, rule)
+	}
+	return r
+}
+
+// Redact returns s with every rule applied
+func (r *Redactor) Redact(s string) string {
+	for _, rule := range r.rules {
+		s = rule.Pattern.ReplaceAllString(s, rule.Replace)
+	}
+	return s
+}
+
+// Process redacts every port of results in place
+func (r *Redactor) Process(results *ScanResults) error {
+	for _, host := range results.Hosts {
+		for i := range host.Ports {
+			port := &host.Ports[i]
+			port.Banner = r.Redact(port.Banner)
+			for key, value := range port.FingerprintData {
+				port.FingerprintData[key] = r.redactValue(value)
+			}
+		}
+	}
+	return nil
+}
+
+// redactValue redacts strings, including those nested in fingerprint maps
+// and lists
+func (r *Redactor) redactValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		return r.Redact(v)
+	case map[string]interface{}:
+		for key, inner := range v {
+			v[key] = r.redactValue(inner)
+		}
+	case []interface{}:
+		for i, inner := range v {
+			v[i] = r.redactValue(inner)
+		}
+	case []string:
+		for i, inner := range v {
+			v[i] = r.Redact(inner)
+		}
+	}
+	return value
+}
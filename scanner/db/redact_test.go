@@ -0,0 +1,70 @@
+package db
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+// internalRedactor hides corp.internal hostnames and the user after
+// "login:", keeping the label
+func internalRedactor() *Redactor {
+	return NewRedactor([]RedactionRule{
+		{Pattern: regexp.MustCompile(`\b[a-z0-9-]+(\.[a-z0-9-]+)*\.corp\.internal\b`)},
+		{Pattern: regexp.MustCompile(`(login:) *\w+`), Replace: "$1 [USER]"},
+	})
+}
+
+func TestRedact(t *testing.T) {
+	r := internalRedactor()
+	for banner, want := range map[string]string{
+		"220 mx01.dc2.corp.internal ESMTP Postfix":     "220 [REDACTED] ESMTP Postfix",
+		"SSH-2.0-OpenSSH_9.6 build-host.corp.internal": "SSH-2.0-OpenSSH_9.6 [REDACTED]",
+		"last login: jsmith from vpn.corp.internal":    "last login: [USER] from [REDACTED]",
+		"220 mail.example.com ESMTP":                   "220 mail.example.com ESMTP",
+		// Only whole names under the internal domain
+		"corp.internal.example.com": "corp.internal.example.com",
+	} {
+		if got := r.Redact(banner); got != want {
+			t.Errorf("Redact(%q) = %q, want %q", banner, got, want)
+		}
+	}
+}
+
+func TestRedactorProcess(t *testing.T) {
+	results := &ScanResults{Hosts: []ScanResultHost{{IPAddress: "10.0.0.1", Hostname: "mx01.corp.internal", Ports: []ScanResultPort{{
+		PortNumber: 25,
+		Banner:     "220 mx01.corp.internal ESMTP",
+		FingerprintData: map[string]interface{}{
+			"ehlo":      "mx01.corp.internal Hello",
+			"size":      10240000,
+			"tls":       map[string]interface{}{"cert_cn": "mx01.corp.internal", "self_signed": true},
+			"san":       []interface{}{"mx01.corp.internal", "mail.example.com"},
+			"relays":    []string{"relay.corp.internal"},
+			"motd_user": "login: jsmith",
+		},
+	}}}}}
+	if err := internalRedactor().Process(results); err != nil {
+		t.Fatal(err)
+	}
+
+	port := results.Hosts[0].Ports[0]
+	if port.Banner != "220 [REDACTED] ESMTP" {
+		t.Errorf("banner = %q", port.Banner)
+	}
+	want := map[string]interface{}{
+		"ehlo":      "[REDACTED] Hello",
+		"size":      10240000,
+		"tls":       map[string]interface{}{"cert_cn": "[REDACTED]", "self_signed": true},
+		"san":       []interface{}{"[REDACTED]", "mail.example.com"},
+		"relays":    []string{"[REDACTED]"},
+		"motd_user": "login: [USER]",
+	}
+	if !reflect.DeepEqual(port.FingerprintData, want) {
+		t.Errorf("fingerprint data = %v, want %v", port.FingerprintData, want)
+	}
+	// Host names come from the operator's targets or DNS, not the banner
+	if results.Hosts[0].Hostname != "mx01.corp.internal" {
+		t.Errorf("hostname = %q, want it left alone", results.Hosts[0].Hostname)
+	}
+}
@@ -0,0 +1,86 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/google/uuid"
+)
+
+// S3PutObjectAPI is the part of the S3 client S3Sink uses
+type S3PutObjectAPI interface {
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+}
+
+// S3Sink writes each scan's results to an S3-compatible bucket as one JSON
+// object, instead of submitting them to the API
+type S3Sink struct {
+	Bucket  string
+	Client  S3PutObjectAPI
+	HMACKey []byte // signs the results when set, as for API submissions
+}
+
+// NewS3Sink creates a sink for bucket. Credentials and region come from the
+// standard AWS sources (AWS_ACCESS_KEY_ID, AWS_REGION, shared config,
+// instance roles). endpoint, when set, points at an S3-compatible store
+// such as MinIO, addressed path-style.
+func NewS3Sink(ctx context.Context, bucket, endpoint string) (*S3Sink, error) {
+	cfg, err := awsconfig.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS configuration: %w", err)
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1" // MinIO and most S3-compatible stores ignore it
+	}
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+			o.UsePathStyle = true
+		}
+	})
+	return &S3Sink{Bucket: bucket, Client: client}, nil
+}
+
+// S3ObjectKey names the object for a scan finished at scanTime, e.g.
+// "scans/20261014T120000Z-<scan id>.json", so keys sort by time
+func S3ObjectKey(scanID uuid.UUID, scanTime time.Time) string {
+	return fmt.Sprintf("scans/%s-%s.json", scanTime.UTC().Format("20060102T150405Z"), scanID)
+}
+
+// WriteScan stores every host in spool as one ScanResults object, with the
+// scan ID, tags and times of header, and returns its key
+func (s *S3Sink) WriteScan(ctx context.Context, header ScanResults, spool *Spool) (string, error) {
+	results := &header
+	results.Hosts = []ScanResultHost{}
+	if err := spool.Each(func(host ScanResultHost) error {
+		results.Hosts = append(results.Hosts, host)
+		return nil
+	}); err != nil {
+		return "", err
+	}
+	if err := results.Sign(s.HMACKey); err != nil {
+		return "", err
+	}
+	body, err := json.Marshal(results)
+	if err != nil {
+		return "", err
+	}
+
+	key := S3ObjectKey(results.ScanID, results.CompletedAt)
+	_, err = s.Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.Bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/json"),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to write s3://%s/%s: %w", s.Bucket, key, err)
+	}
+	return key, nil
+}
@@ -0,0 +1,146 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/google/uuid"
+)
+
+// fakeS3 records the objects put to it, or fails every put with err
+type fakeS3 struct {
+	puts []*s3.PutObjectInput
+	body [][]byte
+	err  error
+}
+
+func (f *fakeS3) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	body, err := io.ReadAll(params.Body)
+	if err != nil {
+		return nil, err
+	}
+	f.puts = append(f.puts, params)
+	f.body = append(f.body, body)
+	return &s3.PutObjectOutput{}, nil
+}
+
+func TestS3ObjectKey(t *testing.T) {
+	id := uuid.MustParse("6f1c2a4e-8a53-4d6e-9c1b-2f0e5d7a9b31")
+	at := time.Date(2026, 10, 14, 14, 5, 9, 0, time.FixedZone("CEST", 2*3600))
+	if got, want := S3ObjectKey(id, at), "scans/20261014T120509Z-6f1c2a4e-8a53-4d6e-9c1b-2f0e5d7a9b31.json"; got != want {
+		t.Errorf("S3ObjectKey = %q, want %q", got, want)
+	}
+}
+
+func TestS3SinkWritesScan(t *testing.T) {
+	full := signedResults()
+	header := *full
+	header.Hosts = nil
+	hosts := append(full.Hosts, ScanResultHost{IPAddress: "10.0.0.2", Ports: []ScanResultPort{{PortNumber: 22, Protocol: "tcp", State: "open"}}})
+
+	client := &fakeS3{}
+	sink := &S3Sink{Bucket: "scan-results", Client: client, HMACKey: testKey}
+	key, err := sink.WriteScan(context.Background(), header, spoolOf(t, hosts))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantKey := "scans/20261014T090130Z-6f1c2a4e-8a53-4d6e-9c1b-2f0e5d7a9b31.json"
+	if key != wantKey || len(client.puts) != 1 {
+		t.Fatalf("key %q after %d puts, want %q once", key, len(client.puts), wantKey)
+	}
+	put := client.puts[0]
+	if aws.ToString(put.Bucket) != "scan-results" || aws.ToString(put.Key) != wantKey || aws.ToString(put.ContentType) != "application/json" {
+		t.Errorf("put %s/%s as %s", aws.ToString(put.Bucket), aws.ToString(put.Key), aws.ToString(put.ContentType))
+	}
+
+	var stored ScanResults
+	if err := json.Unmarshal(client.body[0], &stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.ScanID != header.ScanID || !reflect.DeepEqual(stored.Tags, header.Tags) || !stored.CompletedAt.Equal(header.CompletedAt) {
+		t.Errorf("stored header = %+v, want the scan's", stored)
+	}
+	if len(stored.Hosts) != 2 || stored.Hosts[0].IPAddress != "10.0.0.1" || stored.Hosts[1].IPAddress != "10.0.0.2" {
+		t.Errorf("stored hosts = %+v, want both spooled hosts", stored.Hosts)
+	}
+	if ok, err := stored.Verify(testKey); !ok || err != nil {
+		t.Errorf("stored signature does not verify: %v, %v", ok, err)
+	}
+}
+
+func TestS3SinkEmptyScan(t *testing.T) {
+	client := &fakeS3{}
+	sink := &S3Sink{Bucket: "scan-results", Client: client}
+	if _, err := sink.WriteScan(context.Background(), ScanResults{ScanID: uuid.New(), CompletedAt: time.Now()}, spoolOf(t, nil)); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(client.body[0]), `"hosts":[]`) {
+		t.Errorf("empty scan stored as %s, want an empty hosts list", client.body[0])
+	}
+}
+
+func TestS3SinkPutFails(t *testing.T) {
+	sink := &S3Sink{Bucket: "scan-results", Client: &fakeS3{err: errors.New("AccessDenied")}}
+	_, err := sink.WriteScan(context.Background(), ScanResults{ScanID: uuid.New(), CompletedAt: time.Now()}, spoolOf(t, nil))
+	if err == nil || !strings.Contains(err.Error(), "s3://scan-results/scans/") || !strings.Contains(err.Error(), "AccessDenied") {
+		t.Errorf("WriteScan = %v, want the object and cause", err)
+	}
+}
+
+func TestS3SinkEndpoint(t *testing.T) {
+	// A local stand-in for MinIO: path-style PUTs, credentials from the
+	// environment
+	type request struct {
+		method, path, auth string
+		body               []byte
+	}
+	requests := make(chan request, 1)
+	store := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- request{r.Method, r.URL.Path, r.Header.Get("Authorization"), body}
+		w.Header().Set("ETag", `"d41d8cd98f00b204e9800998ecf8427e"`)
+	}))
+	defer store.Close()
+	t.Setenv("AWS_ACCESS_KEY_ID", "minioadmin")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "minioadmin")
+	t.Setenv("AWS_REGION", "")
+	t.Setenv("AWS_CONFIG_FILE", "/dev/null")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", "/dev/null")
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+
+	sink, err := NewS3Sink(context.Background(), "scan-results", store.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := ScanResults{ScanID: uuid.MustParse("6f1c2a4e-8a53-4d6e-9c1b-2f0e5d7a9b31"), CompletedAt: time.Date(2026, 10, 14, 9, 0, 0, 0, time.UTC)}
+	hosts := []ScanResultHost{{IPAddress: "10.0.0.1", Ports: []ScanResultPort{{PortNumber: 22, Protocol: "tcp", State: "open"}}}}
+	key, err := sink.WriteScan(context.Background(), header, spoolOf(t, hosts))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := <-requests
+	if req.method != http.MethodPut || req.path != "/scan-results/"+key {
+		t.Errorf("%s %s, want a path-style PUT of %s", req.method, req.path, key)
+	}
+	if !strings.Contains(req.auth, "Credential=minioadmin/") || !strings.Contains(req.auth, "/us-east-1/s3/") {
+		t.Errorf("Authorization = %q, want the environment's key signed for us-east-1", req.auth)
+	}
+	if !strings.Contains(string(req.body), `"ip_address":"10.0.0.1"`) {
+		t.Errorf("body = %s, want the scan's hosts", req.body)
+	}
+}
@@ -0,0 +1,84 @@
+package db
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// CanonicalJSON serializes results with every object's keys sorted, no
+// insignificant whitespace, no HTML escaping and the signature fields
+// omitted. This is the byte string PayloadSHA256 and Signature cover; the
+// API rebuilds it with json.dumps(..., sort_keys=True,
+// separators=(",", ":"), ensure_ascii=False).
+func CanonicalJSON(results *ScanResults) ([]byte, error) {
+	unsigned := *results
+	unsigned.PayloadSHA256 = ""
+	unsigned.Signature = ""
+
+	data, err := json.Marshal(&unsigned)
+	if err != nil {
+		return nil, err
+	}
+
+	// Round-trip through generic values: maps are encoded with sorted keys,
+	// which struct fields are not. UseNumber keeps numbers as written.
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var generic interface{}
+	if err := dec.Decode(&generic); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(generic); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// Sign sets PayloadSHA256 over the canonical JSON and, when key is
+// non-empty, Signature to its hex HMAC-SHA256 under key
+func (r *ScanResults) Sign(key []byte) error {
+	payload, err := CanonicalJSON(r)
+	if err != nil {
+		return fmt.Errorf("failed to canonicalize results: %w", err)
+	}
+
+	sum := sha256.Sum256(payload)
+	r.PayloadSHA256 = hex.EncodeToString(sum[:])
+	r.Signature = ""
+	if len(key) > 0 {
+		r.Signature = payloadHMAC(key, payload)
+	}
+	return nil
+}
+
+// Verify reports whether PayloadSHA256, and Signature when key is
+// non-empty, match the current contents of r
+func (r *ScanResults) Verify(key []byte) (bool, error) {
+	payload, err := CanonicalJSON(r)
+	if err != nil {
+		return false, fmt.Errorf("failed to canonicalize results: %w", err)
+	}
+
+	sum := sha256.Sum256(payload)
+	if !hmac.Equal([]byte(r.PayloadSHA256), []byte(hex.EncodeToString(sum[:]))) {
+		return false, nil
+	}
+	if len(key) > 0 && !hmac.Equal([]byte(r.Signature), []byte(payloadHMAC(key, payload))) {
+		return false, nil
+	}
+	return true, nil
+}
+
+func payloadHMAC(key, payload []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
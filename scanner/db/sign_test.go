@@ -0,0 +1,154 @@
+package db
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var testKey = []byte("result-hmac-key")
+
+// signedResults returns results with every field populated
+func signedResults() *ScanResults {
+	results := &ScanResults{
+		ScanID: uuid.MustParse("6f1c2a4e-8a53-4d6e-9c1b-2f0e5d7a9b31"),
+		Tags:   map[string]string{"team": "netops", "env": "prod"},
+		Hosts: []ScanResultHost{{
+			IPAddress:     "10.0.0.1",
+			AddressFamily: "ipv4",
+			Hostname:      "gw.example",
+			MACAddress:    "00:11:22:33:44:55",
+			Ports: []ScanResultPort{{
+				PortNumber:      443,
+				Protocol:        "tcp",
+				State:           "open",
+				ServiceName:     "https",
+				ServiceVersion:  "nginx/1.24.0",
+				Banner:          "HTTP/1.1 200 OK",
+				FingerprintData: map[string]interface{}{"title": "<Login> & more", "status_code": "200"},
+			}},
+		}},
+	}
+	started := time.Date(2026, 10, 14, 9, 0, 0, 0, time.UTC)
+	results.SetTimes(started, started.Add(90*time.Second), time.Hour)
+	return results
+}
+
+func TestSignVerifies(t *testing.T) {
+	for _, key := range [][]byte{nil, testKey} {
+		results := signedResults()
+		if err := results.Sign(key); err != nil {
+			t.Fatal(err)
+		}
+		if len(results.PayloadSHA256) != 64 || (key == nil) != (results.Signature == "") {
+			t.Errorf("key %q: sha256 %q, signature %q", key, results.PayloadSHA256, results.Signature)
+		}
+		if ok, err := results.Verify(key); !ok || err != nil {
+			t.Errorf("key %q: Verify = %v, %v", key, ok, err)
+		}
+	}
+
+	// The wrong key fails; a signature made without a key can't pass for one
+	results := signedResults()
+	results.Sign(testKey)
+	if ok, _ := results.Verify([]byte("other-key")); ok {
+		t.Error("verified under the wrong key")
+	}
+	results.Sign(nil)
+	if ok, _ := results.Verify(testKey); ok {
+		t.Error("unsigned results verified under a key")
+	}
+}
+
+func TestSignChangesWithEveryField(t *testing.T) {
+	base := signedResults()
+	base.Sign(testKey)
+
+	for name, change := range map[string]func(r *ScanResults){
+		"scan id":         func(r *ScanResults) { r.ScanID = uuid.New() },
+		"tag":             func(r *ScanResults) { r.Tags["env"] = "dev" },
+		"started at":      func(r *ScanResults) { r.StartedAt = r.StartedAt.Add(time.Microsecond) },
+		"completed at":    func(r *ScanResults) { r.CompletedAt = r.CompletedAt.Add(time.Second) },
+		"expires at":      func(r *ScanResults) { r.ExpiresAt = nil },
+		"ip":              func(r *ScanResults) { r.Hosts[0].IPAddress = "10.0.0.2" },
+		"address family":  func(r *ScanResults) { r.Hosts[0].AddressFamily = "ipv6" },
+		"hostname":        func(r *ScanResults) { r.Hosts[0].Hostname = "other.example" },
+		"mac":             func(r *ScanResults) { r.Hosts[0].MACAddress = "00:11:22:33:44:56" },
+		"port":            func(r *ScanResults) { r.Hosts[0].Ports[0].PortNumber = 8443 },
+		"protocol":        func(r *ScanResults) { r.Hosts[0].Ports[0].Protocol = "udp" },
+		"state":           func(r *ScanResults) { r.Hosts[0].Ports[0].State = "closed" },
+		"service":         func(r *ScanResults) { r.Hosts[0].Ports[0].ServiceName = "http" },
+		"version":         func(r *ScanResults) { r.Hosts[0].Ports[0].ServiceVersion = "nginx/1.25.0" },
+		"banner":          func(r *ScanResults) { r.Hosts[0].Ports[0].Banner = "HTTP/1.1 302 Found" },
+		"fingerprint":     func(r *ScanResults) { r.Hosts[0].Ports[0].FingerprintData["title"] = "Login" },
+		"fingerprint key": func(r *ScanResults) { r.Hosts[0].Ports[0].FingerprintData["cdn"] = "cloudflare" },
+		"extra host":      func(r *ScanResults) { r.Hosts = append(r.Hosts, ScanResultHost{IPAddress: "10.0.0.3"}) },
+	} {
+		results := signedResults()
+		results.Sign(testKey)
+		change(results)
+		if ok, _ := results.Verify(testKey); ok {
+			t.Errorf("%s: tampered results still verify", name)
+		}
+		results.Sign(testKey)
+		if results.PayloadSHA256 == base.PayloadSHA256 || results.Signature == base.Signature {
+			t.Errorf("%s: re-signing gave the same hash or signature", name)
+		}
+	}
+}
+
+func TestCanonicalJSON(t *testing.T) {
+	results := &ScanResults{
+		ScanID: uuid.MustParse("6f1c2a4e-8a53-4d6e-9c1b-2f0e5d7a9b31"),
+		Tags:   map[string]string{"z": "1", "a": "<&>"},
+		Hosts: []ScanResultHost{{IPAddress: "10.0.0.1", Ports: []ScanResultPort{{
+			PortNumber: 22, Protocol: "tcp", State: "open",
+			FingerprintData: map[string]interface{}{"connect_ms": 1.5, "b": "é"},
+		}}}},
+		PayloadSHA256: "stale",
+		Signature:     "stale",
+	}
+	got, err := CanonicalJSON(results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Sorted keys, no whitespace, no HTML or non-ASCII escaping, and no
+	// signature fields: what the API's json.dumps produces
+	want := `{"completed_at":"0001-01-01T00:00:00Z","hosts":[{"ip_address":"10.0.0.1","ports":[{"fingerprint_data":{"b":"é","connect_ms":1.5},` +
+		`"port_number":22,"protocol":"tcp","state":"open"}]}],"scan_id":"6f1c2a4e-8a53-4d6e-9c1b-2f0e5d7a9b31",` +
+		`"started_at":"0001-01-01T00:00:00Z","tags":{"a":"<&>","z":"1"}}`
+	if string(got) != want {
+		t.Errorf("CanonicalJSON =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestSubmitResultsSigns(t *testing.T) {
+	received := make(chan []byte, 1)
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- body
+	}))
+	defer api.Close()
+
+	client := NewAPIClient(api.URL)
+	client.HMACKey = testKey
+	if err := client.SubmitResults(signedResults()); err != nil {
+		t.Fatal(err)
+	}
+
+	var submitted ScanResults
+	if err := json.Unmarshal(<-received, &submitted); err != nil {
+		t.Fatal(err)
+	}
+	if submitted.Signature == "" {
+		t.Fatal("submission carries no signature")
+	}
+	if ok, err := submitted.Verify(testKey); !ok || err != nil {
+		t.Errorf("submitted payload does not verify: %v, %v", ok, err)
+	}
+}
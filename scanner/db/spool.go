@@ -0,0 +1,130 @@
+package db
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// Spool collects host results in memory up to a limit and appends the rest
+// to a temporary NDJSON file, so a pathological scan can't exhaust memory.
+// Each replays every record in the order it was added.
+type Spool struct {
+	dir   string
+	limit int
+
+	mu      sync.Mutex
+	mem     []ScanResultHost
+	file    *os.File
+	writer  *bufio.Writer
+	spilled int
+}
+
+// NewSpool creates a spool keeping up to limit records in memory. Overflow
+// goes to a temp file in dir ("" for the system temp directory).
+func NewSpool(dir string, limit int) *Spool {
+	return &Spool{dir: dir, limit: limit}
+}
+
+// Add appends host
+func (s *Spool) Add(host ScanResultHost) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.mem) < s.limit {
+		s.mem = append(s.mem, host)
+		return nil
+	}
+
+	if s.file == nil {
+		file, err := os.CreateTemp(s.dir, "scan-results-*.ndjson")
+		if err != nil {
+			return fmt.Errorf("failed to create spill file: %w", err)
+		}
+		s.file = file
+		s.writer = bufio.NewWriter(file)
+	}
+
+	data, err := json.Marshal(host)
+	if err != nil {
+		return fmt.Errorf("failed to marshal spilled result: %w", err)
+	}
+	data = append(data, '\n')
+	if _, err := s.writer.Write(data); err != nil {
+		return fmt.Errorf("failed to write spill file: %w", err)
+	}
+	s.spilled++
+	return nil
+}
+
+// Len returns the number of records added
+func (s *Spool) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.mem) + s.spilled
+}
+
+// Spilled returns how many records live on disk
+func (s *Spool) Spilled() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.spilled
+}
+
+// Each calls fn with every record in insertion order, reading spilled
+// records back from disk. It stops at the first error fn returns.
+func (s *Spool) Each(fn func(ScanResultHost) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, host := range s.mem {
+		if err := fn(host); err != nil {
+			return err
+		}
+	}
+	if s.file == nil {
+		return nil
+	}
+
+	if err := s.writer.Flush(); err != nil {
+		return fmt.Errorf("failed to flush spill file: %w", err)
+	}
+	file, err := os.Open(s.file.Name())
+	if err != nil {
+		return fmt.Errorf("failed to read spill file: %w", err)
+	}
+	defer file.Close()
+
+	dec := json.NewDecoder(bufio.NewReader(file))
+	for {
+		var host ScanResultHost
+		if err := dec.Decode(&host); errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to read spill file: %w", err)
+		}
+		if err := fn(host); err != nil {
+			return err
+		}
+	}
+}
+
+// Close discards the records and removes the spill file
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.mem = nil
+	s.spilled = 0
+	if s.file == nil {
+		return nil
+	}
+	name := s.file.Name()
+	s.file.Close()
+	s.file, s.writer = nil, nil
+	return os.Remove(name)
+}
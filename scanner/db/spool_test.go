@@ -0,0 +1,160 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// spoolHosts returns n distinct hosts with fingerprint data that survives
+// a JSON round trip unchanged
+func spoolHosts(n int) []ScanResultHost {
+	hosts := make([]ScanResultHost, n)
+	for i := range hosts {
+		hosts[i] = ScanResultHost{
+			IPAddress:     fmt.Sprintf("10.%d.%d.%d", i>>16&0xff, i>>8&0xff, i&0xff),
+			AddressFamily: "ipv4",
+			Ports: []ScanResultPort{{
+				PortNumber:      22,
+				Protocol:        "tcp",
+				State:           "open",
+				ServiceName:     "ssh",
+				Banner:          "SSH-2.0-OpenSSH_9.6",
+				FingerprintData: map[string]interface{}{"connect_ms": float64(i) / 4, "host_key": map[string]interface{}{"sha256": fmt.Sprint(i)}},
+			}},
+		}
+	}
+	return hosts
+}
+
+// collect reads every record out of spool
+func collect(t *testing.T, spool *Spool) []ScanResultHost {
+	t.Helper()
+	var got []ScanResultHost
+	if err := spool.Each(func(host ScanResultHost) error {
+		got = append(got, host)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+// spillFiles lists the spill files in dir
+func spillFiles(t *testing.T, dir string) []os.DirEntry {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func TestSpoolSpillsWithoutLoss(t *testing.T) {
+	for _, limit := range []int{0, 1, 10, 5000, 10000} {
+		dir := t.TempDir()
+		spool := NewSpool(dir, limit)
+		hosts := spoolHosts(5000)
+		for _, host := range hosts {
+			if err := spool.Add(host); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		wantSpilled := max(len(hosts)-limit, 0)
+		if spool.Len() != len(hosts) || spool.Spilled() != wantSpilled {
+			t.Errorf("limit %d: Len %d, Spilled %d, want %d and %d", limit, spool.Len(), spool.Spilled(), len(hosts), wantSpilled)
+		}
+		if files := spillFiles(t, dir); (len(files) == 1) != (wantSpilled > 0) {
+			t.Errorf("limit %d: %d spill files", limit, len(files))
+		}
+		if got := collect(t, spool); !reflect.DeepEqual(got, hosts) {
+			t.Errorf("limit %d: read back %d records, not the %d added in order", limit, len(got), len(hosts))
+		}
+
+		if err := spool.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if files := spillFiles(t, dir); len(files) != 0 {
+			t.Errorf("limit %d: Close left %d spill files", limit, len(files))
+		}
+		if spool.Len() != 0 {
+			t.Errorf("limit %d: Len after Close = %d", limit, spool.Len())
+		}
+	}
+}
+
+func TestSpoolAddAfterEach(t *testing.T) {
+	spool := NewSpool(t.TempDir(), 2)
+	defer spool.Close()
+	hosts := spoolHosts(10)
+	for _, host := range hosts[:5] {
+		spool.Add(host)
+	}
+	collect(t, spool)
+	for _, host := range hosts[5:] {
+		spool.Add(host)
+	}
+	// Reading twice gives the same records, including those added between
+	for i := 0; i < 2; i++ {
+		if got := collect(t, spool); !reflect.DeepEqual(got, hosts) {
+			t.Errorf("read %d: got %d records, want all %d in order", i, len(got), len(hosts))
+		}
+	}
+}
+
+func TestSpoolConcurrentAdds(t *testing.T) {
+	spool := NewSpool(t.TempDir(), 50)
+	defer spool.Close()
+	hosts := spoolHosts(2000)
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := w; i < len(hosts); i += 8 {
+				if err := spool.Add(hosts[i]); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	seen := make(map[string]bool)
+	for _, host := range collect(t, spool) {
+		if seen[host.IPAddress] {
+			t.Errorf("%s read twice", host.IPAddress)
+		}
+		seen[host.IPAddress] = true
+	}
+	if len(seen) != len(hosts) {
+		t.Errorf("read %d distinct hosts, want %d", len(seen), len(hosts))
+	}
+}
+
+func TestSpoolEachStopsAtError(t *testing.T) {
+	spool := NewSpool(t.TempDir(), 3)
+	defer spool.Close()
+	for _, host := range spoolHosts(10) {
+		spool.Add(host)
+	}
+	stop := errors.New("stop")
+	for _, at := range []int{2, 7} {
+		calls := 0
+		err := spool.Each(func(ScanResultHost) error {
+			calls++
+			if calls == at {
+				return stop
+			}
+			return nil
+		})
+		if !errors.Is(err, stop) || calls != at {
+			t.Errorf("stopping at %d: %d calls, %v", at, calls, err)
+		}
+	}
+}
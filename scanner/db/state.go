@@ -0,0 +1,294 @@
+package db
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StateFile reads and writes the scan state file: the open endpoints of the
+// last completed scan as a JSON array of hosts, each with the time it was
+// last seen. Gzip-compressed and plain files are both read; Compress picks
+// the form written. With a Retention, hosts a full scan no longer finds are
+// kept until they have been missing that long.
+type StateFile struct {
+	Path      string
+	Compress  bool
+	Retention time.Duration // 0 keeps only the latest scan's hosts
+}
+
+// stateHost is a host as stored in the state file. Files written before
+// last_seen existed load with a zero LastSeen, which never expires.
+type stateHost struct {
+	ScanResultHost
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// Load reads the state file, leaving out hosts past the retention. A
+// missing file is an empty snapshot, not an error.
+func (f *StateFile) Load() (Snapshot, error) {
+	hosts, err := f.read()
+	if err != nil {
+		return nil, err
+	}
+	snap := make(Snapshot)
+	for _, host := range hosts {
+		snap.Add(host.ScanResultHost)
+	}
+	return snap, nil
+}
+
+// Save replaces the state with snap, all of it seen now
+func (f *StateFile) Save(snap Snapshot) error {
+	now := time.Now().UTC()
+	hosts := snap.HostResults()
+	return f.write(func(w io.Writer) error {
+		data, err := json.MarshalIndent(stampHosts(hosts, now), "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal state: %w", err)
+		}
+		_, err = w.Write(data)
+		return err
+	})
+}
+
+// SaveSpool replaces the state with a completed scan's results, streaming
+// them so spilled records never all sit in memory. With a Retention, hosts
+// of the previous state that the scan didn't find are carried over with
+// their old last-seen time until it expires.
+func (f *StateFile) SaveSpool(spool *Spool) error {
+	now := time.Now().UTC()
+
+	var carried []stateHost
+	if f.Retention > 0 {
+		previous, err := f.read()
+		if err != nil {
+			return err
+		}
+		found := make(map[string]bool)
+		spool.Each(func(host ScanResultHost) error {
+			found[host.IPAddress] = true
+			return nil
+		})
+		for _, host := range previous {
+			if !found[host.IPAddress] {
+				carried = append(carried, host)
+			}
+		}
+	}
+
+	return f.write(func(w io.Writer) error {
+		if _, err := io.WriteString(w, "["); err != nil {
+			return err
+		}
+		sep := "\n"
+		writeHost := func(host stateHost) error {
+			data, err := json.Marshal(host)
+			if err != nil {
+				return err
+			}
+			if _, err := io.WriteString(w, sep); err != nil {
+				return err
+			}
+			sep = ",\n"
+			_, err = w.Write(data)
+			return err
+		}
+		err := spool.Each(func(host ScanResultHost) error {
+			return writeHost(stateHost{ScanResultHost: host, LastSeen: now})
+		})
+		if err != nil {
+			return err
+		}
+		for _, host := range carried {
+			if err := writeHost(host); err != nil {
+				return err
+			}
+		}
+		_, err = io.WriteString(w, "\n]\n")
+		return err
+	})
+}
+
+// read loads the state file's hosts, compressed or not, dropping those
+// past the retention
+func (f *StateFile) read() ([]stateHost, error) {
+	file, err := os.Open(f.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+	defer file.Close()
+
+	var r io.Reader = bufio.NewReader(file)
+	if magic, _ := r.(*bufio.Reader).Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read state file %s: %w", f.Path, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	var hosts []stateHost
+	if err := json.NewDecoder(r).Decode(&hosts); err != nil {
+		return nil, fmt.Errorf("failed to parse state file %s: %w", f.Path, err)
+	}
+	if f.Retention <= 0 {
+		return hosts, nil
+	}
+
+	cutoff := time.Now().Add(-f.Retention)
+	kept := hosts[:0]
+	for _, host := range hosts {
+		if host.LastSeen.IsZero() || host.LastSeen.After(cutoff) {
+			kept = append(kept, host)
+		}
+	}
+	if pruned := len(hosts) - len(kept); pruned > 0 {
+		log.Printf("State file: pruned %d hosts not seen in %.0f days", pruned, f.Retention.Hours()/24)
+	}
+	return kept, nil
+}
+
+// write replaces the state file atomically with what write produces, so a
+// crash never leaves a truncated state file
+func (f *StateFile) write(write func(w io.Writer) error) error {
+	if !f.Compress {
+		return writeStateFile(f.Path, write)
+	}
+	return writeStateFile(f.Path, func(w io.Writer) error {
+		gz := gzip.NewWriter(w)
+		if err := write(gz); err != nil {
+			return err
+		}
+		return gz.Close()
+	})
+}
+
+// Cycle reads how many quick verify runs have completed since the last full
+// sweep, kept beside the state file in Path+".cycle". A missing count is 0.
+func (f *StateFile) Cycle() (int, error) {
+	data, err := os.ReadFile(f.cyclePath())
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read scan cycle: %w", err)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("failed to parse scan cycle %s: %q", f.cyclePath(), strings.TrimSpace(string(data)))
+	}
+	return n, nil
+}
+
+// SaveCycle records n quick verify runs since the last full sweep
+func (f *StateFile) SaveCycle(n int) error {
+	return writeStateFile(f.cyclePath(), func(w io.Writer) error {
+		_, err := fmt.Fprintf(w, "%d\n", n)
+		return err
+	})
+}
+
+func (f *StateFile) cyclePath() string {
+	return f.Path + ".cycle"
+}
+
+// Schedule reads the cron schedule set at runtime, kept beside the state
+// file in Path+".schedule", or "" when none was set
+func (f *StateFile) Schedule() (string, error) {
+	data, err := os.ReadFile(f.schedulePath())
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read schedule: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// SaveSchedule records a cron schedule set at runtime
+func (f *StateFile) SaveSchedule(spec string) error {
+	return writeStateFile(f.schedulePath(), func(w io.Writer) error {
+		_, err := fmt.Fprintln(w, spec)
+		return err
+	})
+}
+
+func (f *StateFile) schedulePath() string {
+	return f.Path + ".schedule"
+}
+
+func stampHosts(hosts []ScanResultHost, seen time.Time) []stateHost {
+	stamped := make([]stateHost, len(hosts))
+	for i, host := range hosts {
+		stamped[i] = stateHost{ScanResultHost: host, LastSeen: seen}
+	}
+	return stamped
+}
+
+// writeStateFile replaces path atomically with what write produces
+func writeStateFile(path string, write func(w io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	buf := bufio.NewWriter(tmp)
+	if err := write(buf); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err := buf.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	return nil
+}
+
+// HostResults regroups the snapshot into one ScanResultHost per IP, with
+// hosts and ports in a stable order
+func (s Snapshot) HostResults() []ScanResultHost {
+	byIP := make(map[string]*ScanResultHost)
+	for key, port := range s {
+		host, ok := byIP[key.IP]
+		if !ok {
+			host = &ScanResultHost{IPAddress: key.IP, AddressFamily: AddressFamily(key.IP)}
+			byIP[key.IP] = host
+		}
+		host.Ports = append(host.Ports, port)
+	}
+
+	hosts := make([]ScanResultHost, 0, len(byIP))
+	for _, ip := range s.Hosts() {
+		host := byIP[ip]
+		sort.Slice(host.Ports, func(i, j int) bool {
+			if host.Ports[i].PortNumber != host.Ports[j].PortNumber {
+				return host.Ports[i].PortNumber < host.Ports[j].PortNumber
+			}
+			return host.Ports[i].Protocol < host.Ports[j].Protocol
+		})
+		hosts = append(hosts, *host)
+	}
+	return hosts
+}
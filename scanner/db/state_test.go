@@ -0,0 +1,227 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// stateHosts is a small scan result set
+func stateHosts() []ScanResultHost {
+	return []ScanResultHost{
+		{IPAddress: "10.0.0.1", AddressFamily: "ipv4", Ports: []ScanResultPort{
+			{PortNumber: 22, Protocol: "tcp", State: "open", ServiceName: "ssh", ServiceVersion: "OpenSSH_9.6"},
+			{PortNumber: 443, Protocol: "tcp", State: "open", ServiceName: "https", FingerprintData: map[string]interface{}{"title": "Welcome"}},
+		}},
+		{IPAddress: "10.0.0.2", AddressFamily: "ipv4", Ports: []ScanResultPort{{PortNumber: 53, Protocol: "udp", State: "open", ServiceName: "dns"}}},
+	}
+}
+
+// snapshotOf builds the snapshot of hosts
+func snapshotOf(hosts []ScanResultHost) Snapshot {
+	snap := make(Snapshot)
+	for _, host := range hosts {
+		snap.Add(host)
+	}
+	return snap
+}
+
+// spoolOf holds hosts in a spool
+func spoolOf(t *testing.T, hosts []ScanResultHost) *Spool {
+	t.Helper()
+	spool := NewSpool(t.TempDir(), 1)
+	t.Cleanup(func() { spool.Close() })
+	for _, host := range hosts {
+		if err := spool.Add(host); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return spool
+}
+
+func isGzip(t *testing.T, path string) bool {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.HasPrefix(data, []byte{0x1f, 0x8b})
+}
+
+func TestStateFileCompressedRoundTrip(t *testing.T) {
+	want := snapshotOf(stateHosts())
+	for _, compress := range []bool{true, false} {
+		path := filepath.Join(t.TempDir(), "state.json")
+		written := &StateFile{Path: path, Compress: compress}
+
+		for name, save := range map[string]func() error{
+			"Save":      func() error { return written.Save(want) },
+			"SaveSpool": func() error { return written.SaveSpool(spoolOf(t, stateHosts())) },
+		} {
+			if err := save(); err != nil {
+				t.Fatalf("compress %v: %s: %v", compress, name, err)
+			}
+			if got := isGzip(t, path); got != compress {
+				t.Errorf("compress %v: %s wrote gzip = %v", compress, name, got)
+			}
+			// Either form loads whatever Compress is set to
+			for _, readCompressed := range []bool{true, false} {
+				snap, err := (&StateFile{Path: path, Compress: readCompressed}).Load()
+				if err != nil {
+					t.Fatalf("compress %v: %s: Load: %v", compress, name, err)
+				}
+				if !reflect.DeepEqual(snap, want) {
+					t.Errorf("compress %v: %s: loaded %v, want %v", compress, name, snap, want)
+				}
+			}
+		}
+
+		// Only the state file is left; the temporary file was renamed
+		entries, _ := os.ReadDir(filepath.Dir(path))
+		if len(entries) != 1 {
+			t.Errorf("compress %v: directory holds %d files, want only the state file", compress, len(entries))
+		}
+	}
+}
+
+func TestStateFileMissing(t *testing.T) {
+	snap, err := (&StateFile{Path: filepath.Join(t.TempDir(), "none.json")}).Load()
+	if err != nil || len(snap) != 0 {
+		t.Errorf("Load of a missing file = %v, %v, want an empty snapshot", snap, err)
+	}
+}
+
+func TestStateFileCycle(t *testing.T) {
+	f := &StateFile{Path: filepath.Join(t.TempDir(), "state.json")}
+	if n, err := f.Cycle(); n != 0 || err != nil {
+		t.Errorf("Cycle with no count = %d, %v, want 0", n, err)
+	}
+	if err := f.SaveCycle(2); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := f.Cycle(); n != 2 || err != nil {
+		t.Errorf("Cycle = %d, %v, want 2", n, err)
+	}
+	for _, bad := range []string{"two\n", "-1\n"} {
+		if err := os.WriteFile(f.Path+".cycle", []byte(bad), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Cycle(); err == nil {
+			t.Errorf("Cycle of %q succeeded", bad)
+		}
+	}
+}
+
+// writeStamped writes a plain state file with each host last seen at the
+// given time
+func writeStamped(t *testing.T, path string, seen map[string]time.Time) {
+	t.Helper()
+	var hosts []stateHost
+	for _, host := range stateHosts() {
+		hosts = append(hosts, stateHost{ScanResultHost: host, LastSeen: seen[host.IPAddress]})
+	}
+	hosts = append(hosts, stateHost{ScanResultHost: ScanResultHost{IPAddress: "10.0.0.3", Ports: []ScanResultPort{{PortNumber: 80, Protocol: "tcp", State: "open"}}}, LastSeen: seen["10.0.0.3"]})
+	data, err := json.Marshal(hosts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStateFilePrunesPastRetention(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	now := time.Now().UTC()
+	writeStamped(t, path, map[string]time.Time{
+		"10.0.0.1": now.Add(-time.Hour),
+		"10.0.0.2": now.Add(-40 * 24 * time.Hour),
+		// 10.0.0.3 predates last_seen and never expires
+	})
+
+	state := &StateFile{Path: path, Retention: 30 * 24 * time.Hour}
+	snap, err := state.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := snap.Hosts(); !reflect.DeepEqual(got, []string{"10.0.0.1", "10.0.0.3"}) {
+		t.Errorf("loaded hosts %v, want 10.0.0.2 pruned", got)
+	}
+
+	// Without a retention nothing is pruned
+	snap, err = (&StateFile{Path: path}).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snap.Hosts()) != 3 {
+		t.Errorf("loaded hosts %v without a retention, want all three", snap.Hosts())
+	}
+}
+
+func TestStateFileSaveSpoolCarriesMissingHosts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	old := time.Now().UTC().Add(-10 * 24 * time.Hour)
+	expired := time.Now().UTC().Add(-40 * 24 * time.Hour)
+	writeStamped(t, path, map[string]time.Time{"10.0.0.1": old, "10.0.0.2": old, "10.0.0.3": expired})
+
+	// This scan only finds 10.0.0.1; 10.0.0.2 is carried with its old
+	// last-seen time, and 10.0.0.3 has been missing past the retention
+	state := &StateFile{Path: path, Compress: true, Retention: 30 * 24 * time.Hour}
+	if err := state.SaveSpool(spoolOf(t, stateHosts()[:1])); err != nil {
+		t.Fatal(err)
+	}
+	hosts, err := state.read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]time.Time)
+	for _, host := range hosts {
+		seen[host.IPAddress] = host.LastSeen
+	}
+	if len(seen) != 2 || !seen["10.0.0.2"].Equal(old) || time.Since(seen["10.0.0.1"]) > time.Minute {
+		t.Errorf("saved last-seen times %v, want 10.0.0.1 now and 10.0.0.2 carried from %v", seen, old)
+	}
+
+	// Once 10.0.0.2 has been missing past the retention it is dropped
+	state.Retention = 5 * 24 * time.Hour
+	if err := state.SaveSpool(spoolOf(t, stateHosts()[:1])); err != nil {
+		t.Fatal(err)
+	}
+	snap, err := state.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := snap.Hosts(); !reflect.DeepEqual(got, []string{"10.0.0.1"}) {
+		t.Errorf("hosts after the carried one expired = %v, want only 10.0.0.1", got)
+	}
+}
+
+func TestStateFileFailedWriteKeepsOldState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	state := &StateFile{Path: path, Compress: true}
+	if err := state.Save(snapshotOf(stateHosts())); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := os.ReadFile(path)
+
+	failed := errors.New("disk full")
+	err := state.write(func(w io.Writer) error {
+		io.WriteString(w, "[{\"ip_address\": \"10.0.0.9\"")
+		return failed
+	})
+	if !errors.Is(err, failed) {
+		t.Errorf("write = %v, want the writer's error", err)
+	}
+	if after, _ := os.ReadFile(path); !bytes.Equal(after, before) {
+		t.Error("a failed write changed the state file")
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+		t.Errorf("directory holds %d files after a failed write, want 1", len(entries))
+	}
+}
@@ -0,0 +1,217 @@
+package db
+
+import (
+	"encoding/json"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// STIX 2.1 export. Each scanned host becomes one observed-data object whose
+// object_refs point at cyber-observable objects in the same bundle:
+//
+//	host IP address      -> ipv4-addr / ipv6-addr (value)
+//	hostname             -> domain-name (value, resolves_to_refs: the IP)
+//	MAC address          -> mac-addr (value)
+//	each open port       -> network-traffic (dst_ref: the IP, dst_port,
+//	                        protocols: ["ipv4"|"ipv6", "tcp"|"udp"] plus the
+//	                        service name when known)
+//	each identified port -> x-network-service, a custom object (name,
+//	                        version, banner, fingerprint, traffic_ref: the
+//	                        network-traffic)
+//
+// Observable IDs are deterministic (UUIDv5 over the ID contributing
+// properties, per the spec), so the same endpoint keeps its ID across
+// exports and consumers can deduplicate. observed-data IDs are random.
+
+// stixNamespace is the UUIDv5 namespace STIX defines for observable IDs
+var stixNamespace = uuid.MustParse("00abedb4-aa42-466c-9c01-fed23315a9b7")
+
+// STIXBundle is a STIX 2.1 bundle
+type STIXBundle struct {
+	Type    string        `json:"type"`
+	ID      string        `json:"id"`
+	Objects []interface{} `json:"objects"`
+}
+
+// stixObservedData is the observed-data SDO for one host
+type stixObservedData struct {
+	Type           string   `json:"type"`
+	SpecVersion    string   `json:"spec_version"`
+	ID             string   `json:"id"`
+	Created        string   `json:"created"`
+	Modified       string   `json:"modified"`
+	FirstObserved  string   `json:"first_observed"`
+	LastObserved   string   `json:"last_observed"`
+	NumberObserved int      `json:"number_observed"`
+	ObjectRefs     []string `json:"object_refs"`
+}
+
+// stixAddress is an ipv4-addr, ipv6-addr, mac-addr or domain-name SCO
+type stixAddress struct {
+	Type           string   `json:"type"`
+	SpecVersion    string   `json:"spec_version"`
+	ID             string   `json:"id"`
+	Value          string   `json:"value"`
+	ResolvesToRefs []string `json:"resolves_to_refs,omitempty"` // domain-name only
+}
+
+// stixNetworkTraffic is the network-traffic SCO for one open port
+type stixNetworkTraffic struct {
+	Type        string   `json:"type"`
+	SpecVersion string   `json:"spec_version"`
+	ID          string   `json:"id"`
+	DstRef      string   `json:"dst_ref"`
+	DstPort     int      `json:"dst_port"`
+	Protocols   []string `json:"protocols"`
+}
+
+// stixService is the custom x-network-service object for an identified
+// service
+type stixService struct {
+	Type        string                 `json:"type"`
+	SpecVersion string                 `json:"spec_version"`
+	ID          string                 `json:"id"`
+	Name        string                 `json:"name"`
+	Version     string                 `json:"version,omitempty"`
+	Banner      string                 `json:"banner,omitempty"`
+	Fingerprint map[string]interface{} `json:"fingerprint,omitempty"`
+	TrafficRef  string                 `json:"traffic_ref"`
+}
+
+// ExportSTIX converts hosts observed at observed into a STIX 2.1 bundle.
+// Entries for the same IP are merged into one observation.
+func ExportSTIX(hosts []ScanResultHost, observed time.Time) *STIXBundle {
+	timestamp := stixTimestamp(observed)
+	created := stixTimestamp(time.Now())
+	bundle := &STIXBundle{
+		Type:    "bundle",
+		ID:      "bundle--" + uuid.NewString(),
+		Objects: []interface{}{},
+	}
+
+	for _, host := range mergeHosts(hosts) {
+		ipType, ipVersion := "ipv4-addr", "ipv4"
+		if ip := net.ParseIP(host.IPAddress); ip != nil && ip.To4() == nil {
+			ipType, ipVersion = "ipv6-addr", "ipv6"
+		}
+		ipRef := stixAddressObject(ipType, host.IPAddress, nil)
+		bundle.Objects = append(bundle.Objects, ipRef)
+		refs := []string{ipRef.ID}
+
+		if host.Hostname != "" {
+			domain := stixAddressObject("domain-name", host.Hostname, []string{ipRef.ID})
+			bundle.Objects = append(bundle.Objects, domain)
+			refs = append(refs, domain.ID)
+		}
+		if host.MACAddress != "" {
+			mac := stixAddressObject("mac-addr", strings.ToLower(host.MACAddress), nil)
+			bundle.Objects = append(bundle.Objects, mac)
+			refs = append(refs, mac.ID)
+		}
+
+		for _, port := range host.Ports {
+			protocols := []string{ipVersion, strings.ToLower(port.Protocol)}
+			if port.ServiceName != "" && port.ServiceName != "unknown" {
+				protocols = append(protocols, strings.ToLower(port.ServiceName))
+			}
+			traffic := &stixNetworkTraffic{
+				Type: "network-traffic", SpecVersion: "2.1",
+				DstRef: ipRef.ID, DstPort: port.PortNumber, Protocols: protocols,
+			}
+			traffic.ID = stixID(traffic.Type, map[string]interface{}{
+				"dst_ref": traffic.DstRef, "dst_port": traffic.DstPort, "protocols": traffic.Protocols,
+			})
+			bundle.Objects = append(bundle.Objects, traffic)
+			refs = append(refs, traffic.ID)
+
+			if port.ServiceName == "" || port.ServiceName == "unknown" {
+				continue
+			}
+			service := &stixService{
+				Type: "x-network-service", SpecVersion: "2.1",
+				Name: port.ServiceName, Version: port.ServiceVersion, Banner: port.Banner,
+				Fingerprint: port.FingerprintData, TrafficRef: traffic.ID,
+			}
+			service.ID = stixID(service.Type, map[string]interface{}{
+				"traffic_ref": service.TrafficRef, "name": service.Name, "version": service.Version,
+			})
+			bundle.Objects = append(bundle.Objects, service)
+			refs = append(refs, service.ID)
+		}
+
+		bundle.Objects = append(bundle.Objects, &stixObservedData{
+			Type:           "observed-data",
+			SpecVersion:    "2.1",
+			ID:             "observed-data--" + uuid.NewString(),
+			Created:        created,
+			Modified:       created,
+			FirstObserved:  timestamp,
+			LastObserved:   timestamp,
+			NumberObserved: 1,
+			ObjectRefs:     refs,
+		})
+	}
+	return bundle
+}
+
+// stixTimestamp formats t as STIX requires: UTC with millisecond precision
+func stixTimestamp(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+func stixAddressObject(typ, value string, resolvesTo []string) *stixAddress {
+	return &stixAddress{
+		Type:           typ,
+		SpecVersion:    "2.1",
+		ID:             stixID(typ, map[string]interface{}{"value": value}),
+		Value:          value,
+		ResolvesToRefs: resolvesTo,
+	}
+}
+
+// stixID derives an observable's ID from its ID contributing properties,
+// serialized as JSON with sorted keys
+func stixID(typ string, contributing map[string]interface{}) string {
+	data, _ := json.Marshal(contributing) // maps marshal with sorted keys
+	return typ + "--" + uuid.NewSHA1(stixNamespace, data).String()
+}
+
+// mergeHosts groups entries by IP in IP order, keeping the first hostname
+// and MAC seen and ports ordered by number and protocol
+func mergeHosts(hosts []ScanResultHost) []ScanResultHost {
+	byIP := make(map[string]*ScanResultHost)
+	var ips []string
+	for _, host := range hosts {
+		merged, ok := byIP[host.IPAddress]
+		if !ok {
+			merged = &ScanResultHost{IPAddress: host.IPAddress, AddressFamily: host.AddressFamily}
+			byIP[host.IPAddress] = merged
+			ips = append(ips, host.IPAddress)
+		}
+		if merged.Hostname == "" {
+			merged.Hostname = host.Hostname
+		}
+		if merged.MACAddress == "" {
+			merged.MACAddress = host.MACAddress
+		}
+		merged.Ports = append(merged.Ports, host.Ports...)
+	}
+	sort.Strings(ips)
+
+	result := make([]ScanResultHost, 0, len(ips))
+	for _, ip := range ips {
+		host := byIP[ip]
+		sort.Slice(host.Ports, func(i, j int) bool {
+			if host.Ports[i].PortNumber != host.Ports[j].PortNumber {
+				return host.Ports[i].PortNumber < host.Ports[j].PortNumber
+			}
+			return host.Ports[i].Protocol < host.Ports[j].Protocol
+		})
+		result = append(result, *host)
+	}
+	return result
+}
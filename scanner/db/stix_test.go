@@ -0,0 +1,251 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// The rules ExportSTIX output is checked against, taken from the STIX 2.1
+// JSON schemas (common/core, observed-data and the SCO schemas used)
+var (
+	stixIdentifier = regexp.MustCompile(`^[a-z][a-z0-9-]+[a-z0-9]--[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[1-5][0-9a-fA-F]{3}-[89abAB][0-9a-fA-F]{3}-[0-9a-fA-F]{12}$`)
+	stixTime       = regexp.MustCompile(`^[0-9]{4}-[0-9]{2}-[0-9]{2}T[0-9]{2}:[0-9]{2}:[0-9]{2}(\.[0-9]+)?Z$`)
+	stixProperty   = regexp.MustCompile(`^[a-z0-9_]{3,250}$`)
+	stixRequired   = map[string][]string{
+		"observed-data":     {"type", "spec_version", "id", "created", "modified", "first_observed", "last_observed", "number_observed", "object_refs"},
+		"ipv4-addr":         {"type", "id", "value"},
+		"ipv6-addr":         {"type", "id", "value"},
+		"mac-addr":          {"type", "id", "value"},
+		"domain-name":       {"type", "id", "value"},
+		"network-traffic":   {"type", "id", "protocols"},
+		"x-network-service": {"type", "id"},
+	}
+	// Each reference property and the object types it may point at
+	stixRefTypes = map[string][]string{
+		"dst_ref":          {"ipv4-addr", "ipv6-addr", "mac-addr", "domain-name"},
+		"resolves_to_refs": {"ipv4-addr", "ipv6-addr", "domain-name"},
+		"traffic_ref":      {"network-traffic"},
+	}
+)
+
+// validateSTIX checks a serialized bundle against the schema rules and
+// returns every violation
+func validateSTIX(data []byte) []string {
+	var bundle struct {
+		Type    string                   `json:"type"`
+		ID      string                   `json:"id"`
+		Objects []map[string]interface{} `json:"objects"`
+	}
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		return []string{err.Error()}
+	}
+	var errs []string
+	fail := func(format string, args ...interface{}) { errs = append(errs, fmt.Sprintf(format, args...)) }
+
+	if bundle.Type != "bundle" || !strings.HasPrefix(bundle.ID, "bundle--") || !stixIdentifier.MatchString(bundle.ID) {
+		fail("bundle type %q id %q", bundle.Type, bundle.ID)
+	}
+	types := make(map[string]string)
+	for _, obj := range bundle.Objects {
+		id, _ := obj["id"].(string)
+		types[id] = obj["type"].(string)
+	}
+
+	for _, obj := range bundle.Objects {
+		typ, _ := obj["type"].(string)
+		id, _ := obj["id"].(string)
+		required, known := stixRequired[typ]
+		if !known {
+			fail("%s: unexpected type %q", id, typ)
+			continue
+		}
+		for _, property := range required {
+			if _, ok := obj[property]; !ok {
+				fail("%s: missing %s", id, property)
+			}
+		}
+		if !stixIdentifier.MatchString(id) || !strings.HasPrefix(id, typ+"--") {
+			fail("%s: id is not a %s identifier", id, typ)
+		}
+		if obj["spec_version"] != "2.1" {
+			fail("%s: spec_version %v", id, obj["spec_version"])
+		}
+		for property, value := range obj {
+			if property != "id" && !stixProperty.MatchString(property) { // id predates the length rule
+				fail("%s: property name %q", id, property)
+			}
+			if s, ok := value.(string); ok && s == "" {
+				fail("%s: empty %s", id, property)
+			}
+			if allowed, ok := stixRefTypes[property]; ok {
+				refs, _ := value.([]interface{})
+				if single, ok := value.(string); ok {
+					refs = []interface{}{single}
+				}
+				for _, ref := range refs {
+					if !contains(allowed, types[ref.(string)]) {
+						fail("%s: %s %v points at %q", id, property, ref, types[ref.(string)])
+					}
+				}
+			}
+		}
+
+		switch typ {
+		case "observed-data":
+			for _, property := range []string{"created", "modified", "first_observed", "last_observed"} {
+				if s, _ := obj[property].(string); !stixTime.MatchString(s) {
+					fail("%s: %s %q is not a STIX timestamp", id, property, s)
+				}
+			}
+			if obj["last_observed"].(string) < obj["first_observed"].(string) {
+				fail("%s: last_observed before first_observed", id)
+			}
+			if n, _ := obj["number_observed"].(float64); n < 1 || n > 999999999 {
+				fail("%s: number_observed %v", id, obj["number_observed"])
+			}
+			refs, _ := obj["object_refs"].([]interface{})
+			if len(refs) == 0 {
+				fail("%s: no object_refs", id)
+			}
+			for _, ref := range refs {
+				if _, ok := types[ref.(string)]; !ok || types[ref.(string)] == "observed-data" {
+					fail("%s: object_ref %v is not an observable in the bundle", id, ref)
+				}
+			}
+		case "network-traffic":
+			if _, ok := obj["src_ref"]; !ok {
+				if _, ok := obj["dst_ref"]; !ok {
+					fail("%s: neither src_ref nor dst_ref", id)
+				}
+			}
+			if port, _ := obj["dst_port"].(float64); port < 0 || port > 65535 {
+				fail("%s: dst_port %v", id, obj["dst_port"])
+			}
+			for _, protocol := range obj["protocols"].([]interface{}) {
+				if p := protocol.(string); p != strings.ToLower(p) {
+					fail("%s: protocol %q is not lowercase", id, p)
+				}
+			}
+		}
+	}
+	return errs
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// stixHosts is a small result set: a named, MAC-tagged IPv4 host seen in
+// two entries, and an IPv6 host with an unidentified UDP port
+func stixHosts() []ScanResultHost {
+	return []ScanResultHost{
+		{IPAddress: "10.0.0.5", Hostname: "web.example", MACAddress: "AA:BB:CC:00:11:22", Ports: []ScanResultPort{
+			{PortNumber: 443, Protocol: "tcp", State: "open", ServiceName: "https", ServiceVersion: "nginx/1.24.0",
+				FingerprintData: map[string]interface{}{"title": "Welcome"}},
+		}},
+		{IPAddress: "2001:db8::7", Ports: []ScanResultPort{{PortNumber: 5353, Protocol: "udp", State: "open", ServiceName: "unknown"}}},
+		{IPAddress: "10.0.0.5", Ports: []ScanResultPort{{PortNumber: 22, Protocol: "tcp", State: "open", ServiceName: "SSH", Banner: "SSH-2.0-OpenSSH_9.6"}}},
+	}
+}
+
+// objectsByType groups a bundle's objects by type
+func objectsByType(bundle *STIXBundle) map[string][]interface{} {
+	got := make(map[string][]interface{})
+	for _, obj := range bundle.Objects {
+		data, _ := json.Marshal(obj)
+		var header struct{ Type string }
+		json.Unmarshal(data, &header)
+		got[header.Type] = append(got[header.Type], obj)
+	}
+	return got
+}
+
+func TestExportSTIXValidates(t *testing.T) {
+	observed := time.Date(2026, 10, 14, 9, 30, 0, 0, time.FixedZone("CEST", 2*3600))
+	bundle := ExportSTIX(stixHosts(), observed)
+	data, err := json.Marshal(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, violation := range validateSTIX(data) {
+		t.Error(violation)
+	}
+
+	got := objectsByType(bundle)
+	counts := map[string]int{"observed-data": 2, "ipv4-addr": 1, "ipv6-addr": 1, "domain-name": 1, "mac-addr": 1, "network-traffic": 3, "x-network-service": 2}
+	for typ, want := range counts {
+		if len(got[typ]) != want {
+			t.Errorf("%d %s objects, want %d", len(got[typ]), typ, want)
+		}
+	}
+
+	observation := got["observed-data"][0].(*stixObservedData)
+	if observation.FirstObserved != "2026-10-14T07:30:00.000Z" || len(observation.ObjectRefs) != 7 {
+		t.Errorf("first observation = %+v, want 10.0.0.5 in UTC with seven refs", observation)
+	}
+	ssh := got["network-traffic"][0].(*stixNetworkTraffic)
+	if ssh.DstPort != 22 || strings.Join(ssh.Protocols, ",") != "ipv4,tcp,ssh" {
+		t.Errorf("first traffic = %+v, want 22 over ipv4,tcp,ssh", ssh)
+	}
+	mdns := got["network-traffic"][2].(*stixNetworkTraffic)
+	if mdns.DstPort != 5353 || strings.Join(mdns.Protocols, ",") != "ipv6,udp" {
+		t.Errorf("unidentified traffic = %+v, want 5353 over ipv6,udp", mdns)
+	}
+	if mac := got["mac-addr"][0].(*stixAddress); mac.Value != "aa:bb:cc:00:11:22" {
+		t.Errorf("mac-addr value %q, want lowercase", mac.Value)
+	}
+}
+
+func TestExportSTIXDeterministicIDs(t *testing.T) {
+	first := objectsByType(ExportSTIX(stixHosts(), time.Now()))
+	second := objectsByType(ExportSTIX(stixHosts(), time.Now().Add(time.Hour)))
+	for _, typ := range []string{"ipv4-addr", "network-traffic", "x-network-service"} {
+		for i := range first[typ] {
+			a, _ := json.Marshal(first[typ][i])
+			b, _ := json.Marshal(second[typ][i])
+			if string(a) != string(b) {
+				t.Errorf("%s differs across exports:\n%s\n%s", typ, a, b)
+			}
+		}
+	}
+	if first["observed-data"][0].(*stixObservedData).ID == second["observed-data"][0].(*stixObservedData).ID {
+		t.Error("observed-data IDs repeat across exports")
+	}
+
+	// The spec's own example: ipv4-addr 198.51.100.3
+	ip := stixAddressObject("ipv4-addr", "198.51.100.3", nil)
+	if want := "ipv4-addr--" + uuid.NewSHA1(stixNamespace, []byte(`{"value":"198.51.100.3"}`)).String(); ip.ID != want {
+		t.Errorf("ID = %s, want %s", ip.ID, want)
+	}
+}
+
+func TestExportSTIXEmpty(t *testing.T) {
+	data, _ := json.Marshal(ExportSTIX(nil, time.Now()))
+	if violations := validateSTIX(data); len(violations) != 0 {
+		t.Error(violations)
+	}
+	if !strings.Contains(string(data), `"objects":[]`) {
+		t.Errorf("empty bundle %s, want an empty objects list", data)
+	}
+}
+
+func TestValidateSTIXCatchesViolations(t *testing.T) {
+	bad := `{"type":"bundle","id":"bundle--6ba7b810-9dad-11d1-80b4-00c04fd430c8","objects":[
+		{"type":"observed-data","spec_version":"2.1","id":"observed-data--6ba7b810-9dad-11d1-80b4-00c04fd430c8","created":"yesterday","modified":"2026-10-14T07:30:00.000Z","first_observed":"2026-10-14T07:30:00.000Z","last_observed":"2026-10-14T07:30:00.000Z","number_observed":0,"object_refs":["ipv4-addr--00000000-0000-5000-8000-000000000000"]},
+		{"type":"network-traffic","spec_version":"2.1","id":"network-traffic--6ba7b810-9dad-11d1-80b4-00c04fd430c8","protocols":["TCP"]}
+	]}`
+	if violations := validateSTIX([]byte(bad)); len(violations) < 4 {
+		t.Errorf("violations = %q, want the timestamp, count, dangling ref, missing endpoint and case", violations)
+	}
+}
@@ -0,0 +1,37 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Webhook posts JSON events to a URL
+type Webhook struct {
+	URL        string
+	HTTPClient *http.Client
+}
+
+// NewWebhook creates a Webhook with a 10s request timeout
+func NewWebhook(url string) *Webhook {
+	return &Webhook{URL: url, HTTPClient: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Post sends event as a JSON body; any non-2xx response is an error
+func (w *Webhook) Post(event interface{}) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal webhook event: %w", err)
+	}
+	resp, err := w.HTTPClient.Post(w.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to post webhook: %w", err)
+	}
+	defer drainAndClose(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os/exec"
+	"strings"
+	"time"
+
+	"network-scanner/config"
+	"network-scanner/db"
+	"network-scanner/scanner"
+)
+
+// Diagnostic check outcomes
+const (
+	diagPass = "pass"
+	diagWarn = "warn" // degraded but scans still run
+	diagFail = "fail" // scans will fail or silently find nothing
+)
+
+type diagnosticCheck struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail"`
+}
+
+type diagnosticsReport struct {
+	OK     bool              `json:"ok"`
+	Checks []diagnosticCheck `json:"checks"`
+}
+
+// diagnostics checks the environment a scan depends on. The probe functions
+// are fields so each check can be exercised on its own.
+type diagnostics struct {
+	cfg *config.Config
+
+	lookPath     func(file string) (string, error)
+	version      func(path string) (string, error)
+	rawSocket    func() error
+	interfaceUp  func(name string) error
+	apiReachable func() error
+}
+
+// newDiagnostics creates diagnostics using the real system checks
+func newDiagnostics(cfg *config.Config, apiClient *db.APIClient) *diagnostics {
+	return &diagnostics{
+		cfg:          cfg,
+		lookPath:     exec.LookPath,
+		version:      binaryVersion,
+		rawSocket:    openRawSocket,
+		interfaceUp:  interfaceUp,
+		apiReachable: apiClient.HealthCheck,
+	}
+}
+
+// run performs every check. A report is OK when nothing failed.
+func (d *diagnostics) run() diagnosticsReport {
+	_, usesZmap := d.cfg.ModeNetworks()["zmap"]
+
+	var checks []diagnosticCheck
+	checks = append(checks, d.checkBinary("zmap", usesZmap))
+	// zgrab2 is always optional: native probes take over without it
+	checks = append(checks, d.checkBinary("zgrab2", false))
+	checks = append(checks, d.checkRawSocket(usesZmap))
+	if d.cfg.Interface != "" {
+		checks = append(checks, d.checkInterface(d.cfg.Interface))
+	}
+	checks = append(checks, d.checkAPI())
+
+	report := diagnosticsReport{OK: true, Checks: checks}
+	for _, c := range checks {
+		if c.Status == diagFail {
+			report.OK = false
+		}
+	}
+	return report
+}
+
+// checkBinary looks for name on PATH and records its version. A missing
+// binary fails when required and only warns otherwise.
+func (d *diagnostics) checkBinary(name string, required bool) diagnosticCheck {
+	check := diagnosticCheck{Name: name + " binary"}
+
+	path, err := d.lookPath(name)
+	if err != nil {
+		check.Status = diagWarn
+		check.Detail = "not found on PATH"
+		if required {
+			check.Status = diagFail
+			check.Detail += " (required by zmap scanner mode)"
+		}
+		return check
+	}
+
+	version, err := d.version(path)
+	if err != nil {
+		check.Status = diagFail
+		if !required {
+			check.Status = diagWarn
+		}
+		check.Detail = fmt.Sprintf("%s failed to run: %v", path, err)
+		return check
+	}
+
+	check.Status = diagPass
+	check.Detail = path
+	if version != "" {
+		check.Detail += " (" + version + ")"
+	}
+	return check
+}
+
+// toolVersion returns name's version string, "unknown" when it runs but
+// doesn't report one, or "not found"
+func (d *diagnostics) toolVersion(name string) string {
+	path, err := d.lookPath(name)
+	if err != nil {
+		return "not found"
+	}
+	version, err := d.version(path)
+	if err != nil || version == "" {
+		return "unknown"
+	}
+	return version
+}
+
+// checkRawSocket verifies raw socket privileges (CAP_NET_RAW), which zmap needs
+func (d *diagnostics) checkRawSocket(required bool) diagnosticCheck {
+	check := diagnosticCheck{Name: "raw socket privilege"}
+	if err := d.rawSocket(); err != nil {
+		check.Status = diagWarn
+		check.Detail = fmt.Sprintf("cannot open raw socket: %v", err)
+		if required {
+			check.Status = diagFail
+			check.Detail += " (zmap needs root or CAP_NET_RAW)"
+		}
+		return check
+	}
+	check.Status = diagPass
+	check.Detail = "raw sockets available"
+	return check
+}
+
+// checkInterface verifies the configured interface exists and is up
+func (d *diagnostics) checkInterface(name string) diagnosticCheck {
+	check := diagnosticCheck{Name: "interface " + name}
+	if err := d.interfaceUp(name); err != nil {
+		check.Status = diagFail
+		check.Detail = err.Error()
+		return check
+	}
+	check.Status = diagPass
+	check.Detail = "exists and is up"
+	return check
+}
+
+// checkAPI verifies the results API answers its health check
+func (d *diagnostics) checkAPI() diagnosticCheck {
+	check := diagnosticCheck{Name: "API " + d.cfg.APIURL}
+	if err := d.apiReachable(); err != nil {
+		check.Status = diagFail
+		check.Detail = err.Error()
+		return check
+	}
+	check.Status = diagPass
+	check.Detail = "reachable"
+	return check
+}
+
+// scanErrorHint suggests a fix for scan failures the environment causes, or
+// returns "" when there is none to give
+func scanErrorHint(err error) string {
+	switch {
+	case errors.Is(err, scanner.ErrBinaryNotFound):
+		return "install zmap and make sure it is on PATH, or set scanner_mode: tcp"
+	case errors.Is(err, scanner.ErrInsufficientPrivileges):
+		return "run the scanner as root or grant zmap raw socket access: setcap cap_net_raw,cap_net_admin=eip $(which zmap)"
+	case errors.Is(err, scanner.ErrNoTargets):
+		return "check networks and scope; every address was excluded or failed to parse"
+	}
+	return ""
+}
+
+// logReport prints the report in a pass/fail list
+func logReport(report diagnosticsReport) {
+	log.Printf("Startup diagnostics:")
+	for _, c := range report.Checks {
+		log.Printf("  [%s] %s: %s", strings.ToUpper(c.Status), c.Name, c.Detail)
+	}
+	if !report.OK {
+		log.Printf("Warning: diagnostics found problems; scans may fail or return no results")
+	}
+}
+
+// binaryVersion runs path --version and returns the first output line.
+// Builds without --version exit non-zero; that still counts as runnable.
+func binaryVersion(path string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, path, "--version").CombinedOutput()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return "", err
+		}
+	}
+	version, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+	return version, nil
+}
+
+// openRawSocket opens and closes a raw IP socket
+func openRawSocket() error {
+	conn, err := net.ListenPacket("ip4:icmp", "0.0.0.0")
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// interfaceUp reports an error unless the named interface exists and is up
+func interfaceUp(name string) error {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return err
+	}
+	if iface.Flags&net.FlagUp == 0 {
+		return fmt.Errorf("interface %s is down", name)
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"network-scanner/config"
+	"network-scanner/scanner"
+)
+
+// stubDiagnostics passes every check for cfg; tests break the ones they need
+func stubDiagnostics(cfg *config.Config) *diagnostics {
+	return &diagnostics{
+		cfg:          cfg,
+		lookPath:     func(file string) (string, error) { return "/usr/bin/" + file, nil },
+		version:      func(path string) (string, error) { return "v1.0", nil },
+		rawSocket:    func() error { return nil },
+		interfaceUp:  func(name string) error { return nil },
+		apiReachable: func() error { return nil },
+	}
+}
+
+// missing makes lookPath fail for binary
+func missing(binary string) func(d *diagnostics) {
+	return func(d *diagnostics) {
+		d.lookPath = func(file string) (string, error) {
+			if file == binary {
+				return "", errors.New("not found")
+			}
+			return "/usr/bin/" + file, nil
+		}
+	}
+}
+
+// zmapConfig is a config with one network scanned in mode
+func zmapConfig(mode string) *config.Config {
+	return &config.Config{
+		ScannerMode: mode,
+		Networks:    []config.Network{{CIDR: "10.0.0.0/24"}},
+		Interface:   "eth0",
+		APIURL:      "http://api:8000",
+	}
+}
+
+// statuses maps each check's name to its status
+func statuses(report diagnosticsReport) map[string]string {
+	got := make(map[string]string, len(report.Checks))
+	for _, c := range report.Checks {
+		got[c.Name] = c.Status
+	}
+	return got
+}
+
+func TestDiagnosticsAllPass(t *testing.T) {
+	report := stubDiagnostics(zmapConfig("zmap")).run()
+	if !report.OK || len(report.Checks) != 5 {
+		t.Fatalf("report = %+v, want five passing checks", report)
+	}
+	for _, c := range report.Checks {
+		if c.Status != diagPass {
+			t.Errorf("%s = %s: %s", c.Name, c.Status, c.Detail)
+		}
+	}
+	if report.Checks[0].Detail != "/usr/bin/zmap (v1.0)" {
+		t.Errorf("zmap detail = %q", report.Checks[0].Detail)
+	}
+}
+
+func TestDiagnosticsFailures(t *testing.T) {
+	tests := []struct {
+		name   string
+		mode   string
+		breaks func(d *diagnostics)
+		check  string
+		status string
+		ok     bool
+	}{
+		{"zmap missing in zmap mode", "zmap", missing("zmap"), "zmap binary", diagFail, false},
+		{"zmap missing in tcp mode", "tcp", missing("zmap"), "zmap binary", diagWarn, true},
+		{"zgrab2 missing", "zmap", missing("zgrab2"), "zgrab2 binary", diagWarn, true},
+		{"zmap fails to run", "zmap", func(d *diagnostics) {
+			d.version = func(path string) (string, error) {
+				if path == "/usr/bin/zmap" {
+					return "", errors.New("exec format error")
+				}
+				return "v1.0", nil
+			}
+		}, "zmap binary", diagFail, false},
+		{"no raw sockets for zmap", "zmap", func(d *diagnostics) {
+			d.rawSocket = func() error { return errors.New("operation not permitted") }
+		}, "raw socket privilege", diagFail, false},
+		{"no raw sockets for tcp", "tcp", func(d *diagnostics) {
+			d.rawSocket = func() error { return errors.New("operation not permitted") }
+		}, "raw socket privilege", diagWarn, true},
+		{"interface down", "tcp", func(d *diagnostics) {
+			d.interfaceUp = func(name string) error { return errors.New("interface " + name + " is down") }
+		}, "interface eth0", diagFail, false},
+		{"api unreachable", "tcp", func(d *diagnostics) {
+			d.apiReachable = func() error { return errors.New("connection refused") }
+		}, "API http://api:8000", diagFail, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := stubDiagnostics(zmapConfig(tt.mode))
+			tt.breaks(d)
+			report := d.run()
+			if report.OK != tt.ok {
+				t.Errorf("OK = %v, want %v", report.OK, tt.ok)
+			}
+			got := statuses(report)
+			if got[tt.check] != tt.status {
+				t.Errorf("%s = %q, want %q (checks %v)", tt.check, got[tt.check], tt.status, got)
+			}
+			for name, status := range got {
+				if name != tt.check && status != diagPass {
+					t.Errorf("unbroken check %s = %s", name, status)
+				}
+			}
+		})
+	}
+}
+
+func TestDiagnosticsSkipsUnsetInterface(t *testing.T) {
+	cfg := zmapConfig("tcp")
+	cfg.Interface = ""
+	report := stubDiagnostics(cfg).run()
+	if _, ok := statuses(report)["interface "]; ok || len(report.Checks) != 4 {
+		t.Errorf("checks = %v, want no interface check", statuses(report))
+	}
+}
+
+func TestScanErrorHint(t *testing.T) {
+	for err, want := range map[error]string{
+		&scanner.ZmapError{Err: fmt.Errorf("%w: exec: not found", scanner.ErrBinaryNotFound)}: "install zmap",
+		fmt.Errorf("network 10.0.0.0/24: %w", scanner.ErrInsufficientPrivileges):              "setcap",
+		scanner.ErrNoTargets:                     "check networks and scope",
+		context.Canceled:                         "",
+		errors.New("zmap failed: unknown field"): "",
+	} {
+		if got := scanErrorHint(err); !strings.Contains(got, want) || want == "" && got != "" {
+			t.Errorf("scanErrorHint(%v) = %q, want it to mention %q", err, got, want)
+		}
+	}
+}
+
+func TestDiagnosticsEndpoint(t *testing.T) {
+	healthy := true
+	s := newTestServer(t, zmapConfig("zmap"), stubAPI(t, &healthy).URL)
+	s.diag = stubDiagnostics(s.cfg)
+	s.diag.rawSocket = func() error { return errors.New("operation not permitted") }
+
+	var report diagnosticsReport
+	if code := get(t, s.routes(), "/diagnostics", &report); code != http.StatusOK {
+		t.Fatalf("GET /diagnostics = %d", code)
+	}
+	if report.OK || statuses(report)["raw socket privilege"] != diagFail {
+		t.Errorf("report = %+v, want the raw socket failure", report)
+	}
+}
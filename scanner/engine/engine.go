@@ -0,0 +1,724 @@
+// Package engine runs complete scans - discovery, fingerprinting and
+// enrichment - and hands back the results. What happens to them (API
+// submission, storage, printing) is up to the caller.
+package engine
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"network-scanner/config"
+	"network-scanner/db"
+	"network-scanner/scanner"
+)
+
+// DefaultBatchSize is the most hosts grouped into one ScanResults
+const DefaultBatchSize = 50
+
+// PortScanner discovers open TCP ports. TCPScanner and ZmapScanner implement it.
+type PortScanner interface {
+	ScanPortsWithCallback(ctx context.Context, ports []int, callback scanner.PortScanCallback) (map[string][]int, error)
+	ScanAllPortsWithCallback(ctx context.Context, callback scanner.PortScanCallback) (map[string][]int, error)
+}
+
+// EndpointChecker re-checks known IP:port pairs. TCPScanner implements it.
+type EndpointChecker interface {
+	CheckEndpoints(ctx context.Context, targets []scanner.ZmapResult, callback func(target scanner.ZmapResult, open bool)) error
+}
+
+// UDPPortScanner probes UDP ports. UDPScanner implements it.
+type UDPPortScanner interface {
+	ScanPortsWithCallback(ctx context.Context, ports []int, callback scanner.UDPScanCallback) (map[string][]int, error)
+}
+
+// Batch is one group of results from a single port
+type Batch struct {
+	Port     int
+	Protocol string // "tcp" or "udp"
+	Results  db.ScanResults
+}
+
+// BatchFunc receives each batch as soon as it is complete
+type BatchFunc func(batch Batch)
+
+// ScanEngine runs scans as described by a Config. New wires up the real
+// scanners; the fields may be replaced afterwards, e.g. with stubs.
+type ScanEngine struct {
+	Config *config.Config
+
+	Scanner       PortScanner
+	ScannerName   string         // reported in logs, e.g. "tcp" or "zmap"
+	UDPScanner    UDPPortScanner // nil skips the UDP stage
+	Fingerprinter scanner.HostFingerprinter
+	Geo           *scanner.GeoEnricher  // used by the geo result processor; nil without geoip_source
+	Checker       EndpointChecker       // used by Verify; nil builds a TCP checker
+	Confirmer     *scanner.Confirmer    // re-checks open ports before fingerprinting; nil skips
+	Resolver      *scanner.Resolver     // resolves hostname targets and, with reverse_dns, host names
+	HostLimiter   *scanner.HostLimiter  // caps fingerprint probes per IP; nil for no cap
+	Sockets       *scanner.SocketBudget // caps sockets open at once; nil for no cap
+	BodyBudget    *scanner.BodyBudget   // caps response body bytes per scan; nil for no cap
+	ProbeStats    *scanner.ProbeStats   // probe outcomes per service for the current scan
+	Replay        *scanner.Fixtures     // replay_fixtures: endpoints and probe traffic come from recordings
+	ARP           *scanner.ARPTable     // resolves MAC addresses; nil leaves them unset
+	MACs          *db.MACTracker        // flags IPs whose MAC changed between scans
+	Scope         *scanner.Scope
+	Gate          *scanner.PauseGate
+	Timer         *PhaseTimer // times the phases of the current scan; nil measures nothing
+
+	// SocketOptions set linger and keepalive on the engine's TCP
+	// connections; nil keeps the system defaults
+	SocketOptions *scanner.SocketOptions
+
+	// Ports scanned when scan_all_ports is off
+	Ports []int
+
+	// BatchSize caps the hosts in one ScanResults (default DefaultBatchSize)
+	BatchSize int
+
+	// OnBatch, when set, receives results as they are produced and Run no
+	// longer accumulates them. Long scans use this to submit incrementally.
+	OnBatch BatchFunc
+
+	// OnPortDone and OnHostFound, when set, report progress: each port once
+	// its open hosts are fingerprinted (with zmap_flush_interval, once per
+	// flushed part), and each IP the first time the scan finds it open
+	OnPortDone  func(port int, protocol string, open int)
+	OnHostFound func(ip, hostname string)
+
+	closers []func()
+}
+
+// New builds a ScanEngine from cfg. Call Close when done with it.
+func New(cfg *config.Config) (*ScanEngine, error) {
+	e := &ScanEngine{
+		Config:    cfg,
+		Gate:      scanner.NewPauseGate(),
+		BatchSize: DefaultBatchSize,
+	}
+
+	if len(cfg.AllowNetworks) > 0 || len(cfg.ExcludeNetworks) > 0 {
+		scope, err := scanner.NewScope(cfg.AllowNetworks, cfg.ExcludeNetworks)
+		if err != nil {
+			return nil, fmt.Errorf("invalid scan scope: %w", err)
+		}
+		e.Scope = scope
+	}
+
+	// Resolve the port list: top_ports takes precedence over explicit ports
+	e.Ports = cfg.Ports
+	if cfg.TopPorts > 0 {
+		ports, err := scanner.TopPorts(cfg.TopPorts)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port selection: %w", err)
+		}
+		e.Ports = ports
+	}
+	if len(e.Ports) == 0 {
+		e.Ports = scanner.CommonPorts()
+	}
+
+	// One budget covers every connection the engine opens
+	sockets := cfg.MaxOpenSockets
+	if sockets == 0 {
+		sockets = scanner.DefaultSocketBudget()
+	}
+	if sockets > 0 {
+		e.Sockets = scanner.NewSocketBudget(sockets)
+	}
+	if cfg.ScanLingerZero || cfg.ScanDisableKeepAlive {
+		e.SocketOptions = &scanner.SocketOptions{
+			LingerZero:       cfg.ScanLingerZero,
+			DisableKeepAlive: cfg.ScanDisableKeepAlive,
+		}
+	}
+
+	// Each scanner mode gets its own scanner over just its networks
+	groups := cfg.ModeNetworks()
+	if len(groups) == 0 {
+		groups[cfg.ModeOrDefault()] = nil
+	}
+	var scanners multiScanner
+	for _, mode := range []string{"tcp", "zmap"} {
+		networks, ok := groups[mode]
+		if !ok {
+			continue
+		}
+		s, err := e.newPortScanner(mode, networks)
+		if err != nil {
+			return nil, err
+		}
+		scanners = append(scanners, modeScanner{mode: mode, scanner: s})
+	}
+	if len(scanners) == 1 {
+		e.Scanner = scanners[0].scanner
+		e.ScannerName = scanners[0].mode
+	} else {
+		e.Scanner = scanners
+		e.ScannerName = "tcp+zmap"
+	}
+
+	if len(cfg.UDPPorts) > 0 {
+		udpScanner := scanner.NewUDPScanner(cfg.NetworkCIDRs(), cfg.Rate, cfg.Timeout)
+		udpScanner.Scope = e.Scope
+		udpScanner.Gate = e.Gate
+		udpScanner.Sockets = e.Sockets
+		e.UDPScanner = udpScanner
+	}
+
+	fingerprinter := scanner.NewZgrabFingerprinter()
+	fingerprinter.StoreRaw = cfg.StoreRawZgrab
+	if len(cfg.ZgrabLimits) > 0 {
+		fingerprinter.Limits = make(map[string]scanner.ZgrabLimits, len(cfg.ZgrabLimits))
+		for module, limit := range cfg.ZgrabLimits {
+			fingerprinter.Limits[module] = scanner.ZgrabLimits{
+				MaxHeaders:   limit.MaxHeaders,
+				MaxBodyBytes: limit.MaxBodyBytes,
+				DropHeaders:  limit.DropHeaders,
+			}
+		}
+		if err := scanner.CheckZgrabLimits(fingerprinter.Limits); err != nil {
+			return nil, fmt.Errorf("invalid zgrab_limits: %w", err)
+		}
+	}
+	fingerprinter.Fallback.ProxyTestTarget = cfg.ProxyTestTarget
+	fingerprinter.Fallback.SMTPRelayTest = cfg.SMTPRelayTest
+	fingerprinter.Fallback.FTPAnonTest = cfg.FTPAnonTest
+	fingerprinter.Fallback.MetadataTest = cfg.MetadataTest
+	fingerprinter.Fallback.NTLMInfo = cfg.NTLMInfo
+	if len(cfg.ProxyProtocolPorts) > 0 {
+		version := max(cfg.ProxyProtocolVersion, 1)
+		fingerprinter.Fallback.ProxyProtocolPorts = make(map[int]int, len(cfg.ProxyProtocolPorts))
+		for _, port := range cfg.ProxyProtocolPorts {
+			fingerprinter.Fallback.ProxyProtocolPorts[port] = version
+		}
+	}
+	fingerprinter.Fallback.SourceIP = net.ParseIP(cfg.SourceIP)
+	fingerprinter.Fallback.GracefulClose = cfg.GracefulClose
+	fingerprinter.Fallback.LBDetectSamples = cfg.LBDetectSamples
+	fingerprinter.Fallback.Sockets = e.Sockets
+	fingerprinter.Fallback.SocketOptions = e.SocketOptions
+	if err := scanner.CheckProbeSequence(cfg.ProbeSequence); err != nil {
+		return nil, fmt.Errorf("invalid probe_sequence: %w", err)
+	}
+	fingerprinter.Fallback.ProbeSequence = cfg.ProbeSequence
+	if err := scanner.CheckProbeSequence(cfg.MultiProbe); err != nil {
+		return nil, fmt.Errorf("invalid multi_probe: %w", err)
+	}
+	fingerprinter.Fallback.MultiProbe = cfg.MultiProbe
+	e.ProbeStats = scanner.NewProbeStats()
+	fingerprinter.Fallback.ProbeStats = e.ProbeStats
+	if cfg.MaxTotalBodyBytes > 0 {
+		e.BodyBudget = scanner.NewBodyBudget(cfg.MaxTotalBodyBytes)
+		fingerprinter.Fallback.BodyBudget = e.BodyBudget
+	}
+	if cfg.WebSocketDetect {
+		fingerprinter.Fallback.WebSocketPaths = cfg.WebSocketPaths
+		if len(cfg.WebSocketPaths) == 0 {
+			fingerprinter.Fallback.WebSocketPaths = scanner.DefaultWebSocketPaths
+		}
+	}
+	if cfg.FastFingerprint {
+		fingerprinter.Fallback.FastTimeout = cfg.FastFingerprintTimeout
+		if fingerprinter.Fallback.FastTimeout <= 0 {
+			fingerprinter.Fallback.FastTimeout = scanner.DefaultFastTimeout
+		}
+	}
+	e.Fingerprinter = fingerprinter
+
+	// zgrab2 runs out of process, where its traffic can't be recorded or
+	// replayed, so fixtures always use the native fingerprinter
+	switch {
+	case cfg.ReplayFixtures != "":
+		fixtures, err := scanner.NewFixtureReplayer(cfg.ReplayFixtures)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open replay_fixtures: %w", err)
+		}
+		fingerprinter.Fallback.Fixtures = fixtures
+		e.Fingerprinter = fingerprinter.Fallback
+		e.Replay = fixtures
+	case cfg.RecordFixtures != "":
+		fixtures, err := scanner.NewFixtureRecorder(cfg.RecordFixtures)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open record_fixtures: %w", err)
+		}
+		fingerprinter.Fallback.Fixtures = fixtures
+		e.Fingerprinter = fingerprinter.Fallback
+	}
+
+	if cfg.ConfirmOpen && e.Replay == nil {
+		e.Confirmer = &scanner.Confirmer{
+			Timeout:    time.Duration(cfg.Timeout) * time.Second,
+			ReadBanner: cfg.ConfirmBanner,
+			SourceIP:   net.ParseIP(cfg.SourceIP),
+			Sockets:    e.Sockets,
+
+			SocketOptions: e.SocketOptions,
+		}
+	}
+
+	if cfg.ResolveMAC {
+		e.ARP = scanner.NewARPTable()
+		e.MACs = db.NewMACTracker()
+	}
+
+	if cfg.PerHostProbeConcurrency > 0 {
+		e.HostLimiter = scanner.NewHostLimiter(cfg.PerHostProbeConcurrency)
+	}
+
+	e.Resolver = scanner.NewResolver(cfg.DoHURL, time.Duration(cfg.Timeout)*time.Second)
+	if cfg.DNSAXFRTest {
+		fingerprinter.Fallback.AXFRTest = true
+		fingerprinter.Fallback.Resolver = e.Resolver
+	}
+
+	switch cfg.GeoIPSource {
+	case "maxmind":
+		lookup, err := scanner.NewMaxMindLookup(cfg.GeoIPDB)
+		if err != nil {
+			log.Printf("Warning: geo enrichment disabled: %v", err)
+		} else {
+			e.closers = append(e.closers, func() { lookup.Close() })
+			e.Geo = scanner.NewGeoEnricher(lookup)
+		}
+	case "whois":
+		e.Geo = scanner.NewGeoEnricher(scanner.NewWhoisLookup(cfg.GeoIPWhoisServer, cfg.Timeout))
+	}
+
+	return e, nil
+}
+
+// newPortScanner creates the scanner for one mode over networks
+func (e *ScanEngine) newPortScanner(mode string, networks []string) (PortScanner, error) {
+	cfg := e.Config
+	var sourcePorts *scanner.SourcePortRange
+	if cfg.SourcePorts != "" {
+		min, max, err := cfg.SourcePortRange()
+		if err != nil {
+			return nil, fmt.Errorf("invalid source_ports: %w", err)
+		}
+		sourcePorts = scanner.NewSourcePortRange(min, max)
+	}
+	if mode == "zmap" {
+		zmapScanner := scanner.NewZmapScanner(networks, e.scannerRate(mode, networks), cfg.Timeout)
+		if cfg.Interface != "" {
+			zmapScanner.Interface = cfg.Interface
+		}
+		if ip := net.ParseIP(cfg.SourceIP); ip != nil && ip.To4() != nil {
+			zmapScanner.SourceIP = cfg.SourceIP
+		}
+		if sourcePorts != nil {
+			zmapScanner.SourcePorts = sourcePorts.String()
+		}
+		if err := zmapScanner.SetScope(e.Scope); err != nil {
+			return nil, fmt.Errorf("failed to apply scan scope: %w", err)
+		}
+		zmapScanner.Gate = e.Gate
+		zmapScanner.OutputDir = cfg.ZmapOutputDir
+		zmapScanner.FlushInterval = cfg.ZmapFlushInterval
+		zmapScanner.Retries = cfg.ZmapRetries
+		zmapScanner.IPv6SourceIP = cfg.IPv6SourceIP
+		zmapScanner.MaxIPv6Targets = cfg.MaxIPv6Targets
+		e.closers = append(e.closers, zmapScanner.Close)
+		return zmapScanner, nil
+	}
+
+	tcpScanner := scanner.NewTCPScanner(networks, e.scannerRate(mode, networks), cfg.Timeout)
+	tcpScanner.ParallelPorts = cfg.ParallelPorts
+	tcpScanner.Interleave = cfg.InterleaveNetworks
+	tcpScanner.AllPortsBatch = cfg.AllPortsBatchSize
+	tcpScanner.SourceIP = net.ParseIP(cfg.SourceIP)
+	tcpScanner.SourcePorts = sourcePorts
+	tcpScanner.Scope = e.Scope
+	tcpScanner.Gate = e.Gate
+	tcpScanner.Sockets = e.Sockets
+	tcpScanner.SocketOptions = e.SocketOptions
+	tcpScanner.JitterMin = time.Duration(cfg.ScanJitterMS.Min) * time.Millisecond
+	tcpScanner.JitterMax = time.Duration(cfg.ScanJitterMS.Max) * time.Millisecond
+	return tcpScanner, nil
+}
+
+// Close releases temp files and databases opened by New
+func (e *ScanEngine) Close() {
+	for _, closeFn := range e.closers {
+		closeFn()
+	}
+	e.closers = nil
+}
+
+// Run performs one full scan. Results are returned in batches sharing one
+// scan ID, or passed to OnBatch instead when it is set. On error the
+// batches produced before the failure are still returned.
+func (e *ScanEngine) Run(ctx context.Context) ([]db.ScanResults, error) {
+	r := &run{
+		engine: e,
+		scanID: uuid.New(),
+		// Fresh per run: results are reused within a scan, never across scans
+		fingerprinter: scanner.NewFingerprintCache(e.Fingerprinter),
+		hostnames:     networkHostnames(e.Config.Networks),
+		startedAt:     time.Now(),
+	}
+	log.Printf("Scan ID: %s", r.scanID)
+
+	cfg := e.Config
+	var scanErr error
+
+	if cfg.CapturePcap != "" {
+		if capture := e.startCapture(); capture != nil {
+			defer e.stopCapture(capture)
+		}
+	}
+	if e.MACs != nil {
+		e.MACs.BeginScan()
+		defer func() { logMACChanges(e.MACs.EndScan()) }()
+	}
+	e.BodyBudget.Reset()
+	defer e.logBodyBudget()
+	e.ProbeStats.Reset()
+	defer e.ProbeStats.Log()
+
+	explicit := cfg.TargetsFile != "" || e.Replay != nil
+	if explicit {
+		targets, source, err := e.explicitTargets(ctx)
+		if err != nil {
+			return nil, err
+		}
+		// Scope applies to explicit endpoints as well
+		inScope := targets[:0]
+		for _, t := range targets {
+			if e.Scope.Contains(net.ParseIP(t.IP)) {
+				inScope = append(inScope, t)
+			}
+		}
+		targets = inScope
+		for _, t := range targets {
+			if t.Hostname != "" && r.hostnames[t.IP] == "" {
+				r.hostnames[t.IP] = t.Hostname
+			}
+		}
+		log.Printf("Verifying %d endpoints from %s", len(targets), source)
+
+		// Explicit endpoints skip discovery and go straight to fingerprinting
+		targetPorts, byPort := scanner.GroupByPort(targets)
+		for _, port := range targetPorts {
+			if err := e.Gate.Wait(ctx); err != nil {
+				scanErr = err
+				break
+			}
+			r.fingerprintPort(ctx, port, byPort[port])
+		}
+	} else if cfg.ScanAllPorts {
+		log.Printf("Scanning ALL ports (1-65535) on networks %v using %s", cfg.Networks, e.ScannerName)
+		endDiscovery := e.Timer.Begin(PhaseDiscovery)
+		_, scanErr = e.Scanner.ScanAllPortsWithCallback(ctx, func(port int, results []scanner.ZmapResult) {
+			r.fingerprintPort(ctx, port, results)
+		})
+		endDiscovery()
+	} else {
+		log.Printf("Scanning %d ports on networks %v using %s", len(e.Ports), cfg.Networks, e.ScannerName)
+		endDiscovery := e.Timer.Begin(PhaseDiscovery)
+		_, scanErr = e.Scanner.ScanPortsWithCallback(ctx, e.Ports, func(port int, results []scanner.ZmapResult) {
+			r.fingerprintPort(ctx, port, results)
+		})
+		endDiscovery()
+	}
+
+	// UDP has no discovery phase; each probe doubles as the fingerprint
+	if scanErr == nil && e.UDPScanner != nil && !explicit {
+		log.Printf("Scanning %d UDP ports on networks %v", len(cfg.UDPPorts), cfg.Networks)
+		endDiscovery := e.Timer.Begin(PhaseDiscovery)
+		_, scanErr = e.UDPScanner.ScanPortsWithCallback(ctx, cfg.UDPPorts, func(port int, results []scanner.UDPResult) {
+			r.collectUDPPort(ctx, port, results)
+		})
+		endDiscovery()
+	}
+
+	return r.batches, scanErr
+}
+
+// explicitTargets returns the endpoints of targets_file or, when replaying
+// without one, of the fixture directory, and where they came from
+func (e *ScanEngine) explicitTargets(ctx context.Context) ([]scanner.ZmapResult, string, error) {
+	cfg := e.Config
+	if cfg.TargetsFile != "" {
+		targets, err := scanner.LoadTargetsFile(ctx, cfg.TargetsFile, e.Resolver)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to load targets file %s: %w", cfg.TargetsFile, err)
+		}
+		return targets, cfg.TargetsFile, nil
+	}
+	targets, err := e.Replay.Targets()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to list fixtures in %s: %w", e.Replay.Dir, err)
+	}
+	return targets, e.Replay.Dir + " (replay)", nil
+}
+
+// logBodyBudget adds the scan's body budget use to the summary when bodies
+// were skipped
+func (e *ScanEngine) logBodyBudget() {
+	if skipped := e.BodyBudget.Skipped(); skipped > 0 {
+		log.Printf("Body budget: %d of %d bytes used, %d response bodies skipped or cut short",
+			e.BodyBudget.Used(), e.BodyBudget.Limit(), skipped)
+	}
+}
+
+// startCapture begins recording the scan's TCP packets to capture_pcap. A
+// capture that can't start is logged and the scan runs without it.
+func (e *ScanEngine) startCapture() *scanner.Capture {
+	cfg := e.Config
+	maxBytes := int64(cfg.CaptureMaxMB) << 20
+	if maxBytes == 0 {
+		maxBytes = scanner.DefaultCaptureMaxBytes
+	}
+	capture, err := scanner.StartCapture(cfg.CapturePcap, cfg.Interface, maxBytes)
+	if err != nil {
+		log.Printf("Warning: packet capture disabled: %v", err)
+		return nil
+	}
+	log.Printf("Capturing scan packets to %s (limit %d MB)", cfg.CapturePcap, maxBytes>>20)
+	return capture
+}
+
+func (e *ScanEngine) stopCapture(capture *scanner.Capture) {
+	stats, err := capture.Stop()
+	if err != nil {
+		log.Printf("Warning: packet capture failed: %v", err)
+	}
+	log.Printf("Captured %d packets (%d bytes) to %s", stats.Packets, stats.Bytes, stats.Path)
+	if stats.Limited {
+		log.Printf("Warning: capture stopped early at the size limit; raise capture_max_mb for a full capture")
+	}
+}
+
+// run holds the state of one Run call
+type run struct {
+	engine        *ScanEngine
+	scanID        uuid.UUID
+	fingerprinter *scanner.FingerprintCache
+	batches       []db.ScanResults
+	hostnames     map[string]string // IP -> target hostname it was resolved from
+	startedAt     time.Time
+
+	foundMu sync.Mutex
+	found   map[string]bool // IPs reported to OnHostFound
+}
+
+// emit hands a batch to OnBatch or keeps it for Run's return value
+func (r *run) emit(port int, protocol string, hosts []db.ScanResultHost) {
+	if len(hosts) == 0 {
+		return
+	}
+	r.reportHosts(hosts)
+	results := db.ScanResults{ScanID: r.scanID, Hosts: hosts}
+	results.SetTimes(r.startedAt, time.Now(), r.engine.Config.ResultTTL)
+	if r.engine.OnBatch != nil {
+		r.engine.OnBatch(Batch{Port: port, Protocol: protocol, Results: results})
+		return
+	}
+	r.batches = append(r.batches, results)
+}
+
+// reportHosts passes IPs with an open port not seen before in this run to
+// OnHostFound
+func (r *run) reportHosts(hosts []db.ScanResultHost) {
+	if r.engine.OnHostFound == nil {
+		return
+	}
+	r.foundMu.Lock()
+	defer r.foundMu.Unlock()
+	for _, host := range hosts {
+		if r.found[host.IPAddress] || !hasOpenPort(host) {
+			continue
+		}
+		if r.found == nil {
+			r.found = make(map[string]bool)
+		}
+		r.found[host.IPAddress] = true
+		r.engine.OnHostFound(host.IPAddress, host.Hostname)
+	}
+}
+
+func hasOpenPort(host db.ScanResultHost) bool {
+	for _, port := range host.Ports {
+		if port.State == "open" {
+			return true
+		}
+	}
+	return false
+}
+
+// portDone reports a fingerprinted port to OnPortDone
+func (r *run) portDone(port int, protocol string, open int) {
+	if r.engine.OnPortDone != nil {
+		r.engine.OnPortDone(port, protocol, open)
+	}
+}
+
+func (r *run) batchSize() int {
+	if r.engine.BatchSize > 0 {
+		return r.engine.BatchSize
+	}
+	return DefaultBatchSize
+}
+
+// fingerprintPort fingerprints the open hosts found on one TCP port and
+// emits them in batches as they complete
+func (r *run) fingerprintPort(ctx context.Context, port int, results []scanner.ZmapResult) {
+	// Deferred as a closure to count the hosts left after confirmation
+	defer func() { r.portDone(port, "tcp", len(results)) }()
+	if len(results) == 0 {
+		return
+	}
+
+	e := r.engine
+	defer e.Timer.Begin(PhaseFingerprint)()
+
+	if e.Confirmer != nil {
+		found := len(results)
+		results = e.Confirmer.Confirm(ctx, results)
+		if dropped := found - len(results); dropped > 0 {
+			log.Printf("Port %d: %d of %d hosts failed confirmation", port, dropped, found)
+		}
+		if len(results) == 0 {
+			return
+		}
+	}
+
+	log.Printf("Port %d: fingerprinting %d hosts", port, len(results))
+
+	var hosts []db.ScanResultHost
+	scanner.FingerprintAll(ctx, r.fingerprinter, results, e.Config.FingerprintConcurrency, e.HostLimiter, func(t scanner.ZmapResult, info scanner.ServiceInfo) {
+		portResult := db.ScanResultPort{
+			PortNumber:      t.Port,
+			Protocol:        "tcp",
+			State:           "open",
+			ServiceName:     info.ServiceName,
+			ServiceVersion:  info.ServiceVersion,
+			Banner:          info.Banner,
+			FingerprintData: info.Fingerprint,
+		}
+
+		if t.ConnectTime > 0 {
+			if portResult.FingerprintData == nil {
+				portResult.FingerprintData = make(map[string]interface{})
+			}
+			portResult.FingerprintData["connect_ms"] = float64(t.ConnectTime.Microseconds()) / 1000
+		}
+		if t.Confidence != "" {
+			if portResult.FingerprintData == nil {
+				portResult.FingerprintData = make(map[string]interface{})
+			}
+			portResult.FingerprintData["confidence"] = t.Confidence
+		}
+
+		hosts = append(hosts, r.host(ctx, t.IP, portResult))
+		if len(hosts) >= r.batchSize() {
+			r.emit(port, "tcp", hosts)
+			hosts = nil
+		}
+	})
+	r.emit(port, "tcp", hosts)
+}
+
+// host builds the result entry for one port of ip, adding its hostname and
+// MAC address when those are resolved. A MAC that differs from the
+// previous scans' is flagged in Fingerprint["mac_changed"].
+func (r *run) host(ctx context.Context, ip string, port db.ScanResultPort) db.ScanResultHost {
+	host := db.ScanResultHost{
+		IPAddress:     ip,
+		AddressFamily: db.AddressFamily(ip),
+		Hostname:      r.hostname(ctx, ip),
+		Ports:         []db.ScanResultPort{port},
+	}
+	e := r.engine
+	if e.ARP == nil {
+		return host
+	}
+
+	host.MACAddress = e.ARP.MAC(ip)
+	if change, changed := e.MACs.Observe(ip, host.MACAddress); changed {
+		if port.FingerprintData == nil {
+			port.FingerprintData = make(map[string]interface{})
+		}
+		port.FingerprintData["mac_changed"] = map[string]interface{}{
+			"old": change.OldMAC,
+			"new": change.NewMAC,
+		}
+		host.Ports[0] = port
+	}
+	return host
+}
+
+// logMACChanges adds the scan's MAC changes to the summary
+func logMACChanges(changes []db.MACChange) {
+	if len(changes) == 0 {
+		return
+	}
+	log.Printf("WARNING: %d IPs changed MAC address since the previous scan (possible ARP spoofing):", len(changes))
+	for _, change := range changes {
+		log.Printf("  %s: %s -> %s", change.IP, change.OldMAC, change.NewMAC)
+	}
+}
+
+// hostname returns the target hostname ip was resolved from or, failing
+// that, reverse-resolves ip when reverse_dns is on
+func (r *run) hostname(ctx context.Context, ip string) string {
+	if name := r.hostnames[ip]; name != "" {
+		return name
+	}
+	if !r.engine.Config.ReverseDNS || r.engine.Resolver == nil {
+		return ""
+	}
+	return r.engine.Resolver.Hostname(ctx, ip)
+}
+
+// networkHostnames maps the address of each single-address network that
+// was resolved from a hostname to that hostname
+func networkHostnames(networks []config.Network) map[string]string {
+	names := make(map[string]string)
+	for _, n := range networks {
+		if n.Hostname == "" {
+			continue
+		}
+		if ip, _, err := net.ParseCIDR(n.CIDR); err == nil && names[ip.String()] == "" {
+			names[ip.String()] = n.Hostname
+		}
+	}
+	return names
+}
+
+// collectUDPPort converts one UDP port's probe results into a batch
+func (r *run) collectUDPPort(ctx context.Context, port int, results []scanner.UDPResult) {
+	defer r.engine.Timer.Begin(PhaseFingerprint)()
+
+	hosts := make([]db.ScanResultHost, 0, len(results))
+	for _, res := range results {
+		info := res.Info
+		hosts = append(hosts, r.host(ctx, res.IP, db.ScanResultPort{
+			PortNumber:      res.Port,
+			Protocol:        "udp",
+			State:           "open",
+			ServiceName:     info.ServiceName,
+			ServiceVersion:  info.ServiceVersion,
+			Banner:          info.Banner,
+			FingerprintData: info.Fingerprint,
+		}))
+	}
+	r.emit(port, "udp", hosts)
+	r.portDone(port, "udp", len(hosts))
+}
+
+// FingerprintMode names the fingerprinter in use: "zgrab2" when zgrab2 was
+// found, "native" otherwise or when fixtures force the native probes
+func (e *ScanEngine) FingerprintMode() string {
+	if zgrab, ok := e.Fingerprinter.(*scanner.ZgrabFingerprinter); ok && zgrab.Available() {
+		return "zgrab2"
+	}
+	return "native"
+}
@@ -0,0 +1,732 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"network-scanner/config"
+	"network-scanner/db"
+	"network-scanner/scanner"
+)
+
+// loadConfig loads a config from yaml the way the scanner reads its file
+func loadConfig(t *testing.T, yaml string) *config.Config {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(yaml), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+// newEngine builds an engine from yaml and closes it when the test ends
+func newEngine(t *testing.T, yaml string) *ScanEngine {
+	t.Helper()
+	e, err := New(loadConfig(t, yaml))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(e.Close)
+	return e
+}
+
+// stubScanner reports fixed open hosts per port
+type stubScanner struct {
+	open map[int][]scanner.ZmapResult
+
+	mu      sync.Mutex
+	scanned []int // ports in the order they were scanned
+}
+
+func (s *stubScanner) ScanPortsWithCallback(ctx context.Context, ports []int, callback scanner.PortScanCallback) (map[string][]int, error) {
+	found := make(map[string][]int)
+	for _, port := range ports {
+		if err := ctx.Err(); err != nil {
+			return found, err
+		}
+		s.mu.Lock()
+		s.scanned = append(s.scanned, port)
+		s.mu.Unlock()
+		results := s.open[port]
+		for _, r := range results {
+			found[r.IP] = append(found[r.IP], port)
+		}
+		if len(results) > 0 && callback != nil {
+			callback(port, results)
+		}
+	}
+	return found, nil
+}
+
+func (s *stubScanner) ScanAllPortsWithCallback(ctx context.Context, callback scanner.PortScanCallback) (map[string][]int, error) {
+	ports := make([]int, 0, len(s.open))
+	for port := range s.open {
+		ports = append(ports, port)
+	}
+	sort.Ints(ports)
+	return s.ScanPortsWithCallback(ctx, ports, callback)
+}
+
+// stubFingerprinter names every service after its port and counts the
+// endpoints fingerprinted
+type stubFingerprinter struct {
+	mu    sync.Mutex
+	calls map[string]int // by "ip:port"
+}
+
+func (f *stubFingerprinter) FingerprintHost(ctx context.Context, ip string, ports []int) map[int]scanner.ServiceInfo {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.calls == nil {
+		f.calls = make(map[string]int)
+	}
+	results := make(map[int]scanner.ServiceInfo, len(ports))
+	for _, port := range ports {
+		f.calls[endpoint(ip, port)]++
+		results[port] = scanner.ServiceInfo{ServiceName: "svc-" + strconv.Itoa(port)}
+	}
+	return results
+}
+
+func endpoint(ip string, port int) string {
+	return ip + ":" + strconv.Itoa(port)
+}
+
+// open lists results for ips open on port
+func open(port int, ips ...string) []scanner.ZmapResult {
+	results := make([]scanner.ZmapResult, len(ips))
+	for i, ip := range ips {
+		results[i] = scanner.ZmapResult{IP: ip, Port: port}
+	}
+	return results
+}
+
+// stubEngine is newEngine with the scanner and fingerprinter replaced by
+// stubs
+func stubEngine(t *testing.T, yaml string, scan *stubScanner) (*ScanEngine, *stubFingerprinter) {
+	t.Helper()
+	e := newEngine(t, yaml)
+	fp := &stubFingerprinter{}
+	e.Scanner = scan
+	e.Fingerprinter = fp
+	return e, fp
+}
+
+// resultPorts flattens batches to the ports found per IP, sorted
+func resultPorts(batches []db.ScanResults) map[string][]int {
+	ports := make(map[string][]int)
+	for _, batch := range batches {
+		for _, host := range batch.Hosts {
+			for _, port := range host.Ports {
+				ports[host.IPAddress] = append(ports[host.IPAddress], port.PortNumber)
+			}
+		}
+	}
+	for ip := range ports {
+		sort.Ints(ports[ip])
+	}
+	return ports
+}
+
+// findPort returns ip's result for port across batches
+func findPort(batches []db.ScanResults, ip string, port int) (db.ScanResultPort, bool) {
+	for _, batch := range batches {
+		for _, host := range batch.Hosts {
+			if host.IPAddress != ip {
+				continue
+			}
+			for _, p := range host.Ports {
+				if p.PortNumber == port {
+					return p, true
+				}
+			}
+		}
+	}
+	return db.ScanResultPort{}, false
+}
+
+func TestNewTopPorts(t *testing.T) {
+	e := newEngine(t, `
+networks: [{cidr: 127.0.0.1/32}]
+ports: [8080]
+top_ports: 10
+`)
+	want := []int{80, 23, 443, 21, 22, 25, 3389, 110, 445, 139}
+	if !reflect.DeepEqual(e.Ports, want) {
+		t.Errorf("Ports with top_ports: 10 = %v, want %v", e.Ports, want)
+	}
+}
+
+func TestNewExplicitPorts(t *testing.T) {
+	e := newEngine(t, `
+networks: [{cidr: 127.0.0.1/32}]
+ports: [22, 8080]
+`)
+	if !reflect.DeepEqual(e.Ports, []int{22, 8080}) {
+		t.Errorf("Ports = %v, want [22 8080]", e.Ports)
+	}
+}
+
+func TestRunRecordsConnectMs(t *testing.T) {
+	scan := &stubScanner{open: map[int][]scanner.ZmapResult{22: {
+		{IP: "10.0.0.1", Port: 22, ConnectTime: 1500 * time.Microsecond},
+		{IP: "10.0.0.2", Port: 22},
+	}}}
+	e, _ := stubEngine(t, "networks: [{cidr: 10.0.0.0/24}]\nports: [22]\n", scan)
+
+	batches, err := e.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, ok := findPort(batches, "10.0.0.1", 22)
+	if !ok || port.FingerprintData["connect_ms"] != 1.5 {
+		t.Errorf("10.0.0.1:22 = %+v, want connect_ms 1.5", port)
+	}
+	if port, ok := findPort(batches, "10.0.0.2", 22); !ok || port.FingerprintData["connect_ms"] != nil {
+		t.Errorf("10.0.0.2:22 without a measured handshake = %+v, want no connect_ms", port)
+	}
+}
+
+func TestRunTargetsFileProbesExactEndpoints(t *testing.T) {
+	targets := filepath.Join(t.TempDir(), "targets.txt")
+	if err := os.WriteFile(targets, []byte("10.0.0.1:22\n10.0.0.2:8000-8001\n10.0.0.1:22\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	scan := &stubScanner{open: map[int][]scanner.ZmapResult{22: open(22, "10.0.0.9")}}
+	e, fp := stubEngine(t, "networks: [{cidr: 10.0.0.0/24}]\nports: [22]\ntargets_file: "+targets+"\n", scan)
+
+	batches, err := e.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int{"10.0.0.1:22": 1, "10.0.0.2:8000": 1, "10.0.0.2:8001": 1}
+	if !reflect.DeepEqual(fp.calls, want) {
+		t.Errorf("fingerprinted %v, want %v", fp.calls, want)
+	}
+	if len(scan.scanned) != 0 {
+		t.Errorf("port scanner ran for %v; explicit endpoints skip discovery", scan.scanned)
+	}
+	if got := resultPorts(batches); !reflect.DeepEqual(got, map[string][]int{"10.0.0.1": {22}, "10.0.0.2": {8000, 8001}}) {
+		t.Errorf("results = %v", got)
+	}
+}
+
+func TestRunSubmitsEveryFingerprintedHost(t *testing.T) {
+	var hosts []string
+	for i := 1; i <= 120; i++ {
+		hosts = append(hosts, "10.0.0."+strconv.Itoa(i))
+	}
+	scan := &stubScanner{open: map[int][]scanner.ZmapResult{22: open(22, hosts...), 80: open(80, hosts[:7]...)}}
+	e, fp := stubEngine(t, "networks: [{cidr: 10.0.0.0/24}]\nports: [22, 80]\nfingerprint_concurrency: 16\n", scan)
+
+	var mu sync.Mutex
+	var batches []db.ScanResults
+	e.OnBatch = func(batch Batch) {
+		mu.Lock()
+		defer mu.Unlock()
+		if len(batch.Results.Hosts) > e.BatchSize {
+			t.Errorf("batch of %d hosts, want at most %d", len(batch.Results.Hosts), e.BatchSize)
+		}
+		batches = append(batches, batch.Results)
+	}
+	if _, err := e.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	got := resultPorts(batches)
+	if len(got) != len(hosts) || len(fp.calls) != len(hosts)+7 {
+		t.Fatalf("submitted %d hosts after %d probes, want %d hosts after %d", len(got), len(fp.calls), len(hosts), len(hosts)+7)
+	}
+	for i, ip := range hosts {
+		want := []int{22}
+		if i < 7 {
+			want = []int{22, 80}
+		}
+		if !reflect.DeepEqual(got[ip], want) {
+			t.Errorf("%s = %v, want %v", ip, got[ip], want)
+		}
+	}
+}
+
+// failingScanner reports port 22 open and then fails
+type failingScanner struct{ stubScanner }
+
+func (s *failingScanner) ScanPortsWithCallback(ctx context.Context, ports []int, callback scanner.PortScanCallback) (map[string][]int, error) {
+	callback(22, open(22, "10.0.0.1"))
+	return nil, errors.New("scanner failed")
+}
+
+func TestRunReturnsBatches(t *testing.T) {
+	scan := &stubScanner{open: map[int][]scanner.ZmapResult{
+		22:  open(22, "10.0.0.1", "10.0.0.2", "10.0.0.3"),
+		443: open(443, "10.0.0.2"),
+	}}
+	e, _ := stubEngine(t, "networks: [{cidr: 10.0.0.0/24}]\nports: [22, 80, 443]\n", scan)
+	e.BatchSize = 2
+
+	batches, err := e.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(scan.scanned, []int{22, 80, 443}) {
+		t.Errorf("scanned %v, want [22 80 443]", scan.scanned)
+	}
+	// Three hosts on 22 split into batches of two, one on 443
+	if len(batches) != 3 {
+		t.Fatalf("got %d batches, want 3", len(batches))
+	}
+	for _, batch := range batches {
+		if batch.ScanID != batches[0].ScanID {
+			t.Errorf("batches carry scan IDs %s and %s, want one", batches[0].ScanID, batch.ScanID)
+		}
+	}
+	want := map[string][]int{"10.0.0.1": {22}, "10.0.0.2": {22, 443}, "10.0.0.3": {22}}
+	if got := resultPorts(batches); !reflect.DeepEqual(got, want) {
+		t.Errorf("results = %v, want %v", got, want)
+	}
+	if port, _ := findPort(batches, "10.0.0.2", 443); port.ServiceName != "svc-443" || port.State != "open" || port.Protocol != "tcp" {
+		t.Errorf("10.0.0.2:443 = %+v, want open tcp svc-443", port)
+	}
+
+	// A second run is a new scan
+	again, err := e.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(again) == 0 || again[0].ScanID == batches[0].ScanID {
+		t.Errorf("second run reused scan ID %s", batches[0].ScanID)
+	}
+}
+
+func TestRunAllPorts(t *testing.T) {
+	scan := &stubScanner{open: map[int][]scanner.ZmapResult{
+		22:    open(22, "10.0.0.1"),
+		31337: open(31337, "10.0.0.1"),
+	}}
+	e, _ := stubEngine(t, "networks: [{cidr: 10.0.0.0/24}]\nports: [22]\nscan_all_ports: true\n", scan)
+
+	batches, err := e.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := resultPorts(batches); !reflect.DeepEqual(got, map[string][]int{"10.0.0.1": {22, 31337}}) {
+		t.Errorf("results = %v, want both ports from the full-range scan", got)
+	}
+}
+
+func TestRunOnBatchLeavesReturnEmpty(t *testing.T) {
+	scan := &stubScanner{open: map[int][]scanner.ZmapResult{22: open(22, "10.0.0.1")}}
+	e, _ := stubEngine(t, "networks: [{cidr: 10.0.0.0/24}]\nports: [22]\n", scan)
+	var got []Batch
+	e.OnBatch = func(batch Batch) { got = append(got, batch) }
+
+	batches, err := e.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batches) != 0 {
+		t.Errorf("Run returned %d batches with OnBatch set", len(batches))
+	}
+	if len(got) != 1 || got[0].Port != 22 || got[0].Protocol != "tcp" || len(got[0].Results.Hosts) != 1 {
+		t.Errorf("OnBatch received %+v, want one tcp batch for port 22", got)
+	}
+}
+
+func TestRunScannerErrorKeepsEarlierBatches(t *testing.T) {
+	e, _ := stubEngine(t, "networks: [{cidr: 10.0.0.0/24}]\nports: [22, 80]\n", nil)
+	e.Scanner = &failingScanner{}
+
+	batches, err := e.Run(context.Background())
+	if err == nil {
+		t.Fatal("Run succeeded with a failing scanner")
+	}
+	if got := resultPorts(batches); !reflect.DeepEqual(got, map[string][]int{"10.0.0.1": {22}}) {
+		t.Errorf("results before the failure = %v, want 10.0.0.1:22", got)
+	}
+}
+
+func TestRunCancelled(t *testing.T) {
+	scan := &stubScanner{open: map[int][]scanner.ZmapResult{22: open(22, "10.0.0.1")}}
+	e, fp := stubEngine(t, "networks: [{cidr: 10.0.0.0/24}]\nports: [22]\n", scan)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := e.Run(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Run with a cancelled context = %v, want context.Canceled", err)
+	}
+	if len(fp.calls) != 0 {
+		t.Errorf("fingerprinted %v after cancellation", fp.calls)
+	}
+}
+
+func TestRunProbesRepeatedEndpointOnce(t *testing.T) {
+	// Overlapping networks report 10.0.0.1 twice
+	scan := &stubScanner{open: map[int][]scanner.ZmapResult{22: open(22, "10.0.0.1", "10.0.0.2", "10.0.0.1")}}
+	e, fp := stubEngine(t, "networks: [{cidr: 10.0.0.0/24}, {cidr: 10.0.0.0/25}]\nports: [22]\n", scan)
+
+	if _, err := e.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fp.calls, map[string]int{"10.0.0.1:22": 1, "10.0.0.2:22": 1}) {
+		t.Errorf("fingerprinted %v, want each endpoint once", fp.calls)
+	}
+
+	// The cache is per scan
+	if _, err := e.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if fp.calls["10.0.0.1:22"] != 2 {
+		t.Errorf("second scan probed 10.0.0.1:22 %d times in total, want 2", fp.calls["10.0.0.1:22"])
+	}
+}
+
+func TestRunRecordsAddressFamily(t *testing.T) {
+	scan := &stubScanner{open: map[int][]scanner.ZmapResult{22: open(22, "10.0.0.1", "2001:db8::1")}}
+	e, _ := stubEngine(t, "networks: [{cidr: 10.0.0.0/24}, {cidr: '2001:db8::/120'}]\nports: [22]\n", scan)
+
+	batches, err := e.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	families := make(map[string]string)
+	for _, batch := range batches {
+		for _, host := range batch.Hosts {
+			families[host.IPAddress] = host.AddressFamily
+		}
+	}
+	if !reflect.DeepEqual(families, map[string]string{"10.0.0.1": "ipv4", "2001:db8::1": "ipv6"}) {
+		t.Errorf("address families = %v", families)
+	}
+}
+
+func TestRunConfirmOpen(t *testing.T) {
+	steady := listen(t)
+	gone := closed(t)
+	scan := &stubScanner{open: map[int][]scanner.ZmapResult{
+		steady: open(steady, "127.0.0.1"),
+		gone:   open(gone, "127.0.0.1"),
+	}}
+	yaml := "networks: [127.0.0.0/24]\nports: [" + strconv.Itoa(steady) + ", " + strconv.Itoa(gone) + "]\ntimeout: 1\nconfirm_open: true\n"
+	e, fp := stubEngine(t, yaml, scan)
+	if e.Confirmer == nil {
+		t.Fatal("confirm_open set no Confirmer")
+	}
+
+	batches, err := e.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]int{"127.0.0.1": {steady}}
+	if got := resultPorts(batches); !reflect.DeepEqual(got, want) {
+		t.Errorf("results = %v, want only the port that accepted again", got)
+	}
+	if port, _ := findPort(batches, "127.0.0.1", steady); port.FingerprintData["confidence"] != scanner.ConfidenceMedium {
+		t.Errorf("confidence = %v, want %q", port.FingerprintData["confidence"], scanner.ConfidenceMedium)
+	}
+	if fp.calls[endpoint("127.0.0.1", gone)] != 0 {
+		t.Error("the unconfirmed port was fingerprinted")
+	}
+}
+
+// writeARPTable writes a /proc/net/arp style table mapping IPs to MACs
+func writeARPTable(t *testing.T, path string, macs map[string]string) {
+	t.Helper()
+	table := "IP address       HW type     Flags       HW address            Mask     Device\n"
+	for ip, mac := range macs {
+		table += ip + "         0x1         0x2         " + mac + "     *        eth0\n"
+	}
+	if err := os.WriteFile(path, []byte(table), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunFlagsMACChange(t *testing.T) {
+	scan := &stubScanner{open: map[int][]scanner.ZmapResult{22: open(22, "10.0.0.1", "10.0.0.2")}}
+	e, _ := stubEngine(t, "networks: [10.0.0.0/24]\nports: [22]\nresolve_mac: true\n", scan)
+	if e.MACs == nil {
+		t.Fatal("resolve_mac set no MAC tracker")
+	}
+	arp := filepath.Join(t.TempDir(), "arp")
+
+	scans := []map[string]string{
+		{"10.0.0.1": "aa:aa:aa:aa:aa:01", "10.0.0.2": "aa:aa:aa:aa:aa:02"},
+		{"10.0.0.1": "aa:aa:aa:aa:aa:01", "10.0.0.2": "BB:BB:BB:BB:BB:02"},
+	}
+	var batches []db.ScanResults
+	for _, macs := range scans {
+		writeARPTable(t, arp, macs)
+		e.ARP = &scanner.ARPTable{Path: arp} // a fresh table skips the refresh interval
+		var err error
+		if batches, err = e.Run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, batch := range batches {
+		for _, host := range batch.Hosts {
+			if want := strings.ToLower(scans[1][host.IPAddress]); host.MACAddress != want {
+				t.Errorf("%s MAC = %q, want %q", host.IPAddress, host.MACAddress, want)
+			}
+		}
+	}
+
+	unchanged, _ := findPort(batches, "10.0.0.1", 22)
+	if _, ok := unchanged.FingerprintData["mac_changed"]; ok {
+		t.Errorf("10.0.0.1 flagged with an unchanged MAC: %v", unchanged.FingerprintData)
+	}
+	flipped, _ := findPort(batches, "10.0.0.2", 22)
+	want := map[string]interface{}{"old": "aa:aa:aa:aa:aa:02", "new": "bb:bb:bb:bb:bb:02"}
+	if got := flipped.FingerprintData["mac_changed"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("10.0.0.2 mac_changed = %v, want %v", got, want)
+	}
+}
+
+func TestRunAttachesTargetHostnames(t *testing.T) {
+	scan := &stubScanner{open: map[int][]scanner.ZmapResult{443: open(443, "10.2.0.5", "10.2.0.6", "10.3.0.9", "10.0.0.1")}}
+	e, _ := stubEngine(t, "networks: [{cidr: 10.0.0.0/24}]\nports: [443]\n", scan)
+	// As ReadNetworks resolves them: db.internal and web.internal both
+	// have 10.2.0.5, which keeps the first
+	e.Config.Networks = append(e.Config.Networks,
+		config.Network{CIDR: "10.2.0.5/32", Hostname: "db.internal"},
+		config.Network{CIDR: "10.2.0.6/32", Hostname: "db.internal"},
+		config.Network{CIDR: "10.2.0.5/32", Hostname: "web.internal"},
+		config.Network{CIDR: "10.3.0.9/32", Hostname: "web.internal"},
+	)
+
+	batches, err := e.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]string)
+	for _, batch := range batches {
+		for _, host := range batch.Hosts {
+			got[host.IPAddress] = host.Hostname
+		}
+	}
+	want := map[string]string{"10.2.0.5": "db.internal", "10.2.0.6": "db.internal", "10.3.0.9": "web.internal", "10.0.0.1": ""}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("hostnames = %v, want %v", got, want)
+	}
+}
+
+func TestNewBodyBudget(t *testing.T) {
+	e := newEngine(t, "networks: [{cidr: 127.0.0.1/32}]\nports: [80]\nmax_total_body_bytes: 4096\n")
+	if e.BodyBudget.Limit() != 4096 {
+		t.Errorf("body budget %d, want 4096", e.BodyBudget.Limit())
+	}
+	if e := newEngine(t, "networks: [{cidr: 127.0.0.1/32}]\nports: [80]\n"); e.BodyBudget != nil {
+		t.Errorf("body budget %d without max_total_body_bytes, want none", e.BodyBudget.Limit())
+	}
+}
+
+func TestNewProxyProtocolPorts(t *testing.T) {
+	for yaml, want := range map[string]map[int]int{
+		"proxy_protocol_ports: [22, 8443]\n":                      {22: 1, 8443: 1},
+		"proxy_protocol_ports: [22]\nproxy_protocol_version: 2\n": {22: 2},
+		"proxy_protocol_version: 2\n":                             nil,
+	} {
+		e := newEngine(t, "networks: [{cidr: 127.0.0.1/32}]\nports: [22]\n"+yaml)
+		f := e.Fingerprinter.(*scanner.ZgrabFingerprinter).Fallback
+		if !reflect.DeepEqual(f.ProxyProtocolPorts, want) {
+			t.Errorf("%q: PROXY protocol ports %v, want %v", yaml, f.ProxyProtocolPorts, want)
+		}
+	}
+}
+
+func TestNewSocketOptions(t *testing.T) {
+	e := newEngine(t, "networks: [{cidr: 127.0.0.1/32}]\nports: [22]\nscanner_mode: tcp\nconfirm_open: true\nscan_linger_zero: true\n")
+	want := &scanner.SocketOptions{LingerZero: true}
+	if !reflect.DeepEqual(e.SocketOptions, want) {
+		t.Fatalf("socket options %+v, want %+v", e.SocketOptions, want)
+	}
+	if f := e.Fingerprinter.(*scanner.ZgrabFingerprinter).Fallback; f.SocketOptions != e.SocketOptions {
+		t.Error("fingerprinter doesn't share the engine's socket options")
+	}
+	if e.Confirmer.SocketOptions != e.SocketOptions {
+		t.Error("confirmer doesn't share the engine's socket options")
+	}
+	if e := newEngine(t, "networks: [{cidr: 127.0.0.1/32}]\nports: [22]\n"); e.SocketOptions != nil {
+		t.Errorf("socket options %+v by default, want the system's", e.SocketOptions)
+	}
+}
+
+func TestRunResetsProbeStats(t *testing.T) {
+	e := newEngine(t, "networks: [{cidr: 10.0.0.0/24}]\nports: [22]\n")
+	native := e.Fingerprinter.(*scanner.ZgrabFingerprinter).Fallback
+	if native.ProbeStats == nil || native.ProbeStats != e.ProbeStats {
+		t.Fatal("fingerprinter doesn't count into the engine's probe stats")
+	}
+
+	// Outcomes from before the scan, e.g. a /fingerprint request
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+	native.Timeout = 200 * time.Millisecond
+	native.FingerprintHost(context.Background(), "127.0.0.1", []int{closed})
+	if len(e.ProbeStats.Snapshot()) == 0 {
+		t.Fatal("probe outside a scan not counted")
+	}
+
+	e.Scanner = &stubScanner{}
+	if _, err := e.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := e.ProbeStats.Snapshot(); len(got) != 0 {
+		t.Errorf("counts %+v after a scan that fingerprinted nothing, want the scan's alone", got)
+	}
+}
+
+func TestNewProbeSequence(t *testing.T) {
+	e := newEngine(t, "networks: [{cidr: 127.0.0.1/32}]\nports: [8080]\nprobe_sequence: {8080: [http, ssh]}\n")
+	if got := e.Fingerprinter.(*scanner.ZgrabFingerprinter).Fallback.ProbeSequence; !reflect.DeepEqual(got, map[int][]string{8080: {"http", "ssh"}}) {
+		t.Errorf("probe sequence %v", got)
+	}
+	_, err := New(loadConfig(t, "networks: [{cidr: 127.0.0.1/32}]\nports: [8080]\nprobe_sequence: {8080: [http, gopher]}\n"))
+	if err == nil || !strings.Contains(err.Error(), "gopher") {
+		t.Errorf("unknown probe: %v", err)
+	}
+}
+
+func TestNewNTLMInfo(t *testing.T) {
+	for yaml, want := range map[string]bool{"ntlm_info: true\n": true, "": false} {
+		e := newEngine(t, "networks: [{cidr: 127.0.0.1/32}]\nports: [80]\n"+yaml)
+		if got := e.Fingerprinter.(*scanner.ZgrabFingerprinter).Fallback.NTLMInfo; got != want {
+			t.Errorf("%q: NTLMInfo = %v, want %v", yaml, got, want)
+		}
+	}
+}
+
+func TestNewMultiProbe(t *testing.T) {
+	e := newEngine(t, "networks: [{cidr: 127.0.0.1/32}]\nports: [8443]\nmulti_probe: {8443: [https, http]}\n")
+	if got := e.Fingerprinter.(*scanner.ZgrabFingerprinter).Fallback.MultiProbe; !reflect.DeepEqual(got, map[int][]string{8443: {"https", "http"}}) {
+		t.Errorf("multi probe %v", got)
+	}
+	_, err := New(loadConfig(t, "networks: [{cidr: 127.0.0.1/32}]\nports: [8443]\nmulti_probe: {8443: [https, gopher]}\n"))
+	if err == nil || !strings.Contains(err.Error(), "multi_probe") {
+		t.Errorf("unknown probe: %v", err)
+	}
+}
+
+// progress records what a run passes to OnPortDone and OnHostFound
+type progress struct {
+	mu    sync.Mutex
+	done  map[int]int       // open hosts by port
+	found map[string]string // hostname by IP
+	again []string          // IPs reported more than once
+}
+
+func watchProgress(e *ScanEngine) *progress {
+	p := &progress{done: make(map[int]int), found: make(map[string]string)}
+	e.OnPortDone = func(port int, protocol string, open int) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if protocol == "tcp" {
+			p.done[port] = open
+		}
+	}
+	e.OnHostFound = func(ip, hostname string) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if _, ok := p.found[ip]; ok {
+			p.again = append(p.again, ip)
+		}
+		p.found[ip] = hostname
+	}
+	return p
+}
+
+func TestRunReportsProgress(t *testing.T) {
+	scan := &stubScanner{open: map[int][]scanner.ZmapResult{
+		22:  open(22, "10.0.0.1", "10.2.0.5"),
+		443: open(443, "10.0.0.1", "10.0.0.2"),
+	}}
+	e, _ := stubEngine(t, "networks: [{cidr: 10.0.0.0/24}]\nports: [22, 80, 443]\n", scan)
+	e.Config.Networks = append(e.Config.Networks, config.Network{CIDR: "10.2.0.5/32", Hostname: "db.internal"})
+	p := watchProgress(e)
+	if _, err := e.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Ports with open hosts are reported once each; 80 found none
+	if want := map[int]int{22: 2, 443: 2}; !reflect.DeepEqual(p.done, want) {
+		t.Errorf("ports completed %v, want %v", p.done, want)
+	}
+	// Each IP once, on the first port it was found open
+	want := map[string]string{"10.0.0.1": "", "10.0.0.2": "", "10.2.0.5": "db.internal"}
+	if !reflect.DeepEqual(p.found, want) || len(p.again) != 0 {
+		t.Errorf("hosts found %v (again: %v), want %v once each", p.found, p.again, want)
+	}
+}
+
+func TestRunReportsProgressAfterConfirmation(t *testing.T) {
+	steady := listen(t)
+	gone := closed(t)
+	scan := &stubScanner{open: map[int][]scanner.ZmapResult{
+		gone:   open(gone, "127.0.0.1"),
+		steady: open(steady, "127.0.0.1"),
+	}}
+	yaml := "networks: [127.0.0.0/24]\nports: [" + strconv.Itoa(gone) + ", " + strconv.Itoa(steady) + "]\ntimeout: 1\nconfirm_open: true\n"
+	e, _ := stubEngine(t, yaml, scan)
+	p := watchProgress(e)
+	if _, err := e.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The port that didn't accept again completes with no hosts, and the
+	// host is found on the one that did
+	if want := map[int]int{gone: 0, steady: 1}; !reflect.DeepEqual(p.done, want) {
+		t.Errorf("ports completed %v, want %v", p.done, want)
+	}
+	if want := map[string]string{"127.0.0.1": ""}; !reflect.DeepEqual(p.found, want) {
+		t.Errorf("hosts found %v, want %v", p.found, want)
+	}
+}
+
+func TestNewZgrabLimits(t *testing.T) {
+	e := newEngine(t, "networks: [{cidr: 127.0.0.1/32}]\nports: [80]\nzgrab_limits: {http: {max_headers: 20, max_body_bytes: 4096, drop_headers: [set-cookie]}}\n")
+	want := map[string]scanner.ZgrabLimits{"http": {MaxHeaders: 20, MaxBodyBytes: 4096, DropHeaders: []string{"set-cookie"}}}
+	if got := e.Fingerprinter.(*scanner.ZgrabFingerprinter).Limits; !reflect.DeepEqual(got, want) {
+		t.Errorf("limits %+v, want %+v", got, want)
+	}
+	_, err := New(loadConfig(t, "networks: [{cidr: 127.0.0.1/32}]\nports: [22]\nzgrab_limits: {ssh: {max_headers: 5}}\n"))
+	if err == nil || !strings.Contains(err.Error(), "zgrab_limits") {
+		t.Errorf("limits for ssh: %v", err)
+	}
+}
+
+func TestNewSourcePorts(t *testing.T) {
+	e := newEngine(t, "networks: [{cidr: 127.0.0.1/32}]\nports: [80]\nscanner_mode: tcp\nsource_ports: 40000-40100\n")
+	if got := e.Scanner.(*scanner.TCPScanner).SourcePorts; got == nil || got.Min != 40000 || got.Max != 40100 {
+		t.Errorf("source ports %+v, want 40000-40100", got)
+	}
+	e = newEngine(t, "networks: [{cidr: 127.0.0.1/32}]\nports: [80]\nscanner_mode: zmap\nsource_ports: 40000-40100\n")
+	if got := e.Scanner.(*scanner.ZmapScanner).SourcePorts; got != "40000-40100" {
+		t.Errorf("zmap source ports %q, want 40000-40100", got)
+	}
+	// New reports a range Validate would have refused
+	cfg := loadConfig(t, "networks: [{cidr: 127.0.0.1/32}]\nports: [80]\n")
+	cfg.SourcePorts = "40100-40000"
+	if _, err := New(cfg); err == nil || !strings.Contains(err.Error(), "source_ports") {
+		t.Errorf("reversed range: %v", err)
+	}
+}
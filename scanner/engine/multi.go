@@ -0,0 +1,48 @@
+package engine
+
+import (
+	"context"
+
+	"network-scanner/scanner"
+)
+
+// modeScanner is one scanner covering the networks assigned to its mode
+type modeScanner struct {
+	mode    string
+	scanner PortScanner
+}
+
+// multiScanner runs several PortScanners one after another, so networks
+// with different scanner modes can share one scan. Callbacks from all of
+// them arrive on the same callback; the open-port maps are merged.
+type multiScanner []modeScanner
+
+func (m multiScanner) ScanPortsWithCallback(ctx context.Context, ports []int, callback scanner.PortScanCallback) (map[string][]int, error) {
+	return m.each(ctx, func(s PortScanner) (map[string][]int, error) {
+		return s.ScanPortsWithCallback(ctx, ports, callback)
+	})
+}
+
+func (m multiScanner) ScanAllPortsWithCallback(ctx context.Context, callback scanner.PortScanCallback) (map[string][]int, error) {
+	return m.each(ctx, func(s PortScanner) (map[string][]int, error) {
+		return s.ScanAllPortsWithCallback(ctx, callback)
+	})
+}
+
+// each runs scan against every scanner in turn, stopping at the first error
+func (m multiScanner) each(ctx context.Context, scan func(PortScanner) (map[string][]int, error)) (map[string][]int, error) {
+	merged := make(map[string][]int)
+	for _, ms := range m {
+		if err := ctx.Err(); err != nil {
+			return merged, err
+		}
+		results, err := scan(ms.scanner)
+		for ip, ports := range results {
+			merged[ip] = append(merged[ip], ports...)
+		}
+		if err != nil {
+			return merged, err
+		}
+	}
+	return merged, nil
+}
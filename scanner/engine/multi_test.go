@@ -0,0 +1,99 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+
+	"network-scanner/scanner"
+)
+
+func TestNewMixedModeScanners(t *testing.T) {
+	e := newEngine(t, `
+scanner_mode: tcp
+networks:
+  - 10.0.0.0/24
+  - {cidr: 10.1.0.0/24, mode: zmap}
+  - {cidr: 10.2.0.0/24, mode: tcp}
+`)
+	multi, ok := e.Scanner.(multiScanner)
+	if !ok || len(multi) != 2 {
+		t.Fatalf("Scanner = %T, want a multiScanner over two modes", e.Scanner)
+	}
+	if e.ScannerName != "tcp+zmap" {
+		t.Errorf("ScannerName = %q", e.ScannerName)
+	}
+	tcp, ok := multi[0].scanner.(*scanner.TCPScanner)
+	if multi[0].mode != "tcp" || !ok || !reflect.DeepEqual(tcp.Networks, []string{"10.0.0.0/24", "10.2.0.0/24"}) {
+		t.Errorf("first scanner = %s %+v, want tcp over 10.0.0.0/24 and 10.2.0.0/24", multi[0].mode, multi[0].scanner)
+	}
+	zmap, ok := multi[1].scanner.(*scanner.ZmapScanner)
+	if multi[1].mode != "zmap" || !ok || !reflect.DeepEqual(zmap.Networks, []string{"10.1.0.0/24"}) {
+		t.Errorf("second scanner = %s %+v, want zmap over 10.1.0.0/24", multi[1].mode, multi[1].scanner)
+	}
+}
+
+func TestNewSingleModeScanner(t *testing.T) {
+	e := newEngine(t, "scanner_mode: zmap\nnetworks: [10.0.0.0/24, {cidr: 10.1.0.0/24, mode: zmap}]\n")
+	z, ok := e.Scanner.(*scanner.ZmapScanner)
+	if !ok || e.ScannerName != "zmap" || len(z.Networks) != 2 {
+		t.Errorf("Scanner = %T %q, want one zmap scanner over both networks", e.Scanner, e.ScannerName)
+	}
+}
+
+func TestMultiScannerMergesResults(t *testing.T) {
+	lan := &stubScanner{open: map[int][]scanner.ZmapResult{22: open(22, "192.168.1.5"), 80: open(80, "192.168.1.5")}}
+	routed := &stubScanner{open: map[int][]scanner.ZmapResult{22: open(22, "10.20.0.7")}}
+	multi := multiScanner{{"zmap", lan}, {"tcp", routed}}
+
+	var calls []string
+	found, err := multi.ScanPortsWithCallback(context.Background(), []int{22, 80}, func(port int, results []scanner.ZmapResult) {
+		for _, r := range results {
+			calls = append(calls, endpoint(r.IP, port))
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]int{"192.168.1.5": {22, 80}, "10.20.0.7": {22}}
+	if !reflect.DeepEqual(found, want) {
+		t.Errorf("found = %v, want %v", found, want)
+	}
+	sort.Strings(calls)
+	if !reflect.DeepEqual(calls, []string{"10.20.0.7:22", "192.168.1.5:22", "192.168.1.5:80"}) {
+		t.Errorf("callbacks = %v", calls)
+	}
+}
+
+func TestRunMixedModes(t *testing.T) {
+	e, fp := stubEngine(t, "networks: [10.0.0.0/24, {cidr: 10.1.0.0/24, mode: zmap}]\nports: [22]\n", nil)
+	e.Scanner = multiScanner{
+		{"tcp", &stubScanner{open: map[int][]scanner.ZmapResult{22: open(22, "10.0.0.1")}}},
+		{"zmap", &stubScanner{open: map[int][]scanner.ZmapResult{22: open(22, "10.1.0.1")}}},
+	}
+	batches, err := e.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := resultPorts(batches); !reflect.DeepEqual(got, map[string][]int{"10.0.0.1": {22}, "10.1.0.1": {22}}) {
+		t.Errorf("results = %v, want hosts from both scanners", got)
+	}
+	if len(fp.calls) != 2 {
+		t.Errorf("fingerprinted %v, want both hosts", fp.calls)
+	}
+}
+
+func TestMultiScannerStopsAtError(t *testing.T) {
+	second := &stubScanner{open: map[int][]scanner.ZmapResult{22: open(22, "10.1.0.1")}}
+	multi := multiScanner{{"tcp", &failingScanner{}}, {"zmap", second}}
+
+	_, err := multi.ScanPortsWithCallback(context.Background(), []int{22}, func(int, []scanner.ZmapResult) {})
+	if err == nil || errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want the first scanner's failure", err)
+	}
+	if len(second.scanned) != 0 {
+		t.Errorf("second scanner ran after the first failed")
+	}
+}
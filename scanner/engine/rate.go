@@ -0,0 +1,64 @@
+package engine
+
+import (
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	"network-scanner/scanner"
+)
+
+// autoRate picks the rate for a mode's scanner so that probes fit in
+// budget. zmap sends packets, so it needs probes/budget per second. A TCP
+// connect may hold its slot for the whole timeout when nothing answers,
+// so TCP needs probes×timeout/budget connections in flight. The result is
+// at least 1 and at most maxRate.
+func autoRate(mode string, probes float64, timeout, budget time.Duration, maxRate int) int {
+	rate := probes / budget.Seconds()
+	if mode != "zmap" {
+		rate *= timeout.Seconds()
+	}
+	if rate >= float64(maxRate) {
+		return max(maxRate, 1)
+	}
+	return max(int(math.Ceil(rate)), 1)
+}
+
+// scannerRate returns the configured rate, or with auto_rate the rate for
+// scanning networks within scan_time_budget
+func (e *ScanEngine) scannerRate(mode string, networks []string) int {
+	cfg := e.Config
+	if !cfg.AutoRate {
+		return cfg.Rate
+	}
+
+	var ips uint64
+	for _, network := range networks {
+		size, err := scanner.CIDRSize(network)
+		if err != nil {
+			continue // reported when the scanner expands it
+		}
+		if mode == "zmap" && strings.Contains(network, ":") {
+			size = min(size, uint64(cfg.MaxIPv6Targets)) // larger IPv6 networks are refused
+		}
+		ips = min(ips+size, 1<<62) // CIDRSize saturates there too
+	}
+	ports := len(e.Ports)
+	if cfg.ScanAllPorts {
+		ports = 65535
+	}
+	probes := float64(ips) * float64(ports)
+
+	timeout := time.Duration(cfg.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = 5 * time.Second // TCPScanner's default
+	}
+	rate := autoRate(mode, probes, timeout, cfg.ScanTimeBudget, cfg.MaxAutoRate())
+	unit := "concurrent connections"
+	if mode == "zmap" {
+		unit = "packets/s"
+	}
+	log.Printf("Auto rate for %s: %d %s (%d IPs x %d ports in %s)", mode, rate, unit, ips, ports, cfg.ScanTimeBudget)
+	return rate
+}
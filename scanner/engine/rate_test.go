@@ -0,0 +1,65 @@
+package engine
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAutoRate(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    string
+		probes  float64
+		timeout time.Duration
+		budget  time.Duration
+		maxRate int
+		want    int
+	}{
+		// /28 x 10 ports: 160 connects of up to 5s in 10 minutes
+		{"tcp small network", "tcp", 160, 5 * time.Second, 10 * time.Minute, 1000, 2},
+		// /24 x 100 ports
+		{"tcp medium network", "tcp", 25600, 5 * time.Second, 10 * time.Minute, 1000, 214},
+		{"tcp shorter timeout", "tcp", 25600, time.Second, 10 * time.Minute, 1000, 43},
+		{"tcp longer budget", "tcp", 25600, 5 * time.Second, time.Hour, 1000, 36},
+		// /16 x 10 ports would want 5462 connections
+		{"tcp large network clamped", "tcp", 655360, 5 * time.Second, 10 * time.Minute, 1000, 1000},
+		// zmap rates are packets/s and ignore the timeout
+		{"zmap large network", "zmap", 655360, 5 * time.Second, 10 * time.Minute, 10000, 1093},
+		{"zmap clamped", "zmap", 1 << 32, 5 * time.Second, 10 * time.Minute, 10000, 10000},
+		{"one probe", "zmap", 1, 5 * time.Second, time.Hour, 10000, 1},
+		{"no probes", "tcp", 0, 5 * time.Second, time.Hour, 1000, 1},
+		{"no ceiling", "tcp", 655360, 5 * time.Second, 10 * time.Minute, 0, 1},
+	}
+	for _, tt := range tests {
+		if got := autoRate(tt.mode, tt.probes, tt.timeout, tt.budget, tt.maxRate); got != tt.want {
+			t.Errorf("%s: autoRate = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestScannerRate(t *testing.T) {
+	tests := []struct {
+		name     string
+		yaml     string
+		mode     string
+		networks []string
+		want     int
+	}{
+		{"auto_rate off", "rate: 250\n", "tcp", []string{"10.0.0.0/16"}, 250},
+		// 256 IPs x 4 ports x 2s / 60s
+		{"tcp", "auto_rate: true\nscan_time_budget: 1m\ntimeout: 2\nrate: 500\n", "tcp", []string{"10.0.0.0/24"}, 35},
+		{"tcp across networks", "auto_rate: true\nscan_time_budget: 1m\ntimeout: 2\nrate: 500\n", "tcp", []string{"10.0.0.0/24", "10.1.0.0/24"}, 69},
+		{"tcp default timeout", "auto_rate: true\nscan_time_budget: 1m\nrate: 500\n", "tcp", []string{"10.0.0.0/24"}, 86},
+		{"clamped to max_rate", "auto_rate: true\nscan_time_budget: 1m\nrate: 100\nmax_rate: 5000\n", "tcp", []string{"10.0.0.0/16"}, 5000},
+		{"clamped to rate", "auto_rate: true\nscan_time_budget: 1m\nrate: 100\n", "tcp", []string{"10.0.0.0/16"}, 100},
+		// 65536 IPs x 4 ports / 600s
+		{"zmap", "auto_rate: true\nscan_time_budget: 10m\nmax_rate: 100000\n", "zmap", []string{"10.0.0.0/16"}, 437},
+		{"all ports", "auto_rate: true\nscan_time_budget: 1h\nmax_rate: 100000\nscan_all_ports: true\n", "zmap", []string{"10.0.0.0/24"}, 4661},
+	}
+	for _, tt := range tests {
+		e := newEngine(t, "networks: [10.0.0.0/24]\nports: [22, 80, 443, 8080]\n"+tt.yaml)
+		if got := e.scannerRate(tt.mode, tt.networks); got != tt.want {
+			t.Errorf("%s: scannerRate = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package engine
+
+import (
+	"context"
+	"log"
+	"net"
+	"time"
+
+	"github.com/google/uuid"
+
+	"network-scanner/db"
+	"network-scanner/scanner"
+)
+
+// Refingerprint re-runs only the fingerprinting phase against the TCP
+// endpoints in prior, skipping discovery, to refresh service names,
+// versions and banners, e.g. after a patch window. Results are emitted as
+// in Run. UDP endpoints are left alone: their probe is their discovery.
+func (e *ScanEngine) Refingerprint(ctx context.Context, prior db.Snapshot) ([]db.ScanResults, error) {
+	r := &run{
+		engine:        e,
+		scanID:        uuid.New(),
+		fingerprinter: scanner.NewFingerprintCache(e.Fingerprinter),
+		startedAt:     time.Now(),
+	}
+	log.Printf("Scan ID: %s", r.scanID)
+
+	var targets []scanner.ZmapResult
+	for key := range prior {
+		if key.Protocol != "tcp" || !e.Scope.Contains(net.ParseIP(key.IP)) {
+			continue
+		}
+		targets = append(targets, scanner.ZmapResult{IP: key.IP, Port: key.Port})
+	}
+	log.Printf("Re-fingerprinting %d previously open endpoints", len(targets))
+
+	if e.MACs != nil {
+		e.MACs.BeginScan()
+		defer func() { logMACChanges(e.MACs.EndScan()) }()
+	}
+	e.BodyBudget.Reset()
+	defer e.logBodyBudget()
+	e.ProbeStats.Reset()
+	defer e.ProbeStats.Log()
+
+	ports, byPort := scanner.GroupByPort(targets)
+	for _, port := range ports {
+		if err := e.Gate.Wait(ctx); err != nil {
+			return r.batches, err
+		}
+		r.fingerprintPort(ctx, port, byPort[port])
+	}
+	return r.batches, ctx.Err()
+}
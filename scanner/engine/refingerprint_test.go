@@ -0,0 +1,83 @@
+package engine
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"network-scanner/db"
+	"network-scanner/scanner"
+)
+
+// priorMap is a previous scan: two hosts with TCP services under old
+// names, a UDP endpoint and an excluded host
+func priorMap() db.Snapshot {
+	prior := db.Snapshot{}
+	prior.Add(db.ScanResultHost{IPAddress: "10.0.0.1", Ports: []db.ScanResultPort{
+		{PortNumber: 22, Protocol: "tcp", State: "open", ServiceName: "ssh", ServiceVersion: "OpenSSH_8.9"},
+		{PortNumber: 443, Protocol: "tcp", State: "open", ServiceName: "https"},
+		{PortNumber: 161, Protocol: "udp", State: "open", ServiceName: "snmp"},
+	}})
+	prior.Add(db.ScanResultHost{IPAddress: "10.0.0.2", Ports: []db.ScanResultPort{{PortNumber: 22, Protocol: "tcp", State: "open", ServiceName: "ssh"}}})
+	prior.Add(db.ScanResultHost{IPAddress: "10.9.0.1", Ports: []db.ScanResultPort{{PortNumber: 22, Protocol: "tcp", State: "open"}}})
+	return prior
+}
+
+func TestRefingerprintOnlyFingerprints(t *testing.T) {
+	// The scanner would report more; it must never be asked
+	scan := &stubScanner{open: map[int][]scanner.ZmapResult{22: open(22, "10.0.0.3"), 80: open(80, "10.0.0.1")}}
+	e, fp := stubEngine(t, "networks: [10.0.0.0/8]\nexclude_networks: [10.9.0.0/16]\nports: [22, 80, 443]\n", scan)
+
+	batches, err := e.Refingerprint(context.Background(), priorMap())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scan.scanned) != 0 {
+		t.Errorf("scanner ran on ports %v", scan.scanned)
+	}
+
+	wantCalls := map[string]int{"10.0.0.1:22": 1, "10.0.0.1:443": 1, "10.0.0.2:22": 1}
+	if !reflect.DeepEqual(fp.calls, wantCalls) {
+		t.Errorf("fingerprinted %v, want each prior TCP endpoint in scope once", fp.calls)
+	}
+	want := map[string][]int{"10.0.0.1": {22, 443}, "10.0.0.2": {22}}
+	if got := resultPorts(batches); !reflect.DeepEqual(got, want) {
+		t.Errorf("results = %v, want %v", got, want)
+	}
+
+	// Results carry the fresh service info, not the prior map's
+	for _, batch := range batches {
+		if batch.ScanID != batches[0].ScanID {
+			t.Error("batches carry different scan IDs")
+		}
+		for _, host := range batch.Hosts {
+			for _, port := range host.Ports {
+				if port.ServiceName != "svc-"+strconv.Itoa(port.PortNumber) || port.ServiceVersion != "" || port.State != "open" {
+					t.Errorf("%s:%d = %+v, want the refreshed service", host.IPAddress, port.PortNumber, port)
+				}
+			}
+		}
+	}
+}
+
+func TestRefingerprintEmptyPrior(t *testing.T) {
+	scan := &stubScanner{open: map[int][]scanner.ZmapResult{22: open(22, "10.0.0.1")}}
+	e, fp := stubEngine(t, "networks: [10.0.0.0/24]\nports: [22]\n", scan)
+	batches, err := e.Refingerprint(context.Background(), db.Snapshot{})
+	if err != nil || len(batches) != 0 || len(fp.calls) != 0 || len(scan.scanned) != 0 {
+		t.Errorf("Refingerprint(empty) = %d batches, %v; fingerprinted %v, scanned %v", len(batches), err, fp.calls, scan.scanned)
+	}
+}
+
+func TestRefingerprintCancelled(t *testing.T) {
+	e, fp := stubEngine(t, "networks: [10.0.0.0/24]\nports: [22]\n", &stubScanner{})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := e.Refingerprint(ctx, priorMap()); err != context.Canceled {
+		t.Errorf("Refingerprint(cancelled) = %v, want context.Canceled", err)
+	}
+	if len(fp.calls) != 0 {
+		t.Errorf("fingerprinted %v after cancellation", fp.calls)
+	}
+}
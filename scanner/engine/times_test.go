@@ -0,0 +1,95 @@
+package engine
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"network-scanner/db"
+	"network-scanner/scanner"
+)
+
+// checkTimes fails unless every batch has the same started_at, within
+// [before, after] and no later than its own completed_at, the batches
+// complete in order, and each expires ttl after it completes (never, for
+// ttl 0)
+func checkTimes(t *testing.T, batches []db.ScanResults, before, after time.Time, ttl time.Duration) {
+	t.Helper()
+	if len(batches) == 0 {
+		t.Fatal("no batches")
+	}
+	started := batches[0].StartedAt
+	if started.Before(before.Truncate(time.Microsecond)) || started.After(after) || started.Location() != time.UTC {
+		t.Errorf("started_at %v, want a UTC time during the scan (%v to %v)", started, before, after)
+	}
+	var last time.Time
+	for i, b := range batches {
+		if !b.StartedAt.Equal(started) {
+			t.Errorf("batch %d started_at %v, want the scan's %v", i, b.StartedAt, started)
+		}
+		if b.CompletedAt.Before(b.StartedAt) || b.CompletedAt.After(after) || b.CompletedAt.Before(last) {
+			t.Errorf("batch %d completed_at %v: not between started_at %v, the previous batch's %v and %v", i, b.CompletedAt, b.StartedAt, last, after)
+		}
+		last = b.CompletedAt
+		if b.StartedAt.Nanosecond()%1000 != 0 || b.CompletedAt.Nanosecond()%1000 != 0 {
+			t.Errorf("batch %d times %v, %v not truncated to the microsecond", i, b.StartedAt, b.CompletedAt)
+		}
+		switch {
+		case ttl == 0 && b.ExpiresAt != nil:
+			t.Errorf("batch %d expires_at %v without result_ttl", i, *b.ExpiresAt)
+		case ttl > 0 && (b.ExpiresAt == nil || !b.ExpiresAt.Equal(b.CompletedAt.Add(ttl))):
+			t.Errorf("batch %d expires_at %v, want completed_at %v + %s", i, b.ExpiresAt, b.CompletedAt, ttl)
+		}
+	}
+}
+
+func TestRunStampsTimes(t *testing.T) {
+	for yaml, ttl := range map[string]time.Duration{"": 0, "result_ttl: 48h\n": 48 * time.Hour} {
+		scan := &slowScanner{
+			stubScanner: stubScanner{open: map[int][]scanner.ZmapResult{22: open(22, "10.0.0.1"), 80: open(80, "10.0.0.2")}},
+			delay:       20 * time.Millisecond,
+		}
+		e, _ := stubEngine(t, "networks: [{cidr: 10.0.0.0/24}]\nports: [22, 80]\n"+yaml, &scan.stubScanner)
+		e.Scanner = scan
+
+		before := time.Now()
+		batches, err := e.Run(context.Background())
+		after := time.Now()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(batches) != 2 {
+			t.Fatalf("%d batches, want one per port", len(batches))
+		}
+		checkTimes(t, batches, before, after, ttl)
+		// The second port took another delay to discover
+		if gap := batches[1].CompletedAt.Sub(batches[0].CompletedAt); gap < 20*time.Millisecond {
+			t.Errorf("batches completed %s apart, want each stamped when it was", gap)
+		}
+	}
+}
+
+func TestRefingerprintStampsTimes(t *testing.T) {
+	e, _ := stubEngine(t, "networks: [10.0.0.0/8]\nexclude_networks: [10.9.0.0/16]\nports: [22, 80, 443]\nresult_ttl: 24h\n", &stubScanner{})
+	before := time.Now()
+	batches, err := e.Refingerprint(context.Background(), priorMap())
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTimes(t, batches, before, time.Now(), 24*time.Hour)
+}
+
+func TestVerifyStampsTimes(t *testing.T) {
+	prior := db.Snapshot{}
+	prior.Add(db.ScanResultHost{IPAddress: "127.0.0.1", Ports: []db.ScanResultPort{
+		{PortNumber: listen(t), Protocol: "tcp", State: "open"},
+		{PortNumber: closed(t), Protocol: "tcp", State: "open"},
+	}})
+	e := newEngine(t, "networks: [{cidr: 127.0.0.1/32}]\ntimeout: 1\nresult_ttl: 1h\n")
+	before := time.Now()
+	res, err := e.Verify(context.Background(), prior)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTimes(t, res.Batches, before, time.Now(), time.Hour)
+}
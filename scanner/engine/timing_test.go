@@ -0,0 +1,175 @@
+package engine
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"network-scanner/scanner"
+)
+
+// timingSlack is how far past its stub's sleep a phase may run on a busy
+// machine
+const timingSlack = 60 * time.Millisecond
+
+// checkTiming fails unless the phases add up to the total exactly and each
+// phase in want took at least as long as its stubs slept, within slack
+func checkTiming(t *testing.T, timing Timing, want map[string]time.Duration) {
+	t.Helper()
+	var sum time.Duration
+	for _, d := range timing.Phases {
+		sum += d
+	}
+	if sum != timing.Total {
+		t.Errorf("phases %v sum to %s, want the total %s", timing.Phases, sum, timing.Total)
+	}
+	for phase, min := range want {
+		if got := timing.Phases[phase]; got < min || got > min+timingSlack {
+			t.Errorf("%s took %s, want %s to %s", phase, got, min, min+timingSlack)
+		}
+	}
+}
+
+func TestPhaseTimerSequential(t *testing.T) {
+	timer := NewPhaseTimer()
+	for _, step := range []struct {
+		phase string
+		d     time.Duration
+	}{
+		{PhaseDiscovery, 50 * time.Millisecond},
+		{PhaseFingerprint, 30 * time.Millisecond},
+		{"", 20 * time.Millisecond}, // between phases
+		{PhaseSubmit, 40 * time.Millisecond},
+	} {
+		if step.phase == "" {
+			time.Sleep(step.d)
+			continue
+		}
+		end := timer.Begin(step.phase)
+		time.Sleep(step.d)
+		end()
+		end() // a second call leaves nothing twice
+	}
+	timing := timer.Stop()
+	checkTiming(t, timing, map[string]time.Duration{
+		PhaseDiscovery:   50 * time.Millisecond,
+		PhaseFingerprint: 30 * time.Millisecond,
+		PhaseSubmit:      40 * time.Millisecond,
+		PhaseOther:       20 * time.Millisecond,
+	})
+	if s := timing.String(); !strings.HasPrefix(s, "total ") || !strings.Contains(s, ": discovery ") || !strings.HasSuffix(s, "ms") {
+		t.Errorf("String() = %q", timing.String())
+	}
+}
+
+func TestPhaseTimerConcurrentOverlap(t *testing.T) {
+	timer := NewPhaseTimer()
+	endDiscovery := timer.Begin(PhaseDiscovery)
+	time.Sleep(20 * time.Millisecond)
+
+	// Eight fingerprint workers overlap each other and discovery, and one
+	// of them submits part way through
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer timer.Begin(PhaseFingerprint)()
+			if i == 0 {
+				time.Sleep(10 * time.Millisecond)
+				end := timer.Begin(PhaseSubmit)
+				time.Sleep(20 * time.Millisecond)
+				end()
+				time.Sleep(10 * time.Millisecond)
+				return
+			}
+			time.Sleep(40 * time.Millisecond)
+		}(i)
+	}
+	wg.Wait()
+	time.Sleep(30 * time.Millisecond)
+	endDiscovery()
+
+	// Overlapping time goes to the latest phase, counted once however many
+	// workers were in it
+	checkTiming(t, timer.Stop(), map[string]time.Duration{
+		PhaseDiscovery:   50 * time.Millisecond,
+		PhaseFingerprint: 20 * time.Millisecond,
+		PhaseSubmit:      20 * time.Millisecond,
+	})
+}
+
+func TestPhaseTimerNil(t *testing.T) {
+	var timer *PhaseTimer
+	timer.Begin(PhaseDiscovery)()
+	if timing := timer.Stop(); timing.Total != 0 || timing.Phases != nil {
+		t.Errorf("nil timer measured %+v", timing)
+	}
+}
+
+// slowScanner spends delay discovering each port before reporting it
+type slowScanner struct {
+	stubScanner
+	delay time.Duration
+}
+
+func (s *slowScanner) ScanPortsWithCallback(ctx context.Context, ports []int, callback scanner.PortScanCallback) (map[string][]int, error) {
+	found := make(map[string][]int)
+	for _, port := range ports {
+		time.Sleep(s.delay)
+		more, err := s.stubScanner.ScanPortsWithCallback(ctx, []int{port}, callback)
+		for ip, ports := range more {
+			found[ip] = append(found[ip], ports...)
+		}
+		if err != nil {
+			return found, err
+		}
+	}
+	return found, nil
+}
+
+// slowFingerprinter spends delay on each host
+type slowFingerprinter struct {
+	stubFingerprinter
+	delay time.Duration
+}
+
+func (f *slowFingerprinter) FingerprintHost(ctx context.Context, ip string, ports []int) map[int]scanner.ServiceInfo {
+	time.Sleep(f.delay)
+	return f.stubFingerprinter.FingerprintHost(ctx, ip, ports)
+}
+
+func TestRunTiming(t *testing.T) {
+	e := newEngine(t, "networks: [{cidr: 10.0.0.0/24}]\nports: [22, 80]\n")
+	e.Scanner = &slowScanner{
+		stubScanner: stubScanner{open: map[int][]scanner.ZmapResult{22: open(22, "10.0.0.1"), 80: open(80, "10.0.0.2")}},
+		delay:       40 * time.Millisecond,
+	}
+	e.Fingerprinter = &slowFingerprinter{delay: 30 * time.Millisecond}
+	// Submission as main does it
+	batches := 0
+	e.OnBatch = func(Batch) {
+		defer e.Timer.Begin(PhaseSubmit)()
+		batches++
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	e.Timer = NewPhaseTimer()
+	if _, err := e.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond) // after the scan, before Stop
+	timing := e.Timer.Stop()
+
+	if batches != 2 {
+		t.Fatalf("%d batches, want one per port", batches)
+	}
+	checkTiming(t, timing, map[string]time.Duration{
+		PhaseDiscovery:   2 * 40 * time.Millisecond,
+		PhaseFingerprint: 2 * 30 * time.Millisecond,
+		PhaseSubmit:      2 * 20 * time.Millisecond,
+		PhaseOther:       10 * time.Millisecond,
+	})
+}
@@ -0,0 +1,87 @@
+package engine
+
+import (
+	"context"
+	"log"
+	"net"
+	"time"
+
+	"github.com/google/uuid"
+
+	"network-scanner/db"
+	"network-scanner/scanner"
+)
+
+// VerifyResult reports which previously open endpoints still answer
+type VerifyResult struct {
+	StillOpen []db.EndpointKey
+	NowClosed []db.EndpointKey
+
+	// Batches holds the delta (now-closed endpoints, state "closed") unless
+	// OnBatch is set, in which case it receives them instead
+	Batches []db.ScanResults
+}
+
+// Verify re-checks exactly the TCP endpoints in prior with a plain connect,
+// skipping discovery and fingerprinting. Only endpoints that have closed are
+// emitted, so the submission is the delta against prior. UDP endpoints are
+// left alone since a silent UDP port can't be told from a closed one.
+func (e *ScanEngine) Verify(ctx context.Context, prior db.Snapshot) (VerifyResult, error) {
+	r := &run{engine: e, scanID: uuid.New(), startedAt: time.Now()}
+	log.Printf("Scan ID: %s", r.scanID)
+
+	var targets []scanner.ZmapResult
+	for key := range prior {
+		if key.Protocol != "tcp" {
+			continue
+		}
+		if !e.Scope.Contains(net.ParseIP(key.IP)) {
+			continue
+		}
+		targets = append(targets, scanner.ZmapResult{IP: key.IP, Port: key.Port})
+	}
+	log.Printf("Verifying %d previously open endpoints", len(targets))
+
+	checker := e.Checker
+	if checker == nil {
+		cfg := e.Config
+		tcp := scanner.NewTCPScanner(nil, cfg.Rate, cfg.Timeout)
+		tcp.Gate = e.Gate
+		tcp.Sockets = e.Sockets
+		tcp.SocketOptions = e.SocketOptions
+		checker = tcp
+	}
+
+	var res VerifyResult
+	closedByPort := make(map[int][]db.ScanResultHost)
+	endDiscovery := e.Timer.Begin(PhaseDiscovery)
+	err := checker.CheckEndpoints(ctx, targets, func(t scanner.ZmapResult, open bool) {
+		key := db.EndpointKey{IP: t.IP, Port: t.Port, Protocol: "tcp"}
+		if open {
+			res.StillOpen = append(res.StillOpen, key)
+			return
+		}
+		res.NowClosed = append(res.NowClosed, key)
+		closedByPort[t.Port] = append(closedByPort[t.Port], db.ScanResultHost{
+			IPAddress:     t.IP,
+			AddressFamily: db.AddressFamily(t.IP),
+			Ports: []db.ScanResultPort{{
+				PortNumber: t.Port,
+				Protocol:   "tcp",
+				State:      "closed",
+			}},
+		})
+	})
+	endDiscovery()
+
+	for port, hosts := range closedByPort {
+		for start := 0; start < len(hosts); start += r.batchSize() {
+			end := min(start+r.batchSize(), len(hosts))
+			r.emit(port, "tcp", hosts[start:end])
+		}
+	}
+
+	log.Printf("Verify: %d still open, %d now closed", len(res.StillOpen), len(res.NowClosed))
+	res.Batches = r.batches
+	return res, err
+}
@@ -0,0 +1,129 @@
+package engine
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"sort"
+	"testing"
+
+	"network-scanner/db"
+)
+
+// listen opens a loopback listener that accepts and closes connections
+// until the test ends, and returns its port
+func listen(t *testing.T) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// closed returns a loopback port nothing listens on
+func closed(t *testing.T) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+	return port
+}
+
+// sortKeys orders endpoint keys by port
+func sortKeys(keys []db.EndpointKey) []db.EndpointKey {
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Port < keys[j].Port })
+	return keys
+}
+
+func TestVerifyPriorResults(t *testing.T) {
+	stillOpen := []int{listen(t), listen(t)}
+	nowClosed := []int{closed(t), closed(t), closed(t)}
+
+	prior := db.Snapshot{}
+	for _, port := range append(append([]int{}, stillOpen...), nowClosed...) {
+		prior.Add(db.ScanResultHost{IPAddress: "127.0.0.1", Ports: []db.ScanResultPort{{PortNumber: port, Protocol: "tcp", State: "open", ServiceName: "ssh"}}})
+	}
+	// UDP endpoints are never re-checked
+	prior.Add(db.ScanResultHost{IPAddress: "127.0.0.1", Ports: []db.ScanResultPort{{PortNumber: 161, Protocol: "udp", State: "open"}}})
+
+	e := newEngine(t, "networks: [{cidr: 127.0.0.1/32}]\ntimeout: 1\n")
+	e.BatchSize = 2
+	res, err := e.Verify(context.Background(), prior)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wantOpen, wantClosed []db.EndpointKey
+	for _, port := range stillOpen {
+		wantOpen = append(wantOpen, db.EndpointKey{IP: "127.0.0.1", Port: port, Protocol: "tcp"})
+	}
+	for _, port := range nowClosed {
+		wantClosed = append(wantClosed, db.EndpointKey{IP: "127.0.0.1", Port: port, Protocol: "tcp"})
+	}
+	if got := sortKeys(res.StillOpen); !reflect.DeepEqual(got, sortKeys(wantOpen)) {
+		t.Errorf("StillOpen = %v, want %v", got, wantOpen)
+	}
+	if got := sortKeys(res.NowClosed); !reflect.DeepEqual(got, sortKeys(wantClosed)) {
+		t.Errorf("NowClosed = %v, want %v", got, wantClosed)
+	}
+
+	// The delta holds only the closed endpoints, one batch per port
+	if len(res.Batches) != len(nowClosed) {
+		t.Fatalf("delta has %d batches, want %d", len(res.Batches), len(nowClosed))
+	}
+	var delta []db.EndpointKey
+	for _, batch := range res.Batches {
+		if batch.ScanID != res.Batches[0].ScanID {
+			t.Error("delta batches carry different scan IDs")
+		}
+		for _, host := range batch.Hosts {
+			for _, port := range host.Ports {
+				if port.State != "closed" || port.ServiceName != "" {
+					t.Errorf("delta entry %+v, want a bare closed port", port)
+				}
+				delta = append(delta, db.EndpointKey{IP: host.IPAddress, Port: port.PortNumber, Protocol: port.Protocol})
+			}
+		}
+	}
+	if !reflect.DeepEqual(sortKeys(delta), sortKeys(wantClosed)) {
+		t.Errorf("delta = %v, want %v", delta, wantClosed)
+	}
+}
+
+func TestVerifyRespectsScope(t *testing.T) {
+	port := closed(t)
+	prior := db.Snapshot{}
+	prior.Add(db.ScanResultHost{IPAddress: "127.0.0.1", Ports: []db.ScanResultPort{{PortNumber: port, Protocol: "tcp", State: "open"}}})
+	prior.Add(db.ScanResultHost{IPAddress: "127.0.0.2", Ports: []db.ScanResultPort{{PortNumber: port, Protocol: "tcp", State: "open"}}})
+
+	e := newEngine(t, "networks: [{cidr: 127.0.0.0/30}]\nexclude_networks: [127.0.0.2/32]\ntimeout: 1\n")
+	res, err := e.Verify(context.Background(), prior)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.NowClosed) != 1 || res.NowClosed[0].IP != "127.0.0.1" || len(res.StillOpen) != 0 {
+		t.Errorf("result = %+v, want only 127.0.0.1 checked", res)
+	}
+}
+
+func TestVerifyEmptyPrior(t *testing.T) {
+	e := newEngine(t, "networks: [{cidr: 127.0.0.1/32}]\n")
+	res, err := e.Verify(context.Background(), db.Snapshot{})
+	if err != nil || len(res.StillOpen)+len(res.NowClosed)+len(res.Batches) != 0 {
+		t.Errorf("Verify(empty) = %+v, %v", res, err)
+	}
+}
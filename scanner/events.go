@@ -0,0 +1,212 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"network-scanner/engine"
+)
+
+// Defaults for events_buffer and events_max_clients
+const (
+	defaultEventBuffer     = 64
+	defaultMaxEventClients = 16
+)
+
+// eventKeepalive is how often an idle /events stream gets a comment line,
+// so proxies don't time it out
+const eventKeepalive = 15 * time.Second
+
+// Payloads of the /events stream, by event type
+
+type scanStartedEvent struct {
+	Time time.Time `json:"time"`
+}
+
+type portCompletedEvent struct {
+	Port      int    `json:"port"`
+	Protocol  string `json:"protocol"`
+	OpenHosts int    `json:"open_hosts"` // hosts with the port open, after confirmation
+}
+
+type hostFoundEvent struct {
+	IP       string `json:"ip"`
+	Hostname string `json:"hostname,omitempty"`
+}
+
+type scanCompletedEvent struct {
+	Time            time.Time `json:"time"`
+	ScanID          string    `json:"scan_id"`
+	Status          string    `json:"status"` // "completed" or "failed"; failures include aborts
+	DurationSeconds float64   `json:"duration_seconds"`
+}
+
+// eventPayloads documents each event type's data in /openapi.json
+type eventPayloads struct {
+	ScanStarted   scanStartedEvent   `json:"scan_started"`
+	PortCompleted portCompletedEvent `json:"port_completed"`
+	HostFound     hostFoundEvent     `json:"host_found"`
+	ScanCompleted scanCompletedEvent `json:"scan_completed"`
+}
+
+// sseEvent is one encoded Server-Sent Event
+type sseEvent struct {
+	id   uint64
+	name string
+	data []byte
+}
+
+// eventHub fans scan events out to /events clients. Each client has a
+// bounded buffer; one that falls behind is dropped rather than allowed
+// to stall the scan, and may reconnect. A nil *eventHub publishes nothing.
+type eventHub struct {
+	buffer     int
+	maxClients int
+
+	mu      sync.Mutex
+	clients map[chan sseEvent]struct{}
+	nextID  uint64
+}
+
+// newEventHub creates a hub with buffer events per client and at most
+// maxClients clients; zero values take the defaults
+func newEventHub(buffer, maxClients int) *eventHub {
+	return &eventHub{
+		buffer:     cmp.Or(buffer, defaultEventBuffer),
+		maxClients: cmp.Or(maxClients, defaultMaxEventClients),
+		clients:    make(map[chan sseEvent]struct{}),
+	}
+}
+
+// subscribe registers a client. The channel is closed when the client is
+// dropped for falling behind or unsubscribes.
+func (h *eventHub) subscribe() (chan sseEvent, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.clients) >= h.maxClients {
+		return nil, false
+	}
+	ch := make(chan sseEvent, h.buffer)
+	h.clients[ch] = struct{}{}
+	return ch, true
+}
+
+func (h *eventHub) unsubscribe(ch chan sseEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.clients[ch]; ok {
+		delete(h.clients, ch)
+		close(ch)
+	}
+}
+
+// publish sends an event to every client without blocking
+func (h *eventHub) publish(name string, payload interface{}) {
+	if h == nil {
+		return
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Warning: failed to encode %s event: %v", name, err)
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.nextID++
+	event := sseEvent{id: h.nextID, name: name, data: data}
+	for ch := range h.clients {
+		select {
+		case ch <- event:
+		default:
+			delete(h.clients, ch)
+			close(ch)
+			log.Printf("Dropped an /events client that fell %d events behind", h.buffer)
+		}
+	}
+}
+
+// follow publishes port_completed and host_found as e makes progress
+func (h *eventHub) follow(e *engine.ScanEngine) {
+	e.OnPortDone = func(port int, protocol string, open int) {
+		h.publish("port_completed", portCompletedEvent{Port: port, Protocol: protocol, OpenHosts: open})
+	}
+	e.OnHostFound = func(ip, hostname string) {
+		h.publish("host_found", hostFoundEvent{IP: ip, Hostname: hostname})
+	}
+}
+
+func (h *eventHub) scanStarted(at time.Time) {
+	h.publish("scan_started", scanStartedEvent{Time: at.UTC()})
+}
+
+// scanCompleted publishes the end of the scan begun at started, failed
+// unless succeeded
+func (h *eventHub) scanCompleted(scanID uuid.UUID, started time.Time, succeeded bool) {
+	status := "failed"
+	if succeeded {
+		status = "completed"
+	}
+	now := time.Now()
+	h.publish("scan_completed", scanCompletedEvent{
+		Time:            now.UTC(),
+		ScanID:          scanID.String(),
+		Status:          status,
+		DurationSeconds: now.Sub(started).Seconds(),
+	})
+}
+
+// handleEvents streams scan events as Server-Sent Events until the client
+// disconnects or falls behind
+func (s *controlServer) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSON(w, http.StatusInternalServerError, actionResponse{Status: "error", Message: "Streaming is not supported"})
+		return
+	}
+	ch, ok := s.events.subscribe()
+	if !ok {
+		writeJSON(w, http.StatusServiceUnavailable, actionResponse{
+			Status:  "too_many_clients",
+			Message: fmt.Sprintf("At most %d /events clients may connect", s.events.maxClients),
+		})
+		return
+	}
+	defer s.events.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // nginx would otherwise buffer the stream
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	keepalive := time.NewTicker(eventKeepalive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case event, open := <-ch:
+			if !open {
+				return // dropped for falling behind
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.id, event.name, event.data); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
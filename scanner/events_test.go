@@ -0,0 +1,388 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"network-scanner/config"
+	"network-scanner/engine"
+	"network-scanner/scanner"
+)
+
+// eventStub finds fixed hosts open per port, waiting on release before
+// each port so the test controls the pace of the scan
+type eventStub struct {
+	open    map[int][]string
+	release chan struct{}
+}
+
+func (s *eventStub) ScanPortsWithCallback(ctx context.Context, ports []int, callback scanner.PortScanCallback) (map[string][]int, error) {
+	found := make(map[string][]int)
+	for _, port := range ports {
+		select {
+		case <-s.release:
+		case <-ctx.Done():
+			return found, ctx.Err()
+		}
+		var results []scanner.ZmapResult
+		for _, ip := range s.open[port] {
+			results = append(results, scanner.ZmapResult{IP: ip, Port: port})
+			found[ip] = append(found[ip], port)
+		}
+		if len(results) > 0 {
+			callback(port, results)
+		}
+	}
+	return found, nil
+}
+
+func (s *eventStub) ScanAllPortsWithCallback(ctx context.Context, callback scanner.PortScanCallback) (map[string][]int, error) {
+	return s.ScanPortsWithCallback(ctx, nil, callback)
+}
+
+func (s *eventStub) FingerprintHost(ctx context.Context, ip string, ports []int) map[int]scanner.ServiceInfo {
+	results := make(map[int]scanner.ServiceInfo, len(ports))
+	for _, port := range ports {
+		results[port] = scanner.ServiceInfo{ServiceName: "svc-" + strconv.Itoa(port)}
+	}
+	return results
+}
+
+// eventEngine builds an engine scanning ports with stub, publishing to hub
+func eventEngine(t *testing.T, hub *eventHub, stub *eventStub, ports string) *engine.ScanEngine {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("networks: [{cidr: 10.0.0.0/24}]\nports: ["+ports+"]\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	e, err := engine.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(e.Close)
+	e.Scanner = stub
+	e.Fingerprinter = stub
+	hub.follow(e)
+	return e
+}
+
+// streamedEvent is one event as an /events client parses it
+type streamedEvent struct {
+	id   string
+	name string
+	data string
+}
+
+// eventStream connects to /events and sends each event it reads on the
+// channel, which is closed when the server ends the stream
+func eventStream(t *testing.T, url string) chan streamedEvent {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url+"/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		resp.Body.Close()
+		t.Fatalf("GET /events = %d %q, want an event stream", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	r := bufio.NewReader(resp.Body)
+	if line, err := r.ReadString('\n'); err != nil || line != ": connected\n" {
+		t.Fatalf("stream starts %q, %v, want the connected comment", line, err)
+	}
+	events := make(chan streamedEvent, 64)
+	go func() {
+		defer resp.Body.Close()
+		defer close(events)
+		var event streamedEvent
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			field, value, _ := strings.Cut(strings.TrimSuffix(line, "\n"), ": ")
+			switch field {
+			case "id":
+				event.id = value
+			case "event":
+				event.name = value
+			case "data":
+				event.data = value
+			case "":
+				if event.name != "" {
+					events <- event
+				}
+				event = streamedEvent{}
+			}
+		}
+	}()
+	return events
+}
+
+// next returns the next event from the stream, failing after a second
+func next(t *testing.T, events chan streamedEvent) streamedEvent {
+	t.Helper()
+	select {
+	case event, ok := <-events:
+		if !ok {
+			t.Fatal("stream ended")
+		}
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("no event within a second")
+	}
+	return streamedEvent{}
+}
+
+// decode unmarshals an event's data into v, checking its type
+func decode(t *testing.T, event streamedEvent, name string, v interface{}) {
+	t.Helper()
+	if event.name != name {
+		t.Fatalf("event %q %s, want %s", event.name, event.data, name)
+	}
+	if err := json.Unmarshal([]byte(event.data), v); err != nil {
+		t.Fatalf("%s data %q: %v", name, event.data, err)
+	}
+}
+
+func TestEventsDuringScan(t *testing.T) {
+	healthy := true
+	s := newTestServer(t, &config.Config{}, stubAPI(t, &healthy).URL)
+	s.events = newEventHub(0, 0)
+	server := httptest.NewServer(s.routes())
+	t.Cleanup(server.Close)
+	stub := &eventStub{
+		open:    map[int][]string{22: {"10.0.0.1", "10.0.0.2"}, 80: {"10.0.0.2", "10.0.0.3"}},
+		release: make(chan struct{}),
+	}
+	e := eventEngine(t, s.events, stub, "22, 80, 443")
+
+	// Two clients watch the same scan
+	first := eventStream(t, server.URL)
+	second := eventStream(t, server.URL)
+
+	started := time.Now()
+	done := make(chan uuid.UUID, 1)
+	go func() {
+		// As runScan does it
+		s.events.scanStarted(started)
+		batches, err := e.Run(context.Background())
+		scanID := uuid.Nil
+		if len(batches) > 0 {
+			scanID = batches[0].ScanID
+		}
+		s.events.scanCompleted(scanID, started, err == nil)
+		done <- scanID
+	}()
+
+	var start scanStartedEvent
+	decode(t, next(t, first), "scan_started", &start)
+	if !start.Time.Equal(started.UTC()) {
+		t.Errorf("scan_started time %s, want %s", start.Time, started.UTC())
+	}
+
+	// Each port's events arrive as it completes, before the next is scanned
+	for _, step := range []struct {
+		port  int
+		hosts []string // found for the first time on this port
+	}{
+		{22, []string{"10.0.0.1", "10.0.0.2"}},
+		{80, []string{"10.0.0.3"}},
+	} {
+		stub.release <- struct{}{}
+		found := make(map[string]bool)
+		for range step.hosts {
+			var host hostFoundEvent
+			decode(t, next(t, first), "host_found", &host)
+			found[host.IP] = true
+		}
+		for _, ip := range step.hosts {
+			if !found[ip] {
+				t.Errorf("port %d: host_found for %v, want %v", step.port, found, step.hosts)
+			}
+		}
+		var port portCompletedEvent
+		decode(t, next(t, first), "port_completed", &port)
+		want := portCompletedEvent{Port: step.port, Protocol: "tcp", OpenHosts: len(stub.open[step.port])}
+		if port != want {
+			t.Errorf("port_completed %+v, want %+v", port, want)
+		}
+	}
+
+	// 443 has no open hosts, so nothing to report before the scan ends
+	stub.release <- struct{}{}
+	var end scanCompletedEvent
+	decode(t, next(t, first), "scan_completed", &end)
+	scanID := <-done
+	if end.Status != "completed" || end.ScanID != scanID.String() || scanID == uuid.Nil || end.DurationSeconds <= 0 || end.Time.Before(started) {
+		t.Errorf("scan_completed %+v, want completed for scan %s", end, scanID)
+	}
+
+	// The second client got the same events with the same IDs
+	var ids, names []string
+	for i := 0; i < 7; i++ {
+		event := next(t, second)
+		ids = append(ids, event.id)
+		names = append(names, event.name)
+	}
+	if want := "scan_started host_found host_found port_completed host_found port_completed scan_completed"; strings.Join(names, " ") != want {
+		t.Errorf("second client events %v, want %s", names, want)
+	}
+	for i, id := range ids {
+		if id != strconv.Itoa(i+1) {
+			t.Errorf("event %d has id %q, want ids counting from 1", i, id)
+		}
+	}
+}
+
+func TestEventsScanFailed(t *testing.T) {
+	hub := newEventHub(0, 0)
+	ch, _ := hub.subscribe()
+	defer hub.unsubscribe(ch)
+	stub := &eventStub{release: make(chan struct{})}
+	e := eventEngine(t, hub, stub, "22")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel() // an abort before the first port
+	started := time.Now()
+	hub.scanStarted(started)
+	_, err := e.Run(ctx)
+	hub.scanCompleted(uuid.Nil, started, err == nil)
+
+	var names []string
+	var end scanCompletedEvent
+	for len(ch) > 0 {
+		event := <-ch
+		names = append(names, event.name)
+		if event.name == "scan_completed" {
+			json.Unmarshal(event.data, &end)
+		}
+	}
+	if names[0] != "scan_started" || names[len(names)-1] != "scan_completed" || end.Status != "failed" {
+		t.Errorf("events %v ending %+v, want scan_started first and a failed scan_completed last", names, end)
+	}
+}
+
+func TestEventHubDropsSlowClient(t *testing.T) {
+	hub := newEventHub(2, 0)
+	slow, _ := hub.subscribe()
+	fast, _ := hub.subscribe()
+	defer hub.unsubscribe(fast)
+
+	// fast keeps up and slow reads nothing; the third event overflows slow
+	for i := 1; i <= 4; i++ {
+		hub.publish("host_found", hostFoundEvent{IP: "10.0.0." + strconv.Itoa(i)})
+		if event := <-fast; event.id != uint64(i) || event.name != "host_found" || !strings.Contains(string(event.data), `"10.0.0.`+strconv.Itoa(i)+`"`) {
+			t.Errorf("fast client got %d %s %s", event.id, event.name, event.data)
+		}
+	}
+	var ids []uint64
+	for event := range slow { // closed when dropped
+		ids = append(ids, event.id)
+	}
+	if len(ids) != 2 || ids[0] != 1 || ids[1] != 2 {
+		t.Errorf("slow client got ids %v, want the 2 buffered before it was dropped", ids)
+	}
+	hub.unsubscribe(slow) // already dropped; harmless
+
+	hub.mu.Lock()
+	clients := len(hub.clients)
+	hub.mu.Unlock()
+	if clients != 1 {
+		t.Errorf("%d clients, want only the fast one left", clients)
+	}
+}
+
+func TestEventsDropsSlowStream(t *testing.T) {
+	healthy := true
+	s := newTestServer(t, &config.Config{}, stubAPI(t, &healthy).URL)
+	s.events = newEventHub(1, 0)
+	server := httptest.NewServer(s.routes())
+	t.Cleanup(server.Close)
+	events := eventStream(t, server.URL)
+
+	// Publishing far faster than a client can drain a buffer of one drops
+	// it, ending its stream
+	for i := 0; i < 10000; i++ {
+		s.events.publish("host_found", hostFoundEvent{IP: "10.0.0.1"})
+	}
+	deadline := time.After(2 * time.Second)
+	for {
+		select {
+		case _, ok := <-events:
+			if !ok {
+				return
+			}
+		case <-deadline:
+			t.Fatal("slow client still connected")
+		}
+	}
+}
+
+func TestEventsMaxClients(t *testing.T) {
+	healthy := true
+	s := newTestServer(t, &config.Config{}, stubAPI(t, &healthy).URL)
+	s.events = newEventHub(0, 1)
+	server := httptest.NewServer(s.routes())
+	t.Cleanup(server.Close)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/events", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	// Only one client at a time; a second is turned away
+	var refused actionResponse
+	if code := get(t, s.routes(), "/events", &refused); code != http.StatusServiceUnavailable || refused.Status != "too_many_clients" {
+		t.Errorf("second client = %d %+v, want too_many_clients", code, refused)
+	}
+
+	// Once the first disconnects its slot is free
+	cancel()
+	for i := 0; ; i++ {
+		s.events.mu.Lock()
+		clients := len(s.events.clients)
+		s.events.mu.Unlock()
+		if clients == 0 {
+			break
+		}
+		if i == 100 {
+			t.Fatal("disconnected client still subscribed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	eventStream(t, server.URL)
+}
+
+func TestEventHubNil(t *testing.T) {
+	var hub *eventHub
+	hub.publish("scan_started", scanStartedEvent{}) // publishes nothing
+	hub.scanStarted(time.Now())
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net/http"
+
+	"network-scanner/db"
+)
+
+// handleExportSTIX serves the last completed scan as a STIX 2.1 bundle of
+// observed-data (see db.ExportSTIX for the mapping)
+func (s *controlServer) handleExportSTIX(w http.ResponseWriter, r *http.Request) {
+	var hosts []db.ScanResultHost
+	scanTime, ok, err := s.results.each(func(host db.ScanResultHost) error {
+		hosts = append(hosts, host)
+		return nil
+	})
+	if !ok {
+		writeJSON(w, http.StatusNotFound, actionResponse{
+			Status:  "no_results",
+			Message: "No scan has completed yet",
+		})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, actionResponse{Status: "error", Message: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Disposition", `attachment; filename="scan-results.stix.json"`)
+	writeJSON(w, http.StatusOK, db.ExportSTIX(hosts, scanTime))
+}
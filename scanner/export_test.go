@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"network-scanner/config"
+)
+
+func TestExportSTIXEndpoint(t *testing.T) {
+	healthy := true
+	s := newTestServer(t, &config.Config{}, stubAPI(t, &healthy).URL)
+	s.results = &lastResults{}
+	handler := s.routes()
+
+	var resp actionResponse
+	if code := get(t, handler, "/export/stix", &resp); code != http.StatusNotFound || resp.Status != "no_results" {
+		t.Errorf("before any scan: %d %+v, want 404 no_results", code, resp)
+	}
+
+	storeResults(t, s, knownHosts(), 3)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/export/stix", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Header().Get("Content-Disposition"), "scan-results.stix.json") {
+		t.Fatalf("GET /export/stix = %d, headers %v", rec.Code, rec.Header())
+	}
+	var bundle struct {
+		Type    string
+		Objects []struct {
+			Type          string
+			FirstObserved string `json:"first_observed"`
+		}
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &bundle); err != nil {
+		t.Fatal(err)
+	}
+	observations := 0
+	for _, obj := range bundle.Objects {
+		if obj.Type == "observed-data" {
+			observations++
+			if obj.FirstObserved != "2026-10-14T09:30:00.000Z" {
+				t.Errorf("first_observed = %q, want the scan time", obj.FirstObserved)
+			}
+		}
+	}
+	if bundle.Type != "bundle" || observations != 10 {
+		t.Errorf("bundle %q with %d observations, want one per host", bundle.Type, observations)
+	}
+}
@@ -1,31 +1,53 @@
 package main
 
 import (
+	"cmp"
 	"context"
-	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
 	"log"
-	"net/http"
+	"maps"
+	"math/rand/v2"
+	"net"
+	"net/url"
 	"os"
 	"os/signal"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/robfig/cron/v3"
 
 	"network-scanner/config"
 	"network-scanner/db"
-	"network-scanner/scanner"
+	"network-scanner/engine"
 )
 
 var (
 	scanMutex    sync.Mutex
 	isScanning   bool
 	lastScanTime time.Time
+
+	// Cancels the running scan for POST /scan/abort-all; nil when idle
+	cancelScan context.CancelFunc
+
+	// Phase breakdown of the last scan, with scan_timing
+	lastScanTiming *engine.Timing
+
+	// Readiness state reported by /readyz
+	configValid atomic.Bool
+	apiReady    atomic.Bool
 )
 
 func main() {
+	targets := flag.String("targets", "", "scan the targets in this file (one CIDR, IP or hostname per line; - for stdin) once and exit")
+	flag.Parse()
+
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 	log.Println("Network Scanner starting...")
 
@@ -42,217 +64,709 @@ func main() {
 	if apiURL := os.Getenv("API_URL"); apiURL != "" {
 		cfg.APIURL = apiURL
 	}
+	if listenAddr := os.Getenv("LISTEN_ADDR"); listenAddr != "" {
+		cfg.ListenAddr = listenAddr
+	}
+	if token := os.Getenv("CONTROL_AUTH_TOKEN"); token != "" {
+		cfg.ControlAuthToken = token
+	}
+	if key := os.Getenv("RESULT_HMAC_KEY"); key != "" {
+		cfg.ResultHMACKey = key
+	}
+	// A configured database implies the offline source
+	if cfg.GeoIPSource == "" && cfg.GeoIPDB != "" {
+		cfg.GeoIPSource = "maxmind"
+	}
+	if cfg.ListenAddr == "" {
+		cfg.ListenAddr = ":8081"
+	}
+
+	// -targets replaces the configured networks for a one-shot scan
+	oneShot := *targets != ""
+	if oneShot {
+		source := *targets
+		if source == "-" {
+			source = "stdin"
+		}
+		networks, err := readTargets(*targets)
+		if err != nil {
+			log.Fatalf("Failed to read targets from %s: %v", source, err)
+		}
+		if len(networks) == 0 {
+			log.Printf("No targets on %s; nothing to scan", source)
+			return
+		}
+		log.Printf("One-shot scan of %d targets from %s", len(networks), source)
+		cfg.Networks = networks
+		cfg.TargetsFile = ""
+	}
 
+	// Scanning with part of the configuration ignored could probe outside
+	// scan_window or submit fields and banners it should have dropped
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	configValid.Store(true)
+
+	build := buildInfo()
+	log.Printf("Network scanner %s (commit %s, built %s, %s)", build.Version, build.Commit, build.BuildDate, build.GoVersion)
 	log.Printf("Configuration loaded:")
 	log.Printf("  Networks: %v", cfg.Networks)
+	if len(cfg.AllowNetworks) > 0 {
+		log.Printf("  Allow networks: %v", cfg.AllowNetworks)
+	}
+	if len(cfg.ExcludeNetworks) > 0 {
+		log.Printf("  Exclude networks: %v", cfg.ExcludeNetworks)
+	}
+	if cfg.TargetsFile != "" {
+		log.Printf("  Targets file: %s", cfg.TargetsFile)
+	}
 	log.Printf("  Scan all ports: %v", cfg.ScanAllPorts)
+	if cfg.ScanAllPorts && cfg.AllPortsBatchSize > 0 {
+		log.Printf("  All-ports batch size: %d", cfg.AllPortsBatchSize)
+	}
 	if !cfg.ScanAllPorts {
-		log.Printf("  Ports: %v", cfg.Ports)
+		if cfg.TopPorts > 0 {
+			log.Printf("  Top ports: %d", cfg.TopPorts)
+		} else if len(cfg.Ports) > 64 {
+			log.Printf("  Ports: %d ports (%d-%d)", len(cfg.Ports), cfg.Ports[0], cfg.Ports[len(cfg.Ports)-1])
+		} else {
+			log.Printf("  Ports: %v", cfg.Ports)
+		}
+	}
+	if len(cfg.UDPPorts) > 0 {
+		log.Printf("  UDP ports: %v", cfg.UDPPorts)
 	}
 	log.Printf("  Schedule: %s", cfg.Schedule)
+	if cfg.ScheduleJitter > 0 {
+		log.Printf("  Schedule jitter: up to %s", cfg.ScheduleJitter)
+	}
+	window, err := cfg.ScanTimeWindow()
+	if err != nil {
+		log.Fatalf("Failed to set up scan window: %v", err)
+	}
+	if window != nil {
+		log.Printf("  Scan window: %s", window)
+	}
+	if cfg.Watch.Enabled() {
+		log.Printf("  Watch: %d targets x %d ports on %q, changes to %s", len(cfg.Watch.Targets), len(cfg.Watch.Ports), cfg.Watch.Schedule, cfg.Watch.WebhookURL)
+	}
 	log.Printf("  Scanner mode: %s", cfg.ScannerMode)
 	log.Printf("  Rate: %d", cfg.Rate)
+	if cfg.AutoRate {
+		log.Printf("  Auto rate: fit the port scan in %s, at most %d", cfg.ScanTimeBudget, cfg.MaxAutoRate())
+	}
+	if cfg.ScannerMode == "zmap" && cfg.ZmapOutputDir != "" {
+		log.Printf("  Zmap output dir: %s", cfg.ZmapOutputDir)
+	}
+	if cfg.ScannerMode == "zmap" && cfg.ZmapFlushInterval > 0 {
+		log.Printf("  Zmap flush interval: %s", cfg.ZmapFlushInterval)
+	}
+	if cfg.ScannerMode == "zmap" && cfg.ZmapRetries > 0 {
+		log.Printf("  Zmap retries: %d", cfg.ZmapRetries)
+	}
+	log.Printf("  Parallel ports: %v", cfg.ParallelPorts)
+	if cfg.InterleaveNetworks {
+		log.Printf("  Interleave networks: enabled (tcp mode)")
+	}
+	if cfg.ConfirmOpen {
+		log.Printf("  Confirm open ports: enabled (banner read: %v)", cfg.ConfirmBanner)
+	}
+	log.Printf("  Fingerprint concurrency: %d", cfg.FingerprintConcurrency)
+	if cfg.PerHostProbeConcurrency > 0 {
+		log.Printf("  Per-host probe concurrency: %d", cfg.PerHostProbeConcurrency)
+	}
+	if cfg.ScanJitterMS.Max > 0 {
+		log.Printf("  Scan jitter: %d-%dms", cfg.ScanJitterMS.Min, cfg.ScanJitterMS.Max)
+	}
 	log.Printf("  Timeout: %ds", cfg.Timeout)
 	log.Printf("  Interface: %s", cfg.Interface)
+	if cfg.SourceIP != "" {
+		log.Printf("  Source IP: %s", cfg.SourceIP)
+	}
+	if cfg.SourcePorts != "" {
+		log.Printf("  Source ports: %s", cfg.SourcePorts)
+	}
+	if cfg.ScanLingerZero || cfg.ScanDisableKeepAlive {
+		log.Printf("  Scan sockets: linger 0 %v, keepalive off %v", cfg.ScanLingerZero, cfg.ScanDisableKeepAlive)
+	}
 	log.Printf("  API URL: %s", cfg.APIURL)
+	if cfg.S3Bucket != "" {
+		log.Printf("  S3 bucket: %s; results are not submitted to the API", cfg.S3Bucket)
+		if cfg.S3Endpoint != "" {
+			log.Printf("  S3 endpoint: %s", cfg.S3Endpoint)
+		}
+	}
+	if cfg.ESBulkURL != "" {
+		esURL, err := url.Parse(cfg.ESBulkURL)
+		if err != nil {
+			// Unwrapped, the error leaves out the URL and any credentials in it
+			log.Fatalf("Invalid es_bulk_url: %v", errors.Unwrap(err))
+		}
+		log.Printf("  Elasticsearch bulk: %s (index %s)", esURL.Redacted(), cmp.Or(cfg.ESIndex, db.DefaultESIndex))
+	}
+	log.Printf("  Listen address: %s", cfg.ListenAddr)
+	log.Printf("  Control TLS: %v", cfg.ControlTLSCert != "")
+	log.Printf("  Control auth: %v", cfg.ControlAuthToken != "")
+	log.Printf("  Result signing: %v", cfg.ResultHMACKey != "")
+	if cfg.PolicyFile != "" {
+		log.Printf("  Exposure policy: %s", cfg.PolicyFile)
+	}
+	if cfg.CapturePcap != "" {
+		log.Printf("  Packet capture: %s (privileged)", cfg.CapturePcap)
+	}
+	if cfg.RecordFixtures != "" {
+		log.Printf("  Recording probe fixtures to %s (native fingerprinting only)", cfg.RecordFixtures)
+	}
+	if cfg.ReplayFixtures != "" {
+		log.Printf("  Replaying probe fixtures from %s (no network probes)", cfg.ReplayFixtures)
+	}
+	if cfg.DoHURL != "" {
+		log.Printf("  DNS-over-HTTPS: %s", cfg.DoHURL)
+	}
+	if cfg.ReverseDNS {
+		log.Printf("  Reverse DNS: enabled")
+	}
+	if cfg.ResolveMAC {
+		log.Printf("  MAC resolution: enabled (flags MAC changes)")
+	}
+	if cfg.GeoIPSource != "" {
+		log.Printf("  Geo enrichment: %s", cfg.GeoIPSource)
+	}
+	if cfg.SMTPRelayTest {
+		log.Printf("  SMTP relay test: ENABLED (MAIL FROM/RCPT TO external addresses on ports 25/587)")
+	}
+	if cfg.FTPAnonTest {
+		log.Printf("  FTP anonymous login test: ENABLED")
+	}
+	if cfg.DNSAXFRTest {
+		log.Printf("  DNS AXFR test: ENABLED (zone transfers on TCP 53)")
+	}
+	if cfg.MetadataTest {
+		log.Printf("  Cloud metadata test: ENABLED (metadata index requests via HTTP services)")
+	}
+	if cfg.NTLMInfo {
+		log.Printf("  NTLM info: ENABLED (negotiate messages to HTTP services offering NTLM)")
+	}
+	if len(cfg.ProxyProtocolPorts) > 0 {
+		log.Printf("  PROXY protocol: v%d header on ports %v", max(cfg.ProxyProtocolVersion, 1), []int(cfg.ProxyProtocolPorts))
+	}
+	if cfg.StoreRawZgrab {
+		log.Printf("  Store raw zgrab2 output: enabled")
+	}
+	for _, module := range slices.Sorted(maps.Keys(cfg.ZgrabLimits)) {
+		limit := cfg.ZgrabLimits[module]
+		log.Printf("  zgrab2 %s limits: %d headers, %d body bytes, dropping %v", module, limit.MaxHeaders, limit.MaxBodyBytes, limit.DropHeaders)
+	}
+	if cfg.FastFingerprint {
+		log.Printf("  Fast fingerprint: enabled (timeout %s)", cfg.FastFingerprintTimeout)
+	}
+	if cfg.WebSocketDetect {
+		log.Printf("  WebSocket detection: enabled")
+	}
+	if cfg.LBDetectSamples > 1 {
+		log.Printf("  Load balancer detection: %d samples", cfg.LBDetectSamples)
+	}
+	if cfg.GracefulClose {
+		log.Printf("  Graceful close: enabled")
+	}
+	if cfg.StateFile != "" {
+		log.Printf("  State file: %s (verify only: %v, compressed: %v)", cfg.StateFile, cfg.VerifyOnly, cfg.StateCompress)
+		if cfg.RefingerprintOnly {
+			log.Printf("  Re-fingerprint only: scans refresh services on the endpoints in the state file")
+		}
+		if cfg.FullScanInterval > 1 {
+			log.Printf("  Full scan interval: every %d runs; the others verify the endpoints in the state file", cfg.FullScanInterval)
+		}
+		if cfg.StateRetentionDays > 0 {
+			log.Printf("  State retention: %d days", cfg.StateRetentionDays)
+		}
+	}
+	if cfg.SubmitOnlyChanges {
+		log.Printf("  Submit only changes: full resync every %s", cfg.FullResyncInterval)
+	}
+	if cfg.RetryEmptyScans > 0 {
+		log.Printf("  Retry empty scans: %d times, %s apart", cfg.RetryEmptyScans, cfg.RetryEmptyDelay)
+	}
+	if cfg.ScanTiming {
+		log.Printf("  Scan timing: enabled")
+	}
+	if len(cfg.SubmitFields) > 0 {
+		log.Printf("  Submitted port fields: %s", strings.Join(cfg.SubmitFields, ", "))
+	}
+	if len(cfg.Tags) > 0 {
+		log.Printf("  Tags: %v", cfg.Tags)
+	}
+	if cfg.ReportFile != "" {
+		log.Printf("  Report file: %s (Markdown summary after each scan)", cfg.ReportFile)
+	}
+	if cfg.ResultTTL > 0 {
+		log.Printf("  Result TTL: %s (expires_at on every submission)", cfg.ResultTTL)
+	}
 
-	// Create scanner components based on mode
-	var zmapScanner *scanner.ZmapScanner
-	var tcpScanner *scanner.TCPScanner
-	useZmap := cfg.ScannerMode == "zmap"
+	scanEngine, err := engine.New(cfg)
+	if err != nil {
+		log.Fatalf("Failed to set up scanner: %v", err)
+	}
+	defer scanEngine.Close()
+	if scanEngine.Sockets != nil {
+		log.Printf("  Max open sockets: %d", scanEngine.Sockets.Limit())
+	}
+	if cfg.MaxTotalBodyBytes > 0 {
+		log.Printf("  Max total body bytes per scan: %d", cfg.MaxTotalBodyBytes)
+	}
 
-	if useZmap {
-		zmapScanner = scanner.NewZmapScanner(cfg.Networks, cfg.Rate, cfg.Timeout)
-		if cfg.Interface != "" {
-			zmapScanner.Interface = cfg.Interface
-		}
-	} else {
-		tcpScanner = scanner.NewTCPScanner(cfg.Networks, cfg.Rate, cfg.Timeout)
+	apiClient := db.NewAPIClientWithOptions(cfg.APIURL, db.TransportOptions{
+		MaxIdleConnsPerHost: cfg.APIMaxIdleConns,
+		IdleConnTimeout:     cfg.APIIdleTimeout,
+		RequestTimeout:      cfg.APIRequestTimeout,
+	})
+	if cfg.ResultHMACKey != "" {
+		apiClient.HMACKey = []byte(cfg.ResultHMACKey)
 	}
+	defer apiClient.Close()
 
-	fingerprinter := scanner.NewZgrabFingerprinter()
-	apiClient := db.NewAPIClient(cfg.APIURL)
+	var s3Sink *db.S3Sink
+	if cfg.S3Bucket != "" {
+		if s3Sink, err = db.NewS3Sink(context.Background(), cfg.S3Bucket, cfg.S3Endpoint); err != nil {
+			log.Fatalf("Failed to set up S3 sink: %v", err)
+		}
+		if cfg.ResultHMACKey != "" {
+			s3Sink.HMACKey = []byte(cfg.ResultHMACKey)
+		}
+	}
 
-	// Wait for API to be ready
-	log.Println("Waiting for API to be ready...")
-	for i := 0; i < 30; i++ {
-		if err := apiClient.HealthCheck(); err == nil {
-			log.Println("API is ready")
-			break
+	var esSink *db.ESBulkSink
+	if cfg.ESBulkURL != "" {
+		if esSink, err = db.NewESBulkSink(cfg.ESBulkURL, cfg.ESIndex); err != nil {
+			log.Fatalf("Failed to set up Elasticsearch sink: %v", err)
 		}
-		time.Sleep(2 * time.Second)
 	}
 
-	// Create the scan function
-	runScan := func() {
-		scanMutex.Lock()
-		if isScanning {
-			scanMutex.Unlock()
-			log.Println("Scan already in progress, skipping...")
+	var changeFilter *db.ChangeFilter
+	if cfg.SubmitOnlyChanges {
+		changeFilter = db.NewChangeFilter(cfg.FullResyncInterval)
+	}
+	var fieldFilter *db.FieldFilter
+	if len(cfg.SubmitFields) > 0 {
+		if fieldFilter, err = db.NewFieldFilter(cfg.SubmitFields); err != nil {
+			log.Fatalf("Invalid submit_fields: %v", err)
+		}
+	}
+	stateFile := &db.StateFile{
+		Path:      cfg.StateFile,
+		Compress:  cfg.StateCompress,
+		Retention: time.Duration(cfg.StateRetentionDays) * 24 * time.Hour,
+	}
+	cycles := fullScanCycle{interval: cfg.FullScanInterval, state: stateFile}
+
+	// Open endpoints seen by the current full scan, spilling to disk past
+	// max_results_in_memory. When the scan completes they become the last
+	// results served by the control server and are saved to state_file.
+	// Only touched from runScan, which never overlaps.
+	var scanState *db.Spool
+	lastScan := &lastResults{}
+	if cfg.PolicyFile != "" {
+		if lastScan.policy, err = config.LoadPolicy(cfg.PolicyFile); err != nil {
+			log.Fatalf("Failed to load policy file %s: %v", cfg.PolicyFile, err)
+		}
+	}
+	redactions, err := cfg.RedactionRules()
+	if err != nil {
+		log.Fatalf("Failed to set up banner redactions: %v", err)
+	}
+	processors, err := newProcessorChain(cfg.ResultProcessorNames(), processorDeps{
+		redactor: db.NewRedactor(redactions),
+		geo:      scanEngine.Geo,
+		policy:   lastScan.policy,
+	})
+	if err != nil {
+		log.Fatalf("Failed to set up result processors: %v", err)
+	}
+	if processors.Len() > 0 {
+		log.Printf("  Result processors: %s", strings.Join(processors.Names(), ", "))
+	}
+	verifying := false
+	var scanTags map[string]string // tags of the running scan
+	var scanID uuid.UUID           // ID of the running scan, from its batches
+	var scanStarted time.Time      // start of the running scan
+
+	// Progress for GET /events
+	events := newEventHub(cfg.EventsBuffer, cfg.EventsMaxClients)
+	events.follow(scanEngine)
+
+	// Submit each batch as soon as it is produced
+	scanEngine.OnBatch = func(batch engine.Batch) {
+		defer scanEngine.Timer.Begin(engine.PhaseSubmit)()
+
+		results := batch.Results
+		scanID = results.ScanID
+		portLabel := fmt.Sprintf("port %d", batch.Port)
+		if batch.Protocol == "udp" {
+			portLabel = "UDP " + portLabel
+		}
+		if err := processors.Process(&results); err != nil {
+			log.Printf("Dropping %d results for %s: %v", len(results.Hosts), portLabel, err)
 			return
 		}
-		isScanning = true
-		scanMutex.Unlock()
+		if scanState != nil {
+			for _, host := range results.Hosts {
+				if err := scanState.Add(host); err != nil {
+					log.Printf("Warning: failed to record result for %s: %v", host.IPAddress, err)
+				}
+			}
+		}
+		results.Tags = scanTags
+		// Elasticsearch gets every batch in full, alongside the API or S3
+		if esSink != nil {
+			if err := esSink.Write(&results, time.Now()); err != nil {
+				log.Printf("Failed to send %d results for %s to Elasticsearch: %v", len(results.Hosts), portLabel, err)
+			}
+		}
+		// The S3 sink writes the whole scan once it completes
+		if s3Sink != nil {
+			return
+		}
+		// A verify delta is already minimal and must not disturb the baseline
+		if changeFilter != nil && !verifying {
+			var hosts []db.ScanResultHost
+			for _, host := range results.Hosts {
+				if host, changed := changeFilter.Filter(host); changed {
+					hosts = append(hosts, host)
+				}
+			}
+			results.Hosts = hosts
+		}
+		if len(results.Hosts) == 0 {
+			return
+		}
+		if fieldFilter != nil {
+			results.Hosts = fieldFilter.ApplyHosts(results.Hosts)
+		}
+
+		if err := apiClient.SubmitResults(&results); err != nil {
+			log.Printf("Failed to submit %d results for %s: %v", len(results.Hosts), portLabel, err)
+		} else {
+			log.Printf("Submitted %d results for %s", len(results.Hosts), portLabel)
+		}
+	}
 
-		defer func() {
-			scanMutex.Lock()
-			isScanning = false
-			lastScanTime = time.Now()
-			scanMutex.Unlock()
-		}()
+	// waitForAPI waits up to a minute for the API, then logs the startup
+	// diagnostics. In daemon mode the control server is already serving,
+	// so /healthz answers and /readyz reports 503 meanwhile.
+	diag := newDiagnostics(cfg, apiClient)
+	waitForAPI := func() {
+		log.Println("Waiting for API to be ready...")
+		for i := 0; i < 30 && s3Sink == nil; i++ {
+			if err := apiClient.HealthCheck(); err == nil {
+				log.Println("API is ready")
+				apiReady.Store(true)
+				break
+			}
+			time.Sleep(2 * time.Second)
+		}
+		logReport(diag.run())
+	}
 
-		log.Println("Starting network scan...")
-		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Hour)
+	// storeScan hands a completed scan's results to the S3 sink, if any
+	storeScan := func(spool *db.Spool, scanTime time.Time) {
+		if s3Sink == nil {
+			return
+		}
+		defer scanEngine.Timer.Begin(engine.PhaseSubmit)()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
 		defer cancel()
+		header := db.ScanResults{ScanID: scanID, Tags: scanTags}
+		header.SetTimes(scanStarted, scanTime, cfg.ResultTTL)
+		key, err := s3Sink.WriteScan(ctx, header, spool)
+		if err != nil {
+			log.Printf("Failed to store results in S3: %v", err)
+			return
+		}
+		log.Printf("Stored %d results in s3://%s/%s", spool.Len(), cfg.S3Bucket, key)
+	}
 
-		scanID := uuid.New()
-		log.Printf("Scan ID: %s", scanID)
+	// completeScan makes spool the last results: stored in S3, checked
+	// against the policy and summarized in report_file
+	completeScan := func(spool *db.Spool, scanTime time.Time) {
+		storeScan(spool, scanTime)
+		if cfg.ReportFile == "" {
+			lastScan.set(spool, scanTime)
+			return
+		}
+		previous, err := lastScan.snapshot()
+		if err != nil {
+			log.Printf("Warning: report omits changes: %v", err)
+			previous = nil
+		}
+		lastScan.set(spool, scanTime)
+
+		report := scanReport{
+			ScanID:    scanID,
+			StartedAt: scanStarted,
+			ScanTime:  scanTime,
+			Current:   make(db.Snapshot),
+			Previous:  previous,
+			Policy:    lastScan.policy != nil,
+		}
+		report.Violations, _, _ = lastScan.policyViolations()
+		report.Shared = lastScan.sharedIdentities()
+		// spool belongs to lastScan now, so it is read through it
+		if _, _, err := lastScan.each(func(host db.ScanResultHost) error {
+			report.Current.Add(host)
+			return nil
+		}); err != nil {
+			log.Printf("Failed to write report: %v", err)
+			return
+		}
+		if err := writeReport(cfg.ReportFile, report); err != nil {
+			log.Printf("Failed to write report: %v", err)
+			return
+		}
+		log.Printf("Wrote scan report to %s", cfg.ReportFile)
+	}
 
-		scannerName := "tcp"
-		if useZmap {
-			scannerName = "zmap"
+	// Create the scan function. tags override the configured tags for this
+	// scan only.
+	runScan := func(tags map[string]string) {
+		ctx, endScan, ok := beginScan(2 * time.Hour)
+		if !ok {
+			log.Println("Scan already in progress, skipping...")
+			return
+		}
+		defer endScan()
+
+		if cfg.ScanTiming {
+			scanEngine.Timer = engine.NewPhaseTimer()
+			defer func() {
+				timing := scanEngine.Timer.Stop()
+				scanEngine.Timer = nil
+				log.Printf("Scan timing: %s", timing)
+				scanMutex.Lock()
+				lastScanTiming = &timing
+				scanMutex.Unlock()
+			}()
 		}
 
-		// Callback to fingerprint and submit results immediately after each port scan
-		submitResults := func(port int, results []scanner.ZmapResult) {
-			if len(results) == 0 {
+		scanTags = mergeTags(cfg.Tags, tags)
+		scanID = uuid.New() // replaced by the engine's ID once batches arrive
+		log.Println("Starting network scan...")
+		scanStarted = time.Now()
+		events.scanStarted(scanStarted)
+		succeeded := false
+		defer func() { events.scanCompleted(scanID, scanStarted, succeeded) }()
+
+		// With full_scan_interval, runs between full sweeps only verify
+		quick, cycle := cycles.quick()
+
+		if cfg.VerifyOnly || quick {
+			prior, err := stateFile.Load()
+			if err != nil {
+				log.Printf("Verify failed: %v", err)
 				return
 			}
-
-			log.Printf("Port %d: fingerprinting %d hosts", port, len(results))
-
-			hosts := make([]db.ScanResultHost, 0, len(results))
-			for _, r := range results {
-				// Fingerprint this single port on this host
-				serviceInfo := fingerprinter.FingerprintHost(ctx, r.IP, []int{r.Port})
-
-				portResult := db.ScanResultPort{
-					PortNumber: r.Port,
-					Protocol:   "tcp",
-					State:      "open",
+			if len(prior) > 0 {
+				verifying = true
+				res, err := scanEngine.Verify(ctx, prior)
+				verifying = false
+				if err != nil {
+					log.Printf("Verify completed with error: %v", err)
+					return
+				}
+				for _, key := range res.NowClosed {
+					delete(prior, key)
 				}
+				if err := stateFile.Save(prior); err != nil {
+					log.Printf("Failed to update state file: %v", err)
+				}
+				if quick {
+					cycles.record(true, cycle)
+				}
+				current := db.NewSpool(cfg.SpillDir, cfg.MaxResultsInMemory)
+				for _, host := range prior.HostResults() {
+					current.Add(host)
+				}
+				completeScan(current, time.Now())
+				succeeded = true
+				log.Println("Verify completed successfully")
+				return
+			}
+			log.Printf("No prior results in %s; running a full scan first", cfg.StateFile)
+		}
 
-				if info, ok := serviceInfo[r.Port]; ok {
-					portResult.ServiceName = info.ServiceName
-					portResult.ServiceVersion = info.ServiceVersion
-					portResult.Banner = info.Banner
-					portResult.FingerprintData = info.Fingerprint
+		if cfg.RefingerprintOnly {
+			prior, err := stateFile.Load()
+			if err != nil {
+				log.Printf("Re-fingerprint failed: %v", err)
+				return
+			}
+			if len(prior) > 0 {
+				refreshed := db.NewSpool(cfg.SpillDir, cfg.MaxResultsInMemory)
+				scanState = refreshed
+				// Only known TCP endpoints are covered, so the baseline is
+				// merged into as after a partial scan
+				if changeFilter != nil {
+					changeFilter.BeginScan()
+				}
+				_, err := scanEngine.Refingerprint(ctx, prior)
+				if changeFilter != nil {
+					changeFilter.EndScan(false)
+				}
+				scanState = nil
+				if err != nil {
+					refreshed.Close()
+					log.Printf("Re-fingerprint completed with error: %v", err)
+					return
 				}
 
-				hosts = append(hosts, db.ScanResultHost{
-					IPAddress: r.IP,
-					Ports:     []db.ScanResultPort{portResult},
+				// Endpoints that gave no fresh result keep their old details
+				refreshed.Each(func(host db.ScanResultHost) error {
+					prior.Add(host)
+					return nil
 				})
+				refreshed.Close()
+				if err := stateFile.Save(prior); err != nil {
+					log.Printf("Failed to update state file: %v", err)
+				}
+				current := db.NewSpool(cfg.SpillDir, cfg.MaxResultsInMemory)
+				for _, host := range prior.HostResults() {
+					current.Add(host)
+				}
+				completeScan(current, time.Now())
+				succeeded = true
+				log.Println("Re-fingerprint completed successfully")
+				return
 			}
+			log.Printf("No prior results in %s; running a full scan first", cfg.StateFile)
+		}
 
-			scanResults := &db.ScanResults{
-				ScanID: scanID,
-				Hosts:  hosts,
-			}
+		spool := db.NewSpool(cfg.SpillDir, cfg.MaxResultsInMemory)
+		scanState = spool
+		defer func() { scanState = nil }()
 
-			if err := apiClient.SubmitResults(scanResults); err != nil {
-				log.Printf("Failed to submit %d results for port %d: %v", len(hosts), port, err)
+		if changeFilter != nil {
+			if changeFilter.BeginScan() {
+				log.Println("Submitting all results (full resync)")
 			} else {
-				log.Printf("Submitted %d results for port %d", len(hosts), port)
+				log.Println("Submitting only new or changed results")
 			}
 		}
 
-		ports := cfg.Ports
-		if len(ports) == 0 {
-			ports = scanner.CommonPorts()
-		}
-
-		var scanErr error
+		scanErr := retryEmptyScan(ctx, func(ctx context.Context) error {
+			_, err := scanEngine.Run(ctx)
+			return err
+		}, spool.Len, cfg.RetryEmptyScans, cfg.RetryEmptyDelay)
 
-		if cfg.ScanAllPorts {
-			log.Printf("Scanning ALL ports (1-65535) on networks %v using %s", cfg.Networks, scannerName)
-			if useZmap {
-				_, scanErr = zmapScanner.ScanAllPortsWithCallback(ctx, submitResults)
-			} else {
-				_, scanErr = tcpScanner.ScanAllPortsWithCallback(ctx, submitResults)
-			}
-		} else {
-			log.Printf("Scanning %d ports on networks %v using %s", len(ports), cfg.Networks, scannerName)
-			if useZmap {
-				_, scanErr = zmapScanner.ScanPortsWithCallback(ctx, ports, submitResults)
-			} else {
-				_, scanErr = tcpScanner.ScanPortsWithCallback(ctx, ports, submitResults)
-			}
+		// A partial scan can't show which endpoints went away, so it only
+		// adds to the baseline
+		if changeFilter != nil {
+			changeFilter.EndScan(scanErr == nil)
 		}
 
 		if scanErr != nil {
+			spool.Close()
 			log.Printf("Scan completed with error: %v", scanErr)
+			if hint := scanErrorHint(scanErr); hint != "" {
+				log.Printf("Hint: %s", hint)
+			}
 			return
 		}
 
+		if n := spool.Spilled(); n > 0 {
+			log.Printf("%d of %d results were spilled to disk", n, spool.Len())
+		}
+		if cfg.StateFile != "" {
+			if err := stateFile.SaveSpool(spool); err != nil {
+				log.Printf("Failed to save state file: %v", err)
+			}
+		}
+		cycles.record(false, 0)
+		completeScan(spool, time.Now())
+
+		succeeded = true
 		log.Println("Scan completed successfully")
 	}
 
-	// Set up HTTP server for triggering scans
-	http.HandleFunc("/trigger", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
-		}
-
-		scanMutex.Lock()
-		scanning := isScanning
-		scanMutex.Unlock()
+	if oneShot {
+		waitForAPI()
+		runScan(nil)
+		return
+	}
 
-		if scanning {
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(map[string]interface{}{
-				"status":  "already_running",
-				"message": "Scan already in progress",
-			})
-			return
+	// Start HTTP server. Bind synchronously so a bad or busy address fails at startup.
+	if err := config.ValidateListenAddr(cfg.ListenAddr); err != nil {
+		log.Fatalf("Invalid control server address: %v", err)
+	}
+	if (cfg.ControlTLSCert == "") != (cfg.ControlTLSKey == "") {
+		log.Fatalf("Invalid control server TLS config: control_tls_cert and control_tls_key must be set together")
+	}
+	listener, err := net.Listen("tcp", cfg.ListenAddr)
+	if err != nil {
+		log.Fatalf("Failed to bind HTTP server on %s: %v", cfg.ListenAddr, err)
+	}
+	// Scheduled scans start after the initial one; POST /schedule may
+	// replace the schedule at any time
+	var scheduleState *db.StateFile
+	if cfg.StateFile != "" {
+		scheduleState = stateFile
+	}
+	// The startup and scheduled scans only run inside scan_window
+	scheduledScan := func(which string) {
+		if inScanWindow(window, which, time.Now()) {
+			runScan(nil)
 		}
+	}
+	sched, err := newScheduler(cfg.Schedule, jittered(cfg.ScheduleJitter, func() { scheduledScan("scheduled") }), scheduleState)
+	if err != nil {
+		log.Fatalf("Failed to set up cron: %v", err)
+	}
 
-		// Start scan in background
-		go runScan()
+	server := &controlServer{
+		cfg:       cfg,
+		apiClient: apiClient,
+		runScan:   runScan,
+		pauseGate: scanEngine.Gate,
+		diag:      diag,
+		results:   lastScan,
 
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"status":  "started",
-			"message": "Scan started",
-		})
-	})
+		fingerprinter: scanEngine.Fingerprinter,
+		scope:         scanEngine.Scope,
 
-	http.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
-		scanMutex.Lock()
-		scanning := isScanning
-		lastScan := lastScanTime
-		scanMutex.Unlock()
+		probeStats: scanEngine.ProbeStats,
+		events:     events,
 
-		w.Header().Set("Content-Type", "application/json")
-		response := map[string]interface{}{
-			"is_scanning": scanning,
-		}
-		if !lastScan.IsZero() {
-			response["last_scan_time"] = lastScan.Format(time.RFC3339)
-		}
-		json.NewEncoder(w).Encode(response)
-	})
+		scheduler: sched,
+		window:    window,
 
-	// Start HTTP server
+		scanMode:        scanEngine.ScannerName,
+		fingerprintMode: scanEngine.FingerprintMode(),
+	}
 	go func() {
-		log.Println("Starting HTTP server on :8081")
-		if err := http.ListenAndServe(":8081", nil); err != nil {
+		log.Printf("Starting HTTP server on %s", listener.Addr())
+		if err := server.serve(listener); err != nil {
 			log.Printf("HTTP server error: %v", err)
 		}
 	}()
+	go server.checkAPI(context.Background(), apiCheckInterval)
+
+	waitForAPI()
 
 	// Run initial scan
-	runScan()
+	scheduledScan("initial")
 
-	// Set up cron scheduler
-	c := cron.New()
-	_, err = c.AddFunc(cfg.Schedule, runScan)
-	if err != nil {
-		log.Fatalf("Failed to set up cron: %v", err)
+	sched.Start()
+	log.Printf("Scheduled scans with interval: %s", sched.Spec())
+
+	var watch *watcher
+	if cfg.Watch.Enabled() {
+		watch, err = newWatcher(cfg, scanEngine.Scope)
+		if err != nil {
+			log.Fatalf("Failed to set up watch mode: %v", err)
+		}
+		watch.Start()
+		log.Printf("Watching %d endpoints with interval: %s", len(watch.targets), cfg.Watch.Schedule)
 	}
-	c.Start()
-	log.Printf("Scheduled scans with interval: %s", cfg.Schedule)
 
 	// Wait for shutdown signal
 	sigChan := make(chan os.Signal, 1)
@@ -260,7 +774,118 @@ func main() {
 	<-sigChan
 
 	log.Println("Shutting down...")
-	c.Stop()
+	sched.Stop()
+	if watch != nil {
+		watch.Stop()
+	}
+}
+
+// beginScan marks a scan as running and returns its context, cancelled by
+// POST /scan/abort-all or after timeout, and the func that marks it
+// finished. ok is false when a scan is already running.
+func beginScan(timeout time.Duration) (ctx context.Context, end func(), ok bool) {
+	scanMutex.Lock()
+	defer scanMutex.Unlock()
+	if isScanning {
+		return nil, nil, false
+	}
+	isScanning = true
+	// Set together with isScanning so an abort can never see a running
+	// scan it is unable to cancel
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	cancelScan = cancel
+
+	return ctx, func() {
+		scanMutex.Lock()
+		isScanning = false
+		cancelScan = nil
+		lastScanTime = time.Now()
+		scanMutex.Unlock()
+		cancel()
+	}, true
+}
+
+// inScanWindow reports whether the startup or scheduled scan named which
+// may start at now, logging the skip when it is outside window
+func inScanWindow(window *config.TimeWindow, which string, now time.Time) bool {
+	if window.Contains(now) {
+		return true
+	}
+	log.Printf("Skipping %s scan: outside scan_window %s", which, window)
+	return false
+}
+
+// jittered wraps a cron job to start after a random delay in [0, max).
+// cron calls the wrapper on every tick, so each delay starts from the tick
+// itself and the schedule never drifts.
+func jittered(max time.Duration, job func()) func() {
+	if max <= 0 {
+		return job
+	}
+	return func() {
+		delay := rand.N(max)
+		log.Printf("Scheduled scan starts in %s (schedule_jitter)", delay.Round(time.Second))
+		time.Sleep(delay)
+		job()
+	}
+}
+
+// retryEmptyScan runs scan and, while it succeeds without finding any
+// hosts (e.g. because the network wasn't up yet at boot), runs it again up
+// to retries times, delay apart
+func retryEmptyScan(ctx context.Context, scan func(ctx context.Context) error, found func() int, retries int, delay time.Duration) error {
+	err := scan(ctx)
+	for attempt := 1; err == nil && found() == 0 && attempt <= retries; attempt++ {
+		log.Printf("Scan found no hosts; retrying in %s (%d/%d)", delay, attempt, retries)
+		select {
+		case <-time.After(delay):
+			err = scan(ctx)
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+	return err
+}
+
+// mergeTags returns defaults with overrides applied on top, or nil when
+// both are empty
+func mergeTags(defaults, overrides map[string]string) map[string]string {
+	if len(defaults) == 0 && len(overrides) == 0 {
+		return nil
+	}
+	merged := make(map[string]string, len(defaults)+len(overrides))
+	for key, value := range defaults {
+		merged[key] = value
+	}
+	for key, value := range overrides {
+		merged[key] = value
+	}
+	return merged
+}
+
+// readTargets reads -targets networks from path, or stdin for "-".
+// Malformed lines are logged and skipped.
+func readTargets(path string) ([]config.Network, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	lookup := func(ctx context.Context, host string) ([]string, error) {
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+		return net.DefaultResolver.LookupHost(ctx, host)
+	}
+	networks, skipped, err := config.ReadNetworks(context.Background(), r, lookup)
+	for _, reason := range skipped {
+		log.Printf("Warning: skipping target: %s", reason)
+	}
+	return networks, err
 }
 
 func getEnv(key, defaultValue string) string {
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/robfig/cron/v3"
+
+	"network-scanner/config"
+	"network-scanner/db"
+)
+
+// bootingNetwork is a scan that finds nothing for the first empty runs,
+// as if the network were still coming up, and a host after that
+type bootingNetwork struct {
+	empty int
+	spool *db.Spool
+	runs  int
+}
+
+func (n *bootingNetwork) scan(ctx context.Context) error {
+	n.runs++
+	if n.runs > n.empty {
+		return n.spool.Add(db.ScanResultHost{IPAddress: "10.0.0.1", Ports: []db.ScanResultPort{{PortNumber: 22, Protocol: "tcp", State: "open"}}})
+	}
+	return nil
+}
+
+func TestRetryEmptyScan(t *testing.T) {
+	tests := []struct {
+		name     string
+		empty    int // runs that find nothing
+		retries  int
+		wantRuns int
+		wantLen  int
+	}{
+		{"populated first time", 0, 3, 1, 1},
+		{"empty then populated", 1, 3, 2, 1},
+		{"populated on the last retry", 3, 3, 4, 1},
+		{"retries exhausted", 5, 2, 3, 0},
+		{"retries disabled", 1, 0, 1, 0},
+	}
+	for _, tt := range tests {
+		spool := db.NewSpool(t.TempDir(), 100)
+		t.Cleanup(func() { spool.Close() })
+		network := &bootingNetwork{empty: tt.empty, spool: spool}
+
+		err := retryEmptyScan(context.Background(), network.scan, spool.Len, tt.retries, time.Millisecond)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if network.runs != tt.wantRuns || spool.Len() != tt.wantLen {
+			t.Errorf("%s: %d runs found %d hosts, want %d runs and %d hosts", tt.name, network.runs, spool.Len(), tt.wantRuns, tt.wantLen)
+		}
+	}
+}
+
+func TestRetryEmptyScanError(t *testing.T) {
+	// A failed scan is reported, not retried as empty
+	runs := 0
+	failed := errors.New("zmap failed")
+	err := retryEmptyScan(context.Background(), func(context.Context) error {
+		runs++
+		return failed
+	}, func() int { return 0 }, 3, time.Millisecond)
+	if !errors.Is(err, failed) || runs != 1 {
+		t.Errorf("retryEmptyScan = %v after %d runs, want the scan error after one", err, runs)
+	}
+}
+
+func TestRetryEmptyScanCancelledDuringDelay(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	runs := 0
+	start := time.Now()
+	err := retryEmptyScan(ctx, func(context.Context) error {
+		runs++
+		cancel()
+		return nil
+	}, func() int { return 0 }, 3, time.Hour)
+	if !errors.Is(err, context.Canceled) || runs != 1 {
+		t.Errorf("retryEmptyScan = %v after %d runs, want context.Canceled after one", err, runs)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("cancellation took %v to end the retry delay", elapsed)
+	}
+}
+
+// jitterSlack is how late a jittered start may run past its window on a
+// busy test machine
+const jitterSlack = 50 * time.Millisecond
+
+func TestJitteredStartsWithinWindow(t *testing.T) {
+	const window = 100 * time.Millisecond
+	var (
+		mu      sync.Mutex
+		offsets []time.Duration
+		wg      sync.WaitGroup
+	)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		tick := time.Now()
+		go jittered(window, func() {
+			defer wg.Done()
+			mu.Lock()
+			offsets = append(offsets, time.Since(tick))
+			mu.Unlock()
+		})()
+	}
+	wg.Wait()
+
+	spread := false
+	for _, offset := range offsets {
+		if offset < 0 || offset >= window+jitterSlack {
+			t.Errorf("started %s after the tick, want within %s", offset, window)
+		}
+		if offset >= window/10 {
+			spread = true
+		}
+	}
+	if !spread {
+		t.Errorf("offsets %v all near zero, want them spread across the window", offsets)
+	}
+}
+
+func TestJitteredDisabled(t *testing.T) {
+	ran := false
+	for _, window := range []time.Duration{0, -time.Second} {
+		start := time.Now()
+		jittered(window, func() { ran = true })()
+		if !ran || time.Since(start) > jitterSlack {
+			t.Errorf("jitter %s: ran %v after %s, want at once", window, ran, time.Since(start))
+		}
+	}
+}
+
+func TestJitteredCronTicksDoNotDrift(t *testing.T) {
+	// A window longer than the interval: delays would overlap and push the
+	// schedule if they were added to it
+	const window = 1500 * time.Millisecond
+	type start struct{ tick, at time.Time }
+	starts := make(chan start, 4)
+	c := cron.New()
+	if _, err := c.AddFunc("@every 1s", func() {
+		tick := time.Now()
+		jittered(window, func() { starts <- start{tick, time.Now()} })()
+	}); err != nil {
+		t.Fatal(err)
+	}
+	c.Start()
+	defer c.Stop()
+
+	for i := 0; i < 2; i++ {
+		var s start
+		select {
+		case s = <-starts:
+		case <-time.After(4 * time.Second):
+			t.Fatalf("start %d never came", i)
+		}
+		if offset := s.at.Sub(s.tick); offset < 0 || offset >= window+jitterSlack {
+			t.Errorf("start %d ran %s after its tick, want within %s", i, offset, window)
+		}
+		// Ticks land on whole seconds whatever the delays before them
+		if s.tick.Nanosecond() >= int(jitterSlack) {
+			t.Errorf("tick %d at %s, want on the second", i, s.tick.Format("15:04:05.000"))
+		}
+	}
+}
+
+func TestInScanWindow(t *testing.T) {
+	night, err := config.ParseTimeWindow("22:00-06:00", "UTC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for hour, want := range map[int]bool{23: true, 3: true, 6: false, 12: false, 21: false} {
+		now := time.Date(2026, 3, 10, hour, 0, 0, 0, time.UTC)
+		if got := inScanWindow(night, "scheduled", now); got != want {
+			t.Errorf("scheduled scan at %02d:00 allowed = %v, want %v", hour, got, want)
+		}
+	}
+	if !inScanWindow(nil, "initial", time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)) {
+		t.Error("scan skipped without a scan_window")
+	}
+}
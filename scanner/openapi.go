@@ -0,0 +1,179 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// openAPISpec builds an OpenAPI 3 document describing routes. Request and
+// response schemas are derived from the Go types via their JSON tags.
+func openAPISpec(routes []route) map[string]interface{} {
+	paths := make(map[string]interface{})
+
+	for _, rt := range routes {
+		responses := make(map[string]interface{})
+		for _, resp := range rt.Responses {
+			responses[strconv.Itoa(resp.Status)] = responseContent(resp.Description, resp.ContentType, resp.Body)
+		}
+		if rt.Auth {
+			responses[strconv.Itoa(http.StatusUnauthorized)] = responseContent("Missing or invalid control token", "", actionResponse{})
+		}
+
+		op := map[string]interface{}{
+			"summary":   rt.Summary,
+			"responses": responses,
+		}
+		if len(rt.Params) > 0 {
+			var params []map[string]interface{}
+			for _, param := range rt.Params {
+				params = append(params, map[string]interface{}{
+					"name":        param.Name,
+					"in":          "query",
+					"description": param.Description,
+					"schema":      map[string]interface{}{"type": param.Type},
+				})
+			}
+			op["parameters"] = params
+		}
+		if rt.Request != nil {
+			op["requestBody"] = map[string]interface{}{
+				"required": false,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{
+						"schema": jsonSchema(reflect.TypeOf(rt.Request)),
+					},
+				},
+			}
+		}
+		if rt.Auth {
+			op["security"] = []map[string][]string{
+				{"bearerAuth": {}},
+				{"basicAuth": {}},
+			}
+		}
+
+		item, _ := paths[rt.Path].(map[string]interface{})
+		if item == nil {
+			item = make(map[string]interface{})
+			paths[rt.Path] = item
+		}
+		item[strings.ToLower(rt.Method)] = op
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "Network Scanner Control API",
+			"description": "Control and status endpoints served by the network scanner",
+			"version":     "1.0.0",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"securitySchemes": map[string]interface{}{
+				"bearerAuth": map[string]interface{}{"type": "http", "scheme": "bearer"},
+				"basicAuth":  map[string]interface{}{"type": "http", "scheme": "basic"},
+			},
+		},
+	}
+}
+
+// responseContent describes a response of contentType (default
+// application/json) with body's schema
+func responseContent(description, contentType string, body interface{}) map[string]interface{} {
+	resp := map[string]interface{}{"description": description}
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	if body != nil {
+		resp["content"] = map[string]interface{}{
+			contentType: map[string]interface{}{
+				"schema": jsonSchema(reflect.TypeOf(body)),
+			},
+		}
+	}
+	return resp
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// jsonSchema converts a Go type to an OpenAPI schema following encoding/json rules
+func jsonSchema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": jsonSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": jsonSchema(t.Elem())}
+	case reflect.Struct:
+		return structSchema(t)
+	}
+
+	// interface{} and anything else: any JSON value
+	return map[string]interface{}{}
+}
+
+// structSchema describes a struct's exported JSON fields. Fields without
+// omitempty are listed as required since they are always present.
+func structSchema(t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	var required []string
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name := field.Name
+		omitEmpty := false
+		if tag := field.Tag.Get("json"); tag != "" {
+			if tag == "-" {
+				continue
+			}
+			parts := strings.Split(tag, ",")
+			if parts[0] != "" {
+				name = parts[0]
+			}
+			for _, opt := range parts[1:] {
+				if opt == "omitempty" {
+					omitEmpty = true
+				}
+			}
+		}
+
+		properties[name] = jsonSchema(field.Type)
+		if !omitEmpty {
+			required = append(required, name)
+		}
+	}
+
+	schema := map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"network-scanner/config"
+)
+
+// The parts of an OpenAPI 3.0 document the control API uses
+type openAPIDocument struct {
+	OpenAPI string `json:"openapi"`
+	Info    struct {
+		Title   string `json:"title"`
+		Version string `json:"version"`
+	} `json:"info"`
+	Paths      map[string]map[string]openAPIOperation `json:"paths"`
+	Components struct {
+		SecuritySchemes map[string]struct {
+			Type   string `json:"type"`
+			Scheme string `json:"scheme"`
+		} `json:"securitySchemes"`
+	} `json:"components"`
+}
+
+type openAPIOperation struct {
+	Summary    string                          `json:"summary"`
+	Parameters []openAPIParameter              `json:"parameters"`
+	Responses  map[string]openAPIResponse      `json:"responses"`
+	Security   []map[string][]string           `json:"security"`
+	Request    *struct{ Content openAPIMedia } `json:"requestBody"`
+}
+
+type openAPIParameter struct {
+	Name   string        `json:"name"`
+	In     string        `json:"in"`
+	Schema openAPISchema `json:"schema"`
+}
+
+type openAPIResponse struct {
+	Description string       `json:"description"`
+	Content     openAPIMedia `json:"content"`
+}
+
+type openAPIMedia map[string]struct {
+	Schema openAPISchema `json:"schema"`
+}
+
+type openAPISchema struct {
+	Type                 string                   `json:"type"`
+	Format               string                   `json:"format"`
+	Properties           map[string]openAPISchema `json:"properties"`
+	Required             []string                 `json:"required"`
+	Items                *openAPISchema           `json:"items"`
+	AdditionalProperties *openAPISchema           `json:"additionalProperties"`
+}
+
+var openAPIMethods = map[string]bool{"get": true, "put": true, "post": true, "delete": true, "options": true, "head": true, "patch": true, "trace": true}
+
+// validate reports the first way s breaks the OpenAPI schema object rules
+func (s openAPISchema) validate(path string, t *testing.T) {
+	switch s.Type {
+	case "", "boolean", "integer", "number", "string":
+	case "array":
+		if s.Items == nil {
+			t.Errorf("%s: array schema without items", path)
+			return
+		}
+		s.Items.validate(path+"[]", t)
+	case "object":
+		for _, name := range s.Required {
+			if _, ok := s.Properties[name]; !ok {
+				t.Errorf("%s: required property %q is not defined", path, name)
+			}
+		}
+		for name, prop := range s.Properties {
+			prop.validate(path+"."+name, t)
+		}
+		if s.AdditionalProperties != nil {
+			s.AdditionalProperties.validate(path+"{}", t)
+		}
+	default:
+		t.Errorf("%s: invalid schema type %q", path, s.Type)
+	}
+}
+
+func TestOpenAPIDocument(t *testing.T) {
+	healthy := true
+	s := newTestServer(t, &config.Config{}, stubAPI(t, &healthy).URL)
+
+	rec := httpGet(t, s.routes(), "/openapi.json")
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "application/json") {
+		t.Fatalf("GET /openapi.json = %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	var doc openAPIDocument
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("GET /openapi.json is not JSON: %v", err)
+	}
+
+	if !regexp.MustCompile(`^3\.0\.\d+$`).MatchString(doc.OpenAPI) {
+		t.Errorf("openapi = %q, want a 3.0.x version", doc.OpenAPI)
+	}
+	if doc.Info.Title == "" || doc.Info.Version == "" {
+		t.Errorf("info = %+v, title and version are required", doc.Info)
+	}
+	for path, item := range doc.Paths {
+		if !strings.HasPrefix(path, "/") {
+			t.Errorf("path %q does not start with /", path)
+		}
+		for method, op := range item {
+			where := strings.ToUpper(method) + " " + path
+			if !openAPIMethods[method] {
+				t.Errorf("%s: invalid method", where)
+			}
+			if len(op.Responses) == 0 {
+				t.Errorf("%s: no responses", where)
+			}
+			for code, resp := range op.Responses {
+				if n, err := strconv.Atoi(code); err != nil || n < 100 || n > 599 {
+					t.Errorf("%s: invalid response code %q", where, code)
+				}
+				if resp.Description == "" {
+					t.Errorf("%s %s: response without a description", where, code)
+				}
+				for media, content := range resp.Content {
+					content.Schema.validate(where+" "+code+" "+media, t)
+				}
+			}
+			for _, param := range op.Parameters {
+				if param.Name == "" || param.In != "query" {
+					t.Errorf("%s: invalid parameter %+v", where, param)
+				}
+				param.Schema.validate(where+" ?"+param.Name, t)
+			}
+			for _, requirement := range op.Security {
+				for scheme := range requirement {
+					if _, ok := doc.Components.SecuritySchemes[scheme]; !ok {
+						t.Errorf("%s: undefined security scheme %q", where, scheme)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestOpenAPIMatchesRoutes(t *testing.T) {
+	s := &controlServer{}
+	var doc openAPIDocument
+	data, _ := json.Marshal(openAPISpec(s.routeTable()))
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, rt := range s.routeTable() {
+		op, ok := doc.Paths[rt.Path][strings.ToLower(rt.Method)]
+		if !ok {
+			t.Errorf("%s %s is served but not described", rt.Method, rt.Path)
+			continue
+		}
+		_, unauthorized := op.Responses["401"]
+		if rt.Auth != (len(op.Security) > 0) || rt.Auth != unauthorized {
+			t.Errorf("%s %s: auth %v, described security %v and 401 %v", rt.Method, rt.Path, rt.Auth, op.Security, unauthorized)
+		}
+	}
+
+	// Schemas follow the response structs' JSON tags
+	status := doc.Paths["/status"]["get"].Responses["200"].Content["application/json"].Schema
+	if status.Properties["is_scanning"].Type != "boolean" || status.Properties["last_scan_time"].Type != "string" {
+		t.Errorf("/status schema = %+v", status)
+	}
+	for _, name := range status.Required {
+		if name == "last_scan_time" {
+			t.Error("omitempty field last_scan_time is listed as required")
+		}
+	}
+	trigger := doc.Paths["/trigger"]["post"].Request
+	if trigger == nil || trigger.Content["application/json"].Schema.Properties["tags"].Type != "object" {
+		t.Errorf("/trigger request body = %+v", trigger)
+	}
+}
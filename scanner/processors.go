@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"network-scanner/config"
+	"network-scanner/db"
+	"network-scanner/scanner"
+)
+
+// processorDeps is what the built-in result processors are built from
+type processorDeps struct {
+	redactor *db.Redactor
+	geo      *scanner.GeoEnricher
+	policy   *config.Policy
+}
+
+// builtinProcessors are the result processors result_processors can name.
+// config.Validate checks names and requirements against this set.
+var builtinProcessors = map[string]func(processorDeps) db.ResultProcessor{
+	"redact": redactProcessor,
+	"geo":    geoProcessor,
+	"policy": policyProcessor,
+}
+
+// newProcessorChain builds the named processors in order
+func newProcessorChain(names []string, deps processorDeps) (*db.ProcessorChain, error) {
+	chain := &db.ProcessorChain{}
+	for _, name := range names {
+		build, ok := builtinProcessors[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown result processor %q", name)
+		}
+		chain.Add(name, build(deps))
+	}
+	return chain, nil
+}
+
+// redactProcessor applies banner_redactions to banners and fingerprint
+// strings
+func redactProcessor(deps processorDeps) db.ResultProcessor {
+	return deps.redactor
+}
+
+// geoProcessor adds asn, as_org and country to every port of public hosts
+func geoProcessor(deps processorDeps) db.ResultProcessor {
+	return db.ResultProcessorFunc(func(results *db.ScanResults) error {
+		if deps.geo == nil {
+			return nil
+		}
+		for _, host := range results.Hosts {
+			for i := range host.Ports {
+				host.Ports[i].FingerprintData = deps.geo.Enrich(host.IPAddress, host.Ports[i].FingerprintData)
+			}
+		}
+		return nil
+	})
+}
+
+// policyProcessor sets policy_violation to the covering rule's name on open
+// ports the exposure policy doesn't allow
+func policyProcessor(deps processorDeps) db.ResultProcessor {
+	return db.ResultProcessorFunc(func(results *db.ScanResults) error {
+		if deps.policy == nil {
+			return nil
+		}
+		for _, host := range results.Hosts {
+			ip := net.ParseIP(host.IPAddress)
+			if ip == nil {
+				continue
+			}
+			for i, port := range host.Ports {
+				if port.State != "open" {
+					continue
+				}
+				rule, allowed := deps.policy.Check(ip, port.PortNumber, port.Protocol)
+				if allowed {
+					continue
+				}
+				if port.FingerprintData == nil {
+					host.Ports[i].FingerprintData = make(map[string]interface{})
+				}
+				host.Ports[i].FingerprintData["policy_violation"] = rule
+			}
+		}
+		return nil
+	})
+}
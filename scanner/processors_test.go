@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"network-scanner/db"
+	"network-scanner/scanner"
+)
+
+// stubGeo knows one public address
+type stubGeo struct{}
+
+func (stubGeo) Lookup(ip net.IP) (scanner.GeoInfo, error) {
+	if ip.String() == "8.8.8.8" {
+		return scanner.GeoInfo{ASN: 15169, Org: "GOOGLE", Country: "US"}, nil
+	}
+	return scanner.GeoInfo{}, nil
+}
+
+func TestNewProcessorChain(t *testing.T) {
+	chain, err := newProcessorChain([]string{"policy", "geo"}, processorDeps{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"policy", "geo"}; !reflect.DeepEqual(chain.Names(), want) {
+		t.Errorf("Names() = %v, want %v", chain.Names(), want)
+	}
+	// Built-ins without their dependency leave results alone
+	results := &db.ScanResults{Hosts: knownHosts()}
+	if err := chain.Process(results); err != nil || !reflect.DeepEqual(results.Hosts, knownHosts()) {
+		t.Errorf("Process = %v, hosts %+v, want them unchanged", err, results.Hosts)
+	}
+
+	if _, err := newProcessorChain([]string{"geo", "cpe"}, processorDeps{}); err == nil {
+		t.Error("unknown processor accepted")
+	}
+}
+
+func TestBuiltinProcessors(t *testing.T) {
+	chain, err := newProcessorChain([]string{"geo", "policy"}, processorDeps{
+		geo:    scanner.NewGeoEnricher(stubGeo{}),
+		policy: testPolicy(t),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := &db.ScanResults{Hosts: append(policyHosts()[:3], db.ScanResultHost{IPAddress: "8.8.8.8", Ports: []db.ScanResultPort{
+		{PortNumber: 53, Protocol: "udp", State: "open"},
+	}})}
+	if err := chain.Process(results); err != nil {
+		t.Fatal(err)
+	}
+
+	// HTTPS on 10.0.0.2 breaks the servers rule; the closed port on
+	// 10.0.0.3 and SSH don't
+	for _, host := range results.Hosts[:3] {
+		for _, port := range host.Ports {
+			want := port.PortNumber == 443
+			if got := port.FingerprintData["policy_violation"] == "servers"; got != want {
+				t.Errorf("%s port %d: fingerprint %v, want violation %v", host.IPAddress, port.PortNumber, port.FingerprintData, want)
+			}
+			if _, ok := port.FingerprintData["asn"]; ok {
+				t.Errorf("%s port %d: geo data on a private address", host.IPAddress, port.PortNumber)
+			}
+		}
+	}
+	want := map[string]interface{}{"asn": uint(15169), "as_org": "GOOGLE", "country": "US"}
+	if got := results.Hosts[3].Ports[0].FingerprintData; !reflect.DeepEqual(got, want) {
+		t.Errorf("8.8.8.8 fingerprint = %v, want %v", got, want)
+	}
+}
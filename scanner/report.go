@@ -0,0 +1,328 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"network-scanner/db"
+)
+
+// Bounds on the report so a large network's stays readable
+const (
+	maxReportServices = 10 // rows in "Top services"
+	maxReportRows     = 50 // entries per list of changes, violations or findings
+)
+
+// scanReport is what report_file summarizes for one completed scan
+type scanReport struct {
+	ScanID     uuid.UUID
+	StartedAt  time.Time
+	ScanTime   time.Time
+	Current    db.Snapshot
+	Previous   db.Snapshot // nil when no earlier scan ran since startup
+	Policy     bool        // a policy_file is configured
+	Violations []violation
+	Shared     []sharedIdentity
+}
+
+// reportFinding is a fingerprint flag worth a reviewer's attention, set
+// to true by an opt-in check
+type reportFinding struct {
+	key   string
+	label string
+}
+
+var reportFindings = []reportFinding{
+	{"open_proxy", "Open proxy"},
+	{"open_relay", "Open mail relay"},
+	{"anonymous_login", "Anonymous FTP login"},
+	{"axfr_allowed", "DNS zone transfer allowed"},
+	{"metadata_exposed", "Cloud metadata reachable"},
+	{"unauthenticated", "Unauthenticated API"},
+}
+
+// writeReport replaces path with the Markdown report, atomically so a
+// reader never sees a half-written one
+func writeReport(path string, r scanReport) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(r.Markdown()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	return nil
+}
+
+// Markdown renders the report: overview, counts, top services, changes
+// since the previous scan, policy violations, shared certificates and host
+// keys, and notable findings
+func (r scanReport) Markdown() string {
+	var b strings.Builder
+	b.WriteString("# Scan report\n\n")
+	if r.ScanID != uuid.Nil {
+		fmt.Fprintf(&b, "- Scan ID: `%s`\n", r.ScanID)
+	}
+	if !r.StartedAt.IsZero() {
+		fmt.Fprintf(&b, "- Started: %s\n", r.StartedAt.UTC().Format(time.RFC3339))
+	}
+	fmt.Fprintf(&b, "- Completed: %s\n", r.ScanTime.UTC().Format(time.RFC3339))
+	if !r.StartedAt.IsZero() {
+		fmt.Fprintf(&b, "- Duration: %s\n", r.ScanTime.Sub(r.StartedAt).Round(time.Second))
+	}
+
+	r.writeSummary(&b)
+	r.writeServices(&b)
+	r.writeChanges(&b)
+	r.writeViolations(&b)
+	r.writeShared(&b)
+	r.writeFindings(&b)
+	return b.String()
+}
+
+// openKeys returns the open endpoints of the current scan, sorted
+func (r scanReport) openKeys() []db.EndpointKey {
+	var keys []db.EndpointKey
+	for key, port := range r.Current {
+		if port.State == "open" {
+			keys = append(keys, key)
+		}
+	}
+	sortEndpoints(keys)
+	return keys
+}
+
+func (r scanReport) writeSummary(b *strings.Builder) {
+	hosts := make(map[string]bool)
+	protocols := make(map[string]int)
+	services := make(map[string]bool)
+	for _, key := range r.openKeys() {
+		hosts[key.IP] = true
+		protocols[key.Protocol]++
+		services[serviceLabel(r.Current[key])] = true
+	}
+	b.WriteString("\n## Summary\n\n")
+	b.WriteString("| | Count |\n|---|---|\n")
+	fmt.Fprintf(b, "| Hosts | %d |\n", len(hosts))
+	fmt.Fprintf(b, "| Open TCP ports | %d |\n", protocols["tcp"])
+	fmt.Fprintf(b, "| Open UDP ports | %d |\n", protocols["udp"])
+	fmt.Fprintf(b, "| Distinct services | %d |\n", len(services))
+}
+
+func (r scanReport) writeServices(b *strings.Builder) {
+	counts := make(map[string]int)
+	for _, key := range r.openKeys() {
+		counts[serviceLabel(r.Current[key])]++
+	}
+	b.WriteString("\n## Top services\n\n")
+	if len(counts) == 0 {
+		b.WriteString("_No open ports._\n")
+		return
+	}
+	services := make([]string, 0, len(counts))
+	for service := range counts {
+		services = append(services, service)
+	}
+	sort.Slice(services, func(i, j int) bool {
+		if counts[services[i]] != counts[services[j]] {
+			return counts[services[i]] > counts[services[j]]
+		}
+		return services[i] < services[j]
+	})
+	b.WriteString("| Service | Ports |\n|---|---|\n")
+	for i, service := range services {
+		if i == maxReportServices {
+			fmt.Fprintf(b, "| _%d more_ | |\n", len(services)-i)
+			break
+		}
+		fmt.Fprintf(b, "| %s | %d |\n", markdownCell(service), counts[service])
+	}
+}
+
+func (r scanReport) writeChanges(b *strings.Builder) {
+	b.WriteString("\n## Changes since the previous scan\n")
+	if r.Previous == nil {
+		b.WriteString("\n_No previous scan to compare against._\n")
+		return
+	}
+	diff := db.DiffSnapshots(r.Previous, r.Current)
+	if diff.Empty() && len(diff.NewHosts) == 0 && len(diff.RemovedHosts) == 0 {
+		b.WriteString("\n_No changes._\n")
+		return
+	}
+	writeHostList(b, "New hosts", diff.NewHosts)
+	writeHostList(b, "Removed hosts", diff.RemovedHosts)
+	writeEndpointList(b, "New ports", diff.NewPorts, r.Current)
+	writeEndpointList(b, "Removed ports", diff.RemovedPorts, r.Previous)
+	writeEndpointList(b, "Changed services", diff.ChangedPorts, r.Current)
+}
+
+func writeHostList(b *strings.Builder, title string, hosts []string) {
+	if len(hosts) == 0 {
+		return
+	}
+	sort.Strings(hosts)
+	fmt.Fprintf(b, "\n### %s (%d)\n\n", title, len(hosts))
+	for i, ip := range hosts {
+		if i == maxReportRows {
+			fmt.Fprintf(b, "- _... and %d more_\n", len(hosts)-i)
+			break
+		}
+		fmt.Fprintf(b, "- %s\n", ip)
+	}
+}
+
+// writeEndpointList lists endpoints with their service as seen in snap
+func writeEndpointList(b *strings.Builder, title string, keys []db.EndpointKey, snap db.Snapshot) {
+	if len(keys) == 0 {
+		return
+	}
+	fmt.Fprintf(b, "\n### %s (%d)\n\n", title, len(keys))
+	for i, key := range keys {
+		if i == maxReportRows {
+			fmt.Fprintf(b, "- _... and %d more_\n", len(keys)-i)
+			break
+		}
+		fmt.Fprintf(b, "- %s:%d/%s %s\n", key.IP, key.Port, key.Protocol, serviceLabel(snap[key]))
+	}
+}
+
+func (r scanReport) writeViolations(b *strings.Builder) {
+	b.WriteString("\n## Policy violations\n\n")
+	switch {
+	case !r.Policy:
+		b.WriteString("_No policy_file is configured._\n")
+		return
+	case len(r.Violations) == 0:
+		b.WriteString("_None._\n")
+		return
+	}
+	fmt.Fprintf(b, "Open ports the exposure policy doesn't allow: %d\n\n", len(r.Violations))
+	b.WriteString("| Endpoint | Service | Rule |\n|---|---|---|\n")
+	for i, v := range r.Violations {
+		if i == maxReportRows {
+			fmt.Fprintf(b, "| _... and %d more (see /violations)_ | | |\n", len(r.Violations)-i)
+			break
+		}
+		fmt.Fprintf(b, "| %s:%d/%s | %s | %s |\n", v.IPAddress, v.Port, v.Protocol, markdownCell(v.ServiceName), markdownCell(v.Rule))
+	}
+}
+
+func (r scanReport) writeShared(b *strings.Builder) {
+	b.WriteString("\n## Shared certificates and host keys\n\n")
+	if len(r.Shared) == 0 {
+		b.WriteString("_None._\n")
+		return
+	}
+	b.WriteString("Presented by more than one host, which points to shared infrastructure or cloned images.\n\n")
+	b.WriteString("| Kind | SHA256 | Label | Hosts |\n|---|---|---|---|\n")
+	for i, s := range r.Shared {
+		if i == maxReportRows {
+			fmt.Fprintf(b, "| _... and %d more_ | | | |\n", len(r.Shared)-i)
+			break
+		}
+		hosts := s.Hosts
+		more := ""
+		if len(hosts) > maxReportRows {
+			more = fmt.Sprintf(" and %d more", len(hosts)-maxReportRows)
+			hosts = hosts[:maxReportRows]
+		}
+		fmt.Fprintf(b, "| %s | `%s` | %s | %d: %s%s |\n", s.kindLabel(), s.SHA256, markdownCell(s.Label), len(s.Hosts), strings.Join(hosts, ", "), more)
+	}
+}
+
+func (r scanReport) writeFindings(b *strings.Builder) {
+	type finding struct {
+		key    db.EndpointKey
+		detail string
+	}
+	var findings []finding
+	for _, key := range r.openKeys() {
+		port := r.Current[key]
+		for _, f := range reportFindings {
+			if port.FingerprintData[f.key] == true {
+				findings = append(findings, finding{key, f.label})
+			}
+		}
+		if until, ok := certificateExpiry(port.FingerprintData); ok && until.Before(r.ScanTime) {
+			findings = append(findings, finding{key, "Expired certificate (valid until " + until.UTC().Format(time.RFC3339) + ")"})
+		}
+	}
+
+	b.WriteString("\n## Notable findings\n\n")
+	if len(findings) == 0 {
+		b.WriteString("_None._\n")
+		return
+	}
+	b.WriteString("| Endpoint | Service | Finding |\n|---|---|---|\n")
+	for i, f := range findings {
+		if i == maxReportRows {
+			fmt.Fprintf(b, "| _... and %d more_ | | |\n", len(findings)-i)
+			break
+		}
+		fmt.Fprintf(b, "| %s:%d/%s | %s | %s |\n", f.key.IP, f.key.Port, f.key.Protocol, markdownCell(serviceLabel(r.Current[f.key])), markdownCell(f.detail))
+	}
+}
+
+// tlsCertificate returns the server certificate recorded in
+// fingerprint_data.tls, as both native and zgrab2 probes record it, or nil
+func tlsCertificate(fingerprint map[string]interface{}) map[string]interface{} {
+	tlsInfo, ok := fingerprint["tls"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	cert, _ := tlsInfo["certificate"].(map[string]interface{})
+	return cert
+}
+
+// certificateExpiry reads the server certificate's valid_until
+func certificateExpiry(fingerprint map[string]interface{}) (time.Time, bool) {
+	raw, ok := tlsCertificate(fingerprint)["valid_until"].(string)
+	if !ok {
+		return time.Time{}, false
+	}
+	until, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return until, true
+}
+
+func serviceLabel(port db.ScanResultPort) string {
+	if port.ServiceName == "" {
+		return "unknown"
+	}
+	return port.ServiceName
+}
+
+// markdownCell keeps a value from breaking out of its table cell
+func markdownCell(s string) string {
+	s = strings.ReplaceAll(s, "|", `\|`)
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func sortEndpoints(keys []db.EndpointKey) {
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].IP != keys[j].IP {
+			return keys[i].IP < keys[j].IP
+		}
+		if keys[i].Port != keys[j].Port {
+			return keys[i].Port < keys[j].Port
+		}
+		return keys[i].Protocol < keys[j].Protocol
+	})
+}
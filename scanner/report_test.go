@@ -0,0 +1,288 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"network-scanner/db"
+)
+
+// reportScanTime is when the report's scan completed
+var reportScanTime = time.Date(2026, 10, 14, 9, 30, 0, 0, time.UTC)
+
+// snapshotOf keys hosts by endpoint
+func snapshotOf(hosts ...db.ScanResultHost) db.Snapshot {
+	snap := make(db.Snapshot)
+	for _, host := range hosts {
+		snap.Add(host)
+	}
+	return snap
+}
+
+// reportHosts is a scan of three web servers, one of them with an expired
+// certificate, an FTP server allowing anonymous login, an open proxy and a
+// DNS server on UDP, plus a closed port that must not count
+func reportHosts() []db.ScanResultHost {
+	expired := map[string]interface{}{"tls": map[string]interface{}{
+		"certificate": map[string]interface{}{"valid_until": "2026-01-01T00:00:00Z"},
+	}}
+	valid := map[string]interface{}{"tls": map[string]interface{}{
+		"certificate": map[string]interface{}{"valid_until": "2027-01-01T00:00:00Z"},
+	}}
+	return []db.ScanResultHost{
+		{IPAddress: "10.0.0.1", Ports: []db.ScanResultPort{
+			{PortNumber: 22, Protocol: "tcp", State: "open", ServiceName: "ssh", Banner: "SSH-2.0-OpenSSH_9.6"},
+			{PortNumber: 443, Protocol: "tcp", State: "open", ServiceName: "https", FingerprintData: valid},
+		}},
+		{IPAddress: "10.0.0.2", Ports: []db.ScanResultPort{
+			{PortNumber: 22, Protocol: "tcp", State: "open", ServiceName: "ssh", Banner: "SSH-2.0-OpenSSH_9.7"},
+			{PortNumber: 443, Protocol: "tcp", State: "open", ServiceName: "https", FingerprintData: expired},
+			{PortNumber: 3306, Protocol: "tcp", State: "closed"},
+		}},
+		{IPAddress: "10.0.0.3", Ports: []db.ScanResultPort{
+			{PortNumber: 21, Protocol: "tcp", State: "open", ServiceName: "ftp", FingerprintData: map[string]interface{}{"anonymous_login": true}},
+			{PortNumber: 443, Protocol: "tcp", State: "open", ServiceName: "https"},
+			{PortNumber: 3128, Protocol: "tcp", State: "open", ServiceName: "http|proxy", FingerprintData: map[string]interface{}{"open_proxy": true}},
+		}},
+		{IPAddress: "10.0.0.4", Ports: []db.ScanResultPort{
+			{PortNumber: 53, Protocol: "udp", State: "open", ServiceName: "dns", FingerprintData: map[string]interface{}{"axfr_allowed": false}},
+			{PortNumber: 8080, Protocol: "tcp", State: "open"},
+		}},
+	}
+}
+
+// reportPrevious is the scan before reportHosts: 10.0.0.4 was not up yet,
+// 10.0.0.5 has gone, 10.0.0.1 had telnet open, and 10.0.0.2's SSH has since
+// been upgraded
+func reportPrevious() []db.ScanResultHost {
+	return []db.ScanResultHost{
+		{IPAddress: "10.0.0.1", Ports: []db.ScanResultPort{
+			{PortNumber: 22, Protocol: "tcp", State: "open", ServiceName: "ssh", Banner: "SSH-2.0-OpenSSH_9.6"},
+			{PortNumber: 23, Protocol: "tcp", State: "open", ServiceName: "telnet"},
+			{PortNumber: 443, Protocol: "tcp", State: "open", ServiceName: "https"},
+		}},
+		{IPAddress: "10.0.0.2", Ports: []db.ScanResultPort{
+			{PortNumber: 22, Protocol: "tcp", State: "open", ServiceName: "ssh", Banner: "SSH-2.0-OpenSSH_9.6"},
+			{PortNumber: 443, Protocol: "tcp", State: "open", ServiceName: "https"},
+			{PortNumber: 3306, Protocol: "tcp", State: "closed"},
+		}},
+		{IPAddress: "10.0.0.3", Ports: []db.ScanResultPort{
+			{PortNumber: 21, Protocol: "tcp", State: "open", ServiceName: "ftp"},
+			{PortNumber: 443, Protocol: "tcp", State: "open", ServiceName: "https"},
+			{PortNumber: 3128, Protocol: "tcp", State: "open", ServiceName: "http|proxy"},
+		}},
+		{IPAddress: "10.0.0.5", Ports: []db.ScanResultPort{
+			{PortNumber: 80, Protocol: "tcp", State: "open", ServiceName: "http"},
+		}},
+	}
+}
+
+// section returns the body of the ## heading in report up to the next one,
+// failing if the heading is missing
+func section(t *testing.T, report, heading string) string {
+	t.Helper()
+	_, body, ok := strings.Cut(report, "\n## "+heading+"\n")
+	if !ok {
+		t.Fatalf("report has no %q section:\n%s", heading, report)
+	}
+	body, _, _ = strings.Cut(body, "\n## ")
+	return body
+}
+
+// checkLines fails unless body contains each of want
+func checkLines(t *testing.T, name, body string, want ...string) {
+	t.Helper()
+	for _, line := range want {
+		if !strings.Contains(body, line) {
+			t.Errorf("%s lacks %q:\n%s", name, line, body)
+		}
+	}
+}
+
+func TestReportMarkdown(t *testing.T) {
+	scanID := uuid.MustParse("7f9c1d2e-3b4a-4c5d-8e6f-0a1b2c3d4e5f")
+	r := scanReport{
+		ScanID:    scanID,
+		StartedAt: reportScanTime.Add(-90 * time.Second),
+		ScanTime:  reportScanTime,
+		Current:   snapshotOf(reportHosts()...),
+		Previous:  snapshotOf(reportPrevious()...),
+		Policy:    true,
+		Violations: []violation{
+			{IPAddress: "10.0.0.3", Port: 21, Protocol: "tcp", ServiceName: "ftp", Rule: "servers"},
+			{IPAddress: "10.0.0.3", Port: 3128, Protocol: "tcp", ServiceName: "http|proxy", Rule: "servers"},
+		},
+		Shared: []sharedIdentity{
+			{Kind: "tls_certificate", SHA256: "ab12", Label: "*.example.com", Hosts: []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}},
+			{Kind: "ssh_host_key", SHA256: "cd34", Label: "ssh-ed25519", Hosts: []string{"10.0.0.1", "10.0.0.2"}},
+		},
+	}
+	report := r.Markdown()
+
+	if !strings.HasPrefix(report, "# Scan report\n") {
+		t.Errorf("report starts %q", report[:min(len(report), 40)])
+	}
+	// The sections, in order
+	last := -1
+	for _, heading := range []string{"Summary", "Top services", "Changes since the previous scan", "Policy violations", "Shared certificates and host keys", "Notable findings"} {
+		i := strings.Index(report, "\n## "+heading+"\n")
+		if i < 0 || i < last {
+			t.Errorf("section %q at %d, want after %d", heading, i, last)
+		}
+		last = i
+	}
+
+	overview, _, _ := strings.Cut(report, "\n## ")
+	checkLines(t, "overview", overview,
+		"- Scan ID: `"+scanID.String()+"`\n",
+		"- Started: 2026-10-14T09:28:30Z\n",
+		"- Completed: 2026-10-14T09:30:00Z\n",
+		"- Duration: 1m30s\n")
+
+	checkLines(t, "summary", section(t, report, "Summary"),
+		"| Hosts | 4 |\n",
+		"| Open TCP ports | 8 |\n",
+		"| Open UDP ports | 1 |\n",
+		"| Distinct services | 6 |\n")
+
+	// By count, then name; a service without a name is "unknown" and a pipe
+	// stays inside its cell
+	services := section(t, report, "Top services")
+	checkLines(t, "top services", services,
+		"| Service | Ports |\n|---|---|\n| https | 3 |\n| ssh | 2 |\n| dns | 1 |\n| ftp | 1 |\n| http\\|proxy | 1 |\n| unknown | 1 |\n")
+
+	changes := section(t, report, "Changes since the previous scan")
+	checkLines(t, "changes", changes,
+		"### New hosts (1)\n\n- 10.0.0.4\n",
+		"### Removed hosts (1)\n\n- 10.0.0.5\n",
+		"### New ports (2)\n\n- 10.0.0.4:53/udp dns\n- 10.0.0.4:8080/tcp unknown\n",
+		"### Removed ports (2)\n\n- 10.0.0.1:23/tcp telnet\n- 10.0.0.5:80/tcp http\n",
+		"### Changed services (1)\n\n- 10.0.0.2:22/tcp ssh\n")
+
+	checkLines(t, "policy violations", section(t, report, "Policy violations"),
+		"Open ports the exposure policy doesn't allow: 2\n",
+		"| 10.0.0.3:21/tcp | ftp | servers |\n",
+		"| 10.0.0.3:3128/tcp | http\\|proxy | servers |\n")
+
+	checkLines(t, "shared identities", section(t, report, "Shared certificates and host keys"),
+		"| TLS certificate | `ab12` | *.example.com | 3: 10.0.0.1, 10.0.0.2, 10.0.0.3 |\n",
+		"| SSH host key | `cd34` | ssh-ed25519 | 2: 10.0.0.1, 10.0.0.2 |\n")
+
+	// Only flags set to true count, and only certificates expired by the
+	// scan's own time
+	findings := section(t, report, "Notable findings")
+	checkLines(t, "findings", findings,
+		"| 10.0.0.2:443/tcp | https | Expired certificate (valid until 2026-01-01T00:00:00Z) |\n",
+		"| 10.0.0.3:21/tcp | ftp | Anonymous FTP login |\n",
+		"| 10.0.0.3:3128/tcp | http\\|proxy | Open proxy |\n")
+	if rows := strings.Count(findings, "\n| 10.0.0."); rows != 3 {
+		t.Errorf("%d findings, want 3:\n%s", rows, findings)
+	}
+	if strings.Contains(findings, "10.0.0.1:443") || strings.Contains(findings, "zone transfer") {
+		t.Errorf("findings include a valid certificate or a false flag:\n%s", findings)
+	}
+}
+
+func TestReportMarkdownEmpty(t *testing.T) {
+	report := scanReport{ScanTime: reportScanTime, Current: make(db.Snapshot)}.Markdown()
+
+	// Without an ID or start time neither is shown
+	overview, _, _ := strings.Cut(report, "\n## ")
+	if strings.Contains(overview, "Scan ID") || strings.Contains(overview, "Started") || strings.Contains(overview, "Duration") {
+		t.Errorf("overview = %q, want only the completion time", overview)
+	}
+	checkLines(t, "summary", section(t, report, "Summary"), "| Hosts | 0 |\n", "| Open TCP ports | 0 |\n")
+	for heading, want := range map[string]string{
+		"Top services":                      "_No open ports._\n",
+		"Changes since the previous scan":   "_No previous scan to compare against._\n",
+		"Policy violations":                 "_No policy_file is configured._\n",
+		"Shared certificates and host keys": "_None._\n",
+		"Notable findings":                  "_None._\n",
+	} {
+		checkLines(t, heading, section(t, report, heading), want)
+	}
+
+	// A policy with nothing against it, and the same open ports as before
+	same := snapshotOf(reportPrevious()...)
+	report = scanReport{ScanTime: reportScanTime, Current: same, Previous: same, Policy: true}.Markdown()
+	checkLines(t, "changes", section(t, report, "Changes since the previous scan"), "_No changes._\n")
+	checkLines(t, "policy violations", section(t, report, "Policy violations"), "_None._\n")
+}
+
+func TestReportMarkdownTruncates(t *testing.T) {
+	var hosts []db.ScanResultHost
+	for i := 0; i < maxReportRows+5; i++ {
+		hosts = append(hosts, db.ScanResultHost{IPAddress: fmt.Sprintf("10.1.%d.%d", i/250, i%250), Ports: []db.ScanResultPort{
+			{PortNumber: 1000 + i, Protocol: "tcp", State: "open", ServiceName: fmt.Sprintf("svc%02d", i)},
+		}})
+	}
+	report := scanReport{ScanTime: reportScanTime, Current: snapshotOf(hosts...), Previous: make(db.Snapshot)}.Markdown()
+
+	services := section(t, report, "Top services")
+	if rows := strings.Count(services, "| svc"); rows != maxReportServices {
+		t.Errorf("%d service rows, want %d", rows, maxReportServices)
+	}
+	checkLines(t, "top services", services, fmt.Sprintf("| _%d more_ | |\n", maxReportRows+5-maxReportServices))
+
+	changes := section(t, report, "Changes since the previous scan")
+	checkLines(t, "changes", changes,
+		fmt.Sprintf("### New hosts (%d)\n", maxReportRows+5),
+		fmt.Sprintf("### New ports (%d)\n", maxReportRows+5),
+		"- _... and 5 more_\n")
+}
+
+func TestWriteReport(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "report.md")
+	if err := os.WriteFile(path, []byte("stale"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	r := scanReport{ScanTime: reportScanTime, Current: snapshotOf(reportHosts()...)}
+	if err := writeReport(path, r); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil || string(got) != r.Markdown() {
+		t.Errorf("report file = %q, %v, want the rendered report", got, err)
+	}
+	// The temporary file is renamed over the old report, not left behind
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("%d files in the report directory, want 1", len(entries))
+	}
+
+	if err := writeReport(filepath.Join(dir, "missing", "report.md"), r); err == nil {
+		t.Error("report written into a missing directory")
+	}
+}
+
+func TestLastResultsSnapshot(t *testing.T) {
+	results := &lastResults{}
+	if snap, err := results.snapshot(); snap != nil || err != nil {
+		t.Errorf("snapshot before any scan = %v, %v, want none", snap, err)
+	}
+
+	spool := db.NewSpool(t.TempDir(), 2)
+	t.Cleanup(func() { spool.Close() })
+	for _, host := range reportHosts() {
+		if err := spool.Add(host); err != nil {
+			t.Fatal(err)
+		}
+	}
+	results.set(spool, reportScanTime)
+	snap, err := results.snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := snapshotOf(reportHosts()...); len(snap) != len(want) {
+		t.Errorf("snapshot has %d endpoints, want %d", len(snap), len(want))
+	}
+	if port := snap[db.EndpointKey{IP: "10.0.0.4", Port: 53, Protocol: "udp"}]; port.ServiceName != "dns" {
+		t.Errorf("10.0.0.4:53/udp = %+v", port)
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"network-scanner/config"
+	"network-scanner/db"
+)
+
+// Pagination bounds for GET /results/last
+const (
+	defaultResultsLimit = 100
+	maxResultsLimit     = 1000
+)
+
+// lastResults holds the hosts found by the most recent completed scan and,
+// with a policy, the violations among them
+type lastResults struct {
+	policy *config.Policy // nil skips the policy check
+
+	mu         sync.RWMutex
+	spool      *db.Spool
+	scanTime   time.Time
+	violations []violation
+	shared     []sharedIdentity
+}
+
+// set replaces the stored results, taking ownership of spool, and logs the
+// policy check and the certificates and host keys hosts share
+func (l *lastResults) set(spool *db.Spool, scanTime time.Time) {
+	var violations []violation
+	if l.policy != nil {
+		var err error
+		if violations, err = findViolations(l.policy, spool); err != nil {
+			log.Printf("Policy check failed: %v", err)
+		} else {
+			logViolations(violations)
+		}
+	}
+	shared, err := findSharedIdentities(spool)
+	if err != nil {
+		log.Printf("Shared identity check failed: %v", err)
+	} else {
+		logSharedIdentities(shared)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.spool != nil {
+		l.spool.Close()
+	}
+	l.spool = spool
+	l.scanTime = scanTime
+	l.violations = violations
+	l.shared = shared
+}
+
+// policyViolations returns the last scan's violations. ok is false before
+// any scan completes.
+func (l *lastResults) policyViolations() (violations []violation, scanTime time.Time, ok bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.spool == nil {
+		return nil, time.Time{}, false
+	}
+	return l.violations, l.scanTime, true
+}
+
+// sharedIdentities returns the certificates and host keys more than one
+// host presented in the last scan
+func (l *lastResults) sharedIdentities() []sharedIdentity {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.shared
+}
+
+// snapshot returns the stored results keyed by endpoint, nil before any
+// scan completes
+func (l *lastResults) snapshot() (db.Snapshot, error) {
+	snap := make(db.Snapshot)
+	_, ok, err := l.each(func(host db.ScanResultHost) error {
+		snap.Add(host)
+		return nil
+	})
+	if !ok {
+		return nil, nil
+	}
+	return snap, err
+}
+
+// each calls fn with every stored host entry. ok is false before any scan
+// completes.
+func (l *lastResults) each(fn func(db.ScanResultHost) error) (scanTime time.Time, ok bool, err error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.spool == nil {
+		return time.Time{}, false, nil
+	}
+	return l.scanTime, true, l.spool.Each(fn)
+}
+
+// resultsFilter selects ports by service name and/or number. Zero fields
+// match everything.
+type resultsFilter struct {
+	Service string
+	Port    int
+}
+
+// match returns host with only its matching ports, and false when none
+// match. host's own ports slice is not modified.
+func (f resultsFilter) match(host db.ScanResultHost) (db.ScanResultHost, bool) {
+	if f.Service == "" && f.Port == 0 {
+		return host, len(host.Ports) > 0
+	}
+
+	var ports []db.ScanResultPort
+	for _, port := range host.Ports {
+		if f.Port != 0 && port.PortNumber != f.Port {
+			continue
+		}
+		if f.Service != "" && !strings.EqualFold(port.ServiceName, f.Service) {
+			continue
+		}
+		ports = append(ports, port)
+	}
+	host.Ports = ports
+	return host, len(ports) > 0
+}
+
+type resultsResponse struct {
+	ScanTime string              `json:"scan_time"`
+	Total    int                 `json:"total"` // entries matching the filters
+	Offset   int                 `json:"offset"`
+	Limit    int                 `json:"limit"`
+	Hosts    []db.ScanResultHost `json:"hosts"`
+}
+
+// handleLastResults serves the last completed scan's hosts, filtered by
+// ?service= and ?port= and paged by ?limit= and ?offset=. Results are
+// streamed from the spool, so only the requested page is held in memory.
+func (s *controlServer) handleLastResults(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	badRequest := func(message string) {
+		writeJSON(w, http.StatusBadRequest, actionResponse{Status: "invalid_request", Message: message})
+	}
+	intParam := func(name string, def int) (int, bool) {
+		raw := query.Get(name)
+		if raw == "" {
+			return def, true
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			badRequest(name + " must be a non-negative integer")
+			return 0, false
+		}
+		return n, true
+	}
+
+	filter := resultsFilter{Service: query.Get("service")}
+	var valid bool
+	if filter.Port, valid = intParam("port", 0); !valid {
+		return
+	}
+	if filter.Port > 65535 {
+		badRequest("port must be at most 65535")
+		return
+	}
+	offset, valid := intParam("offset", 0)
+	if !valid {
+		return
+	}
+	limit, valid := intParam("limit", defaultResultsLimit)
+	if !valid {
+		return
+	}
+	if limit == 0 {
+		badRequest("limit must be at least 1")
+		return
+	}
+	if limit > maxResultsLimit {
+		limit = maxResultsLimit
+	}
+
+	total := 0
+	page := []db.ScanResultHost{}
+	scanTime, ok, err := s.results.each(func(host db.ScanResultHost) error {
+		host, matched := filter.match(host)
+		if !matched {
+			return nil
+		}
+		if total >= offset && len(page) < limit {
+			page = append(page, host)
+		}
+		total++
+		return nil
+	})
+	if !ok {
+		writeJSON(w, http.StatusNotFound, actionResponse{
+			Status:  "no_results",
+			Message: "No scan has completed yet",
+		})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, actionResponse{Status: "error", Message: err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, resultsResponse{
+		ScanTime: scanTime.UTC().Format(time.RFC3339),
+		Total:    total,
+		Offset:   offset,
+		Limit:    limit,
+		Hosts:    page,
+	})
+}
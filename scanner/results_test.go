@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"network-scanner/config"
+	"network-scanner/db"
+)
+
+// knownHosts is the result set the results tests page through: 10.0.0.1
+// to 10.0.0.10, every host with ssh on 22, even hosts with https on 443
+// and 10.0.0.5 with ssh on 2222 too
+func knownHosts() []db.ScanResultHost {
+	var hosts []db.ScanResultHost
+	for i := 1; i <= 10; i++ {
+		host := db.ScanResultHost{IPAddress: fmt.Sprintf("10.0.0.%d", i), Ports: []db.ScanResultPort{
+			{PortNumber: 22, Protocol: "tcp", State: "open", ServiceName: "ssh"},
+		}}
+		if i%2 == 0 {
+			host.Ports = append(host.Ports, db.ScanResultPort{PortNumber: 443, Protocol: "tcp", State: "open", ServiceName: "https"})
+		}
+		if i == 5 {
+			host.Ports = append(host.Ports, db.ScanResultPort{PortNumber: 2222, Protocol: "tcp", State: "open", ServiceName: "SSH"})
+		}
+		hosts = append(hosts, host)
+	}
+	return hosts
+}
+
+// storeResults makes hosts the server's last completed scan, spilling to
+// disk after spoolLimit hosts
+func storeResults(t *testing.T, s *controlServer, hosts []db.ScanResultHost, spoolLimit int) time.Time {
+	t.Helper()
+	spool := db.NewSpool(t.TempDir(), spoolLimit)
+	for _, host := range hosts {
+		if err := spool.Add(host); err != nil {
+			t.Fatal(err)
+		}
+	}
+	scanTime := time.Date(2026, 10, 14, 9, 30, 0, 0, time.UTC)
+	s.results = &lastResults{}
+	s.results.set(spool, scanTime)
+	t.Cleanup(func() { spool.Close() })
+	return scanTime
+}
+
+// page summarizes a response as its IPs and each one's port numbers
+func page(resp resultsResponse) map[string][]int {
+	got := make(map[string][]int)
+	for _, host := range resp.Hosts {
+		for _, port := range host.Ports {
+			got[host.IPAddress] = append(got[host.IPAddress], port.PortNumber)
+		}
+	}
+	return got
+}
+
+func TestLastResultsFilterAndPage(t *testing.T) {
+	healthy := true
+	for _, spoolLimit := range []int{100, 3} {
+		s := newTestServer(t, &config.Config{}, stubAPI(t, &healthy).URL)
+		scanTime := storeResults(t, s, knownHosts(), spoolLimit)
+		handler := s.routes()
+
+		tests := []struct {
+			query string
+			total int
+			want  map[string][]int
+		}{
+			{"", 10, nil},
+			{"?limit=3", 10, map[string][]int{"10.0.0.1": {22}, "10.0.0.2": {22, 443}, "10.0.0.3": {22}}},
+			{"?limit=3&offset=3", 10, map[string][]int{"10.0.0.4": {22, 443}, "10.0.0.5": {22, 2222}, "10.0.0.6": {22, 443}}},
+			{"?offset=9", 10, map[string][]int{"10.0.0.10": {22, 443}}},
+			{"?offset=10", 10, map[string][]int{}},
+			{"?port=443&limit=2", 5, map[string][]int{"10.0.0.2": {443}, "10.0.0.4": {443}}},
+			{"?port=443&offset=4", 5, map[string][]int{"10.0.0.10": {443}}},
+			{"?service=ssh&port=2222", 1, map[string][]int{"10.0.0.5": {2222}}},
+			{"?service=HTTPS&limit=1&offset=1", 5, map[string][]int{"10.0.0.4": {443}}},
+			{"?service=ssh&offset=4&limit=1", 10, map[string][]int{"10.0.0.5": {22, 2222}}},
+			{"?service=mysql", 0, map[string][]int{}},
+			{"?port=3306", 0, map[string][]int{}},
+		}
+		for _, tt := range tests {
+			var resp resultsResponse
+			if code := get(t, handler, "/results/last"+tt.query, &resp); code != http.StatusOK {
+				t.Fatalf("GET /results/last%s = %d", tt.query, code)
+			}
+			if resp.Total != tt.total || resp.ScanTime != scanTime.Format(time.RFC3339) {
+				t.Errorf("spool %d, %q: total %d at %s, want %d", spoolLimit, tt.query, resp.Total, resp.ScanTime, tt.total)
+			}
+			if tt.want == nil {
+				if len(resp.Hosts) != 10 || resp.Limit != defaultResultsLimit || resp.Offset != 0 {
+					t.Errorf("spool %d, %q: %d hosts, limit %d, offset %d", spoolLimit, tt.query, len(resp.Hosts), resp.Limit, resp.Offset)
+				}
+				continue
+			}
+			if got := page(resp); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("spool %d, %q: page = %v, want %v", spoolLimit, tt.query, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestLastResultsLimitCapped(t *testing.T) {
+	healthy := true
+	s := newTestServer(t, &config.Config{}, stubAPI(t, &healthy).URL)
+	storeResults(t, s, knownHosts(), 100)
+	var resp resultsResponse
+	get(t, s.routes(), "/results/last?limit=5000", &resp)
+	if resp.Limit != maxResultsLimit {
+		t.Errorf("limit = %d, want the %d cap", resp.Limit, maxResultsLimit)
+	}
+}
+
+func TestLastResultsInvalidQuery(t *testing.T) {
+	healthy := true
+	s := newTestServer(t, &config.Config{}, stubAPI(t, &healthy).URL)
+	storeResults(t, s, knownHosts(), 100)
+	handler := s.routes()
+	for _, query := range []string{"?limit=0", "?limit=-1", "?offset=x", "?port=65536", "?port=ssh"} {
+		if code := get(t, handler, "/results/last"+query, nil); code != http.StatusBadRequest {
+			t.Errorf("GET /results/last%s = %d, want 400", query, code)
+		}
+	}
+}
+
+func TestLastResultsBeforeAnyScan(t *testing.T) {
+	healthy := true
+	s := newTestServer(t, &config.Config{}, stubAPI(t, &healthy).URL)
+	s.results = &lastResults{}
+	var resp actionResponse
+	if code := get(t, s.routes(), "/results/last", &resp); code != http.StatusNotFound || resp.Status != "no_results" {
+		t.Errorf("GET /results/last = %d %+v, want 404 no_results", code, resp)
+	}
+}
+
+func TestLastResultsRequiresAuth(t *testing.T) {
+	healthy := true
+	s := newTestServer(t, &config.Config{ControlAuthToken: "secret"}, stubAPI(t, &healthy).URL)
+	storeResults(t, s, knownHosts(), 100)
+	handler := s.routes()
+
+	if code := get(t, handler, "/results/last", nil); code != http.StatusUnauthorized {
+		t.Errorf("GET /results/last without a token = %d, want 401", code)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/results/last", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("GET /results/last with the token = %d, want 200", rec.Code)
+	}
+}
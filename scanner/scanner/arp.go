@@ -0,0 +1,66 @@
+package scanner
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// arpTablePath is the kernel's IPv4 neighbour table
+const arpTablePath = "/proc/net/arp"
+
+// arpRefreshInterval is how stale a cached neighbour table may get
+const arpRefreshInterval = time.Second
+
+// ARPTable resolves IPv4 addresses of on-link hosts to MAC addresses from
+// the kernel's neighbour table. Entries appear once the scanner has talked
+// to a host, so lookups after a probe find it; hosts behind a router have
+// no entry.
+type ARPTable struct {
+	Path string
+
+	mu      sync.Mutex
+	entries map[string]string
+	read    time.Time
+}
+
+// NewARPTable creates an ARPTable backed by /proc/net/arp
+func NewARPTable() *ARPTable {
+	return &ARPTable{Path: arpTablePath}
+}
+
+// MAC returns ip's MAC address, or "" when it has none
+func (a *ARPTable) MAC(ip string) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.entries == nil || time.Since(a.read) >= arpRefreshInterval {
+		a.entries = readARPTable(a.Path)
+		a.read = time.Now()
+	}
+	return a.entries[ip]
+}
+
+// readARPTable parses the /proc/net/arp format, skipping incomplete entries
+func readARPTable(path string) map[string]string {
+	entries := make(map[string]string)
+	f, err := os.Open(path)
+	if err != nil {
+		return entries
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // header
+	for scanner.Scan() {
+		// IP address, HW type, Flags, HW address, Mask, Device
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || fields[2] == "0x0" || fields[3] == "00:00:00:00:00:00" {
+			continue
+		}
+		entries[fields[0]] = strings.ToLower(fields[3])
+	}
+	return entries
+}
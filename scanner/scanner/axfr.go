@@ -0,0 +1,164 @@
+package scanner
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DNS record types used by the zone transfer test
+const (
+	dnsTypeSOA  = 6
+	dnsTypeAXFR = 252
+)
+
+// DNS response codes a server refuses a transfer with
+const (
+	dnsRcodeRefused = 5
+	dnsRcodeNotAuth = 9
+)
+
+// checkAXFR asks a DNS server on TCP 53 for a full zone transfer of the
+// zones its own reverse lookup name sits in (ns1.example.com -> example.com)
+// and records Fingerprint["axfr_allowed"]. When a transfer is permitted the
+// zone and its record count are recorded; the records themselves are
+// counted and discarded.
+func (f *Fingerprinter) checkAXFR(ctx context.Context, ip string, port int, info *ServiceInfo) {
+	if !f.AXFRTest || port != 53 || f.Resolver == nil {
+		return
+	}
+
+	zones := axfrCandidateZones(f.Resolver.Hostname(ctx, ip))
+	if len(zones) == 0 {
+		return
+	}
+
+	address := net.JoinHostPort(ip, strconv.Itoa(port))
+	refused := false
+	for _, zone := range zones {
+		log.Printf("DNS AXFR test: %s zone %s", address, zone)
+		count, err := f.axfrCount(address, zone)
+		if err == errAXFRRefused {
+			refused = true
+			continue
+		}
+		if err != nil {
+			log.Printf("DNS AXFR test of %s on %s inconclusive: %v", zone, address, err)
+			continue
+		}
+
+		if info.Fingerprint == nil {
+			info.Fingerprint = make(map[string]interface{})
+		}
+		info.Fingerprint["axfr_allowed"] = true
+		info.Fingerprint["axfr_zone"] = zone
+		info.Fingerprint["axfr_record_count"] = count
+		log.Printf("DNS AXFR test: %s ALLOWS zone transfer of %s (%d records)", address, zone, count)
+		return
+	}
+
+	// Only an explicit refusal is a result; errors leave the test unrecorded
+	if refused {
+		if info.Fingerprint == nil {
+			info.Fingerprint = make(map[string]interface{})
+		}
+		info.Fingerprint["axfr_allowed"] = false
+	}
+}
+
+// axfrCandidateZones returns the parent domains of hostname, longest first,
+// down to two labels: "ns1.dc.example.com" gives "dc.example.com" and
+// "example.com"
+func axfrCandidateZones(hostname string) []string {
+	labels := strings.Split(strings.TrimSuffix(hostname, "."), ".")
+	var zones []string
+	for i := 1; len(labels)-i >= 2; i++ {
+		zones = append(zones, strings.Join(labels[i:], "."))
+	}
+	return zones
+}
+
+var errAXFRRefused = errors.New("zone transfer refused")
+
+// axfrCount runs an AXFR of zone and returns the number of records
+// received, the opening and closing SOA included. errAXFRRefused means the
+// server answered but wouldn't transfer.
+func (f *Fingerprinter) axfrCount(address, zone string) (int, error) {
+	query, err := buildDNSQuery(zone, dnsTypeAXFR)
+	if err != nil {
+		return 0, err
+	}
+
+	conn, err := f.dial(address)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(f.Timeout))
+	framed := make([]byte, 2, 2+len(query))
+	binary.BigEndian.PutUint16(framed, uint16(len(query)))
+	if _, err := conn.Write(append(framed, query...)); err != nil {
+		return 0, err
+	}
+
+	// The transfer is one or more messages, ending with the zone's SOA
+	// repeated. Each message gets a fresh deadline so large zones can
+	// finish.
+	count, soas := 0, 0
+	for soas < 2 {
+		conn.SetReadDeadline(time.Now().Add(f.Timeout))
+		msg, err := readDNSTCPMessage(conn)
+		if err != nil {
+			if count == 0 && (err == io.EOF || err == io.ErrUnexpectedEOF) {
+				return 0, errAXFRRefused // closed without answering
+			}
+			return 0, err
+		}
+		if len(msg) < 12 {
+			return 0, errShortDNSMessage
+		}
+		switch msg[3] & 0x0f {
+		case 0:
+		case dnsRcodeRefused, dnsRcodeNotAuth:
+			return 0, errAXFRRefused
+		default:
+			return 0, fmt.Errorf("AXFR failed with rcode %d", msg[3]&0x0f)
+		}
+
+		records, err := parseDNSResponse(msg)
+		if err != nil {
+			return 0, err
+		}
+		if count == 0 && (len(records) == 0 || records[0].Type != dnsTypeSOA) {
+			return 0, errAXFRRefused // an answer, but not a zone
+		}
+		for _, r := range records {
+			if r.Type == dnsTypeSOA {
+				soas++
+			}
+		}
+		count += len(records)
+	}
+	return count, nil
+}
+
+// readDNSTCPMessage reads one length-prefixed DNS message
+func readDNSTCPMessage(r io.Reader) ([]byte, error) {
+	var length [2]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
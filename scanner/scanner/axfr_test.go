@@ -0,0 +1,203 @@
+package scanner
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// axfrStub is a DNS server on TCP. Zones in zones are transferred as the
+// given messages of record types; any other zone gets rcode.
+type axfrStub struct {
+	zones map[string][][]uint16
+	rcode byte
+}
+
+func (s *axfrStub) serve(conn net.Conn) {
+	query, err := readDNSTCPMessage(conn)
+	if err != nil {
+		return
+	}
+	name, end, err := readDNSName(query, 12)
+	if err != nil || end+4 > len(query) {
+		return
+	}
+	messages, ok := s.zones[name]
+	if !ok {
+		conn.Write(dnsTCPReply(query[:end+4], s.rcode))
+		return
+	}
+	for _, types := range messages {
+		conn.Write(dnsTCPReply(query[:end+4], 0, types...))
+	}
+}
+
+// dnsTCPReply builds a length-prefixed response echoing question, with
+// one answer of each of types
+func dnsTCPReply(question []byte, rcode byte, types ...uint16) []byte {
+	resp := append([]byte(nil), question...)
+	resp[2] |= 0x80
+	resp[3] = 0x80 | rcode
+	binary.BigEndian.PutUint16(resp[6:8], uint16(len(types)))
+	for _, rtype := range types {
+		rdata := []byte{192, 0, 2, 1}
+		if rtype == dnsTypeSOA {
+			// Root MNAME and RNAME, then serial and the four timers
+			rdata = make([]byte, 22)
+		}
+		resp = append(resp, 0xc0, 12) // name: pointer to the question
+		resp = binary.BigEndian.AppendUint16(resp, rtype)
+		resp = binary.BigEndian.AppendUint16(resp, 1)
+		resp = binary.BigEndian.AppendUint32(resp, 300)
+		resp = binary.BigEndian.AppendUint16(resp, uint16(len(rdata)))
+		resp = append(resp, rdata...)
+	}
+	return append(binary.BigEndian.AppendUint16(nil, uint16(len(resp))), resp...)
+}
+
+// exampleZone is example.com transferred in two messages: six records
+// between its opening and closing SOA
+var exampleZone = [][]uint16{
+	{dnsTypeSOA, dnsTypeA, dnsTypeA, dnsTypeAAAA},
+	{dnsTypeA, dnsTypeA, dnsTypeA, dnsTypeSOA},
+}
+
+func TestAXFRCount(t *testing.T) {
+	stub := &axfrStub{zones: map[string][][]uint16{
+		"example.com.":  exampleZone,
+		"notazone.com.": {{dnsTypeA}},
+	}, rcode: dnsRcodeRefused}
+	ip, port := stubServer(t, stub.serve)
+	address := stubAddress(ip, port)
+	f := testFingerprinter()
+
+	if count, err := f.axfrCount(address, "example.com"); err != nil || count != 8 {
+		t.Errorf("allowed transfer = %d, %v, want 8 records", count, err)
+	}
+	for _, rcode := range []byte{dnsRcodeRefused, dnsRcodeNotAuth} {
+		stub.rcode = rcode
+		if _, err := f.axfrCount(address, "other.com"); err != errAXFRRefused {
+			t.Errorf("rcode %d = %v, want errAXFRRefused", rcode, err)
+		}
+	}
+	if _, err := f.axfrCount(address, "notazone.com"); err != errAXFRRefused {
+		t.Errorf("answer without a SOA = %v, want errAXFRRefused", err)
+	}
+
+	// Closing without an answer is a refusal; a server failure is not
+	closing, closingPort := stubServer(t, func(net.Conn) {})
+	if _, err := f.axfrCount(stubAddress(closing, closingPort), "example.com"); err != errAXFRRefused {
+		t.Errorf("closed without answering = %v, want errAXFRRefused", err)
+	}
+	stub.rcode = 2 // SERVFAIL
+	if _, err := f.axfrCount(address, "other.com"); err == nil || err == errAXFRRefused {
+		t.Errorf("SERVFAIL = %v, want an error other than a refusal", err)
+	}
+}
+
+func TestAXFRCandidateZones(t *testing.T) {
+	for hostname, want := range map[string][]string{
+		"ns1.dc.example.com.": {"dc.example.com", "example.com"},
+		"ns1.example.com":     {"example.com"},
+		"example.com":         nil,
+		"":                    nil,
+	} {
+		if got := axfrCandidateZones(hostname); !reflect.DeepEqual(got, want) {
+			t.Errorf("axfrCandidateZones(%q) = %v, want %v", hostname, got, want)
+		}
+	}
+}
+
+// axfrTarget serves stub on TCP 53 of a loopback address whose reverse
+// lookup, through a DoH stub, is ns1.dc.example.com, and returns a
+// fingerprinter with the test enabled and the address
+func axfrTarget(t *testing.T, stub *axfrStub) (*Fingerprinter, string) {
+	t.Helper()
+	const ip = "127.0.53.1"
+	listener, err := net.Listen("tcp", net.JoinHostPort(ip, "53"))
+	if err != nil {
+		t.Skipf("cannot listen on %s:53: %v", ip, err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				stub.serve(conn)
+			}()
+		}
+	}()
+
+	doh := httptest.NewServer(&dohStub{records: map[string]map[uint16][]string{
+		"1.53.0.127.in-addr.arpa.": {dnsTypePTR: {"ns1.dc.example.com."}},
+	}})
+	t.Cleanup(doh.Close)
+
+	f := testFingerprinter()
+	f.AXFRTest = true
+	f.Resolver = NewResolver(doh.URL+"/dns-query", f.Timeout)
+	return f, ip
+}
+
+func TestCheckAXFRAllowed(t *testing.T) {
+	// dc.example.com is refused, so the parent zone is tried next
+	f, ip := axfrTarget(t, &axfrStub{zones: map[string][][]uint16{"example.com.": exampleZone}, rcode: dnsRcodeRefused})
+
+	info := ServiceInfo{ServiceName: "dns"}
+	f.checkAXFR(context.Background(), ip, 53, &info)
+	want := map[string]interface{}{"axfr_allowed": true, "axfr_zone": "example.com", "axfr_record_count": 8}
+	if !reflect.DeepEqual(info.Fingerprint, want) {
+		t.Errorf("fingerprint = %v, want %v", info.Fingerprint, want)
+	}
+}
+
+func TestCheckAXFRRefused(t *testing.T) {
+	f, ip := axfrTarget(t, &axfrStub{rcode: dnsRcodeRefused})
+
+	info := ServiceInfo{ServiceName: "dns", Fingerprint: map[string]interface{}{"version": "9.18"}}
+	f.checkAXFR(context.Background(), ip, 53, &info)
+	want := map[string]interface{}{"version": "9.18", "axfr_allowed": false}
+	if !reflect.DeepEqual(info.Fingerprint, want) {
+		t.Errorf("fingerprint = %v, want %v", info.Fingerprint, want)
+	}
+}
+
+func TestCheckAXFRInconclusive(t *testing.T) {
+	f, ip := axfrTarget(t, &axfrStub{rcode: 2}) // SERVFAIL
+
+	info := ServiceInfo{ServiceName: "dns"}
+	f.checkAXFR(context.Background(), ip, 53, &info)
+	if info.Fingerprint != nil {
+		t.Errorf("fingerprint = %v, want nothing recorded for a server failure", info.Fingerprint)
+	}
+}
+
+func TestCheckAXFRSkipped(t *testing.T) {
+	f, ip := axfrTarget(t, &axfrStub{zones: map[string][][]uint16{"example.com.": exampleZone}})
+	resolver := f.Resolver
+	tests := []struct {
+		name     string
+		enabled  bool
+		port     int
+		resolver *Resolver
+	}{
+		{"disabled", false, 53, resolver},
+		{"not port 53", true, 5353, resolver},
+		{"no resolver", true, 53, nil},
+	}
+	for _, tt := range tests {
+		f.AXFRTest, f.Resolver = tt.enabled, tt.resolver
+		info := ServiceInfo{ServiceName: "dns"}
+		f.checkAXFR(context.Background(), ip, tt.port, &info)
+		if info.Fingerprint != nil {
+			t.Errorf("%s: fingerprint = %v, want nothing recorded", tt.name, info.Fingerprint)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package scanner
+
+import (
+	"log"
+	"sync/atomic"
+)
+
+// BodyBudget caps the response body bytes a scan reads and keeps across
+// all concurrent probes: native HTTP bodies and stored raw zgrab2 output.
+// Once it is spent, bodies are skipped while status lines, headers and
+// banners are still captured. A nil budget is unlimited.
+type BodyBudget struct {
+	limit   int64
+	used    atomic.Int64
+	skipped atomic.Int64
+	warned  atomic.Bool
+}
+
+// NewBodyBudget creates a budget of limit bytes
+func NewBodyBudget(limit int64) *BodyBudget {
+	return &BodyBudget{limit: limit}
+}
+
+// Reset starts a new scan with the whole budget available
+func (b *BodyBudget) Reset() {
+	if b == nil {
+		return
+	}
+	b.used.Store(0)
+	b.skipped.Store(0)
+	b.warned.Store(false)
+}
+
+// Limit returns the budget in bytes, 0 when unlimited
+func (b *BodyBudget) Limit() int64 {
+	if b == nil {
+		return 0
+	}
+	return b.limit
+}
+
+// Used returns the bytes taken so far this scan
+func (b *BodyBudget) Used() int64 {
+	if b == nil {
+		return 0
+	}
+	return b.used.Load()
+}
+
+// Skipped returns how many bodies were skipped or cut short this scan
+func (b *BodyBudget) Skipped() int64 {
+	if b == nil {
+		return 0
+	}
+	return b.skipped.Load()
+}
+
+// take reserves up to n bytes and returns how many were granted, which is
+// less than n once the budget runs low and 0 once it is spent
+func (b *BodyBudget) take(n int) int {
+	if b == nil {
+		return n
+	}
+	for {
+		used := b.used.Load()
+		grant := min(int64(n), max(b.limit-used, 0))
+		if grant == 0 || b.used.CompareAndSwap(used, used+grant) {
+			return int(grant)
+		}
+	}
+}
+
+// skip counts a body dropped or cut short for lack of budget, warning on
+// the scan's first
+func (b *BodyBudget) skip() {
+	if b == nil {
+		return
+	}
+	b.skipped.Add(1)
+	if b.warned.CompareAndSwap(false, true) {
+		log.Printf("Warning: max_total_body_bytes of %d reached; skipping further response bodies this scan", b.limit)
+	}
+}
+
+// refund returns n reserved bytes that went unused
+func (b *BodyBudget) refund(n int) {
+	if b == nil || n <= 0 {
+		return
+	}
+	b.used.Add(-int64(n))
+}
@@ -0,0 +1,99 @@
+package scanner
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// FingerprintCache memoizes another HostFingerprinter by IP:port, so an
+// endpoint reached twice in one scan (e.g. via overlapping networks) is only
+// probed once. Concurrent requests for the same endpoint wait for the first
+// probe instead of starting their own. Create one per scan.
+type FingerprintCache struct {
+	fp HostFingerprinter
+
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+}
+
+type cacheEntry struct {
+	done   chan struct{} // closed once the probe finishes or is abandoned
+	info   ServiceInfo
+	probed bool
+}
+
+// NewFingerprintCache wraps fp with an empty cache
+func NewFingerprintCache(fp HostFingerprinter) *FingerprintCache {
+	return &FingerprintCache{
+		fp:      fp,
+		entries: make(map[string]*cacheEntry),
+	}
+}
+
+// FingerprintHost returns cached results where available and probes the rest
+func (c *FingerprintCache) FingerprintHost(ctx context.Context, ip string, ports []int) map[int]ServiceInfo {
+	results := make(map[int]ServiceInfo)
+
+	for _, port := range ports {
+		key := net.JoinHostPort(ip, strconv.Itoa(port))
+
+		c.mu.Lock()
+		entry, ok := c.entries[key]
+		if !ok {
+			entry = &cacheEntry{done: make(chan struct{})}
+			c.entries[key] = entry
+		}
+		c.mu.Unlock()
+
+		if ok {
+			select {
+			case <-entry.done:
+				if !entry.probed {
+					return results
+				}
+				results[port] = cloneServiceInfo(entry.info)
+			case <-ctx.Done():
+				return results
+			}
+			continue
+		}
+
+		info, probed := c.fp.FingerprintHost(ctx, ip, []int{port})[port]
+		if !probed {
+			// Cancelled before probing; let a later caller try again
+			c.mu.Lock()
+			delete(c.entries, key)
+			c.mu.Unlock()
+			close(entry.done)
+			return results
+		}
+		entry.info = info
+		entry.probed = true
+		close(entry.done)
+		results[port] = cloneServiceInfo(info)
+	}
+
+	return results
+}
+
+// Len returns the number of endpoints cached
+func (c *FingerprintCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// cloneServiceInfo copies info with its own Fingerprint map, since callers
+// add keys (geo, timings) to the map they get back
+func cloneServiceInfo(info ServiceInfo) ServiceInfo {
+	if info.Fingerprint != nil {
+		fp := make(map[string]interface{}, len(info.Fingerprint))
+		for k, v := range info.Fingerprint {
+			fp[k] = v
+		}
+		info.Fingerprint = fp
+	}
+	return info
+}
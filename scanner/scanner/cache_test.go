@@ -0,0 +1,146 @@
+package scanner
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingFingerprinter counts probes per IP:port, holding each for delay;
+// cancelled contexts probe nothing
+type countingFingerprinter struct {
+	delay time.Duration
+
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func (f *countingFingerprinter) FingerprintHost(ctx context.Context, ip string, ports []int) map[int]ServiceInfo {
+	results := make(map[int]ServiceInfo, len(ports))
+	for _, port := range ports {
+		if ctx.Err() != nil {
+			return results
+		}
+		f.mu.Lock()
+		if f.calls == nil {
+			f.calls = make(map[string]int)
+		}
+		f.calls[stubAddress(ip, port)]++
+		f.mu.Unlock()
+		time.Sleep(f.delay)
+		results[port] = ServiceInfo{ServiceName: "ssh", Fingerprint: map[string]interface{}{"port": port}}
+	}
+	return results
+}
+
+func (f *countingFingerprinter) count(address string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[address]
+}
+
+func TestFingerprintCacheProbesOnce(t *testing.T) {
+	fp := &countingFingerprinter{}
+	cache := NewFingerprintCache(fp)
+
+	first := cache.FingerprintHost(context.Background(), "10.0.0.1", []int{22, 80})
+	second := cache.FingerprintHost(context.Background(), "10.0.0.1", []int{22, 443})
+	cache.FingerprintHost(context.Background(), "10.0.0.2", []int{22})
+
+	for address, want := range map[string]int{"10.0.0.1:22": 1, "10.0.0.1:80": 1, "10.0.0.1:443": 1, "10.0.0.2:22": 1} {
+		if got := fp.count(address); got != want {
+			t.Errorf("%s probed %d times, want %d", address, got, want)
+		}
+	}
+	if second[22].ServiceName != "ssh" || len(second) != 2 || len(first) != 2 {
+		t.Errorf("results = %v then %v", first, second)
+	}
+	if cache.Len() != 4 {
+		t.Errorf("Len() = %d, want 4", cache.Len())
+	}
+
+	// Callers get their own Fingerprint map
+	first[22].Fingerprint["geo"] = "added"
+	if again := cache.FingerprintHost(context.Background(), "10.0.0.1", []int{22}); again[22].Fingerprint["geo"] != nil {
+		t.Errorf("cached Fingerprint was modified through a result: %v", again[22].Fingerprint)
+	}
+}
+
+func TestFingerprintCacheConcurrentCallers(t *testing.T) {
+	fp := &countingFingerprinter{delay: 20 * time.Millisecond}
+	cache := NewFingerprintCache(fp)
+
+	var wg sync.WaitGroup
+	results := make([]map[int]ServiceInfo, 32)
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = cache.FingerprintHost(context.Background(), "10.0.0.1", []int{22})
+		}()
+	}
+	wg.Wait()
+
+	if got := fp.count("10.0.0.1:22"); got != 1 {
+		t.Errorf("probed %d times under %d concurrent callers, want 1", got, len(results))
+	}
+	for i, r := range results {
+		if r[22].ServiceName != "ssh" {
+			t.Errorf("caller %d got %v", i, r)
+		}
+	}
+}
+
+func TestFingerprintCacheRetriesCancelledProbe(t *testing.T) {
+	fp := &countingFingerprinter{}
+	cache := NewFingerprintCache(fp)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if got := cache.FingerprintHost(ctx, "10.0.0.1", []int{22}); len(got) != 0 {
+		t.Errorf("cancelled lookup = %v, want nothing", got)
+	}
+	if cache.Len() != 0 {
+		t.Errorf("abandoned probe left %d cache entries", cache.Len())
+	}
+
+	if got := cache.FingerprintHost(context.Background(), "10.0.0.1", []int{22}); got[22].ServiceName != "ssh" {
+		t.Errorf("retry after cancellation = %v", got)
+	}
+	if got := fp.count("10.0.0.1:22"); got != 1 {
+		t.Errorf("probed %d times, want 1", got)
+	}
+}
+
+func TestFingerprintAllWithOverlappingTargets(t *testing.T) {
+	fp := &countingFingerprinter{}
+	cache := NewFingerprintCache(fp)
+	// The same endpoints reached through two overlapping networks
+	targets := append(stubHosts(20, 22), stubHosts(20, 22)...)
+
+	var mu sync.Mutex
+	seen := 0
+	FingerprintAll(context.Background(), cache, targets, 8, nil, func(ZmapResult, ServiceInfo) {
+		mu.Lock()
+		seen++
+		mu.Unlock()
+	})
+	if seen != len(targets) {
+		t.Errorf("got %d results, want %d", seen, len(targets))
+	}
+	for _, target := range stubHosts(20, 22) {
+		if got := fp.count(stubAddress(target.IP, target.Port)); got != 1 {
+			t.Errorf("%s probed %d times, want 1", target.IP, got)
+		}
+	}
+}
+
+func TestFingerprintCachePerScan(t *testing.T) {
+	fp := &countingFingerprinter{}
+	NewFingerprintCache(fp).FingerprintHost(context.Background(), "10.0.0.1", []int{22})
+	NewFingerprintCache(fp).FingerprintHost(context.Background(), "10.0.0.1", []int{22})
+	if got := fp.count("10.0.0.1:22"); got != 2 {
+		t.Errorf("probed %d times across two caches, want 2", got)
+	}
+}
@@ -0,0 +1,142 @@
+package scanner
+
+import "sort"
+
+// ServiceCapability is one service a fingerprinter identifies and the ports
+// routed to it
+type ServiceCapability struct {
+	Service string `json:"service"`
+	Ports   []int  `json:"ports"`
+}
+
+// portProtocol is a protocol, the ports routed to it, and how each
+// fingerprinter handles it
+type portProtocol struct {
+	Service string
+	Ports   []int
+	probe   func(f *Fingerprinter, ip string, port int) ServiceInfo // native probe; nil for a banner grab
+	zgrab   string                                                  // zgrab2 module; "" for the native probe, or banner without one
+}
+
+// protocols is the one port→protocol table that both fingerprinters and the
+// capabilities report route by. Ports not listed get a generic banner grab.
+var protocols = []portProtocol{
+	{"ftp", []int{21}, (*Fingerprinter).probeFTP, "ftp"},
+	{"ssh", []int{22}, (*Fingerprinter).probeSSH, "ssh"},
+	{"telnet", []int{23}, (*Fingerprinter).probeTelnet, "telnet"},
+	{"smtp", []int{25, 587}, (*Fingerprinter).probeSMTP, "smtp"},
+	// zgrab2 runs smtp with --smtps here, and the tls-suffixed mail
+	// protocols below with --imaps and --pop3s
+	{"smtps", []int{465}, (*Fingerprinter).probeSMTP, "smtp"},
+	{"http", []int{80, 8080, 8000, 8888}, func(f *Fingerprinter, ip string, port int) ServiceInfo {
+		return f.probeHTTP(ip, port, false)
+	}, "http"},
+	{"http-proxy", []int{3128}, func(f *Fingerprinter, ip string, port int) ServiceInfo {
+		info := f.probeHTTP(ip, port, false)
+		info.ServiceName = "http-proxy"
+		return info
+	}, "http"},
+	{"socks", []int{1080}, (*Fingerprinter).probeSOCKS, ""},
+	{"docker", []int{2375, 2376}, func(f *Fingerprinter, ip string, port int) ServiceInfo {
+		return f.probeDocker(ip, port, port == 2376)
+	}, ""},
+	{"kubernetes", []int{6443, 10250}, (*Fingerprinter).probeKubernetes, ""},
+	{"kubelet", []int{10255}, (*Fingerprinter).probeKubeletReadOnly, ""},
+	// zgrab2 runs http with --use-https here
+	{"https", []int{443, 8443}, func(f *Fingerprinter, ip string, port int) ServiceInfo {
+		return f.probeHTTP(ip, port, true)
+	}, "http"},
+	{"pop3", []int{110}, (*Fingerprinter).probePOP3, "pop3"},
+	{"pop3s", []int{995}, nil, "pop3"},
+	{"imap", []int{143}, (*Fingerprinter).probeIMAP, "imap"},
+	{"imaps", []int{993}, nil, "imap"},
+	{"mysql", []int{3306}, (*Fingerprinter).probeMySQL, "mysql"},
+	{"postgresql", []int{5432}, (*Fingerprinter).probePostgreSQL, "postgres"},
+	{"redis", []int{6379}, (*Fingerprinter).probeRedis, "redis"},
+	{"mongodb", []int{27017}, (*Fingerprinter).probeMongoDB, "mongodb"},
+	{"tacacs", []int{49}, (*Fingerprinter).probeTACACS, ""},
+}
+
+// protocolByPort indexes protocols by port
+var protocolByPort = func() map[int]*portProtocol {
+	index := make(map[int]*portProtocol)
+	for i := range protocols {
+		for _, port := range protocols[i].Ports {
+			index[port] = &protocols[i]
+		}
+	}
+	return index
+}()
+
+// portService returns the service port is routed to, "" for a banner grab
+func portService(port int) string {
+	if p := protocolByPort[port]; p != nil {
+		return p.Service
+	}
+	return ""
+}
+
+// nativeProbe returns the native probe for port, or nil if it only gets a
+// banner grab
+func nativeProbe(port int) func(f *Fingerprinter, ip string, port int) ServiceInfo {
+	if p := protocolByPort[port]; p != nil {
+		return p.probe
+	}
+	return nil
+}
+
+// getZgrabModule returns the zgrab2 module for port, banner when its
+// protocol has none
+func getZgrabModule(port int) string {
+	if p := protocolByPort[port]; p != nil && p.zgrab != "" {
+		return p.zgrab
+	}
+	return "banner"
+}
+
+// NativeCapabilities lists the services the native fingerprinter has a
+// protocol probe for, in routing-table order
+func NativeCapabilities() []ServiceCapability {
+	var capabilities []ServiceCapability
+	for _, p := range protocols {
+		if p.probe != nil {
+			capabilities = append(capabilities, ServiceCapability{Service: p.Service, Ports: sortedPorts(p.Ports)})
+		}
+	}
+	return capabilities
+}
+
+// ZgrabCapabilities lists the zgrab2 modules and the ports sent to each.
+// Ports with a native probe but no zgrab2 module (SOCKS, container APIs,
+// TACACS+) always go to the native probes.
+func ZgrabCapabilities() []ServiceCapability {
+	var modules []string
+	portsByModule := make(map[string][]int)
+	for _, p := range protocols {
+		if p.zgrab == "" {
+			continue
+		}
+		if _, ok := portsByModule[p.zgrab]; !ok {
+			modules = append(modules, p.zgrab)
+		}
+		portsByModule[p.zgrab] = append(portsByModule[p.zgrab], p.Ports...)
+	}
+
+	capabilities := make([]ServiceCapability, len(modules))
+	for i, module := range modules {
+		capabilities[i] = ServiceCapability{Service: module, Ports: sortedPorts(portsByModule[module])}
+	}
+	return capabilities
+}
+
+// zgrabNativePort reports whether port skips zgrab2 for the native probe:
+// it has one, and zgrab2 would only grab a banner
+func zgrabNativePort(port int) bool {
+	return nativeProbe(port) != nil && getZgrabModule(port) == "banner"
+}
+
+func sortedPorts(ports []int) []int {
+	sorted := append([]int(nil), ports...)
+	sort.Ints(sorted)
+	return sorted
+}
@@ -0,0 +1,128 @@
+package scanner
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// zgrabModuleFor is the zgrab2 module each routed service runs under when
+// it isn't the service's own name
+var zgrabModuleFor = map[string]string{
+	"https":      "http",
+	"http-proxy": "http",
+	"smtps":      "smtp",
+	"imaps":      "imap",
+	"pop3s":      "pop3",
+	"postgresql": "postgres",
+}
+
+func TestRoutingAgreesForCommonPorts(t *testing.T) {
+	for _, port := range CommonPorts() {
+		service, module := portService(port), getZgrabModule(port)
+		if got := GetModuleForPort(port); got != module {
+			t.Errorf("port %d: GetModuleForPort = %q, getZgrabModule = %q", port, got, module)
+		}
+
+		switch {
+		case service == "":
+			// Unrouted: a banner grab in both modes
+			if nativeProbe(port) != nil || module != "banner" {
+				t.Errorf("port %d: unrouted but native probe %v, zgrab2 module %q", port, nativeProbe(port) != nil, module)
+			}
+		case module == "banner":
+			// No zgrab2 module: zgrab2 mode hands the port to the native probe
+			if nativeProbe(port) == nil || !zgrabNativePort(port) {
+				t.Errorf("port %d (%s): no zgrab2 module and no native route", port, service)
+			}
+		default:
+			want := service
+			if m, ok := zgrabModuleFor[service]; ok {
+				want = m
+			}
+			if module != want {
+				t.Errorf("port %d (%s): zgrab2 module %q, want %q", port, service, module, want)
+			}
+			if zgrabNativePort(port) {
+				t.Errorf("port %d (%s): skips its zgrab2 module", port, service)
+			}
+		}
+	}
+}
+
+func TestPortServiceTLSVariants(t *testing.T) {
+	for port, service := range map[int]string{
+		80: "http", 8080: "http", 8000: "http", 8888: "http", 443: "https", 8443: "https",
+		25: "smtp", 587: "smtp", 465: "smtps", 143: "imap", 993: "imaps", 110: "pop3", 995: "pop3s",
+		3128: "http-proxy", 5900: "",
+	} {
+		if got := portService(port); got != service {
+			t.Errorf("portService(%d) = %q, want %q", port, got, service)
+		}
+	}
+	// The capabilities report lists each port once
+	seen := map[int]string{}
+	for _, c := range NativeCapabilities() {
+		for _, port := range c.Ports {
+			if other, ok := seen[port]; ok {
+				t.Errorf("port %d listed under %s and %s", port, other, c.Service)
+			}
+			seen[port] = c.Service
+		}
+	}
+}
+
+func TestZgrabFlagsFollowTable(t *testing.T) {
+	z, calls := cannedZgrab(t, `{"ip":"127.0.0.1","data":{}}`)
+	want := map[int]string{
+		80:  "http -p 80 --max-redirects 3",
+		443: "http -p 443 --use-https --max-redirects 3",
+		25:  "smtp -p 25 --send-ehlo --ehlo-domain scanner.local --starttls",
+		465: "smtp -p 465 --send-ehlo --ehlo-domain scanner.local --smtps",
+		143: "imap -p 143 --starttls",
+		993: "imap -p 993 --imaps",
+		110: "pop3 -p 110 --starttls",
+		995: "pop3 -p 995 --pop3s",
+	}
+	ports := make([]int, 0, len(want))
+	for port := range want {
+		ports = append(ports, port)
+	}
+	slices.Sort(ports)
+	for _, port := range ports {
+		z.fingerprintPort(context.Background(), "127.0.0.1", port)
+	}
+
+	out, err := os.ReadFile(calls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runs := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if len(runs) != len(ports) {
+		t.Fatalf("zgrab2 runs = %q, want one per port", runs)
+	}
+	for i, port := range ports {
+		if runs[i] != want[port] {
+			t.Errorf("port %d: zgrab2 %s, want %s", port, runs[i], want[port])
+		}
+	}
+}
+
+func TestZgrabHTTPNamedLikeNative(t *testing.T) {
+	z := &ZgrabFingerprinter{}
+	result := &ZgrabResult{Data: map[string]*ZgrabModule{"http": {
+		Status: "success",
+		Result: json.RawMessage(`{"response":{"status_code":200,"status_line":"200 OK"}}`),
+	}}}
+	for _, port := range CommonPorts() {
+		if getZgrabModule(port) != "http" {
+			continue
+		}
+		if got := z.parseZgrabResult(result, "http", port).ServiceName; got != portService(port) {
+			t.Errorf("port %d: zgrab2 names it %q, native routes it to %q", port, got, portService(port))
+		}
+	}
+}
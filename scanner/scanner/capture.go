@@ -0,0 +1,22 @@
+package scanner
+
+// CaptureSnaplen is the most of each packet a scan capture keeps, enough
+// for the Ethernet, IP and TCP headers plus the start of any banner
+const CaptureSnaplen = 256
+
+// pcap framing sizes, counted against the capture size limit
+const (
+	pcapFileHeaderLen   = 24
+	pcapPacketHeaderLen = 16
+)
+
+// DefaultCaptureMaxBytes bounds a capture file when no limit is configured
+const DefaultCaptureMaxBytes = 100 << 20
+
+// CaptureStats summarizes a finished capture
+type CaptureStats struct {
+	Path    string
+	Packets int
+	Bytes   int64
+	Limited bool // recording stopped early at the size limit
+}
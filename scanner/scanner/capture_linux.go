@@ -0,0 +1,203 @@
+package scanner
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"golang.org/x/sys/unix"
+)
+
+// captureReadTimeout is how often the capture loop checks for Stop on a
+// quiet interface
+const captureReadTimeout = 200 * time.Millisecond
+
+// Capture records the scan's TCP packets to a pcap file through an
+// AF_PACKET socket, which needs root or CAP_NET_RAW. Each packet is cut to
+// CaptureSnaplen bytes and recording stops once the file would pass the
+// size limit.
+type Capture struct {
+	path     string
+	maxBytes int64
+
+	fd       int
+	loopback map[int]bool // interface indexes that see each packet twice
+	file     *os.File
+	out      *bufio.Writer
+	pcap     *pcapgo.Writer
+
+	stop chan struct{}
+	done chan struct{}
+
+	mu      sync.Mutex
+	packets int
+	written int64
+	limited bool
+	err     error
+}
+
+// StartCapture starts recording TCP traffic on iface ("" for every
+// interface) to path, truncating any existing file
+func StartCapture(path, iface string, maxBytes int64) (*Capture, error) {
+	ifindex := 0
+	if iface != "" {
+		intf, err := net.InterfaceByName(iface)
+		if err != nil {
+			return nil, fmt.Errorf("capture interface %s: %w", iface, err)
+		}
+		ifindex = intf.Index
+	}
+
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW, int(htons(unix.ETH_P_ALL)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open packet socket (needs root or CAP_NET_RAW): %w", err)
+	}
+	if err := unix.Bind(fd, &unix.SockaddrLinklayer{Protocol: htons(unix.ETH_P_ALL), Ifindex: ifindex}); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("failed to bind packet socket: %w", err)
+	}
+	timeout := unix.NsecToTimeval(captureReadTimeout.Nanoseconds())
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &timeout); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("failed to set capture read timeout: %w", err)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	out := bufio.NewWriter(file)
+	writer := pcapgo.NewWriter(out)
+	if err := writer.WriteFileHeader(CaptureSnaplen, layers.LinkTypeEthernet); err != nil {
+		unix.Close(fd)
+		file.Close()
+		return nil, err
+	}
+
+	c := &Capture{
+		path:     path,
+		maxBytes: maxBytes,
+		fd:       fd,
+		loopback: loopbackIndexes(),
+		file:     file,
+		out:      out,
+		pcap:     writer,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+		written:  pcapFileHeaderLen,
+	}
+	go c.loop()
+	return c, nil
+}
+
+// loop reads packets until Stop or the size limit
+func (c *Capture) loop() {
+	defer close(c.done)
+
+	buf := make([]byte, 1<<16)
+	for {
+		select {
+		case <-c.stop:
+			return
+		default:
+		}
+
+		n, from, err := unix.Recvfrom(c.fd, buf, unix.MSG_TRUNC)
+		if err != nil {
+			if errors.Is(err, unix.EAGAIN) || errors.Is(err, unix.EINTR) {
+				continue
+			}
+			c.fail(err)
+			return
+		}
+		// Loopback delivers every packet as both outgoing and incoming
+		if ll, ok := from.(*unix.SockaddrLinklayer); ok && ll.Pkttype == unix.PACKET_OUTGOING && c.loopback[ll.Ifindex] {
+			continue
+		}
+		if n > len(buf) {
+			n = len(buf)
+		}
+		if !isTCPFrame(buf[:n]) {
+			continue
+		}
+
+		captured := min(n, CaptureSnaplen)
+		size := int64(pcapPacketHeaderLen + captured)
+		if c.maxBytes > 0 && c.written+size > c.maxBytes {
+			c.mu.Lock()
+			c.limited = true
+			c.mu.Unlock()
+			return
+		}
+
+		ci := gopacket.CaptureInfo{Timestamp: time.Now(), CaptureLength: captured, Length: n}
+		if err := c.pcap.WritePacket(ci, buf[:captured]); err != nil {
+			c.fail(err)
+			return
+		}
+		c.mu.Lock()
+		c.packets++
+		c.written += size
+		c.mu.Unlock()
+	}
+}
+
+func (c *Capture) fail(err error) {
+	c.mu.Lock()
+	c.err = err
+	c.mu.Unlock()
+}
+
+// Stop ends the capture and closes the file
+func (c *Capture) Stop() (CaptureStats, error) {
+	close(c.stop)
+	<-c.done
+	unix.Close(c.fd)
+
+	err := c.out.Flush()
+	if closeErr := c.file.Close(); err == nil {
+		err = closeErr
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		err = c.err
+	}
+	return CaptureStats{Path: c.path, Packets: c.packets, Bytes: c.written, Limited: c.limited}, err
+}
+
+// isTCPFrame reports whether an Ethernet frame carries IPv4 or IPv6 TCP
+func isTCPFrame(frame []byte) bool {
+	if len(frame) < 14 {
+		return false
+	}
+	switch layers.EthernetType(uint16(frame[12])<<8 | uint16(frame[13])) {
+	case layers.EthernetTypeIPv4:
+		return len(frame) > 23 && layers.IPProtocol(frame[23]) == layers.IPProtocolTCP
+	case layers.EthernetTypeIPv6:
+		return len(frame) > 20 && layers.IPProtocol(frame[20]) == layers.IPProtocolTCP
+	}
+	return false
+}
+
+func loopbackIndexes() map[int]bool {
+	indexes := make(map[int]bool)
+	interfaces, _ := net.Interfaces()
+	for _, intf := range interfaces {
+		if intf.Flags&net.FlagLoopback != 0 {
+			indexes[intf.Index] = true
+		}
+	}
+	return indexes
+}
+
+func htons(v uint16) uint16 { return v<<8 | v>>8 }
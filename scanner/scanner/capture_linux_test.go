@@ -0,0 +1,157 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"golang.org/x/sys/unix"
+)
+
+// startLoopbackCapture starts a capture on lo, skipping the test without
+// the privileges a packet socket needs
+func startLoopbackCapture(t *testing.T, maxBytes int64) (*Capture, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "scan.pcap")
+	c, err := StartCapture(path, "lo", maxBytes)
+	if errors.Is(err, unix.EPERM) || errors.Is(err, unix.EACCES) {
+		t.Skipf("packet capture needs root or CAP_NET_RAW: %v", err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, path
+}
+
+// readCapture decodes every packet in a pcap file
+func readCapture(t *testing.T, path string) []gopacket.Packet {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := pcapgo.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.LinkType() != layers.LinkTypeEthernet || r.Snaplen() != CaptureSnaplen {
+		t.Errorf("pcap link type %v, snaplen %d, want Ethernet and %d", r.LinkType(), r.Snaplen(), CaptureSnaplen)
+	}
+	var packets []gopacket.Packet
+	for {
+		data, _, err := r.ReadPacketData()
+		if err == io.EOF {
+			return packets
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		packets = append(packets, gopacket.NewPacket(data, layers.LinkTypeEthernet, gopacket.Default))
+	}
+}
+
+func TestCaptureRecordsScanPackets(t *testing.T) {
+	port := listenOn(t, "127.0.0.1", 0)
+	c, path := startLoopbackCapture(t, DefaultCaptureMaxBytes)
+
+	s := NewTCPScanner([]string{"127.0.0.1/32"}, 4, 1)
+	if _, err := s.ScanPortsWithCallback(context.Background(), []int{port}, nil); err != nil {
+		c.Stop()
+		t.Fatal(err)
+	}
+	// Let the capture loop drain the handshake before stopping it
+	eventually(func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.packets >= 3
+	})
+	stats, err := c.Stop()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var syn, synAck int
+	for _, packet := range readCapture(t, path) {
+		tcp, ok := packet.TransportLayer().(*layers.TCP)
+		if !ok {
+			t.Fatalf("captured a non-TCP packet: %v", packet)
+		}
+		switch {
+		case tcp.SYN && !tcp.ACK && int(tcp.DstPort) == port:
+			syn++
+		case tcp.SYN && tcp.ACK && int(tcp.SrcPort) == port:
+			synAck++
+		}
+	}
+	// Loopback shows each packet twice unless outgoing copies are dropped
+	if syn != 1 || synAck != 1 {
+		t.Errorf("captured %d SYNs and %d SYN-ACKs for port %d, want one of each", syn, synAck, port)
+	}
+	if stats.Packets < 2 || stats.Limited {
+		t.Errorf("stats = %+v, want the handshake recorded without hitting the limit", stats)
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() != stats.Bytes {
+		t.Errorf("file size %v, stats.Bytes %d, want equal", info, stats.Bytes)
+	}
+}
+
+func TestCaptureSizeLimit(t *testing.T) {
+	port := listenOn(t, "127.0.0.1", 0)
+	// Room for the file header and two packets
+	limit := int64(pcapFileHeaderLen + 2*(pcapPacketHeaderLen+CaptureSnaplen))
+	c, path := startLoopbackCapture(t, limit)
+
+	s := NewTCPScanner([]string{"127.0.0.1/32"}, 4, 1)
+	for range 5 {
+		s.ScanPortsWithCallback(context.Background(), []int{port}, nil)
+	}
+	if !eventually(func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.limited
+	}) {
+		t.Fatal("capture never reached its size limit")
+	}
+	stats, err := c.Stop()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Bytes > limit || !stats.Limited {
+		t.Errorf("stats = %+v, want limited to %d bytes", stats, limit)
+	}
+	if packets := readCapture(t, path); len(packets) != stats.Packets {
+		t.Errorf("file holds %d packets, stats report %d", len(packets), stats.Packets)
+	}
+}
+
+func TestIsTCPFrame(t *testing.T) {
+	frame := func(ethertype uint16, protoOffset int, proto byte) []byte {
+		b := make([]byte, 60)
+		b[12], b[13] = byte(ethertype>>8), byte(ethertype)
+		b[protoOffset] = proto
+		return b
+	}
+	for _, tc := range []struct {
+		name  string
+		frame []byte
+		want  bool
+	}{
+		{"IPv4 TCP", frame(0x0800, 23, 6), true},
+		{"IPv4 UDP", frame(0x0800, 23, 17), false},
+		{"IPv6 TCP", frame(0x86dd, 20, 6), true},
+		{"IPv6 ICMPv6", frame(0x86dd, 20, 58), false},
+		{"ARP", frame(0x0806, 23, 6), false},
+		{"short", make([]byte, 10), false},
+	} {
+		if got := isTCPFrame(tc.frame); got != tc.want {
+			t.Errorf("%s: isTCPFrame = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package scanner
+
+import "errors"
+
+// Capture is only implemented on Linux, where it uses AF_PACKET sockets
+type Capture struct{}
+
+// StartCapture always fails off Linux
+func StartCapture(path, iface string, maxBytes int64) (*Capture, error) {
+	return nil, errors.New("packet capture is only supported on Linux")
+}
+
+// Stop does nothing off Linux
+func (c *Capture) Stop() (CaptureStats, error) {
+	return CaptureStats{}, nil
+}
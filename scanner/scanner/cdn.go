@@ -0,0 +1,59 @@
+package scanner
+
+import "strings"
+
+// cdnSignature identifies a CDN or WAF by one response header: present at
+// all when contains is empty, otherwise containing it (case-insensitive)
+type cdnSignature struct {
+	provider string
+	header   string
+	contains string
+}
+
+// cdnSignatures is checked in order; the first match names the provider
+var cdnSignatures = []cdnSignature{
+	{"cloudflare", "CF-Ray", ""},
+	{"cloudflare", "Server", "cloudflare"},
+	{"akamai", "X-Akamai-Transformed", ""},
+	{"akamai", "X-Akamai-Request-ID", ""},
+	{"akamai", "Akamai-GRN", ""},
+	{"akamai", "Server", "akamaighost"},
+	{"fastly", "X-Fastly-Request-ID", ""},
+	{"fastly", "Fastly-Debug-Digest", ""},
+	{"fastly", "X-Served-By", "cache-"},
+	{"cloudfront", "X-Amz-Cf-Id", ""},
+	{"cloudfront", "Via", "cloudfront"},
+	{"azure-front-door", "X-Azure-Ref", ""},
+	{"imperva", "X-Iinfo", ""},
+	{"imperva", "X-CDN", "incapsula"},
+	{"sucuri", "X-Sucuri-ID", ""},
+	{"sucuri", "Server", "sucuri"},
+}
+
+// detectCDN names the CDN or WAF fronting a service from its response
+// headers, or returns "" for what looks like a direct origin. header
+// returns a header's value, "" when absent.
+func detectCDN(header func(name string) string) string {
+	for _, sig := range cdnSignatures {
+		value := header(sig.header)
+		if value == "" {
+			continue
+		}
+		if sig.contains == "" || strings.Contains(strings.ToLower(value), sig.contains) {
+			return sig.provider
+		}
+	}
+	return ""
+}
+
+// zgrabHeader looks a header up in zgrab2's header map, whose keys are
+// lowercase with dashes written as underscores
+func zgrabHeader(headers map[string]string) func(name string) string {
+	return func(name string) string {
+		name = strings.ToLower(name)
+		if value, ok := headers[name]; ok {
+			return value
+		}
+		return headers[strings.ReplaceAll(name, "-", "_")]
+	}
+}
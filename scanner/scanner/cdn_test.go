@@ -0,0 +1,75 @@
+package scanner
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+// cdnResponse is a canned page served with extra header lines
+func cdnResponse(headers string) string {
+	const page = "<html><head><title>Shop</title></head></html>"
+	return "HTTP/1.1 200 OK\r\nContent-Type: text/html\r\n" + headers +
+		"Content-Length: 45\r\n\r\n" + page
+}
+
+func TestProbeHTTPDetectsCDN(t *testing.T) {
+	for name, tt := range map[string]struct {
+		headers string
+		want    string
+	}{
+		"cloudflare ray":   {"Server: cloudflare\r\nCF-Ray: 8c2f1a7b9e4d3f21-AMS\r\n", "cloudflare"},
+		"cloudflare only":  {"Server: cloudflare\r\n", "cloudflare"},
+		"akamai":           {"Server: AkamaiGHost\r\n", "akamai"},
+		"akamai via id":    {"Server: Apache\r\nX-Akamai-Request-ID: 3f0a2c\r\n", "akamai"},
+		"fastly":           {"Server: nginx\r\nX-Served-By: cache-ams21045-AMS\r\nX-Fastly-Request-ID: 9a1b\r\n", "fastly"},
+		"cloudfront":       {"Server: AmazonS3\r\nVia: 1.1 5e9c.cloudfront.net (CloudFront)\r\n", "cloudfront"},
+		"direct origin":    {"Server: nginx/1.24.0\r\nVia: 1.1 varnish\r\n", ""},
+		"served by origin": {"Server: nginx/1.24.0\r\nX-Served-By: web-03\r\n", ""},
+	} {
+		ip, port := cannedServer(t, cdnResponse(tt.headers))
+		info := testFingerprinter().probeHTTP(ip, port, false)
+		if info.Fingerprint["title"] != "Shop" {
+			t.Fatalf("%s: fingerprint = %v, want the page parsed", name, info.Fingerprint)
+		}
+		got, _ := info.Fingerprint["cdn"].(string)
+		if got != tt.want {
+			t.Errorf("%s: cdn = %q, want %q", name, got, tt.want)
+		}
+		if _, ok := info.Fingerprint["cdn"]; tt.want == "" && ok {
+			t.Errorf("%s: cdn recorded for a direct origin", name)
+		}
+	}
+}
+
+func TestDetectCDNFirstSignatureWins(t *testing.T) {
+	// Akamai in front of CloudFront: the table's order decides
+	header := http.Header{}
+	header.Set("Via", "1.1 abc.cloudfront.net (CloudFront)")
+	header.Set("X-Akamai-Transformed", "9 - 0 pmb=mRUM,1")
+	if got := detectCDN(header.Get); got != "akamai" {
+		t.Errorf("detectCDN = %q, want akamai", got)
+	}
+	if got := detectCDN(http.Header{}.Get); got != "" {
+		t.Errorf("detectCDN of no headers = %q", got)
+	}
+}
+
+func TestZgrabDetectsCDN(t *testing.T) {
+	z := &ZgrabFingerprinter{}
+	for headers, want := range map[string]string{
+		`{"server":"cloudflare","cf_ray":"8c2f1a7b9e4d3f21-AMS"}`: "cloudflare",
+		`{"server":"nginx","x_amz_cf_id":"Yk3Qj"}`:                "cloudfront",
+		`{"server":"nginx","x-fastly-request-id":"9a1b"}`:         "fastly",
+		`{"server":"nginx/1.24.0"}`:                               "",
+	} {
+		result := &ZgrabResult{Data: map[string]*ZgrabModule{"http": {
+			Status: "success",
+			Result: json.RawMessage(`{"response":{"status_code":200,"status_line":"200 OK","headers":` + headers + `}}`),
+		}}}
+		info := z.parseZgrabResult(result, "http", 80)
+		if got, _ := info.Fingerprint["cdn"].(string); got != want {
+			t.Errorf("headers %s: cdn = %q, want %q", headers, got, want)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package scanner
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strings"
+)
+
+// CoAP message codes (RFC 7252)
+const (
+	coapVersion     = 1
+	coapTypeCON     = 0
+	coapCodeGET     = 0x01
+	coapOptURIPath  = 11
+	coapPayloadMark = 0xFF
+)
+
+// buildCoAPGet encodes a confirmable GET for the given path segments
+func buildCoAPGet(messageID uint16, segments ...string) []byte {
+	msg := []byte{
+		coapVersion<<6 | coapTypeCON<<4, // no token
+		coapCodeGET,
+		byte(messageID >> 8), byte(messageID),
+	}
+
+	// Options are delta-encoded; all segments are Uri-Path so only the first
+	// carries a non-zero delta
+	delta := coapOptURIPath
+	for _, seg := range segments {
+		msg = append(msg, coapOptionHeader(delta, len(seg))...)
+		msg = append(msg, seg...)
+		delta = 0
+	}
+	return msg
+}
+
+// coapOptionHeader encodes an option delta/length pair with extended forms
+func coapOptionHeader(delta, length int) []byte {
+	nibble := func(v int) (byte, []byte) {
+		switch {
+		case v < 13:
+			return byte(v), nil
+		case v < 269:
+			return 13, []byte{byte(v - 13)}
+		default:
+			v -= 269
+			return 14, []byte{byte(v >> 8), byte(v)}
+		}
+	}
+	d, dExt := nibble(delta)
+	l, lExt := nibble(length)
+	out := []byte{d<<4 | l}
+	out = append(out, dExt...)
+	return append(out, lExt...)
+}
+
+// parseCoAPResponse validates a CoAP message and returns its response code
+// ("2.05") and payload
+func parseCoAPResponse(msg []byte) (string, []byte, error) {
+	if len(msg) < 4 {
+		return "", nil, fmt.Errorf("short CoAP message")
+	}
+	if msg[0]>>6 != coapVersion {
+		return "", nil, fmt.Errorf("not a CoAP message")
+	}
+	tkl := int(msg[0] & 0x0F)
+	if tkl > 8 {
+		return "", nil, fmt.Errorf("invalid CoAP token length")
+	}
+	code := fmt.Sprintf("%d.%02d", msg[1]>>5, msg[1]&0x1F)
+
+	pos := 4 + tkl
+	if pos > len(msg) {
+		return "", nil, fmt.Errorf("truncated CoAP token")
+	}
+
+	// Skip options until the payload marker
+	for pos < len(msg) {
+		if msg[pos] == coapPayloadMark {
+			return code, msg[pos+1:], nil
+		}
+		d, l := int(msg[pos]>>4), int(msg[pos]&0x0F)
+		pos++
+		for _, v := range []*int{&d, &l} {
+			switch *v {
+			case 13:
+				if pos >= len(msg) {
+					return "", nil, fmt.Errorf("truncated CoAP option")
+				}
+				*v = int(msg[pos]) + 13
+				pos++
+			case 14:
+				if pos+1 >= len(msg) {
+					return "", nil, fmt.Errorf("truncated CoAP option")
+				}
+				*v = int(msg[pos])<<8 | int(msg[pos+1]) + 269
+				pos += 2
+			case 15:
+				return "", nil, fmt.Errorf("invalid CoAP option")
+			}
+		}
+		pos += l
+		if pos > len(msg) {
+			return "", nil, fmt.Errorf("truncated CoAP option value")
+		}
+	}
+
+	return code, nil, nil
+}
+
+// parseCoRELinks extracts resource URIs from a CoRE Link Format document
+// (RFC 6690), e.g. `</sensors/temp>;rt="temperature",</light>`
+func parseCoRELinks(doc string) []string {
+	var resources []string
+	inQuote := false
+	start := 0
+	for i := 0; i <= len(doc); i++ {
+		if i < len(doc) {
+			if doc[i] == '"' {
+				inQuote = !inQuote
+			}
+			if doc[i] != ',' || inQuote {
+				continue
+			}
+		}
+		link := strings.TrimSpace(doc[start:i])
+		start = i + 1
+		if strings.HasPrefix(link, "<") {
+			if end := strings.Index(link, ">"); end > 1 {
+				resources = append(resources, link[1:end])
+			}
+		}
+	}
+	return resources
+}
+
+// probeCoAP requests /.well-known/core and lists the advertised resources
+func (f *Fingerprinter) probeCoAP(ip string, port int) (ServiceInfo, error) {
+	var info ServiceInfo
+
+	request := buildCoAPGet(uint16(rand.IntN(1<<16)), ".well-known", "core")
+	reply, err := f.udpExchange(ip, port, request)
+	if err != nil {
+		return info, err
+	}
+
+	code, payload, err := parseCoAPResponse(reply)
+	if err != nil {
+		return info, errUnrecognizedReply
+	}
+
+	info.ServiceName = "coap"
+	info.Banner = fmt.Sprintf("CoAP %s", code)
+	info.Fingerprint = map[string]interface{}{
+		"coap_code": code,
+	}
+	if code == "2.05" && len(payload) > 0 {
+		if resources := parseCoRELinks(string(payload)); len(resources) > 0 {
+			info.Fingerprint["coap_resources"] = resources
+		}
+	}
+
+	return info, nil
+}
@@ -0,0 +1,127 @@
+package scanner
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+)
+
+// udpStub answers each datagram on a loopback UDP port with reply(request)
+// and returns the port
+func udpStub(t *testing.T, reply func(request []byte) []byte) int {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if out := reply(append([]byte(nil), buf[:n]...)); out != nil {
+				conn.WriteTo(out, addr)
+			}
+		}
+	}()
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+// capturedCoAPCore is a 2.05 Content acknowledgement carrying a CoRE link
+// format document, as a constrained device returns for /.well-known/core;
+// its message ID is replaced with the request's
+var capturedCoAPCore = append([]byte{
+	0x60, 0x45, 0x00, 0x00, // ver 1 ACK, 2.05 Content, message ID
+	0xc1, 0x28, // Content-Format: application/link-format (40)
+	0xff,
+}, `</sensors/temp>;rt="temperature";title="Temp, indoor",</light>;if="actuator",</.well-known/core>`...)
+
+func TestProbeCoAP(t *testing.T) {
+	// The stub replies from its own goroutine; keep the first request
+	requests := make(chan []byte, 1)
+	port := udpStub(t, func(req []byte) []byte {
+		select {
+		case requests <- req:
+		default:
+		}
+		reply := append([]byte(nil), capturedCoAPCore...)
+		copy(reply[2:4], req[2:4])
+		return reply
+	})
+
+	info, err := testFingerprinter().probeCoAP("127.0.0.1", port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ServiceName != "coap" || info.Banner != "CoAP 2.05" || info.Fingerprint["coap_code"] != "2.05" {
+		t.Errorf("probeCoAP = %+v", info)
+	}
+	want := []string{"/sensors/temp", "/light", "/.well-known/core"}
+	if got := info.Fingerprint["coap_resources"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("coap_resources = %v, want %v", got, want)
+	}
+
+	// The request is a confirmable GET for /.well-known/core
+	request := <-requests
+	wantRequest := []byte{0x40, 0x01, request[2], request[3], 0xbb}
+	wantRequest = append(wantRequest, ".well-known"...)
+	wantRequest = append(wantRequest, 0x04)
+	wantRequest = append(wantRequest, "core"...)
+	if !bytes.Equal(request, wantRequest) {
+		t.Errorf("request = % x, want % x", request, wantRequest)
+	}
+}
+
+func TestProbeCoAPNotFound(t *testing.T) {
+	port := udpStub(t, func(req []byte) []byte {
+		return []byte{0x60, 0x84, req[2], req[3]} // 4.04 Not Found, no payload
+	})
+	info, err := testFingerprinter().probeCoAP("127.0.0.1", port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ServiceName != "coap" || info.Fingerprint["coap_code"] != "4.04" || info.Fingerprint["coap_resources"] != nil {
+		t.Errorf("probeCoAP = %+v", info)
+	}
+}
+
+func TestProbeCoAPUnrecognized(t *testing.T) {
+	port := udpStub(t, func([]byte) []byte { return []byte("hello") })
+	if _, err := testFingerprinter().probeCoAP("127.0.0.1", port); err != errUnrecognizedReply {
+		t.Errorf("probeCoAP of a non-CoAP reply = %v, want errUnrecognizedReply", err)
+	}
+}
+
+func TestParseCoAPResponseErrors(t *testing.T) {
+	for name, msg := range map[string][]byte{
+		"short":           {0x60, 0x45},
+		"version":         {0xa0, 0x45, 0, 0},
+		"token length":    {0x69, 0x45, 0, 0},
+		"truncated token": {0x64, 0x45, 0, 0, 1},
+		"option 15":       {0x60, 0x45, 0, 0, 0xf1, 0},
+		"truncated value": {0x60, 0x45, 0, 0, 0xb5, 'a'},
+	} {
+		if _, _, err := parseCoAPResponse(msg); err == nil {
+			t.Errorf("%s: parseCoAPResponse(% x) succeeded", name, msg)
+		}
+	}
+}
+
+func TestCoAPOptionHeader(t *testing.T) {
+	for _, tt := range []struct {
+		delta, length int
+		want          []byte
+	}{
+		{11, 4, []byte{0xb4}},
+		{11, 20, []byte{0xbd, 7}},
+		{300, 1, []byte{0xe1, 0, 31}},
+	} {
+		if got := coapOptionHeader(tt.delta, tt.length); !bytes.Equal(got, tt.want) {
+			t.Errorf("coapOptionHeader(%d, %d) = % x, want % x", tt.delta, tt.length, got, tt.want)
+		}
+	}
+}
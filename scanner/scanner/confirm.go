@@ -0,0 +1,93 @@
+package scanner
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Confidence levels recorded for ports that pass confirmation
+const (
+	ConfidenceMedium = "medium" // a second, independent connection succeeded
+	ConfidenceHigh   = "high"   // ...and the service sent data on it
+)
+
+// defaultConfirmConcurrency bounds confirmation connections in flight
+const defaultConfirmConcurrency = 50
+
+// Confirmer re-checks ports reported open with a fresh TCP connection, so
+// one-off accepts from SYN proxies or flaky middleboxes aren't recorded
+type Confirmer struct {
+	Timeout     time.Duration
+	Concurrency int           // connections in flight (default 50)
+	ReadBanner  bool          // also wait up to Timeout for the service to send data
+	SourceIP    net.IP        // local address to connect from (optional)
+	Sockets     *SocketBudget // caps connections open at once (optional)
+
+	SocketOptions *SocketOptions // applied to every connection (optional)
+}
+
+// Confirm returns the results whose confirmation connection succeeds, in
+// their original order, with Confidence set. A cancelled context drops
+// any result not yet confirmed.
+func (c *Confirmer) Confirm(ctx context.Context, results []ZmapResult) []ZmapResult {
+	concurrency := c.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultConfirmConcurrency
+	}
+
+	confirmed := make([]bool, len(results))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, concurrency)
+
+	for i := range results {
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		sem <- struct{}{} // acquire
+
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }() // release
+
+			if confidence := c.check(ctx, results[i].IP, results[i].Port); confidence != "" {
+				results[i].Confidence = confidence
+				confirmed[i] = true
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	var kept []ZmapResult
+	for i, ok := range confirmed {
+		if ok {
+			kept = append(kept, results[i])
+		}
+	}
+	return kept
+}
+
+// check reconnects to ip:port and returns the confidence level, or "" if
+// the connection fails
+func (c *Confirmer) check(ctx context.Context, ip string, port int) string {
+	conn, err := c.Sockets.dial(ctx, func() (net.Conn, error) {
+		return dialFrom(c.SourceIP, net.JoinHostPort(ip, strconv.Itoa(port)), c.Timeout, c.SocketOptions)
+	})
+	if err != nil {
+		return ""
+	}
+	defer conn.Close()
+
+	if !c.ReadBanner {
+		return ConfidenceMedium
+	}
+	conn.SetReadDeadline(time.Now().Add(c.Timeout))
+	buf := make([]byte, 1)
+	if n, _ := conn.Read(buf); n > 0 {
+		return ConfidenceHigh
+	}
+	return ConfidenceMedium
+}
@@ -0,0 +1,97 @@
+package scanner
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// acceptOnce listens on loopback, accepts a single connection and then
+// closes the listener, so every later connection is refused. It returns
+// the port and a channel closed once that connection has been accepted.
+func acceptOnce(t *testing.T) (int, <-chan struct{}) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	accepted := make(chan struct{})
+	go func() {
+		defer close(accepted)
+		conn, err := listener.Accept()
+		listener.Close()
+		if err == nil {
+			conn.Close()
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr).Port, accepted
+}
+
+func TestConfirmAcceptOnceNotConfirmed(t *testing.T) {
+	port, accepted := acceptOnce(t)
+
+	// The scan's own connection gets the one accept
+	conn, err := net.DialTimeout("tcp", stubAddress("127.0.0.1", port), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	<-accepted
+
+	c := &Confirmer{Timeout: time.Second}
+	if kept := c.Confirm(context.Background(), []ZmapResult{{IP: "127.0.0.1", Port: port}}); len(kept) != 0 {
+		t.Errorf("Confirm kept %+v, want the port that stopped accepting dropped", kept)
+	}
+}
+
+func TestConfirmConfidence(t *testing.T) {
+	silent := listenOn(t, "127.0.0.1", 0)
+	_, talking := stubServer(t, func(conn net.Conn) {
+		io.WriteString(conn, "SSH-2.0-OpenSSH_9.6\r\n")
+		io.Copy(io.Discard, conn)
+	})
+	refused, accepted := acceptOnce(t)
+	conn, err := net.DialTimeout("tcp", stubAddress("127.0.0.1", refused), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	<-accepted
+
+	results := []ZmapResult{
+		{IP: "127.0.0.1", Port: talking},
+		{IP: "127.0.0.1", Port: refused},
+		{IP: "127.0.0.1", Port: silent},
+	}
+	for _, tc := range []struct {
+		readBanner bool
+		want       []string // confidence of the talking and silent ports
+	}{
+		{false, []string{ConfidenceMedium, ConfidenceMedium}},
+		{true, []string{ConfidenceHigh, ConfidenceMedium}},
+	} {
+		c := &Confirmer{Timeout: 200 * time.Millisecond, ReadBanner: tc.readBanner}
+		kept := c.Confirm(context.Background(), append([]ZmapResult(nil), results...))
+		if len(kept) != 2 || kept[0].Port != talking || kept[1].Port != silent {
+			t.Fatalf("ReadBanner %v: kept %+v, want the talking and silent ports in order", tc.readBanner, kept)
+		}
+		for i, want := range tc.want {
+			if kept[i].Confidence != want {
+				t.Errorf("ReadBanner %v: port %d confidence = %q, want %q", tc.readBanner, kept[i].Port, kept[i].Confidence, want)
+			}
+		}
+	}
+}
+
+func TestConfirmCancelled(t *testing.T) {
+	port := listenOn(t, "127.0.0.1", 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c := &Confirmer{Timeout: time.Second}
+	if kept := c.Confirm(ctx, []ZmapResult{{IP: "127.0.0.1", Port: port}}); len(kept) != 0 {
+		t.Errorf("Confirm with a cancelled context kept %+v", kept)
+	}
+}
@@ -0,0 +1,239 @@
+package scanner
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxAPIBody caps how much of a JSON response is read. Docker's /version
+// lists components and easily exceeds MaxBanner.
+const maxAPIBody = 64 << 10
+
+// maxKubeletBody caps how much of a kubelet /pods or /metrics response is
+// read. Both run to megabytes on a busy node.
+const maxKubeletBody = 16 << 20
+
+// kubeBuildInfo matches the git_version label of the kubernetes_build_info
+// metric
+var kubeBuildInfo = regexp.MustCompile(`^kubernetes_build_info\{.*\bgit_version="([^"]+)"`)
+
+// dockerVersion is the subset of Docker's GET /version response we record
+type dockerVersion struct {
+	Version       string `json:"Version"`
+	APIVersion    string `json:"ApiVersion"`
+	Os            string `json:"Os"`
+	Arch          string `json:"Arch"`
+	KernelVersion string `json:"KernelVersion"`
+}
+
+// kubeVersion is the Kubernetes GET /version response
+type kubeVersion struct {
+	GitVersion string `json:"gitVersion"`
+	Platform   string `json:"platform"`
+	GoVersion  string `json:"goVersion"`
+}
+
+// probeClient returns an HTTP client for probing ip: one connection per
+// request, TLS certificates not verified, redirects not followed
+func (f *Fingerprinter) probeClient(ip string) *http.Client {
+	return &http.Client{
+		Timeout: f.Timeout,
+		Transport: &http.Transport{
+			DialContext:       f.dialContext,
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			DisableKeepAlives: true,
+		},
+		// A redirect is an answer in itself; don't follow it elsewhere
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// apiGet issues a GET against ip:port and returns the status and up to
+// maxAPIBody bytes of the body. TLS certificates are not verified.
+func (f *Fingerprinter) apiGet(ip string, port int, useTLS bool, path string) (int, []byte, error) {
+	resp, err := f.apiRequest(ip, port, useTLS, path)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxAPIBody))
+	return resp.StatusCode, body, err
+}
+
+// apiRequest issues a GET against ip:port and returns the response for the
+// caller to read and close
+func (f *Fingerprinter) apiRequest(ip string, port int, useTLS bool, path string) (*http.Response, error) {
+	scheme := "http"
+	if useTLS {
+		scheme = "https"
+	}
+
+	url := fmt.Sprintf("%s://%s%s", scheme, net.JoinHostPort(ip, strconv.Itoa(port)), path)
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "NetworkScanner/1.0")
+	return f.probeClient(ip).Do(req)
+}
+
+// probeDocker queries the Docker Engine API's /version endpoint. Docker has
+// no authentication of its own, so any answer means full daemon access.
+func (f *Fingerprinter) probeDocker(ip string, port int, useTLS bool) ServiceInfo {
+	var info ServiceInfo
+	info.ServiceName = "docker"
+
+	status, body, err := f.apiGet(ip, port, useTLS, "/version")
+	if err != nil {
+		return info
+	}
+
+	info.Fingerprint = map[string]interface{}{"status_code": status}
+
+	var v dockerVersion
+	if status != http.StatusOK || json.Unmarshal(body, &v) != nil || v.Version == "" {
+		// TLS daemons with client-cert auth reject the handshake or the request
+		info.Fingerprint["unauthenticated"] = false
+		return info
+	}
+
+	info.ServiceVersion = v.Version
+	info.Banner = sanitizeBanner(fmt.Sprintf("Docker %s (API %s) %s/%s", v.Version, v.APIVersion, v.Os, v.Arch))
+	info.Fingerprint["unauthenticated"] = true
+	info.Fingerprint["api_version"] = v.APIVersion
+	info.Fingerprint["os"] = v.Os
+	info.Fingerprint["arch"] = v.Arch
+	if v.KernelVersion != "" {
+		info.Fingerprint["kernel_version"] = v.KernelVersion
+	}
+
+	return info
+}
+
+// probeKubernetes identifies a Kubernetes API server or kubelet and checks
+// whether it serves resources anonymously. The API server is checked with
+// /api; the kubelet, which has no /api, with /pods.
+func (f *Fingerprinter) probeKubernetes(ip string, port int) ServiceInfo {
+	var info ServiceInfo
+	resourcePath := "/api"
+	info.ServiceName = "kubernetes"
+	if port == 10250 {
+		resourcePath = "/pods"
+		info.ServiceName = "kubelet"
+	}
+
+	status, body, err := f.apiGet(ip, port, true, "/version")
+	if err != nil {
+		return info
+	}
+
+	info.Fingerprint = map[string]interface{}{"status_code": status}
+
+	// /version is often readable anonymously even when everything else isn't
+	var v kubeVersion
+	if status == http.StatusOK && json.Unmarshal(body, &v) == nil && v.GitVersion != "" {
+		info.ServiceVersion = v.GitVersion
+		info.Banner = sanitizeBanner(fmt.Sprintf("Kubernetes %s %s", v.GitVersion, v.Platform))
+		if v.Platform != "" {
+			info.Fingerprint["platform"] = v.Platform
+		}
+	}
+
+	status, _, err = f.apiGet(ip, port, true, resourcePath)
+	if err != nil {
+		return info
+	}
+	info.Fingerprint["unauthenticated"] = status == http.StatusOK
+	info.Fingerprint["auth_check_path"] = resourcePath
+	info.Fingerprint["auth_check_status"] = status
+
+	return info
+}
+
+// kubeletPodList is the part of the kubelet's GET /pods response we read;
+// items decode to nothing so only their number is kept
+type kubeletPodList struct {
+	Kind  string     `json:"kind"`
+	Items []struct{} `json:"items"`
+}
+
+// probeKubeletReadOnly checks the kubelet's unauthenticated read-only port
+// (10255), which serves /pods and /metrics to anyone. A PodList from /pods
+// or kubelet metrics from /metrics identify it; anything else is probed as
+// plain HTTP.
+func (f *Fingerprinter) probeKubeletReadOnly(ip string, port int) ServiceInfo {
+	var info ServiceInfo
+	info.Fingerprint = map[string]interface{}{}
+
+	if resp, err := f.apiRequest(ip, port, false, "/pods"); err == nil {
+		var pods kubeletPodList
+		err := json.NewDecoder(io.LimitReader(resp.Body, maxKubeletBody)).Decode(&pods)
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK && err == nil && pods.Kind == "PodList" {
+			info.Fingerprint["pod_count"] = len(pods.Items)
+		}
+	}
+
+	if version, ok := f.kubeletMetrics(ip, port); ok {
+		info.Fingerprint["metrics_exposed"] = true
+		if version != "" {
+			info.ServiceVersion = version
+		}
+	}
+
+	if len(info.Fingerprint) == 0 {
+		return f.probeHTTP(ip, port, false)
+	}
+
+	info.ServiceName = "kubelet"
+	info.Fingerprint["readonly_exposed"] = true
+	banner := "Kubernetes kubelet read-only port"
+	if info.ServiceVersion != "" {
+		banner += " " + info.ServiceVersion
+	}
+	if count, ok := info.Fingerprint["pod_count"].(int); ok {
+		banner += fmt.Sprintf(", %d pods visible", count)
+	}
+	info.Banner = sanitizeBanner(banner)
+	return info
+}
+
+// kubeletMetrics reports whether /metrics serves kubelet metrics and the
+// Kubernetes version from kubernetes_build_info, when present
+func (f *Fingerprinter) kubeletMetrics(ip string, port int) (version string, ok bool) {
+	resp, err := f.apiRequest(ip, port, false, "/metrics")
+	if err != nil {
+		return "", false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", false
+	}
+
+	lines := bufio.NewScanner(io.LimitReader(resp.Body, maxKubeletBody))
+	lines.Buffer(make([]byte, 0, 64<<10), 1<<20)
+	for lines.Scan() {
+		line := lines.Text()
+		if strings.HasPrefix(line, "kubelet_") {
+			ok = true
+		}
+		if m := kubeBuildInfo.FindStringSubmatch(line); m != nil {
+			version = m[1]
+		}
+		if ok && version != "" {
+			break
+		}
+	}
+	return version, ok
+}
@@ -0,0 +1,176 @@
+package scanner
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+// Canned /version responses, trimmed from real servers
+const (
+	cannedDockerVersion = `{"Platform":{"Name":"Docker Engine - Community"},"Components":[{"Name":"Engine","Version":"24.0.7"}],` +
+		`"Version":"24.0.7","ApiVersion":"1.43","MinAPIVersion":"1.12","GitCommit":"311b9ff","GoVersion":"go1.20.10",` +
+		`"Os":"linux","Arch":"amd64","KernelVersion":"6.1.0-13-amd64","BuildTime":"2023-10-26T09:08:02.000000000+00:00"}`
+	cannedKubeVersion = `{"major":"1","minor":"28","gitVersion":"v1.28.3","gitCommit":"a8a1abc25cad87333840cd7d54be2efaf31a3177",` +
+		`"gitTreeState":"clean","buildDate":"2023-10-18T11:33:18Z","goVersion":"go1.20.10","compiler":"gc","platform":"linux/amd64"}`
+	// The kubelet's read-only /pods: a PodList of two pods, each trimmed
+	// to its metadata and one container
+	cannedKubeletPods = `{"kind":"PodList","apiVersion":"v1","metadata":{},"items":[` +
+		`{"metadata":{"name":"coredns-5d78c9869d-8kq2x","namespace":"kube-system","uid":"0b6a1b7e-6f0e-4f45-9d8a-1e2f3a4b5c6d"},` +
+		`"spec":{"containers":[{"name":"coredns","image":"registry.k8s.io/coredns/coredns:v1.10.1"}],"nodeName":"node-1"},"status":{"phase":"Running"}},` +
+		`{"metadata":{"name":"web-7f9c8d6b5-x2v4n","namespace":"default","uid":"3c1d2e4f-5a6b-4c7d-8e9f-0a1b2c3d4e5f"},` +
+		`"spec":{"containers":[{"name":"web","image":"nginx:1.25","env":[{"name":"DB_PASSWORD","value":"hunter2"}]}],"nodeName":"node-1"},"status":{"phase":"Running"}}]}`
+	cannedKubeletMetrics = "# HELP kubelet_running_pods Number of pods that have a running pod sandbox\n" +
+		"# TYPE kubelet_running_pods gauge\nkubelet_running_pods 2\n" +
+		`kubernetes_build_info{build_date="2023-10-18T11:33:18Z",compiler="gc",git_commit="a8a1abc",git_tree_state="clean",git_version="v1.28.3",go_version="go1.20.10",major="1",minor="28",platform="linux/amd64"} 1` + "\n"
+	cannedKubeAPI = `{"kind":"APIVersions","versions":["v1"],"serverAddressByClientCIDRs":[{"clientCIDR":"0.0.0.0/0","serverAddress":"10.0.0.1:6443"}]}`
+)
+
+// apiStub serves routes, 403 for anything else, and returns the server's
+// IP and port
+func apiStub(t *testing.T, useTLS bool, routes map[string]string) (string, int) {
+	t.Helper()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := routes[r.URL.Path]
+		if !ok {
+			http.Error(w, `{"kind":"Status","status":"Failure","reason":"Forbidden","code":403}`, http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	})
+	var server *httptest.Server
+	if useTLS {
+		server = httptest.NewTLSServer(handler)
+	} else {
+		server = httptest.NewServer(handler)
+	}
+	t.Cleanup(server.Close)
+	host, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	n, _ := strconv.Atoi(port)
+	return host, n
+}
+
+func TestProbeDocker(t *testing.T) {
+	for _, useTLS := range []bool{false, true} {
+		ip, port := apiStub(t, useTLS, map[string]string{"/version": cannedDockerVersion})
+		info := testFingerprinter().probeDocker(ip, port, useTLS)
+
+		if info.ServiceName != "docker" || info.ServiceVersion != "24.0.7" {
+			t.Errorf("TLS %v: service = %q %q, want docker 24.0.7", useTLS, info.ServiceName, info.ServiceVersion)
+		}
+		if info.Banner != "Docker 24.0.7 (API 1.43) linux/amd64" {
+			t.Errorf("TLS %v: banner = %q", useTLS, info.Banner)
+		}
+		fp := info.Fingerprint
+		if fp["unauthenticated"] != true || fp["api_version"] != "1.43" || fp["kernel_version"] != "6.1.0-13-amd64" || fp["status_code"] != 200 {
+			t.Errorf("TLS %v: fingerprint = %v", useTLS, fp)
+		}
+	}
+}
+
+func TestProbeDockerRejected(t *testing.T) {
+	ip, port := apiStub(t, false, nil)
+	info := testFingerprinter().probeDocker(ip, port, false)
+	if info.ServiceName != "docker" || info.ServiceVersion != "" {
+		t.Errorf("service = %q %q, want docker without a version", info.ServiceName, info.ServiceVersion)
+	}
+	if info.Fingerprint["unauthenticated"] != false || info.Fingerprint["status_code"] != 403 {
+		t.Errorf("fingerprint = %v, want authenticated with status 403", info.Fingerprint)
+	}
+}
+
+func TestProbeKubernetes(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		routes map[string]string
+		open   bool
+		status int
+	}{
+		{"anonymous", map[string]string{"/version": cannedKubeVersion, "/api": cannedKubeAPI}, true, 200},
+		{"version only", map[string]string{"/version": cannedKubeVersion}, false, 403},
+	} {
+		ip, port := apiStub(t, true, tc.routes)
+		info := testFingerprinter().probeKubernetes(ip, port)
+
+		if info.ServiceName != "kubernetes" || info.ServiceVersion != "v1.28.3" {
+			t.Errorf("%s: service = %q %q, want kubernetes v1.28.3", tc.name, info.ServiceName, info.ServiceVersion)
+		}
+		if info.Banner != "Kubernetes v1.28.3 linux/amd64" {
+			t.Errorf("%s: banner = %q", tc.name, info.Banner)
+		}
+		fp := info.Fingerprint
+		if fp["unauthenticated"] != tc.open || fp["auth_check_path"] != "/api" || fp["auth_check_status"] != tc.status {
+			t.Errorf("%s: fingerprint = %v, want unauthenticated %v via /api", tc.name, fp, tc.open)
+		}
+	}
+}
+
+func TestProbeKubernetesNoVersion(t *testing.T) {
+	ip, port := apiStub(t, true, nil)
+	info := testFingerprinter().probeKubernetes(ip, port)
+	if info.ServiceVersion != "" || info.Banner != "" {
+		t.Errorf("version %q banner %q from a 403 /version", info.ServiceVersion, info.Banner)
+	}
+	if info.Fingerprint["unauthenticated"] != false || info.Fingerprint["status_code"] != 403 {
+		t.Errorf("fingerprint = %v", info.Fingerprint)
+	}
+}
+
+func TestContainerPortsRouted(t *testing.T) {
+	common := CommonPorts()
+	for port, service := range map[int]string{2375: "docker", 2376: "docker", 6443: "kubernetes", 10250: "kubernetes", 10255: "kubelet"} {
+		if got := portService(port); got != service {
+			t.Errorf("port %d routes to %q, want %q", port, got, service)
+		}
+		if !slices.Contains(common, port) {
+			t.Errorf("port %d missing from CommonPorts", port)
+		}
+	}
+}
+
+func TestProbeKubeletReadOnly(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		routes  map[string]string
+		pods    interface{}
+		metrics interface{}
+		version string
+		banner  string
+	}{
+		{"pods and metrics", map[string]string{"/pods": cannedKubeletPods, "/metrics": cannedKubeletMetrics},
+			2, true, "v1.28.3", "Kubernetes kubelet read-only port v1.28.3, 2 pods visible"},
+		{"pods only", map[string]string{"/pods": cannedKubeletPods},
+			2, nil, "", "Kubernetes kubelet read-only port, 2 pods visible"},
+		{"no pods scheduled", map[string]string{"/pods": `{"kind":"PodList","apiVersion":"v1","metadata":{},"items":null}`},
+			0, nil, "", "Kubernetes kubelet read-only port, 0 pods visible"},
+		{"metrics only", map[string]string{"/metrics": cannedKubeletMetrics},
+			nil, true, "v1.28.3", "Kubernetes kubelet read-only port v1.28.3"},
+	} {
+		ip, port := apiStub(t, false, tc.routes)
+		info := testFingerprinter().probeKubeletReadOnly(ip, port)
+		if info.ServiceName != "kubelet" || info.ServiceVersion != tc.version || info.Banner != tc.banner {
+			t.Errorf("%s: service %q %q, banner %q", tc.name, info.ServiceName, info.ServiceVersion, info.Banner)
+		}
+		fp := info.Fingerprint
+		if fp["readonly_exposed"] != true || fp["pod_count"] != tc.pods || fp["metrics_exposed"] != tc.metrics {
+			t.Errorf("%s: fingerprint = %v", tc.name, fp)
+		}
+	}
+}
+
+func TestProbeKubeletReadOnlyOtherHTTP(t *testing.T) {
+	// Neither a PodList nor kubelet metrics: probed as the web server it is
+	ip, port := apiStub(t, false, map[string]string{
+		"/":        "<html><title>Grafana</title></html>",
+		"/pods":    `{"kind":"Status","status":"Failure"}`,
+		"/metrics": "go_goroutines 12\n",
+	})
+	info := testFingerprinter().probeKubeletReadOnly(ip, port)
+	if info.ServiceName == "kubelet" || info.Fingerprint["readonly_exposed"] != nil {
+		t.Errorf("info = %+v, want plain HTTP", info)
+	}
+}
@@ -0,0 +1,191 @@
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// DNS record types the DoH client asks for
+const (
+	dnsTypeA    = 1
+	dnsTypePTR  = 12
+	dnsTypeAAAA = 28
+)
+
+// dohMessageType is the RFC 8484 media type for wire-format DNS messages
+const dohMessageType = "application/dns-message"
+
+// dnsRecord is one answer from a DoH response. Data is the address for A
+// and AAAA records and the target name for PTR records.
+type dnsRecord struct {
+	Type uint16
+	Data string
+}
+
+// dohQuery POSTs a single-question query for name to url and returns the
+// answers of type qtype
+func dohQuery(ctx context.Context, client *http.Client, url, name string, qtype uint16) ([]string, error) {
+	query, err := buildDNSQuery(name, qtype)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", dohMessageType)
+	req.Header.Set("Accept", dohMessageType)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("DoH server returned %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := parseDNSResponse(body)
+	if err != nil {
+		return nil, err
+	}
+	var answers []string
+	for _, r := range records {
+		if r.Type == qtype {
+			answers = append(answers, r.Data)
+		}
+	}
+	return answers, nil
+}
+
+// buildDNSQuery encodes a recursive query for name. The ID is zero as RFC
+// 8484 recommends, so responses stay cacheable.
+func buildDNSQuery(name string, qtype uint16) ([]byte, error) {
+	var msg bytes.Buffer
+	// ID 0, RD set, one question
+	msg.Write([]byte{0, 0, 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0})
+
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if label == "" || len(label) > 63 {
+			return nil, fmt.Errorf("invalid DNS name %q", name)
+		}
+		msg.WriteByte(byte(len(label)))
+		msg.WriteString(label)
+	}
+	msg.WriteByte(0)
+
+	binary.Write(&msg, binary.BigEndian, qtype)
+	binary.Write(&msg, binary.BigEndian, uint16(1)) // class IN
+	return msg.Bytes(), nil
+}
+
+var errShortDNSMessage = errors.New("truncated DNS message")
+
+// parseDNSResponse decodes the answer section of a response. A, AAAA and
+// PTR records are decoded; other types are returned with empty Data.
+func parseDNSResponse(msg []byte) ([]dnsRecord, error) {
+	if len(msg) < 12 {
+		return nil, errShortDNSMessage
+	}
+	if rcode := msg[3] & 0x0f; rcode != 0 {
+		// NXDOMAIN and friends are no answers rather than a failure
+		if rcode == 3 {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("DNS query failed with rcode %d", rcode)
+	}
+	qdcount := int(binary.BigEndian.Uint16(msg[4:6]))
+	ancount := int(binary.BigEndian.Uint16(msg[6:8]))
+
+	offset := 12
+	for i := 0; i < qdcount; i++ {
+		_, next, err := readDNSName(msg, offset)
+		if err != nil {
+			return nil, err
+		}
+		offset = next + 4 // type, class
+	}
+
+	var records []dnsRecord
+	for i := 0; i < ancount; i++ {
+		_, next, err := readDNSName(msg, offset)
+		if err != nil {
+			return nil, err
+		}
+		if next+10 > len(msg) {
+			return nil, errShortDNSMessage
+		}
+		rtype := binary.BigEndian.Uint16(msg[next : next+2])
+		rdlength := int(binary.BigEndian.Uint16(msg[next+8 : next+10]))
+		rdata := next + 10
+		if rdata+rdlength > len(msg) {
+			return nil, errShortDNSMessage
+		}
+
+		record := dnsRecord{Type: rtype}
+		switch {
+		case rtype == dnsTypeA && rdlength == net.IPv4len,
+			rtype == dnsTypeAAAA && rdlength == net.IPv6len:
+			record.Data = net.IP(msg[rdata : rdata+rdlength]).String()
+		case rtype == dnsTypePTR:
+			target, _, err := readDNSName(msg, rdata)
+			if err != nil {
+				return nil, err
+			}
+			record.Data = target
+		}
+		records = append(records, record)
+		offset = rdata + rdlength
+	}
+	return records, nil
+}
+
+// readDNSName decodes the possibly compressed name at offset, returning it
+// with a trailing dot and the offset just past it
+func readDNSName(msg []byte, offset int) (string, int, error) {
+	var labels []string
+	next := -1
+	for jumps := 0; ; {
+		if offset >= len(msg) {
+			return "", 0, errShortDNSMessage
+		}
+		length := int(msg[offset])
+		switch {
+		case length == 0:
+			if next < 0 {
+				next = offset + 1
+			}
+			return strings.Join(labels, ".") + ".", next, nil
+		case length&0xc0 == 0xc0:
+			if offset+1 >= len(msg) {
+				return "", 0, errShortDNSMessage
+			}
+			if jumps++; jumps > 16 {
+				return "", 0, errors.New("DNS name compression loop")
+			}
+			if next < 0 {
+				next = offset + 2
+			}
+			offset = int(binary.BigEndian.Uint16(msg[offset:offset+2]) & 0x3fff)
+		default:
+			if offset+1+length > len(msg) {
+				return "", 0, errShortDNSMessage
+			}
+			labels = append(labels, string(msg[offset+1:offset+1+length]))
+			offset += 1 + length
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package scanner
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// dohStub is a DoH server answering from fixed records, keyed by query
+// name and type. Unknown names get NXDOMAIN.
+type dohStub struct {
+	records map[string]map[uint16][]string
+	queries atomic.Int64
+}
+
+func (s *dohStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.queries.Add(1)
+	if r.Method != http.MethodPost || r.Header.Get("Content-Type") != dohMessageType {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	query, _ := io.ReadAll(r.Body)
+	name, end, err := readDNSName(query, 12)
+	if err != nil || end+4 > len(query) {
+		http.Error(w, "bad query", http.StatusBadRequest)
+		return
+	}
+	qtype := binary.BigEndian.Uint16(query[end : end+2])
+	answers, known := s.records[name]
+
+	// Header with QR and RA set, then the question echoed back
+	resp := append([]byte(nil), query[:end+4]...)
+	resp[2] |= 0x80
+	resp[3] = 0x80
+	if !known {
+		resp[3] |= 3 // NXDOMAIN
+	}
+	binary.BigEndian.PutUint16(resp[6:8], uint16(len(answers[qtype])))
+	for _, data := range answers[qtype] {
+		rdata := []byte(net.ParseIP(data).To4())
+		switch qtype {
+		case dnsTypeAAAA:
+			rdata = net.ParseIP(data).To16()
+		case dnsTypePTR:
+			rdata, _ = buildDNSQuery(data, 0)
+			rdata = rdata[12 : len(rdata)-4]
+		}
+		resp = append(resp, 0xc0, 12) // name: pointer to the question
+		resp = binary.BigEndian.AppendUint16(resp, qtype)
+		resp = binary.BigEndian.AppendUint16(resp, 1)
+		resp = binary.BigEndian.AppendUint32(resp, 300)
+		resp = binary.BigEndian.AppendUint16(resp, uint16(len(rdata)))
+		resp = append(resp, rdata...)
+	}
+	w.Header().Set("Content-Type", dohMessageType)
+	w.Write(resp)
+}
+
+func newDoHStub(t *testing.T) (*dohStub, string) {
+	t.Helper()
+	stub := &dohStub{records: map[string]map[uint16][]string{
+		"app.example.": {
+			dnsTypeA:    {"192.0.2.10", "192.0.2.11"},
+			dnsTypeAAAA: {"2001:db8::10"},
+		},
+		"v4only.example.": {dnsTypeA: {"198.51.100.7"}},
+		"10.2.0.192.in-addr.arpa.": {
+			dnsTypePTR: {"app.example."},
+		},
+	}}
+	server := httptest.NewServer(stub)
+	t.Cleanup(server.Close)
+	return stub, server.URL + "/dns-query"
+}
+
+func TestResolverDoHLookupHost(t *testing.T) {
+	_, url := newDoHStub(t)
+	r := NewResolver(url, time.Second)
+
+	for host, want := range map[string][]string{
+		"app.example":    {"192.0.2.10", "192.0.2.11", "2001:db8::10"},
+		"v4only.example": {"198.51.100.7"},
+	} {
+		addrs, err := r.LookupHost(context.Background(), host)
+		if err != nil {
+			t.Fatalf("LookupHost(%s): %v", host, err)
+		}
+		if !reflect.DeepEqual(addrs, want) {
+			t.Errorf("LookupHost(%s) = %v, want %v", host, addrs, want)
+		}
+	}
+}
+
+func TestResolverDoHLookupAddr(t *testing.T) {
+	stub, url := newDoHStub(t)
+	r := NewResolver(url, time.Second)
+
+	names, err := r.LookupAddr(context.Background(), "192.0.2.10")
+	if err != nil || !reflect.DeepEqual(names, []string{"app.example"}) {
+		t.Fatalf("LookupAddr = %v, %v, want [app.example]", names, err)
+	}
+
+	// Hostname caches per IP
+	before := stub.queries.Load()
+	for range 3 {
+		if name := r.Hostname(context.Background(), "192.0.2.10"); name != "app.example" {
+			t.Fatalf("Hostname = %q, want app.example", name)
+		}
+	}
+	if n := stub.queries.Load() - before; n != 1 {
+		t.Errorf("%d DoH queries for three Hostname calls, want 1", n)
+	}
+}
+
+func TestResolverDoHFallsBackToSystem(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(failing.Close)
+	_, stubURL := newDoHStub(t)
+
+	// A failing server and an NXDOMAIN both leave it to the system
+	// resolver, which knows localhost
+	for name, url := range map[string]string{"server error": failing.URL, "nxdomain": stubURL} {
+		addrs, err := NewResolver(url, time.Second).LookupHost(context.Background(), "localhost")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		found := false
+		for _, addr := range addrs {
+			found = found || net.ParseIP(addr).IsLoopback()
+		}
+		if !found {
+			t.Errorf("%s: LookupHost(localhost) = %v, want a loopback address", name, addrs)
+		}
+	}
+}
+
+func TestResolverDoHTimeout(t *testing.T) {
+	release := make(chan struct{})
+	hanging := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(hanging.Close)
+	t.Cleanup(func() { close(release) })
+
+	r := NewResolver(hanging.URL, 100*time.Millisecond)
+	start := time.Now()
+	r.LookupHost(context.Background(), "app.example")
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("lookup against a hanging DoH server took %v, want about the 100ms timeout", elapsed)
+	}
+}
+
+func TestReverseName(t *testing.T) {
+	for ip, want := range map[string]string{
+		"192.0.2.10":  "10.2.0.192.in-addr.arpa.",
+		"2001:db8::1": "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa.",
+	} {
+		if got, err := reverseName(ip); err != nil || got != want {
+			t.Errorf("reverseName(%s) = %q, %v, want %q", ip, got, err, want)
+		}
+	}
+	if _, err := reverseName("not-an-ip"); err == nil {
+		t.Error("reverseName accepted an invalid IP")
+	}
+}
@@ -0,0 +1,85 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Errors the port scanners wrap so callers can tell failures apart with
+// errors.Is. A cancelled or timed-out scan returns the context's error.
+var (
+	// ErrBinaryNotFound means the zmap binary isn't installed or on PATH
+	ErrBinaryNotFound = errors.New("scanner binary not found")
+
+	// ErrInsufficientPrivileges means zmap lacks or was denied the raw
+	// socket access it needs (root, or CAP_NET_RAW and CAP_NET_ADMIN),
+	// either found up front by ZmapPrivileges or reported by zmap itself
+	ErrInsufficientPrivileges = errors.New("insufficient privileges")
+
+	// ErrNoTargets means no configured network has an in-scope IP
+	ErrNoTargets = errors.New("no valid IPs to scan")
+)
+
+// ZmapError is a zmap run that failed to start or exited with an error
+// before reporting any hosts. Err wraps ErrBinaryNotFound or
+// ErrInsufficientPrivileges when the failure is one of those.
+type ZmapError struct {
+	Stderr string // what zmap printed, if it ran
+	Err    error
+}
+
+func (e *ZmapError) Error() string {
+	if stderr := strings.TrimSpace(e.Stderr); stderr != "" {
+		return "zmap failed: " + stderr
+	}
+	return "zmap failed: " + e.Err.Error()
+}
+
+func (e *ZmapError) Unwrap() error {
+	return e.Err
+}
+
+// zmapPrivilegeMessages are stderr fragments of zmap failing to open its
+// raw socket or capture device
+var zmapPrivilegeMessages = []string{
+	"operation not permitted",
+	"permission denied",
+	"must be run as root",
+	"root privileges",
+}
+
+// newZmapError classifies a zmap failure from the exec error and stderr
+func newZmapError(err error, stderr string) *ZmapError {
+	if err == nil {
+		err = errors.New(strings.TrimSpace(stderr))
+	}
+	switch {
+	case errors.Is(err, exec.ErrNotFound), errors.Is(err, os.ErrNotExist):
+		err = fmt.Errorf("%w: %w", ErrBinaryNotFound, err)
+	case errors.Is(err, os.ErrPermission):
+		err = fmt.Errorf("%w: %w", ErrInsufficientPrivileges, err)
+	default:
+		lower := strings.ToLower(stderr)
+		for _, msg := range zmapPrivilegeMessages {
+			if strings.Contains(lower, msg) {
+				err = fmt.Errorf("%w: %w", ErrInsufficientPrivileges, err)
+				break
+			}
+		}
+	}
+	return &ZmapError{Stderr: stderr, Err: err}
+}
+
+// fatalScanError reports whether err will fail every remaining network and
+// port as well, so a scan should stop instead of moving on
+func fatalScanError(err error) bool {
+	return errors.Is(err, ErrBinaryNotFound) ||
+		errors.Is(err, ErrInsufficientPrivileges) ||
+		errors.Is(err, ErrNoTargets) ||
+		errors.Is(err, context.Canceled) ||
+		errors.Is(err, context.DeadlineExceeded)
+}
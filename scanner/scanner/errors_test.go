@@ -0,0 +1,152 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// failingZmap puts a zmap script on a fresh PATH that logs each call to
+// the returned file, prints stderr and exits 1 without finding anything
+func failingZmap(t *testing.T, stderr string) string {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("PATH", dir+":/usr/bin:/bin")
+	calls := filepath.Join(dir, "calls")
+	script := fmt.Sprintf("#!/bin/sh\necho \"$@\" >> %s\necho %q >&2\nexit 1\n", calls, stderr)
+	if err := os.WriteFile(filepath.Join(dir, "zmap"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return calls
+}
+
+func TestNewZmapError(t *testing.T) {
+	exit := exec.Command("false").Run()
+	tests := []struct {
+		name   string
+		err    error
+		stderr string
+		want   error
+	}{
+		{"not on PATH", &exec.Error{Name: "zmap", Err: exec.ErrNotFound}, "", ErrBinaryNotFound},
+		{"missing path", &fs.PathError{Op: "fork/exec", Path: "/usr/sbin/zmap", Err: syscall.ENOENT}, "", ErrBinaryNotFound},
+		{"not executable", &fs.PathError{Op: "fork/exec", Path: "/usr/sbin/zmap", Err: syscall.EACCES}, "", ErrInsufficientPrivileges},
+		{"raw socket refused", exit, "[FATAL] send: couldn't create socket. Are you root? Operation not permitted", ErrInsufficientPrivileges},
+		{"pcap denied", exit, "[FATAL] recv: could not open device eth0: eth0: You don't have permission to capture on that device (socket: Permission denied)", ErrInsufficientPrivileges},
+		{"bad arguments", exit, "[FATAL] zmap: target port required", nil},
+	}
+	for _, tt := range tests {
+		err := newZmapError(tt.err, tt.stderr)
+		for _, sentinel := range []error{ErrBinaryNotFound, ErrInsufficientPrivileges} {
+			if got := errors.Is(err, sentinel); got != (sentinel == tt.want) {
+				t.Errorf("%s: errors.Is(%v, %v) = %v", tt.name, err, sentinel, got)
+			}
+		}
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: %v no longer wraps %v", tt.name, err, tt.err)
+		}
+		if tt.stderr != "" && err.Error() != "zmap failed: "+tt.stderr {
+			t.Errorf("%s: message %q, want zmap's stderr", tt.name, err.Error())
+		}
+	}
+}
+
+func TestZmapBinaryNotFound(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+	z := testZmapScanner("127.0.0.1/32")
+	_, err := z.ScanPorts(context.Background(), []int{22, 80, 443})
+	if !errors.Is(err, ErrBinaryNotFound) {
+		t.Fatalf("ScanPorts = %v, want ErrBinaryNotFound", err)
+	}
+	var zerr *ZmapError
+	if !errors.As(err, &zerr) || !errors.Is(zerr.Err, exec.ErrNotFound) {
+		t.Errorf("ScanPorts = %#v, want a *ZmapError from exec", err)
+	}
+}
+
+func TestZmapInsufficientPrivileges(t *testing.T) {
+	stderr := "[FATAL] send: couldn't create socket. Are you root? Operation not permitted"
+	calls := failingZmap(t, stderr)
+	z := testZmapScanner("10.0.0.0/24", "10.0.1.0/24")
+	_, err := z.ScanPorts(context.Background(), []int{22, 80, 443})
+	if !errors.Is(err, ErrInsufficientPrivileges) {
+		t.Fatalf("ScanPorts = %v, want ErrInsufficientPrivileges", err)
+	}
+	var zerr *ZmapError
+	if !errors.As(err, &zerr) || strings.TrimSpace(zerr.Stderr) != stderr {
+		t.Errorf("ScanPorts = %#v, want a *ZmapError with zmap's stderr", err)
+	}
+
+	// The scan stops rather than failing every other network and port
+	data, err := os.ReadFile(calls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(data), "\n"); n != 1 {
+		t.Errorf("zmap ran %d times, want 1", n)
+	}
+}
+
+func TestZmapOtherFailureContinues(t *testing.T) {
+	calls := failingZmap(t, "[FATAL] csv: unknown field")
+	z := testZmapScanner("10.0.0.0/24")
+	found, err := z.ScanPorts(context.Background(), []int{22, 80})
+	if err != nil || len(found) != 0 {
+		t.Fatalf("ScanPorts = %v, %v, want the failure logged and an empty result", found, err)
+	}
+	data, _ := os.ReadFile(calls)
+	if n := strings.Count(string(data), "\n"); n != 2 {
+		t.Errorf("zmap ran %d times, want once per port", n)
+	}
+}
+
+func TestZmapPrivilegeCheckFails(t *testing.T) {
+	calls := failingZmap(t, "unreachable")
+	z := testZmapScanner("10.0.0.0/24")
+	denied := fmt.Errorf("%w: no CAP_NET_RAW", ErrInsufficientPrivileges)
+	z.PrivilegeCheck = func() error { return denied }
+	if _, err := z.ScanPorts(context.Background(), []int{22}); !errors.Is(err, ErrInsufficientPrivileges) {
+		t.Errorf("ScanPorts = %v, want ErrInsufficientPrivileges", err)
+	}
+	if _, err := os.Stat(calls); err == nil {
+		t.Error("zmap launched after the privilege check failed")
+	}
+}
+
+func TestScanCancelled(t *testing.T) {
+	failingZmap(t, "unreachable")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	scans := map[string]func(context.Context, []int) (map[string][]int, error){
+		"zmap": testZmapScanner("10.0.0.0/24").ScanPorts,
+		"tcp":  NewTCPScanner([]string{"127.0.0.1/32"}, 4, 1).ScanPorts,
+	}
+	for name, scan := range scans {
+		_, err := scan(ctx, []int{22, 80})
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("%s: ScanPorts = %v, want context.Canceled", name, err)
+		}
+		if errors.Is(err, ErrBinaryNotFound) || errors.Is(err, ErrInsufficientPrivileges) {
+			t.Errorf("%s: cancellation reported as %v", name, err)
+		}
+	}
+}
+
+func TestTCPNoTargets(t *testing.T) {
+	scope, err := NewScope(nil, []string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewTCPScanner([]string{"10.1.0.0/30", "not-a-network"}, 4, 1)
+	s.Scope = scope
+	if _, err := s.ScanPorts(context.Background(), []int{22, 80}); !errors.Is(err, ErrNoTargets) {
+		t.Errorf("ScanPorts = %v, want ErrNoTargets", err)
+	}
+}
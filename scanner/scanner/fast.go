@@ -0,0 +1,43 @@
+package scanner
+
+import "time"
+
+// DefaultFastTimeout is the suggested fast-phase banner timeout
+const DefaultFastTimeout = time.Second
+
+// clientFirstPorts carry protocols where the client speaks first, so a
+// passive banner read always comes back empty. These go straight to the
+// protocol probe instead of waiting out the fast phase.
+var clientFirstPorts = map[int]bool{
+	80: true, 8080: true, 8000: true, 8888: true, 3128: true, 1080: true,
+	2375: true, 2376: true, 6443: true, 10250: true, 10255: true, 443: true, 8443: true,
+	5432: true, 6379: true, 27017: true, 49: true,
+}
+
+// fastFingerprint is the two-phase fingerprint. A banner read bounded by
+// FastTimeout comes first; a banner that names its service is the result.
+// Only when the banner is empty or unrecognized, and the port has a
+// protocol probe, does the full probe run. Other ports keep the fast
+// result, so silent uninteresting ports cost at most FastTimeout.
+func (f *Fingerprinter) fastFingerprint(ip string, port int) ServiceInfo {
+	if clientFirstPorts[port] {
+		return f.probePort(ip, port)
+	}
+
+	fast := f.grabBanner(ip, port, f.FastTimeout)
+	if fast.Banner != "" {
+		if name := guessServiceFromBanner(fast.Banner, port); name != "" {
+			fast.ServiceName = name
+			if name == "ssh" {
+				fast.ServiceVersion = sshVersion(fast.Banner)
+			} else {
+				fast.ServiceVersion = extractVersion(fast.Banner)
+			}
+			return fast
+		}
+	}
+	if nativeProbe(port) == nil && len(f.ProbeSequence[port]) == 0 && len(f.MultiProbe[port]) == 0 {
+		return fast
+	}
+	return f.probePort(ip, port)
+}
@@ -0,0 +1,89 @@
+package scanner
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// silentStub accepts connections and never speaks, counting connections
+func silentStub(t *testing.T) (string, int, *atomic.Int64) {
+	t.Helper()
+	var conns atomic.Int64
+	ip, port := stubServer(t, func(conn net.Conn) {
+		conns.Add(1)
+		io.Copy(io.Discard, conn)
+	})
+	return ip, port, &conns
+}
+
+func fastFingerprinter() *Fingerprinter {
+	f := testFingerprinter()
+	f.Timeout = time.Second
+	f.FastTimeout = 100 * time.Millisecond
+	return f
+}
+
+func TestFastFingerprintSilentPort(t *testing.T) {
+	ip, port, conns := silentStub(t)
+	f := fastFingerprinter()
+
+	start := time.Now()
+	info := f.fastFingerprint(ip, port)
+	if elapsed := time.Since(start); elapsed >= f.Timeout {
+		t.Errorf("silent port took %v, want about the %v fast timeout", elapsed, f.FastTimeout)
+	}
+	if info.Banner != "" || info.ServiceName != "" {
+		t.Errorf("silent port = %+v, want no banner or service", info)
+	}
+	if got := conns.Load(); got != 1 {
+		t.Errorf("%d connections, want 1: a silent port without a probe is not escalated", got)
+	}
+}
+
+func TestFastFingerprintBannerPort(t *testing.T) {
+	var conns atomic.Int64
+	ip, port := stubServer(t, func(conn net.Conn) {
+		conns.Add(1)
+		io.WriteString(conn, "SSH-2.0-OpenSSH_9.6\r\n")
+		io.Copy(io.Discard, conn)
+	})
+	f := fastFingerprinter()
+	// A probe is configured, but the banner already names the service
+	f.ProbeSequence = map[int][]string{port: {"ssh"}}
+
+	info := f.fastFingerprint(ip, port)
+	if info.ServiceName != "ssh" || info.ServiceVersion != sshVersion(info.Banner) || info.ServiceVersion == "" {
+		t.Errorf("banner port = %+v, want ssh with its version", info)
+	}
+	if got := conns.Load(); got != 1 {
+		t.Errorf("%d connections, want 1: an identifying banner is not escalated", got)
+	}
+}
+
+func TestFastFingerprintEscalatesProbedPort(t *testing.T) {
+	ip, port, conns := silentStub(t)
+	f := fastFingerprinter()
+	f.ProbeSequence = map[int][]string{port: {"ssh"}}
+
+	f.fastFingerprint(ip, port)
+	if !eventually(func() bool { return conns.Load() == 2 }) {
+		t.Errorf("%d connections, want 2: a silent port with a probe gets the full probe", conns.Load())
+	}
+}
+
+func TestFingerprintPortFastPhaseOptional(t *testing.T) {
+	ip, port, conns := silentStub(t)
+	f := fastFingerprinter()
+	f.ProbeSequence = map[int][]string{port: {"ssh"}}
+
+	// Without a fast timeout the protocol probe runs directly
+	f.FastTimeout = 0
+	f.fingerprintPort(context.Background(), ip, port)
+	if !eventually(func() bool { return conns.Load() == 1 }) {
+		t.Errorf("%d connections without a fast phase, want 1", conns.Load())
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"io"
 	"net"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -24,6 +25,85 @@ type ServiceInfo struct {
 type Fingerprinter struct {
 	Timeout    time.Duration
 	MaxBanner  int
+
+	// ProxyTestTarget is the host:port proxies are asked to tunnel to when
+	// checking for open proxies. Empty disables the check.
+	ProxyTestTarget string
+
+	// SMTPRelayTest enables the open-relay check on SMTP ports
+	SMTPRelayTest bool
+
+	// FTPAnonTest enables the anonymous login check on FTP services
+	FTPAnonTest bool
+
+	// AXFRTest enables the zone transfer check on TCP 53, for the zones
+	// named by the server's reverse lookup through Resolver
+	AXFRTest bool
+	Resolver *Resolver
+
+	// MetadataTest enables the cloud metadata forwarding check on HTTP(S)
+	// services
+	MetadataTest bool
+
+	// NTLMInfo answers HTTP(S) NTLM challenges with a negotiate message to
+	// learn the server's domain and host names
+	NTLMInfo bool
+
+	// SourceIP, when set, is the local address probes connect from
+	SourceIP net.IP
+
+	// SocketOptions, when set, are applied to every probe connection
+	SocketOptions *SocketOptions
+
+	// ProbeStats, when set, counts the outcome of every port fingerprinted
+	ProbeStats *ProbeStats
+
+	// ProbeSequence lists, per port, the probes tried in order until one
+	// identifies the service, in place of the port's usual probe
+	ProbeSequence map[int][]string
+
+	// MultiProbe lists, per port, probes run at once whose results are
+	// merged into the richest, in place of the port's usual probe
+	MultiProbe map[int][]string
+
+	// GracefulClose sends the protocol's QUIT/LOGOUT before closing
+	// FTP, SMTP, POP3, IMAP and Redis probe connections
+	GracefulClose bool
+
+	// LBDetectSamples is how many HTTP(S) requests are compared to detect a
+	// load balancer; below 2 disables the check
+	LBDetectSamples int
+
+	// FastTimeout, when set, enables two-phase fingerprinting: a banner
+	// read bounded by FastTimeout first, and the full protocol probe only
+	// when that is inconclusive (see fastFingerprint)
+	FastTimeout time.Duration
+
+	// WebSocketPaths are tried with an upgrade handshake on HTTP(S) ports;
+	// empty disables WebSocket detection
+	WebSocketPaths []string
+
+	// Fixtures, when set, records every probe connection or replays
+	// recorded ones instead of using the network
+	Fixtures *Fixtures
+
+	// ICMP, when set, correlates ICMP errors to UDP probes so unanswered
+	// ports can be told apart as closed or filtered
+	ICMP *ICMPListener
+
+	// Sockets, when set, caps the probe connections open at once
+	Sockets *SocketBudget
+
+	// ctx is the context of the scan the probes run for, set per call by
+	// withContext; nil is context.Background()
+	ctx context.Context
+
+	// BodyBudget, when set, caps the response body bytes read per scan
+	BodyBudget *BodyBudget
+
+	// ProxyProtocolPorts maps ports whose services sit behind a PROXY
+	// protocol load balancer to the header version (1 or 2) sent first
+	ProxyProtocolPorts map[int]int
 }
 
 // NewFingerprinter creates a new Fingerprinter instance
@@ -52,65 +132,90 @@ func (f *Fingerprinter) FingerprintHost(ctx context.Context, ip string, ports []
 	return results
 }
 
+// withContext returns a shallow copy of f whose probes give up waiting for
+// the socket budget once ctx is cancelled
+func (f *Fingerprinter) withContext(ctx context.Context) *Fingerprinter {
+	g := *f
+	g.ctx = ctx
+	return &g
+}
+
+// context returns the context set by withContext
+func (f *Fingerprinter) context() context.Context {
+	if f.ctx == nil {
+		return context.Background()
+	}
+	return f.ctx
+}
+
 func (f *Fingerprinter) fingerprintPort(ctx context.Context, ip string, port int) ServiceInfo {
+	f = f.withContext(ctx)
 	var info ServiceInfo
-
-	// Try protocol-specific probes based on port
-	switch port {
-	case 21:
-		info = f.probeFTP(ip, port)
-	case 22:
-		info = f.probeSSH(ip, port)
-	case 23:
-		info = f.probeTelnet(ip, port)
-	case 25, 465, 587:
-		info = f.probeSMTP(ip, port)
-	case 80, 8080, 8000, 8888:
-		info = f.probeHTTP(ip, port, false)
-	case 443, 8443:
-		info = f.probeHTTP(ip, port, true)
-	case 110:
-		info = f.probePOP3(ip, port)
-	case 143:
-		info = f.probeIMAP(ip, port)
-	case 3306:
-		info = f.probeMySQL(ip, port)
-	case 5432:
-		info = f.probePostgreSQL(ip, port)
-	case 6379:
-		info = f.probeRedis(ip, port)
-	case 27017:
-		info = f.probeMongoDB(ip, port)
-	default:
-		// Generic banner grab
-		info = f.probeGeneric(ip, port)
+	if f.FastTimeout > 0 {
+		info = f.fastFingerprint(ip, port)
+	} else {
+		info = f.probePort(ip, port)
 	}
 
+	f.checkOpenProxy(ip, port, &info)
+	f.checkOpenRelay(ip, port, &info)
+	f.checkAnonymousFTP(ip, port, &info)
+	f.checkAXFR(ctx, ip, port, &info)
+	f.checkLoadBalancer(ip, port, &info)
+	f.checkWebSocket(ip, port, &info)
+	f.checkCloudMetadata(ip, port, &info)
+	f.checkNTLMInfo(ip, port, &info)
+	f.markProxyProtocol(port, &info)
+	markProduct(&info)
+
 	// If we didn't get a service name, try to guess from banner
 	if info.ServiceName == "" && info.Banner != "" {
 		info.ServiceName = guessServiceFromBanner(info.Banner, port)
 	}
 
 	// Fall back to port-based service name
-	if info.ServiceName == "" {
+	fallback := info.ServiceName == ""
+	if fallback {
 		info.ServiceName = getDefaultServiceName(port)
 	}
+	f.ProbeStats.record(info, fallback)
 
 	return info
 }
 
+// probePort runs the protocol-specific probe for port, or a generic banner
+// grab for ports without one (see protocols)
+func (f *Fingerprinter) probePort(ip string, port int) ServiceInfo {
+	if names := f.MultiProbe[port]; len(names) > 0 {
+		return f.probeMulti(ip, port, names)
+	}
+	if names := f.ProbeSequence[port]; len(names) > 0 {
+		return f.probeSequence(ip, port, names)
+	}
+	if probe := nativeProbe(port); probe != nil {
+		return probe(f, ip, port)
+	}
+	// Generic banner grab
+	return f.probeGeneric(ip, port)
+}
+
 // probeGeneric tries to get a banner by connecting and waiting
 func (f *Fingerprinter) probeGeneric(ip string, port int) ServiceInfo {
+	return f.grabBanner(ip, port, f.Timeout)
+}
+
+// grabBanner connects and reads whatever the service sends within timeout
+func (f *Fingerprinter) grabBanner(ip string, port int, timeout time.Duration) ServiceInfo {
 	var info ServiceInfo
-	address := fmt.Sprintf("%s:%d", ip, port)
+	address := net.JoinHostPort(ip, strconv.Itoa(port))
 
-	conn, err := net.DialTimeout("tcp", address, f.Timeout)
+	conn, err := f.dialTimeout(address, timeout)
 	if err != nil {
 		return info
 	}
 	defer conn.Close()
 
-	conn.SetReadDeadline(time.Now().Add(f.Timeout))
+	conn.SetReadDeadline(time.Now().Add(timeout))
 
 	// Wait for banner (some services send immediately)
 	buf := make([]byte, f.MaxBanner)
@@ -126,9 +231,9 @@ func (f *Fingerprinter) probeGeneric(ip string, port int) ServiceInfo {
 func (f *Fingerprinter) probeSSH(ip string, port int) ServiceInfo {
 	var info ServiceInfo
 	info.ServiceName = "ssh"
-	address := fmt.Sprintf("%s:%d", ip, port)
+	address := net.JoinHostPort(ip, strconv.Itoa(port))
 
-	conn, err := net.DialTimeout("tcp", address, f.Timeout)
+	conn, err := f.dial(address)
 	if err != nil {
 		return info
 	}
@@ -144,17 +249,29 @@ func (f *Fingerprinter) probeSSH(ip string, port int) ServiceInfo {
 
 	info.Banner = sanitizeBanner(banner)
 
-	// Parse SSH version from banner like "SSH-2.0-OpenSSH_8.9p1 Ubuntu-3ubuntu0.1"
+	info.ServiceVersion = sshVersion(banner)
+
 	if strings.HasPrefix(banner, "SSH-") {
-		parts := strings.SplitN(banner, "-", 3)
-		if len(parts) >= 3 {
-			info.ServiceVersion = strings.TrimSpace(parts[2])
+		if hostKey, err := sshHostKey(conn, reader, f.Timeout); err == nil {
+			info.Fingerprint = map[string]interface{}{"host_key": hostKey}
 		}
 	}
 
 	return info
 }
 
+// sshVersion parses the software version from an SSH identification line
+// like "SSH-2.0-OpenSSH_8.9p1 Ubuntu-3ubuntu0.1"
+func sshVersion(banner string) string {
+	if strings.HasPrefix(banner, "SSH-") {
+		parts := strings.SplitN(banner, "-", 3)
+		if len(parts) >= 3 {
+			return strings.TrimSpace(parts[2])
+		}
+	}
+	return ""
+}
+
 // probeHTTP sends an HTTP request and parses response
 func (f *Fingerprinter) probeHTTP(ip string, port int, useTLS bool) ServiceInfo {
 	var info ServiceInfo
@@ -163,20 +280,12 @@ func (f *Fingerprinter) probeHTTP(ip string, port int, useTLS bool) ServiceInfo
 	} else {
 		info.ServiceName = "http"
 	}
-	address := fmt.Sprintf("%s:%d", ip, port)
+	address := net.JoinHostPort(ip, strconv.Itoa(port))
 
 	var conn net.Conn
 	var err error
 
-	if useTLS {
-		dialer := &net.Dialer{Timeout: f.Timeout}
-		conn, err = tls.DialWithDialer(dialer, "tcp", address, &tls.Config{
-			InsecureSkipVerify: true,
-		})
-	} else {
-		conn, err = net.DialTimeout("tcp", address, f.Timeout)
-	}
-
+	conn, err = f.dial(address)
 	if err != nil {
 		return info
 	}
@@ -184,37 +293,63 @@ func (f *Fingerprinter) probeHTTP(ip string, port int, useTLS bool) ServiceInfo
 
 	conn.SetDeadline(time.Now().Add(f.Timeout))
 
+	var tlsInfo map[string]interface{}
+	if useTLS {
+		tlsConn := tls.Client(conn, &tls.Config{InsecureSkipVerify: true})
+		if err := tlsConn.Handshake(); err != nil {
+			return info
+		}
+		tlsInfo = tlsStateInfo(tlsConn.ConnectionState())
+		conn = tlsConn
+	}
+
 	// Send HTTP request
 	request := fmt.Sprintf("GET / HTTP/1.1\r\nHost: %s\r\nUser-Agent: NetworkScanner/1.0\r\nConnection: close\r\n\r\n", ip)
 	conn.Write([]byte(request))
 
-	// Read response
-	buf := make([]byte, f.MaxBanner)
-	n, _ := conn.Read(buf)
-	if n > 0 {
-		response := string(buf[:n])
-		info.Banner = sanitizeBanner(response)
-
-		// Extract Server header
-		serverRe := regexp.MustCompile(`(?i)Server:\s*([^\r\n]+)`)
-		if matches := serverRe.FindStringSubmatch(response); len(matches) > 1 {
-			info.ServiceVersion = strings.TrimSpace(matches[1])
-		}
+	// Read response. The first MaxBanner raw bytes form the banner; the
+	// parsed response gives the decoded body for the title.
+	raw := &cappedBuffer{max: f.MaxBanner}
+	reader := bufio.NewReader(io.TeeReader(conn, raw))
+	body, parsed := readHTTPResponse(reader, f.BodyBudget)
+	if raw.Len() == 0 {
+		return info
+	}
 
-		// Store additional fingerprint data
-		info.Fingerprint = make(map[string]interface{})
+	response := raw.String()
+	info.Banner = sanitizeBanner(response)
+	info.Fingerprint = make(map[string]interface{})
+	if tlsInfo != nil {
+		info.Fingerprint["tls"] = tlsInfo
+	}
 
-		// Extract status code
-		statusRe := regexp.MustCompile(`HTTP/[\d.]+\s+(\d+)`)
-		if matches := statusRe.FindStringSubmatch(response); len(matches) > 1 {
-			info.Fingerprint["status_code"] = matches[1]
+	if parsed != nil {
+		if server := parsed.Header.Get("Server"); server != "" {
+			info.ServiceVersion = strings.TrimSpace(server)
 		}
-
-		// Extract title if present
-		titleRe := regexp.MustCompile(`(?i)<title>([^<]+)</title>`)
-		if matches := titleRe.FindStringSubmatch(response); len(matches) > 1 {
-			info.Fingerprint["title"] = strings.TrimSpace(matches[1])
+		info.Fingerprint["status_code"] = strconv.Itoa(parsed.StatusCode)
+		if title := extractTitle(body); title != "" {
+			info.Fingerprint["title"] = title
 		}
+		if cdn := detectCDN(parsed.Header.Get); cdn != "" {
+			info.Fingerprint["cdn"] = cdn
+		}
+		markHTTPAuth(&info, parsed.StatusCode, parsed.Header.Values("WWW-Authenticate"))
+		return info
+	}
+
+	// Not parseable as HTTP/1.x; fall back to scanning the raw text
+	serverRe := regexp.MustCompile(`(?i)Server:\s*([^\r\n]+)`)
+	if matches := serverRe.FindStringSubmatch(response); len(matches) > 1 {
+		info.ServiceVersion = strings.TrimSpace(matches[1])
+	}
+	statusRe := regexp.MustCompile(`HTTP/[\d.]+\s+(\d+)`)
+	if matches := statusRe.FindStringSubmatch(response); len(matches) > 1 {
+		info.Fingerprint["status_code"] = matches[1]
+	}
+	titleRe := regexp.MustCompile(`(?i)<title>([^<]+)</title>`)
+	if matches := titleRe.FindStringSubmatch(response); len(matches) > 1 {
+		info.Fingerprint["title"] = strings.TrimSpace(matches[1])
 	}
 
 	return info
@@ -224,9 +359,9 @@ func (f *Fingerprinter) probeHTTP(ip string, port int, useTLS bool) ServiceInfo
 func (f *Fingerprinter) probeFTP(ip string, port int) ServiceInfo {
 	var info ServiceInfo
 	info.ServiceName = "ftp"
-	address := fmt.Sprintf("%s:%d", ip, port)
+	address := net.JoinHostPort(ip, strconv.Itoa(port))
 
-	conn, err := net.DialTimeout("tcp", address, f.Timeout)
+	conn, err := f.dial(address)
 	if err != nil {
 		return info
 	}
@@ -242,28 +377,31 @@ func (f *Fingerprinter) probeFTP(ip string, port int) ServiceInfo {
 	if strings.HasPrefix(banner, "220") {
 		info.ServiceVersion = extractVersion(banner)
 	}
+	if banner != "" {
+		f.quit(conn, quitLine)
+	}
 
 	return info
 }
 
-// probeTelnet connects and reads telnet banner
+// probeTelnet negotiates telnet options, reads up to the first prompt and
+// records in Fingerprint["telnet_auth"] whether it asks for a login, only a
+// password, or is already a shell
 func (f *Fingerprinter) probeTelnet(ip string, port int) ServiceInfo {
 	var info ServiceInfo
 	info.ServiceName = "telnet"
-	address := fmt.Sprintf("%s:%d", ip, port)
+	address := net.JoinHostPort(ip, strconv.Itoa(port))
 
-	conn, err := net.DialTimeout("tcp", address, f.Timeout)
+	conn, err := f.dial(address)
 	if err != nil {
 		return info
 	}
 	defer conn.Close()
 
-	conn.SetReadDeadline(time.Now().Add(f.Timeout))
-
-	buf := make([]byte, f.MaxBanner)
-	n, _ := conn.Read(buf)
-	if n > 0 {
-		info.Banner = sanitizeBanner(string(buf[:n]))
+	text := f.readTelnet(conn)
+	info.Banner = sanitizeBanner(text)
+	if auth := classifyTelnetPrompt(text); auth != "" {
+		info.Fingerprint = map[string]interface{}{"telnet_auth": auth}
 	}
 
 	return info
@@ -273,9 +411,9 @@ func (f *Fingerprinter) probeTelnet(ip string, port int) ServiceInfo {
 func (f *Fingerprinter) probeSMTP(ip string, port int) ServiceInfo {
 	var info ServiceInfo
 	info.ServiceName = "smtp"
-	address := fmt.Sprintf("%s:%d", ip, port)
+	address := net.JoinHostPort(ip, strconv.Itoa(port))
 
-	conn, err := net.DialTimeout("tcp", address, f.Timeout)
+	conn, err := f.dial(address)
 	if err != nil {
 		return info
 	}
@@ -289,6 +427,10 @@ func (f *Fingerprinter) probeSMTP(ip string, port int) ServiceInfo {
 
 	if strings.HasPrefix(banner, "220") {
 		info.ServiceVersion = extractVersion(banner)
+		conn = f.smtpStartTLS(conn, reader, &info)
+	}
+	if banner != "" {
+		f.quit(conn, quitLine)
 	}
 
 	return info
@@ -298,9 +440,9 @@ func (f *Fingerprinter) probeSMTP(ip string, port int) ServiceInfo {
 func (f *Fingerprinter) probePOP3(ip string, port int) ServiceInfo {
 	var info ServiceInfo
 	info.ServiceName = "pop3"
-	address := fmt.Sprintf("%s:%d", ip, port)
+	address := net.JoinHostPort(ip, strconv.Itoa(port))
 
-	conn, err := net.DialTimeout("tcp", address, f.Timeout)
+	conn, err := f.dial(address)
 	if err != nil {
 		return info
 	}
@@ -311,6 +453,12 @@ func (f *Fingerprinter) probePOP3(ip string, port int) ServiceInfo {
 	reader := bufio.NewReader(conn)
 	banner, _ := reader.ReadString('\n')
 	info.Banner = sanitizeBanner(banner)
+	if strings.HasPrefix(banner, "+OK") {
+		conn = f.pop3StartTLS(conn, reader, &info)
+	}
+	if banner != "" {
+		f.quit(conn, quitLine)
+	}
 
 	return info
 }
@@ -319,9 +467,9 @@ func (f *Fingerprinter) probePOP3(ip string, port int) ServiceInfo {
 func (f *Fingerprinter) probeIMAP(ip string, port int) ServiceInfo {
 	var info ServiceInfo
 	info.ServiceName = "imap"
-	address := fmt.Sprintf("%s:%d", ip, port)
+	address := net.JoinHostPort(ip, strconv.Itoa(port))
 
-	conn, err := net.DialTimeout("tcp", address, f.Timeout)
+	conn, err := f.dial(address)
 	if err != nil {
 		return info
 	}
@@ -332,6 +480,12 @@ func (f *Fingerprinter) probeIMAP(ip string, port int) ServiceInfo {
 	reader := bufio.NewReader(conn)
 	banner, _ := reader.ReadString('\n')
 	info.Banner = sanitizeBanner(banner)
+	if strings.HasPrefix(banner, "* OK") {
+		conn = f.imapStartTLS(conn, reader, &info)
+	}
+	if banner != "" {
+		f.quit(conn, imapQuit)
+	}
 
 	return info
 }
@@ -340,9 +494,9 @@ func (f *Fingerprinter) probeIMAP(ip string, port int) ServiceInfo {
 func (f *Fingerprinter) probeMySQL(ip string, port int) ServiceInfo {
 	var info ServiceInfo
 	info.ServiceName = "mysql"
-	address := fmt.Sprintf("%s:%d", ip, port)
+	address := net.JoinHostPort(ip, strconv.Itoa(port))
 
-	conn, err := net.DialTimeout("tcp", address, f.Timeout)
+	conn, err := f.dial(address)
 	if err != nil {
 		return info
 	}
@@ -375,9 +529,9 @@ func (f *Fingerprinter) probeMySQL(ip string, port int) ServiceInfo {
 func (f *Fingerprinter) probePostgreSQL(ip string, port int) ServiceInfo {
 	var info ServiceInfo
 	info.ServiceName = "postgresql"
-	address := fmt.Sprintf("%s:%d", ip, port)
+	address := net.JoinHostPort(ip, strconv.Itoa(port))
 
-	conn, err := net.DialTimeout("tcp", address, f.Timeout)
+	conn, err := f.dial(address)
 	if err != nil {
 		return info
 	}
@@ -407,9 +561,9 @@ func (f *Fingerprinter) probePostgreSQL(ip string, port int) ServiceInfo {
 func (f *Fingerprinter) probeRedis(ip string, port int) ServiceInfo {
 	var info ServiceInfo
 	info.ServiceName = "redis"
-	address := fmt.Sprintf("%s:%d", ip, port)
+	address := net.JoinHostPort(ip, strconv.Itoa(port))
 
-	conn, err := net.DialTimeout("tcp", address, f.Timeout)
+	conn, err := f.dial(address)
 	if err != nil {
 		return info
 	}
@@ -440,6 +594,7 @@ func (f *Fingerprinter) probeRedis(ip string, port int) ServiceInfo {
 				info.ServiceVersion = matches[1]
 			}
 		}
+		f.quit(conn, redisQuit)
 	}
 
 	return info
@@ -449,9 +604,9 @@ func (f *Fingerprinter) probeRedis(ip string, port int) ServiceInfo {
 func (f *Fingerprinter) probeMongoDB(ip string, port int) ServiceInfo {
 	var info ServiceInfo
 	info.ServiceName = "mongodb"
-	address := fmt.Sprintf("%s:%d", ip, port)
+	address := net.JoinHostPort(ip, strconv.Itoa(port))
 
-	conn, err := net.DialTimeout("tcp", address, f.Timeout)
+	conn, err := f.dial(address)
 	if err != nil {
 		return info
 	}
@@ -0,0 +1,315 @@
+package scanner
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fixtures records every native probe connection as raw bytes, one JSON
+// file per IP:port in Dir, or replays such a directory in place of the
+// network so fingerprint parsing can be reproduced offline. Replayed
+// connections return the recorded server bytes in order and ignore what
+// the probe writes. TLS sessions are recorded as ciphertext and cannot be
+// replayed, so TLS probes replay as failed handshakes.
+type Fixtures struct {
+	Dir    string
+	replay bool
+
+	mu    sync.Mutex
+	files map[string]*fixtureFile // by address
+	dials map[string]int          // replay: connections handed out per address
+}
+
+// fixtureFile is the on-disk form of one endpoint's connections, in dial
+// order
+type fixtureFile struct {
+	Address     string              `json:"address"`
+	Connections []fixtureConnection `json:"connections"`
+}
+
+// fixtureConnection is one probe connection. DialError is set instead of
+// Events when the connection failed. End is how reading finished: "eof",
+// "timeout", another error message, or "" if the probe closed first.
+type fixtureConnection struct {
+	DialError string         `json:"dial_error,omitempty"`
+	Events    []fixtureEvent `json:"events,omitempty"`
+	End       string         `json:"end,omitempty"`
+}
+
+// fixtureEvent is one Read or Write, in the order the probe made them
+type fixtureEvent struct {
+	Read  []byte `json:"read,omitempty"`
+	Write []byte `json:"write,omitempty"`
+}
+
+// NewFixtureRecorder records probe connections into dir, creating it
+func NewFixtureRecorder(dir string) (*Fixtures, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &Fixtures{Dir: dir, files: make(map[string]*fixtureFile)}, nil
+}
+
+// NewFixtureReplayer serves probe connections from the recordings in dir
+func NewFixtureReplayer(dir string) (*Fixtures, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	return &Fixtures{
+		Dir:    dir,
+		replay: true,
+		files:  make(map[string]*fixtureFile),
+		dials:  make(map[string]int),
+	}, nil
+}
+
+// Replaying reports whether connections come from recordings
+func (x *Fixtures) Replaying() bool {
+	return x.replay
+}
+
+// Dial returns the next connection to address. When recording it calls
+// dial and records the connection; when replaying dial is never called.
+func (x *Fixtures) Dial(address string, dial func() (net.Conn, error)) (net.Conn, error) {
+	if x.replay {
+		return x.replayConn(address)
+	}
+
+	conn, err := dial()
+	if err != nil {
+		x.save(address, fixtureConnection{DialError: err.Error()})
+		return nil, err
+	}
+	return &recordingConn{Conn: conn, fixtures: x, address: address}, nil
+}
+
+// Targets lists the endpoints in the fixture directory
+func (x *Fixtures) Targets() ([]ZmapResult, error) {
+	paths, err := filepath.Glob(filepath.Join(x.Dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var targets []ZmapResult
+	for _, path := range paths {
+		file, err := readFixtureFile(path)
+		if err != nil {
+			return nil, err
+		}
+		host, portStr, err := net.SplitHostPort(file.Address)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		port, err := strconv.Atoi(portStr)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid port %q", path, portStr)
+		}
+		targets = append(targets, ZmapResult{IP: host, Port: port})
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		if targets[i].Port != targets[j].Port {
+			return targets[i].Port < targets[j].Port
+		}
+		return targets[i].IP < targets[j].IP
+	})
+	return targets, nil
+}
+
+// fixturePath maps an address to its file, e.g. 10.0.0.5:22 to
+// 10.0.0.5_22.json and [2001:db8::1]:443 to 2001-db8--1_443.json
+func (x *Fixtures) fixturePath(address string) string {
+	host, port, _ := net.SplitHostPort(address)
+	return filepath.Join(x.Dir, strings.ReplaceAll(host, ":", "-")+"_"+port+".json")
+}
+
+// save appends a finished connection to address's file
+func (x *Fixtures) save(address string, conn fixtureConnection) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	file := x.files[address]
+	if file == nil {
+		file = &fixtureFile{Address: address}
+		x.files[address] = file
+	}
+	file.Connections = append(file.Connections, conn)
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err == nil {
+		err = os.WriteFile(x.fixturePath(address), data, 0o644)
+	}
+	if err != nil {
+		// Recording is a debugging aid; never fail the probe over it
+		log.Printf("Warning: failed to record fixture for %s: %v", address, err)
+	}
+}
+
+// replayConn hands out address's connections in recorded order, starting
+// over once all have been used so repeated scans replay the same way
+func (x *Fixtures) replayConn(address string) (net.Conn, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	file := x.files[address]
+	if file == nil {
+		var err error
+		if file, err = readFixtureFile(x.fixturePath(address)); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil, fmt.Errorf("no fixture for %s", address)
+			}
+			return nil, err
+		}
+		x.files[address] = file
+	}
+	if len(file.Connections) == 0 {
+		return nil, fmt.Errorf("no recorded connections for %s", address)
+	}
+
+	n := x.dials[address] % len(file.Connections)
+	x.dials[address]++
+	recorded := file.Connections[n]
+	if recorded.DialError != "" {
+		return nil, errors.New(recorded.DialError)
+	}
+	return newReplayConn(address, recorded), nil
+}
+
+func readFixtureFile(path string) (*fixtureFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	file := &fixtureFile{}
+	if err := json.Unmarshal(data, file); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return file, nil
+}
+
+// recordingConn records a live connection's traffic, saving it on Close
+type recordingConn struct {
+	net.Conn
+	fixtures *Fixtures
+	address  string
+
+	mu       sync.Mutex
+	recorded fixtureConnection
+	closed   bool
+}
+
+func (c *recordingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if n > 0 {
+		c.recorded.Events = append(c.recorded.Events, fixtureEvent{Read: append([]byte(nil), p[:n]...)})
+	}
+	if err != nil && c.recorded.End == "" {
+		c.recorded.End = fixtureEnd(err)
+	}
+	return n, err
+}
+
+func (c *recordingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.mu.Lock()
+		c.recorded.Events = append(c.recorded.Events, fixtureEvent{Write: append([]byte(nil), p[:n]...)})
+		c.mu.Unlock()
+	}
+	return n, err
+}
+
+func (c *recordingConn) Close() error {
+	c.mu.Lock()
+	if !c.closed {
+		c.closed = true
+		c.fixtures.save(c.address, c.recorded)
+	}
+	c.mu.Unlock()
+	return c.Conn.Close()
+}
+
+// fixtureEnd names a read error for the recording
+func fixtureEnd(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, io.EOF):
+		return "eof"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	default:
+		return err.Error()
+	}
+}
+
+// replayConn plays back a recorded connection
+type replayConn struct {
+	address string
+	reads   [][]byte
+	end     error
+}
+
+func newReplayConn(address string, recorded fixtureConnection) *replayConn {
+	c := &replayConn{address: address}
+	for _, event := range recorded.Events {
+		if len(event.Read) > 0 {
+			c.reads = append(c.reads, event.Read)
+		}
+	}
+	switch recorded.End {
+	case "eof":
+		c.end = io.EOF
+	case "timeout", "":
+		// A probe that closed first would have waited out its deadline
+		c.end = replayTimeout{}
+	default:
+		c.end = errors.New(recorded.End)
+	}
+	return c
+}
+
+// Read returns the next recorded chunk, or the recorded end once all have
+// been read
+func (c *replayConn) Read(p []byte) (int, error) {
+	if len(c.reads) == 0 {
+		return 0, c.end
+	}
+	n := copy(p, c.reads[0])
+	if c.reads[0] = c.reads[0][n:]; len(c.reads[0]) == 0 {
+		c.reads = c.reads[1:]
+	}
+	return n, nil
+}
+
+func (c *replayConn) Write(p []byte) (int, error)      { return len(p), nil }
+func (c *replayConn) Close() error                     { return nil }
+func (c *replayConn) LocalAddr() net.Addr              { return &net.TCPAddr{} }
+func (c *replayConn) SetDeadline(time.Time) error      { return nil }
+func (c *replayConn) SetReadDeadline(time.Time) error  { return nil }
+func (c *replayConn) SetWriteDeadline(time.Time) error { return nil }
+
+func (c *replayConn) RemoteAddr() net.Addr {
+	addr, err := net.ResolveTCPAddr("tcp", c.address)
+	if err != nil {
+		return &net.TCPAddr{}
+	}
+	return addr
+}
+
+// replayTimeout is the read deadline error replayed for "timeout"
+type replayTimeout struct{}
+
+func (replayTimeout) Error() string   { return "i/o timeout (replayed)" }
+func (replayTimeout) Timeout() bool   { return true }
+func (replayTimeout) Temporary() bool { return true }
@@ -0,0 +1,109 @@
+package scanner
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+)
+
+// fixtureStubs starts an SSH-style banner server and an HTTP server that
+// count their connections, plus a closed port
+func fixtureStubs(t *testing.T) (ip string, ports []int, conns *atomic.Int64) {
+	t.Helper()
+	conns = &atomic.Int64{}
+	_, ssh := stubServer(t, func(conn net.Conn) {
+		conns.Add(1)
+		io.WriteString(conn, "SSH-2.0-OpenSSH_9.6p1 Ubuntu-3ubuntu13\r\n")
+		io.Copy(io.Discard, conn)
+	})
+	ip, web := stubServer(t, func(conn net.Conn) {
+		conns.Add(1)
+		bufio.NewReader(conn).ReadString('\n')
+		body := "<html><head><title>Fixture Page</title></head></html>"
+		fmt.Fprintf(conn, "HTTP/1.1 200 OK\r\nServer: nginx/1.25.3\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s", len(body), body)
+	})
+	return ip, []int{ssh, web, closedPort(t)}, conns
+}
+
+func TestFixturesRecordThenReplay(t *testing.T) {
+	ip, ports, conns := fixtureStubs(t)
+	dir := filepath.Join(t.TempDir(), "fixtures")
+	web := ports[1]
+
+	recorder, err := NewFixtureRecorder(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	live := testFingerprinter()
+	live.ProbeSequence = map[int][]string{web: {"http"}}
+	live.Fixtures = recorder
+	recorded := live.FingerprintHost(context.Background(), ip, ports)
+	if recorded[ports[0]].ServiceName != "ssh" || recorded[web].Fingerprint["title"] != "Fixture Page" {
+		t.Fatalf("live results = %+v, want ssh and the HTTP title", recorded)
+	}
+
+	replayer, err := NewFixtureReplayer(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !replayer.Replaying() {
+		t.Fatal("replayer is not replaying")
+	}
+	offline := testFingerprinter()
+	offline.ProbeSequence = live.ProbeSequence
+	offline.Fixtures = replayer
+
+	before := conns.Load()
+	for range 2 {
+		replayed := offline.FingerprintHost(context.Background(), ip, ports)
+		if !reflect.DeepEqual(replayed, recorded) {
+			t.Errorf("replayed %+v\nrecorded %+v", replayed, recorded)
+		}
+	}
+	if n := conns.Load() - before; n != 0 {
+		t.Errorf("replay made %d network connections, want none", n)
+	}
+
+	// The recordings name every endpoint, the closed port included
+	targets, err := replayer.Targets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(targets) != len(ports) {
+		t.Errorf("Targets() = %+v, want %d endpoints", targets, len(ports))
+	}
+}
+
+func TestFixturesReplayMissing(t *testing.T) {
+	replayer, err := NewFixtureReplayer(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := replayer.Dial("10.0.0.1:22", func() (net.Conn, error) {
+		t.Fatal("replay dialed the network")
+		return nil, nil
+	}); err == nil {
+		t.Error("Dial succeeded with no recording")
+	}
+	if _, err := NewFixtureReplayer(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("NewFixtureReplayer accepted a missing directory")
+	}
+}
+
+func TestFixturePath(t *testing.T) {
+	x := &Fixtures{Dir: "/fixtures"}
+	for address, want := range map[string]string{
+		"10.0.0.5:22":       "/fixtures/10.0.0.5_22.json",
+		"[2001:db8::1]:443": "/fixtures/2001-db8--1_443.json",
+	} {
+		if got := x.fixturePath(address); got != want {
+			t.Errorf("fixturePath(%s) = %s, want %s", address, got, want)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package scanner
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Anonymous login credentials. The password is the conventional
+// "email address" anonymous servers ask for.
+const (
+	ftpAnonUser = "anonymous"
+	ftpAnonPass = "anonymous@"
+)
+
+// maxFTPListing caps how many NLST entries are read when counting the
+// anonymous root listing
+const maxFTPListing = 10000
+
+// pasvReply matches the address in a 227 reply, "(h1,h2,h3,h4,p1,p2)"
+var pasvReply = regexp.MustCompile(`(\d+),(\d+),(\d+),(\d+),(\d+),(\d+)`)
+
+// checkAnonymousFTP tries an anonymous login on an FTP service and records
+// Fingerprint["anonymous_login"]. When the login is accepted the root
+// directory listing is counted into Fingerprint["anonymous_listing_count"];
+// nothing is downloaded or written.
+func (f *Fingerprinter) checkAnonymousFTP(ip string, port int, info *ServiceInfo) {
+	if !f.FTPAnonTest || info.ServiceName != "ftp" {
+		return
+	}
+
+	address := net.JoinHostPort(ip, strconv.Itoa(port))
+	log.Printf("FTP anonymous login test: %s USER %s", address, ftpAnonUser)
+
+	accepted, entries, err := f.ftpAnonymousLogin(ip, address)
+	if err != nil {
+		log.Printf("FTP anonymous login test on %s inconclusive: %v", address, err)
+		return
+	}
+
+	if info.Fingerprint == nil {
+		info.Fingerprint = make(map[string]interface{})
+	}
+	info.Fingerprint["anonymous_login"] = accepted
+	if entries >= 0 {
+		info.Fingerprint["anonymous_listing_count"] = entries
+	}
+	if accepted {
+		log.Printf("FTP anonymous login test: %s allows ANONYMOUS LOGIN", address)
+	}
+}
+
+// ftpAnonymousLogin logs in as anonymous and reports whether the server
+// accepted it and, if so, how many entries the root listing has (-1 when
+// the listing couldn't be read). An error means the test didn't get as far
+// as a login reply.
+func (f *Fingerprinter) ftpAnonymousLogin(ip, address string) (bool, int, error) {
+	conn, err := f.dial(address)
+	if err != nil {
+		return false, -1, err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(f.Timeout))
+	reader := bufio.NewReader(conn)
+
+	if code, err := readFTPReply(reader); err != nil {
+		return false, -1, err
+	} else if code != 220 {
+		return false, -1, fmt.Errorf("greeting rejected with %d", code)
+	}
+
+	command := func(line string) (int, string, error) {
+		if _, err := fmt.Fprintf(conn, "%s\r\n", line); err != nil {
+			return 0, "", err
+		}
+		return readFTPReplyLine(reader)
+	}
+
+	code, _, err := command("USER " + ftpAnonUser)
+	if err != nil {
+		return false, -1, err
+	}
+	// 230 straight after USER is a login that needs no password
+	if code == 331 || code == 332 {
+		if code, _, err = command("PASS " + ftpAnonPass); err != nil {
+			return false, -1, err
+		}
+	}
+	if code != 230 {
+		command("QUIT")
+		return false, -1, nil
+	}
+
+	entries := f.ftpCountListing(ip, command, reader)
+	command("QUIT")
+	return true, entries, nil
+}
+
+// ftpCountListing counts the entries of an NLST of the login directory over
+// a passive data connection, returning -1 if the listing fails. The data
+// connection goes to the control connection's IP whatever address the 227
+// reply names, so a server can't point it elsewhere.
+func (f *Fingerprinter) ftpCountListing(ip string, command func(string) (int, string, error), reader *bufio.Reader) int {
+	code, reply, err := command("PASV")
+	if err != nil || code != 227 {
+		return -1
+	}
+	m := pasvReply.FindStringSubmatch(reply)
+	if m == nil {
+		return -1
+	}
+	p1, _ := strconv.Atoi(m[5])
+	p2, _ := strconv.Atoi(m[6])
+	dataPort := p1<<8 | p2
+	if p1 > 255 || p2 > 255 || dataPort == 0 {
+		return -1
+	}
+
+	data, err := f.dialSecondary(net.JoinHostPort(ip, strconv.Itoa(dataPort)))
+	if err != nil {
+		return -1
+	}
+	defer data.Close()
+
+	if code, _, err = command("NLST"); err != nil || (code != 125 && code != 150) {
+		return -1
+	}
+
+	data.SetDeadline(time.Now().Add(f.Timeout))
+	entries := 0
+	lines := bufio.NewScanner(data)
+	for lines.Scan() && entries < maxFTPListing {
+		if strings.TrimSpace(lines.Text()) != "" {
+			entries++
+		}
+	}
+	data.Close()
+
+	// 226 once the transfer completes
+	if code, err = readFTPReply(reader); err != nil || code != 226 && code != 250 {
+		return -1
+	}
+	return entries
+}
+
+// readFTPReply reads a possibly multi-line reply and returns its status code
+func readFTPReply(reader *bufio.Reader) (int, error) {
+	code, _, err := readFTPReplyLine(reader)
+	return code, err
+}
+
+// readFTPReplyLine is readFTPReply that also returns the reply's last line.
+// Unlike SMTP, the inner lines of a multi-line FTP reply ("230-...") needn't
+// carry the code; the reply ends at the first line starting with the code
+// and a space.
+func readFTPReplyLine(reader *bufio.Reader) (int, string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return 0, "", err
+	}
+	if len(line) < 4 {
+		return 0, "", fmt.Errorf("malformed FTP reply %q", strings.TrimSpace(line))
+	}
+	code, err := strconv.Atoi(line[:3])
+	if err != nil {
+		return 0, "", fmt.Errorf("malformed FTP reply %q", strings.TrimSpace(line))
+	}
+	if line[3] == '-' {
+		end := line[:3] + " "
+		for !strings.HasPrefix(line, end) {
+			if line, err = reader.ReadString('\n'); err != nil {
+				return 0, "", err
+			}
+		}
+	}
+	return code, strings.TrimRight(line, "\r\n"), nil
+}
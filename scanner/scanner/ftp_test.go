@@ -0,0 +1,240 @@
+package scanner
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// ftpStub is a minimal FTP server. With anonymous set it accepts USER
+// anonymous, lists listing over PASV and records every command.
+type ftpStub struct {
+	anonymous bool
+	noPass    bool   // log in on USER without asking for a password
+	pasvHost  string // address the 227 reply names; the data listener is always local
+
+	mu       sync.Mutex
+	commands []string
+}
+
+func (s *ftpStub) serve(t *testing.T, listing []string) (string, int) {
+	t.Helper()
+	return stubServer(t, func(conn net.Conn) {
+		reader := bufio.NewReader(conn)
+		reply := func(format string, args ...interface{}) { fmt.Fprintf(conn, format+"\r\n", args...) }
+		reply("220-Welcome to the test FTP service\r\n220 Ready")
+		var data net.Listener
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			command := strings.TrimSpace(line)
+			s.mu.Lock()
+			s.commands = append(s.commands, command)
+			s.mu.Unlock()
+
+			switch verb, _, _ := strings.Cut(command, " "); verb {
+			case "USER":
+				switch {
+				case !s.anonymous:
+					reply("331 Password required")
+				case s.noPass:
+					reply("230 Anonymous access granted")
+				default:
+					reply("331 Please specify the password")
+				}
+			case "PASS":
+				if s.anonymous {
+					reply("230-Anonymous access granted, restrictions apply\r\n230 Login successful")
+				} else {
+					reply("530 Login incorrect")
+				}
+			case "PASV":
+				if data, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+					reply("425 Can't open data connection")
+					continue
+				}
+				port := data.Addr().(*net.TCPAddr).Port
+				host := strings.ReplaceAll(s.pasvHost, ".", ",")
+				if host == "" {
+					host = "127,0,0,1"
+				}
+				reply("227 Entering Passive Mode (%s,%d,%d)", host, port>>8, port&0xff)
+			case "NLST":
+				if data == nil {
+					reply("425 Use PASV first")
+					continue
+				}
+				dataConn, err := data.Accept()
+				data.Close()
+				if err != nil {
+					return
+				}
+				reply("150 Here comes the directory listing")
+				for _, name := range listing {
+					fmt.Fprintf(dataConn, "%s\r\n", name)
+				}
+				dataConn.Close()
+				reply("226 Directory send OK")
+			case "QUIT":
+				reply("221 Goodbye")
+				return
+			default:
+				reply("502 Command not implemented")
+			}
+		}
+	})
+}
+
+// sent returns the commands the stub received once its session has ended
+func (s *ftpStub) sent() []string {
+	eventually(func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return len(s.commands) > 0 && s.commands[len(s.commands)-1] == "QUIT"
+	})
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.commands...)
+}
+
+// ftpFingerprinter has the anonymous login test on
+func ftpFingerprinter() *Fingerprinter {
+	f := testFingerprinter()
+	f.FTPAnonTest = true
+	return f
+}
+
+func TestAnonymousFTPAccepted(t *testing.T) {
+	stub := &ftpStub{anonymous: true}
+	ip, port := stub.serve(t, []string{"pub", "README", "upload"})
+
+	info := ServiceInfo{ServiceName: "ftp"}
+	ftpFingerprinter().checkAnonymousFTP(ip, port, &info)
+	if info.Fingerprint["anonymous_login"] != true || info.Fingerprint["anonymous_listing_count"] != 3 {
+		t.Errorf("fingerprint = %v, want an anonymous login listing 3 entries", info.Fingerprint)
+	}
+	want := []string{"USER anonymous", "PASS anonymous@", "PASV", "NLST", "QUIT"}
+	if got := stub.sent(); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("commands = %q, want %q", got, want)
+	}
+}
+
+func TestAnonymousFTPRejected(t *testing.T) {
+	stub := &ftpStub{}
+	ip, port := stub.serve(t, nil)
+
+	info := ServiceInfo{ServiceName: "ftp", Fingerprint: map[string]interface{}{"banner_code": 220}}
+	ftpFingerprinter().checkAnonymousFTP(ip, port, &info)
+	if info.Fingerprint["anonymous_login"] != false || info.Fingerprint["banner_code"] != 220 {
+		t.Errorf("fingerprint = %v, want anonymous_login false alongside what was there", info.Fingerprint)
+	}
+	if _, ok := info.Fingerprint["anonymous_listing_count"]; ok {
+		t.Error("listing counted after a rejected login")
+	}
+	// Nothing is listed, and the session still ends with QUIT
+	want := []string{"USER anonymous", "PASS anonymous@", "QUIT"}
+	if got := stub.sent(); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("commands = %q, want %q", got, want)
+	}
+}
+
+func TestAnonymousFTPWithoutPassword(t *testing.T) {
+	stub := &ftpStub{anonymous: true, noPass: true}
+	ip, port := stub.serve(t, []string{"pub"})
+	info := ServiceInfo{ServiceName: "ftp"}
+	ftpFingerprinter().checkAnonymousFTP(ip, port, &info)
+	if info.Fingerprint["anonymous_login"] != true || info.Fingerprint["anonymous_listing_count"] != 1 {
+		t.Errorf("fingerprint = %v", info.Fingerprint)
+	}
+	if got := stub.sent(); got[1] == "PASS anonymous@" {
+		t.Errorf("commands = %q, want no PASS after a 230 to USER", got)
+	}
+}
+
+func TestAnonymousFTPDataStaysOnTarget(t *testing.T) {
+	// The 227 reply names another host; the listing is still read from
+	// the server's own address
+	stub := &ftpStub{anonymous: true, pasvHost: "192.0.2.10"}
+	ip, port := stub.serve(t, []string{"a", "b"})
+	info := ServiceInfo{ServiceName: "ftp"}
+	ftpFingerprinter().checkAnonymousFTP(ip, port, &info)
+	if info.Fingerprint["anonymous_listing_count"] != 2 {
+		t.Errorf("fingerprint = %v, want the listing from the control connection's IP", info.Fingerprint)
+	}
+}
+
+func TestAnonymousFTPSingleSocketBudget(t *testing.T) {
+	stub := &ftpStub{anonymous: true}
+	ip, port := stub.serve(t, []string{"pub"})
+	f := ftpFingerprinter()
+	f.Sockets = NewSocketBudget(1)
+
+	done := make(chan ServiceInfo)
+	go func() {
+		info := ServiceInfo{ServiceName: "ftp"}
+		f.checkAnonymousFTP(ip, port, &info)
+		done <- info
+	}()
+	select {
+	case info := <-done:
+		if info.Fingerprint["anonymous_listing_count"] != 1 {
+			t.Errorf("fingerprint = %v, want the listing", info.Fingerprint)
+		}
+	case <-time.After(f.Timeout + time.Second):
+		t.Fatal("anonymous login test deadlocked on a one-socket budget")
+	}
+	if len(f.Sockets.sem) != 0 {
+		t.Errorf("%d sockets still taken", len(f.Sockets.sem))
+	}
+}
+
+func TestAnonymousFTPGated(t *testing.T) {
+	port, accepted := countAccepts(t, "127.0.0.1")
+	for name, tc := range map[string]struct {
+		enabled bool
+		service string
+	}{
+		"disabled": {false, "ftp"},
+		"not ftp":  {true, "ssh"},
+	} {
+		f := testFingerprinter()
+		f.FTPAnonTest = tc.enabled
+		info := ServiceInfo{ServiceName: tc.service}
+		f.checkAnonymousFTP("127.0.0.1", port, &info)
+		if info.Fingerprint != nil {
+			t.Errorf("%s: fingerprint = %v", name, info.Fingerprint)
+		}
+	}
+	if accepted() != 0 {
+		t.Errorf("%d connections made with the test off", accepted())
+	}
+}
+
+func TestReadFTPReplyLine(t *testing.T) {
+	tests := []struct {
+		input string
+		code  int
+		line  string
+	}{
+		{"220 Ready\r\n", 220, "220 Ready"},
+		{"230-Welcome\r\nno code here\r\n230 OK\r\n", 230, "230 OK"},
+		{"227 Entering Passive Mode (1,2,3,4,5,6)\r\n", 227, "227 Entering Passive Mode (1,2,3,4,5,6)"},
+	}
+	for _, tt := range tests {
+		code, line, err := readFTPReplyLine(bufio.NewReader(strings.NewReader(tt.input)))
+		if err != nil || code != tt.code || line != tt.line {
+			t.Errorf("readFTPReplyLine(%q) = %d %q %v, want %d %q", tt.input, code, line, err, tt.code, tt.line)
+		}
+	}
+	for _, bad := range []string{"", "ok\r\n", "abc hello\r\n", "230-never ends\r\n"} {
+		if _, _, err := readFTPReplyLine(bufio.NewReader(strings.NewReader(bad))); err == nil {
+			t.Errorf("readFTPReplyLine(%q) accepted", bad)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package scanner
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// GeoInfo contains ASN and location context for a public IP
+type GeoInfo struct {
+	ASN     uint
+	Org     string
+	Country string
+}
+
+// GeoLookup resolves geo/ASN context for a single IP
+type GeoLookup interface {
+	Lookup(ip net.IP) (GeoInfo, error)
+}
+
+// GeoEnricher attaches geo/ASN context to results for public IPs,
+// caching each lookup so an IP is only resolved once
+type GeoEnricher struct {
+	lookup GeoLookup
+
+	mu    sync.Mutex
+	cache map[string]GeoInfo
+}
+
+// NewGeoEnricher creates a GeoEnricher backed by lookup
+func NewGeoEnricher(lookup GeoLookup) *GeoEnricher {
+	return &GeoEnricher{
+		lookup: lookup,
+		cache:  make(map[string]GeoInfo),
+	}
+}
+
+// Enrich fills fingerprint["asn"], ["as_org"] and ["country"] for public
+// IPs and returns fingerprint, allocated if it was nil and something was
+// found. Private, loopback and link-local addresses are skipped.
+func (e *GeoEnricher) Enrich(ip string, fingerprint map[string]interface{}) map[string]interface{} {
+	parsed := net.ParseIP(ip)
+	if parsed == nil || !isPublicIP(parsed) {
+		return fingerprint
+	}
+
+	geo, ok := e.cached(ip)
+	if !ok {
+		var err error
+		geo, err = e.lookup.Lookup(parsed)
+		if err != nil {
+			log.Printf("Geo lookup failed for %s: %v", ip, err)
+		}
+		// Cache failures too so a bad source isn't queried for every port
+		e.mu.Lock()
+		e.cache[ip] = geo
+		e.mu.Unlock()
+	}
+
+	if geo.ASN == 0 && geo.Country == "" {
+		return fingerprint
+	}
+
+	if fingerprint == nil {
+		fingerprint = make(map[string]interface{})
+	}
+	if geo.ASN != 0 {
+		fingerprint["asn"] = geo.ASN
+	}
+	if geo.Org != "" {
+		fingerprint["as_org"] = geo.Org
+	}
+	if geo.Country != "" {
+		fingerprint["country"] = geo.Country
+	}
+	return fingerprint
+}
+
+func (e *GeoEnricher) cached(ip string) (GeoInfo, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	geo, ok := e.cache[ip]
+	return geo, ok
+}
+
+// sharedAddressSpace is the RFC 6598 carrier-grade NAT range, which
+// IsPrivate does not cover
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// isPublicIP reports whether ip is globally routable: not private (RFC 1918
+// or ULA), shared address space, loopback, link-local or multicast
+func isPublicIP(ip net.IP) bool {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return false
+	}
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !addr.IsLoopback() &&
+		!addr.IsLinkLocalUnicast() && !sharedAddressSpace.Contains(addr)
+}
+
+// MaxMindLookup reads ASN and country data from an offline MaxMind DB.
+// Both GeoLite2-ASN and GeoLite2-Country/City databases are supported;
+// fields missing from the database are left empty.
+type MaxMindLookup struct {
+	reader *maxminddb.Reader
+}
+
+// NewMaxMindLookup opens the MaxMind database at path
+func NewMaxMindLookup(path string) (*MaxMindLookup, error) {
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open GeoIP database %s: %w", path, err)
+	}
+	return &MaxMindLookup{reader: reader}, nil
+}
+
+// Lookup returns the ASN and country recorded for ip
+func (m *MaxMindLookup) Lookup(ip net.IP) (GeoInfo, error) {
+	var record struct {
+		ASN     uint   `maxminddb:"autonomous_system_number"`
+		Org     string `maxminddb:"autonomous_system_organization"`
+		Country struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+	}
+	if err := m.reader.Lookup(ip, &record); err != nil {
+		return GeoInfo{}, err
+	}
+	return GeoInfo{
+		ASN:     record.ASN,
+		Org:     record.Org,
+		Country: record.Country.ISOCode,
+	}, nil
+}
+
+// Close releases the database
+func (m *MaxMindLookup) Close() error {
+	return m.reader.Close()
+}
+
+// WhoisLookup queries an IP-to-ASN WHOIS service using the Team Cymru
+// verbose format: "AS | IP | BGP Prefix | CC | Registry | Allocated | AS Name"
+type WhoisLookup struct {
+	Server  string // host:port, e.g. "whois.cymru.com:43"
+	Timeout time.Duration
+}
+
+// NewWhoisLookup creates a WhoisLookup against server
+func NewWhoisLookup(server string, timeoutSecs int) *WhoisLookup {
+	if server == "" {
+		server = "whois.cymru.com:43"
+	}
+	if timeoutSecs <= 0 {
+		timeoutSecs = 5
+	}
+	return &WhoisLookup{
+		Server:  server,
+		Timeout: time.Duration(timeoutSecs) * time.Second,
+	}
+}
+
+// Lookup queries the WHOIS server for the origin ASN of ip
+func (w *WhoisLookup) Lookup(ip net.IP) (GeoInfo, error) {
+	conn, err := net.DialTimeout("tcp", w.Server, w.Timeout)
+	if err != nil {
+		return GeoInfo{}, fmt.Errorf("whois connect failed: %w", err)
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(w.Timeout))
+
+	if _, err := fmt.Fprintf(conn, " -v %s\r\n", ip); err != nil {
+		return GeoInfo{}, fmt.Errorf("whois query failed: %w", err)
+	}
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		if geo, ok := parseCymruLine(scanner.Text()); ok {
+			return geo, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return GeoInfo{}, fmt.Errorf("whois read failed: %w", err)
+	}
+	return GeoInfo{}, fmt.Errorf("no whois record for %s", ip)
+}
+
+// parseCymruLine parses a data line of the Team Cymru verbose response,
+// skipping the header line and unannounced ("NA") prefixes
+func parseCymruLine(line string) (GeoInfo, bool) {
+	fields := strings.Split(line, "|")
+	if len(fields) < 7 {
+		return GeoInfo{}, false
+	}
+	asn, err := strconv.ParseUint(strings.TrimSpace(fields[0]), 10, 32)
+	if err != nil {
+		return GeoInfo{}, false
+	}
+	return GeoInfo{
+		ASN:     uint(asn),
+		Country: strings.TrimSpace(fields[3]),
+		Org:     strings.TrimSpace(fields[6]),
+	}, true
+}
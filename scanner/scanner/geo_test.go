@@ -0,0 +1,146 @@
+package scanner
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// stubGeoLookup returns fixed records and counts lookups per IP
+type stubGeoLookup struct {
+	mu      sync.Mutex
+	records map[string]GeoInfo
+	calls   map[string]int
+}
+
+func (s *stubGeoLookup) Lookup(ip net.IP) (GeoInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.calls == nil {
+		s.calls = make(map[string]int)
+	}
+	s.calls[ip.String()]++
+	geo, ok := s.records[ip.String()]
+	if !ok {
+		return GeoInfo{}, fmt.Errorf("no record for %s", ip)
+	}
+	return geo, nil
+}
+
+func TestGeoEnricherEnrich(t *testing.T) {
+	lookup := &stubGeoLookup{records: map[string]GeoInfo{
+		"8.8.8.8": {ASN: 15169, Org: "GOOGLE", Country: "US"},
+		"1.1.1.1": {ASN: 13335, Country: "AU"},
+	}}
+	enricher := NewGeoEnricher(lookup)
+
+	fp := enricher.Enrich("8.8.8.8", map[string]interface{}{"banner": "x"})
+	if fp["asn"] != uint(15169) || fp["as_org"] != "GOOGLE" || fp["country"] != "US" || fp["banner"] != "x" {
+		t.Errorf("Enrich(8.8.8.8) = %v", fp)
+	}
+
+	fp = enricher.Enrich("1.1.1.1", nil)
+	if fp["asn"] != uint(13335) || fp["country"] != "AU" {
+		t.Errorf("Enrich(1.1.1.1) = %v", fp)
+	}
+	if _, ok := fp["as_org"]; ok {
+		t.Errorf("Enrich(1.1.1.1) set as_org without an org: %v", fp)
+	}
+
+	// Lookups are cached per IP, failures included
+	enricher.Enrich("8.8.8.8", nil)
+	enricher.Enrich("9.9.9.9", nil)
+	enricher.Enrich("9.9.9.9", nil)
+	if lookup.calls["8.8.8.8"] != 1 || lookup.calls["9.9.9.9"] != 1 {
+		t.Errorf("lookup calls = %v, want one per IP", lookup.calls)
+	}
+}
+
+func TestGeoEnricherSkipsNonPublic(t *testing.T) {
+	lookup := &stubGeoLookup{}
+	enricher := NewGeoEnricher(lookup)
+	for _, ip := range []string{
+		"10.1.2.3", "172.16.0.1", "192.168.1.1", // RFC 1918
+		"100.64.0.1", "100.127.255.254", // RFC 6598 shared address space
+		"127.0.0.1", "::1", // loopback
+		"169.254.1.1", "fe80::1", // link-local
+		"fd00::1",              // ULA
+		"224.0.0.1", "0.0.0.0", // multicast, unspecified
+		"::ffff:10.0.0.1", // IPv4-mapped private
+		"not-an-ip",
+	} {
+		if fp := enricher.Enrich(ip, nil); fp != nil {
+			t.Errorf("Enrich(%s) = %v, want nil", ip, fp)
+		}
+	}
+	if len(lookup.calls) != 0 {
+		t.Errorf("non-public IPs were looked up: %v", lookup.calls)
+	}
+}
+
+func TestIsPublicIP(t *testing.T) {
+	for ip, want := range map[string]bool{
+		"8.8.8.8":              true,
+		"100.63.255.255":       true,
+		"100.128.0.0":          true,
+		"2001:4860:4860::8888": true,
+		"::ffff:8.8.8.8":       true,
+		"100.64.0.0":           false,
+		"10.0.0.1":             false,
+		"fc00::1":              false,
+	} {
+		if got := isPublicIP(net.ParseIP(ip)); got != want {
+			t.Errorf("isPublicIP(%s) = %v, want %v", ip, got, want)
+		}
+	}
+}
+
+func TestParseCymruLine(t *testing.T) {
+	geo, ok := parseCymruLine("15169   | 8.8.8.8          | 8.8.8.0/24          | US | arin     | 2023-12-28 | GOOGLE, US")
+	if !ok || geo != (GeoInfo{ASN: 15169, Country: "US", Org: "GOOGLE, US"}) {
+		t.Errorf("parseCymruLine = %+v, %v", geo, ok)
+	}
+	for _, line := range []string{
+		"AS      | IP               | BGP Prefix          | CC | Registry | Allocated  | AS Name",
+		"NA      | 10.0.0.1         | NA                  |    | other    |            | NA",
+		"",
+	} {
+		if _, ok := parseCymruLine(line); ok {
+			t.Errorf("parseCymruLine(%q) parsed a non-data line", line)
+		}
+	}
+}
+
+func TestWhoisLookup(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	queries := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		query, _ := bufio.NewReader(conn).ReadString('\n')
+		queries <- query
+		fmt.Fprint(conn, "AS      | IP               | BGP Prefix          | CC | Registry | Allocated  | AS Name\n")
+		fmt.Fprint(conn, "13335   | 1.1.1.1          | 1.1.1.0/24          | AU | apnic    | 2011-08-11 | CLOUDFLARENET, US\n")
+	}()
+
+	geo, err := NewWhoisLookup(listener.Addr().String(), 2).Lookup(net.ParseIP("1.1.1.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if geo != (GeoInfo{ASN: 13335, Country: "AU", Org: "CLOUDFLARENET, US"}) {
+		t.Errorf("Lookup = %+v", geo)
+	}
+	if query := <-queries; strings.TrimSpace(query) != "-v 1.1.1.1" {
+		t.Errorf("query = %q, want \" -v 1.1.1.1\"", query)
+	}
+}
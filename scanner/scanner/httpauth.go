@@ -0,0 +1,333 @@
+package scanner
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// authChallenge is one challenge from a WWW-Authenticate header: the
+// scheme, lowercased, and either its parameters or a token68 (the base64
+// blob NTLM and Negotiate send)
+type authChallenge struct {
+	scheme  string
+	params  map[string]string
+	token68 string
+}
+
+// parseChallenges splits WWW-Authenticate values into challenges. One
+// header may carry several ("Negotiate, NTLM"), so values are split at
+// commas outside quotes, and a part not shaped like key=value starts a new
+// challenge.
+func parseChallenges(values []string) []authChallenge {
+	var challenges []authChallenge
+	for _, value := range values {
+		for _, part := range splitQuoted(value) {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			eq := strings.IndexByte(part, '=')
+			sp := strings.IndexAny(part, " \t")
+			if eq < 0 || (sp >= 0 && sp < eq) {
+				// "Scheme", "Scheme token68" or "Scheme key=value"
+				scheme, rest, _ := strings.Cut(part, " ")
+				c := authChallenge{scheme: strings.ToLower(scheme), params: make(map[string]string)}
+				if rest = strings.TrimSpace(rest); rest != "" {
+					if !strings.Contains(strings.TrimRight(rest, "="), "=") {
+						c.token68 = rest
+					} else {
+						addAuthParam(c.params, rest)
+					}
+				}
+				challenges = append(challenges, c)
+				continue
+			}
+			if len(challenges) > 0 {
+				addAuthParam(challenges[len(challenges)-1].params, part)
+			}
+		}
+	}
+	return challenges
+}
+
+// splitQuoted splits s at commas that aren't inside a quoted string
+func splitQuoted(s string) []string {
+	var parts []string
+	quoted, escaped := false, false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case escaped:
+			escaped = false
+		case s[i] == '\\' && quoted:
+			escaped = true
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == ',' && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// addAuthParam records one key=value parameter, unquoting the value
+func addAuthParam(params map[string]string, kv string) {
+	key, value, _ := strings.Cut(kv, "=")
+	value = strings.TrimSpace(value)
+	if unquoted, err := strconv.Unquote(value); err == nil && strings.HasPrefix(value, `"`) {
+		value = unquoted
+	} else {
+		value = strings.Trim(value, `"`)
+	}
+	params[strings.ToLower(strings.TrimSpace(key))] = value
+}
+
+// markHTTPAuth records the authentication a 401 response asks for:
+// Fingerprint["auth_scheme"] (basic, digest, ntlm, negotiate, bearer, ...)
+// from the first challenge, ["auth_schemes"] when several are offered, and
+// ["auth_realm"]. A challenge that already carries an NTLM Type-2 message
+// is decoded into ["ntlm"].
+func markHTTPAuth(info *ServiceInfo, status int, values []string) {
+	if status != http.StatusUnauthorized {
+		return
+	}
+	challenges := parseChallenges(values)
+	if len(challenges) == 0 {
+		return
+	}
+	if info.Fingerprint == nil {
+		info.Fingerprint = make(map[string]interface{})
+	}
+	info.Fingerprint["auth_scheme"] = challenges[0].scheme
+	if len(challenges) > 1 {
+		schemes := make([]string, len(challenges))
+		for i, c := range challenges {
+			schemes[i] = c.scheme
+		}
+		info.Fingerprint["auth_schemes"] = schemes
+	}
+	for _, c := range challenges {
+		if realm := c.params["realm"]; realm != "" {
+			info.Fingerprint["auth_realm"] = realm
+			break
+		}
+	}
+	if ntlm := ntlmFromChallenges(challenges); ntlm != nil {
+		info.Fingerprint["ntlm"] = ntlm
+	}
+}
+
+// ntlmFromChallenges decodes the first NTLM or Negotiate token that is an
+// NTLM Type-2 (challenge) message
+func ntlmFromChallenges(challenges []authChallenge) map[string]interface{} {
+	for _, c := range challenges {
+		if (c.scheme != "ntlm" && c.scheme != "negotiate") || c.token68 == "" {
+			continue
+		}
+		if ntlm, err := parseNTLMChallenge(c.token68); err == nil {
+			return ntlm
+		}
+	}
+	return nil
+}
+
+// offeredNTLMScheme returns the scheme to send an NTLM negotiate message
+// with, NTLM preferred over Negotiate, or "" when neither is offered
+func offeredNTLMScheme(info *ServiceInfo) string {
+	var schemes []string
+	switch s := info.Fingerprint["auth_schemes"].(type) {
+	case []string:
+		schemes = s
+	default:
+		if scheme, ok := info.Fingerprint["auth_scheme"].(string); ok {
+			schemes = []string{scheme}
+		}
+	}
+	offered := ""
+	for _, scheme := range schemes {
+		switch scheme {
+		case "ntlm":
+			return "NTLM"
+		case "negotiate":
+			offered = "Negotiate"
+		}
+	}
+	return offered
+}
+
+// ntlmNegotiate is a minimal NTLM Type-1 (negotiate) message: signature,
+// type 1, and flags for Unicode, OEM, request target, NTLM, always sign,
+// extended session security, target info, version, 128-bit and 56-bit
+// keys, with empty domain and workstation fields
+var ntlmNegotiate = base64.StdEncoding.EncodeToString([]byte{
+	'N', 'T', 'L', 'M', 'S', 'S', 'P', 0,
+	1, 0, 0, 0,
+	0x07, 0x82, 0x88, 0xa2,
+	0, 0, 0, 0, 0, 0, 0, 0,
+	0, 0, 0, 0, 0, 0, 0, 0,
+})
+
+// checkNTLMInfo answers an NTLM or Negotiate challenge with a Type-1
+// message when NTLMInfo is set; the server's Type-2 reply names its
+// domain and host, recorded in Fingerprint["ntlm"]. No credentials are
+// sent and the handshake is never completed.
+func (f *Fingerprinter) checkNTLMInfo(ip string, port int, info *ServiceInfo) {
+	if !f.NTLMInfo || (info.ServiceName != "http" && info.ServiceName != "https" && info.ServiceName != "http-proxy") {
+		return
+	}
+	if info.Fingerprint["ntlm"] != nil {
+		return
+	}
+	scheme := offeredNTLMScheme(info)
+	if scheme == "" {
+		return
+	}
+	ntlm, err := f.ntlmRequest(ip, port, info.ServiceName == "https", scheme)
+	if err != nil {
+		return
+	}
+	info.Fingerprint["ntlm"] = ntlm
+}
+
+// ntlmRequest sends GET / with a Type-1 message and decodes the Type-2
+// message in the 401 that comes back
+func (f *Fingerprinter) ntlmRequest(ip string, port int, useTLS bool, scheme string) (map[string]interface{}, error) {
+	conn, err := f.dial(net.JoinHostPort(ip, strconv.Itoa(port)))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(f.Timeout))
+
+	if useTLS {
+		tlsConn := tls.Client(conn, &tls.Config{InsecureSkipVerify: true})
+		if err := tlsConn.Handshake(); err != nil {
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	request := fmt.Sprintf("GET / HTTP/1.1\r\nHost: %s\r\nUser-Agent: NetworkScanner/1.0\r\nAuthorization: %s %s\r\nConnection: close\r\n\r\n", ip, scheme, ntlmNegotiate)
+	if _, err := conn.Write([]byte(request)); err != nil {
+		return nil, err
+	}
+
+	// Only the headers matter
+	_, resp := readHTTPResponse(bufio.NewReader(conn), f.BodyBudget)
+	if resp == nil {
+		return nil, fmt.Errorf("no HTTP response")
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	if ntlm := ntlmFromChallenges(parseChallenges(resp.Header.Values("WWW-Authenticate"))); ntlm != nil {
+		return ntlm, nil
+	}
+	return nil, fmt.Errorf("no NTLM challenge")
+}
+
+// NTLM Type-2 layout: flags that matter here, and the AV pair IDs in the
+// target info block that name the server
+const (
+	ntlmNegotiateUnicode = 0x00000001
+	ntlmNegotiateVersion = 0x02000000
+
+	ntlmAvEOL             = 0
+	ntlmAvNbComputerName  = 1
+	ntlmAvNbDomainName    = 2
+	ntlmAvDNSComputerName = 3
+	ntlmAvDNSDomainName   = 4
+	ntlmAvDNSTreeName     = 5
+)
+
+var ntlmAvNames = map[uint16]string{
+	ntlmAvNbComputerName:  "netbios_computer",
+	ntlmAvNbDomainName:    "netbios_domain",
+	ntlmAvDNSComputerName: "dns_computer",
+	ntlmAvDNSDomainName:   "dns_domain",
+	ntlmAvDNSTreeName:     "dns_tree",
+}
+
+// parseNTLMChallenge decodes a base64 NTLM Type-2 message into its target
+// name, the NetBIOS and DNS names from its target info, and the Windows
+// version when the server sends one
+func parseNTLMChallenge(token string) (map[string]interface{}, error) {
+	msg, err := base64.StdEncoding.DecodeString(token)
+	if err != nil {
+		return nil, err
+	}
+	if len(msg) < 32 || string(msg[:8]) != "NTLMSSP\x00" {
+		return nil, fmt.Errorf("not an NTLMSSP message")
+	}
+	if binary.LittleEndian.Uint32(msg[8:12]) != 2 {
+		return nil, fmt.Errorf("not an NTLM challenge message")
+	}
+	flags := binary.LittleEndian.Uint32(msg[20:24])
+	unicode := flags&ntlmNegotiateUnicode != 0
+
+	ntlm := make(map[string]interface{})
+	if name, ok := ntlmField(msg, 12); ok && len(name) > 0 {
+		if unicode {
+			ntlm["target_name"] = decodeUTF16LE(name)
+		} else {
+			ntlm["target_name"] = string(name)
+		}
+	}
+	if len(msg) >= 48 {
+		if targetInfo, ok := ntlmField(msg, 40); ok {
+			for len(targetInfo) >= 4 {
+				id := binary.LittleEndian.Uint16(targetInfo[0:2])
+				n := int(binary.LittleEndian.Uint16(targetInfo[2:4]))
+				if id == ntlmAvEOL || 4+n > len(targetInfo) {
+					break
+				}
+				if key, ok := ntlmAvNames[id]; ok && n > 0 {
+					ntlm[key] = decodeUTF16LE(targetInfo[4 : 4+n])
+				}
+				targetInfo = targetInfo[4+n:]
+			}
+		}
+	}
+	if flags&ntlmNegotiateVersion != 0 && len(msg) >= 56 {
+		// Major, minor, build; the rest is the NTLM revision
+		ntlm["os_version"] = fmt.Sprintf("%d.%d.%d", msg[48], msg[49], binary.LittleEndian.Uint16(msg[50:52]))
+	}
+	if len(ntlm) == 0 {
+		return nil, fmt.Errorf("empty NTLM challenge")
+	}
+	return ntlm, nil
+}
+
+// ntlmField returns the bytes an NTLM security buffer at offset points to:
+// a 2-byte length, a 2-byte maximum length and a 4-byte offset
+func ntlmField(msg []byte, offset int) ([]byte, bool) {
+	if offset+8 > len(msg) {
+		return nil, false
+	}
+	n := int(binary.LittleEndian.Uint16(msg[offset : offset+2]))
+	start := int(binary.LittleEndian.Uint32(msg[offset+4 : offset+8]))
+	if start < 0 || start > len(msg) || n > len(msg)-start {
+		return nil, false
+	}
+	return msg[start : start+n], true
+}
+
+// decodeUTF16LE decodes the little-endian UTF-16 strings NTLM uses
+func decodeUTF16LE(b []byte) string {
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		units[i] = binary.LittleEndian.Uint16(b[2*i:])
+	}
+	return string(utf16.Decode(units))
+}
@@ -0,0 +1,287 @@
+package scanner
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"unicode/utf16"
+)
+
+// utf16LE encodes s the way NTLM sends strings
+func utf16LE(s string) []byte {
+	var b []byte
+	for _, unit := range utf16.Encode([]rune(s)) {
+		b = binary.LittleEndian.AppendUint16(b, unit)
+	}
+	return b
+}
+
+// ntlmType2 builds a base64 NTLM challenge message from a domain
+// controller the way Windows sends one: a Unicode target name, target info
+// naming the domain and host, and the OS version
+func ntlmType2(target string, av map[uint16]string) string {
+	var info []byte
+	for _, id := range []uint16{ntlmAvNbDomainName, ntlmAvNbComputerName, ntlmAvDNSDomainName, ntlmAvDNSComputerName, ntlmAvDNSTreeName} {
+		if value, ok := av[id]; ok {
+			info = binary.LittleEndian.AppendUint16(info, id)
+			info = binary.LittleEndian.AppendUint16(info, uint16(2*len(value)))
+			info = append(info, utf16LE(value)...)
+		}
+	}
+	info = append(info, 0, 0, 0, 0) // MsvAvEOL
+
+	name := utf16LE(target)
+	msg := make([]byte, 56)
+	copy(msg, "NTLMSSP\x00")
+	binary.LittleEndian.PutUint32(msg[8:], 2)
+	binary.LittleEndian.PutUint16(msg[12:], uint16(len(name)))
+	binary.LittleEndian.PutUint16(msg[14:], uint16(len(name)))
+	binary.LittleEndian.PutUint32(msg[16:], 56)
+	binary.LittleEndian.PutUint32(msg[20:], ntlmNegotiateUnicode|ntlmNegotiateVersion|0x00800000)
+	copy(msg[24:32], "\x01\x23\x45\x67\x89\xab\xcd\xef") // server challenge
+	binary.LittleEndian.PutUint16(msg[40:], uint16(len(info)))
+	binary.LittleEndian.PutUint16(msg[42:], uint16(len(info)))
+	binary.LittleEndian.PutUint32(msg[44:], uint32(56+len(name)))
+	msg[48], msg[49] = 10, 0 // Windows 10.0 build 20348
+	binary.LittleEndian.PutUint16(msg[50:], 20348)
+	msg[55] = 15 // NTLM revision
+	msg = append(append(msg, name...), info...)
+	return base64.StdEncoding.EncodeToString(msg)
+}
+
+// corpNTLM is the challenge from a DC for CORP / corp.example.com
+var corpNTLM = ntlmType2("CORP", map[uint16]string{
+	ntlmAvNbDomainName:    "CORP",
+	ntlmAvNbComputerName:  "DC01",
+	ntlmAvDNSDomainName:   "corp.example.com",
+	ntlmAvDNSComputerName: "dc01.corp.example.com",
+	ntlmAvDNSTreeName:     "corp.example.com",
+})
+
+var corpNTLMInfo = map[string]interface{}{
+	"target_name":      "CORP",
+	"netbios_domain":   "CORP",
+	"netbios_computer": "DC01",
+	"dns_domain":       "corp.example.com",
+	"dns_computer":     "dc01.corp.example.com",
+	"dns_tree":         "corp.example.com",
+	"os_version":       "10.0.20348",
+}
+
+// unauthorized is a 401 response carrying challenges, one header each
+func unauthorized(challenges ...string) string {
+	var b strings.Builder
+	b.WriteString("HTTP/1.1 401 Unauthorized\r\nServer: Microsoft-IIS/10.0\r\n")
+	for _, c := range challenges {
+		b.WriteString("WWW-Authenticate: " + c + "\r\n")
+	}
+	b.WriteString("Content-Length: 0\r\nConnection: close\r\n\r\n")
+	return b.String()
+}
+
+func TestProbeHTTPAuthSchemes(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		scheme  string
+		schemes []string
+		realm   string
+		ntlm    map[string]interface{}
+	}{
+		{name: "basic", raw: unauthorized(`Basic realm="Router Admin"`), scheme: "basic", realm: "Router Admin"},
+		{name: "basic with charset", raw: unauthorized(`Basic realm="Intranet, Berlin", charset="UTF-8"`), scheme: "basic", realm: "Intranet, Berlin"},
+		{
+			name:   "digest",
+			raw:    unauthorized(`Digest realm="DVR", qop="auth,auth-int", nonce="dcd98b7102dd2f0e8b11d0f600bfb0c093", opaque="5ccc069c403ebaf9f0171e9517f40e41", algorithm=MD5`),
+			scheme: "digest", realm: "DVR",
+		},
+		{name: "ntlm", raw: unauthorized("NTLM"), scheme: "ntlm"},
+		{
+			name:   "negotiate and ntlm",
+			raw:    unauthorized("Negotiate", "NTLM"),
+			scheme: "negotiate", schemes: []string{"negotiate", "ntlm"},
+		},
+		{
+			// A challenge sent straight away is decoded without NTLMInfo
+			name:   "ntlm challenge",
+			raw:    unauthorized("NTLM " + corpNTLM),
+			scheme: "ntlm", ntlm: corpNTLMInfo,
+		},
+		{
+			name:   "bearer",
+			raw:    unauthorized(`Bearer realm="api", error="invalid_token", error_description="The access token expired"`),
+			scheme: "bearer", realm: "api",
+		},
+		{
+			// Several challenges in one header
+			name:   "digest or basic",
+			raw:    unauthorized(`Digest realm="cams", nonce="abc", Basic realm="cams-legacy"`),
+			scheme: "digest", schemes: []string{"digest", "basic"}, realm: "cams",
+		},
+		{name: "challenge on a 200", raw: strings.Replace(unauthorized(`Basic realm="x"`), "401 Unauthorized", "200 OK", 1)},
+		{name: "403", raw: strings.Replace(unauthorized(`Basic realm="x"`), "401 Unauthorized", "403 Forbidden", 1)},
+		{name: "401 without a challenge", raw: unauthorized()},
+	}
+	for _, tt := range tests {
+		ip, port := cannedServer(t, tt.raw)
+		info := testFingerprinter().probeHTTP(ip, port, false)
+		if got, _ := info.Fingerprint["auth_scheme"].(string); got != tt.scheme {
+			t.Errorf("%s: auth_scheme = %q, want %q", tt.name, got, tt.scheme)
+		}
+		if got, _ := info.Fingerprint["auth_schemes"].([]string); !reflect.DeepEqual(got, tt.schemes) {
+			t.Errorf("%s: auth_schemes = %v, want %v", tt.name, got, tt.schemes)
+		}
+		if got, _ := info.Fingerprint["auth_realm"].(string); got != tt.realm {
+			t.Errorf("%s: auth_realm = %q, want %q", tt.name, got, tt.realm)
+		}
+		if got, _ := info.Fingerprint["ntlm"].(map[string]interface{}); !reflect.DeepEqual(got, tt.ntlm) {
+			t.Errorf("%s: ntlm = %v, want %v", tt.name, got, tt.ntlm)
+		}
+	}
+}
+
+func TestZgrabHTTPAuth(t *testing.T) {
+	z := &ZgrabFingerprinter{}
+	for challenge, want := range map[string][2]string{
+		`Basic realm="Router Admin"`:    {"basic", "Router Admin"},
+		`Digest realm="DVR", nonce="a"`: {"digest", "DVR"},
+		"NTLM":                          {"ntlm", ""},
+		`Bearer realm="api"`:            {"bearer", "api"},
+	} {
+		headers, _ := json.Marshal(map[string]string{"server": "nginx", "www_authenticate": challenge})
+		result := &ZgrabResult{Data: map[string]*ZgrabModule{"http": {
+			Status: "success",
+			Result: json.RawMessage(`{"response":{"status_code":401,"status_line":"401 Unauthorized","headers":` + string(headers) + `}}`),
+		}}}
+		info := z.parseZgrabResult(result, "http", 80)
+		scheme, _ := info.Fingerprint["auth_scheme"].(string)
+		realm, _ := info.Fingerprint["auth_realm"].(string)
+		if [2]string{scheme, realm} != want {
+			t.Errorf("%s: scheme %q realm %q, want %q", challenge, scheme, realm, want)
+		}
+	}
+}
+
+// ntlmServer is an IIS site with Windows authentication: it challenges
+// every request with schemes, and answers a Type-1 negotiate message with
+// corpNTLM. It counts the negotiate messages it receives.
+func ntlmServer(t *testing.T, schemes ...string) (string, int, *atomic.Int32) {
+	t.Helper()
+	var negotiations atomic.Int32
+	ip, port := stubServer(t, func(conn net.Conn) {
+		request := textproto.NewReader(bufio.NewReader(conn))
+		if _, err := request.ReadLine(); err != nil {
+			return
+		}
+		header, err := request.ReadMIMEHeader()
+		if err != nil {
+			return
+		}
+		scheme, token, _ := strings.Cut(header.Get("Authorization"), " ")
+		if msg, err := base64.StdEncoding.DecodeString(token); err == nil && len(msg) >= 12 &&
+			string(msg[:8]) == "NTLMSSP\x00" && binary.LittleEndian.Uint32(msg[8:]) == 1 {
+			negotiations.Add(1)
+			io.WriteString(conn, unauthorized(scheme+" "+corpNTLM))
+			return
+		}
+		io.WriteString(conn, unauthorized(schemes...))
+	})
+	return ip, port, &negotiations
+}
+
+func TestCheckNTLMInfo(t *testing.T) {
+	for _, schemes := range [][]string{{"Negotiate", "NTLM"}, {"Negotiate"}} {
+		ip, port, negotiations := ntlmServer(t, schemes...)
+		f := testFingerprinter()
+		f.ProbeSequence = map[int][]string{port: {"http"}}
+		info := f.FingerprintHost(context.Background(), ip, []int{port})[port]
+		if info.Fingerprint["ntlm"] != nil || negotiations.Load() != 0 {
+			t.Errorf("%v without ntlm_info: ntlm %v after %d negotiations, want none", schemes, info.Fingerprint["ntlm"], negotiations.Load())
+		}
+
+		f.NTLMInfo = true
+		info = f.FingerprintHost(context.Background(), ip, []int{port})[port]
+		if got := info.Fingerprint["ntlm"]; !reflect.DeepEqual(got, corpNTLMInfo) {
+			t.Errorf("%v: ntlm = %v, want %v", schemes, got, corpNTLMInfo)
+		}
+		if n := negotiations.Load(); n != 1 {
+			t.Errorf("%v: %d negotiate messages, want 1", schemes, n)
+		}
+	}
+
+	// Basic-only sites get no negotiate message
+	ip, port, negotiations := ntlmServer(t, `Basic realm="intranet"`)
+	f := testFingerprinter()
+	f.NTLMInfo = true
+	f.ProbeSequence = map[int][]string{port: {"http"}}
+	info := f.FingerprintHost(context.Background(), ip, []int{port})[port]
+	if info.Fingerprint["auth_scheme"] != "basic" || info.Fingerprint["ntlm"] != nil || negotiations.Load() != 0 {
+		t.Errorf("basic site: %v after %d negotiations", info.Fingerprint, negotiations.Load())
+	}
+}
+
+func TestParseNTLMChallenge(t *testing.T) {
+	ntlm, err := parseNTLMChallenge(corpNTLM)
+	if err != nil || !reflect.DeepEqual(ntlm, corpNTLMInfo) {
+		t.Errorf("parseNTLMChallenge = %v, %v, want %v", ntlm, err, corpNTLMInfo)
+	}
+
+	// An OEM target name, no target info and no version
+	oem := make([]byte, 32)
+	copy(oem, "NTLMSSP\x00")
+	binary.LittleEndian.PutUint32(oem[8:], 2)
+	binary.LittleEndian.PutUint16(oem[12:], 4)
+	binary.LittleEndian.PutUint32(oem[16:], 32)
+	oem = append(oem, "WORK"...)
+	if ntlm, err := parseNTLMChallenge(base64.StdEncoding.EncodeToString(oem)); err != nil || !reflect.DeepEqual(ntlm, map[string]interface{}{"target_name": "WORK"}) {
+		t.Errorf("OEM challenge = %v, %v", ntlm, err)
+	}
+
+	truncated, _ := base64.StdEncoding.DecodeString(corpNTLM)
+	// Security buffers pointing past the end
+	overrun := append([]byte(nil), truncated...)
+	binary.LittleEndian.PutUint32(overrun[16:], 0xfffffff0)
+	binary.LittleEndian.PutUint32(overrun[44:], uint32(len(overrun)-2))
+	binary.LittleEndian.PutUint16(overrun[40:], 100)
+	for name, token := range map[string]string{
+		"not base64":   "%%%",
+		"not NTLMSSP":  base64.StdEncoding.EncodeToString([]byte("KERBEROS-TICKET-BLOB-0123456789ab")),
+		"type 1":       ntlmNegotiate,
+		"short":        base64.StdEncoding.EncodeToString(truncated[:20]),
+		"overrun only": base64.StdEncoding.EncodeToString(overrun[:48]),
+	} {
+		if ntlm, err := parseNTLMChallenge(token); err == nil {
+			t.Errorf("%s: parsed as %v", name, ntlm)
+		}
+	}
+	// Whatever survives of a damaged challenge, never a panic
+	parseNTLMChallenge(base64.StdEncoding.EncodeToString(overrun))
+	for n := 0; n < len(truncated); n++ {
+		parseNTLMChallenge(base64.StdEncoding.EncodeToString(truncated[:n]))
+	}
+}
+
+func TestParseChallenges(t *testing.T) {
+	got := parseChallenges([]string{`Newauth realm="apps", type=1, title="Login to \"apps\"", Basic realm="simple"`, "NTLM TlRMTVNTUAABAAAAB4IIogAAAAAAAAAAAAAAAAAAAAA="})
+	want := []authChallenge{
+		{scheme: "newauth", params: map[string]string{"realm": "apps", "type": "1", "title": `Login to "apps"`}},
+		{scheme: "basic", params: map[string]string{"realm": "simple"}},
+		{scheme: "ntlm", params: map[string]string{}, token68: "TlRMTVNTUAABAAAAB4IIogAAAAAAAAAAAAAAAAAAAAA="},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseChallenges = %+v, want %+v", got, want)
+	}
+	if got := fmt.Sprint(parseChallenges([]string{"", " , "})); got != "[]" {
+		t.Errorf("empty headers: %s", got)
+	}
+}
@@ -0,0 +1,93 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// maxHTTPBody caps how much decoded body is read when looking for a title.
+// Pages with large inline headers push <title> well past MaxBanner.
+const maxHTTPBody = 64 << 10
+
+// cappedBuffer keeps the first max bytes written to it and discards the
+// rest without error, so it can sit behind an io.TeeReader.
+type cappedBuffer struct {
+	bytes.Buffer
+	max int
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.Len(); room > 0 {
+		if len(p) > room {
+			b.Buffer.Write(p[:room])
+		} else {
+			b.Buffer.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+// budgetReader reads at most left bytes from r, reserving each read's
+// bytes from budget as it goes so a slow body holds only what it has read.
+// It ends early once the budget is spent.
+type budgetReader struct {
+	r      io.Reader
+	budget *BodyBudget
+	left   int
+	short  bool // the budget ran out with body still unread
+}
+
+func (b *budgetReader) Read(p []byte) (int, error) {
+	if b.left == 0 {
+		return 0, io.EOF
+	}
+	want := min(len(p), b.left)
+	grant := b.budget.take(want)
+	if grant == 0 {
+		// Only a body with more to read was cut short; one that ended just
+		// as the budget did was read whole
+		var next [1]byte
+		if _, err := io.ReadFull(b.r, next[:]); err == nil {
+			b.short = true
+		}
+		return 0, io.EOF
+	}
+	n, err := b.r.Read(p[:grant])
+	b.budget.refund(grant - n)
+	b.left -= n
+	return n, err
+}
+
+// readHTTPResponse parses an HTTP/1.x response and returns its decoded body
+// (chunked transfer-encoding removed, gzip content-encoding inflated) up to
+// maxHTTPBody bytes, or less as budget allows; a spent budget returns the
+// response without its body. The response is nil when r isn't HTTP.
+func readHTTPResponse(r *bufio.Reader, budget *BodyBudget) (string, *http.Response) {
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		return "", nil
+	}
+	defer resp.Body.Close()
+
+	body := io.Reader(resp.Body)
+	if strings.EqualFold(strings.TrimSpace(resp.Header.Get("Content-Encoding")), "gzip") {
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return "", resp
+		}
+		defer gz.Close()
+		body = gz
+	}
+
+	// A truncated or corrupt stream still yields whatever decoded cleanly
+	limited := &budgetReader{r: body, budget: budget, left: maxHTTPBody}
+	data, _ := io.ReadAll(limited)
+	if limited.short {
+		budget.skip()
+	}
+	return string(data), resp
+}
@@ -0,0 +1,254 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const cannedPage = "<!DOCTYPE html><html><head><meta charset=\"utf-8\"><title>  Router Login  </title></head><body>Welcome</body></html>"
+
+// gzipped compresses s
+func gzipped(s string) string {
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	w.Write([]byte(s))
+	w.Close()
+	return b.String()
+}
+
+// chunked encodes s as a chunked body in chunks of size n
+func chunked(s string, n int) string {
+	var b strings.Builder
+	for len(s) > 0 {
+		chunk := s[:min(n, len(s))]
+		s = s[len(chunk):]
+		fmt.Fprintf(&b, "%x\r\n%s\r\n", len(chunk), chunk)
+	}
+	b.WriteString("0\r\n\r\n")
+	return b.String()
+}
+
+// cannedResponses are the same page under each transfer and content
+// encoding
+var cannedResponses = map[string]string{
+	"plain": "HTTP/1.1 200 OK\r\nServer: nginx/1.24.0\r\nContent-Type: text/html\r\nContent-Length: " +
+		fmt.Sprint(len(cannedPage)) + "\r\n\r\n" + cannedPage,
+	"chunked": "HTTP/1.1 200 OK\r\nServer: nginx/1.24.0\r\nContent-Type: text/html\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		chunked(cannedPage, 17),
+	"gzip": "HTTP/1.1 200 OK\r\nServer: nginx/1.24.0\r\nContent-Type: text/html\r\nContent-Encoding: gzip\r\nContent-Length: " +
+		fmt.Sprint(len(gzipped(cannedPage))) + "\r\n\r\n" + gzipped(cannedPage),
+	"chunked gzip": "HTTP/1.1 200 OK\r\nServer: nginx/1.24.0\r\nContent-Type: text/html\r\nTransfer-Encoding: chunked\r\nContent-Encoding: GZIP\r\n\r\n" +
+		chunked(gzipped(cannedPage), 9),
+	"until close": "HTTP/1.0 200 OK\r\nServer: nginx/1.24.0\r\nContent-Type: text/html\r\n\r\n" + cannedPage,
+}
+
+func TestReadHTTPResponseDecodes(t *testing.T) {
+	for name, raw := range cannedResponses {
+		body, resp := readHTTPResponse(bufio.NewReader(strings.NewReader(raw)), nil)
+		if resp == nil {
+			t.Errorf("%s: not parsed as HTTP", name)
+			continue
+		}
+		if body != cannedPage {
+			t.Errorf("%s: body = %q, want the decoded page", name, body)
+		}
+		if title := extractTitle(body); title != "Router Login" {
+			t.Errorf("%s: title = %q", name, title)
+		}
+	}
+}
+
+func TestReadHTTPResponseCapsBody(t *testing.T) {
+	page := "<html><head><title>Big</title></head><body>" + strings.Repeat("x", 2*maxHTTPBody) + "</body></html>"
+	raw := "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nContent-Encoding: gzip\r\n\r\n" + chunked(gzipped(page), 4096)
+	body, resp := readHTTPResponse(bufio.NewReader(strings.NewReader(raw)), nil)
+	if resp == nil || len(body) != maxHTTPBody || extractTitle(body) != "Big" {
+		t.Errorf("decoded %d bytes, title %q; want %d bytes with the title", len(body), extractTitle(body), maxHTTPBody)
+	}
+}
+
+func TestReadHTTPResponseDamagedBodies(t *testing.T) {
+	z := gzipped(cannedPage)
+	for name, raw := range map[string]string{
+		// Everything that decodes before the cut is kept
+		"truncated gzip":    "HTTP/1.1 200 OK\r\nContent-Encoding: gzip\r\n\r\n" + z[:len(z)-12],
+		"truncated chunked": "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n" + chunked(cannedPage, 40)[:90],
+	} {
+		body, resp := readHTTPResponse(bufio.NewReader(strings.NewReader(raw)), nil)
+		if resp == nil || !strings.HasPrefix(cannedPage, body) || body == "" {
+			t.Errorf("%s: body = %q, want a prefix of the page", name, body)
+		}
+	}
+
+	// A gzip header that isn't gzip leaves no body, but the response stands
+	body, resp := readHTTPResponse(bufio.NewReader(strings.NewReader("HTTP/1.1 200 OK\r\nContent-Encoding: gzip\r\n\r\nnot gzip")), nil)
+	if resp == nil || body != "" {
+		t.Errorf("bad gzip: body %q, response %v", body, resp)
+	}
+	if _, resp := readHTTPResponse(bufio.NewReader(strings.NewReader("SSH-2.0-OpenSSH_9.6\r\n")), nil); resp != nil {
+		t.Error("non-HTTP input parsed as a response")
+	}
+}
+
+func TestProbeHTTPEncodedTitles(t *testing.T) {
+	for name, raw := range cannedResponses {
+		ip, port := stubServer(t, func(conn net.Conn) {
+			request := bufio.NewReader(conn)
+			for {
+				line, err := request.ReadString('\n')
+				if err != nil || line == "\r\n" {
+					break
+				}
+			}
+			io.WriteString(conn, raw)
+		})
+		info := testFingerprinter().probeHTTP(ip, port, false)
+		if info.Fingerprint["title"] != "Router Login" {
+			t.Errorf("%s: title = %v, want Router Login", name, info.Fingerprint["title"])
+		}
+		if info.ServiceVersion != "nginx/1.24.0" || info.Fingerprint["status_code"] != "200" {
+			t.Errorf("%s: version %q, status %v", name, info.ServiceVersion, info.Fingerprint["status_code"])
+		}
+		// The banner is the raw response, still capped at MaxBanner
+		if len(info.Banner) > testFingerprinter().MaxBanner || !strings.HasPrefix(info.Banner, "HTTP/1.") {
+			t.Errorf("%s: banner = %q", name, info.Banner)
+		}
+	}
+}
+
+// cannedServer serves raw to every request
+func cannedServer(t *testing.T, raw string) (string, int) {
+	t.Helper()
+	return stubServer(t, func(conn net.Conn) {
+		request := bufio.NewReader(conn)
+		for {
+			line, err := request.ReadString('\n')
+			if err != nil || line == "\r\n" {
+				break
+			}
+		}
+		io.WriteString(conn, raw)
+	})
+}
+
+func TestReadHTTPResponseBudget(t *testing.T) {
+	raw := cannedResponses["plain"]
+	// Room for one page and the start of another
+	budget := NewBodyBudget(int64(len(cannedPage) + 10))
+
+	var bodies []string
+	for i := 0; i < 3; i++ {
+		body, resp := readHTTPResponse(bufio.NewReader(strings.NewReader(raw)), budget)
+		if resp == nil || resp.Header.Get("Server") != "nginx/1.24.0" {
+			t.Fatalf("response %d: headers lost once the budget ran out: %v", i, resp)
+		}
+		bodies = append(bodies, body)
+	}
+	if bodies[0] != cannedPage || bodies[1] != cannedPage[:10] || bodies[2] != "" {
+		t.Errorf("bodies = %q, want the page, its first 10 bytes, then nothing", bodies)
+	}
+	if budget.Used() != budget.Limit() || budget.Skipped() != 2 {
+		t.Errorf("used %d of %d with %d skipped, want all used and 2 skipped", budget.Used(), budget.Limit(), budget.Skipped())
+	}
+
+	// The next scan starts with the whole budget
+	budget.Reset()
+	if body, _ := readHTTPResponse(bufio.NewReader(strings.NewReader(raw)), budget); body != cannedPage || budget.Skipped() != 0 {
+		t.Errorf("after Reset: body %q, %d skipped", body, budget.Skipped())
+	}
+}
+
+func TestReadHTTPResponseBudgetReservedAsRead(t *testing.T) {
+	const length = 1000
+	budget := NewBodyBudget(1 << 20)
+	server, client := net.Pipe()
+	defer client.Close()
+	done := make(chan string)
+	go func() {
+		body, _ := readHTTPResponse(bufio.NewReader(client), budget)
+		done <- body
+	}()
+
+	// Partway through a slow body what has arrived is reserved, and at
+	// most the pending read's buffer besides; not the Content-Length or the
+	// body cap
+	fmt.Fprintf(server, "HTTP/1.1 200 OK\r\nContent-Length: %d\r\n\r\n", length)
+	server.Write(bytes.Repeat([]byte("a"), 100))
+	if !eventually(func() bool { return budget.Used() >= 100 }) || budget.Used() >= length {
+		t.Errorf("reserved %d bytes after 100 of %d arrived", budget.Used(), length)
+	}
+	server.Write(bytes.Repeat([]byte("b"), length-100))
+	server.Close()
+	if body := <-done; len(body) != length || budget.Used() != length {
+		t.Errorf("read %d bytes with %d reserved, want %d of each", len(body), budget.Used(), length)
+	}
+
+	// A body that ends early gives back what it didn't read
+	short := "HTTP/1.1 200 OK\r\nContent-Length: 500\r\n\r\n" + strings.Repeat("c", 40)
+	readHTTPResponse(bufio.NewReader(strings.NewReader(short)), budget)
+	if budget.Used() != length+40 {
+		t.Errorf("reserved %d bytes after a 40-byte truncated body, want %d", budget.Used(), length+40)
+	}
+}
+
+func TestBodyBudgetConcurrentProbes(t *testing.T) {
+	raw := cannedResponses["chunked gzip"]
+	budget := NewBodyBudget(int64(5*len(cannedPage) + len(cannedPage)/2))
+	var (
+		mu    sync.Mutex
+		total int
+		full  int
+		wg    sync.WaitGroup
+	)
+	for i := 0; i < 40; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			body, _ := readHTTPResponse(bufio.NewReader(strings.NewReader(raw)), budget)
+			mu.Lock()
+			defer mu.Unlock()
+			total += len(body)
+			if body == cannedPage {
+				full++
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Exactly the budget was handed out, and never more
+	if int64(total) != budget.Limit() || budget.Used() != budget.Limit() {
+		t.Errorf("read %d body bytes, %d reserved, want the limit %d", total, budget.Used(), budget.Limit())
+	}
+	// Every body is either whole or counted as skipped
+	if full > 5 || int64(full)+budget.Skipped() != 40 {
+		t.Errorf("%d full bodies, %d skipped, want at most 5 full and the rest skipped", full, budget.Skipped())
+	}
+}
+
+func TestProbeHTTPBudgetDropsLaterBodies(t *testing.T) {
+	ip, port := cannedServer(t, cannedResponses["plain"])
+	f := testFingerprinter()
+	f.BodyBudget = NewBodyBudget(int64(2 * len(cannedPage)))
+
+	for i := 0; i < 4; i++ {
+		info := f.probeHTTP(ip, port, false)
+		wantTitle := i < 2
+		if _, ok := info.Fingerprint["title"]; ok != wantTitle {
+			t.Errorf("probe %d: title recorded %v, want %v", i, ok, wantTitle)
+		}
+		// Headers and the banner are captured whatever the budget
+		if info.ServiceVersion != "nginx/1.24.0" || info.Fingerprint["status_code"] != "200" || !strings.HasPrefix(info.Banner, "HTTP/1.1 200") {
+			t.Errorf("probe %d: version %q, status %v, banner %q", i, info.ServiceVersion, info.Fingerprint["status_code"], info.Banner)
+		}
+	}
+	if f.BodyBudget.Skipped() != 2 {
+		t.Errorf("%d bodies skipped, want 2", f.BodyBudget.Skipped())
+	}
+}
@@ -0,0 +1,239 @@
+package scanner
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// icmpGrace is how long a UDP read that failed early waits for the ICMP
+// error behind it to come through the listener
+const icmpGrace = 100 * time.Millisecond
+
+// UDP port states, as classified from the reply or ICMP error to a probe
+const (
+	UDPOpen         = "open"          // the service replied
+	UDPClosed       = "closed"        // ICMP port unreachable
+	UDPFiltered     = "filtered"      // ICMP unreachable for another reason, e.g. administratively prohibited
+	UDPOpenFiltered = "open|filtered" // nothing came back; a silent service or a dropping firewall
+)
+
+// icmpKey identifies a UDP probe by its destination and local source port,
+// which an ICMP error quotes back in the embedded original datagram
+type icmpKey struct {
+	IP      string
+	DstPort int
+	SrcPort int
+}
+
+// UnreachableError is returned for a UDP probe that drew an ICMP
+// destination unreachable; State is UDPClosed or UDPFiltered
+type UnreachableError struct {
+	State string
+	Code  int // ICMP code
+}
+
+func (e *UnreachableError) Error() string {
+	return fmt.Sprintf("ICMP destination unreachable (code %d): %s", e.Code, e.State)
+}
+
+// ICMPListener reads ICMP errors from raw sockets and hands them to the
+// UDP probes they quote. Raw sockets need root or CAP_NET_RAW.
+type ICMPListener struct {
+	conns []net.PacketConn
+
+	mu      sync.Mutex
+	waiters map[icmpKey]chan *UnreachableError
+}
+
+// ListenICMP opens raw ICMP and ICMPv6 sockets. It fails only if neither
+// can be opened.
+func ListenICMP() (*ICMPListener, error) {
+	l := &ICMPListener{waiters: make(map[icmpKey]chan *UnreachableError)}
+	var errs []error
+	for _, family := range []struct {
+		network, address string
+		parse            func([]byte) (icmpKey, *UnreachableError, bool)
+	}{
+		{"ip4:icmp", "0.0.0.0", parseICMPv4Error},
+		{"ip6:ipv6-icmp", "::", parseICMPv6Error},
+	} {
+		conn, err := net.ListenPacket(family.network, family.address)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		l.conns = append(l.conns, conn)
+		go l.read(conn, family.parse)
+	}
+	if len(l.conns) == 0 {
+		return nil, errors.Join(errs...)
+	}
+	return l, nil
+}
+
+// Close stops the listener
+func (l *ICMPListener) Close() error {
+	for _, conn := range l.conns {
+		conn.Close()
+	}
+	return nil
+}
+
+// watch registers a probe sent from srcPort to ip:dstPort and returns the
+// channel its ICMP error, if any, arrives on
+func (l *ICMPListener) watch(ip string, dstPort, srcPort int) (<-chan *UnreachableError, func()) {
+	key := icmpKey{IP: normalizeIP(ip), DstPort: dstPort, SrcPort: srcPort}
+	ch := make(chan *UnreachableError, 1)
+	l.mu.Lock()
+	l.waiters[key] = ch
+	l.mu.Unlock()
+	return ch, func() {
+		l.mu.Lock()
+		delete(l.waiters, key)
+		l.mu.Unlock()
+	}
+}
+
+func (l *ICMPListener) read(conn net.PacketConn, parse func([]byte) (icmpKey, *UnreachableError, bool)) {
+	buf := make([]byte, 1500)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		key, unreachable, ok := parse(buf[:n])
+		if !ok {
+			continue
+		}
+		l.deliver(key, unreachable)
+	}
+}
+
+// deliver passes an ICMP error to the probe it quotes, if still waiting
+func (l *ICMPListener) deliver(key icmpKey, unreachable *UnreachableError) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if ch, ok := l.waiters[key]; ok {
+		select {
+		case ch <- unreachable:
+		default:
+		}
+	}
+}
+
+// awaitUnreachable returns the ICMP error delivered for a probe whose read
+// failed. A read that failed early failed on an ICMP error the kernel saw
+// too, so the listener gets icmpGrace to deliver it; after a timeout
+// anything that arrived is already queued.
+func awaitUnreachable(ch <-chan *UnreachableError, timedOut bool) *UnreachableError {
+	if timedOut {
+		select {
+		case e := <-ch:
+			return e
+		default:
+			return nil
+		}
+	}
+	select {
+	case e := <-ch:
+		return e
+	case <-time.After(icmpGrace):
+		return nil
+	}
+}
+
+// parseICMPv4Error extracts the quoted UDP datagram's endpoints from an
+// ICMP destination unreachable message (the IPv4 header already stripped
+// by the raw socket) and classifies it: code 3 (port unreachable) is
+// closed; host, protocol, network and administrative prohibitions are
+// filtered.
+func parseICMPv4Error(msg []byte) (icmpKey, *UnreachableError, bool) {
+	const typeDestUnreachable, codePortUnreachable = 3, 3
+	if len(msg) < 8 || msg[0] != typeDestUnreachable {
+		return icmpKey{}, nil, false
+	}
+	code := int(msg[1])
+
+	quoted := msg[8:]
+	if len(quoted) < 20 || quoted[0]>>4 != 4 {
+		return icmpKey{}, nil, false
+	}
+	headerLen := int(quoted[0]&0x0f) * 4
+	if headerLen < 20 || len(quoted) < headerLen+4 || quoted[9] != 17 { // UDP
+		return icmpKey{}, nil, false
+	}
+	key := icmpKey{
+		IP:      net.IP(quoted[16:20]).String(),
+		SrcPort: int(binary.BigEndian.Uint16(quoted[headerLen:])),
+		DstPort: int(binary.BigEndian.Uint16(quoted[headerLen+2:])),
+	}
+
+	state := UDPFiltered
+	if code == codePortUnreachable {
+		state = UDPClosed
+	}
+	return key, &UnreachableError{State: state, Code: code}, true
+}
+
+// parseICMPv6Error is parseICMPv4Error for ICMPv6 destination unreachable,
+// where code 4 is port unreachable. Extension headers in the quoted packet
+// are not followed.
+func parseICMPv6Error(msg []byte) (icmpKey, *UnreachableError, bool) {
+	const typeDestUnreachable, codePortUnreachable = 1, 4
+	if len(msg) < 8 || msg[0] != typeDestUnreachable {
+		return icmpKey{}, nil, false
+	}
+	code := int(msg[1])
+
+	quoted := msg[8:]
+	if len(quoted) < 40+4 || quoted[0]>>4 != 6 || quoted[6] != 17 { // next header UDP
+		return icmpKey{}, nil, false
+	}
+	key := icmpKey{
+		IP:      net.IP(quoted[24:40]).String(),
+		SrcPort: int(binary.BigEndian.Uint16(quoted[40:])),
+		DstPort: int(binary.BigEndian.Uint16(quoted[42:])),
+	}
+
+	state := UDPFiltered
+	if code == codePortUnreachable {
+		state = UDPClosed
+	}
+	return key, &UnreachableError{State: state, Code: code}, true
+}
+
+// normalizeIP gives ip the form net.IP.String produces, so keys built from
+// targets match those parsed from packets
+func normalizeIP(ip string) string {
+	if parsed := net.ParseIP(ip); parsed != nil {
+		return parsed.String()
+	}
+	return ip
+}
+
+// classifyUDP names the state a probe's outcome shows: a reply that wasn't
+// the expected protocol still means something is listening. Without an
+// ICMP listener a port unreachable can still surface as ECONNREFUSED on
+// the connected socket.
+func classifyUDP(err error) string {
+	var unreachable *UnreachableError
+	switch {
+	case err == nil, errors.Is(err, errUnrecognizedReply):
+		return UDPOpen
+	case errors.As(err, &unreachable):
+		return unreachable.State
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return UDPClosed
+	default:
+		return UDPOpenFiltered
+	}
+}
+
+// errUnrecognizedReply is returned by a UDP probe whose reply wasn't its
+// protocol
+var errUnrecognizedReply = errors.New("unrecognized UDP reply")
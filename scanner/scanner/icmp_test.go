@@ -0,0 +1,227 @@
+package scanner
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// icmpv4Error builds an ICMP destination unreachable message of code that
+// quotes a UDP datagram from srcPort to dst:dstPort, as a raw ip4:icmp
+// socket reads it
+func icmpv4Error(code byte, dst string, dstPort, srcPort int) []byte {
+	msg := []byte{3, code, 0, 0, 0, 0, 0, 0}
+	quoted := make([]byte, 20+8)
+	quoted[0] = 0x45
+	quoted[9] = 17
+	copy(quoted[12:16], net.ParseIP("192.0.2.1").To4())
+	copy(quoted[16:20], net.ParseIP(dst).To4())
+	binary.BigEndian.PutUint16(quoted[20:], uint16(srcPort))
+	binary.BigEndian.PutUint16(quoted[22:], uint16(dstPort))
+	return append(msg, quoted...)
+}
+
+// icmpv6Error is icmpv4Error for ICMPv6
+func icmpv6Error(code byte, dst string, dstPort, srcPort int) []byte {
+	msg := []byte{1, code, 0, 0, 0, 0, 0, 0}
+	quoted := make([]byte, 40+8)
+	quoted[0] = 0x60
+	quoted[6] = 17
+	copy(quoted[8:24], net.ParseIP("2001:db8::1"))
+	copy(quoted[24:40], net.ParseIP(dst))
+	binary.BigEndian.PutUint16(quoted[40:], uint16(srcPort))
+	binary.BigEndian.PutUint16(quoted[42:], uint16(dstPort))
+	return append(msg, quoted...)
+}
+
+func TestParseICMPv4Error(t *testing.T) {
+	tests := []struct {
+		name  string
+		code  byte
+		state string
+	}{
+		{"port unreachable", 3, UDPClosed},
+		{"host unreachable", 1, UDPFiltered},
+		{"protocol unreachable", 2, UDPFiltered},
+		{"administratively prohibited", 13, UDPFiltered},
+	}
+	for _, tt := range tests {
+		key, unreachable, ok := parseICMPv4Error(icmpv4Error(tt.code, "10.0.0.7", 161, 40001))
+		if !ok {
+			t.Errorf("%s: not parsed", tt.name)
+			continue
+		}
+		if want := (icmpKey{IP: "10.0.0.7", DstPort: 161, SrcPort: 40001}); key != want {
+			t.Errorf("%s: key = %+v, want %+v", tt.name, key, want)
+		}
+		if unreachable.State != tt.state || unreachable.Code != int(tt.code) {
+			t.Errorf("%s: %+v, want %s with code %d", tt.name, unreachable, tt.state, tt.code)
+		}
+	}
+
+	// An IPv4 header with options moves the quoted UDP header
+	msg := icmpv4Error(3, "10.0.0.7", 161, 40001)
+	withOptions := append(append([]byte{}, msg[:28]...), 1, 1, 1, 1)
+	withOptions = append(withOptions, msg[28:]...)
+	withOptions[8] = 0x46
+	if key, _, ok := parseICMPv4Error(withOptions); !ok || key.DstPort != 161 || key.SrcPort != 40001 {
+		t.Errorf("header with options: %+v, %v", key, ok)
+	}
+}
+
+func TestParseICMPv6Error(t *testing.T) {
+	for code, state := range map[byte]string{4: UDPClosed, 1: UDPFiltered, 3: UDPFiltered} {
+		key, unreachable, ok := parseICMPv6Error(icmpv6Error(code, "2001:db8::7", 53, 40002))
+		if !ok {
+			t.Errorf("code %d: not parsed", code)
+			continue
+		}
+		if want := (icmpKey{IP: "2001:db8::7", DstPort: 53, SrcPort: 40002}); key != want {
+			t.Errorf("code %d: key = %+v, want %+v", code, key, want)
+		}
+		if unreachable.State != state {
+			t.Errorf("code %d: state %s, want %s", code, unreachable.State, state)
+		}
+	}
+}
+
+func TestParseICMPIgnores(t *testing.T) {
+	echoReply := icmpv4Error(3, "10.0.0.7", 161, 40001)
+	echoReply[0] = 0
+	tcp := icmpv4Error(3, "10.0.0.7", 161, 40001)
+	tcp[8+9] = 6
+	for name, msg := range map[string][]byte{
+		"not unreachable": echoReply,
+		"quotes tcp":      tcp,
+		"truncated":       icmpv4Error(3, "10.0.0.7", 161, 40001)[:30],
+		"no quote":        {3, 3, 0, 0, 0, 0, 0, 0},
+		"empty":           nil,
+	} {
+		if _, _, ok := parseICMPv4Error(msg); ok {
+			t.Errorf("%s: parsed", name)
+		}
+	}
+	v6tcp := icmpv6Error(4, "2001:db8::7", 53, 40002)
+	v6tcp[8+6] = 6
+	if _, _, ok := parseICMPv6Error(v6tcp); ok {
+		t.Error("ICMPv6 quoting tcp parsed")
+	}
+	if _, _, ok := parseICMPv6Error(icmpv4Error(3, "10.0.0.7", 161, 40001)); ok {
+		t.Error("ICMPv4 message parsed as ICMPv6")
+	}
+}
+
+// testListener is an ICMP listener without sockets, fed through deliver
+func testListener() *ICMPListener {
+	return &ICMPListener{waiters: make(map[icmpKey]chan *UnreachableError)}
+}
+
+// feed parses msg as a raw socket read would and delivers it
+func feed(l *ICMPListener, msg []byte, parse func([]byte) (icmpKey, *UnreachableError, bool)) {
+	if key, unreachable, ok := parse(msg); ok {
+		l.deliver(key, unreachable)
+	}
+}
+
+func TestICMPCorrelation(t *testing.T) {
+	l := testListener()
+	probe, stop := l.watch("10.0.0.7", 161, 40001)
+	defer stop()
+	other, stopOther := l.watch("10.0.0.7", 161, 40002)
+	defer stopOther()
+	v6, stopV6 := l.watch("2001:DB8:0::7", 53, 40003)
+	defer stopV6()
+
+	// Errors quoting other probes, or no probe at all, go nowhere
+	feed(l, icmpv4Error(3, "10.0.0.8", 161, 40001), parseICMPv4Error)
+	feed(l, icmpv4Error(3, "10.0.0.7", 162, 40001), parseICMPv4Error)
+	feed(l, icmpv4Error(13, "10.0.0.7", 161, 40001), parseICMPv4Error)
+	feed(l, icmpv6Error(4, "2001:db8::7", 53, 40003), parseICMPv6Error)
+
+	if e := awaitUnreachable(probe, true); e == nil || e.State != UDPFiltered || e.Code != 13 {
+		t.Errorf("probe got %+v, want filtered with code 13", e)
+	}
+	if e := awaitUnreachable(other, true); e != nil {
+		t.Errorf("probe from another source port got %+v", e)
+	}
+	if e := awaitUnreachable(v6, true); e == nil || e.State != UDPClosed {
+		t.Errorf("IPv6 probe got %+v, want closed", e)
+	}
+
+	// Once stopped, a probe is no longer delivered to
+	stop()
+	feed(l, icmpv4Error(3, "10.0.0.7", 161, 40001), parseICMPv4Error)
+	if e := awaitUnreachable(probe, true); e != nil {
+		t.Errorf("stopped probe got %+v", e)
+	}
+}
+
+func TestAwaitUnreachableGrace(t *testing.T) {
+	l := testListener()
+	ch, stop := l.watch("10.0.0.7", 161, 40001)
+	defer stop()
+	go func() {
+		time.Sleep(icmpGrace / 4)
+		feed(l, icmpv4Error(3, "10.0.0.7", 161, 40001), parseICMPv4Error)
+	}()
+	if e := awaitUnreachable(ch, false); e == nil || e.State != UDPClosed {
+		t.Errorf("early read failure got %+v, want the late ICMP error", e)
+	}
+
+	start := time.Now()
+	if e := awaitUnreachable(ch, false); e != nil || time.Since(start) < icmpGrace {
+		t.Errorf("nothing delivered: got %+v after %s, want nil after the grace", e, time.Since(start))
+	}
+}
+
+func TestClassifyUDP(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{nil, UDPOpen},
+		{errUnrecognizedReply, UDPOpen},
+		{&UnreachableError{State: UDPClosed, Code: 3}, UDPClosed},
+		{&UnreachableError{State: UDPFiltered, Code: 13}, UDPFiltered},
+		{&net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNREFUSED)}, UDPClosed},
+		{os.ErrDeadlineExceeded, UDPOpenFiltered},
+		{errors.New("network unreachable"), UDPOpenFiltered},
+	}
+	for _, tt := range tests {
+		if got := classifyUDP(tt.err); got != tt.want {
+			t.Errorf("classifyUDP(%v) = %s, want %s", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestUDPExchangeClosedPort(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+	conn.Close()
+
+	// Without privileges the kernel's port unreachable still surfaces
+	f := testFingerprinter()
+	_, err = f.udpExchange("127.0.0.1", port, []byte("probe"))
+	if got := classifyUDP(err); got != UDPClosed {
+		t.Errorf("without a listener: %v classified %s, want closed", err, got)
+	}
+
+	listener, err := ListenICMP()
+	if err != nil {
+		t.Skipf("no raw ICMP socket: %v", err)
+	}
+	defer listener.Close()
+	f.ICMP = listener
+	_, err = f.udpExchange("127.0.0.1", port, []byte("probe"))
+	var unreachable *UnreachableError
+	if !errors.As(err, &unreachable) || unreachable.State != UDPClosed || unreachable.Code != 3 {
+		t.Errorf("with a listener: %v, want a correlated port unreachable", err)
+	}
+}
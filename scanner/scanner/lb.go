@@ -0,0 +1,80 @@
+package scanner
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// backend identifies which server behind an address answered a request
+type backend struct {
+	Server string `json:"server,omitempty"`  // Server response header
+	CertCN string `json:"cert_cn,omitempty"` // leaf certificate subject CN
+}
+
+// checkLoadBalancer requests / LBDetectSamples times, each on a new
+// connection, and sets Fingerprint["load_balanced"] when the answers come
+// from visibly different backends. Fingerprint["backends"] lists each
+// distinct backend in the order first seen.
+func (f *Fingerprinter) checkLoadBalancer(ip string, port int, info *ServiceInfo) {
+	if f.LBDetectSamples < 2 || (info.ServiceName != "http" && info.ServiceName != "https") {
+		return
+	}
+	useTLS := info.ServiceName == "https"
+
+	var backends []backend
+	seen := make(map[backend]bool)
+	samples := 0
+	for i := 0; i < f.LBDetectSamples; i++ {
+		b, err := f.sampleBackend(ip, port, useTLS)
+		if err != nil {
+			continue
+		}
+		samples++
+		if !seen[b] {
+			seen[b] = true
+			backends = append(backends, b)
+		}
+	}
+	if samples < 2 {
+		return // too few answers to compare
+	}
+
+	if info.Fingerprint == nil {
+		info.Fingerprint = make(map[string]interface{})
+	}
+	info.Fingerprint["load_balanced"] = len(backends) > 1
+	if len(backends) > 1 {
+		info.Fingerprint["backends"] = backends
+	}
+}
+
+// sampleBackend makes one request and reports the answering backend
+func (f *Fingerprinter) sampleBackend(ip string, port int, useTLS bool) (backend, error) {
+	scheme := "http"
+	if useTLS {
+		scheme = "https"
+	}
+
+	url := fmt.Sprintf("%s://%s/", scheme, net.JoinHostPort(ip, strconv.Itoa(port)))
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return backend{}, err
+	}
+	req.Header.Set("User-Agent", "NetworkScanner/1.0")
+
+	resp, err := f.probeClient(ip).Do(req)
+	if err != nil {
+		return backend{}, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxHTTPBody))
+
+	b := backend{Server: resp.Header.Get("Server")}
+	if resp.TLS != nil && len(resp.TLS.PeerCertificates) > 0 {
+		b.CertCN = resp.TLS.PeerCertificates[0].Subject.CommonName
+	}
+	return b, nil
+}
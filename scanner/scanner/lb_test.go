@@ -0,0 +1,112 @@
+package scanner
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+// roundRobin serves /, naming each response's Server after the next of
+// servers in turn, and returns its IP and port
+func roundRobin(t *testing.T, servers ...string) (string, int) {
+	t.Helper()
+	var next atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", servers[int(next.Add(1)-1)%len(servers)])
+		w.Write([]byte("<html><title>app</title></html>"))
+	}))
+	t.Cleanup(server.Close)
+	return listenerAddress(server)
+}
+
+// listenerAddress splits a test server's address
+func listenerAddress(server *httptest.Server) (string, int) {
+	host, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	n, _ := strconv.Atoi(port)
+	return host, n
+}
+
+func TestLoadBalancerAlternatingServers(t *testing.T) {
+	ip, port := roundRobin(t, "nginx/1.24.0 (backend-a)", "nginx/1.24.0 (backend-b)")
+	f := testFingerprinter()
+	f.LBDetectSamples = 3
+
+	info := ServiceInfo{ServiceName: "http"}
+	f.checkLoadBalancer(ip, port, &info)
+	if info.Fingerprint["load_balanced"] != true {
+		t.Fatalf("load_balanced = %v, want true", info.Fingerprint["load_balanced"])
+	}
+	want := []backend{{Server: "nginx/1.24.0 (backend-a)"}, {Server: "nginx/1.24.0 (backend-b)"}}
+	if got := info.Fingerprint["backends"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("backends = %v, want %v", got, want)
+	}
+}
+
+func TestLoadBalancerSingleBackend(t *testing.T) {
+	ip, port := roundRobin(t, "Apache/2.4.58")
+	f := testFingerprinter()
+	f.LBDetectSamples = 3
+
+	info := ServiceInfo{ServiceName: "http", Fingerprint: map[string]interface{}{"title": "app"}}
+	f.checkLoadBalancer(ip, port, &info)
+	if info.Fingerprint["load_balanced"] != false || info.Fingerprint["title"] != "app" {
+		t.Errorf("fingerprint = %v, want load_balanced false alongside the title", info.Fingerprint)
+	}
+	if _, ok := info.Fingerprint["backends"]; ok {
+		t.Errorf("backends recorded for a single backend: %v", info.Fingerprint["backends"])
+	}
+}
+
+func TestLoadBalancerCertificates(t *testing.T) {
+	certs := []tls.Certificate{testCertificate(t, "web-1.example"), testCertificate(t, "web-2.example")}
+	var next atomic.Int64
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "envoy")
+	}))
+	// A plain TLS listener: httptest's own TLS setup would pin one
+	// certificate for clients that send no server name
+	server.Listener = tls.NewListener(server.Listener, &tls.Config{GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return &certs[int(next.Add(1)-1)%len(certs)], nil
+	}})
+	server.Start()
+	t.Cleanup(server.Close)
+	ip, port := listenerAddress(server)
+
+	f := testFingerprinter()
+	f.LBDetectSamples = 4
+	info := ServiceInfo{ServiceName: "https"}
+	f.checkLoadBalancer(ip, port, &info)
+	want := []backend{{Server: "envoy", CertCN: "web-1.example"}, {Server: "envoy", CertCN: "web-2.example"}}
+	if info.Fingerprint["load_balanced"] != true || !reflect.DeepEqual(info.Fingerprint["backends"], want) {
+		t.Errorf("fingerprint = %v, want both certificates", info.Fingerprint)
+	}
+}
+
+func TestLoadBalancerSkipped(t *testing.T) {
+	ip, port := roundRobin(t, "a", "b")
+	tests := []struct {
+		name    string
+		samples int
+		service string
+		port    int
+	}{
+		{"disabled", 0, "http", port},
+		{"one sample", 1, "http", port},
+		{"not http", 3, "ssh", port},
+		{"no answers", 3, "http", closedPort(t)},
+	}
+	for _, tt := range tests {
+		f := testFingerprinter()
+		f.LBDetectSamples = tt.samples
+		info := ServiceInfo{ServiceName: tt.service}
+		f.checkLoadBalancer(ip, tt.port, &info)
+		if _, ok := info.Fingerprint["load_balanced"]; ok {
+			t.Errorf("%s: load_balanced recorded: %v", tt.name, info.Fingerprint)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package scanner
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// metadataIP is the link-local address cloud metadata services answer on
+const metadataIP = "169.254.169.254"
+
+// metadataProbe is one provider's metadata index: a read-only listing that
+// holds no credentials, and how to recognize it
+type metadataProbe struct {
+	provider string
+	host     string
+	path     string
+	header   [2]string // extra request header the service requires, if any
+	match    func(resp *http.Response, body string) bool
+}
+
+var metadataProbes = []metadataProbe{
+	// AWS IMDSv1, also served by OpenStack and others
+	{"aws", metadataIP, "/latest/meta-data/", [2]string{}, func(resp *http.Response, body string) bool {
+		if resp.StatusCode != http.StatusOK {
+			return false
+		}
+		for _, line := range strings.Split(body, "\n") {
+			if line = strings.TrimSpace(line); line == "instance-id" || line == "ami-id" {
+				return true
+			}
+		}
+		return false
+	}},
+	{"gcp", "metadata.google.internal", "/computeMetadata/v1/", [2]string{"Metadata-Flavor", "Google"}, func(resp *http.Response, body string) bool {
+		return resp.StatusCode == http.StatusOK && resp.Header.Get("Metadata-Flavor") == "Google"
+	}},
+}
+
+// checkCloudMetadata asks an HTTP(S) service for the cloud metadata index,
+// once as a forward proxy (absolute URI) and once by Host header, and
+// records Fingerprint["metadata_exposed"]. When a metadata response comes
+// back, ["metadata_provider"] and ["metadata_via"] ("proxy" or "host") say
+// which. Only the index listing is requested, never credentials.
+func (f *Fingerprinter) checkCloudMetadata(ip string, port int, info *ServiceInfo) {
+	if !f.MetadataTest || (info.ServiceName != "http" && info.ServiceName != "https" && info.ServiceName != "http-proxy") {
+		return
+	}
+	useTLS := info.ServiceName == "https"
+
+	answered := false
+	for _, probe := range metadataProbes {
+		for _, via := range []string{"proxy", "host"} {
+			exposed, err := f.metadataRequest(ip, port, useTLS, probe, via == "proxy")
+			if err != nil {
+				continue
+			}
+			answered = true
+			if exposed {
+				if info.Fingerprint == nil {
+					info.Fingerprint = make(map[string]interface{})
+				}
+				info.Fingerprint["metadata_exposed"] = true
+				info.Fingerprint["metadata_provider"] = probe.provider
+				info.Fingerprint["metadata_via"] = via
+				log.Printf("Metadata test: %s:%d forwards to the %s metadata service (%s)", ip, port, probe.provider, via)
+				return
+			}
+		}
+	}
+
+	if answered {
+		if info.Fingerprint == nil {
+			info.Fingerprint = make(map[string]interface{})
+		}
+		info.Fingerprint["metadata_exposed"] = false
+	}
+}
+
+// metadataRequest sends one probe's request, with an absolute URI when
+// asProxy is set, and reports whether the response is the provider's
+// metadata. An error means no usable answer at all.
+func (f *Fingerprinter) metadataRequest(ip string, port int, useTLS bool, probe metadataProbe, asProxy bool) (bool, error) {
+	conn, err := f.dial(net.JoinHostPort(ip, strconv.Itoa(port)))
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(f.Timeout))
+
+	if useTLS {
+		tlsConn := tls.Client(conn, &tls.Config{InsecureSkipVerify: true})
+		if err := tlsConn.Handshake(); err != nil {
+			return false, err
+		}
+		conn = tlsConn
+	}
+
+	target := probe.path
+	if asProxy {
+		target = "http://" + probe.host + probe.path
+	}
+	request := fmt.Sprintf("GET %s HTTP/1.1\r\nHost: %s\r\nUser-Agent: NetworkScanner/1.0\r\n", target, probe.host)
+	if probe.header[0] != "" {
+		request += probe.header[0] + ": " + probe.header[1] + "\r\n"
+	}
+	request += "Connection: close\r\n\r\n"
+	if _, err := conn.Write([]byte(request)); err != nil {
+		return false, err
+	}
+
+	// Index listings are small, so the body budget doesn't apply
+	body, resp := readHTTPResponse(bufio.NewReader(conn), nil)
+	if resp == nil {
+		return false, fmt.Errorf("no HTTP response")
+	}
+	return probe.match(resp, body), nil
+}
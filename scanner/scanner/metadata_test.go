@@ -0,0 +1,126 @@
+package scanner
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// awsIndex is the start of an IMDSv1 meta-data listing
+const awsIndex = "ami-id\nami-launch-index\nhostname\ninstance-id\nlocal-ipv4\n"
+
+// forwardingProxy answers absolute-URI requests for the metadata IP the
+// way an open proxy in front of IMDS would, and anything else as an app
+func forwardingProxy(w http.ResponseWriter, r *http.Request) {
+	if r.URL.IsAbs() && r.URL.Host == metadataIP && r.URL.Path == "/latest/meta-data/" {
+		w.Write([]byte(awsIndex))
+		return
+	}
+	w.Write([]byte("<html><title>app</title></html>"))
+}
+
+// hostRouter routes by Host header, sending metadata.google.internal on to
+// the GCP metadata server, which answers only requests with its header
+func hostRouter(w http.ResponseWriter, r *http.Request) {
+	if r.Host == "metadata.google.internal" && !r.URL.IsAbs() && r.Header.Get("Metadata-Flavor") == "Google" {
+		w.Header().Set("Metadata-Flavor", "Google")
+		w.Write([]byte("instance/\nproject/\n"))
+		return
+	}
+	http.NotFound(w, r)
+}
+
+// metadataService serves handler and returns a fingerprinter with the test
+// enabled, the server's IP and port
+func metadataService(t *testing.T, handler http.HandlerFunc, useTLS bool) (*Fingerprinter, string, int) {
+	t.Helper()
+	server := httptest.NewUnstartedServer(handler)
+	if useTLS {
+		server.StartTLS()
+	} else {
+		server.Start()
+	}
+	t.Cleanup(server.Close)
+	ip, port := listenerAddress(server)
+	f := testFingerprinter()
+	f.MetadataTest = true
+	return f, ip, port
+}
+
+func TestCloudMetadataExposed(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		service string
+		tls     bool
+		want    map[string]interface{}
+	}{
+		{"forward proxy", forwardingProxy, "http-proxy", false, map[string]interface{}{
+			"metadata_exposed": true, "metadata_provider": "aws", "metadata_via": "proxy",
+		}},
+		{"forward proxy over TLS", forwardingProxy, "https", true, map[string]interface{}{
+			"metadata_exposed": true, "metadata_provider": "aws", "metadata_via": "proxy",
+		}},
+		{"Host header routing", hostRouter, "http", false, map[string]interface{}{
+			"metadata_exposed": true, "metadata_provider": "gcp", "metadata_via": "host",
+		}},
+	}
+	for _, tt := range tests {
+		f, ip, port := metadataService(t, tt.handler, tt.tls)
+		info := ServiceInfo{ServiceName: tt.service}
+		f.checkCloudMetadata(ip, port, &info)
+		if !reflect.DeepEqual(info.Fingerprint, tt.want) {
+			t.Errorf("%s: fingerprint = %v, want %v", tt.name, info.Fingerprint, tt.want)
+		}
+	}
+}
+
+func TestCloudMetadataNotForwarded(t *testing.T) {
+	handlers := map[string]http.HandlerFunc{
+		"plain app": func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("<html><title>app</title></html>"))
+		},
+		// Mentions instance-id, but as an error page
+		"listing in a 404": func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(awsIndex))
+		},
+		// Claims the flavor without being asked as GCP requires
+		"flavor header on a 404": func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Metadata-Flavor", "Google")
+			http.NotFound(w, r)
+		},
+	}
+	for name, handler := range handlers {
+		f, ip, port := metadataService(t, handler, false)
+		info := ServiceInfo{ServiceName: "http", Fingerprint: map[string]interface{}{"title": "app"}}
+		f.checkCloudMetadata(ip, port, &info)
+		want := map[string]interface{}{"title": "app", "metadata_exposed": false}
+		if !reflect.DeepEqual(info.Fingerprint, want) {
+			t.Errorf("%s: fingerprint = %v, want %v", name, info.Fingerprint, want)
+		}
+	}
+}
+
+func TestCloudMetadataSkipped(t *testing.T) {
+	f, ip, port := metadataService(t, forwardingProxy, false)
+	tests := []struct {
+		name    string
+		enabled bool
+		service string
+		port    int
+	}{
+		{"disabled", false, "http", port},
+		{"not http", true, "ssh", port},
+		{"no answer", true, "http", closedPort(t)},
+	}
+	for _, tt := range tests {
+		f.MetadataTest = tt.enabled
+		info := ServiceInfo{ServiceName: tt.service}
+		f.checkCloudMetadata(ip, tt.port, &info)
+		if info.Fingerprint != nil {
+			t.Errorf("%s: fingerprint = %v, want nothing recorded", tt.name, info.Fingerprint)
+		}
+	}
+}
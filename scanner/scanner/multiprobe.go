@@ -0,0 +1,83 @@
+package scanner
+
+import (
+	"sort"
+	"sync"
+)
+
+// probeMulti runs the named probes at once and merges the results of those
+// that identify the service: the richest keeps its name and values, and the
+// others fill in what it lacks. When none identifies the service, it is
+// left unnamed for the port-based fallback, as with probeSequence.
+func (f *Fingerprinter) probeMulti(ip string, port int, names []string) ServiceInfo {
+	results := make([]ServiceInfo, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, p sequenceProbe) {
+			defer wg.Done()
+			results[i] = p.probe(f, ip, port)
+		}(i, sequenceProbes[name])
+	}
+	wg.Wait()
+
+	var identified []ServiceInfo
+	var unidentified ServiceInfo
+	for i, info := range results {
+		if sequenceProbes[names[i]].identified(info) {
+			identified = append(identified, info)
+		} else if unidentified.Banner == "" {
+			unidentified.Banner = info.Banner
+		}
+	}
+	if len(identified) == 0 {
+		return unidentified
+	}
+	return mergeServiceInfo(identified)
+}
+
+// richness scores how informative a result is: one point for each
+// populated field (version, banner) and each fingerprint entry
+func richness(info ServiceInfo) int {
+	score := len(info.Fingerprint)
+	if info.ServiceVersion != "" {
+		score++
+	}
+	if info.Banner != "" {
+		score++
+	}
+	return score
+}
+
+// mergeServiceInfo starts from the richest of results, the first on a
+// tie, and fills in its empty fields and missing fingerprint entries from
+// the rest, richest first
+func mergeServiceInfo(results []ServiceInfo) ServiceInfo {
+	order := make([]int, len(results))
+	for i := range order {
+		order[i] = i
+	}
+	// Stable, so on a tie the order the probes were listed in decides
+	sort.SliceStable(order, func(a, b int) bool {
+		return richness(results[order[a]]) > richness(results[order[b]])
+	})
+
+	merged := results[order[0]]
+	fingerprint := make(map[string]interface{}, len(merged.Fingerprint))
+	for _, i := range order {
+		info := results[i]
+		if merged.ServiceVersion == "" {
+			merged.ServiceVersion = info.ServiceVersion
+		}
+		if merged.Banner == "" {
+			merged.Banner = info.Banner
+		}
+		for key, value := range info.Fingerprint {
+			if _, ok := fingerprint[key]; !ok {
+				fingerprint[key] = value
+			}
+		}
+	}
+	merged.Fingerprint = fingerprint
+	return merged
+}
@@ -0,0 +1,158 @@
+package scanner
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMergeServiceInfo(t *testing.T) {
+	// The TLS side only saw the certificate and a status; the plain side
+	// got the Server header and the page
+	tlsSide := ServiceInfo{ServiceName: "https", Banner: "HTTP/1.1 200 OK", Fingerprint: map[string]interface{}{
+		"tls": map[string]interface{}{"subject": "CN=app.internal"}, "status_code": "200",
+	}}
+	plainSide := ServiceInfo{ServiceName: "http", ServiceVersion: "nginx/1.24.0", Banner: "HTTP/1.1 200 OK  Server: nginx/1.24.0", Fingerprint: map[string]interface{}{
+		"status_code": "301", "title": "Dashboard",
+	}}
+	if richness(tlsSide) != 3 || richness(plainSide) != 4 {
+		t.Fatalf("richness %d and %d, want a point per field and fingerprint entry", richness(tlsSide), richness(plainSide))
+	}
+
+	want := ServiceInfo{ServiceName: "http", ServiceVersion: "nginx/1.24.0", Banner: plainSide.Banner, Fingerprint: map[string]interface{}{
+		"tls": tlsSide.Fingerprint["tls"], "status_code": "301", "title": "Dashboard",
+	}}
+	for _, order := range [][]ServiceInfo{{tlsSide, plainSide}, {plainSide, tlsSide}} {
+		if got := mergeServiceInfo(order); !reflect.DeepEqual(got, want) {
+			t.Errorf("merge of %s then %s = %+v, want %+v", order[0].ServiceName, order[1].ServiceName, got, want)
+		}
+	}
+	if len(plainSide.Fingerprint) != 2 || len(tlsSide.Fingerprint) != 2 {
+		t.Error("merge modified its inputs")
+	}
+
+	// On a tie the first listed wins, and gaps are still filled
+	a := ServiceInfo{ServiceName: "imap", Banner: "* OK"}
+	b := ServiceInfo{ServiceName: "pop3", ServiceVersion: "Dovecot"}
+	if got := mergeServiceInfo([]ServiceInfo{a, b}); got.ServiceName != "imap" || got.ServiceVersion != "Dovecot" || got.Banner != "* OK" || got.Fingerprint == nil {
+		t.Errorf("tie = %+v, want imap filled in from pop3", got)
+	}
+}
+
+// peekedConn is a conn whose first bytes were read through r
+type peekedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c peekedConn) Read(b []byte) (int, error) { return c.r.Read(b) }
+
+// dualHTTP serves HTTP and HTTPS on one port, as some appliances do: the
+// TLS side is a terminator that sends no Server header or page, the plain
+// side a full nginx response. Each connection waits for the other probe's
+// to arrive, reporting on together whether they ever overlapped.
+func dualHTTP(t *testing.T, probes int) (string, int, chan bool) {
+	t.Helper()
+	cert := testCertificate(t, "app.internal")
+	together := make(chan bool, probes)
+	var mu sync.Mutex
+	arrived := 0
+	all := make(chan struct{})
+	ip, port := stubServer(t, func(conn net.Conn) {
+		mu.Lock()
+		if arrived++; arrived == probes {
+			close(all)
+		}
+		mu.Unlock()
+		select {
+		case <-all:
+			together <- true
+		case <-time.After(time.Second):
+			together <- false
+		}
+
+		r := bufio.NewReader(conn)
+		first, err := r.Peek(1)
+		if err != nil {
+			return
+		}
+		var c net.Conn = peekedConn{conn, r}
+		response := "HTTP/1.1 301 Moved Permanently\r\nServer: nginx/1.24.0\r\nLocation: https://app.internal/\r\nContent-Type: text/html\r\nContent-Length: 40\r\nConnection: close\r\n\r\n<html><title>Dashboard</title></html>\r\n\r\n"
+		if first[0] == 0x16 { // a TLS handshake record
+			tlsConn := tls.Server(c, &tls.Config{Certificates: []tls.Certificate{cert}})
+			if tlsConn.Handshake() != nil {
+				return
+			}
+			c = tlsConn
+			response = "HTTP/1.1 200 OK\r\nContent-Length: 0\r\nConnection: close\r\n\r\n"
+		}
+		request := bufio.NewReader(c)
+		for {
+			line, err := request.ReadString('\n')
+			if err != nil || line == "\r\n" {
+				break
+			}
+		}
+		io.WriteString(c, response)
+	})
+	return ip, port, together
+}
+
+func TestProbeMultiMergesPartials(t *testing.T) {
+	ip, port, together := dualHTTP(t, 2)
+	f := testFingerprinter()
+	f.MultiProbe = map[int][]string{port: {"https", "http"}}
+	info := f.FingerprintHost(context.Background(), ip, []int{port})[port]
+
+	for i := 0; i < 2; i++ {
+		if !<-together {
+			t.Error("probes ran one after the other, want them at once")
+		}
+	}
+	if info.ServiceName != "http" || info.ServiceVersion != "nginx/1.24.0" {
+		t.Errorf("service %q %q, want the richer plain side's", info.ServiceName, info.ServiceVersion)
+	}
+	if info.Fingerprint["title"] != "Dashboard" || info.Fingerprint["status_code"] != "301" {
+		t.Errorf("fingerprint %v, want the plain side's page", info.Fingerprint)
+	}
+	tlsInfo, _ := info.Fingerprint["tls"].(map[string]interface{})
+	if tlsInfo == nil {
+		t.Errorf("fingerprint %v, want the TLS side's certificate merged in", info.Fingerprint)
+	}
+}
+
+func TestProbeMultiUnidentified(t *testing.T) {
+	// Neither probe's protocol: the port is named from the port database,
+	// keeping the banner one of them heard
+	ip, port := stubServer(t, func(conn net.Conn) {
+		io.WriteString(conn, "HELLO custom-app v3\r\n")
+		io.Copy(io.Discard, conn)
+	})
+	f := testFingerprinter()
+	f.Timeout = 300 * time.Millisecond
+	f.MultiProbe = map[int][]string{port: {"ssh", "redis"}}
+	info := f.FingerprintHost(context.Background(), ip, []int{port})[port]
+	if info.ServiceName != getDefaultServiceName(port) || info.Banner == "" {
+		t.Errorf("info = %q %q, want the port's name and a banner", info.ServiceName, info.Banner)
+	}
+}
+
+func TestProbeMultiZgrabGoesNative(t *testing.T) {
+	z, calls := cannedZgrab(t, cannedZgrabSSH)
+	ip, port, _ := dualHTTP(t, 2)
+	z.Fallback.Timeout = 2 * time.Second
+	z.Fallback.MultiProbe = map[int][]string{port: {"https", "http"}}
+	if info := z.fingerprintPort(context.Background(), ip, port); info.ServiceName != "http" || info.Fingerprint["tls"] == nil {
+		t.Errorf("info = %+v, want the native merge", info)
+	}
+	if _, err := os.Stat(calls); err == nil {
+		t.Error("zgrab2 ran for a multi_probe port")
+	}
+}
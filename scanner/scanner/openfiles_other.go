@@ -0,0 +1,8 @@
+//go:build !unix
+
+package scanner
+
+// OpenFileLimit is unknown off Unix
+func OpenFileLimit() int {
+	return 0
+}
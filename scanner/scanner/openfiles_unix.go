@@ -0,0 +1,19 @@
+//go:build unix
+
+package scanner
+
+import "syscall"
+
+// OpenFileLimit returns the process's soft RLIMIT_NOFILE, which the Go
+// runtime raises to the hard limit at startup, or 0 if it can't be read
+// or is unlimited
+func OpenFileLimit() int {
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+		return 0
+	}
+	if limit.Cur > uint64(int(^uint(0)>>1)) { // RLIM_INFINITY
+		return 0
+	}
+	return int(limit.Cur)
+}
@@ -0,0 +1,32 @@
+//go:build unix
+
+package scanner
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestDefaultSocketBudget(t *testing.T) {
+	var saved syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &saved); err != nil {
+		t.Skip(err)
+	}
+	defer syscall.Setrlimit(syscall.RLIMIT_NOFILE, &saved)
+
+	for soft, want := range map[uint64]int{1000: 800, 200: 136, 64: 1} {
+		if soft > saved.Max {
+			continue
+		}
+		limit := syscall.Rlimit{Cur: soft, Max: saved.Max}
+		if err := syscall.Setrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+			t.Skip(err)
+		}
+		if got := OpenFileLimit(); got != int(soft) {
+			t.Errorf("OpenFileLimit() = %d, want %d", got, soft)
+		}
+		if got := DefaultSocketBudget(); got != want {
+			t.Errorf("soft limit %d: DefaultSocketBudget() = %d, want %d", soft, got, want)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package scanner
+
+import (
+	"context"
+	"sync"
+)
+
+// PauseGate lets a running scan be paused between connection attempts.
+// Workers call Wait before acquiring their semaphore slot, so in-flight
+// probes finish normally while no new ones start. A nil gate never pauses.
+type PauseGate struct {
+	mu     sync.Mutex
+	paused bool
+	resume chan struct{} // closed on Resume
+}
+
+// NewPauseGate creates an unpaused gate
+func NewPauseGate() *PauseGate {
+	return &PauseGate{}
+}
+
+// Pause stops new work from starting. It reports false if already paused.
+func (g *PauseGate) Pause() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.paused {
+		return false
+	}
+	g.paused = true
+	g.resume = make(chan struct{})
+	return true
+}
+
+// Resume releases all waiting workers. It reports false if not paused.
+func (g *PauseGate) Resume() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.paused {
+		return false
+	}
+	g.paused = false
+	close(g.resume)
+	return true
+}
+
+// Paused reports whether the gate is currently paused
+func (g *PauseGate) Paused() bool {
+	if g == nil {
+		return false
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.paused
+}
+
+// Wait blocks while the gate is paused, returning the context's error if it
+// is cancelled first
+func (g *PauseGate) Wait(ctx context.Context) error {
+	if g == nil {
+		return ctx.Err()
+	}
+	g.mu.Lock()
+	paused, resume := g.paused, g.resume
+	g.mu.Unlock()
+
+	if !paused {
+		return ctx.Err()
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-resume:
+		return ctx.Err()
+	}
+}
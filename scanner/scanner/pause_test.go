@@ -0,0 +1,86 @@
+package scanner
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPauseGate(t *testing.T) {
+	g := NewPauseGate()
+	if !g.Pause() || g.Pause() {
+		t.Fatal("Pause should succeed once")
+	}
+	if !g.Paused() {
+		t.Fatal("Paused() = false after Pause")
+	}
+
+	released := make(chan error, 1)
+	go func() { released <- g.Wait(context.Background()) }()
+	select {
+	case <-released:
+		t.Fatal("Wait returned while paused")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if !g.Resume() || g.Resume() {
+		t.Fatal("Resume should succeed once")
+	}
+	if err := <-released; err != nil {
+		t.Fatalf("Wait after Resume = %v", err)
+	}
+
+	g.Pause()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := g.Wait(ctx); err != context.Canceled {
+		t.Errorf("Wait with a cancelled context = %v, want context.Canceled", err)
+	}
+
+	var nilGate *PauseGate
+	if nilGate.Paused() || nilGate.Wait(context.Background()) != nil {
+		t.Error("a nil gate should never pause")
+	}
+}
+
+func TestPausedScanAttemptsNothingUntilResumed(t *testing.T) {
+	first, firstAccepts := countAccepts(t, "127.0.0.1")
+	second, secondAccepts := countAccepts(t, "127.0.0.1")
+
+	s := NewTCPScanner([]string{"127.0.0.1/32"}, 4, 1)
+	s.Gate = NewPauseGate()
+
+	// Pause as soon as the first port is done, as /pause would mid-scan
+	paused := make(chan struct{})
+	done := make(chan map[string][]int, 1)
+	go func() {
+		results, err := s.ScanPortsWithCallback(context.Background(), []int{first, second}, func(port int, _ []ZmapResult) {
+			if port == first {
+				s.Gate.Pause()
+				close(paused)
+			}
+		})
+		if err != nil {
+			t.Error(err)
+		}
+		done <- results
+	}()
+
+	<-paused
+	eventually(func() bool { return firstAccepts() == 1 })
+	time.Sleep(100 * time.Millisecond)
+	if firstAccepts() != 1 || secondAccepts() != 0 {
+		t.Fatalf("while paused: %d attempts on the first port, %d on the second; want 1 and 0", firstAccepts(), secondAccepts())
+	}
+	select {
+	case <-done:
+		t.Fatal("scan finished while paused")
+	default:
+	}
+
+	s.Gate.Resume()
+	results := <-done
+	if !eventually(func() bool { return secondAccepts() == 1 }) || len(results["127.0.0.1"]) != 2 {
+		t.Errorf("after resume: %d attempts on the second port, results %v", secondAccepts(), results)
+	}
+}
@@ -0,0 +1,120 @@
+package scanner
+
+import (
+	"context"
+	"sync"
+)
+
+// HostFingerprinter fingerprints a set of open ports on one host.
+// Both Fingerprinter and ZgrabFingerprinter implement it.
+type HostFingerprinter interface {
+	FingerprintHost(ctx context.Context, ip string, ports []int) map[int]ServiceInfo
+}
+
+// FingerprintEmitFunc receives each fingerprinted endpoint. Calls are
+// serialized, but arrive in completion order rather than input order.
+type FingerprintEmitFunc func(target ZmapResult, info ServiceInfo)
+
+// HostLimiter caps the fingerprint probes in flight against any one IP,
+// however high the overall concurrency. It may be shared by several
+// FingerprintAll calls.
+type HostLimiter struct {
+	limit int
+
+	mu    sync.Mutex
+	hosts map[string]*hostSlots
+}
+
+type hostSlots struct {
+	sem  chan struct{}
+	refs int // holders and waiters; the entry is dropped at zero
+}
+
+// NewHostLimiter creates a HostLimiter allowing limit probes per IP
+func NewHostLimiter(limit int) *HostLimiter {
+	if limit <= 0 {
+		limit = 1
+	}
+	return &HostLimiter{limit: limit, hosts: make(map[string]*hostSlots)}
+}
+
+// Acquire waits for a probe slot on ip. It returns false without a slot
+// if ctx is cancelled first.
+func (l *HostLimiter) Acquire(ctx context.Context, ip string) bool {
+	l.mu.Lock()
+	slots := l.hosts[ip]
+	if slots == nil {
+		slots = &hostSlots{sem: make(chan struct{}, l.limit)}
+		l.hosts[ip] = slots
+	}
+	slots.refs++
+	l.mu.Unlock()
+
+	select {
+	case slots.sem <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		l.unref(ip, slots)
+		return false
+	}
+}
+
+// Release frees a slot taken by Acquire
+func (l *HostLimiter) Release(ip string) {
+	l.mu.Lock()
+	slots := l.hosts[ip]
+	l.mu.Unlock()
+	<-slots.sem
+	l.unref(ip, slots)
+}
+
+func (l *HostLimiter) unref(ip string, slots *hostSlots) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if slots.refs--; slots.refs == 0 {
+		delete(l.hosts, ip)
+	}
+}
+
+// FingerprintAll fingerprints targets with at most concurrency probes in
+// flight, and with a limiter at most its limit per IP, calling emit as each
+// completes. It stops dispatching new targets once ctx is cancelled and
+// returns after all started probes finish.
+func FingerprintAll(ctx context.Context, fp HostFingerprinter, targets []ZmapResult, concurrency int, limiter *HostLimiter, emit FingerprintEmitFunc) {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	var emitMu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, concurrency)
+
+	for _, target := range targets {
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		sem <- struct{}{} // acquire
+
+		go func(t ZmapResult) {
+			defer wg.Done()
+			defer func() { <-sem }() // release
+
+			if limiter != nil {
+				if !limiter.Acquire(ctx, t.IP) {
+					return
+				}
+				defer limiter.Release(t.IP)
+			}
+
+			info := fp.FingerprintHost(ctx, t.IP, []int{t.Port})[t.Port]
+
+			emitMu.Lock()
+			emit(t, info)
+			emitMu.Unlock()
+		}(target)
+	}
+
+	wg.Wait()
+}
@@ -0,0 +1,188 @@
+package scanner
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slowFingerprinter holds each probe for delay and records the most probes
+// in flight at once, overall and per IP
+type slowFingerprinter struct {
+	delay time.Duration
+
+	mu         sync.Mutex
+	inFlight   int
+	maxFlight  int
+	perHost    map[string]int
+	maxPerHost int
+}
+
+func (f *slowFingerprinter) FingerprintHost(ctx context.Context, ip string, ports []int) map[int]ServiceInfo {
+	f.mu.Lock()
+	if f.perHost == nil {
+		f.perHost = make(map[string]int)
+	}
+	f.inFlight++
+	f.perHost[ip]++
+	f.maxFlight = max(f.maxFlight, f.inFlight)
+	f.maxPerHost = max(f.maxPerHost, f.perHost[ip])
+	f.mu.Unlock()
+
+	time.Sleep(f.delay)
+
+	f.mu.Lock()
+	f.inFlight--
+	f.perHost[ip]--
+	f.mu.Unlock()
+
+	results := make(map[int]ServiceInfo, len(ports))
+	for _, port := range ports {
+		results[port] = ServiceInfo{ServiceName: fmt.Sprintf("svc-%s-%d", ip, port)}
+	}
+	return results
+}
+
+// stubHosts returns one target per host, n hosts on port
+func stubHosts(n, port int) []ZmapResult {
+	targets := make([]ZmapResult, n)
+	for i := range targets {
+		targets[i] = ZmapResult{IP: fmt.Sprintf("10.0.%d.%d", i/250, i%250+1), Port: port}
+	}
+	return targets
+}
+
+func TestFingerprintAllBoundedConcurrency(t *testing.T) {
+	fp := &slowFingerprinter{delay: 5 * time.Millisecond}
+	targets := stubHosts(200, 22)
+
+	emitted := make(map[string]string)
+	FingerprintAll(context.Background(), fp, targets, 8, nil, func(target ZmapResult, info ServiceInfo) {
+		emitted[target.IP] = info.ServiceName
+	})
+
+	if fp.maxFlight > 8 {
+		t.Errorf("%d probes in flight at once, want at most 8", fp.maxFlight)
+	}
+	if fp.maxFlight < 2 {
+		t.Errorf("at most %d probe in flight; fingerprinting was not concurrent", fp.maxFlight)
+	}
+	if len(emitted) != len(targets) {
+		t.Fatalf("emitted %d of %d targets", len(emitted), len(targets))
+	}
+	for _, target := range targets {
+		if want := fmt.Sprintf("svc-%s-22", target.IP); emitted[target.IP] != want {
+			t.Errorf("%s = %q, want %q", target.IP, emitted[target.IP], want)
+		}
+	}
+}
+
+func TestFingerprintAllStopsWhenCancelled(t *testing.T) {
+	fp := &slowFingerprinter{delay: 20 * time.Millisecond}
+	ctx, cancel := context.WithCancel(context.Background())
+	var mu sync.Mutex
+	emitted := 0
+	FingerprintAll(ctx, fp, stubHosts(100, 22), 4, nil, func(ZmapResult, ServiceInfo) {
+		mu.Lock()
+		defer mu.Unlock()
+		if emitted++; emitted == 4 {
+			cancel()
+		}
+	})
+	if emitted >= 100 {
+		t.Errorf("emitted all %d targets after cancellation", emitted)
+	}
+}
+
+// interleavedTargets lists ports 1..perHost on n hosts, host by host for
+// each port, so every host has probes queued throughout
+func interleavedTargets(n, perHost int) []ZmapResult {
+	var targets []ZmapResult
+	for port := 1; port <= perHost; port++ {
+		targets = append(targets, stubHosts(n, port)...)
+	}
+	return targets
+}
+
+func TestFingerprintAllPerHostLimit(t *testing.T) {
+	fp := &slowFingerprinter{delay: 5 * time.Millisecond}
+	targets := interleavedTargets(20, 5)
+	limiter := NewHostLimiter(2)
+
+	emitted := 0
+	FingerprintAll(context.Background(), fp, targets, 16, limiter, func(ZmapResult, ServiceInfo) {
+		emitted++
+	})
+
+	if fp.maxPerHost > 2 {
+		t.Errorf("%d probes in flight against one host, want at most 2", fp.maxPerHost)
+	}
+	if fp.maxFlight < 8 {
+		t.Errorf("at most %d probes in flight overall, want the per-host limit to leave concurrency high", fp.maxFlight)
+	}
+	if fp.maxFlight > 16 {
+		t.Errorf("%d probes in flight at once, want at most 16", fp.maxFlight)
+	}
+	if emitted != len(targets) {
+		t.Errorf("emitted %d of %d targets", emitted, len(targets))
+	}
+	if len(limiter.hosts) != 0 {
+		t.Errorf("limiter still tracks %d hosts after every probe finished", len(limiter.hosts))
+	}
+}
+
+func TestFingerprintAllSingleHostLimit(t *testing.T) {
+	// Every target on one host: the limit holds even with all workers on it
+	fp := &slowFingerprinter{delay: 2 * time.Millisecond}
+	targets := make([]ZmapResult, 50)
+	for i := range targets {
+		targets[i] = ZmapResult{IP: "10.0.0.1", Port: i + 1}
+	}
+	FingerprintAll(context.Background(), fp, targets, 32, NewHostLimiter(3), func(ZmapResult, ServiceInfo) {})
+	if fp.maxPerHost > 3 {
+		t.Errorf("%d probes in flight against 10.0.0.1, want at most 3", fp.maxPerHost)
+	}
+}
+
+func TestHostLimiterSharedAcrossCalls(t *testing.T) {
+	fp := &slowFingerprinter{delay: 5 * time.Millisecond}
+	limiter := NewHostLimiter(1)
+
+	var wg sync.WaitGroup
+	for range 3 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			FingerprintAll(context.Background(), fp, interleavedTargets(4, 4), 8, limiter, func(ZmapResult, ServiceInfo) {})
+		}()
+	}
+	wg.Wait()
+	if fp.maxPerHost > 1 {
+		t.Errorf("%d probes in flight against one host across calls, want at most 1", fp.maxPerHost)
+	}
+}
+
+func TestHostLimiterAcquireCancelled(t *testing.T) {
+	limiter := NewHostLimiter(1)
+	if !limiter.Acquire(context.Background(), "10.0.0.1") {
+		t.Fatal("first Acquire failed")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if limiter.Acquire(ctx, "10.0.0.1") {
+		t.Fatal("second Acquire succeeded past the limit")
+	}
+	// Other hosts are unaffected
+	if !limiter.Acquire(context.Background(), "10.0.0.2") {
+		t.Fatal("Acquire on another host failed")
+	}
+
+	limiter.Release("10.0.0.1")
+	limiter.Release("10.0.0.2")
+	if len(limiter.hosts) != 0 {
+		t.Errorf("limiter still tracks %v after every slot was released", limiter.hosts)
+	}
+}
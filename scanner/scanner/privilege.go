@@ -0,0 +1,15 @@
+package scanner
+
+// capNetRaw is CAP_NET_RAW's bit in Linux capability sets
+const capNetRaw = 13
+
+// checkPrivileges runs PrivilegeCheck, or ZmapPrivileges when it is unset,
+// before any zmap run starts, so a scan without raw socket access fails
+// once with a clear reason instead of once per network and port
+func (z *ZmapScanner) checkPrivileges() error {
+	check := z.PrivilegeCheck
+	if check == nil {
+		check = ZmapPrivileges
+	}
+	return check()
+}
@@ -0,0 +1,106 @@
+package scanner
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// ZmapPrivileges reports whether zmap will be able to open raw sockets:
+// the scanner runs as root with CAP_NET_RAW, holds CAP_NET_RAW as an
+// ambient capability zmap inherits, or the zmap binary carries it as a
+// file capability or is setuid root. Otherwise it returns an error
+// wrapping ErrInsufficientPrivileges that says what is missing.
+func ZmapPrivileges() error {
+	return currentZmapPrivileges().check()
+}
+
+// zmapPrivileges is what ZmapPrivileges decides from
+type zmapPrivileges struct {
+	euid           int
+	capEff, capAmb uint64 // capability sets, each used only when known
+	effKnown       bool
+	ambKnown       bool
+	zmapPath       string // "" when zmap isn't on PATH
+	zmapSetuid     bool   // zmapPath is setuid root
+	zmapFileCaps   uint64 // zmapPath's permitted file capabilities
+}
+
+// currentZmapPrivileges reads this process's and the zmap binary's
+// privileges
+func currentZmapPrivileges() zmapPrivileges {
+	p := zmapPrivileges{euid: os.Geteuid()}
+	eff, err := procCapabilities("CapEff")
+	p.capEff, p.effKnown = eff, err == nil
+	amb, err := procCapabilities("CapAmb")
+	p.capAmb, p.ambKnown = amb, err == nil
+	if path, err := exec.LookPath("zmap"); err == nil {
+		p.zmapPath = path
+		p.zmapSetuid = setuidRoot(path)
+		p.zmapFileCaps = fileCapabilities(path)
+	}
+	return p
+}
+
+func (p zmapPrivileges) check() error {
+	if p.euid == 0 {
+		// Root in a container can still have CAP_NET_RAW dropped
+		if p.effKnown && p.capEff&(1<<capNetRaw) == 0 {
+			return fmt.Errorf("%w: running as root without CAP_NET_RAW; zmap needs it (e.g. docker run --cap-add NET_RAW)", ErrInsufficientPrivileges)
+		}
+		return nil
+	}
+	if p.ambKnown && p.capAmb&(1<<capNetRaw) != 0 {
+		return nil
+	}
+	if p.zmapPath == "" {
+		// The zmap run reports a missing binary as ErrBinaryNotFound
+		return nil
+	}
+	if p.zmapSetuid || p.zmapFileCaps&(1<<capNetRaw) != 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: zmap needs root or CAP_NET_RAW, but the scanner runs as uid %d and %s has no cap_net_raw file capability; grant it with setcap cap_net_raw,cap_net_admin=eip %s",
+		ErrInsufficientPrivileges, p.euid, p.zmapPath, p.zmapPath)
+}
+
+// procCapabilities reads one capability set (CapEff, CapAmb, ...) of this
+// process from /proc/self/status
+func procCapabilities(name string) (uint64, error) {
+	status, err := os.ReadFile("/proc/self/status")
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range strings.Split(string(status), "\n") {
+		if value, ok := strings.CutPrefix(line, name+":"); ok {
+			return strconv.ParseUint(strings.TrimSpace(value), 16, 64)
+		}
+	}
+	return 0, fmt.Errorf("%s not in /proc/self/status", name)
+}
+
+// fileCapabilities returns the low 32 bits of path's permitted file
+// capabilities (security.capability, struct vfs_cap_data), 0 when it has
+// none
+func fileCapabilities(path string) uint64 {
+	buf := make([]byte, 24)
+	n, err := syscall.Getxattr(path, "security.capability", buf)
+	if err != nil || n < 8 {
+		return 0
+	}
+	return uint64(binary.LittleEndian.Uint32(buf[4:8]))
+}
+
+// setuidRoot reports whether path is a setuid binary owned by root
+func setuidRoot(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil || info.Mode()&os.ModeSetuid == 0 {
+		return false
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	return ok && st.Uid == 0
+}
@@ -0,0 +1,69 @@
+package scanner
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestZmapPrivilegesCheck(t *testing.T) {
+	const raw = 1 << capNetRaw
+	const zmap = "/usr/sbin/zmap"
+	tests := []struct {
+		name string
+		p    zmapPrivileges
+		hint string // in the error; "" for no error
+	}{
+		{"root", zmapPrivileges{euid: 0, capEff: 0x3fffffffff, effKnown: true}, ""},
+		{"root without CAP_NET_RAW", zmapPrivileges{euid: 0, capEff: 0x3fffffffff &^ raw, effKnown: true}, "--cap-add NET_RAW"},
+		{"root, capabilities unreadable", zmapPrivileges{euid: 0}, ""},
+		{"ambient CAP_NET_RAW", zmapPrivileges{euid: 1000, capAmb: raw, ambKnown: true, zmapPath: zmap}, ""},
+		{"setuid zmap", zmapPrivileges{euid: 1000, ambKnown: true, zmapPath: zmap, zmapSetuid: true}, ""},
+		{"zmap file capability", zmapPrivileges{euid: 1000, ambKnown: true, zmapPath: zmap, zmapFileCaps: raw | 1<<12}, ""},
+		{"zmap missing", zmapPrivileges{euid: 1000, ambKnown: true}, ""},
+		{"unprivileged", zmapPrivileges{euid: 1000, ambKnown: true, zmapPath: zmap, zmapFileCaps: 1 << 12}, "setcap cap_net_raw,cap_net_admin=eip " + zmap},
+		{"unprivileged, capabilities unreadable", zmapPrivileges{euid: 1000, capAmb: raw, zmapPath: zmap}, "uid 1000"},
+	}
+	for _, tt := range tests {
+		err := tt.p.check()
+		if tt.hint == "" {
+			if err != nil {
+				t.Errorf("%s: %v, want zmap allowed", tt.name, err)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrInsufficientPrivileges) || !strings.Contains(err.Error(), tt.hint) {
+			t.Errorf("%s: %v, want ErrInsufficientPrivileges mentioning %q", tt.name, err, tt.hint)
+		}
+	}
+}
+
+func TestCurrentZmapPrivileges(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("PATH", dir)
+	if p := currentZmapPrivileges(); p.euid != os.Geteuid() || !p.effKnown || !p.ambKnown || p.zmapPath != "" {
+		t.Errorf("without zmap: %+v", p)
+	}
+
+	// A plain executable: no setuid bit, no file capabilities
+	path := filepath.Join(dir, "zmap")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if p := currentZmapPrivileges(); p.zmapPath != path || p.zmapSetuid || p.zmapFileCaps != 0 {
+		t.Errorf("plain zmap: %+v", p)
+	}
+
+	if os.Geteuid() != 0 {
+		return
+	}
+	// Owned by root, as the tests run
+	if err := os.Chmod(path, 0o755|os.ModeSetuid); err != nil {
+		t.Fatal(err)
+	}
+	if p := currentZmapPrivileges(); !p.zmapSetuid {
+		t.Errorf("setuid root zmap: %+v", p)
+	}
+}
@@ -0,0 +1,70 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestZmapPrivilegeCheckStubbed(t *testing.T) {
+	denied := fmt.Errorf("%w: no CAP_NET_RAW", ErrInsufficientPrivileges)
+	scans := map[string]func(z *ZmapScanner) error{
+		"ScanPort": func(z *ZmapScanner) error {
+			_, err := z.ScanPort(context.Background(), 22)
+			return err
+		},
+		"ScanPortsWithCallback": func(z *ZmapScanner) error {
+			_, err := z.ScanPortsWithCallback(context.Background(), []int{22, 80}, func(int, []ZmapResult) {})
+			return err
+		},
+		"ScanAllPortsWithCallback": func(z *ZmapScanner) error {
+			_, err := z.ScanAllPortsWithCallback(context.Background(), func(int, []ZmapResult) {})
+			return err
+		},
+	}
+	for name, scan := range scans {
+		for _, privileged := range []bool{true, false} {
+			if privileged && name == "ScanAllPortsWithCallback" {
+				continue // a zmap run per port of all 65535
+			}
+			calls := fakeZmap(t, "saddr\n10.0.0.1\n")
+			z := testZmapScanner("10.0.0.0/24")
+			checks := 0
+			z.PrivilegeCheck = func() error {
+				checks++
+				if privileged {
+					return nil
+				}
+				return denied
+			}
+
+			err := scan(z)
+			ran, _ := os.ReadFile(calls)
+			if checks != 1 {
+				t.Errorf("%s privileged=%v: checked %d times, want once up front", name, privileged, checks)
+			}
+			if privileged {
+				if err != nil || len(ran) == 0 {
+					t.Errorf("%s privileged: %v, zmap ran %q", name, err, ran)
+				}
+				continue
+			}
+			if err != denied {
+				t.Errorf("%s unprivileged: %v, want the check's error", name, err)
+			}
+			if len(ran) != 0 {
+				t.Errorf("%s unprivileged: zmap launched %d times", name, strings.Count(string(ran), "\n"))
+			}
+		}
+	}
+
+	// Unset, ZmapPrivileges decides
+	fakeZmap(t, "saddr\n10.0.0.1\n")
+	_, err := NewZmapScanner([]string{"10.0.0.0/24"}, 0, 1).ScanPort(context.Background(), 22)
+	if want := ZmapPrivileges() != nil; errors.Is(err, ErrInsufficientPrivileges) != want {
+		t.Errorf("default check: ScanPort = %v, want refused %v", err, want)
+	}
+}
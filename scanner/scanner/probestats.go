@@ -0,0 +1,100 @@
+package scanner
+
+import (
+	"log"
+	"sort"
+	"sync"
+)
+
+// ProbeCounts are the outcomes of fingerprinting ports of one service
+type ProbeCounts struct {
+	Attempts int64 `json:"attempts"`
+	Banner   int64 `json:"banner"`   // a banner or response was captured
+	Version  int64 `json:"version"`  // a service version was extracted
+	Fallback int64 `json:"fallback"` // nothing identified the service; it is named after the port
+}
+
+// ProbeStats counts probe outcomes per service name across one scan, to
+// show which probes earn their keep. A nil *ProbeStats counts nothing.
+type ProbeStats struct {
+	mu       sync.Mutex
+	services map[string]*ProbeCounts
+}
+
+// NewProbeStats creates empty probe statistics
+func NewProbeStats() *ProbeStats {
+	return &ProbeStats{services: make(map[string]*ProbeCounts)}
+}
+
+// Reset starts a new scan's counts
+func (s *ProbeStats) Reset() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.services = make(map[string]*ProbeCounts)
+}
+
+// Snapshot returns a copy of the counts by service name
+func (s *ProbeStats) Snapshot() map[string]ProbeCounts {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snapshot := make(map[string]ProbeCounts, len(s.services))
+	for service, counts := range s.services {
+		snapshot[service] = *counts
+	}
+	return snapshot
+}
+
+// record counts one fingerprinted port. fallback reports that the service
+// name came from the port number alone.
+func (s *ProbeStats) record(info ServiceInfo, fallback bool) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counts := s.services[info.ServiceName]
+	if counts == nil {
+		counts = &ProbeCounts{}
+		s.services[info.ServiceName] = counts
+	}
+	counts.Attempts++
+	if info.Banner != "" {
+		counts.Banner++
+	}
+	if info.ServiceVersion != "" {
+		counts.Version++
+	}
+	if fallback {
+		counts.Fallback++
+	}
+}
+
+// Log adds the counts to the scan summary, most attempted service first
+func (s *ProbeStats) Log() {
+	snapshot := s.Snapshot()
+	if len(snapshot) == 0 {
+		return
+	}
+	services := make([]string, 0, len(snapshot))
+	for service := range snapshot {
+		services = append(services, service)
+	}
+	sort.Slice(services, func(i, j int) bool {
+		a, b := snapshot[services[i]], snapshot[services[j]]
+		if a.Attempts != b.Attempts {
+			return a.Attempts > b.Attempts
+		}
+		return services[i] < services[j]
+	})
+	log.Printf("Probe outcomes (attempts / banner / version / port-name fallback):")
+	for _, service := range services {
+		c := snapshot[service]
+		log.Printf("  %s: %d / %d / %d / %d", service, c.Attempts, c.Banner, c.Version, c.Fallback)
+	}
+}
@@ -0,0 +1,129 @@
+package scanner
+
+import (
+	"context"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// serveOn runs handle for every connection to ip:port
+func serveOn(t *testing.T, ip string, port int, handle func(conn net.Conn)) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", net.JoinHostPort(ip, strconv.Itoa(port)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				handle(conn)
+			}()
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestProbeStatsCountsOutcomes(t *testing.T) {
+	const ip = "127.0.0.11"
+	// On their own ports, SSH and FTP get their protocol probes and a
+	// version; elsewhere a banner is all there is
+	serveOn(t, ip, 22, func(conn net.Conn) { io.WriteString(conn, sshBanner+"\r\n") })
+	serveOn(t, ip, 21, func(conn net.Conn) {
+		io.WriteString(conn, "220 (vsFTPd 3.0.3)\r\n")
+		io.Copy(io.Discard, conn)
+	})
+	sshElsewhere := serveOn(t, ip, 0, func(conn net.Conn) { io.WriteString(conn, sshBanner+"\r\n") })
+	hello := serveOn(t, ip, 0, func(conn net.Conn) { io.WriteString(conn, "Welcome to the device\r\n") })
+	silent := serveOn(t, ip, 0, func(conn net.Conn) { io.Copy(io.Discard, conn) })
+	closed := closedPort(t)
+
+	f := testFingerprinter()
+	f.Timeout = 300 * time.Millisecond
+	f.ProbeStats = NewProbeStats()
+	results := f.FingerprintHost(context.Background(), ip, []int{22, 21, sshElsewhere, hello, silent, closed})
+	if len(results) != 6 {
+		t.Fatalf("%d results, want 6", len(results))
+	}
+
+	want := map[string]ProbeCounts{
+		"ssh":     {Attempts: 2, Banner: 2, Version: 1},
+		"ftp":     {Attempts: 1, Banner: 1, Version: 1},
+		"unknown": {Attempts: 3, Banner: 1, Fallback: 3},
+	}
+	if got := f.ProbeStats.Snapshot(); !reflect.DeepEqual(got, want) {
+		for port, info := range results {
+			t.Logf("port %d: %q %q %q", port, info.ServiceName, info.ServiceVersion, info.Banner)
+		}
+		t.Errorf("counts = %+v, want %+v", got, want)
+	}
+
+	// The next scan starts from nothing
+	f.ProbeStats.Reset()
+	f.FingerprintHost(context.Background(), ip, []int{silent})
+	if got := f.ProbeStats.Snapshot(); !reflect.DeepEqual(got, map[string]ProbeCounts{"unknown": {Attempts: 1, Fallback: 1}}) {
+		t.Errorf("after Reset: counts = %+v", got)
+	}
+}
+
+func TestProbeStatsZgrab(t *testing.T) {
+	z, _ := cannedZgrab(t, cannedZgrabSSH)
+	z.Fallback.ProbeStats = NewProbeStats()
+	z.Fallback.Timeout = 300 * time.Millisecond
+	z.fingerprintPort(context.Background(), "127.0.0.1", 22)
+
+	// Ports zgrab2 hands to the native probes are counted once, there
+	port := closedPort(t)
+	z.Fallback.ProbeSequence = map[int][]string{port: {"http"}}
+	z.fingerprintPort(context.Background(), "127.0.0.1", port)
+
+	got := z.Fallback.ProbeStats.Snapshot()
+	if got["ssh"] != (ProbeCounts{Attempts: 1, Banner: 1, Version: 1}) {
+		t.Errorf("zgrab2 ssh counts = %+v", got["ssh"])
+	}
+	var attempts int64
+	for _, counts := range got {
+		attempts += counts.Attempts
+	}
+	if attempts != 2 {
+		t.Errorf("%d attempts for 2 ports: %+v", attempts, got)
+	}
+}
+
+func TestProbeStatsNil(t *testing.T) {
+	var stats *ProbeStats
+	stats.record(ServiceInfo{ServiceName: "ssh"}, false)
+	stats.Reset()
+	stats.Log()
+	if stats.Snapshot() != nil {
+		t.Error("nil stats counted")
+	}
+}
+
+func TestProbeStatsConcurrent(t *testing.T) {
+	stats := NewProbeStats()
+	done := make(chan struct{})
+	for i := 0; i < 8; i++ {
+		go func() {
+			for j := 0; j < 100; j++ {
+				stats.record(ServiceInfo{ServiceName: "http", Banner: "HTTP/1.1 200 OK"}, false)
+			}
+			done <- struct{}{}
+		}()
+	}
+	for i := 0; i < 8; i++ {
+		<-done
+	}
+	if got := stats.Snapshot()["http"]; got != (ProbeCounts{Attempts: 800, Banner: 800}) {
+		t.Errorf("counts = %+v, want 800 of each", got)
+	}
+}
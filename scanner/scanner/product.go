@@ -0,0 +1,134 @@
+package scanner
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// knownProduct maps a name as servers announce it to CPE vendor and
+// product names
+type knownProduct struct {
+	name    string // as it appears in Server headers and banners
+	vendor  string
+	product string
+}
+
+var knownProducts = []knownProduct{
+	// HTTP servers and frameworks
+	{"Apache-Coyote", "apache", "coyote_http_connector"},
+	{"Apache", "apache", "http_server"},
+	{"nginx", "f5", "nginx"},
+	{"openresty", "openresty", "openresty"},
+	{"Tengine", "alibaba", "tengine"},
+	{"Microsoft-IIS", "microsoft", "internet_information_services"},
+	{"lighttpd", "lighttpd", "lighttpd"},
+	{"LiteSpeed", "litespeedtech", "litespeed_web_server"},
+	{"Caddy", "caddyserver", "caddy"},
+	{"Jetty", "eclipse", "jetty"},
+	{"gunicorn", "gunicorn", "gunicorn"},
+	{"Werkzeug", "palletsprojects", "werkzeug"},
+	{"TornadoServer", "tornadoweb", "tornado"},
+	{"CherryPy", "cherrypy", "cherrypy"},
+	{"envoy", "envoyproxy", "envoy"},
+	{"Kestrel", "microsoft", "kestrel"},
+
+	// SSH
+	{"OpenSSH", "openbsd", "openssh"},
+	{"dropbear", "dropbear_ssh_project", "dropbear_ssh"},
+
+	// FTP
+	{"ProFTPD", "proftpd", "proftpd"},
+	{"vsFTPd", "beasts", "vsftpd"},
+	{"Pure-FTPd", "pureftpd", "pure-ftpd"},
+	{"FileZilla Server", "filezilla-project", "filezilla_server"},
+
+	// Mail
+	{"Postfix", "postfix", "postfix"},
+	{"Exim", "exim", "exim"},
+	{"Sendmail", "sendmail", "sendmail"},
+	{"Dovecot", "dovecot", "dovecot"},
+}
+
+// productRe finds the first known product name, standing alone or
+// followed by its version in the usual forms: "Apache/2.4.52",
+// "OpenSSH_8.9p1", "Jetty(9.4.43.v20210629)" or "ProFTPD 1.3.5"
+var productRe = func() *regexp.Regexp {
+	names := make([]string, len(knownProducts))
+	for i, p := range knownProducts {
+		names[i] = regexp.QuoteMeta(p.name)
+	}
+	// Longest first, so Apache-Coyote wins over Apache
+	sort.SliceStable(names, func(i, j int) bool { return len(names[i]) > len(names[j]) })
+	return regexp.MustCompile(`(?i)(?:^|[^\w.-])(` + strings.Join(names, "|") + `)(?:[/_ (]v?(\d[\w.~+-]*)|[\s);,\]]|$)`)
+}()
+
+// sshPrefixRe and replyCodeRe strip the "SSH-2.0-" identification prefix
+// and FTP/SMTP reply codes from banners
+var (
+	sshPrefixRe = regexp.MustCompile(`^SSH-[\d.]+-`)
+	replyCodeRe = regexp.MustCompile(`^\d{3}[ -]`)
+)
+
+// parseProduct splits a server string such as "Apache/2.4.52 (Ubuntu)" or
+// "OpenSSH_8.9p1 Ubuntu-3ubuntu0.1" into vendor, product, version and
+// whatever follows (extra). It returns nil for software it doesn't know.
+func parseProduct(s string) map[string]interface{} {
+	s = strings.TrimSpace(s)
+	s = sshPrefixRe.ReplaceAllString(s, "")
+	s = replyCodeRe.ReplaceAllString(s, "")
+
+	m := productRe.FindStringSubmatchIndex(s)
+	if m == nil {
+		return nil
+	}
+	name := s[m[2]:m[3]]
+	var known knownProduct
+	for _, p := range knownProducts {
+		if strings.EqualFold(p.name, name) {
+			known = p
+			break
+		}
+	}
+
+	product := map[string]interface{}{
+		"vendor":  known.vendor,
+		"product": known.product,
+	}
+	if m[4] >= 0 {
+		product["version"] = strings.TrimRight(s[m[4]:m[5]], ".-")
+	}
+	if extra := productExtra(s[m[1]:]); extra != "" {
+		product["extra"] = extra
+	}
+	return product
+}
+
+// productExtra tidies what follows the version: the closing parenthesis of
+// "(vsFTPd 3.0.3)" is dropped, and a lone "(Ubuntu)" unwrapped
+func productExtra(rest string) string {
+	rest = strings.TrimSpace(strings.TrimLeft(rest, ") "))
+	if strings.HasPrefix(rest, "(") && strings.Index(rest, ")") == len(rest)-1 {
+		rest = strings.TrimSpace(rest[1 : len(rest)-1])
+	}
+	return rest
+}
+
+// markProduct records Fingerprint["product"] from the service version
+// string, or else the banner's first line (FTP and mail banners name the
+// software there while ServiceVersion keeps only the number)
+func markProduct(info *ServiceInfo) {
+	product := parseProduct(info.ServiceVersion)
+	if product == nil && info.Banner != "" {
+		// sanitizeBanner turns CRLF into two spaces
+		line, _, _ := strings.Cut(info.Banner, "  ")
+		product = parseProduct(line)
+	}
+	if product == nil {
+		return
+	}
+	if info.Fingerprint == nil {
+		info.Fingerprint = make(map[string]interface{})
+	}
+	info.Fingerprint["product"] = product
+}
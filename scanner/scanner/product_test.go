@@ -0,0 +1,98 @@
+package scanner
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+)
+
+// product builds the expected Fingerprint["product"], leaving out empty
+// version and extra as parseProduct does
+func product(vendor, name, version, extra string) map[string]interface{} {
+	p := map[string]interface{}{"vendor": vendor, "product": name}
+	if version != "" {
+		p["version"] = version
+	}
+	if extra != "" {
+		p["extra"] = extra
+	}
+	return p
+}
+
+func TestParseProduct(t *testing.T) {
+	tests := []struct {
+		in   string
+		want map[string]interface{}
+	}{
+		// Server headers
+		{"Apache/2.4.52 (Ubuntu)", product("apache", "http_server", "2.4.52", "Ubuntu")},
+		{"Apache/2.4.6 (CentOS) OpenSSL/1.0.2k-fips PHP/7.4.33", product("apache", "http_server", "2.4.6", "(CentOS) OpenSSL/1.0.2k-fips PHP/7.4.33")},
+		{"Apache", product("apache", "http_server", "", "")},
+		{"Apache-Coyote/1.1", product("apache", "coyote_http_connector", "1.1", "")},
+		{"nginx/1.18.0 (Ubuntu)", product("f5", "nginx", "1.18.0", "Ubuntu")},
+		{"nginx", product("f5", "nginx", "", "")},
+		{"openresty/1.21.4.1", product("openresty", "openresty", "1.21.4.1", "")},
+		{"Microsoft-IIS/10.0", product("microsoft", "internet_information_services", "10.0", "")},
+		{"lighttpd/1.4.59", product("lighttpd", "lighttpd", "1.4.59", "")},
+		{"LiteSpeed", product("litespeedtech", "litespeed_web_server", "", "")},
+		{"Jetty(9.4.43.v20210629)", product("eclipse", "jetty", "9.4.43.v20210629", "")},
+		{"gunicorn/20.1.0", product("gunicorn", "gunicorn", "20.1.0", "")},
+		{"Werkzeug/2.0.3 Python/3.8.10", product("palletsprojects", "werkzeug", "2.0.3", "Python/3.8.10")},
+		{"Caddy", product("caddyserver", "caddy", "", "")},
+		{"Microsoft-HTTPAPI/2.0", nil},
+
+		// Banners
+		{"SSH-2.0-OpenSSH_8.9p1 Ubuntu-3ubuntu0.1", product("openbsd", "openssh", "8.9p1", "Ubuntu-3ubuntu0.1")},
+		{"SSH-2.0-OpenSSH_7.4", product("openbsd", "openssh", "7.4", "")},
+		{"SSH-2.0-dropbear_2020.81", product("dropbear_ssh_project", "dropbear_ssh", "2020.81", "")},
+		{"220 ProFTPD 1.3.5e Server (Debian) [::ffff:10.0.0.5]", product("proftpd", "proftpd", "1.3.5e", "Server (Debian) [::ffff:10.0.0.5]")},
+		{"220 (vsFTPd 3.0.3)", product("beasts", "vsftpd", "3.0.3", "")},
+		{"220-FileZilla Server 0.9.60 beta", product("filezilla-project", "filezilla_server", "0.9.60", "beta")},
+		{"220 mail.example.com ESMTP Postfix (Ubuntu)", product("postfix", "postfix", "", "Ubuntu")},
+		{"220 mx.example.org ESMTP Exim 4.94.2 Tue, 10 Mar 2026 09:00:00 +0000", product("exim", "exim", "4.94.2", "Tue, 10 Mar 2026 09:00:00 +0000")},
+		{"* OK [CAPABILITY IMAP4rev1] Dovecot (Ubuntu) ready.", product("dovecot", "dovecot", "", "(Ubuntu) ready.")},
+
+		// A known name only counts as a whole word
+		{"myapache/1.0", nil},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		if got := parseProduct(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseProduct(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestMarkProduct(t *testing.T) {
+	// The version string wins over the banner
+	info := ServiceInfo{ServiceVersion: "nginx/1.24.0", Banner: "HTTP/1.1 200 OK  Server: nginx/1.24.0"}
+	markProduct(&info)
+	if got := info.Fingerprint["product"]; !reflect.DeepEqual(got, product("f5", "nginx", "1.24.0", "")) {
+		t.Errorf("from version: product = %v", got)
+	}
+
+	// FTP keeps only the number as its version, so the banner's first line
+	// names the software
+	info = ServiceInfo{ServiceVersion: "1.3.5e", Banner: "220 ProFTPD 1.3.5e Server (Debian)  "}
+	markProduct(&info)
+	if got := info.Fingerprint["product"]; !reflect.DeepEqual(got, product("proftpd", "proftpd", "1.3.5e", "Server (Debian)")) {
+		t.Errorf("from banner: product = %v", got)
+	}
+
+	info = ServiceInfo{ServiceVersion: "1.0", Banner: "220 Welcome  vsFTPd 3.0.3"}
+	markProduct(&info)
+	if _, ok := info.Fingerprint["product"]; ok || info.Fingerprint != nil {
+		t.Errorf("unknown software: fingerprint = %v, want no product", info.Fingerprint)
+	}
+}
+
+func TestFingerprintHostProduct(t *testing.T) {
+	ip, port := stubServer(t, func(conn net.Conn) {
+		conn.Write([]byte(sshBanner + "\r\n"))
+	})
+	info := testFingerprinter().FingerprintHost(context.Background(), ip, []int{port})[port]
+	if got := info.Fingerprint["product"]; !reflect.DeepEqual(got, product("openbsd", "openssh", "9.6p1", "Ubuntu-3ubuntu13")) {
+		t.Errorf("product = %v from banner %q", got, info.Banner)
+	}
+}
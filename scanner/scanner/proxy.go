@@ -0,0 +1,155 @@
+package scanner
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// proxyPorts are ports commonly used by forward proxies
+var proxyPorts = map[int]bool{
+	1080: true, // SOCKS
+	3128: true, // Squid
+	8080: true, // HTTP proxy / alt HTTP
+}
+
+// checkOpenProxy asks the service to tunnel to ProxyTestTarget and sets
+// Fingerprint["open_proxy"] = true when it agrees. Only the tunnel is set up;
+// no payload is ever relayed through it.
+func (f *Fingerprinter) checkOpenProxy(ip string, port int, info *ServiceInfo) {
+	if f.ProxyTestTarget == "" || !proxyPorts[port] {
+		return
+	}
+
+	var open bool
+	if port == 1080 {
+		open = f.socksConnects(ip, port)
+	} else {
+		open = f.httpConnects(ip, port)
+	}
+
+	if open {
+		if info.Fingerprint == nil {
+			info.Fingerprint = make(map[string]interface{})
+		}
+		info.Fingerprint["open_proxy"] = true
+		info.Fingerprint["proxy_test_target"] = f.ProxyTestTarget
+	}
+}
+
+// httpConnects reports whether an HTTP CONNECT to ProxyTestTarget succeeds
+func (f *Fingerprinter) httpConnects(ip string, port int) bool {
+	address := net.JoinHostPort(ip, strconv.Itoa(port))
+
+	conn, err := f.dial(address)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(f.Timeout))
+
+	request := fmt.Sprintf("CONNECT %s HTTP/1.1\r\nHost: %s\r\nUser-Agent: NetworkScanner/1.0\r\n\r\n", f.ProxyTestTarget, f.ProxyTestTarget)
+	if _, err := conn.Write([]byte(request)); err != nil {
+		return false
+	}
+
+	status, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return false
+	}
+
+	// "HTTP/1.1 200 Connection established"
+	fields := strings.Fields(status)
+	return len(fields) >= 2 && strings.HasPrefix(fields[0], "HTTP/") && fields[1] == "200"
+}
+
+// socksConnects reports whether an unauthenticated SOCKS5 CONNECT to
+// ProxyTestTarget succeeds
+func (f *Fingerprinter) socksConnects(ip string, port int) bool {
+	host, portStr, err := net.SplitHostPort(f.ProxyTestTarget)
+	if err != nil {
+		return false
+	}
+	targetPort, err := strconv.Atoi(portStr)
+	if err != nil || len(host) > 255 {
+		return false
+	}
+
+	address := net.JoinHostPort(ip, strconv.Itoa(port))
+	conn, err := f.dial(address)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(f.Timeout))
+
+	// Greeting: version 5, one method, "no authentication"
+	if _, err := conn.Write([]byte{0x05, 0x01, 0x00}); err != nil {
+		return false
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil || reply[0] != 0x05 || reply[1] != 0x00 {
+		return false
+	}
+
+	// CONNECT request using a domain-name address
+	req := []byte{0x05, 0x01, 0x00, 0x03, byte(len(host))}
+	req = append(req, host...)
+	req = append(req, byte(targetPort>>8), byte(targetPort))
+	if _, err := conn.Write(req); err != nil {
+		return false
+	}
+
+	// Reply: VER REP RSV ATYP ...; REP 0x00 means the tunnel is up
+	resp := make([]byte, 4)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return false
+	}
+	return resp[0] == 0x05 && resp[1] == 0x00
+}
+
+// probeSOCKS identifies a SOCKS server from its method-selection reply
+func (f *Fingerprinter) probeSOCKS(ip string, port int) ServiceInfo {
+	var info ServiceInfo
+	address := net.JoinHostPort(ip, strconv.Itoa(port))
+
+	conn, err := f.dial(address)
+	if err != nil {
+		return info
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(f.Timeout))
+
+	// Offer "no authentication" and "username/password"
+	if _, err := conn.Write([]byte{0x05, 0x02, 0x00, 0x02}); err != nil {
+		return info
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil || reply[0] != 0x05 {
+		return info
+	}
+
+	info.ServiceName = "socks5"
+	info.Fingerprint = make(map[string]interface{})
+	switch reply[1] {
+	case 0x00:
+		info.Banner = "SOCKS5 (no authentication)"
+		info.Fingerprint["auth_required"] = false
+	case 0x02:
+		info.Banner = "SOCKS5 (username/password)"
+		info.Fingerprint["auth_required"] = true
+	default:
+		info.Banner = "SOCKS5"
+		info.Fingerprint["auth_required"] = true
+	}
+
+	return info
+}
@@ -0,0 +1,132 @@
+package scanner
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+)
+
+// httpProxyStub answers CONNECT requests with status, recording the
+// requested target
+func httpProxyStub(t *testing.T, status string, targets chan<- string) (string, int) {
+	return stubServer(t, func(conn net.Conn) {
+		req, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil {
+			return
+		}
+		targets <- req.Method + " " + req.Host
+		io.WriteString(conn, "HTTP/1.1 "+status+"\r\n\r\n")
+	})
+}
+
+// socksStub answers a SOCKS5 greeting with method and, for no
+// authentication, a CONNECT with reply
+func socksStub(t *testing.T, method, reply byte, targets chan<- string) (string, int) {
+	return stubServer(t, func(conn net.Conn) {
+		greeting := make([]byte, 2)
+		if _, err := io.ReadFull(conn, greeting); err != nil {
+			return
+		}
+		io.CopyN(io.Discard, conn, int64(greeting[1]))
+		conn.Write([]byte{0x05, method})
+		if method != 0x00 {
+			return
+		}
+		header := make([]byte, 5)
+		if _, err := io.ReadFull(conn, header); err != nil || header[3] != 0x03 {
+			return
+		}
+		host := make([]byte, int(header[4])+2)
+		if _, err := io.ReadFull(conn, host); err != nil {
+			return
+		}
+		targets <- string(host[:len(host)-2])
+		conn.Write([]byte{0x05, reply, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+	})
+}
+
+func TestHTTPConnects(t *testing.T) {
+	f := testFingerprinter()
+	f.ProxyTestTarget = "proxy-check.internal:443"
+
+	targets := make(chan string, 1)
+	ip, port := httpProxyStub(t, "200 Connection established", targets)
+	if !f.httpConnects(ip, port) {
+		t.Errorf("httpConnects = false for a proxy that tunnels")
+	}
+	if got := <-targets; got != "CONNECT proxy-check.internal:443" {
+		t.Errorf("proxy asked for %q, want the configured test target", got)
+	}
+
+	ip, port = httpProxyStub(t, "403 Forbidden", targets)
+	if f.httpConnects(ip, port) {
+		t.Errorf("httpConnects = true for a proxy that refuses")
+	}
+
+	// A plain web server answers CONNECT with an error
+	ip, port = stubServer(t, func(conn net.Conn) {
+		bufio.NewReader(conn).ReadString('\n')
+		io.WriteString(conn, "HTTP/1.1 405 Method Not Allowed\r\n\r\n")
+	})
+	if f.httpConnects(ip, port) {
+		t.Errorf("httpConnects = true for a web server")
+	}
+}
+
+func TestSOCKSConnects(t *testing.T) {
+	f := testFingerprinter()
+	f.ProxyTestTarget = "proxy-check.internal:443"
+
+	targets := make(chan string, 1)
+	ip, port := socksStub(t, 0x00, 0x00, targets)
+	if !f.socksConnects(ip, port) {
+		t.Errorf("socksConnects = false for an open SOCKS5 proxy")
+	}
+	if got := <-targets; got != "proxy-check.internal" {
+		t.Errorf("proxy asked for %q, want the configured test target", got)
+	}
+
+	ip, port = socksStub(t, 0x00, 0x02, targets) // connection not allowed by ruleset
+	if f.socksConnects(ip, port) {
+		t.Errorf("socksConnects = true when the CONNECT is refused")
+	}
+
+	ip, port = socksStub(t, 0xff, 0x00, targets) // no acceptable methods
+	if f.socksConnects(ip, port) {
+		t.Errorf("socksConnects = true for a proxy requiring authentication")
+	}
+}
+
+func TestCheckOpenProxy(t *testing.T) {
+	// Ports not used by proxies are never checked
+	f := testFingerprinter()
+	f.ProxyTestTarget = "proxy-check.internal:443"
+	var info ServiceInfo
+	f.checkOpenProxy("127.0.0.1", 22, &info)
+	if info.Fingerprint != nil {
+		t.Errorf("checkOpenProxy on port 22 = %v, want no check", info.Fingerprint)
+	}
+
+	// Without a test target nothing is dialed
+	f.ProxyTestTarget = ""
+	f.checkOpenProxy("127.0.0.1", 3128, &info)
+	if info.Fingerprint != nil {
+		t.Errorf("checkOpenProxy without a test target = %v", info.Fingerprint)
+	}
+}
+
+func TestProbeSOCKS(t *testing.T) {
+	f := testFingerprinter()
+	for method, wantAuth := range map[byte]bool{0x00: false, 0x02: true} {
+		ip, port := stubServer(t, func(conn net.Conn) {
+			io.ReadFull(conn, make([]byte, 4))
+			conn.Write([]byte{0x05, method})
+		})
+		info := f.probeSOCKS(ip, port)
+		if info.ServiceName != "socks5" || info.Fingerprint["auth_required"] != wantAuth {
+			t.Errorf("probeSOCKS with method %#x = %+v, want socks5 auth_required=%v", method, info, wantAuth)
+		}
+	}
+}
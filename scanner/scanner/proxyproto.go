@@ -0,0 +1,95 @@
+package scanner
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+// proxyV2Signature starts every PROXY protocol v2 header
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyProtocol returns the PROXY protocol version sent ahead of probes to
+// port, or 0 for none
+func (f *Fingerprinter) proxyProtocol(port int) int {
+	return f.ProxyProtocolPorts[port]
+}
+
+// sendProxyHeader writes the PROXY protocol header for the connection's own
+// addresses when address's port is configured for one, so a backend behind
+// a PROXY-protocol load balancer answers the probe that follows. The
+// connection is closed if the header can't be written.
+func (f *Fingerprinter) sendProxyHeader(conn net.Conn, address string) (net.Conn, error) {
+	_, portStr, _ := net.SplitHostPort(address)
+	port, _ := strconv.Atoi(portStr)
+	version := f.proxyProtocol(port)
+	if version == 0 {
+		return conn, nil
+	}
+
+	header, err := proxyHeader(version, conn.LocalAddr(), conn.RemoteAddr())
+	if err == nil {
+		conn.SetWriteDeadline(time.Now().Add(f.Timeout))
+		_, err = conn.Write(header)
+		conn.SetWriteDeadline(time.Time{})
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to send PROXY header: %w", err)
+	}
+	return conn, nil
+}
+
+// proxyHeader builds a PROXY protocol v1 (text) or v2 (binary) header
+// describing a TCP connection from src to dst
+func proxyHeader(version int, src, dst net.Addr) ([]byte, error) {
+	srcTCP, ok1 := src.(*net.TCPAddr)
+	dstTCP, ok2 := dst.(*net.TCPAddr)
+	if !ok1 || !ok2 {
+		return nil, fmt.Errorf("PROXY header needs TCP addresses, got %s and %s", src, dst)
+	}
+
+	srcIP, dstIP := srcTCP.IP.To4(), dstTCP.IP.To4()
+	ipv6 := srcIP == nil || dstIP == nil
+	if ipv6 {
+		srcIP, dstIP = srcTCP.IP.To16(), dstTCP.IP.To16()
+	}
+
+	switch version {
+	case 1:
+		family := "TCP4"
+		if ipv6 {
+			family = "TCP6"
+		}
+		return fmt.Appendf(nil, "PROXY %s %s %s %d %d\r\n", family, srcIP, dstIP, srcTCP.Port, dstTCP.Port), nil
+	case 2:
+		family := byte(0x11) // AF_INET, STREAM
+		if ipv6 {
+			family = 0x21 // AF_INET6, STREAM
+		}
+		header := append([]byte(nil), proxyV2Signature...)
+		header = append(header, 0x21, family) // version 2, PROXY command
+		header = binary.BigEndian.AppendUint16(header, uint16(2*len(srcIP)+4))
+		header = append(header, srcIP...)
+		header = append(header, dstIP...)
+		header = binary.BigEndian.AppendUint16(header, uint16(srcTCP.Port))
+		header = binary.BigEndian.AppendUint16(header, uint16(dstTCP.Port))
+		return header, nil
+	}
+	return nil, fmt.Errorf("unsupported PROXY protocol version %d", version)
+}
+
+// markProxyProtocol records Fingerprint["proxy_protocol"] ("v1" or "v2")
+// on ports probed behind a PROXY header
+func (f *Fingerprinter) markProxyProtocol(port int, info *ServiceInfo) {
+	version := f.proxyProtocol(port)
+	if version == 0 {
+		return
+	}
+	if info.Fingerprint == nil {
+		info.Fingerprint = make(map[string]interface{})
+	}
+	info.Fingerprint["proxy_protocol"] = fmt.Sprintf("v%d", version)
+}
@@ -0,0 +1,162 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sshBanner is what the backend behind the stub load balancer sends
+const sshBanner = "SSH-2.0-OpenSSH_9.6p1 Ubuntu-3ubuntu13"
+
+// readProxyHeader reads a PROXY v1 or v2 header the way a backend that
+// requires one does, and returns the source and destination it describes
+func readProxyHeader(r *bufio.Reader) (version int, src, dst string, err error) {
+	start, err := r.Peek(len(proxyV2Signature))
+	if err != nil {
+		return 0, "", "", err
+	}
+	if bytes.Equal(start, proxyV2Signature) {
+		fixed := make([]byte, 16)
+		if _, err := io.ReadFull(r, fixed); err != nil {
+			return 0, "", "", err
+		}
+		if fixed[12] != 0x21 || fixed[13] != 0x11 && fixed[13] != 0x21 {
+			return 0, "", "", fmt.Errorf("v2 command %#x family %#x", fixed[12], fixed[13])
+		}
+		addrs := make([]byte, binary.BigEndian.Uint16(fixed[14:]))
+		if _, err := io.ReadFull(r, addrs); err != nil {
+			return 0, "", "", err
+		}
+		n := (len(addrs) - 4) / 2
+		srcIP, dstIP, ports := net.IP(addrs[:n]), net.IP(addrs[n:2*n]), addrs[2*n:]
+		src = net.JoinHostPort(srcIP.String(), fmt.Sprint(binary.BigEndian.Uint16(ports)))
+		dst = net.JoinHostPort(dstIP.String(), fmt.Sprint(binary.BigEndian.Uint16(ports[2:])))
+		return 2, src, dst, nil
+	}
+
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return 0, "", "", err
+	}
+	fields := strings.Fields(line)
+	if len(fields) != 6 || fields[0] != "PROXY" || fields[1] != "TCP4" && fields[1] != "TCP6" || !strings.HasSuffix(line, "\r\n") {
+		return 0, "", "", fmt.Errorf("not a PROXY v1 header: %q", line)
+	}
+	return 1, net.JoinHostPort(fields[2], fields[4]), net.JoinHostPort(fields[3], fields[5]), nil
+}
+
+// proxiedHeader is a header a proxiedSSH backend received
+type proxiedHeader struct {
+	version  int
+	src, dst string
+	local    string // the backend's own end of the connection
+	remote   string // the prober's end
+}
+
+// proxiedSSH is an SSH server behind a PROXY-protocol load balancer: it
+// sends its banner only after a valid header, and otherwise drops the
+// connection silently. Each header it accepts is sent on the channel.
+func proxiedSSH(t *testing.T) (string, int, chan proxiedHeader) {
+	t.Helper()
+	headers := make(chan proxiedHeader, 16)
+	ip, port := stubServer(t, func(conn net.Conn) {
+		conn.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+		version, src, dst, err := readProxyHeader(bufio.NewReader(conn))
+		if err != nil {
+			return
+		}
+		headers <- proxiedHeader{version, src, dst, conn.LocalAddr().String(), conn.RemoteAddr().String()}
+		conn.Write([]byte(sshBanner + "\r\n"))
+	})
+	return ip, port, headers
+}
+
+func TestFingerprintBehindProxyProtocol(t *testing.T) {
+	for _, version := range []int{1, 2} {
+		ip, port, headers := proxiedSSH(t)
+		f := testFingerprinter()
+		f.ProxyProtocolPorts = map[int]int{port: version}
+		info := f.FingerprintHost(context.Background(), ip, []int{port})[port]
+		if !strings.HasPrefix(info.Banner, sshBanner) {
+			t.Errorf("v%d: banner = %q, want the backend's", version, info.Banner)
+		}
+		if got := info.Fingerprint["proxy_protocol"]; got != fmt.Sprintf("v%d", version) {
+			t.Errorf("v%d: proxy_protocol = %v", version, got)
+		}
+
+		// The header describes the prober's own connection
+		select {
+		case h := <-headers:
+			if h.version != version || h.src != h.remote || h.dst != h.local {
+				t.Errorf("v%d: header %+v, want version %d from the prober to the backend", version, h, version)
+			}
+		default:
+			t.Errorf("v%d: backend got no header", version)
+		}
+	}
+}
+
+func TestFingerprintWithoutProxyProtocol(t *testing.T) {
+	ip, port, headers := proxiedSSH(t)
+	f := testFingerprinter()
+	f.Timeout = time.Second
+	f.ProxyProtocolPorts = map[int]int{port + 1: 1} // another port
+	info := f.FingerprintHost(context.Background(), ip, []int{port})[port]
+	if strings.Contains(info.Banner, "SSH") {
+		t.Errorf("banner = %q without a PROXY header, want the backend to stay silent", info.Banner)
+	}
+	if _, ok := info.Fingerprint["proxy_protocol"]; ok {
+		t.Errorf("fingerprint = %v, want proxy_protocol unset", info.Fingerprint)
+	}
+	if len(headers) != 0 {
+		t.Errorf("backend got a header on an unconfigured port")
+	}
+}
+
+func TestProxyHeader(t *testing.T) {
+	src4 := &net.TCPAddr{IP: net.ParseIP("192.0.2.10"), Port: 51234}
+	dst4 := &net.TCPAddr{IP: net.ParseIP("198.51.100.7"), Port: 22}
+	src6 := &net.TCPAddr{IP: net.ParseIP("2001:db8::10"), Port: 51234}
+	dst6 := &net.TCPAddr{IP: net.ParseIP("2001:db8::7"), Port: 443}
+
+	header, err := proxyHeader(1, src4, dst4)
+	if err != nil || string(header) != "PROXY TCP4 192.0.2.10 198.51.100.7 51234 22\r\n" {
+		t.Errorf("v1 IPv4 header = %q, %v", header, err)
+	}
+	header, err = proxyHeader(1, src6, dst6)
+	if err != nil || string(header) != "PROXY TCP6 2001:db8::10 2001:db8::7 51234 443\r\n" {
+		t.Errorf("v1 IPv6 header = %q, %v", header, err)
+	}
+
+	header, err = proxyHeader(2, src4, dst4)
+	want := append(append([]byte(nil), proxyV2Signature...),
+		0x21, 0x11, 0, 12,
+		192, 0, 2, 10, 198, 51, 100, 7,
+		0xc8, 0x22, 0, 22)
+	if err != nil || !bytes.Equal(header, want) {
+		t.Errorf("v2 IPv4 header = %x, %v, want %x", header, err, want)
+	}
+	header, err = proxyHeader(2, src6, dst6)
+	if err != nil || len(header) != 16+36 || header[13] != 0x21 {
+		t.Errorf("v2 IPv6 header = %x, %v", header, err)
+	}
+	version, src, dst, err := readProxyHeader(bufio.NewReader(bytes.NewReader(header)))
+	if err != nil || version != 2 || src != src6.String() || dst != dst6.String() {
+		t.Errorf("v2 IPv6 header parsed as %d %s %s, %v", version, src, dst, err)
+	}
+
+	if _, err := proxyHeader(3, src4, dst4); err == nil {
+		t.Error("version 3 accepted")
+	}
+	if _, err := proxyHeader(1, &net.UDPAddr{IP: src4.IP, Port: 53}, dst4); err == nil {
+		t.Error("UDP source accepted")
+	}
+}
@@ -0,0 +1,30 @@
+package scanner
+
+import (
+	"net"
+	"time"
+)
+
+// Goodbye commands sent before closing when GracefulClose is set
+const (
+	quitLine  = "QUIT\r\n"             // SMTP, FTP, POP3
+	imapQuit  = "q LOGOUT\r\n"         // IMAP has no QUIT
+	redisQuit = "*1\r\n$4\r\nQUIT\r\n" // RESP-encoded QUIT
+)
+
+// quit ends a session politely: it sends command and reads the server's
+// reply (or waits for it to hang up) so the server logs an orderly QUIT
+// instead of a dropped connection. It does nothing unless GracefulClose is
+// set, and the whole exchange is bounded by Timeout. The caller still
+// closes conn.
+func (f *Fingerprinter) quit(conn net.Conn, command string) {
+	if !f.GracefulClose {
+		return
+	}
+	conn.SetDeadline(time.Now().Add(f.Timeout))
+	if _, err := conn.Write([]byte(command)); err != nil {
+		return
+	}
+	buf := make([]byte, 512)
+	conn.Read(buf)
+}
@@ -0,0 +1,129 @@
+package scanner
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sessionStub greets, answers each line it receives from replies (nothing
+// when absent) and records the lines, returning its address and the lines
+// received over every connection so far
+func sessionStub(t *testing.T, greeting string, replies map[string]string) (string, int, func() []string) {
+	t.Helper()
+	var mu sync.Mutex
+	var received []string
+	ip, port := stubServer(t, func(conn net.Conn) {
+		conn.Write([]byte(greeting))
+		reader := bufio.NewReader(conn)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			mu.Lock()
+			received = append(received, line)
+			mu.Unlock()
+			if reply, ok := replies[line]; ok {
+				conn.Write([]byte(reply))
+			}
+		}
+	})
+	return ip, port, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), received...)
+	}
+}
+
+// gracefulSessions are the probes that say goodbye, with a server that
+// offers no STARTTLS
+var gracefulSessions = []struct {
+	name     string
+	probe    func(f *Fingerprinter, ip string, port int) ServiceInfo
+	greeting string
+	replies  map[string]string
+	quit     string // the last line sent when GracefulClose is set
+}{
+	{"ftp", (*Fingerprinter).probeFTP, "220 ProFTPD 1.3.8 Server\r\n",
+		map[string]string{"QUIT": "221 Goodbye.\r\n"}, "QUIT"},
+	{"smtp", (*Fingerprinter).probeSMTP, "220 mail.example ESMTP Postfix\r\n",
+		map[string]string{"EHLO scanner.local": "250-mail.example\r\n250 8BITMIME\r\n", "QUIT": "221 2.0.0 Bye\r\n"}, "QUIT"},
+	{"pop3", (*Fingerprinter).probePOP3, "+OK Dovecot ready.\r\n",
+		map[string]string{"CAPA": "+OK\r\nUSER\r\n.\r\n", "QUIT": "+OK Logging out.\r\n"}, "QUIT"},
+	{"imap", (*Fingerprinter).probeIMAP, "* OK [CAPABILITY IMAP4rev1] Dovecot ready.\r\n",
+		map[string]string{"c CAPABILITY": "* CAPABILITY IMAP4rev1\r\nc OK done\r\n", "q LOGOUT": "* BYE\r\nq OK Logout completed.\r\n"}, "q LOGOUT"},
+	{"redis", (*Fingerprinter).probeRedis, "",
+		map[string]string{"PING": "+PONG\r\n", "INFO": "$24\r\n# Server\r\nredis_version:7.2.4\r\n", "QUIT": "+OK\r\n"}, "QUIT"},
+}
+
+func TestGracefulCloseSendsQuit(t *testing.T) {
+	for _, tc := range gracefulSessions {
+		ip, port, received := sessionStub(t, tc.greeting, tc.replies)
+		f := testFingerprinter()
+		f.GracefulClose = true
+
+		if info := tc.probe(f, ip, port); info.Banner == "" {
+			t.Errorf("%s: no banner", tc.name)
+		}
+		if !eventually(func() bool {
+			lines := received()
+			return len(lines) > 0 && lines[len(lines)-1] == tc.quit
+		}) {
+			t.Errorf("%s: last line sent = %q, want %q", tc.name, received(), tc.quit)
+		}
+		if lines := received(); strings.Count(strings.Join(lines, "\n"), tc.quit) != 1 {
+			t.Errorf("%s: sent %q, want %q once", tc.name, lines, tc.quit)
+		}
+	}
+}
+
+func TestNoQuitWithoutGracefulClose(t *testing.T) {
+	for _, tc := range gracefulSessions {
+		ip, port, received := sessionStub(t, tc.greeting, tc.replies)
+		f := testFingerprinter()
+		tc.probe(f, ip, port)
+
+		// Give a stray QUIT time to arrive before checking
+		time.Sleep(20 * time.Millisecond)
+		for _, line := range received() {
+			if line == tc.quit {
+				t.Errorf("%s: sent %q with GracefulClose off", tc.name, tc.quit)
+			}
+		}
+	}
+}
+
+func TestGracefulCloseBoundedByTimeout(t *testing.T) {
+	// The server never answers QUIT
+	ip, port, received := sessionStub(t, "220 ProFTPD 1.3.8 Server\r\n", nil)
+	f := testFingerprinter()
+	f.GracefulClose = true
+	f.Timeout = 200 * time.Millisecond
+
+	start := time.Now()
+	f.probeFTP(ip, port)
+	if elapsed := time.Since(start); elapsed > 3*f.Timeout {
+		t.Errorf("probe took %v against a silent QUIT, want about the %v timeout", elapsed, f.Timeout)
+	}
+	if !eventually(func() bool { return len(received()) == 1 && received()[0] == "QUIT" }) {
+		t.Errorf("sent %q, want QUIT", received())
+	}
+}
+
+func TestNoQuitWithoutBanner(t *testing.T) {
+	// A silent port isn't a session to end
+	ip, port, received := sessionStub(t, "", nil)
+	f := testFingerprinter()
+	f.GracefulClose = true
+	f.Timeout = 100 * time.Millisecond
+	f.probeFTP(ip, port)
+	time.Sleep(20 * time.Millisecond)
+	if lines := received(); len(lines) != 0 {
+		t.Errorf("sent %q to a silent server", lines)
+	}
+}
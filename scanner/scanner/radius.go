@@ -0,0 +1,155 @@
+package scanner
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"math/rand/v2"
+)
+
+// RADIUS packet codes (RFC 2865, RFC 2866)
+const (
+	radiusAccessRequest      = 1
+	radiusAccessAccept       = 2
+	radiusAccessReject       = 3
+	radiusAccountingRequest  = 4
+	radiusAccountingResponse = 5
+	radiusAccessChallenge    = 11
+)
+
+// RADIUS attribute types used by the probes
+const (
+	radiusAttrUserName             = 1
+	radiusAttrUserPassword         = 2
+	radiusAttrAcctStatusType       = 40
+	radiusAttrAcctSessionID        = 44
+	radiusAttrMessageAuthenticator = 80
+)
+
+// radiusProbeSecret is the shared secret the probes sign with. A server
+// won't know it, so it answers with a reject, or drops the request if it
+// ignores unknown clients.
+const radiusProbeSecret = "network-scanner"
+
+// radiusCodeNames names the reply codes a RADIUS server may send
+var radiusCodeNames = map[byte]string{
+	radiusAccessAccept:       "Access-Accept",
+	radiusAccessReject:       "Access-Reject",
+	radiusAccountingResponse: "Accounting-Response",
+	radiusAccessChallenge:    "Access-Challenge",
+}
+
+// radiusAttribute encodes one type-length-value attribute
+func radiusAttribute(typ byte, value []byte) []byte {
+	return append([]byte{typ, byte(2 + len(value))}, value...)
+}
+
+// radiusPacket assembles a packet with its length filled in
+func radiusPacket(code, id byte, authenticator [16]byte, attrs ...[]byte) []byte {
+	packet := []byte{code, id, 0, 0}
+	packet = append(packet, authenticator[:]...)
+	for _, attr := range attrs {
+		packet = append(packet, attr...)
+	}
+	binary.BigEndian.PutUint16(packet[2:4], uint16(len(packet)))
+	return packet
+}
+
+// buildRADIUSAccessRequest encodes an Access-Request for a dummy user,
+// with the password hidden and a Message-Authenticator under secret, which
+// servers hardened against BlastRADIUS require
+func buildRADIUSAccessRequest(id byte, authenticator [16]byte, secret string) []byte {
+	// User-Password (RFC 2865 5.2): one block XORed with MD5(secret + RA)
+	password := make([]byte, 16)
+	copy(password, "probe")
+	pad := md5.Sum(append([]byte(secret), authenticator[:]...))
+	for i := range password {
+		password[i] ^= pad[i]
+	}
+
+	packet := radiusPacket(radiusAccessRequest, id, authenticator,
+		radiusAttribute(radiusAttrUserName, []byte("probe")),
+		radiusAttribute(radiusAttrUserPassword, password),
+		radiusAttribute(radiusAttrMessageAuthenticator, make([]byte, md5.Size)),
+	)
+
+	// HMAC-MD5 over the packet with the attribute zeroed, written in place
+	mac := hmac.New(md5.New, []byte(secret))
+	mac.Write(packet)
+	copy(packet[len(packet)-md5.Size:], mac.Sum(nil))
+	return packet
+}
+
+// buildRADIUSAccountingRequest encodes an Accounting-Request Start. Its
+// authenticator is MD5(packet with zero authenticator + secret) (RFC 2866).
+func buildRADIUSAccountingRequest(id byte, secret string) []byte {
+	sessionID := fmt.Sprintf("probe-%08x", rand.Uint32())
+	packet := radiusPacket(radiusAccountingRequest, id, [16]byte{},
+		radiusAttribute(radiusAttrAcctStatusType, []byte{0, 0, 0, 1}), // Start
+		radiusAttribute(radiusAttrAcctSessionID, []byte(sessionID)),
+		radiusAttribute(radiusAttrUserName, []byte("probe")),
+	)
+	sum := md5.Sum(append(append([]byte(nil), packet...), secret...))
+	copy(packet[4:20], sum[:])
+	return packet
+}
+
+// parseRADIUSReply validates a reply to the request with identifier id and
+// returns its code's name
+func parseRADIUSReply(reply []byte, id byte) (string, error) {
+	if len(reply) < 20 {
+		return "", fmt.Errorf("short RADIUS packet")
+	}
+	length := int(binary.BigEndian.Uint16(reply[2:4]))
+	if length < 20 || length > len(reply) {
+		return "", fmt.Errorf("invalid RADIUS length %d", length)
+	}
+	if reply[1] != id {
+		return "", fmt.Errorf("RADIUS identifier mismatch")
+	}
+	name, ok := radiusCodeNames[reply[0]]
+	if !ok {
+		return "", fmt.Errorf("unexpected RADIUS code %d", reply[0])
+	}
+	return name, nil
+}
+
+// probeRADIUS sends an Access-Request (authentication port) or an
+// Accounting-Request (accounting port) signed with a dummy secret. Any
+// well-formed reply, usually Access-Reject, identifies a RADIUS server.
+func (f *Fingerprinter) probeRADIUS(ip string, port int) (ServiceInfo, error) {
+	var info ServiceInfo
+
+	id := byte(rand.IntN(256))
+	accounting := port == 1813
+	var request []byte
+	if accounting {
+		request = buildRADIUSAccountingRequest(id, radiusProbeSecret)
+	} else {
+		var authenticator [16]byte
+		binary.BigEndian.PutUint64(authenticator[:8], rand.Uint64())
+		binary.BigEndian.PutUint64(authenticator[8:], rand.Uint64())
+		request = buildRADIUSAccessRequest(id, authenticator, radiusProbeSecret)
+	}
+
+	reply, err := f.udpExchange(ip, port, request)
+	if err != nil {
+		return info, err
+	}
+	code, err := parseRADIUSReply(reply, id)
+	if err != nil {
+		return info, errUnrecognizedReply
+	}
+
+	info.ServiceName = "radius"
+	if accounting {
+		info.ServiceName = "radius-acct"
+	}
+	info.Banner = "RADIUS " + code
+	info.Fingerprint = map[string]interface{}{
+		"radius_response": true,
+		"radius_code":     code,
+	}
+	return info, nil
+}
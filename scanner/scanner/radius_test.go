@@ -0,0 +1,131 @@
+package scanner
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+)
+
+// radiusAttributes splits a packet's attributes by type
+func radiusAttributes(packet []byte) map[byte][]byte {
+	attrs := make(map[byte][]byte)
+	for rest := packet[20:]; len(rest) >= 2 && int(rest[1]) <= len(rest); rest = rest[rest[1]:] {
+		attrs[rest[0]] = rest[2:rest[1]]
+	}
+	return attrs
+}
+
+// cannedAccessReject is an Access-Reject as a server sends for a client
+// whose secret it doesn't know
+func cannedAccessReject(id byte) []byte {
+	return radiusPacket(radiusAccessReject, id, [16]byte{0xde, 0xad, 0xbe, 0xef},
+		radiusAttribute(18, []byte("Authentication failed"))) // Reply-Message
+}
+
+func TestProbeRADIUSAccessReject(t *testing.T) {
+	// The stub replies from its own goroutine; keep the first request
+	requests := make(chan []byte, 1)
+	port := udpStub(t, func(req []byte) []byte {
+		select {
+		case requests <- req:
+		default:
+		}
+		return cannedAccessReject(req[1])
+	})
+
+	info, err := testFingerprinter().probeRADIUS("127.0.0.1", port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ServiceName != "radius" || info.Banner != "RADIUS Access-Reject" ||
+		info.Fingerprint["radius_response"] != true || info.Fingerprint["radius_code"] != "Access-Reject" {
+		t.Errorf("probeRADIUS = %+v", info)
+	}
+
+	// The request is a well-formed Access-Request under the probe secret
+	request := <-requests
+	if request[0] != radiusAccessRequest || int(binary.BigEndian.Uint16(request[2:4])) != len(request) {
+		t.Fatalf("request header % x, want an Access-Request with its length", request[:4])
+	}
+	attrs := radiusAttributes(request)
+	if string(attrs[radiusAttrUserName]) != "probe" {
+		t.Errorf("User-Name = %q, want probe", attrs[radiusAttrUserName])
+	}
+	pad := md5.Sum(append([]byte(radiusProbeSecret), request[4:20]...))
+	password := make([]byte, len(attrs[radiusAttrUserPassword]))
+	for i := range password {
+		password[i] = attrs[radiusAttrUserPassword][i] ^ pad[i]
+	}
+	if !bytes.Equal(bytes.TrimRight(password, "\x00"), []byte("probe")) {
+		t.Errorf("User-Password decodes to %q, want probe", password)
+	}
+	signed := append([]byte(nil), request...)
+	copy(signed[len(signed)-md5.Size:], make([]byte, md5.Size))
+	mac := hmac.New(md5.New, []byte(radiusProbeSecret))
+	mac.Write(signed)
+	if !hmac.Equal(attrs[radiusAttrMessageAuthenticator], mac.Sum(nil)) {
+		t.Error("Message-Authenticator does not verify under the probe secret")
+	}
+}
+
+func TestProbeRADIUSUnrecognized(t *testing.T) {
+	for name, reply := range map[string]func(req []byte) []byte{
+		"wrong identifier": func(req []byte) []byte { return cannedAccessReject(req[1] + 1) },
+		"unknown code":     func(req []byte) []byte { return radiusPacket(99, req[1], [16]byte{}) },
+		"not RADIUS":       func([]byte) []byte { return []byte("hello") },
+	} {
+		port := udpStub(t, reply)
+		if _, err := testFingerprinter().probeRADIUS("127.0.0.1", port); !errors.Is(err, errUnrecognizedReply) {
+			t.Errorf("%s: err = %v, want errUnrecognizedReply", name, err)
+		}
+	}
+}
+
+func TestProbeRADIUSNoReply(t *testing.T) {
+	// A server that drops requests from unknown clients gives no answer
+	port := udpStub(t, func([]byte) []byte { return nil })
+	f := testFingerprinter()
+	f.Timeout = 200 * time.Millisecond
+	info, err := f.probeRADIUS("127.0.0.1", port)
+	if err == nil || info.ServiceName != "" {
+		t.Errorf("probeRADIUS = %+v, %v, want an error and no service", info, err)
+	}
+}
+
+func TestBuildRADIUSAccountingRequest(t *testing.T) {
+	packet := buildRADIUSAccountingRequest(7, radiusProbeSecret)
+	if packet[0] != radiusAccountingRequest || packet[1] != 7 || int(binary.BigEndian.Uint16(packet[2:4])) != len(packet) {
+		t.Fatalf("header % x, want Accounting-Request 7 with its length", packet[:4])
+	}
+	if status := radiusAttributes(packet)[radiusAttrAcctStatusType]; !bytes.Equal(status, []byte{0, 0, 0, 1}) {
+		t.Errorf("Acct-Status-Type = % x, want Start", status)
+	}
+	// The authenticator is MD5 over the packet with a zero authenticator
+	// followed by the secret
+	zeroed := append([]byte(nil), packet...)
+	copy(zeroed[4:20], make([]byte, 16))
+	if sum := md5.Sum(append(zeroed, radiusProbeSecret...)); !bytes.Equal(packet[4:20], sum[:]) {
+		t.Error("request authenticator does not verify under the probe secret")
+	}
+}
+
+func TestParseRADIUSReply(t *testing.T) {
+	reply := radiusPacket(radiusAccountingResponse, 3, [16]byte{})
+	if code, err := parseRADIUSReply(reply, 3); err != nil || code != "Accounting-Response" {
+		t.Errorf("parseRADIUSReply = %q, %v, want Accounting-Response", code, err)
+	}
+	short := append([]byte(nil), reply...)
+	binary.BigEndian.PutUint16(short[2:4], 40)
+	for name, bad := range map[string][]byte{
+		"short":          reply[:10],
+		"length too big": short,
+	} {
+		if _, err := parseRADIUSReply(bad, 3); err == nil {
+			t.Errorf("%s: parsed", name)
+		}
+	}
+}
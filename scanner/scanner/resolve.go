@@ -0,0 +1,146 @@
+package scanner
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultResolveTimeout bounds a lookup when the resolver has no timeout
+const DefaultResolveTimeout = 5 * time.Second
+
+// Resolver resolves hostname targets and reverse lookups. With a DoH URL
+// it queries that server over HTTPS first, for networks where outbound DNS
+// is blocked, falling back to the system resolver when the query fails.
+type Resolver struct {
+	DoHURL  string
+	Timeout time.Duration
+
+	client *http.Client
+	system *net.Resolver
+
+	mu    sync.Mutex
+	names map[string]string // reverse lookup cache, "" for no name
+}
+
+// NewResolver creates a Resolver; an empty dohURL uses only the system
+// resolver
+func NewResolver(dohURL string, timeout time.Duration) *Resolver {
+	if timeout <= 0 {
+		timeout = DefaultResolveTimeout
+	}
+	return &Resolver{
+		DoHURL:  dohURL,
+		Timeout: timeout,
+		client:  &http.Client{Timeout: timeout},
+		system:  net.DefaultResolver,
+		names:   make(map[string]string),
+	}
+}
+
+// LookupHost returns the IPv4 and IPv6 addresses of host
+func (r *Resolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	if r.DoHURL != "" {
+		addrs, err := r.dohHost(ctx, host)
+		if err == nil && len(addrs) > 0 {
+			return addrs, nil
+		}
+		if err != nil {
+			log.Printf("DoH lookup of %s failed, using system resolver: %v", host, err)
+		}
+	}
+	return r.system.LookupHost(ctx, host)
+}
+
+// dohHost asks the DoH server for A and then AAAA records
+func (r *Resolver) dohHost(ctx context.Context, host string) ([]string, error) {
+	var addrs []string
+	for _, qtype := range []uint16{dnsTypeA, dnsTypeAAAA} {
+		answers, err := dohQuery(ctx, r.client, r.DoHURL, host, qtype)
+		if err != nil {
+			return nil, err
+		}
+		addrs = append(addrs, answers...)
+	}
+	return addrs, nil
+}
+
+// LookupAddr returns the names ip reverse-resolves to, without the trailing
+// dot
+func (r *Resolver) LookupAddr(ctx context.Context, ip string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	if r.DoHURL != "" {
+		name, err := reverseName(ip)
+		if err != nil {
+			return nil, err
+		}
+		answers, err := dohQuery(ctx, r.client, r.DoHURL, name, dnsTypePTR)
+		if err == nil && len(answers) > 0 {
+			return trimDots(answers), nil
+		}
+		if err != nil {
+			log.Printf("DoH reverse lookup of %s failed, using system resolver: %v", ip, err)
+		}
+	}
+	names, err := r.system.LookupAddr(ctx, ip)
+	return trimDots(names), err
+}
+
+// Hostname returns the first reverse lookup name for ip, caching the
+// result, or "" when it has none
+func (r *Resolver) Hostname(ctx context.Context, ip string) string {
+	r.mu.Lock()
+	name, ok := r.names[ip]
+	r.mu.Unlock()
+	if ok {
+		return name
+	}
+
+	// Misses are cached too so a host isn't looked up for every port
+	if names, err := r.LookupAddr(ctx, ip); err == nil && len(names) > 0 {
+		name = names[0]
+	}
+	r.mu.Lock()
+	r.names[ip] = name
+	r.mu.Unlock()
+	return name
+}
+
+// reverseName builds the in-addr.arpa or ip6.arpa name for ip
+func reverseName(ip string) (string, error) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return "", fmt.Errorf("invalid IP %q", ip)
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa.", v4[3], v4[2], v4[1], v4[0]), nil
+	}
+
+	const hexDigits = "0123456789abcdef"
+	var b strings.Builder
+	for i := len(parsed) - 1; i >= 0; i-- {
+		b.WriteByte(hexDigits[parsed[i]&0x0f])
+		b.WriteByte('.')
+		b.WriteByte(hexDigits[parsed[i]>>4])
+		b.WriteByte('.')
+	}
+	b.WriteString("ip6.arpa.")
+	return b.String(), nil
+}
+
+func trimDots(names []string) []string {
+	for i, name := range names {
+		names[i] = strings.TrimSuffix(name, ".")
+	}
+	return names
+}
@@ -0,0 +1,141 @@
+package scanner
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+)
+
+// Scope restricts which IPs may be scanned. When Allow is non-empty it
+// defines the universe of scannable addresses; Exclude then carves addresses
+// out of whatever remains. A nil Scope allows everything.
+type Scope struct {
+	Allow   []*net.IPNet
+	Exclude []*net.IPNet
+}
+
+// NewScope parses allow and exclude CIDR lists. Bare IPs are treated as /32
+// (or /128 for IPv6).
+func NewScope(allow, exclude []string) (*Scope, error) {
+	s := &Scope{}
+	var err error
+	if s.Allow, err = parseNetworks(allow); err != nil {
+		return nil, fmt.Errorf("invalid allow_networks: %w", err)
+	}
+	if s.Exclude, err = parseNetworks(exclude); err != nil {
+		return nil, fmt.Errorf("invalid exclude_networks: %w", err)
+	}
+	return s, nil
+}
+
+func parseNetworks(cidrs []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", cidr)
+			}
+			if ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipnet)
+	}
+	return nets, nil
+}
+
+// Contains reports whether ip is inside the allowlist (if any) and not excluded
+func (s *Scope) Contains(ip net.IP) bool {
+	if s == nil {
+		return true
+	}
+	if len(s.Allow) > 0 && !anyContains(s.Allow, ip) {
+		return false
+	}
+	return !anyContains(s.Exclude, ip)
+}
+
+func anyContains(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Networks intersects target CIDRs with the allowlist, returning the CIDRs
+// that may be scanned. Exclusions are not applied here since they can split
+// a CIDR; use Contains per IP, or ExcludeFile for zmap.
+func (s *Scope) Networks(targets []string) []string {
+	if s == nil || len(s.Allow) == 0 {
+		return targets
+	}
+
+	var scoped []string
+	seen := make(map[string]bool)
+	for _, target := range targets {
+		_, tnet, err := net.ParseCIDR(target)
+		if err != nil {
+			log.Printf("Warning: failed to parse CIDR %s: %v", target, err)
+			continue
+		}
+		for _, allow := range s.Allow {
+			if n := intersectCIDR(tnet, allow); n != "" && !seen[n] {
+				seen[n] = true
+				scoped = append(scoped, n)
+			}
+		}
+	}
+	return scoped
+}
+
+// intersectCIDR returns the smaller of two CIDRs if one contains the other.
+// Two CIDRs either nest or are disjoint, so this is the full intersection.
+func intersectCIDR(a, b *net.IPNet) string {
+	aOnes, _ := a.Mask.Size()
+	bOnes, _ := b.Mask.Size()
+	switch {
+	case aOnes >= bOnes && b.Contains(a.IP):
+		return a.String()
+	case bOnes > aOnes && a.Contains(b.IP):
+		return b.String()
+	}
+	return ""
+}
+
+// ExcludeFile writes the exclusions to a temp file in zmap blacklist format
+// and returns its path, or "" when there is nothing to exclude. The caller
+// removes the file.
+func (s *Scope) ExcludeFile() (string, error) {
+	if s == nil || len(s.Exclude) == 0 {
+		return "", nil
+	}
+
+	f, err := os.CreateTemp("", "scanner-exclude-*.conf")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	for _, n := range s.Exclude {
+		// IPv6 exclusions are applied when IPv6 target files are written
+		if n.IP.To4() == nil {
+			continue
+		}
+		if _, err := fmt.Fprintln(f, n.String()); err != nil {
+			os.Remove(f.Name())
+			return "", err
+		}
+	}
+	return f.Name(), nil
+}
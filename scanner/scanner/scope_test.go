@@ -0,0 +1,97 @@
+package scanner
+
+import (
+	"net"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestScopeScannedSet(t *testing.T) {
+	scope, err := NewScope(
+		[]string{"10.0.0.0/29", "10.0.1.4/30", "192.168.5.5"},
+		[]string{"10.0.0.2", "10.0.0.4/31", "10.0.1.6"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	targets := []string{"10.0.0.0/24", "10.0.1.0/29", "172.16.0.0/30"}
+
+	if got, want := scope.Networks(targets), []string{"10.0.0.0/29", "10.0.1.4/30"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Networks = %v, want %v", got, want)
+	}
+
+	ips, err := scopedIPs(targets, scope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 10.0.0.0/29 hosts .1-.6 minus .2, .4 and .5; 10.0.1.4/30 hosts .5-.6
+	// minus .6; nothing from the target outside the allowlist
+	want := []string{"10.0.0.1", "10.0.0.3", "10.0.0.6", "10.0.1.5"}
+	if !reflect.DeepEqual(ips, want) {
+		t.Errorf("scanned set = %v, want %v", ips, want)
+	}
+}
+
+func TestScopeExcludeOnly(t *testing.T) {
+	scope, err := NewScope(nil, []string{"10.0.0.0/30"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ips, err := scopedIPs([]string{"10.0.0.0/29"}, scope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"10.0.0.4", "10.0.0.5", "10.0.0.6"}; !reflect.DeepEqual(ips, want) {
+		t.Errorf("scanned set = %v, want %v", ips, want)
+	}
+}
+
+func TestScopeNothingInScope(t *testing.T) {
+	scope, err := NewScope([]string{"10.9.0.0/16"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := scopedIPs([]string{"10.0.0.0/24"}, scope); err != ErrNoTargets {
+		t.Errorf("scopedIPs with a disjoint allowlist = %v, want ErrNoTargets", err)
+	}
+}
+
+func TestScopeContains(t *testing.T) {
+	var nilScope *Scope
+	if !nilScope.Contains(net.ParseIP("8.8.8.8")) {
+		t.Error("a nil scope excludes addresses")
+	}
+	scope, _ := NewScope([]string{"2001:db8::/64"}, []string{"2001:db8::1"})
+	for ip, want := range map[string]bool{"2001:db8::2": true, "2001:db8::1": false, "2001:db9::1": false} {
+		if got := scope.Contains(net.ParseIP(ip)); got != want {
+			t.Errorf("Contains(%s) = %v, want %v", ip, got, want)
+		}
+	}
+}
+
+func TestNewScopeInvalid(t *testing.T) {
+	if _, err := NewScope([]string{"10.0.0.0/33"}, nil); err == nil || !strings.Contains(err.Error(), "allow_networks") {
+		t.Errorf("invalid allow CIDR: %v", err)
+	}
+	if _, err := NewScope(nil, []string{"not-an-ip"}); err == nil || !strings.Contains(err.Error(), "exclude_networks") {
+		t.Errorf("invalid exclude address: %v", err)
+	}
+}
+
+func TestScopeExcludeFile(t *testing.T) {
+	scope, _ := NewScope(nil, []string{"10.0.0.2", "2001:db8::/64", "192.168.0.0/16"})
+	path, err := scope.ExcludeFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(path)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "10.0.0.2/32\n192.168.0.0/16\n" {
+		t.Errorf("exclude file = %q, want the IPv4 exclusions only", data)
+	}
+}
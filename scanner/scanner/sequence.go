@@ -0,0 +1,111 @@
+package scanner
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// sequenceProbe is a probe probe_sequence can name. Every native probe
+// labels its result with its own service whether or not the peer spoke
+// the protocol, so identified decides from the response itself.
+type sequenceProbe struct {
+	probe      func(f *Fingerprinter, ip string, port int) ServiceInfo
+	identified func(info ServiceInfo) bool
+}
+
+// sequenceProbes are the probes probe_sequence can name, by service
+var sequenceProbes = map[string]sequenceProbe{
+	"http":       {httpProbe(false, "http"), httpAnswered},
+	"https":      {httpProbe(true, "https"), httpAnswered},
+	"http-proxy": {httpProbe(false, "http-proxy"), httpAnswered},
+	"ssh": {(*Fingerprinter).probeSSH, func(info ServiceInfo) bool {
+		return strings.HasPrefix(info.Banner, "SSH-")
+	}},
+	"ftp": {(*Fingerprinter).probeFTP, func(info ServiceInfo) bool {
+		return strings.HasPrefix(info.Banner, "220") && !strings.Contains(strings.ToLower(info.Banner), "smtp")
+	}},
+	"smtp": {(*Fingerprinter).probeSMTP, func(info ServiceInfo) bool {
+		return strings.HasPrefix(info.Banner, "220") && !strings.Contains(strings.ToLower(info.Banner), "ftp")
+	}},
+	"telnet": {(*Fingerprinter).probeTelnet, func(info ServiceInfo) bool {
+		return info.Fingerprint["telnet_auth"] != nil
+	}},
+	"pop3": {(*Fingerprinter).probePOP3, func(info ServiceInfo) bool {
+		return strings.HasPrefix(info.Banner, "+OK")
+	}},
+	"imap": {(*Fingerprinter).probeIMAP, func(info ServiceInfo) bool {
+		return strings.HasPrefix(info.Banner, "* OK") || strings.HasPrefix(info.Banner, "* PREAUTH")
+	}},
+	"mysql":      {(*Fingerprinter).probeMySQL, func(info ServiceInfo) bool { return info.ServiceVersion != "" }},
+	"postgresql": {(*Fingerprinter).probePostgreSQL, func(info ServiceInfo) bool { return info.Banner != "" }},
+	"redis":      {(*Fingerprinter).probeRedis, func(info ServiceInfo) bool { return info.Banner != "" }},
+	"socks":      {(*Fingerprinter).probeSOCKS, func(info ServiceInfo) bool { return info.Banner != "" }},
+	// A generic banner grab, accepted when the banner names its service
+	"banner": {func(f *Fingerprinter, ip string, port int) ServiceInfo {
+		info := f.probeGeneric(ip, port)
+		if info.Banner != "" {
+			info.ServiceName = guessServiceFromBanner(info.Banner, port)
+		}
+		return info
+	}, func(info ServiceInfo) bool { return info.ServiceName != "" }},
+}
+
+func httpProbe(useTLS bool, service string) func(f *Fingerprinter, ip string, port int) ServiceInfo {
+	return func(f *Fingerprinter, ip string, port int) ServiceInfo {
+		info := f.probeHTTP(ip, port, useTLS)
+		info.ServiceName = service
+		return info
+	}
+}
+
+// httpAnswered reports whether the peer sent an HTTP status line
+func httpAnswered(info ServiceInfo) bool {
+	return info.Fingerprint["status_code"] != nil
+}
+
+// SequenceProbeNames lists the probes probe_sequence can name, sorted
+func SequenceProbeNames() []string {
+	names := make([]string, 0, len(sequenceProbes))
+	for name := range sequenceProbes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CheckProbeSequence reports the first probe_sequence entry naming a probe
+// that doesn't exist
+func CheckProbeSequence(sequence map[int][]string) error {
+	ports := make([]int, 0, len(sequence))
+	for port := range sequence {
+		ports = append(ports, port)
+	}
+	sort.Ints(ports)
+	for _, port := range ports {
+		for _, name := range sequence[port] {
+			if _, ok := sequenceProbes[name]; !ok {
+				return fmt.Errorf("unknown probe %q for port %d (known: %s)", name, port, strings.Join(SequenceProbeNames(), ", "))
+			}
+		}
+	}
+	return nil
+}
+
+// probeSequence tries the named probes in order and returns the first
+// result that identifies its service. When none does, the service is left
+// unnamed for the port-based fallback, keeping the first banner seen.
+func (f *Fingerprinter) probeSequence(ip string, port int, names []string) ServiceInfo {
+	var unidentified ServiceInfo
+	for _, name := range names {
+		p := sequenceProbes[name]
+		info := p.probe(f, ip, port)
+		if p.identified(info) {
+			return info
+		}
+		if unidentified.Banner == "" {
+			unidentified.Banner = info.Banner
+		}
+	}
+	return unidentified
+}
@@ -0,0 +1,156 @@
+package scanner
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Handlers for a port that could be any of several protocols
+var (
+	// speaks SSH first, and so ignores an HTTP request
+	sshService = func(conn net.Conn) { io.WriteString(conn, sshBanner+"\r\n") }
+
+	// answers requests and stays silent until one arrives, so a probe
+	// waiting for a banner times out
+	httpService = func(conn net.Conn) {
+		request := bufio.NewReader(conn)
+		for {
+			line, err := request.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if line == "\r\n" {
+				break
+			}
+		}
+		io.WriteString(conn, "HTTP/1.1 200 OK\r\nServer: nginx/1.24.0\r\nContent-Length: 0\r\nConnection: close\r\n\r\n")
+	}
+)
+
+// newSequenceServer runs handle for each connection and counts them
+func newSequenceServer(t *testing.T, handle func(conn net.Conn)) (string, int, *atomic.Int32) {
+	t.Helper()
+	var conns atomic.Int32
+	ip, port := stubServer(t, func(conn net.Conn) {
+		conns.Add(1)
+		handle(conn)
+	})
+	return ip, port, &conns
+}
+
+func sequenceFingerprinter(port int, probes ...string) *Fingerprinter {
+	f := testFingerprinter()
+	f.Timeout = 300 * time.Millisecond
+	f.ProbeSequence = map[int][]string{port: probes}
+	return f
+}
+
+func TestProbeSequenceSecondProbeIdentifies(t *testing.T) {
+	tests := []struct {
+		name    string
+		handle  func(conn net.Conn)
+		probes  []string
+		service string
+		banner  string
+	}{
+		// The HTTP probe gets an SSH banner back instead of a status line
+		{"ssh behind http", sshService, []string{"http", "ssh"}, "ssh", "SSH-2.0-OpenSSH_9.6p1"},
+		// The SSH probe hears nothing, the HTTP one gets a response
+		{"http behind ssh", httpService, []string{"ssh", "http"}, "http", "HTTP/1.1 200 OK"},
+		{"http behind ssh and telnet", httpService, []string{"ssh", "telnet", "http-proxy"}, "http-proxy", "HTTP/1.1 200 OK"},
+	}
+	for _, tt := range tests {
+		ip, port, conns := newSequenceServer(t, tt.handle)
+		f := sequenceFingerprinter(port, tt.probes...)
+		info := f.FingerprintHost(context.Background(), ip, []int{port})[port]
+		if info.ServiceName != tt.service || !strings.HasPrefix(info.Banner, tt.banner) {
+			t.Errorf("%s: %q with banner %q, want %q from probe %d", tt.name, info.ServiceName, info.Banner, tt.service, len(tt.probes))
+		}
+		if n := conns.Load(); int(n) != len(tt.probes) {
+			t.Errorf("%s: %d connections, want one per probe", tt.name, n)
+		}
+	}
+}
+
+func TestProbeSequenceFirstMatchWins(t *testing.T) {
+	ip, port, conns := newSequenceServer(t, httpService)
+	f := sequenceFingerprinter(port, "http", "http-proxy", "banner")
+	info := f.FingerprintHost(context.Background(), ip, []int{port})[port]
+	if info.ServiceName != "http" || info.ServiceVersion != "nginx/1.24.0" || info.Fingerprint["status_code"] != "200" {
+		t.Errorf("info = %+v, want the first probe's http result", info)
+	}
+	if n := conns.Load(); n != 1 {
+		t.Errorf("%d connections, want the later probes skipped", n)
+	}
+}
+
+func TestProbeSequenceNoneIdentifies(t *testing.T) {
+	// A banner no probe recognises is kept for the port-based name
+	ip, port, conns := newSequenceServer(t, func(conn net.Conn) {
+		io.WriteString(conn, "HELLO custom-app v3\r\n")
+		io.Copy(io.Discard, conn)
+	})
+	f := sequenceFingerprinter(port, "ssh", "http")
+	info := f.FingerprintHost(context.Background(), ip, []int{port})[port]
+	if info.ServiceName != getDefaultServiceName(port) || !strings.HasPrefix(info.Banner, "HELLO custom-app") {
+		t.Errorf("info = %q %q, want the port's name and the first banner", info.ServiceName, info.Banner)
+	}
+	if n := conns.Load(); n != 2 {
+		t.Errorf("%d connections, want every probe tried", n)
+	}
+}
+
+func TestProbeSequenceOtherPortsUnchanged(t *testing.T) {
+	ip, port, conns := newSequenceServer(t, sshService)
+	f := sequenceFingerprinter(port+1, "http", "ssh")
+	info := f.FingerprintHost(context.Background(), ip, []int{port})[port]
+	if info.ServiceName != "ssh" || conns.Load() != 1 {
+		t.Errorf("unlisted port: %q over %d connections, want the single usual probe", info.ServiceName, conns.Load())
+	}
+}
+
+func TestProbeSequenceZgrabGoesNative(t *testing.T) {
+	z, calls := cannedZgrab(t, cannedZgrabSSH)
+	ip, port, _ := newSequenceServer(t, httpService)
+	z.Fallback.Timeout = 300 * time.Millisecond
+	z.Fallback.ProbeSequence = map[int][]string{port: {"ssh", "http"}}
+	info := z.fingerprintPort(context.Background(), ip, port)
+	if info.ServiceName != "http" {
+		t.Errorf("service = %q, want the native sequence's http", info.ServiceName)
+	}
+	if _, err := os.Stat(calls); err == nil {
+		t.Error("zgrab2 ran for a probe_sequence port")
+	}
+}
+
+func TestCheckProbeSequence(t *testing.T) {
+	if err := CheckProbeSequence(map[int][]string{8080: {"http", "http-proxy", "socks", "banner"}, 2222: {"ssh", "telnet"}}); err != nil {
+		t.Error(err)
+	}
+	err := CheckProbeSequence(map[int][]string{8080: {"http"}, 9000: {"ssh", "gopher"}})
+	if err == nil || !strings.Contains(err.Error(), `"gopher" for port 9000`) || !strings.Contains(err.Error(), "http-proxy") {
+		t.Errorf("unknown probe: %v", err)
+	}
+
+	names := SequenceProbeNames()
+	if !sort.StringsAreSorted(names) || len(names) != len(sequenceProbes) {
+		t.Errorf("SequenceProbeNames() = %v", names)
+	}
+	for _, name := range names {
+		if err := CheckProbeSequence(map[int][]string{1: {name}}); err != nil {
+			t.Errorf("listed probe %q rejected: %v", name, err)
+		}
+	}
+	if !reflect.DeepEqual(names[:3], []string{"banner", "ftp", "http"}) {
+		t.Errorf("SequenceProbeNames() starts %v", names[:3])
+	}
+}
@@ -0,0 +1,135 @@
+package scanner
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Relay test envelope. Both use RFC 2606 reserved domains so an accepting
+// server has nowhere real to deliver to; DATA is never sent regardless.
+const (
+	relayTestFrom = "relay-test@example.com"
+	relayTestTo   = "relay-test@example.net"
+)
+
+// relayTestPorts are the plaintext SMTP ports the relay test runs against.
+// 465 is implicit TLS and is not tested.
+var relayTestPorts = map[int]bool{
+	25:  true,
+	587: true,
+}
+
+// checkOpenRelay asks the server to accept mail from one external domain to
+// another and records Fingerprint["open_relay"]. The transaction is reset
+// after RCPT TO, so no message is ever submitted.
+func (f *Fingerprinter) checkOpenRelay(ip string, port int, info *ServiceInfo) {
+	if !f.SMTPRelayTest || !relayTestPorts[port] || info.ServiceName != "smtp" {
+		return
+	}
+
+	address := net.JoinHostPort(ip, strconv.Itoa(port))
+	log.Printf("SMTP relay test: %s MAIL FROM <%s> RCPT TO <%s>", address, relayTestFrom, relayTestTo)
+
+	open, err := f.smtpRelays(address)
+	if err != nil {
+		log.Printf("SMTP relay test on %s inconclusive: %v", address, err)
+		return
+	}
+
+	if info.Fingerprint == nil {
+		info.Fingerprint = make(map[string]interface{})
+	}
+	info.Fingerprint["open_relay"] = open
+	if open {
+		log.Printf("SMTP relay test: %s is an OPEN RELAY", address)
+	}
+}
+
+// smtpRelays runs the envelope exchange and reports whether the server
+// accepted the external recipient. An error means the test didn't get as
+// far as RCPT TO.
+func (f *Fingerprinter) smtpRelays(address string) (bool, error) {
+	conn, err := f.dial(address)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(f.Timeout))
+	reader := bufio.NewReader(conn)
+
+	if code, err := readSMTPReply(reader); err != nil {
+		return false, err
+	} else if code != 220 {
+		return false, fmt.Errorf("greeting rejected with %d", code)
+	}
+
+	command := func(line string) (int, error) {
+		if _, err := fmt.Fprintf(conn, "%s\r\n", line); err != nil {
+			return 0, err
+		}
+		return readSMTPReply(reader)
+	}
+
+	code, err := command("EHLO scanner.local")
+	if err != nil {
+		return false, err
+	}
+	if code != 250 {
+		if code, err = command("HELO scanner.local"); err != nil {
+			return false, err
+		} else if code != 250 {
+			return false, fmt.Errorf("HELO rejected with %d", code)
+		}
+	}
+
+	if code, err = command("MAIL FROM:<" + relayTestFrom + ">"); err != nil {
+		return false, err
+	} else if code != 250 {
+		return false, fmt.Errorf("MAIL FROM rejected with %d", code)
+	}
+
+	if code, err = command("RCPT TO:<" + relayTestTo + ">"); err != nil {
+		return false, err
+	}
+
+	// Abandon the transaction before any DATA
+	command("RSET")
+	command("QUIT")
+
+	return code == 250 || code == 251, nil
+}
+
+// readSMTPReply reads a possibly multi-line reply ("250-...", "250 ...")
+// and returns its status code
+func readSMTPReply(reader *bufio.Reader) (int, error) {
+	code, _, err := readSMTPReplyLines(reader)
+	return code, err
+}
+
+// readSMTPReplyLines is readSMTPReply that also returns the reply's lines
+func readSMTPReplyLines(reader *bufio.Reader) (int, []string, error) {
+	var lines []string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return 0, lines, err
+		}
+		if len(line) < 4 {
+			return 0, lines, fmt.Errorf("malformed SMTP reply %q", strings.TrimSpace(line))
+		}
+		code, err := strconv.Atoi(line[:3])
+		if err != nil {
+			return 0, lines, fmt.Errorf("malformed SMTP reply %q", strings.TrimSpace(line))
+		}
+		lines = append(lines, strings.TrimRight(line, "\r\n"))
+		if line[3] != '-' {
+			return code, lines, nil
+		}
+	}
+}
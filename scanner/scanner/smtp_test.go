@@ -0,0 +1,141 @@
+package scanner
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// smtpStub is an SMTP server that answers RCPT TO with rcptCode and every
+// other command with 250, recording the commands it receives
+type smtpStub struct {
+	rcptCode int
+
+	mu       sync.Mutex
+	commands []string
+}
+
+func (s *smtpStub) handle(conn net.Conn) {
+	fmt.Fprint(conn, "220 stub.example ESMTP\r\n")
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command := strings.TrimRight(line, "\r\n")
+		s.mu.Lock()
+		s.commands = append(s.commands, command)
+		s.mu.Unlock()
+
+		verb := strings.ToUpper(strings.SplitN(command, ":", 2)[0])
+		switch {
+		case strings.HasPrefix(verb, "EHLO"):
+			fmt.Fprint(conn, "250-stub.example\r\n250 8BITMIME\r\n")
+		case verb == "RCPT TO":
+			fmt.Fprintf(conn, "%d recipient\r\n", s.rcptCode)
+		case verb == "QUIT":
+			fmt.Fprint(conn, "221 bye\r\n")
+			return
+		default:
+			fmt.Fprint(conn, "250 ok\r\n")
+		}
+	}
+}
+
+func (s *smtpStub) seen() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.commands...)
+}
+
+func TestSMTPRelays(t *testing.T) {
+	for _, tc := range []struct {
+		rcptCode int
+		want     bool
+	}{
+		{250, true},
+		{251, true},
+		{550, false},
+		{554, false},
+	} {
+		stub := &smtpStub{rcptCode: tc.rcptCode}
+		ip, port := stubServer(t, stub.handle)
+
+		open, err := testFingerprinter().smtpRelays(stubAddress(ip, port))
+		if err != nil {
+			t.Fatalf("RCPT %d: %v", tc.rcptCode, err)
+		}
+		if open != tc.want {
+			t.Errorf("RCPT %d: open = %v, want %v", tc.rcptCode, open, tc.want)
+		}
+
+		// Wait for QUIT so the full exchange has been recorded
+		if !eventually(func() bool {
+			seen := stub.seen()
+			return len(seen) > 0 && seen[len(seen)-1] == "QUIT"
+		}) {
+			t.Fatalf("RCPT %d: no QUIT in %q", tc.rcptCode, stub.seen())
+		}
+		want := []string{
+			"EHLO scanner.local",
+			"MAIL FROM:<" + relayTestFrom + ">",
+			"RCPT TO:<" + relayTestTo + ">",
+			"RSET",
+			"QUIT",
+		}
+		if got := stub.seen(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+			t.Errorf("RCPT %d: commands = %q, want %q", tc.rcptCode, got, want)
+		}
+	}
+}
+
+func TestSMTPRelaysRejectedGreeting(t *testing.T) {
+	ip, port := stubServer(t, func(conn net.Conn) {
+		fmt.Fprint(conn, "554 no service\r\n")
+	})
+	if _, err := testFingerprinter().smtpRelays(stubAddress(ip, port)); err == nil {
+		t.Error("smtpRelays succeeded against a 554 greeting")
+	}
+}
+
+func TestCheckOpenRelay(t *testing.T) {
+	stub := &smtpStub{rcptCode: 250}
+	ip, port := stubServer(t, stub.handle)
+	relayTestPorts[port] = true
+	t.Cleanup(func() { delete(relayTestPorts, port) })
+
+	f := testFingerprinter()
+	f.SMTPRelayTest = true
+	info := ServiceInfo{ServiceName: "smtp"}
+	f.checkOpenRelay(ip, port, &info)
+	if info.Fingerprint["open_relay"] != true {
+		t.Errorf("open_relay = %v, want true", info.Fingerprint["open_relay"])
+	}
+
+	// Disabled, non-SMTP and untested ports are never contacted
+	for _, tc := range []struct {
+		name    string
+		enabled bool
+		service string
+		port    int
+	}{
+		{"disabled", false, "smtp", port},
+		{"not smtp", true, "http", port},
+		{"implicit TLS port", true, "smtp", 465},
+	} {
+		f.SMTPRelayTest = tc.enabled
+		info := ServiceInfo{ServiceName: tc.service}
+		before := len(stub.seen())
+		f.checkOpenRelay(ip, tc.port, &info)
+		if _, ok := info.Fingerprint["open_relay"]; ok {
+			t.Errorf("%s: open_relay recorded", tc.name)
+		}
+		if len(stub.seen()) != before {
+			t.Errorf("%s: stub was contacted", tc.name)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package scanner
+
+import (
+	"context"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// socketThrottleLogInterval is the least time between two reports of the
+// socket budget holding connections back
+const socketThrottleLogInterval = 10 * time.Second
+
+// SocketBudget caps the sockets held open at once across port scanning,
+// confirmation and fingerprinting, so a high rate plus concurrent probes
+// can't run into the open file limit and lose results to EMFILE. A dial
+// over budget waits for a socket to close rather than failing. zgrab2 runs
+// in its own process and isn't counted. A nil budget is no cap.
+type SocketBudget struct {
+	sem chan struct{}
+
+	mu        sync.Mutex
+	waits     int // dials held back since the last report
+	lastLogAt time.Time
+}
+
+// NewSocketBudget creates a budget of limit simultaneous sockets
+func NewSocketBudget(limit int) *SocketBudget {
+	if limit <= 0 {
+		limit = 1
+	}
+	return &SocketBudget{sem: make(chan struct{}, limit)}
+}
+
+// DefaultSocketBudget returns a budget below the process's open file
+// limit, leaving a fifth of it (at least 64) for files, listeners and the
+// API client. It returns 0, no cap, when there is no limit to stay under.
+func DefaultSocketBudget() int {
+	limit := OpenFileLimit()
+	if limit <= 0 {
+		return 0
+	}
+	reserve := max(limit/5, 64)
+	return max(limit-reserve, 1)
+}
+
+// Limit returns the most sockets the budget allows at once
+func (b *SocketBudget) Limit() int {
+	return cap(b.sem)
+}
+
+// acquire takes a socket from the budget, waiting for one to be released
+// when it is exhausted. It returns false if ctx is cancelled first.
+func (b *SocketBudget) acquire(ctx context.Context) bool {
+	select {
+	case b.sem <- struct{}{}:
+		return true
+	default:
+	}
+
+	b.mu.Lock()
+	b.waits++
+	if time.Since(b.lastLogAt) >= socketThrottleLogInterval {
+		log.Printf("Socket budget of %d exhausted; %d connection attempts waited for a free socket", b.Limit(), b.waits)
+		b.waits = 0
+		b.lastLogAt = time.Now()
+	}
+	b.mu.Unlock()
+
+	select {
+	case b.sem <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (b *SocketBudget) release() {
+	<-b.sem
+}
+
+// dial runs dial under the budget. The socket is returned to the budget
+// when the connection is closed, or straight away if the dial fails.
+func (b *SocketBudget) dial(ctx context.Context, dial func() (net.Conn, error)) (net.Conn, error) {
+	if b == nil {
+		return dial()
+	}
+	if !b.acquire(ctx) {
+		return nil, ctx.Err()
+	}
+	conn, err := dial()
+	if err != nil {
+		b.release()
+		return nil, err
+	}
+	return &budgetConn{Conn: conn, budget: b}, nil
+}
+
+// budgetConn gives its socket back to the budget on the first Close
+type budgetConn struct {
+	net.Conn
+	budget *SocketBudget
+	once   sync.Once
+}
+
+func (c *budgetConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.budget.release)
+	return err
+}
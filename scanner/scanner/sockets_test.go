@@ -0,0 +1,159 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSocketBudgetSerializesDials(t *testing.T) {
+	ip, port := stubServer(t, func(conn net.Conn) { conn.Read(make([]byte, 1)) })
+	for _, limit := range []int{1, 3} {
+		b := NewSocketBudget(limit)
+		var open, peak atomic.Int64
+		var wg sync.WaitGroup
+		for i := 0; i < 12; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				conn, err := b.dial(context.Background(), func() (net.Conn, error) {
+					return net.Dial("tcp", stubAddress(ip, port))
+				})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				n := open.Add(1)
+				for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+				}
+				time.Sleep(10 * time.Millisecond)
+				open.Add(-1)
+				conn.Close()
+			}()
+		}
+		wg.Wait()
+		if got := peak.Load(); got != int64(limit) {
+			t.Errorf("budget %d: %d sockets open at once, want %d", limit, got, limit)
+		}
+		if len(b.sem) != 0 {
+			t.Errorf("budget %d: %d sockets still taken after every close", limit, len(b.sem))
+		}
+	}
+}
+
+// holdSocket takes the budget's only socket with a loopback connection and
+// returns the function that gives it back
+func holdSocket(t *testing.T, b *SocketBudget) func() {
+	t.Helper()
+	port := listenOn(t, "127.0.0.1", 0)
+	conn, err := b.dial(context.Background(), func() (net.Conn, error) {
+		return net.Dial("tcp", stubAddress("127.0.0.1", port))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return func() { conn.Close() }
+}
+
+func TestSocketBudgetBlocksScanner(t *testing.T) {
+	b := NewSocketBudget(1)
+	release := holdSocket(t, b)
+
+	port, accepted := countAccepts(t, "127.0.0.1")
+	s := NewTCPScanner([]string{"127.0.0.1/32"}, 50, 1)
+	s.Sockets = b
+	done := make(chan []ZmapResult)
+	go func() {
+		results, _ := s.ScanPort(context.Background(), port)
+		done <- results
+	}()
+
+	// Exhausted, the scan waits rather than failing the connection
+	select {
+	case results := <-done:
+		t.Fatalf("scan finished with the budget exhausted: %v", results)
+	case <-time.After(200 * time.Millisecond):
+	}
+	if accepted() != 0 {
+		t.Fatalf("%d connections made over budget", accepted())
+	}
+
+	release()
+	select {
+	case results := <-done:
+		if len(results) != 1 || results[0].Port != port {
+			t.Errorf("results = %v, want the open port once the socket was freed", results)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("scan still waiting after the socket was freed")
+	}
+}
+
+func TestSocketBudgetCancelledWhileHeld(t *testing.T) {
+	b := NewSocketBudget(1)
+	defer holdSocket(t, b)()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	dialed := false
+	start := time.Now()
+	conn, err := b.dial(ctx, func() (net.Conn, error) {
+		dialed = true
+		return nil, errors.New("unreachable")
+	})
+	if !errors.Is(err, context.Canceled) || conn != nil || dialed {
+		t.Errorf("dial = %v, %v (dialed %v), want context.Canceled without dialing", conn, err, dialed)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("dial returned %s after cancellation", elapsed)
+	}
+
+	// A scan cancelled while waiting for a socket ends without connecting
+	port, accepted := countAccepts(t, "127.0.0.1")
+	s := NewTCPScanner([]string{"127.0.0.1/32"}, 50, 1)
+	s.Sockets = b
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if results, _ := s.ScanPort(ctx, port); len(results) != 0 || accepted() != 0 {
+		t.Errorf("cancelled scan found %v with %d connections", results, accepted())
+	}
+}
+
+func TestSocketBudgetReleases(t *testing.T) {
+	b := NewSocketBudget(1)
+
+	// A failed dial gives its socket straight back
+	if _, err := b.dial(context.Background(), func() (net.Conn, error) { return nil, errors.New("refused") }); err == nil {
+		t.Fatal("failed dial succeeded")
+	}
+	if len(b.sem) != 0 {
+		t.Fatal("failed dial kept its socket")
+	}
+
+	// Closing twice gives one socket back, not two
+	release := holdSocket(t, b)
+	release()
+	release()
+	second := holdSocket(t, b)
+	defer second()
+	select {
+	case b.sem <- struct{}{}:
+		t.Error("budget of 1 gave out a second socket after a double close")
+	default:
+	}
+
+	// A nil budget dials straight through
+	var unlimited *SocketBudget
+	ran := false
+	unlimited.dial(context.Background(), func() (net.Conn, error) { ran = true; return nil, errors.New("x") })
+	if !ran {
+		t.Error("nil budget did not dial")
+	}
+	if got := NewSocketBudget(0).Limit(); got != 1 {
+		t.Errorf("NewSocketBudget(0).Limit() = %d, want 1", got)
+	}
+}
@@ -0,0 +1,43 @@
+package scanner
+
+import (
+	"net"
+	"syscall"
+)
+
+// SocketOptions tunes the short-lived scan, confirmation and probe
+// connections. Rapid scans can otherwise leave enough sockets in TIME_WAIT
+// or holding keepalive timers to exhaust ports on some stacks. A nil
+// *SocketOptions leaves the system defaults.
+type SocketOptions struct {
+	// LingerZero sets SO_LINGER with a zero timeout, so Close resets the
+	// connection instead of leaving it in TIME_WAIT
+	LingerZero bool
+
+	// DisableKeepAlive turns TCP keepalive off
+	DisableKeepAlive bool
+}
+
+// apply sets the options on dialer through its Control hook
+func (o *SocketOptions) apply(dialer *net.Dialer) {
+	if o == nil || (!o.LingerZero && !o.DisableKeepAlive) {
+		return
+	}
+	if o.DisableKeepAlive {
+		// net.Dialer enables keepalive after Control runs unless told not to
+		dialer.KeepAlive = -1
+	}
+	dialer.Control = o.control
+}
+
+// control is a net.Dialer Control hook setting the options on the socket
+// before it connects
+func (o *SocketOptions) control(network, address string, c syscall.RawConn) error {
+	var sockErr error
+	if err := c.Control(func(fd uintptr) {
+		sockErr = setSocketOptions(fd, o)
+	}); err != nil {
+		return err
+	}
+	return sockErr
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package scanner
+
+// setSocketOptions is a no-op off Unix; DisableKeepAlive still takes effect
+// through net.Dialer
+func setSocketOptions(fd uintptr, o *SocketOptions) error {
+	return nil
+}
@@ -0,0 +1,29 @@
+package scanner
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSocketOptionsApply(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      *SocketOptions
+		control   bool
+		keepAlive int
+	}{
+		{"nil", nil, false, 0},
+		{"all off", &SocketOptions{}, false, 0},
+		{"linger zero", &SocketOptions{LingerZero: true}, true, 0},
+		{"no keepalive", &SocketOptions{DisableKeepAlive: true}, true, -1},
+		{"both", &SocketOptions{LingerZero: true, DisableKeepAlive: true}, true, -1},
+	}
+	for _, tt := range tests {
+		dialer := &net.Dialer{}
+		tt.opts.apply(dialer)
+		if (dialer.Control != nil) != tt.control || dialer.KeepAlive != time.Duration(tt.keepAlive) {
+			t.Errorf("%s: Control set %v, KeepAlive %s; want %v, %d", tt.name, dialer.Control != nil, dialer.KeepAlive, tt.control, tt.keepAlive)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+//go:build unix
+
+package scanner
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// setSocketOptions applies o to the socket fd
+func setSocketOptions(fd uintptr, o *SocketOptions) error {
+	if o.LingerZero {
+		linger := &syscall.Linger{Onoff: 1, Linger: 0}
+		if err := syscall.SetsockoptLinger(int(fd), syscall.SOL_SOCKET, syscall.SO_LINGER, linger); err != nil {
+			return fmt.Errorf("failed to set SO_LINGER: %w", err)
+		}
+	}
+	if o.DisableKeepAlive {
+		if err := syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_KEEPALIVE, 0); err != nil {
+			return fmt.Errorf("failed to clear SO_KEEPALIVE: %w", err)
+		}
+	}
+	return nil
+}
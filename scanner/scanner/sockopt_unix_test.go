@@ -0,0 +1,160 @@
+//go:build unix
+
+package scanner
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// socketState is the linger and keepalive settings read off a socket
+type socketState struct {
+	linger    unix.Linger
+	keepAlive int
+}
+
+// readSocketState reads the options SocketOptions sets from c
+func readSocketState(t *testing.T, c syscall.RawConn) socketState {
+	t.Helper()
+	var state socketState
+	var err error
+	c.Control(func(fd uintptr) {
+		var linger *unix.Linger
+		if linger, err = unix.GetsockoptLinger(int(fd), unix.SOL_SOCKET, unix.SO_LINGER); err != nil {
+			return
+		}
+		state.linger = *linger
+		state.keepAlive, err = unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_KEEPALIVE)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return state
+}
+
+func TestSocketOptionsControl(t *testing.T) {
+	port := listenOn(t, "127.0.0.1", 0)
+	address := stubAddress("127.0.0.1", port)
+	tests := []struct {
+		name string
+		opts *SocketOptions
+		// At Control time, and once connected, when net.Dialer has applied
+		// its own keepalive default
+		control, connected socketState
+	}{
+		{"defaults", nil, socketState{}, socketState{keepAlive: 1}},
+		{"linger zero", &SocketOptions{LingerZero: true},
+			socketState{linger: unix.Linger{Onoff: 1}},
+			socketState{linger: unix.Linger{Onoff: 1}, keepAlive: 1}},
+		{"no keepalive", &SocketOptions{DisableKeepAlive: true}, socketState{}, socketState{}},
+		{"both", &SocketOptions{LingerZero: true, DisableKeepAlive: true},
+			socketState{linger: unix.Linger{Onoff: 1}},
+			socketState{linger: unix.Linger{Onoff: 1}}},
+	}
+	for _, tt := range tests {
+		dialer := sourceDialer(nil, "127.0.0.1", time.Second, tt.opts)
+		set := dialer.Control
+		var seen []socketState
+		dialer.Control = func(network, address string, c syscall.RawConn) error {
+			if set != nil {
+				if err := set(network, address, c); err != nil {
+					return err
+				}
+			}
+			seen = append(seen, readSocketState(t, c))
+			return nil
+		}
+
+		conn, err := dialer.Dial("tcp", address)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		raw, err := conn.(*net.TCPConn).SyscallConn()
+		if err != nil {
+			t.Fatal(err)
+		}
+		connected := readSocketState(t, raw)
+		conn.Close()
+
+		if len(seen) != 1 || seen[0] != tt.control {
+			t.Errorf("%s: at Control %+v, want %+v", tt.name, seen, tt.control)
+		}
+		if connected != tt.connected {
+			t.Errorf("%s: connected socket %+v, want %+v", tt.name, connected, tt.connected)
+		}
+	}
+}
+
+// closeKind listens on loopback and reports for each connection whether
+// the peer closed it with a reset or an orderly FIN
+func closeKind(t *testing.T) (int, <-chan string) {
+	t.Helper()
+	closes := make(chan string, 16)
+	_, port := stubServer(t, func(conn net.Conn) {
+		_, err := io.Copy(io.Discard, conn)
+		switch {
+		case errors.Is(err, syscall.ECONNRESET):
+			closes <- "reset"
+		case err == nil:
+			closes <- "fin"
+		default:
+			closes <- err.Error()
+		}
+	})
+	return port, closes
+}
+
+func TestLingerZeroResetsScanConnections(t *testing.T) {
+	for _, lingerZero := range []bool{false, true} {
+		port, closes := closeKind(t)
+		opts := &SocketOptions{LingerZero: lingerZero, DisableKeepAlive: true}
+		want := "fin"
+		if lingerZero {
+			want = "reset"
+		}
+
+		tcp := NewTCPScanner(nil, 1, 1)
+		tcp.SocketOptions = opts
+		confirmer := &Confirmer{Timeout: time.Second, SocketOptions: opts}
+		f := testFingerprinter()
+		f.SocketOptions = opts
+		sourcePorts := NewSourcePortRange(40000+port%20000, 40000+port%20000+10)
+
+		paths := map[string]func(){
+			"tcp scan": func() {
+				if open, _ := tcp.probe(context.Background(), "127.0.0.1", port); !open {
+					t.Errorf("tcp scan: port %d not open", port)
+				}
+			},
+			"confirmation": func() { confirmer.check(context.Background(), "127.0.0.1", port) },
+			"probe": func() {
+				if conn, err := f.dial(stubAddress("127.0.0.1", port)); err == nil {
+					conn.Close()
+				}
+			},
+			"source port range": func() {
+				if conn, _, err := sourcePorts.dial(context.Background(), nil, stubAddress("127.0.0.1", port), time.Second, opts); err == nil {
+					conn.Close()
+				}
+			},
+		}
+		for name, connect := range paths {
+			connect()
+			select {
+			case got := <-closes:
+				if got != want {
+					t.Errorf("%s with LingerZero %v: peer saw %s, want %s", name, lingerZero, got, want)
+				}
+			case <-time.After(2 * time.Second):
+				t.Errorf("%s with LingerZero %v: no connection", name, lingerZero)
+			}
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// sourceDialer returns a dialer with the given timeout and socket options,
+// bound to source when source is set and the same address family as host.
+// Other destinations keep the default route's address.
+func sourceDialer(source net.IP, host string, timeout time.Duration, opts *SocketOptions) *net.Dialer {
+	dialer := &net.Dialer{Timeout: timeout}
+	opts.apply(dialer)
+	if source == nil {
+		return dialer
+	}
+	if ip := net.ParseIP(host); ip != nil && (ip.To4() == nil) == (source.To4() == nil) {
+		dialer.LocalAddr = &net.TCPAddr{IP: source}
+	}
+	return dialer
+}
+
+// dialFrom opens a TCP connection to address (host:port) from source
+func dialFrom(source net.IP, address string, timeout time.Duration, opts *SocketOptions) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	return sourceDialer(source, host, timeout, opts).Dial("tcp", address)
+}
+
+// dial opens a probe connection to address from SourceIP, through Fixtures
+// when set
+func (f *Fingerprinter) dial(address string) (net.Conn, error) {
+	return f.dialTimeout(address, f.Timeout)
+}
+
+// dialTimeout is dial with its own connect timeout. A wait for the socket
+// budget ends when the probe's context (see withContext) is cancelled.
+func (f *Fingerprinter) dialTimeout(address string, timeout time.Duration) (net.Conn, error) {
+	dial := func() (net.Conn, error) {
+		return f.Sockets.dial(f.context(), func() (net.Conn, error) {
+			return f.connect(address, timeout)
+		})
+	}
+	if f.Fixtures != nil {
+		return f.Fixtures.Dial(address, dial)
+	}
+	return dial()
+}
+
+// dialSecondary opens a second connection for a probe whose first still
+// holds a socket from the budget, such as an FTP data connection, and
+// counts it against that socket. Taking another from the budget could
+// deadlock once every holder waits for its second.
+func (f *Fingerprinter) dialSecondary(address string) (net.Conn, error) {
+	dial := func() (net.Conn, error) {
+		return f.connect(address, f.Timeout)
+	}
+	if f.Fixtures != nil {
+		return f.Fixtures.Dial(address, dial)
+	}
+	return dial()
+}
+
+// connect dials address from SourceIP and sends its PROXY header, outside
+// the socket budget
+func (f *Fingerprinter) connect(address string, timeout time.Duration) (net.Conn, error) {
+	conn, err := dialFrom(f.SourceIP, address, timeout, f.SocketOptions)
+	if err != nil {
+		return nil, err
+	}
+	return f.sendProxyHeader(conn, address)
+}
+
+// dialContext is dial for http.Transport
+func (f *Fingerprinter) dialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	dial := func() (net.Conn, error) {
+		return f.Sockets.dial(ctx, func() (net.Conn, error) {
+			conn, err := sourceDialer(f.SourceIP, host, f.Timeout, f.SocketOptions).DialContext(ctx, network, address)
+			if err != nil {
+				return nil, err
+			}
+			return f.sendProxyHeader(conn, address)
+		})
+	}
+	if f.Fixtures != nil {
+		return f.Fixtures.Dial(address, dial)
+	}
+	return dial()
+}
+
+// Bounds of the wait between passes over a source port range with every
+// port in use
+const (
+	sourcePortMinBackoff = 10 * time.Millisecond
+	sourcePortMaxBackoff = time.Second
+)
+
+// SourcePortRange hands out local ports from Min to Max in turn, starting
+// over after Max, so connections leave from a predictable port range
+type SourcePortRange struct {
+	Min, Max int
+
+	next   atomic.Uint64
+	warned atomic.Bool // the range has been logged as exhausted
+}
+
+// NewSourcePortRange creates a range of local ports min..max inclusive
+func NewSourcePortRange(min, max int) *SourcePortRange {
+	return &SourcePortRange{Min: min, Max: max}
+}
+
+// String formats the range as zmap's --source-port expects
+func (r *SourcePortRange) String() string {
+	if r.Min == r.Max {
+		return fmt.Sprint(r.Min)
+	}
+	return fmt.Sprintf("%d-%d", r.Min, r.Max)
+}
+
+// dial connects to address from source with the next port in the range and
+// returns how long the connecting attempt took. A port still in use (or in
+// TIME_WAIT) is skipped for the next one. Once every port in the range has
+// been tried, dial backs off and tries the range again until a port frees
+// up, so a busy range slows the scan down instead of failing its
+// connections; it gives up only when ctx is done.
+func (r *SourcePortRange) dial(ctx context.Context, source net.IP, address string, timeout time.Duration, opts *SocketOptions) (net.Conn, time.Duration, error) {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, 0, err
+	}
+	local := &net.TCPAddr{}
+	if laddr, ok := sourceDialer(source, host, timeout, nil).LocalAddr.(*net.TCPAddr); ok {
+		local.IP = laddr.IP
+	}
+
+	size := uint64(r.Max - r.Min + 1)
+	backoff := sourcePortMinBackoff
+	for {
+		for attempt := uint64(0); attempt < size; attempt++ {
+			local.Port = r.Min + int(r.next.Add(1)-1)%int(size)
+			dialer := &net.Dialer{Timeout: timeout, LocalAddr: local}
+			opts.apply(dialer)
+			start := time.Now()
+			conn, err := dialer.DialContext(ctx, "tcp", address)
+			if errors.Is(err, syscall.EADDRINUSE) {
+				continue
+			}
+			return conn, time.Since(start), err
+		}
+
+		if r.warned.CompareAndSwap(false, true) {
+			log.Printf("Warning: every source port in %s is in use; connections wait for one to free up", r)
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, 0, fmt.Errorf("no free source port in %s: %w", r, ctx.Err())
+		case <-timer.C:
+		}
+		backoff = min(2*backoff, sourcePortMaxBackoff)
+	}
+}
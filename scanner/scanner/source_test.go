@@ -0,0 +1,285 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// remoteIPs listens on 127.0.0.1 and records the source address of every
+// connection, returning its port and the addresses seen so far
+func remoteIPs(t *testing.T) (int, func() []string) {
+	t.Helper()
+	var mu sync.Mutex
+	var seen []string
+	_, port := stubServer(t, func(conn net.Conn) {
+		mu.Lock()
+		seen = append(seen, conn.RemoteAddr().(*net.TCPAddr).IP.String())
+		mu.Unlock()
+	})
+	return port, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), seen...)
+	}
+}
+
+// allFrom reports whether seen is non-empty and every address is ip
+func allFrom(seen []string, ip string) bool {
+	for _, s := range seen {
+		if s != ip {
+			return false
+		}
+	}
+	return len(seen) > 0
+}
+
+func TestTCPScannerSourceIP(t *testing.T) {
+	port, seen := remoteIPs(t)
+	s := NewTCPScanner([]string{"127.0.0.1/32"}, 4, 1)
+	s.SourceIP = net.ParseIP("127.0.0.5")
+
+	found, err := s.ScanPortsWithCallback(context.Background(), []int{port}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found["127.0.0.1"]) != 1 {
+		t.Fatalf("found = %v, want the listener", found)
+	}
+	if !eventually(func() bool { return allFrom(seen(), "127.0.0.5") }) {
+		t.Errorf("connections came from %v, want 127.0.0.5", seen())
+	}
+
+	var checked bool
+	s.CheckEndpoints(context.Background(), []ZmapResult{{IP: "127.0.0.1", Port: port}}, func(_ ZmapResult, open bool) { checked = open })
+	if !checked || !eventually(func() bool { return len(seen()) == 2 && allFrom(seen(), "127.0.0.5") }) {
+		t.Errorf("endpoint check open %v from %v, want 127.0.0.5", checked, seen())
+	}
+}
+
+func TestTCPScannerDefaultSource(t *testing.T) {
+	port, seen := remoteIPs(t)
+	s := NewTCPScanner([]string{"127.0.0.1/32"}, 4, 1)
+	if _, err := s.ScanPortsWithCallback(context.Background(), []int{port}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !eventually(func() bool { return allFrom(seen(), "127.0.0.1") }) {
+		t.Errorf("connections came from %v, want the default 127.0.0.1", seen())
+	}
+}
+
+func TestFingerprinterSourceIP(t *testing.T) {
+	port, seen := remoteIPs(t)
+	f := testFingerprinter()
+	f.Timeout = 200 * time.Millisecond
+	f.SourceIP = net.ParseIP("127.0.0.7")
+
+	f.FingerprintHost(context.Background(), "127.0.0.1", []int{port})
+	if !eventually(func() bool { return allFrom(seen(), "127.0.0.7") }) {
+		t.Errorf("probe connections came from %v, want 127.0.0.7", seen())
+	}
+}
+
+func TestSourceDialerMatchesFamily(t *testing.T) {
+	source := net.ParseIP("192.0.2.10")
+	if local, ok := sourceDialer(source, "198.51.100.1", time.Second, nil).LocalAddr.(*net.TCPAddr); !ok || !local.IP.Equal(source) {
+		t.Errorf("IPv4 destination bound to %v, want %v", local, source)
+	}
+	// An IPv4 source can't reach an IPv6 destination, or a name
+	for _, host := range []string{"2001:db8::1", "example.com"} {
+		if local := sourceDialer(source, host, time.Second, nil).LocalAddr; local != nil {
+			t.Errorf("%s bound to %v, want the default route", host, local)
+		}
+	}
+	if local := sourceDialer(nil, "198.51.100.1", time.Second, nil).LocalAddr; local != nil {
+		t.Errorf("no source bound to %v", local)
+	}
+}
+
+// remotePorts listens on 127.0.0.1 and records the source port of every
+// connection, returning its port and the source ports seen so far
+func remotePorts(t *testing.T) (int, func() []int) {
+	t.Helper()
+	var mu sync.Mutex
+	var seen []int
+	_, port := stubServer(t, func(conn net.Conn) {
+		mu.Lock()
+		seen = append(seen, conn.RemoteAddr().(*net.TCPAddr).Port)
+		mu.Unlock()
+	})
+	return port, func() []int {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]int(nil), seen...)
+	}
+}
+
+// freePortRange returns n consecutive local ports nothing is bound to
+func freePortRange(t testing.TB, n int) (int, int) {
+	t.Helper()
+	for base := 41000; base < 60000; base += n {
+		free := true
+		for port := base; port < base+n && free; port++ {
+			l, err := net.Listen("tcp", stubAddress("127.0.0.1", port))
+			if err != nil {
+				free = false
+				continue
+			}
+			l.Close()
+		}
+		if free {
+			return base, base + n - 1
+		}
+	}
+	t.Skip("no free local port range")
+	return 0, 0
+}
+
+func TestTCPScannerSourcePorts(t *testing.T) {
+	port, seen := remotePorts(t)
+	min, max := freePortRange(t, 4)
+	s := NewTCPScanner([]string{"127.0.0.1/32"}, 1, 1)
+	s.SourcePorts = NewSourcePortRange(min, max)
+	// Reset rather than close, so no port is left in TIME_WAIT when the
+	// range comes round again
+	s.SocketOptions = &SocketOptions{LingerZero: true}
+
+	targets := make([]ZmapResult, 8)
+	for i := range targets {
+		targets[i] = ZmapResult{IP: "127.0.0.1", Port: port}
+	}
+	opened := 0
+	s.CheckEndpoints(context.Background(), targets, func(_ ZmapResult, open bool) {
+		if open {
+			opened++
+		}
+	})
+	if opened != len(targets) {
+		t.Fatalf("%d of %d connections opened", opened, len(targets))
+	}
+	if !eventually(func() bool { return len(seen()) == len(targets) }) {
+		t.Fatalf("listener saw %d connections, want %d", len(seen()), len(targets))
+	}
+
+	// Every connection leaves from the range, which is cycled through
+	used := make(map[int]bool)
+	for _, source := range seen() {
+		if source < min || source > max {
+			t.Errorf("connection from port %d, want %d-%d", source, min, max)
+		}
+		used[source] = true
+	}
+	if len(used) != max-min+1 {
+		t.Errorf("used source ports %v, want all of %d-%d", used, min, max)
+	}
+}
+
+func TestSourcePortRangeSkipsPortInUse(t *testing.T) {
+	port, seen := remotePorts(t)
+	min, max := freePortRange(t, 3)
+	// A listener holds the first port, so the dial moves on to the next
+	listenOn(t, "127.0.0.1", min)
+
+	r := NewSourcePortRange(min, max)
+	conn, _, err := r.dial(context.Background(), nil, stubAddress("127.0.0.1", port), time.Second, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if !eventually(func() bool { return len(seen()) == 1 }) || seen()[0] != min+1 {
+		t.Errorf("connection came from %v, want port %d", seen(), min+1)
+	}
+}
+
+func TestSourcePortRangeWaitsForFreePort(t *testing.T) {
+	port, seen := remotePorts(t)
+	min, max := freePortRange(t, 2)
+	listenOn(t, "127.0.0.1", min)
+	held, err := net.Listen("tcp", stubAddress("127.0.0.1", max))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer held.Close()
+
+	// Every port is in use until the second one is let go
+	r := NewSourcePortRange(min, max)
+	time.AfterFunc(100*time.Millisecond, func() { held.Close() })
+	start := time.Now()
+	conn, connectTime, err := r.dial(context.Background(), nil, stubAddress("127.0.0.1", port), time.Second, nil)
+	if err != nil {
+		t.Fatalf("dial after a port freed up: %v", err)
+	}
+	conn.Close()
+	if waited := time.Since(start); waited < 100*time.Millisecond || connectTime >= waited {
+		t.Errorf("waited %s with a %s connect, want the wait outside the connect time", waited, connectTime)
+	}
+	if !eventually(func() bool { return len(seen()) == 1 }) || seen()[0] != max {
+		t.Errorf("connection came from %v, want port %d", seen(), max)
+	}
+}
+
+func TestSourcePortRangeExhausted(t *testing.T) {
+	port := listenOn(t, "127.0.0.1", 0)
+	min, max := freePortRange(t, 2)
+	listenOn(t, "127.0.0.1", min)
+	listenOn(t, "127.0.0.1", max)
+
+	// With every port held, dial waits until its context ends
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	r := NewSourcePortRange(min, max)
+	conn, _, err := r.dial(ctx, nil, stubAddress("127.0.0.1", port), time.Second, nil)
+	if err == nil {
+		conn.Close()
+		t.Fatal("dial succeeded with every source port in use")
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("dial = %v, want the context's deadline", err)
+	}
+}
+
+func TestTCPScannerWaitsForSourcePort(t *testing.T) {
+	port := listenOn(t, "127.0.0.1", 0)
+	min, max := freePortRange(t, 1)
+	held, err := net.Listen("tcp", stubAddress("127.0.0.1", min))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer held.Close()
+	s := NewTCPScanner([]string{"127.0.0.1/32"}, 1, 1)
+	s.SourcePorts = NewSourcePortRange(min, max)
+
+	// An open port is reported open once the source port frees up, not
+	// closed because it was busy
+	time.AfterFunc(100*time.Millisecond, func() { held.Close() })
+	if open, _ := s.probe(context.Background(), "127.0.0.1", port); !open {
+		t.Error("open port reported closed while the source port was busy")
+	}
+}
+
+func TestSourcePortRangeString(t *testing.T) {
+	if got := NewSourcePortRange(40000, 40000).String(); got != "40000" {
+		t.Errorf("single port = %q, want 40000", got)
+	}
+	if got := NewSourcePortRange(40000, 40100).String(); got != "40000-40100" {
+		t.Errorf("range = %q, want 40000-40100", got)
+	}
+
+	z := testZmapScanner()
+	z.SourcePorts = NewSourcePortRange(40000, 40100).String()
+	args, cleanup, err := z.zmapArgs("10.0.0.0/24", 22, "-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	found := false
+	for _, arg := range args {
+		found = found || arg == "--source-port=40000-40100"
+	}
+	if !found {
+		t.Errorf("zmap args %q lack --source-port=40000-40100", args)
+	}
+}
@@ -0,0 +1,160 @@
+package scanner
+
+import (
+	"bufio"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// SSH transport message numbers used by the host key exchange
+const (
+	sshMsgDisconnect   = 1
+	sshMsgKexInit      = 20
+	sshMsgKexECDHInit  = 30
+	sshMsgKexECDHReply = 31
+)
+
+// maxSSHPacket bounds a packet read before keys are exchanged; RFC 4253
+// requires support for 35000 bytes
+const maxSSHPacket = 35000
+
+// The algorithms offered for the exchange. Only the key exchange and host
+// key lists matter: the exchange stops once the server has sent its host
+// key, before any cipher is used.
+var sshKexInitLists = []string{
+	"curve25519-sha256,curve25519-sha256@libssh.org",
+	"ssh-ed25519,ecdsa-sha2-nistp256,ecdsa-sha2-nistp384,ecdsa-sha2-nistp521,rsa-sha2-512,rsa-sha2-256,ssh-rsa",
+	"aes128-ctr,aes256-ctr,chacha20-poly1305@openssh.com",
+	"aes128-ctr,aes256-ctr,chacha20-poly1305@openssh.com",
+	"hmac-sha2-256,hmac-sha2-512",
+	"hmac-sha2-256,hmac-sha2-512",
+	"none",
+	"none",
+	"",
+	"",
+}
+
+// sshHostKey runs the first half of a curve25519 key exchange on a
+// connection whose server identification has been read, and returns the
+// server's host key algorithm and the hex SHA256 of the key blob, the same
+// digest OpenSSH prints base64-encoded. No keys are derived and nothing is
+// authenticated.
+func sshHostKey(conn net.Conn, reader *bufio.Reader, timeout time.Duration) (map[string]interface{}, error) {
+	conn.SetDeadline(time.Now().Add(timeout))
+	if _, err := io.WriteString(conn, "SSH-2.0-NetworkScanner_1.0\r\n"); err != nil {
+		return nil, err
+	}
+
+	kexInit := []byte{sshMsgKexInit}
+	cookie := make([]byte, 16)
+	rand.Read(cookie)
+	kexInit = append(kexInit, cookie...)
+	for _, list := range sshKexInitLists {
+		kexInit = appendSSHString(kexInit, []byte(list))
+	}
+	kexInit = append(kexInit, 0, 0, 0, 0, 0) // first_kex_packet_follows, reserved
+	if err := writeSSHPacket(conn, kexInit); err != nil {
+		return nil, err
+	}
+
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	sentInit := false
+	// The server's KEXINIT comes first; stray packets before the reply
+	// (SSH_MSG_IGNORE, DEBUG) are skipped
+	for i := 0; i < 8; i++ {
+		payload, err := readSSHPacket(reader)
+		if err != nil {
+			return nil, err
+		}
+		switch payload[0] {
+		case sshMsgKexInit:
+			if sentInit {
+				continue
+			}
+			ecdhInit := appendSSHString([]byte{sshMsgKexECDHInit}, key.PublicKey().Bytes())
+			if err := writeSSHPacket(conn, ecdhInit); err != nil {
+				return nil, err
+			}
+			sentInit = true
+		case sshMsgKexECDHReply:
+			blob, _, ok := readSSHString(payload[1:])
+			if !ok {
+				return nil, fmt.Errorf("malformed key exchange reply")
+			}
+			algorithm, _, ok := readSSHString(blob)
+			if !ok {
+				return nil, fmt.Errorf("malformed host key")
+			}
+			return map[string]interface{}{
+				"algorithm": string(algorithm),
+				"sha256":    fmt.Sprintf("%x", sha256.Sum256(blob)),
+			}, nil
+		case sshMsgDisconnect:
+			return nil, fmt.Errorf("server disconnected during key exchange")
+		}
+	}
+	return nil, fmt.Errorf("no key exchange reply")
+}
+
+// writeSSHPacket sends payload as an unencrypted binary packet: length,
+// padding length, payload and at least 4 bytes of padding, 8-byte aligned
+func writeSSHPacket(conn net.Conn, payload []byte) error {
+	padding := 8 - (5+len(payload))%8
+	if padding < 4 {
+		padding += 8
+	}
+	packet := make([]byte, 5, 5+len(payload)+padding)
+	binary.BigEndian.PutUint32(packet, uint32(1+len(payload)+padding))
+	packet[4] = byte(padding)
+	packet = append(packet, payload...)
+	packet = append(packet, make([]byte, padding)...)
+	_, err := conn.Write(packet)
+	return err
+}
+
+// readSSHPacket reads one unencrypted binary packet and returns its payload
+func readSSHPacket(reader *bufio.Reader) ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(header[:])
+	if length < 2 || length > maxSSHPacket {
+		return nil, fmt.Errorf("bad packet length %d", length)
+	}
+	packet := make([]byte, length)
+	if _, err := io.ReadFull(reader, packet); err != nil {
+		return nil, err
+	}
+	padding := int(packet[0])
+	if padding >= len(packet)-1 {
+		return nil, fmt.Errorf("bad padding length %d", padding)
+	}
+	return packet[1 : len(packet)-padding], nil
+}
+
+func appendSSHString(b, s []byte) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
+
+// readSSHString splits a length-prefixed string off the front of b
+func readSSHString(b []byte) (s, rest []byte, ok bool) {
+	if len(b) < 4 {
+		return nil, nil, false
+	}
+	n := binary.BigEndian.Uint32(b)
+	if uint64(n) > uint64(len(b)-4) {
+		return nil, nil, false
+	}
+	return b[4 : 4+n], b[4+n:], true
+}
@@ -0,0 +1,156 @@
+package scanner
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sshMsgIgnore is a packet the client must skip during the exchange
+const sshMsgIgnore = 2
+
+// ed25519Blob is the wire encoding of an ssh-ed25519 public key
+func ed25519Blob(key byte) []byte {
+	public := make([]byte, 32)
+	for i := range public {
+		public[i] = key
+	}
+	return appendSSHString(appendSSHString(nil, []byte("ssh-ed25519")), public)
+}
+
+// sshKexServer is an SSH server that gets as far as sending its host key
+// blob in the key exchange reply. reply, when set, replaces the reply
+// packet's payload.
+func sshKexServer(t *testing.T, blob []byte, reply []byte) (string, int) {
+	t.Helper()
+	return stubServer(t, func(conn net.Conn) {
+		conn.Write([]byte(sshBanner + "\r\n"))
+		reader := bufio.NewReader(conn)
+		if line, err := reader.ReadString('\n'); err != nil || !strings.HasPrefix(line, "SSH-2.0-") {
+			return
+		}
+		if payload, err := readSSHPacket(reader); err != nil || payload[0] != sshMsgKexInit {
+			return
+		}
+		kexInit := append([]byte{sshMsgKexInit}, make([]byte, 16)...)
+		for _, list := range sshKexInitLists {
+			kexInit = appendSSHString(kexInit, []byte(list))
+		}
+		kexInit = append(kexInit, 0, 0, 0, 0, 0)
+		writeSSHPacket(conn, []byte{sshMsgIgnore, 0, 0, 0, 0})
+		writeSSHPacket(conn, kexInit)
+
+		payload, err := readSSHPacket(reader)
+		if err != nil || payload[0] != sshMsgKexECDHInit {
+			return
+		}
+		if _, rest, ok := readSSHString(payload[1:]); !ok || len(rest) != 0 {
+			t.Errorf("client ECDH init % x is not one string", payload)
+		}
+		if reply == nil {
+			reply = appendSSHString([]byte{sshMsgKexECDHReply}, blob)
+			reply = appendSSHString(reply, make([]byte, 32))    // server ephemeral key
+			reply = appendSSHString(reply, []byte("signature")) // never checked
+		}
+		writeSSHPacket(conn, reply)
+		// Hold the connection until the client hangs up
+		reader.ReadByte()
+	})
+}
+
+func TestProbeSSHHostKey(t *testing.T) {
+	f := testFingerprinter()
+	blob := ed25519Blob(1)
+	want := fmt.Sprintf("%x", sha256.Sum256(blob))
+
+	// Two servers with the same key report the same digest, another key a
+	// different one
+	var digests []string
+	for _, key := range [][]byte{blob, blob, ed25519Blob(2)} {
+		ip, port := sshKexServer(t, key, nil)
+		info := f.probeSSH(ip, port)
+		hostKey, ok := info.Fingerprint["host_key"].(map[string]interface{})
+		if !ok {
+			t.Fatalf("fingerprint = %v, want host_key", info.Fingerprint)
+		}
+		if hostKey["algorithm"] != "ssh-ed25519" {
+			t.Errorf("algorithm = %v, want ssh-ed25519", hostKey["algorithm"])
+		}
+		if info.ServiceName != "ssh" || !strings.HasPrefix(info.ServiceVersion, "OpenSSH_9.6p1") {
+			t.Errorf("service = %q %q", info.ServiceName, info.ServiceVersion)
+		}
+		digest, _ := hostKey["sha256"].(string)
+		digests = append(digests, digest)
+	}
+	if digests[0] != want || digests[1] != want {
+		t.Errorf("digests of the shared key = %v, want %s", digests[:2], want)
+	}
+	if digests[2] == want || len(digests[2]) != 64 {
+		t.Errorf("digest of another key = %q", digests[2])
+	}
+}
+
+func TestProbeSSHHostKeyFailures(t *testing.T) {
+	for name, reply := range map[string][]byte{
+		"disconnect":      {sshMsgDisconnect, 0, 0, 0, 11},
+		"truncated reply": {sshMsgKexECDHReply, 0, 0, 1, 0, 's'},
+		"malformed blob":  appendSSHString([]byte{sshMsgKexECDHReply}, []byte{0, 0, 0, 9, 's', 's', 'h'}),
+	} {
+		ip, port := sshKexServer(t, nil, reply)
+		f := testFingerprinter()
+		f.Timeout = 500 * time.Millisecond
+		info := f.probeSSH(ip, port)
+		if _, ok := info.Fingerprint["host_key"]; ok {
+			t.Errorf("%s: host_key = %v, want none", name, info.Fingerprint["host_key"])
+		}
+		// The banner is reported all the same
+		if info.ServiceName != "ssh" || !strings.HasPrefix(info.Banner, sshBanner) {
+			t.Errorf("%s: service %q banner %q", name, info.ServiceName, info.Banner)
+		}
+	}
+
+	// A server that sends only its banner
+	ip, port := stubServer(t, func(conn net.Conn) { conn.Write([]byte(sshBanner + "\r\n")) })
+	if info := testFingerprinter().probeSSH(ip, port); info.Fingerprint["host_key"] != nil || !strings.HasPrefix(info.ServiceVersion, "OpenSSH_9.6p1") {
+		t.Errorf("banner only: %+v", info)
+	}
+}
+
+func TestSSHPacketRoundTrip(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	reader := bufio.NewReader(server)
+
+	for _, size := range []int{1, 3, 7, 8, 100} {
+		payload := make([]byte, size)
+		payload[0] = sshMsgKexInit
+		go writeSSHPacket(client, payload)
+		got, err := readSSHPacket(reader)
+		if err != nil || len(got) != size {
+			t.Errorf("%d-byte payload read back as %d bytes, %v", size, len(got), err)
+		}
+	}
+
+	for name, packet := range map[string][]byte{
+		"too short":     {0, 0, 0, 1, 0},
+		"too long":      {0, 0, 0x90, 0, 0},
+		"padding":       {0, 0, 0, 4, 3, 20, 0, 0},
+		"truncated":     {0, 0, 0, 12, 4, 20},
+		"no length yet": {0, 0},
+	} {
+		client, server := net.Pipe()
+		go func() {
+			client.Write(packet)
+			client.Close()
+		}()
+		if _, err := readSSHPacket(bufio.NewReader(server)); err == nil {
+			t.Errorf("%s: packet % x accepted", name, packet)
+		}
+		server.Close()
+	}
+}
@@ -0,0 +1,177 @@
+package scanner
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// A STARTTLS upgrade is attempted whenever the server advertises it. Only
+// the handshake is performed; nothing is sent over the encrypted session
+// except the closing QUIT when GracefulClose is set.
+
+// smtpStartTLS sends EHLO after the greeting and, if STARTTLS is in the
+// extension list, upgrades the connection. It returns the connection later
+// commands should use.
+func (f *Fingerprinter) smtpStartTLS(conn net.Conn, reader *bufio.Reader, info *ServiceInfo) net.Conn {
+	conn.SetDeadline(time.Now().Add(f.Timeout))
+	if _, err := fmt.Fprintf(conn, "EHLO scanner.local\r\n"); err != nil {
+		return conn
+	}
+	code, lines, err := readSMTPReplyLines(reader)
+	if err != nil || code != 250 {
+		return conn
+	}
+
+	offered := false
+	for _, line := range lines {
+		// Extension lines look like "250-STARTTLS" or "250 STARTTLS"
+		if len(line) > 4 && strings.EqualFold(strings.TrimSpace(line[4:]), "STARTTLS") {
+			offered = true
+		}
+	}
+	return f.startTLS(conn, info, offered, func() bool {
+		if _, err := fmt.Fprintf(conn, "STARTTLS\r\n"); err != nil {
+			return false
+		}
+		code, err := readSMTPReply(reader)
+		return err == nil && code == 220
+	})
+}
+
+// imapStartTLS issues CAPABILITY and, if STARTTLS is listed, upgrades the
+// connection
+func (f *Fingerprinter) imapStartTLS(conn net.Conn, reader *bufio.Reader, info *ServiceInfo) net.Conn {
+	conn.SetDeadline(time.Now().Add(f.Timeout))
+	if _, err := fmt.Fprintf(conn, "c CAPABILITY\r\n"); err != nil {
+		return conn
+	}
+
+	offered := false
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return conn
+		}
+		if strings.HasPrefix(line, "* ") && strings.Contains(strings.ToUpper(line), " STARTTLS") {
+			offered = true
+		}
+		if strings.HasPrefix(line, "c ") {
+			if !strings.HasPrefix(line, "c OK") {
+				return conn
+			}
+			break
+		}
+	}
+	return f.startTLS(conn, info, offered, func() bool {
+		if _, err := fmt.Fprintf(conn, "s STARTTLS\r\n"); err != nil {
+			return false
+		}
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return false
+			}
+			if strings.HasPrefix(line, "s ") {
+				return strings.HasPrefix(line, "s OK")
+			}
+		}
+	})
+}
+
+// pop3StartTLS issues CAPA and, if STLS is listed, upgrades the connection
+func (f *Fingerprinter) pop3StartTLS(conn net.Conn, reader *bufio.Reader, info *ServiceInfo) net.Conn {
+	conn.SetDeadline(time.Now().Add(f.Timeout))
+	if _, err := fmt.Fprintf(conn, "CAPA\r\n"); err != nil {
+		return conn
+	}
+	line, err := reader.ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "+OK") {
+		// Servers without CAPA can't advertise STLS
+		return conn
+	}
+
+	offered := false
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return conn
+		}
+		line = strings.TrimSpace(line)
+		if line == "." {
+			break
+		}
+		if strings.EqualFold(line, "STLS") {
+			offered = true
+		}
+	}
+	return f.startTLS(conn, info, offered, func() bool {
+		if _, err := fmt.Fprintf(conn, "STLS\r\n"); err != nil {
+			return false
+		}
+		line, err := reader.ReadString('\n')
+		return err == nil && strings.HasPrefix(line, "+OK")
+	})
+}
+
+// startTLS records whether STARTTLS is offered and, if so, runs request
+// (the protocol's upgrade command) followed by a TLS handshake. The
+// certificate details go in Fingerprint["tls"]. It returns the TLS
+// connection after a successful handshake, otherwise conn.
+func (f *Fingerprinter) startTLS(conn net.Conn, info *ServiceInfo, offered bool, request func() bool) net.Conn {
+	if info.Fingerprint == nil {
+		info.Fingerprint = make(map[string]interface{})
+	}
+	info.Fingerprint["starttls"] = offered
+	if !offered || !request() {
+		return conn
+	}
+
+	tlsConn := tls.Client(conn, &tls.Config{InsecureSkipVerify: true})
+	if err := tlsConn.Handshake(); err != nil {
+		info.Fingerprint["tls"] = map[string]interface{}{"error": err.Error()}
+		return conn
+	}
+	info.Fingerprint["tls"] = tlsStateInfo(tlsConn.ConnectionState())
+	return tlsConn
+}
+
+// tlsStateInfo summarizes a handshake using the same keys zgrab results
+// are recorded under
+func tlsStateInfo(state tls.ConnectionState) map[string]interface{} {
+	tlsInfo := map[string]interface{}{
+		"version":      tls.VersionName(state.Version),
+		"cipher_suite": tls.CipherSuiteName(state.CipherSuite),
+	}
+	if len(state.PeerCertificates) == 0 {
+		return tlsInfo
+	}
+
+	tlsInfo["certificate"] = certificateInfo(state.PeerCertificates[0])
+	tlsInfo["chain_length"] = len(state.PeerCertificates)
+	return tlsInfo
+}
+
+func certificateInfo(cert *x509.Certificate) map[string]interface{} {
+	certInfo := map[string]interface{}{
+		"valid_from":          cert.NotBefore.UTC().Format(time.RFC3339),
+		"valid_until":         cert.NotAfter.UTC().Format(time.RFC3339),
+		"signature_algorithm": cert.SignatureAlgorithm.String(),
+		"sha256":              fmt.Sprintf("%x", sha256.Sum256(cert.Raw)),
+	}
+	if cert.Subject.CommonName != "" {
+		certInfo["subject_cn"] = cert.Subject.CommonName
+	}
+	if cert.Issuer.CommonName != "" {
+		certInfo["issuer_cn"] = cert.Issuer.CommonName
+	}
+	if len(cert.DNSNames) > 0 {
+		certInfo["san_dns"] = cert.DNSNames
+	}
+	return certInfo
+}
@@ -0,0 +1,230 @@
+package scanner
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCertificate returns a self-signed server certificate for cn
+func testCertificate(t *testing.T, cn string) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		Issuer:       pkix.Name{CommonName: cn},
+		DNSNames:     []string{cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// starttlsProtocol describes one protocol's capability exchange for
+// starttlsStub
+type starttlsProtocol struct {
+	name     string
+	probe    func(f *Fingerprinter, ip string, port int) ServiceInfo
+	greeting string
+	capCmd   string
+	caps     func(offered bool) string // the reply to capCmd
+	upgrade  string                    // the STARTTLS command
+	accept   string                    // the reply that precedes the handshake
+	reject   string
+}
+
+var starttlsProtocols = []starttlsProtocol{
+	{
+		name: "smtp", probe: (*Fingerprinter).probeSMTP,
+		greeting: "220 mail.example ESMTP\r\n", capCmd: "EHLO scanner.local",
+		caps: func(offered bool) string {
+			if offered {
+				return "250-mail.example\r\n250-STARTTLS\r\n250 8BITMIME\r\n"
+			}
+			return "250-mail.example\r\n250 8BITMIME\r\n"
+		},
+		upgrade: "STARTTLS", accept: "220 2.0.0 Ready to start TLS\r\n", reject: "454 4.7.0 TLS not available\r\n",
+	},
+	{
+		name: "imap", probe: (*Fingerprinter).probeIMAP,
+		greeting: "* OK Dovecot ready.\r\n", capCmd: "c CAPABILITY",
+		caps: func(offered bool) string {
+			if offered {
+				return "* CAPABILITY IMAP4rev1 STARTTLS LOGINDISABLED\r\nc OK done\r\n"
+			}
+			return "* CAPABILITY IMAP4rev1 AUTH=PLAIN\r\nc OK done\r\n"
+		},
+		upgrade: "s STARTTLS", accept: "s OK Begin TLS negotiation now\r\n", reject: "s BAD not now\r\n",
+	},
+	{
+		name: "pop3", probe: (*Fingerprinter).probePOP3,
+		greeting: "+OK Dovecot ready.\r\n", capCmd: "CAPA",
+		caps: func(offered bool) string {
+			if offered {
+				return "+OK\r\nUSER\r\nSTLS\r\n.\r\n"
+			}
+			return "+OK\r\nUSER\r\n.\r\n"
+		},
+		upgrade: "STLS", accept: "+OK Begin TLS\r\n", reject: "-ERR not now\r\n",
+	},
+}
+
+// starttlsStub runs p's capability exchange, advertising STARTTLS when
+// offered and, on the upgrade command, answering accept or reject
+// (handshaking with cert after accept). A nil cert makes the handshake
+// fail.
+func starttlsStub(t *testing.T, p starttlsProtocol, offered, accept bool, cert *tls.Certificate) (string, int) {
+	t.Helper()
+	return stubServer(t, func(conn net.Conn) {
+		conn.Write([]byte(p.greeting))
+		reader := bufio.NewReader(conn)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch strings.TrimRight(line, "\r\n") {
+			case p.capCmd:
+				conn.Write([]byte(p.caps(offered)))
+			case p.upgrade:
+				if !accept {
+					conn.Write([]byte(p.reject))
+					continue
+				}
+				conn.Write([]byte(p.accept))
+				if cert == nil {
+					conn.Write([]byte("not a TLS record\r\n"))
+					return
+				}
+				tlsConn := tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{*cert}})
+				if tlsConn.Handshake() != nil {
+					return
+				}
+				bufio.NewReader(tlsConn).ReadString('\n')
+				return
+			}
+		}
+	})
+}
+
+func TestSTARTTLSAdvertised(t *testing.T) {
+	cert := testCertificate(t, "mail.example")
+	for _, p := range starttlsProtocols {
+		ip, port := starttlsStub(t, p, true, true, &cert)
+		f := testFingerprinter()
+		f.GracefulClose = true // QUIT goes over the upgraded connection
+		info := p.probe(f, ip, port)
+
+		if info.Fingerprint["starttls"] != true {
+			t.Errorf("%s: starttls = %v, want true", p.name, info.Fingerprint["starttls"])
+		}
+		tlsInfo, _ := info.Fingerprint["tls"].(map[string]interface{})
+		certInfo, _ := tlsInfo["certificate"].(map[string]interface{})
+		if certInfo["subject_cn"] != "mail.example" || tlsInfo["version"] != "TLS 1.3" {
+			t.Errorf("%s: tls = %v, want the stub's certificate", p.name, tlsInfo)
+		}
+	}
+}
+
+func TestSTARTTLSNotAdvertised(t *testing.T) {
+	for _, p := range starttlsProtocols {
+		ip, port := starttlsStub(t, p, false, true, nil)
+		info := p.probe(testFingerprinter(), ip, port)
+		if info.Fingerprint["starttls"] != false {
+			t.Errorf("%s: starttls = %v, want false", p.name, info.Fingerprint["starttls"])
+		}
+		if _, ok := info.Fingerprint["tls"]; ok {
+			t.Errorf("%s: tls recorded without STARTTLS: %v", p.name, info.Fingerprint["tls"])
+		}
+		if info.Banner == "" {
+			t.Errorf("%s: banner lost", p.name)
+		}
+	}
+}
+
+func TestSTARTTLSRefused(t *testing.T) {
+	for _, p := range starttlsProtocols {
+		ip, port := starttlsStub(t, p, true, false, nil)
+		info := p.probe(testFingerprinter(), ip, port)
+		if info.Fingerprint["starttls"] != true {
+			t.Errorf("%s: starttls = %v, want true as advertised", p.name, info.Fingerprint["starttls"])
+		}
+		if _, ok := info.Fingerprint["tls"]; ok {
+			t.Errorf("%s: tls recorded after the upgrade was refused", p.name)
+		}
+	}
+}
+
+func TestSTARTTLSHandshakeFails(t *testing.T) {
+	for _, p := range starttlsProtocols {
+		ip, port := starttlsStub(t, p, true, true, nil)
+		info := p.probe(testFingerprinter(), ip, port)
+		tlsInfo, _ := info.Fingerprint["tls"].(map[string]interface{})
+		if info.Fingerprint["starttls"] != true || tlsInfo["error"] == nil {
+			t.Errorf("%s: fingerprint = %v, want starttls with a handshake error", p.name, info.Fingerprint)
+		}
+	}
+}
+
+func TestProbeHTTPSCertificateSHA256(t *testing.T) {
+	shared := testCertificate(t, "*.example.com")
+	want := fmt.Sprintf("%x", sha256.Sum256(shared.Certificate[0]))
+
+	// Three hosts presenting the same certificate report the same digest,
+	// one with its own certificate another
+	f := testFingerprinter()
+	var digests []string
+	for _, cert := range []tls.Certificate{shared, shared, shared, testCertificate(t, "*.example.com")} {
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		server.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+		server.StartTLS()
+		t.Cleanup(server.Close)
+		addr := server.Listener.Addr().(*net.TCPAddr)
+
+		info := f.probeHTTP(addr.IP.String(), addr.Port, true)
+		tlsInfo, _ := info.Fingerprint["tls"].(map[string]interface{})
+		certInfo, _ := tlsInfo["certificate"].(map[string]interface{})
+		if certInfo["subject_cn"] != "*.example.com" {
+			t.Fatalf("certificate = %v, want the server's", certInfo)
+		}
+		digest, _ := certInfo["sha256"].(string)
+		digests = append(digests, digest)
+	}
+	for i, digest := range digests[:3] {
+		if digest != want {
+			t.Errorf("host %d digest = %q, want %s", i, digest, want)
+		}
+	}
+	if digests[3] == want || len(digests[3]) != 64 {
+		t.Errorf("another certificate's digest = %q", digests[3])
+	}
+
+	// Plain HTTP has no tls
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(server.Close)
+	addr := server.Listener.Addr().(*net.TCPAddr)
+	if info := f.probeHTTP(addr.IP.String(), addr.Port, false); info.Fingerprint["tls"] != nil {
+		t.Errorf("plain HTTP tls = %v", info.Fingerprint["tls"])
+	}
+}
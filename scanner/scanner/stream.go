@@ -0,0 +1,75 @@
+package scanner
+
+import (
+	"sync"
+	"time"
+)
+
+// portStream hands one port's results to a callback in chunks: every
+// interval while zmap is still running, then the remainder in finish.
+// Callback calls are serialized.
+type portStream struct {
+	port     int
+	callback PortScanCallback
+
+	mu      sync.Mutex
+	pending []ZmapResult
+
+	callbackMu sync.Mutex
+	stop       chan struct{}
+	stopped    chan struct{}
+}
+
+// newPortStream starts flushing results for port every interval
+func newPortStream(port int, interval time.Duration, callback PortScanCallback) *portStream {
+	s := &portStream{
+		port:     port,
+		callback: callback,
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go func() {
+		defer close(s.stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+				s.flush()
+			}
+		}
+	}()
+	return s
+}
+
+// add queues a result for the next flush
+func (s *portStream) add(r ZmapResult) {
+	s.mu.Lock()
+	s.pending = append(s.pending, r)
+	s.mu.Unlock()
+}
+
+// flush passes everything queued so far to the callback. The queue is
+// released first so results keep arriving while the callback runs.
+func (s *portStream) flush() {
+	s.callbackMu.Lock()
+	defer s.callbackMu.Unlock()
+
+	s.mu.Lock()
+	batch := s.pending
+	s.pending = nil
+	s.mu.Unlock()
+
+	if len(batch) > 0 {
+		s.callback(s.port, batch)
+	}
+}
+
+// finish stops the periodic flush and delivers what is left
+func (s *portStream) finish() {
+	close(s.stop)
+	<-s.stopped
+	s.flush()
+}
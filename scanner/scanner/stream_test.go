@@ -0,0 +1,92 @@
+package scanner
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestZmapFlushIntervalSubmitsEarly(t *testing.T) {
+	output, hosts := zmapCSV(1000)
+	fakeZmap(t, output)
+	z := testZmapScanner("10.0.0.0/16")
+	z.OutputDir = t.TempDir()
+	z.FlushInterval = 20 * time.Millisecond
+
+	var mu sync.Mutex
+	var batches [][]ZmapResult
+	found, err := z.ScanPortsWithCallback(context.Background(), []int{22}, func(port int, results []ZmapResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		batches = append(batches, results)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// zmap pauses 0.3s after writing the first half; those hosts are
+	// handed on during the pause, so the first flush holds none of the rest
+	if len(batches) < 2 {
+		t.Fatalf("got %d callbacks, want the port's hosts in several flushes", len(batches))
+	}
+	firstHalf := make(map[string]bool, len(hosts)/2)
+	for _, ip := range hosts[:len(hosts)/2] {
+		firstHalf[ip] = true
+	}
+	for _, r := range batches[0] {
+		if !firstHalf[r.IP] {
+			t.Fatalf("first flush holds %s, written after the pause", r.IP)
+		}
+	}
+
+	// Every host is delivered exactly once
+	seen := make(map[string]int)
+	for _, batch := range batches {
+		for _, r := range batch {
+			if r.Port != 22 {
+				t.Errorf("%s delivered for port %d, want 22", r.IP, r.Port)
+			}
+			seen[r.IP]++
+		}
+	}
+	if len(seen) != len(hosts) || len(found) != len(hosts) {
+		t.Fatalf("delivered %d hosts, found %d, want %d", len(seen), len(found), len(hosts))
+	}
+	for ip, n := range seen {
+		if n != 1 {
+			t.Errorf("%s delivered %d times", ip, n)
+		}
+	}
+}
+
+func TestZmapNoFlushIntervalOneCallbackPerPort(t *testing.T) {
+	output, hosts := zmapCSV(100)
+	fakeZmap(t, output)
+	z := testZmapScanner("10.0.0.0/16")
+	z.OutputDir = t.TempDir()
+
+	var calls []int
+	_, err := z.ScanPortsWithCallback(context.Background(), []int{22}, func(port int, results []ZmapResult) {
+		calls = append(calls, len(results))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 1 || calls[0] != len(hosts) {
+		t.Errorf("callbacks delivered %v hosts, want one callback with %d", calls, len(hosts))
+	}
+}
+
+func TestPortStreamFinishDeliversRemainder(t *testing.T) {
+	var got []ZmapResult
+	s := newPortStream(22, time.Hour, func(port int, results []ZmapResult) {
+		got = append(got, results...)
+	})
+	s.add(ZmapResult{IP: "10.0.0.1", Port: 22})
+	s.add(ZmapResult{IP: "10.0.0.2", Port: 22})
+	s.finish()
+	if len(got) != 2 || got[0].IP != "10.0.0.1" || got[1].IP != "10.0.0.2" {
+		t.Errorf("finish delivered %+v, want both queued results in order", got)
+	}
+}
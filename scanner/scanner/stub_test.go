@@ -0,0 +1,115 @@
+package scanner
+
+import (
+	"net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// stubServer accepts loopback connections and runs handle on each until
+// the test ends. It returns the listener's IP and port.
+func stubServer(t *testing.T, handle func(conn net.Conn)) (string, int) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.SetDeadline(time.Now().Add(5 * time.Second))
+				handle(conn)
+			}()
+		}
+	}()
+	addr := listener.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port
+}
+
+// stubAddress joins ip and port as a dial address
+func stubAddress(ip string, port int) string {
+	return net.JoinHostPort(ip, strconv.Itoa(port))
+}
+
+// listenOn opens a listener on ip:port (port 0 for any) that accepts and
+// immediately closes connections until the test ends, and returns its port
+func listenOn(t testing.TB, ip string, port int) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", net.JoinHostPort(ip, strconv.Itoa(port)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// countAccepts opens a listener on ip that counts and closes the
+// connections it accepts, and returns its port and the count so far
+func countAccepts(t testing.TB, ip string) (int, func() int) {
+	t.Helper()
+	listener, err := net.Listen("tcp", net.JoinHostPort(ip, "0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	var accepted atomic.Int64
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			accepted.Add(1)
+			conn.Close()
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr).Port, func() int { return int(accepted.Load()) }
+}
+
+// eventually polls cond until it holds or a second has passed
+func eventually(cond func() bool) bool {
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return true
+}
+
+// closedPort returns a loopback port nothing listens on
+func closedPort(t testing.TB) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+	return port
+}
+
+// testFingerprinter is a native fingerprinter with short timeouts
+func testFingerprinter() *Fingerprinter {
+	f := NewFingerprinter()
+	f.Timeout = 2 * time.Second
+	return f
+}
@@ -0,0 +1,146 @@
+package scanner
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"strconv"
+	"time"
+)
+
+// TACACS+ packet header fields (RFC 8907)
+const (
+	tacacsHeaderLen    = 12
+	tacacsMajorVersion = 0xc
+	tacacsTypeAuthen   = 1
+	tacacsTypeAuthor   = 2
+	tacacsTypeAcct     = 3
+)
+
+// tacacsProbeKey obfuscates the probe's body. The server won't share it,
+// so it answers with an error reply or closes the connection.
+const tacacsProbeKey = "network-scanner"
+
+// tacacsHeader is a parsed TACACS+ packet header
+type tacacsHeader struct {
+	MinorVersion byte
+	Type         byte
+	SeqNo        byte
+	Flags        byte
+	SessionID    uint32
+	Length       uint32
+}
+
+// buildTACACSAuthenStart encodes an authentication START for an ASCII login
+// by a dummy user, its body obfuscated under key
+func buildTACACSAuthenStart(sessionID uint32, key string) []byte {
+	user, port := "probe", "tty0"
+	body := []byte{
+		1, // action: LOGIN
+		1, // priv_lvl: user
+		1, // authen_type: ASCII
+		1, // authen_service: LOGIN
+		byte(len(user)), byte(len(port)), 0, 0,
+	}
+	body = append(body, user...)
+	body = append(body, port...)
+
+	header := make([]byte, tacacsHeaderLen)
+	header[0] = tacacsMajorVersion << 4
+	header[1] = tacacsTypeAuthen
+	header[2] = 1 // seq_no
+	binary.BigEndian.PutUint32(header[4:8], sessionID)
+	binary.BigEndian.PutUint32(header[8:12], uint32(len(body)))
+
+	tacacsObfuscate(body, header, key)
+	return append(header, body...)
+}
+
+// tacacsObfuscate XORs body with the MD5 pad keyed on the header's session
+// ID, version and sequence number (RFC 8907 4.5)
+func tacacsObfuscate(body, header []byte, key string) {
+	seed := append(append(append([]byte(nil), header[4:8]...), key...), header[0], header[2])
+	var pad []byte
+	var prev []byte
+	for len(pad) < len(body) {
+		sum := md5.Sum(append(append([]byte(nil), seed...), prev...))
+		prev = sum[:]
+		pad = append(pad, prev...)
+	}
+	for i := range body {
+		body[i] ^= pad[i]
+	}
+}
+
+// parseTACACSHeader validates a reply header for sessionID. A server's
+// first reply has sequence number 2.
+func parseTACACSHeader(data []byte, sessionID uint32) (tacacsHeader, error) {
+	var h tacacsHeader
+	if len(data) < tacacsHeaderLen {
+		return h, fmt.Errorf("short TACACS+ header")
+	}
+	if data[0]>>4 != tacacsMajorVersion {
+		return h, fmt.Errorf("not a TACACS+ packet")
+	}
+	h = tacacsHeader{
+		MinorVersion: data[0] & 0x0f,
+		Type:         data[1],
+		SeqNo:        data[2],
+		Flags:        data[3],
+		SessionID:    binary.BigEndian.Uint32(data[4:8]),
+		Length:       binary.BigEndian.Uint32(data[8:12]),
+	}
+	if h.Type < tacacsTypeAuthen || h.Type > tacacsTypeAcct {
+		return h, fmt.Errorf("unknown TACACS+ packet type %d", h.Type)
+	}
+	if h.SeqNo != 2 || h.SessionID != sessionID {
+		return h, fmt.Errorf("TACACS+ reply does not match the request")
+	}
+	return h, nil
+}
+
+// probeTACACS sends an authentication START under a dummy key and checks
+// for a TACACS+ reply header. A server that closes without replying (e.g.
+// one that only talks to known clients) is still recorded, with
+// tacacs_response false.
+func (f *Fingerprinter) probeTACACS(ip string, port int) ServiceInfo {
+	var info ServiceInfo
+	address := net.JoinHostPort(ip, strconv.Itoa(port))
+
+	conn, err := f.dial(address)
+	if err != nil {
+		return info
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(f.Timeout))
+
+	sessionID := rand.Uint32()
+	if _, err := conn.Write(buildTACACSAuthenStart(sessionID, tacacsProbeKey)); err != nil {
+		return info
+	}
+
+	info.ServiceName = "tacacs"
+	info.Fingerprint = map[string]interface{}{"tacacs_response": false}
+
+	reply := make([]byte, tacacsHeaderLen)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return info
+	}
+	header, err := parseTACACSHeader(reply, sessionID)
+	if err != nil {
+		// Something else answered on the port; let the banner speak
+		info.ServiceName = ""
+		info.Fingerprint = nil
+		info.Banner = sanitizeBanner(string(reply))
+		return info
+	}
+
+	info.Banner = fmt.Sprintf("TACACS+ (minor version %d)", header.MinorVersion)
+	info.Fingerprint["tacacs_response"] = true
+	info.Fingerprint["tacacs_minor_version"] = int(header.MinorVersion)
+	return info
+}
@@ -0,0 +1,96 @@
+package scanner
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+)
+
+// tacacsStub reads an authentication START and answers with a REPLY header
+// for its session, decoding the body under tacacsProbeKey into started
+func tacacsStub(t *testing.T, minor byte, started chan<- []byte) (string, int) {
+	t.Helper()
+	return stubServer(t, func(conn net.Conn) {
+		header := make([]byte, tacacsHeaderLen)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		body := make([]byte, binary.BigEndian.Uint32(header[8:12]))
+		if _, err := io.ReadFull(conn, body); err != nil {
+			return
+		}
+		tacacsObfuscate(body, header, tacacsProbeKey)
+		started <- body
+
+		// REPLY with status FAIL, obfuscated under a key the probe lacks
+		reply := make([]byte, tacacsHeaderLen)
+		reply[0] = tacacsMajorVersion<<4 | minor
+		reply[1] = tacacsTypeAuthen
+		reply[2] = header[2] + 1
+		copy(reply[4:8], header[4:8])
+		binary.BigEndian.PutUint32(reply[8:12], 6)
+		conn.Write(append(reply, 0x9a, 0x12, 0x44, 0x01, 0x7c, 0xee))
+	})
+}
+
+func TestProbeTACACSHeader(t *testing.T) {
+	started := make(chan []byte, 1)
+	ip, port := tacacsStub(t, 1, started)
+
+	info := testFingerprinter().probeTACACS(ip, port)
+	if info.ServiceName != "tacacs" || info.Banner != "TACACS+ (minor version 1)" {
+		t.Errorf("probeTACACS = %+v, want tacacs minor version 1", info)
+	}
+	if info.Fingerprint["tacacs_response"] != true || info.Fingerprint["tacacs_minor_version"] != 1 {
+		t.Errorf("fingerprint = %v", info.Fingerprint)
+	}
+
+	// The START is a LOGIN for the dummy user, readable under the probe key
+	body := <-started
+	if body[0] != 1 || int(body[4]) != len("probe") || string(body[8:8+body[4]]) != "probe" {
+		t.Errorf("decoded START body % x, want a LOGIN for user probe", body)
+	}
+}
+
+func TestProbeTACACSClosedWithoutReply(t *testing.T) {
+	ip, port := stubServer(t, func(conn net.Conn) {
+		io.ReadFull(conn, make([]byte, tacacsHeaderLen))
+	})
+	info := testFingerprinter().probeTACACS(ip, port)
+	if info.ServiceName != "tacacs" || info.Fingerprint["tacacs_response"] != false {
+		t.Errorf("probeTACACS = %+v, want tacacs without a response", info)
+	}
+}
+
+func TestProbeTACACSOtherService(t *testing.T) {
+	ip, port := stubServer(t, func(conn net.Conn) {
+		io.WriteString(conn, "SSH-2.0-OpenSSH_9.6\r\n")
+		io.Copy(io.Discard, conn)
+	})
+	info := testFingerprinter().probeTACACS(ip, port)
+	if info.ServiceName != "" || info.Fingerprint != nil || info.Banner == "" {
+		t.Errorf("probeTACACS = %+v, want the other service's banner and no name", info)
+	}
+}
+
+func TestParseTACACSHeader(t *testing.T) {
+	valid := []byte{0xc1, tacacsTypeAuthor, 2, 0, 0, 0, 0, 42, 0, 0, 0, 0}
+	if h, err := parseTACACSHeader(valid, 42); err != nil || h.MinorVersion != 1 || h.Type != tacacsTypeAuthor {
+		t.Errorf("parseTACACSHeader = %+v, %v", h, err)
+	}
+	for name, tc := range map[string]struct {
+		data    []byte
+		session uint32
+	}{
+		"short":         {valid[:8], 42},
+		"wrong version": {append([]byte{0x11}, valid[1:]...), 42},
+		"unknown type":  {append([]byte{0xc1, 9}, valid[2:]...), 42},
+		"wrong session": {valid, 43},
+		"wrong seq":     {append([]byte{0xc1, tacacsTypeAuthor, 1}, valid[3:]...), 42},
+	} {
+		if _, err := parseTACACSHeader(tc.data, tc.session); err == nil {
+			t.Errorf("%s: parsed", name)
+		}
+	}
+}
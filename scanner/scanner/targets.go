@@ -0,0 +1,139 @@
+package scanner
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// LoadTargetsFile reads explicit endpoints from path. See ParseTargets.
+func LoadTargetsFile(ctx context.Context, path string, resolver *Resolver) ([]ZmapResult, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseTargets(ctx, f, resolver)
+}
+
+// ParseTargets parses one endpoint per line as "ip:port" or "ip:start-end"
+// (IPv6 addresses in brackets, e.g. "[2001:db8::1]:443"). Blank lines and
+// lines starting with # are ignored. Duplicate endpoints are dropped.
+// A hostname in place of the IP is resolved with resolver, giving one
+// endpoint per address, each carrying the hostname; without a resolver
+// hostnames are rejected. An endpoint reached through several hostnames
+// keeps the first.
+func ParseTargets(ctx context.Context, r io.Reader, resolver *Resolver) ([]ZmapResult, error) {
+	var targets []ZmapResult
+	seen := make(map[string]bool)
+
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		host, portSpec, err := net.SplitHostPort(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid target %q: %w", lineNum, line, err)
+		}
+		ips, err := resolveTarget(ctx, host, resolver)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+
+		start, end, err := parsePortRange(portSpec)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+
+		hostname := ""
+		if net.ParseIP(host) == nil {
+			hostname = host
+		}
+		for _, ip := range ips {
+			for port := start; port <= end; port++ {
+				key := net.JoinHostPort(ip, strconv.Itoa(port))
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+				targets = append(targets, ZmapResult{IP: ip, Port: port, Hostname: hostname})
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return targets, nil
+}
+
+// resolveTarget returns host itself when it is an IP, otherwise the
+// addresses it resolves to
+func resolveTarget(ctx context.Context, host string, resolver *Resolver) ([]string, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []string{ip.String()}, nil
+	}
+	if resolver == nil {
+		return nil, fmt.Errorf("invalid IP %q", host)
+	}
+
+	addrs, err := resolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", host, err)
+	}
+	ips := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		if ip := net.ParseIP(addr); ip != nil {
+			ips = append(ips, ip.String())
+		}
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("%s has no addresses", host)
+	}
+	return ips, nil
+}
+
+// parsePortRange parses "80" or "8000-8100"
+func parsePortRange(spec string) (int, int, error) {
+	startStr, endStr, isRange := strings.Cut(spec, "-")
+	start, err := strconv.Atoi(startStr)
+	if err != nil || start < 1 || start > 65535 {
+		return 0, 0, fmt.Errorf("invalid port %q", startStr)
+	}
+	if !isRange {
+		return start, start, nil
+	}
+	end, err := strconv.Atoi(endStr)
+	if err != nil || end < 1 || end > 65535 {
+		return 0, 0, fmt.Errorf("invalid port %q", endStr)
+	}
+	if end < start {
+		return 0, 0, fmt.Errorf("invalid port range %q", spec)
+	}
+	return start, end, nil
+}
+
+// GroupByPort groups endpoints by port, returning the ports in ascending order
+func GroupByPort(targets []ZmapResult) ([]int, map[int][]ZmapResult) {
+	byPort := make(map[int][]ZmapResult)
+	for _, t := range targets {
+		byPort[t.Port] = append(byPort[t.Port], t)
+	}
+	ports := make([]int, 0, len(byPort))
+	for port := range byPort {
+		ports = append(ports, port)
+	}
+	sort.Ints(ports)
+	return ports, byPort
+}
@@ -0,0 +1,97 @@
+package scanner
+
+import (
+	"context"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTargets(t *testing.T) {
+	input := `# web and ssh
+192.168.1.10:22
+192.168.1.10:8000-8002
+
+[2001:db8::1]:443
+192.168.1.10:22
+  10.0.0.5:80  
+`
+	targets, err := ParseTargets(context.Background(), strings.NewReader(input), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ZmapResult{
+		{IP: "192.168.1.10", Port: 22},
+		{IP: "192.168.1.10", Port: 8000},
+		{IP: "192.168.1.10", Port: 8001},
+		{IP: "192.168.1.10", Port: 8002},
+		{IP: "2001:db8::1", Port: 443},
+		{IP: "10.0.0.5", Port: 80},
+	}
+	if !reflect.DeepEqual(targets, want) {
+		t.Errorf("ParseTargets = %v, want %v", targets, want)
+	}
+}
+
+func TestParseTargetsHostnames(t *testing.T) {
+	doh := httptest.NewServer(&dohStub{records: map[string]map[uint16][]string{
+		"app.example.": {
+			dnsTypeA:    {"192.0.2.10", "192.0.2.11"},
+			dnsTypeAAAA: {"2001:db8::10"},
+		},
+		// Shares 192.0.2.11 with app.example
+		"www.example.": {dnsTypeA: {"192.0.2.11", "203.0.113.5"}},
+	}})
+	t.Cleanup(doh.Close)
+	resolver := NewResolver(doh.URL+"/dns-query", time.Second)
+
+	input := "app.example:443\nwww.example:443\n192.0.2.10:22\n"
+	targets, err := ParseTargets(context.Background(), strings.NewReader(input), resolver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ZmapResult{
+		{IP: "192.0.2.10", Port: 443, Hostname: "app.example"},
+		{IP: "192.0.2.11", Port: 443, Hostname: "app.example"},
+		{IP: "2001:db8::10", Port: 443, Hostname: "app.example"},
+		{IP: "203.0.113.5", Port: 443, Hostname: "www.example"},
+		{IP: "192.0.2.10", Port: 22},
+	}
+	if !reflect.DeepEqual(targets, want) {
+		t.Errorf("ParseTargets = %v, want %v", targets, want)
+	}
+}
+
+func TestParseTargetsErrors(t *testing.T) {
+	for _, line := range []string{
+		"192.168.1.10",
+		"192.168.1.10:0",
+		"192.168.1.10:65536",
+		"192.168.1.10:90-80",
+		"192.168.1.10:http",
+		"2001:db8::1:443",
+		"host.example:22", // hostnames need a resolver
+	} {
+		if _, err := ParseTargets(context.Background(), strings.NewReader("10.0.0.1:22\n"+line+"\n"), nil); err == nil {
+			t.Errorf("ParseTargets(%q) succeeded", line)
+		} else if !strings.HasPrefix(err.Error(), "line 2:") {
+			t.Errorf("ParseTargets(%q) error %q does not name line 2", line, err)
+		}
+	}
+}
+
+func TestGroupByPort(t *testing.T) {
+	ports, byPort := GroupByPort([]ZmapResult{
+		{IP: "10.0.0.1", Port: 443},
+		{IP: "10.0.0.1", Port: 22},
+		{IP: "10.0.0.2", Port: 443},
+	})
+	if !reflect.DeepEqual(ports, []int{22, 443}) {
+		t.Errorf("ports = %v, want [22 443]", ports)
+	}
+	if len(byPort[443]) != 2 || len(byPort[22]) != 1 {
+		t.Errorf("byPort = %v", byPort)
+	}
+}
@@ -2,9 +2,11 @@ package scanner
 
 import (
 	"context"
-	"fmt"
 	"log"
+	"math/rand/v2"
 	"net"
+	"sort"
+	"strconv"
 	"sync"
 	"time"
 )
@@ -14,8 +16,50 @@ type TCPScanner struct {
 	Networks []string
 	Rate     int           // concurrent connections
 	Timeout  time.Duration // connection timeout
+
+	// Scope optionally restricts which IPs are scanned
+	Scope *Scope
+
+	// Gate, when set, pauses the scan between connection attempts
+	Gate *PauseGate
+
+	// JitterMin/JitterMax add a random delay before each connection attempt
+	// to spread traffic out; zero disables
+	JitterMin time.Duration
+	JitterMax time.Duration
+
+	// ParallelPorts fans out across the full IP×port product with one shared
+	// semaphore instead of sweeping one port at a time
+	ParallelPorts bool
+
+	// SourceIP, when set, is the local address connections are made from
+	SourceIP net.IP
+
+	// SourcePorts, when set, is the range of local ports connections are
+	// made from
+	SourcePorts *SourcePortRange
+
+	// SocketOptions, when set, are applied to every connection
+	SocketOptions *SocketOptions
+
+	// Sockets, when set, caps the connections open at once across this
+	// scanner and anything sharing the budget
+	Sockets *SocketBudget
+
+	// AllPortsBatch is how many ports ScanAllPorts scans together
+	// (default DefaultAllPortsBatch)
+	AllPortsBatch int
+
+	// Interleave probes networks round-robin instead of one after another,
+	// so every network makes progress under the shared Rate, and reports
+	// each network's results for a port as soon as that network is done
+	Interleave bool
 }
 
+// DefaultAllPortsBatch is the default number of ports ScanAllPorts scans
+// together
+const DefaultAllPortsBatch = 1000
+
 // NewTCPScanner creates a new TCPScanner instance
 func NewTCPScanner(networks []string, rate int, timeoutSecs int) *TCPScanner {
 	if rate <= 0 {
@@ -43,8 +87,9 @@ func expandCIDR(cidr string) ([]string, error) {
 		ips = append(ips, ip.String())
 	}
 
-	// Remove network and broadcast addresses for /24 and smaller
-	if len(ips) > 2 {
+	// Remove IPv4 network and broadcast addresses for /30 and larger; IPv6
+	// has no broadcast and every address of a range is a host
+	if ip.To4() != nil && len(ips) > 2 {
 		ips = ips[1 : len(ips)-1]
 	}
 
@@ -60,21 +105,141 @@ func inc(ip net.IP) {
 	}
 }
 
-// ScanPort scans a specific port across all configured networks using TCP connect
-func (t *TCPScanner) ScanPort(ctx context.Context, port int) ([]ZmapResult, error) {
-	// Collect all IPs from all networks
+// scanTarget is one IP to probe and the index of the target group, one per
+// network when interleaving, that it is reported with
+type scanTarget struct {
+	ip    string
+	group int
+}
+
+// targets returns the IPs to probe in order along with the network each
+// group covers. Without Interleave all IPs form one group, network by
+// network; with it each network is a group and IPs alternate between them.
+func (t *TCPScanner) targets() ([]scanTarget, []string, error) {
+	networks, groups, err := scopedNetworkIPs(t.Networks, t.Scope)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var targets []scanTarget
+	if !t.Interleave {
+		for _, ips := range groups {
+			for _, ip := range ips {
+				targets = append(targets, scanTarget{ip: ip})
+			}
+		}
+		return targets, []string{"all networks"}, nil
+	}
+
+	total := 0
+	for _, ips := range groups {
+		total += len(ips)
+	}
+	targets = make([]scanTarget, 0, total)
+	for i := 0; len(targets) < total; i++ {
+		for group, ips := range groups {
+			if i < len(ips) {
+				targets = append(targets, scanTarget{ip: ips[i], group: group})
+			}
+		}
+	}
+	return targets, networks, nil
+}
+
+// scopedIPs expands networks to the individual IPs allowed by scope
+func scopedIPs(networks []string, scope *Scope) ([]string, error) {
+	_, groups, err := scopedNetworkIPs(networks, scope)
+	if err != nil {
+		return nil, err
+	}
 	var allIPs []string
-	for _, network := range t.Networks {
+	for _, ips := range groups {
+		allIPs = append(allIPs, ips...)
+	}
+	return allIPs, nil
+}
+
+// scopedNetworkIPs expands each network to the IPs allowed by scope,
+// returning the networks that have any alongside their IPs
+func scopedNetworkIPs(networks []string, scope *Scope) ([]string, [][]string, error) {
+	var names []string
+	var groups [][]string
+	for _, network := range scope.Networks(networks) {
 		ips, err := expandCIDR(network)
 		if err != nil {
 			log.Printf("Warning: failed to parse CIDR %s: %v", network, err)
 			continue
 		}
-		allIPs = append(allIPs, ips...)
+		var inScope []string
+		for _, ip := range ips {
+			if scope.Contains(net.ParseIP(ip)) {
+				inScope = append(inScope, ip)
+			}
+		}
+		if len(inScope) > 0 {
+			names = append(names, network)
+			groups = append(groups, inScope)
+		}
 	}
 
-	if len(allIPs) == 0 {
-		return nil, fmt.Errorf("no valid IPs to scan")
+	if len(groups) == 0 {
+		return nil, nil, ErrNoTargets
+	}
+	return names, groups, nil
+}
+
+// probe reports whether a TCP connect to ip:port succeeds and how long the
+// handshake took. Callers must acquire the semaphore before calling so the
+// measurement covers only the dial, which excludes any wait for the socket
+// budget too.
+func (t *TCPScanner) probe(ctx context.Context, ip string, port int) (bool, time.Duration) {
+	address := net.JoinHostPort(ip, strconv.Itoa(port))
+	var elapsed time.Duration
+	conn, err := t.Sockets.dial(ctx, func() (net.Conn, error) {
+		if t.SourcePorts != nil {
+			// Measured per attempt, excluding any wait for a free port
+			conn, connectTime, err := t.SourcePorts.dial(ctx, t.SourceIP, address, t.Timeout, t.SocketOptions)
+			elapsed = connectTime
+			return conn, err
+		}
+		start := time.Now()
+		defer func() { elapsed = time.Since(start) }()
+		return dialFrom(t.SourceIP, address, t.Timeout, t.SocketOptions)
+	})
+	if err != nil {
+		return false, 0
+	}
+	conn.Close()
+	return true, elapsed
+}
+
+// jitter sleeps a random duration in [JitterMin, JitterMax] before a
+// connection attempt. It returns the context's error if it is cancelled,
+// with or without jitter configured.
+func (t *TCPScanner) jitter(ctx context.Context) error {
+	if t.JitterMax <= 0 {
+		return ctx.Err()
+	}
+	delay := t.JitterMin
+	if span := t.JitterMax - t.JitterMin; span > 0 {
+		delay += time.Duration(rand.Int64N(int64(span)))
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// ScanPort scans a specific port across all configured networks using TCP connect
+func (t *TCPScanner) ScanPort(ctx context.Context, port int) ([]ZmapResult, error) {
+	targets, _, err := t.targets()
+	if err != nil {
+		return nil, err
 	}
 
 	var results []ZmapResult
@@ -84,11 +249,14 @@ func (t *TCPScanner) ScanPort(ctx context.Context, port int) ([]ZmapResult, erro
 	// Semaphore for rate limiting
 	sem := make(chan struct{}, t.Rate)
 
-	for _, ip := range allIPs {
-		select {
-		case <-ctx.Done():
-			return results, ctx.Err()
-		default:
+	for _, target := range targets {
+		if err := t.Gate.Wait(ctx); err != nil {
+			wg.Wait()
+			return results, err
+		}
+		if err := t.jitter(ctx); err != nil {
+			wg.Wait()
+			return results, err
 		}
 
 		wg.Add(1)
@@ -98,21 +266,56 @@ func (t *TCPScanner) ScanPort(ctx context.Context, port int) ([]ZmapResult, erro
 			defer wg.Done()
 			defer func() { <-sem }() // release
 
-			address := fmt.Sprintf("%s:%d", targetIP, port)
-			conn, err := net.DialTimeout("tcp", address, t.Timeout)
-			if err == nil {
-				conn.Close()
+			if open, elapsed := t.probe(ctx, targetIP, port); open {
 				mu.Lock()
-				results = append(results, ZmapResult{IP: targetIP, Port: port})
+				results = append(results, ZmapResult{IP: targetIP, Port: port, ConnectTime: elapsed})
 				mu.Unlock()
 			}
-		}(ip)
+		}(target.ip)
 	}
 
 	wg.Wait()
 	return results, nil
 }
 
+// CheckEndpoints connects to each target and reports whether it is still
+// open. Unlike ScanPort it probes exactly the given IP:port pairs, ignoring
+// Networks. callback calls are serialized.
+func (t *TCPScanner) CheckEndpoints(ctx context.Context, targets []ZmapResult, callback func(target ZmapResult, open bool)) error {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	sem := make(chan struct{}, t.Rate)
+
+	for _, target := range targets {
+		if err := t.Gate.Wait(ctx); err != nil {
+			wg.Wait()
+			return err
+		}
+		if err := t.jitter(ctx); err != nil {
+			wg.Wait()
+			return err
+		}
+
+		wg.Add(1)
+		sem <- struct{}{} // acquire
+
+		go func(target ZmapResult) {
+			defer wg.Done()
+			open, elapsed := t.probe(ctx, target.IP, target.Port)
+			<-sem // release before the callback, which may be slow
+			target.ConnectTime = elapsed
+
+			mu.Lock()
+			callback(target, open)
+			mu.Unlock()
+		}(target)
+	}
+
+	wg.Wait()
+	return nil
+}
+
 // PortScanCallback is called after each port is scanned with results
 type PortScanCallback func(port int, results []ZmapResult)
 
@@ -123,6 +326,10 @@ func (t *TCPScanner) ScanPorts(ctx context.Context, ports []int) (map[string][]i
 
 // ScanPortsWithCallback scans ports and calls callback after each port
 func (t *TCPScanner) ScanPortsWithCallback(ctx context.Context, ports []int, callback PortScanCallback) (map[string][]int, error) {
+	if t.ParallelPorts {
+		return t.scanPortsParallel(ctx, ports, callback)
+	}
+
 	results := make(map[string][]int)
 
 	for _, port := range ports {
@@ -133,7 +340,22 @@ func (t *TCPScanner) ScanPortsWithCallback(ctx context.Context, ports []int, cal
 		}
 
 		log.Printf("Scanning port %d across %d networks...", port, len(t.Networks))
+		if t.Interleave {
+			// Run the port through the group-aware scan so each network's
+			// hosts are reported as soon as that network is done
+			portResults, err := t.scanPortsParallel(ctx, []int{port}, callback)
+			for ip, ports := range portResults {
+				results[ip] = append(results[ip], ports...)
+			}
+			if err != nil {
+				return results, err
+			}
+			continue
+		}
 		portResults, err := t.ScanPort(ctx, port)
+		if fatalScanError(err) {
+			return results, err
+		}
 		if err != nil {
 			log.Printf("Error scanning port %d: %v", port, err)
 			continue
@@ -154,43 +376,142 @@ func (t *TCPScanner) ScanPortsWithCallback(ctx context.Context, ports []int, cal
 	return results, nil
 }
 
+// scanPortsParallel scans every IP×port pair under a single semaphore sized
+// by Rate. The callback still fires once per port, as soon as the last IP for
+// that port has been probed, or once per network and port with Interleave;
+// callbacks are serialized.
+func (t *TCPScanner) scanPortsParallel(ctx context.Context, ports []int, callback PortScanCallback) (map[string][]int, error) {
+	results := make(map[string][]int)
+
+	targets, groups, err := t.targets()
+	if err != nil {
+		return results, err
+	}
+
+	sizes := make([]int, len(groups))
+	for _, target := range targets {
+		sizes[target.group]++
+	}
+
+	type portState struct {
+		remaining []int
+		results   [][]ZmapResult
+	}
+	states := make(map[int]*portState, len(ports))
+	for _, port := range ports {
+		states[port] = &portState{
+			remaining: append([]int(nil), sizes...),
+			results:   make([][]ZmapResult, len(groups)),
+		}
+	}
+
+	log.Printf("Scanning %d ports x %d hosts in parallel...", len(ports), len(targets))
+
+	var mu sync.Mutex         // guards results and states
+	var callbackMu sync.Mutex // serializes callbacks
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, t.Rate)
+
+	for _, port := range ports {
+		for _, target := range targets {
+			if err := t.Gate.Wait(ctx); err != nil {
+				wg.Wait()
+				return results, err
+			}
+			if err := t.jitter(ctx); err != nil {
+				wg.Wait()
+				return results, err
+			}
+
+			wg.Add(1)
+			sem <- struct{}{} // acquire
+
+			go func(target scanTarget, targetPort int) {
+				defer wg.Done()
+
+				open, elapsed := t.probe(ctx, target.ip, targetPort)
+				<-sem // release before the callback so scanning continues
+
+				mu.Lock()
+				state := states[targetPort]
+				state.remaining[target.group]--
+				if open {
+					state.results[target.group] = append(state.results[target.group], ZmapResult{IP: target.ip, Port: targetPort, ConnectTime: elapsed})
+					results[target.ip] = append(results[target.ip], targetPort)
+				}
+				done := state.remaining[target.group] == 0
+				portResults := state.results[target.group]
+				mu.Unlock()
+
+				if !done {
+					return
+				}
+				if t.Interleave {
+					log.Printf("Port %d on %s: found %d hosts", targetPort, groups[target.group], len(portResults))
+				} else {
+					log.Printf("Port %d: found %d hosts", targetPort, len(portResults))
+				}
+				if callback != nil && len(portResults) > 0 {
+					callbackMu.Lock()
+					callback(targetPort, portResults)
+					callbackMu.Unlock()
+				}
+			}(target, port)
+		}
+	}
+
+	wg.Wait()
+	return results, nil
+}
+
 // ScanAllPorts scans all 65535 ports using TCP connect
 func (t *TCPScanner) ScanAllPorts(ctx context.Context) (map[string][]int, error) {
 	return t.ScanAllPortsWithCallback(ctx, nil)
 }
 
-// ScanAllPortsWithCallback scans all ports and calls the callback after each port
+// ScanAllPortsWithCallback scans all ports and calls the callback after each
+// port. Ports go through the parallel IP×port scan AllPortsBatch at a time,
+// which bounds the per-port bookkeeping while keeping Rate connections in
+// flight across port boundaries within a batch.
 func (t *TCPScanner) ScanAllPortsWithCallback(ctx context.Context, callback PortScanCallback) (map[string][]int, error) {
-	results := make(map[string][]int)
+	return t.scanPortRange(ctx, 1, 65535, callback)
+}
 
-	// Scan all 65535 ports
-	totalPorts := 65535
-	batchSize := 1000
+// scanPortRange scans ports first to last, AllPortsBatch at a time
+func (t *TCPScanner) scanPortRange(ctx context.Context, first, last int, callback PortScanCallback) (map[string][]int, error) {
+	results := make(map[string][]int)
 
-	for batchStart := 1; batchStart <= totalPorts; batchStart += batchSize {
-		batchEnd := batchStart + batchSize - 1
-		if batchEnd > totalPorts {
-			batchEnd = totalPorts
-		}
+	batchSize := t.AllPortsBatch
+	if batchSize <= 0 {
+		batchSize = DefaultAllPortsBatch
+	}
 
-		log.Printf("Scanning ports %d-%d across %d networks...", batchStart, batchEnd, len(t.Networks))
+	found := 0
+	for batchStart := first; batchStart <= last; batchStart += batchSize {
+		batchEnd := min(batchStart+batchSize-1, last)
 
-		// Generate port list for this batch
-		var batchPorts []int
+		batchPorts := make([]int, 0, batchEnd-batchStart+1)
 		for port := batchStart; port <= batchEnd; port++ {
 			batchPorts = append(batchPorts, port)
 		}
 
-		batchResults, err := t.ScanPortsWithCallback(ctx, batchPorts, callback)
-		if err != nil {
-			log.Printf("Error scanning ports %d-%d: %v", batchStart, batchEnd, err)
-			continue
-		}
-
+		batchResults, err := t.scanPortsParallel(ctx, batchPorts, callback)
 		for ip, ports := range batchResults {
 			results[ip] = append(results[ip], ports...)
+			found += len(ports)
 		}
+		// Errors are cancellation or no targets, which every batch would hit
+		if err != nil {
+			return results, err
+		}
+
+		log.Printf("Scanned ports %d-%d (%.1f%% of ports %d-%d): %d open endpoints so far",
+			batchStart, batchEnd, float64(batchEnd-first+1)*100/float64(last-first+1), first, last, found)
 	}
 
+	// Batches finish ports out of order; keep each host's list ascending
+	for _, ports := range results {
+		sort.Ints(ports)
+	}
 	return results, nil
 }
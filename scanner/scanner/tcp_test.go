@@ -0,0 +1,360 @@
+package scanner
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// loopbackTargets opens listeners on a few addresses of 127.0.0.0/29 and
+// returns the ports to scan and the hosts expected open on each
+func loopbackTargets(t testing.TB) ([]int, map[string][]int) {
+	t.Helper()
+	p1 := listenOn(t, "127.0.0.1", 0)
+	listenOn(t, "127.0.0.3", p1)
+	p2 := listenOn(t, "127.0.0.2", 0)
+	listenOn(t, "127.0.0.6", p2)
+	listenOn(t, "127.0.0.1", p2)
+	closed := closedPort(t)
+
+	want := map[string][]int{
+		"127.0.0.1": {p1, p2},
+		"127.0.0.2": {p2},
+		"127.0.0.3": {p1},
+		"127.0.0.6": {p2},
+	}
+	sort.Ints(want["127.0.0.1"])
+	return []int{p1, p2, closed}, want
+}
+
+// scanLoopback scans ports on 127.0.0.0/29 and returns the open ports per
+// host and the hosts each callback reported per port, both sorted
+func scanLoopback(t testing.TB, ports []int, configure func(*TCPScanner)) (map[string][]int, map[int][]string) {
+	t.Helper()
+	s := NewTCPScanner([]string{"127.0.0.0/29"}, 16, 1)
+	configure(s)
+
+	var mu sync.Mutex
+	reported := make(map[int][]string)
+	results, err := s.ScanPortsWithCallback(context.Background(), ports, func(port int, results []ZmapResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, r := range results {
+			if r.Port != port {
+				t.Errorf("callback for port %d got a result for port %d", port, r.Port)
+			}
+			reported[port] = append(reported[port], r.IP)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ip := range results {
+		sort.Ints(results[ip])
+	}
+	for port := range reported {
+		sort.Strings(reported[port])
+	}
+	return results, reported
+}
+
+func TestExpandCIDR(t *testing.T) {
+	tests := []struct {
+		cidr string
+		want []string
+	}{
+		{"192.168.1.0/30", []string{"192.168.1.1", "192.168.1.2"}},
+		{"192.168.1.5/32", []string{"192.168.1.5"}},
+		{"192.168.1.4/31", []string{"192.168.1.4", "192.168.1.5"}},
+		{"2001:db8::/126", []string{"2001:db8::", "2001:db8::1", "2001:db8::2", "2001:db8::3"}},
+	}
+	for _, tt := range tests {
+		got, err := expandCIDR(tt.cidr)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("expandCIDR(%s) = %v, %v, want %v", tt.cidr, got, err, tt.want)
+		}
+	}
+}
+
+func TestScanPortsParallelMatchesSequential(t *testing.T) {
+	ports, want := loopbackTargets(t)
+
+	sequential, seqReported := scanLoopback(t, ports, func(s *TCPScanner) {})
+	parallel, parReported := scanLoopback(t, ports, func(s *TCPScanner) { s.ParallelPorts = true })
+
+	if !reflect.DeepEqual(sequential, want) {
+		t.Errorf("sequential scan = %v, want %v", sequential, want)
+	}
+	if !reflect.DeepEqual(parallel, sequential) {
+		t.Errorf("parallel scan = %v, sequential = %v", parallel, sequential)
+	}
+	if !reflect.DeepEqual(parReported, seqReported) {
+		t.Errorf("parallel callbacks = %v, sequential = %v", parReported, seqReported)
+	}
+	if _, ok := seqReported[ports[2]]; ok {
+		t.Errorf("callback for closed port %d", ports[2])
+	}
+}
+
+func BenchmarkScanPorts(b *testing.B) {
+	ports, _ := loopbackTargets(b)
+	for _, mode := range []struct {
+		name     string
+		parallel bool
+	}{{"sequential", false}, {"parallel", true}} {
+		b.Run(mode.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				scanLoopback(b, ports, func(s *TCPScanner) { s.ParallelPorts = mode.parallel })
+			}
+		})
+	}
+}
+
+func TestScanPortRecordsConnectTime(t *testing.T) {
+	port := listenOn(t, "127.0.0.1", 0)
+	s := NewTCPScanner([]string{"127.0.0.1/32"}, 4, 1)
+
+	results, err := s.ScanPort(context.Background(), port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("ScanPort = %v, want one open host", results)
+	}
+	// A loopback handshake takes microseconds; allow for a busy machine
+	if elapsed := results[0].ConnectTime; elapsed <= 0 || elapsed > 100*time.Millisecond {
+		t.Errorf("ConnectTime = %v, want a plausible loopback handshake time", elapsed)
+	}
+}
+
+func TestScanPortJitter(t *testing.T) {
+	port := listenOn(t, "127.0.0.1", 0)
+	scan := func(jitter time.Duration) (time.Duration, []ZmapResult) {
+		s := NewTCPScanner([]string{"127.0.0.0/29"}, 16, 1)
+		s.JitterMin, s.JitterMax = jitter, jitter
+		start := time.Now()
+		results, err := s.ScanPort(context.Background(), port)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return time.Since(start), results
+	}
+
+	baseline, _ := scan(0)
+	const delay = 25 * time.Millisecond
+	elapsed, results := scan(delay)
+
+	// Six hosts, each delayed before its connection attempt
+	added := elapsed - baseline
+	if added < 6*delay || added > 6*delay+500*time.Millisecond {
+		t.Errorf("jitter added %v over %v, want about %v", added, baseline, 6*delay)
+	}
+	if len(results) != 1 || results[0].IP != "127.0.0.1" {
+		t.Errorf("results with jitter = %v, want only 127.0.0.1", results)
+	}
+}
+
+func TestJitterRange(t *testing.T) {
+	s := &TCPScanner{JitterMin: 5 * time.Millisecond, JitterMax: 15 * time.Millisecond}
+	for i := 0; i < 5; i++ {
+		start := time.Now()
+		if err := s.jitter(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if elapsed := time.Since(start); elapsed < s.JitterMin {
+			t.Errorf("jitter slept %v, want at least %v", elapsed, s.JitterMin)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s.JitterMin, s.JitterMax = time.Hour, time.Hour
+	if err := s.jitter(ctx); err != context.Canceled {
+		t.Errorf("jitter with a cancelled context = %v, want context.Canceled", err)
+	}
+}
+
+// rangeTargets opens listeners on several addresses of 127.0.0.0/29 at
+// ports inside a free range of n ports, and returns the range and the
+// ports expected open on each host
+func rangeTargets(t testing.TB, n int) (int, int, map[string][]int) {
+	t.Helper()
+	first, last := freePortRange(t, n)
+	want := map[string][]int{}
+	for _, open := range []struct {
+		ip     string
+		offset int
+	}{{"127.0.0.1", 0}, {"127.0.0.1", n / 2}, {"127.0.0.2", n / 2}, {"127.0.0.3", 1}, {"127.0.0.6", n - 1}} {
+		port := first + open.offset
+		listenOn(t, open.ip, port)
+		want[open.ip] = append(want[open.ip], port)
+	}
+	return first, last, want
+}
+
+// serialRange scans first to last the way ScanAllPorts used to: one port
+// after another through ScanPortsWithCallback
+func serialRange(t testing.TB, first, last int, callback PortScanCallback) map[string][]int {
+	t.Helper()
+	var ports []int
+	for port := first; port <= last; port++ {
+		ports = append(ports, port)
+	}
+	results, err := NewTCPScanner([]string{"127.0.0.0/29"}, 16, 1).ScanPortsWithCallback(context.Background(), ports, callback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return results
+}
+
+func TestScanPortRangeMatchesSerial(t *testing.T) {
+	first, last, want := rangeTargets(t, 30)
+
+	serialCalls := map[int]int{}
+	serial := serialRange(t, first, last, func(port int, results []ZmapResult) { serialCalls[port] += len(results) })
+	if !reflect.DeepEqual(serial, want) {
+		t.Fatalf("serial scan = %v, want %v", serial, want)
+	}
+
+	for _, batch := range []int{1, 7, 1000} {
+		s := NewTCPScanner([]string{"127.0.0.0/29"}, 64, 1)
+		s.AllPortsBatch = batch
+		var mu sync.Mutex
+		calls := map[int]int{}
+		batched, err := s.scanPortRange(context.Background(), first, last, func(port int, results []ZmapResult) {
+			mu.Lock()
+			calls[port] += len(results)
+			mu.Unlock()
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Same map, each host's ports already in ascending order
+		if !reflect.DeepEqual(batched, serial) {
+			t.Errorf("batch %d: %v, serial %v", batch, batched, serial)
+		}
+		if !reflect.DeepEqual(calls, serialCalls) {
+			t.Errorf("batch %d: callbacks reported %v, serial %v", batch, calls, serialCalls)
+		}
+	}
+}
+
+func TestScanPortRangeCancelled(t *testing.T) {
+	first, last, _ := rangeTargets(t, 30)
+	s := NewTCPScanner([]string{"127.0.0.0/29"}, 4, 1)
+	s.AllPortsBatch = 5
+	ctx, cancel := context.WithCancel(context.Background())
+	batches := 0
+	_, err := s.scanPortRange(ctx, first, last, func(int, []ZmapResult) {
+		if batches++; batches == 1 {
+			cancel()
+		}
+	})
+	if err != context.Canceled {
+		t.Errorf("scanPortRange = %v, want context.Canceled", err)
+	}
+}
+
+func BenchmarkScanPortRange(b *testing.B) {
+	first, last, _ := rangeTargets(b, 500)
+	b.Run("serial", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			serialRange(b, first, last, nil)
+		}
+	})
+	b.Run("batched", func(b *testing.B) {
+		s := NewTCPScanner([]string{"127.0.0.0/29"}, 16, 1)
+		s.AllPortsBatch = 100
+		for i := 0; i < b.N; i++ {
+			if _, err := s.scanPortRange(context.Background(), first, last, nil); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestTargetsInterleaved(t *testing.T) {
+	s := NewTCPScanner([]string{"127.0.10.0/29", "127.0.20.0/30", "127.0.30.0/30"}, 4, 1)
+	ips := func(targets []scanTarget) []string {
+		var out []string
+		for _, target := range targets {
+			out = append(out, target.ip)
+		}
+		return out
+	}
+
+	targets, groups, err := s.targets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"127.0.10.1", "127.0.10.2", "127.0.10.3", "127.0.10.4", "127.0.10.5", "127.0.10.6", "127.0.20.1", "127.0.20.2", "127.0.30.1", "127.0.30.2"}
+	if !reflect.DeepEqual(ips(targets), want) || len(groups) != 1 {
+		t.Errorf("targets = %v in groups %v, want network by network in one group", ips(targets), groups)
+	}
+
+	s.Interleave = true
+	targets, groups, err = s.targets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"127.0.10.1", "127.0.20.1", "127.0.30.1", "127.0.10.2", "127.0.20.2", "127.0.30.2", "127.0.10.3", "127.0.10.4", "127.0.10.5", "127.0.10.6"}
+	if !reflect.DeepEqual(ips(targets), want) || !reflect.DeepEqual(groups, s.Networks) {
+		t.Errorf("targets = %v in groups %v, want round-robin across %v", ips(targets), groups, s.Networks)
+	}
+	for _, target := range targets {
+		if _, network, _ := net.ParseCIDR(groups[target.group]); !network.Contains(net.ParseIP(target.ip)) {
+			t.Errorf("%s reported with %s", target.ip, groups[target.group])
+		}
+	}
+}
+
+func TestInterleaveReportsSmallNetworksFirst(t *testing.T) {
+	// The large network is listed first, so scanned serially it would
+	// hold up the small ones
+	port := listenOn(t, "127.0.10.200", 0)
+	listenOn(t, "127.0.20.1", port)
+	listenOn(t, "127.0.30.2", port)
+	networks := []string{"127.0.10.0/24", "127.0.20.0/30", "127.0.30.0/30"}
+
+	for _, interleave := range []bool{false, true} {
+		s := NewTCPScanner(networks, 4, 1)
+		s.Interleave = interleave
+		var reported [][]string
+		results, err := s.ScanPortsWithCallback(context.Background(), []int{port}, func(_ int, results []ZmapResult) {
+			var ips []string
+			for _, r := range results {
+				ips = append(ips, r.IP)
+			}
+			sort.Strings(ips)
+			reported = append(reported, ips)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 3 {
+			t.Errorf("interleave %v: found %v, want the three listeners", interleave, results)
+		}
+
+		if !interleave {
+			if want := [][]string{{"127.0.10.200", "127.0.20.1", "127.0.30.2"}}; !reflect.DeepEqual(reported, want) {
+				t.Errorf("serial: reported %v, want %v", reported, want)
+			}
+			continue
+		}
+		// Each small network is reported once its two hosts are probed,
+		// long before the large one's 254 are; the two small ones finish
+		// together, in either order
+		if len(reported) != 3 {
+			t.Fatalf("interleaved: reported %v, want one callback per network", reported)
+		}
+		small := []string{reported[0][0], reported[1][0]}
+		sort.Strings(small)
+		if !reflect.DeepEqual(small, []string{"127.0.20.1", "127.0.30.2"}) || !reflect.DeepEqual(reported[2], []string{"127.0.10.200"}) {
+			t.Errorf("interleaved: reported %v, want both small networks before 127.0.10.0/24", reported)
+		}
+	}
+}
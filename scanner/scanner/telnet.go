@@ -0,0 +1,157 @@
+package scanner
+
+import (
+	"bytes"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Telnet command bytes (RFC 854) and the options accepted from servers
+const (
+	telnetIAC  = 255
+	telnetDONT = 254
+	telnetDO   = 253
+	telnetWONT = 252
+	telnetWILL = 251
+	telnetSB   = 250
+	telnetSE   = 240
+
+	telnetOptEcho = 1
+	telnetOptSGA  = 3 // suppress go-ahead
+)
+
+// Telnet prompt classes recorded as Fingerprint["telnet_auth"]
+const (
+	telnetLoginRequired = "login_required"
+	telnetPasswordOnly  = "password_only"
+	telnetOpenShell     = "open_shell"
+)
+
+var (
+	telnetLoginPrompt    = regexp.MustCompile(`(?i)(login|username|user name|user)\s*:$`)
+	telnetPasswordPrompt = regexp.MustCompile(`(?i)(password|passcode|passwd)\s*:$`)
+	telnetShellPrompt    = regexp.MustCompile(`[#$>%]$`)
+)
+
+// telnetSession reads a telnet server's output, answering its option
+// negotiation and keeping only the text. Servers may offer echo and
+// suppress-go-ahead, as password prompts rely on them; everything else is
+// refused, each option answered once so negotiation can't loop.
+type telnetSession struct {
+	conn     net.Conn
+	text     bytes.Buffer
+	answered map[[2]byte]bool
+
+	// Parser state carried across reads
+	cmd byte // pending WILL/WONT/DO/DONT awaiting its option byte
+	iac bool // last byte was IAC
+	sub bool // inside a subnegotiation, skipped up to IAC SE
+}
+
+// feed parses raw bytes from the server, appending text and replying to
+// negotiation
+func (s *telnetSession) feed(data []byte) {
+	var reply []byte
+	for _, b := range data {
+		switch {
+		case s.cmd != 0:
+			reply = append(reply, s.answer(s.cmd, b)...)
+			s.cmd = 0
+		case s.iac:
+			s.iac = false
+			switch b {
+			case telnetIAC:
+				if !s.sub {
+					s.text.WriteByte(b) // escaped 0xff data byte
+				}
+			case telnetSB:
+				s.sub = true
+			case telnetSE:
+				s.sub = false
+			case telnetWILL, telnetWONT, telnetDO, telnetDONT:
+				s.cmd = b
+			}
+			// Other commands (NOP, GA, ...) carry no text
+		case b == telnetIAC:
+			s.iac = true
+		case !s.sub:
+			s.text.WriteByte(b)
+		}
+	}
+	if len(reply) > 0 {
+		s.conn.Write(reply)
+	}
+}
+
+// answer returns the reply to one negotiation command, or nothing for an
+// option already answered
+func (s *telnetSession) answer(cmd, option byte) []byte {
+	key := [2]byte{cmd, option}
+	if s.answered[key] {
+		return nil
+	}
+	s.answered[key] = true
+
+	switch cmd {
+	case telnetWILL:
+		if option == telnetOptEcho || option == telnetOptSGA {
+			return []byte{telnetIAC, telnetDO, option}
+		}
+		return []byte{telnetIAC, telnetDONT, option}
+	case telnetDO:
+		return []byte{telnetIAC, telnetWONT, option}
+	}
+	// WONT and DONT need no answer beyond what was already sent
+	return nil
+}
+
+// readTelnet reads the server's greeting with negotiation handled, until
+// it ends in a recognizable prompt, MaxBanner bytes of text arrive or the
+// timeout passes
+func (f *Fingerprinter) readTelnet(conn net.Conn) string {
+	s := &telnetSession{conn: conn, answered: make(map[[2]byte]bool)}
+	conn.SetDeadline(time.Now().Add(f.Timeout))
+
+	buf := make([]byte, 1024)
+	for s.text.Len() < f.MaxBanner {
+		n, err := conn.Read(buf)
+		s.feed(buf[:n])
+		if classifyTelnetPrompt(s.text.String()) != "" || err != nil {
+			break
+		}
+	}
+	text := s.text.String()
+	if len(text) > f.MaxBanner {
+		text = text[:f.MaxBanner]
+	}
+	return text
+}
+
+// classifyTelnetPrompt classifies the prompt the server's output ends in:
+// a username prompt, a password prompt without one, or a shell prompt.
+// Prompts aren't followed by a newline, so output ending in one (e.g. a
+// "#####" banner border) is no prompt yet and gives "".
+func classifyTelnetPrompt(text string) string {
+	text = strings.TrimRight(text, " \t\x00")
+	if strings.HasSuffix(text, "\n") || strings.HasSuffix(text, "\r") {
+		return ""
+	}
+	if i := strings.LastIndexAny(text, "\r\n"); i >= 0 {
+		text = text[i+1:]
+	}
+	line := strings.TrimSpace(text)
+
+	switch {
+	case line == "":
+		return ""
+	case telnetLoginPrompt.MatchString(line):
+		return telnetLoginRequired
+	case telnetPasswordPrompt.MatchString(line):
+		return telnetPasswordOnly
+	case telnetShellPrompt.MatchString(line):
+		return telnetOpenShell
+	}
+	return ""
+}
@@ -0,0 +1,175 @@
+package scanner
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Telnet options the captures offer besides echo and suppress-go-ahead
+const (
+	telnetOptTTYPE = 24
+	telnetOptNAWS  = 31
+)
+
+// telnetServer replays chunks a short pause apart, so each arrives in its
+// own read, and sends whatever the client wrote back on the channel once
+// it hangs up
+func telnetServer(t *testing.T, chunks ...string) (string, int, chan []byte) {
+	t.Helper()
+	replies := make(chan []byte, 1)
+	ip, port := stubServer(t, func(conn net.Conn) {
+		go func() {
+			for _, chunk := range chunks {
+				if _, err := conn.Write([]byte(chunk)); err != nil {
+					return
+				}
+				time.Sleep(20 * time.Millisecond)
+			}
+		}()
+		reply, _ := io.ReadAll(conn)
+		replies <- reply
+	})
+	return ip, port, replies
+}
+
+// iac builds a telnet command sequence
+func iac(b ...byte) string {
+	return string(append([]byte{telnetIAC}, b...))
+}
+
+func TestProbeTelnetCaptures(t *testing.T) {
+	tests := []struct {
+		name    string
+		chunks  []string
+		auth    string
+		banner  string
+		replies []byte
+	}{
+		{
+			name: "busybox login",
+			chunks: []string{
+				iac(telnetDO, telnetOptTTYPE) + iac(telnetWILL, telnetOptEcho) + string([]byte{telnetIAC}),
+				string([]byte{telnetWILL, telnetOptSGA}),
+				"\r\nOpenWrt 23.05\r\nrouter login: ",
+			},
+			auth:   telnetLoginRequired,
+			banner: "OpenWrt 23.05",
+			replies: []byte{
+				telnetIAC, telnetWONT, telnetOptTTYPE,
+				telnetIAC, telnetDO, telnetOptEcho,
+				telnetIAC, telnetDO, telnetOptSGA,
+			},
+		},
+		{
+			name: "cisco password only",
+			chunks: []string{
+				iac(telnetWILL, telnetOptEcho) + iac(telnetWILL, telnetOptSGA) + iac(telnetDO, telnetOptNAWS) + iac(telnetDONT, telnetOptEcho),
+				"\r\n\r\nUser Access Verification\r\n\r\nPassword: ",
+			},
+			auth:   telnetPasswordOnly,
+			banner: "User Access Verification",
+			replies: []byte{
+				telnetIAC, telnetDO, telnetOptEcho,
+				telnetIAC, telnetDO, telnetOptSGA,
+				telnetIAC, telnetWONT, telnetOptNAWS,
+			},
+		},
+		{
+			name: "busybox root shell",
+			chunks: []string{
+				iac(telnetDO, telnetOptTTYPE) + iac(telnetSB, telnetOptTTYPE, 1) + iac(telnetSE),
+				"\r\n\r\nBusyBox v1.19.4 (2013-05-21) built-in shell (ash)\r\nEnter 'help' for a list of built-in commands.\r\n\r\n",
+				"# ",
+			},
+			auth:    telnetOpenShell,
+			banner:  "BusyBox v1.19.4",
+			replies: []byte{telnetIAC, telnetWONT, telnetOptTTYPE},
+		},
+		{
+			// A "#" border line is not a shell prompt; the login follows
+			name:   "bordered login",
+			chunks: []string{"##########\r\n# DVR v2 #\r\n##########\r\n", "Username:"},
+			auth:   telnetLoginRequired,
+			banner: "# DVR v2 #",
+		},
+		{
+			// The same option offered over and over is answered only once
+			name: "negotiation loop",
+			chunks: []string{
+				strings.Repeat(iac(telnetDO, telnetOptTTYPE), 3),
+				strings.Repeat(iac(telnetDO, telnetOptTTYPE), 3) + "\r\nlogin: ",
+			},
+			auth:    telnetLoginRequired,
+			replies: []byte{telnetIAC, telnetWONT, telnetOptTTYPE},
+		},
+		{
+			name:    "no prompt",
+			chunks:  []string{iac(telnetWILL, telnetOptEcho) + "\r\nConnection refused by policy\r\n"},
+			banner:  "Connection refused by policy",
+			replies: []byte{telnetIAC, telnetDO, telnetOptEcho},
+		},
+	}
+	for _, tt := range tests {
+		ip, port, replies := telnetServer(t, tt.chunks...)
+		f := testFingerprinter()
+		f.Timeout = 500 * time.Millisecond
+		info := f.probeTelnet(ip, port)
+
+		if got, _ := info.Fingerprint["telnet_auth"].(string); got != tt.auth {
+			t.Errorf("%s: telnet_auth = %q, want %q", tt.name, got, tt.auth)
+		}
+		if !strings.Contains(info.Banner, tt.banner) || strings.ContainsAny(info.Banner, "\ufffd\xff") {
+			t.Errorf("%s: banner = %q, want the text %q without IAC bytes", tt.name, info.Banner, tt.banner)
+		}
+		if info.ServiceName != "telnet" {
+			t.Errorf("%s: service = %q", tt.name, info.ServiceName)
+		}
+
+		select {
+		case got := <-replies:
+			if tt.replies == nil && len(got) != 0 || tt.replies != nil && !bytes.Equal(got, tt.replies) {
+				t.Errorf("%s: client replied % x, want % x", tt.name, got, tt.replies)
+			}
+		case <-time.After(2 * time.Second):
+			t.Errorf("%s: client never hung up", tt.name)
+		}
+	}
+}
+
+func TestTelnetSessionFeed(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	go io.Copy(io.Discard, server)
+
+	s := &telnetSession{conn: client, answered: make(map[[2]byte]bool)}
+	// An escaped 0xff data byte, a NOP, and a subnegotiation whose payload
+	// contains an escaped 0xff of its own
+	s.feed([]byte("a" + iac(telnetIAC) + "b" + iac(241) + "c" + iac(telnetSB, telnetOptNAWS, 0, telnetIAC, telnetIAC, 0, 24) + iac(telnetSE) + "d"))
+	if got := s.text.String(); got != "a\xffbcd" {
+		t.Errorf("text = %q, want the data bytes only", got)
+	}
+}
+
+func TestClassifyTelnetPrompt(t *testing.T) {
+	for text, want := range map[string]string{
+		"\r\nlogin: ":                "login_required",
+		"Welcome\r\nUser Name :":     "login_required",
+		"\r\nPassword:":              "password_only",
+		"Enter passcode: ":           "password_only",
+		"admin@switch:~$ ":           "open_shell",
+		"switch> ":                   "open_shell",
+		"[root@nas /]# \x00":         "open_shell",
+		"Welcome to the device\r\n":  "",
+		"Press any key to continue ": "",
+		"":                           "",
+	} {
+		if got := classifyTelnetPrompt(text); got != want {
+			t.Errorf("classifyTelnetPrompt(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package scanner
+
+import "fmt"
+
+// topPorts lists the most frequently open TCP ports, most common first.
+// Ranking follows the open-frequency data in Nmap's nmap-services file.
+var topPorts = []int{
+	80, 23, 443, 21, 22, 25, 3389, 110, 445, 139,
+	143, 53, 135, 3306, 8080, 1723, 111, 995, 993, 5900,
+	1025, 587, 8888, 199, 1720, 465, 548, 113, 81, 6001,
+	10000, 514, 5060, 179, 1026, 2000, 8443, 8000, 32768, 554,
+	26, 1433, 49152, 2001, 515, 8008, 49154, 1027, 5666, 646,
+	5000, 5631, 631, 49153, 8081, 2049, 88, 79, 5800, 106,
+	2121, 1110, 49155, 6000, 513, 990, 5357, 427, 49156, 543,
+	544, 5101, 144, 7, 389, 8009, 3128, 444, 9999, 5009,
+	7070, 5190, 3000, 5432, 1900, 3986, 13, 1029, 9, 5051,
+	6646, 49157, 1028, 873, 1755, 2717, 4899, 9100, 119, 37,
+}
+
+// TopPorts returns the n most common ports in rank order
+func TopPorts(n int) ([]int, error) {
+	if n < 1 || n > len(topPorts) {
+		return nil, fmt.Errorf("top_ports must be between 1 and %d, got %d", len(topPorts), n)
+	}
+	ports := make([]int, n)
+	copy(ports, topPorts[:n])
+	return ports, nil
+}
+
+// commonPortCount is how many of the top ports CommonPorts selects
+const commonPortCount = 22
+
+// criticalPorts are ranked below the top ports but expose container and
+// cluster APIs, so CommonPorts scans them as well
+var criticalPorts = []int{
+	2375,  // Docker
+	2376,  // Docker (TLS)
+	6443,  // Kubernetes API
+	10250, // Kubelet
+	10255, // Kubelet read-only
+}
+
+// CommonPorts returns the default port selection: the top ports followed by
+// the critical container ports
+func CommonPorts() []int {
+	ports := make([]int, 0, commonPortCount+len(criticalPorts))
+	ports = append(ports, topPorts[:commonPortCount]...)
+	return append(ports, criticalPorts...)
+}
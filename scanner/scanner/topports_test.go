@@ -0,0 +1,62 @@
+package scanner
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTopPorts(t *testing.T) {
+	got, err := TopPorts(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []int{80, 23, 443, 21, 22, 25, 3389, 110, 445, 139}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("TopPorts(10) = %v, want %v", got, want)
+	}
+
+	// The result is a copy the caller may modify
+	got[0] = 1
+	if again, _ := TopPorts(1); again[0] != 80 {
+		t.Errorf("modifying TopPorts' result changed the list: %v", again)
+	}
+
+	if all, err := TopPorts(len(topPorts)); err != nil || len(all) != len(topPorts) {
+		t.Errorf("TopPorts(%d) = %d ports, %v", len(topPorts), len(all), err)
+	}
+	for _, n := range []int{0, -1, len(topPorts) + 1} {
+		if _, err := TopPorts(n); err == nil {
+			t.Errorf("TopPorts(%d) succeeded", n)
+		}
+	}
+}
+
+func TestTopPortsUnique(t *testing.T) {
+	seen := make(map[int]bool)
+	for _, port := range topPorts {
+		if seen[port] {
+			t.Errorf("port %d ranked twice", port)
+		}
+		seen[port] = true
+	}
+}
+
+func TestCommonPorts(t *testing.T) {
+	top, err := TopPorts(22)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := CommonPorts()
+	if !reflect.DeepEqual(got[:22], top) {
+		t.Errorf("CommonPorts starts with %v, want the top 22 %v", got[:22], top)
+	}
+	if !reflect.DeepEqual(got[22:], criticalPorts) {
+		t.Errorf("CommonPorts ends with %v, want %v", got[22:], criticalPorts)
+	}
+
+	// Each call returns a fresh slice
+	got[0] = 1
+	if CommonPorts()[0] != 80 || topPorts[0] != 80 {
+		t.Error("modifying CommonPorts' result changed the lists")
+	}
+}
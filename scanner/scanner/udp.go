@@ -0,0 +1,189 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// udpProbe sends a protocol-specific request and returns nil once the reply
+// identified the service. UDP has no handshake, so a valid reply is the only
+// evidence a port is open; the error otherwise is classified by classifyUDP.
+type udpProbe func(f *Fingerprinter, ip string, port int) (ServiceInfo, error)
+
+// udpProbes maps each UDP port to the probe that speaks its protocol
+var udpProbes = map[int]udpProbe{
+	1812: (*Fingerprinter).probeRADIUS,
+	1813: (*Fingerprinter).probeRADIUS,
+	5683: (*Fingerprinter).probeCoAP,
+}
+
+// UDPPorts returns the UDP ports that have a probe, in ascending order
+func UDPPorts() []int {
+	ports := make([]int, 0, len(udpProbes))
+	for port := range udpProbes {
+		ports = append(ports, port)
+	}
+	sort.Ints(ports)
+	return ports
+}
+
+// UDPResult is a UDP port that answered its probe
+type UDPResult struct {
+	IP   string
+	Port int
+	Info ServiceInfo
+}
+
+// UDPScanCallback is called after each UDP port is scanned with results
+type UDPScanCallback func(port int, results []UDPResult)
+
+// UDPScanner probes UDP services across all configured networks
+type UDPScanner struct {
+	Networks []string
+	Rate     int // concurrent probes
+	Scope    *Scope
+	Gate     *PauseGate
+	Sockets  *SocketBudget // caps probe sockets open at once; nil for no cap
+
+	fingerprinter *Fingerprinter
+}
+
+// NewUDPScanner creates a new UDPScanner instance
+func NewUDPScanner(networks []string, rate int, timeoutSecs int) *UDPScanner {
+	if rate <= 0 {
+		rate = 100
+	}
+	f := NewFingerprinter()
+	if timeoutSecs > 0 {
+		f.Timeout = time.Duration(timeoutSecs) * time.Second
+	}
+	return &UDPScanner{
+		Networks:      networks,
+		Rate:          rate,
+		fingerprinter: f,
+	}
+}
+
+// ScanPortsWithCallback probes each port that has a UDP probe across all
+// networks, calling callback after each port with the hosts whose service
+// replied. Ports without a probe are skipped. Unanswered probes are
+// classified from ICMP errors when a raw ICMP socket can be opened, and
+// otherwise only as no response.
+func (u *UDPScanner) ScanPortsWithCallback(ctx context.Context, ports []int, callback UDPScanCallback) (map[string][]int, error) {
+	results := make(map[string][]int)
+
+	allIPs, err := scopedIPs(u.Networks, u.Scope)
+	if err != nil {
+		return results, err
+	}
+
+	u.fingerprinter.Sockets = u.Sockets
+	if listener, err := ListenICMP(); err != nil {
+		log.Printf("Warning: ICMP listener unavailable (%v); unanswered UDP ports can't be told apart as closed or filtered", err)
+	} else {
+		u.fingerprinter.ICMP = listener
+		defer func() {
+			listener.Close()
+			u.fingerprinter.ICMP = nil
+		}()
+	}
+
+	fingerprinter := u.fingerprinter.withContext(ctx)
+	for _, port := range ports {
+		probe, ok := udpProbes[port]
+		if !ok {
+			log.Printf("Warning: no UDP probe for port %d, skipping", port)
+			continue
+		}
+
+		log.Printf("Scanning UDP port %d across %d networks...", port, len(u.Networks))
+
+		var portResults []UDPResult
+		states := make(map[string]int)
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		sem := make(chan struct{}, u.Rate)
+
+		for _, ip := range allIPs {
+			if err := u.Gate.Wait(ctx); err != nil {
+				wg.Wait()
+				return results, err
+			}
+
+			wg.Add(1)
+			sem <- struct{}{} // acquire
+
+			go func(targetIP string) {
+				defer wg.Done()
+				defer func() { <-sem }() // release
+
+				info, err := probe(fingerprinter, targetIP, port)
+				mu.Lock()
+				defer mu.Unlock()
+				if err == nil {
+					portResults = append(portResults, UDPResult{IP: targetIP, Port: port, Info: info})
+				}
+				states[classifyUDP(err)]++
+			}(ip)
+		}
+		wg.Wait()
+
+		log.Printf("UDP port %d: found %d hosts (%d open, %d closed, %d filtered, %d no response)",
+			port, len(portResults), states[UDPOpen], states[UDPClosed], states[UDPFiltered], states[UDPOpenFiltered])
+
+		for _, r := range portResults {
+			results[r.IP] = append(results[r.IP], r.Port)
+		}
+		if callback != nil && len(portResults) > 0 {
+			callback(port, portResults)
+		}
+	}
+
+	return results, nil
+}
+
+// udpExchange sends payload to ip:port and returns the first reply. With
+// an ICMP listener, an ICMP error quoting the probe is returned as an
+// *UnreachableError.
+func (f *Fingerprinter) udpExchange(ip string, port int, payload []byte) ([]byte, error) {
+	conn, err := f.Sockets.dial(f.context(), func() (net.Conn, error) {
+		return net.DialTimeout("udp", net.JoinHostPort(ip, strconv.Itoa(port)), f.Timeout)
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	var unreachable <-chan *UnreachableError
+	if f.ICMP != nil {
+		var stop func()
+		unreachable, stop = f.ICMP.watch(ip, port, conn.LocalAddr().(*net.UDPAddr).Port)
+		defer stop()
+	}
+
+	conn.SetDeadline(time.Now().Add(f.Timeout))
+
+	if _, err := conn.Write(payload); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 4096)
+	n, err := conn.Read(buf)
+	if err != nil {
+		if unreachable != nil {
+			var netErr net.Error
+			timedOut := errors.As(err, &netErr) && netErr.Timeout()
+			if e := awaitUnreachable(unreachable, timedOut); e != nil {
+				return nil, e
+			}
+		}
+		return nil, err
+	}
+	return buf[:n], nil
+}
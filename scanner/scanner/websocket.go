@@ -0,0 +1,95 @@
+package scanner
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultWebSocketPaths are tried in order when WebSocket detection is on
+// without configured paths
+var DefaultWebSocketPaths = []string{"/", "/ws", "/websocket", "/socket.io/?EIO=4&transport=websocket"}
+
+// checkWebSocket sends a WebSocket upgrade handshake to each of
+// WebSocketPaths on an HTTP(S) port until one is accepted, and records
+// Fingerprint["websocket"] and, on success, ["websocket_path"]. Only the
+// handshake is performed; the connection is closed right after the 101.
+func (f *Fingerprinter) checkWebSocket(ip string, port int, info *ServiceInfo) {
+	if len(f.WebSocketPaths) == 0 || (info.ServiceName != "http" && info.ServiceName != "https") {
+		return
+	}
+	useTLS := info.ServiceName == "https"
+
+	answered := false
+	for _, path := range f.WebSocketPaths {
+		upgraded, err := f.websocketUpgrade(ip, port, useTLS, path)
+		if err != nil {
+			continue
+		}
+		answered = true
+		if upgraded {
+			if info.Fingerprint == nil {
+				info.Fingerprint = make(map[string]interface{})
+			}
+			info.Fingerprint["websocket"] = true
+			info.Fingerprint["websocket_path"] = path
+			return
+		}
+	}
+
+	if answered {
+		if info.Fingerprint == nil {
+			info.Fingerprint = make(map[string]interface{})
+		}
+		info.Fingerprint["websocket"] = false
+	}
+}
+
+// websocketUpgrade reports whether the server accepts an upgrade on path.
+// A server that answers with anything but a 101 to "websocket" has rejected
+// it; an error means no usable answer at all.
+func (f *Fingerprinter) websocketUpgrade(ip string, port int, useTLS bool, path string) (bool, error) {
+	address := net.JoinHostPort(ip, strconv.Itoa(port))
+	conn, err := f.dial(address)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(f.Timeout))
+
+	if useTLS {
+		tlsConn := tls.Client(conn, &tls.Config{InsecureSkipVerify: true})
+		if err := tlsConn.Handshake(); err != nil {
+			return false, err
+		}
+		conn = tlsConn
+	}
+
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	request := fmt.Sprintf("GET %s HTTP/1.1\r\nHost: %s\r\nUser-Agent: NetworkScanner/1.0\r\n"+
+		"Upgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: %s\r\nSec-WebSocket-Version: 13\r\n\r\n",
+		path, address, key)
+	if _, err := conn.Write([]byte(request)); err != nil {
+		return false, err
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		return false, err
+	}
+	// The body is never read; closing conn discards it
+
+	return resp.StatusCode == http.StatusSwitchingProtocols &&
+		strings.EqualFold(resp.Header.Get("Upgrade"), "websocket"), nil
+}
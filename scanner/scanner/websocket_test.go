@@ -0,0 +1,133 @@
+package scanner
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// websocketGUID is appended to the client key for Sec-WebSocket-Accept
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// websocketServer upgrades handshakes on upgradePath and answers every
+// other request with a plain page, recording the paths it was asked for
+func websocketServer(t *testing.T, useTLS bool, upgradePath string) (*httptest.Server, func() []string) {
+	t.Helper()
+	var mu sync.Mutex
+	var paths []string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.URL.RequestURI())
+		mu.Unlock()
+
+		if r.URL.RequestURI() != upgradePath || !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+			w.Write([]byte("<html>no websockets here</html>"))
+			return
+		}
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		sum := sha1.Sum([]byte(r.Header.Get("Sec-WebSocket-Key") + websocketGUID))
+		buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+			"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n")
+		buf.Flush()
+	})
+
+	server := httptest.NewUnstartedServer(handler)
+	if useTLS {
+		server.StartTLS()
+	} else {
+		server.Start()
+	}
+	t.Cleanup(server.Close)
+	return server, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), paths...)
+	}
+}
+
+func TestCheckWebSocketUpgrades(t *testing.T) {
+	for _, tc := range []struct {
+		service string
+		useTLS  bool
+	}{
+		{"http", false},
+		{"https", true},
+	} {
+		server, paths := websocketServer(t, tc.useTLS, "/ws")
+		ip, port := listenerAddress(server)
+
+		f := testFingerprinter()
+		f.WebSocketPaths = DefaultWebSocketPaths
+		info := ServiceInfo{ServiceName: tc.service}
+		f.checkWebSocket(ip, port, &info)
+
+		if info.Fingerprint["websocket"] != true || info.Fingerprint["websocket_path"] != "/ws" {
+			t.Errorf("%s: fingerprint = %v, want websocket on /ws", tc.service, info.Fingerprint)
+		}
+		// Paths are tried in order and the search stops at the upgrade
+		if got := paths(); strings.Join(got, " ") != "/ /ws" {
+			t.Errorf("%s: requested %q, want / then /ws", tc.service, got)
+		}
+	}
+}
+
+func TestCheckWebSocketRejected(t *testing.T) {
+	server, paths := websocketServer(t, false, "")
+	ip, port := listenerAddress(server)
+
+	f := testFingerprinter()
+	f.WebSocketPaths = DefaultWebSocketPaths
+	info := ServiceInfo{ServiceName: "http", Fingerprint: map[string]interface{}{"title": "kept"}}
+	f.checkWebSocket(ip, port, &info)
+
+	if info.Fingerprint["websocket"] != false {
+		t.Errorf("websocket = %v, want false from a server that answers without upgrading", info.Fingerprint["websocket"])
+	}
+	if _, ok := info.Fingerprint["websocket_path"]; ok || info.Fingerprint["title"] != "kept" {
+		t.Errorf("fingerprint = %v, want only websocket added", info.Fingerprint)
+	}
+	if got := paths(); len(got) != len(DefaultWebSocketPaths) {
+		t.Errorf("requested %q, want every configured path", got)
+	}
+}
+
+func TestCheckWebSocketNoAnswer(t *testing.T) {
+	f := testFingerprinter()
+	f.WebSocketPaths = []string{"/ws"}
+
+	// A closed port gives no answer, so nothing is recorded
+	info := ServiceInfo{ServiceName: "http"}
+	f.checkWebSocket("127.0.0.1", closedPort(t), &info)
+	if _, ok := info.Fingerprint["websocket"]; ok {
+		t.Errorf("fingerprint = %v, want websocket unset without an answer", info.Fingerprint)
+	}
+
+	// Disabled, or not an HTTP service: the port is never contacted
+	server, paths := websocketServer(t, false, "/ws")
+	ip, port := listenerAddress(server)
+	for _, tc := range []struct {
+		paths   []string
+		service string
+	}{
+		{nil, "http"},
+		{[]string{"/ws"}, "ssh"},
+	} {
+		f.WebSocketPaths = tc.paths
+		info := ServiceInfo{ServiceName: tc.service}
+		f.checkWebSocket(ip, port, &info)
+		if _, ok := info.Fingerprint["websocket"]; ok {
+			t.Errorf("%s with paths %v: websocket recorded", tc.service, tc.paths)
+		}
+	}
+	if got := paths(); len(got) != 0 {
+		t.Errorf("server was asked for %q", got)
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"os/exec"
 	"strings"
 	"time"
@@ -15,15 +17,73 @@ type ZgrabFingerprinter struct {
 	Timeout    time.Duration
 	MaxBanner  int
 	Fallback   *Fingerprinter // Fallback to native fingerprinting
+
+	// StoreRaw attaches the raw zgrab2 output, up to maxRawZgrab bytes and
+	// within Fallback's BodyBudget, to each result's fingerprint as
+	// RawZgrabKey
+	StoreRaw bool
+
+	// Limits caps the headers and body kept from each module's result, by
+	// module name; modules not listed keep every header and no body
+	Limits map[string]ZgrabLimits
+
+	available bool   // zgrab2 was found and runs
+	version   string // first line of zgrab2 --version output
 }
 
-// NewZgrabFingerprinter creates a new ZgrabFingerprinter
+// NewZgrabFingerprinter creates a new ZgrabFingerprinter. zgrab2 is looked
+// up once here; if it is missing every port goes straight to the native
+// fingerprinter instead of paying for a failed exec each time.
 func NewZgrabFingerprinter() *ZgrabFingerprinter {
-	return &ZgrabFingerprinter{
+	z := &ZgrabFingerprinter{
 		Timeout:   10 * time.Second,
 		MaxBanner: 4096,
 		Fallback:  NewFingerprinter(),
 	}
+
+	version, err := detectZgrab()
+	if err != nil {
+		log.Printf("Warning: zgrab2 unavailable (%v); using native fingerprinting only", err)
+	} else {
+		z.available = true
+		z.version = version
+	}
+
+	return z
+}
+
+// Available reports whether zgrab2 was found at construction
+func (z *ZgrabFingerprinter) Available() bool {
+	return z.available
+}
+
+// Version returns the detected zgrab2 version string, if any
+func (z *ZgrabFingerprinter) Version() string {
+	return z.version
+}
+
+// detectZgrab checks that zgrab2 is on PATH and can be executed
+func detectZgrab() (string, error) {
+	path, err := exec.LookPath("zgrab2")
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Older builds have no --version flag and exit non-zero; only a failure
+	// to run the binary at all counts as unavailable.
+	out, err := exec.CommandContext(ctx, path, "--version").CombinedOutput()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return "", fmt.Errorf("failed to run %s: %w", path, err)
+		}
+	}
+
+	version, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+	return version, nil
 }
 
 // ZgrabResult represents the top-level zgrab2 JSON output
@@ -31,8 +91,17 @@ type ZgrabResult struct {
 	IP     string                   `json:"ip"`
 	Domain string                   `json:"domain,omitempty"`
 	Data   map[string]*ZgrabModule  `json:"data"`
+
+	raw []byte // the JSON line this was parsed from
 }
 
+// RawZgrabKey is the fingerprint key StoreRaw puts zgrab2's output under
+const RawZgrabKey = "_raw_zgrab"
+
+// maxRawZgrab caps the raw zgrab2 output kept per port. HTTP bodies and
+// certificate chains push it well past the parsed fields.
+const maxRawZgrab = 16 << 10
+
 // ZgrabModule represents a protocol module result
 type ZgrabModule struct {
 	Status    string          `json:"status"`
@@ -74,6 +143,7 @@ type ParsedCert struct {
 	ValidityNotAfter   string             `json:"validity_not_after,omitempty"`
 	SignatureAlgorithm string             `json:"signature_algorithm,omitempty"`
 	SubjectAltNames    *SubjectAltNames   `json:"subject_alt_name,omitempty"`
+	FingerprintSHA256  string             `json:"fingerprint_sha256,omitempty"`
 }
 
 // DistinguishedName represents X.509 DN fields
@@ -134,6 +204,18 @@ type FTPResult struct {
 type SSHResult struct {
 	ServerID         *SSHServerID `json:"server_id,omitempty"`
 	AlgorithmSelection map[string]interface{} `json:"algorithm_selection,omitempty"`
+	KeyExchange      *SSHKeyExchange `json:"key_exchange,omitempty"`
+}
+
+// SSHKeyExchange contains the server's half of the key exchange
+type SSHKeyExchange struct {
+	ServerHostKey *SSHHostKey `json:"server_host_key,omitempty"`
+}
+
+// SSHHostKey identifies the server's host key
+type SSHHostKey struct {
+	Algorithm         string `json:"algorithm,omitempty"`
+	FingerprintSHA256 string `json:"fingerprint_sha256,omitempty"`
 }
 
 // SSHServerID contains SSH server identification
@@ -188,38 +270,6 @@ type TelnetResult struct {
 	Banner string `json:"banner,omitempty"`
 }
 
-// getZgrabModule returns the zgrab2 module name for a port
-func getZgrabModule(port int) string {
-	switch port {
-	case 21:
-		return "ftp"
-	case 22:
-		return "ssh"
-	case 23:
-		return "telnet"
-	case 25, 465, 587:
-		return "smtp"
-	case 80, 8080, 8000, 8888:
-		return "http"
-	case 110, 995:
-		return "pop3"
-	case 143, 993:
-		return "imap"
-	case 443, 8443:
-		return "http" // with --use-https flag
-	case 3306:
-		return "mysql"
-	case 5432:
-		return "postgres"
-	case 6379:
-		return "redis"
-	case 27017:
-		return "mongodb"
-	default:
-		return "banner" // Generic banner grab
-	}
-}
-
 // FingerprintHost uses zgrab2 for enhanced fingerprinting
 func (z *ZgrabFingerprinter) FingerprintHost(ctx context.Context, ip string, ports []int) map[int]ServiceInfo {
 	results := make(map[int]ServiceInfo)
@@ -239,21 +289,34 @@ func (z *ZgrabFingerprinter) FingerprintHost(ctx context.Context, ip string, por
 }
 
 func (z *ZgrabFingerprinter) fingerprintPort(ctx context.Context, ip string, port int) ServiceInfo {
+	// Without zgrab2, or for native probes it has no module for (SOCKS,
+	// container APIs, TACACS+), go native. zgrab2 can't send a PROXY
+	// header either, or walk a probe_sequence.
+	if !z.available || zgrabNativePort(port) || z.Fallback.proxyProtocol(port) != 0 || len(z.Fallback.ProbeSequence[port]) > 0 || len(z.Fallback.MultiProbe[port]) > 0 {
+		return z.Fallback.fingerprintPort(ctx, ip, port)
+	}
+
 	module := getZgrabModule(port)
 
+	// Two-phase mode: ports zgrab2 only banner-grabs get the quick native
+	// read instead of a full zgrab2 run
+	if module == "banner" && z.Fallback.FastTimeout > 0 {
+		return z.Fallback.fingerprintPort(ctx, ip, port)
+	}
+
 	// Build zgrab2 command
 	args := []string{module, "-p", fmt.Sprintf("%d", port)}
 
 	// Add module-specific flags
 	switch module {
 	case "http":
-		if port == 443 || port == 8443 {
+		if portService(port) == "https" {
 			args = append(args, "--use-https")
 		}
 		args = append(args, "--max-redirects", "3")
 	case "smtp":
 		args = append(args, "--send-ehlo", "--ehlo-domain", "scanner.local")
-		if port == 465 {
+		if portService(port) == "smtps" {
 			args = append(args, "--smtps")
 		} else {
 			args = append(args, "--starttls")
@@ -261,13 +324,13 @@ func (z *ZgrabFingerprinter) fingerprintPort(ctx context.Context, ip string, por
 	case "ftp":
 		args = append(args, "--authtls")
 	case "imap":
-		if port == 993 {
+		if portService(port) == "imaps" {
 			args = append(args, "--imaps")
 		} else {
 			args = append(args, "--starttls")
 		}
 	case "pop3":
-		if port == 995 {
+		if portService(port) == "pop3s" {
 			args = append(args, "--pop3s")
 		} else {
 			args = append(args, "--starttls")
@@ -292,11 +355,43 @@ func (z *ZgrabFingerprinter) fingerprintPort(ctx context.Context, ip string, por
 
 	// Parse zgrab2 result
 	info := z.parseZgrabResult(result, module, port)
+	if z.StoreRaw {
+		raw := result.raw
+		if len(raw) > maxRawZgrab {
+			raw = raw[:maxRawZgrab]
+		}
+		if n := z.Fallback.BodyBudget.take(len(raw)); n < len(raw) {
+			z.Fallback.BodyBudget.skip()
+			raw = raw[:n]
+		}
+		if len(raw) > 0 {
+			if info.Fingerprint == nil {
+				info.Fingerprint = make(map[string]interface{})
+			}
+			info.Fingerprint[RawZgrabKey] = string(raw)
+		}
+	}
+	if port == 3128 && info.ServiceName == "http" {
+		info.ServiceName = "http-proxy"
+	}
+	checks := z.Fallback.withContext(ctx)
+	checks.checkOpenProxy(ip, port, &info)
+	checks.checkOpenRelay(ip, port, &info)
+	checks.checkAnonymousFTP(ip, port, &info)
+	checks.checkAXFR(ctx, ip, port, &info)
+	checks.checkLoadBalancer(ip, port, &info)
+	checks.checkWebSocket(ip, port, &info)
+	checks.checkCloudMetadata(ip, port, &info)
+	checks.checkNTLMInfo(ip, port, &info)
+	markProduct(&info)
 
 	// Ensure we have a service name
-	if info.ServiceName == "" {
+	fallback := info.ServiceName == ""
+	if fallback {
 		info.ServiceName = getDefaultServiceName(port)
 	}
+	// Ports handed to the native fingerprinter above are counted there
+	z.Fallback.ProbeStats.record(info, fallback)
 
 	return info
 }
@@ -322,6 +417,7 @@ func (z *ZgrabFingerprinter) runZgrab(ctx context.Context, ip string, args []str
 	if err := json.Unmarshal(stdout.Bytes(), &result); err != nil {
 		return nil, fmt.Errorf("failed to parse zgrab2 output: %v", err)
 	}
+	result.raw = bytes.TrimSpace(stdout.Bytes())
 
 	return &result, nil
 }
@@ -346,7 +442,7 @@ func (z *ZgrabFingerprinter) parseZgrabResult(result *ZgrabResult, module string
 		var httpRes HTTPResult
 		if err := json.Unmarshal(modResult.Result, &httpRes); err == nil && httpRes.Response != nil {
 			info.ServiceName = "http"
-			if port == 443 || port == 8443 {
+			if portService(port) == "https" {
 				info.ServiceName = "https"
 			}
 
@@ -360,14 +456,36 @@ func (z *ZgrabFingerprinter) parseZgrabResult(result *ZgrabResult, module string
 			if server, ok := resp.Headers["server"]; ok {
 				info.ServiceVersion = server
 			}
+			limits := z.Limits[module]
 			if resp.Headers != nil {
-				info.Fingerprint["headers"] = resp.Headers
+				if cdn := detectCDN(zgrabHeader(resp.Headers)); cdn != "" {
+					info.Fingerprint["cdn"] = cdn
+				}
+				if challenge := zgrabHeader(resp.Headers)("WWW-Authenticate"); challenge != "" {
+					markHTTPAuth(&info, resp.StatusCode, []string{challenge})
+				}
+				headers, omitted := limits.limitHeaders(resp.Headers)
+				if len(headers) > 0 {
+					info.Fingerprint["headers"] = headers
+				}
+				if omitted > 0 {
+					info.Fingerprint["headers_omitted"] = omitted
+				}
 			}
 			// Extract title from body
 			if resp.Body != "" {
 				if title := extractTitle(resp.Body); title != "" {
 					info.Fingerprint["title"] = title
 				}
+				if body, truncated := limits.limitBody(resp.Body, z.Fallback.BodyBudget); body != "" {
+					info.Fingerprint["body"] = body
+					if truncated {
+						info.Fingerprint["body_truncated"] = true
+					}
+				}
+			}
+			if resp.BodySHA256 != "" {
+				info.Fingerprint["body_sha256"] = strings.ToLower(resp.BodySHA256)
 			}
 		}
 
@@ -425,6 +543,12 @@ func (z *ZgrabFingerprinter) parseZgrabResult(result *ZgrabResult, module string
 			if sshRes.AlgorithmSelection != nil {
 				info.Fingerprint["algorithms"] = sshRes.AlgorithmSelection
 			}
+			if kex := sshRes.KeyExchange; kex != nil && kex.ServerHostKey != nil && kex.ServerHostKey.FingerprintSHA256 != "" {
+				info.Fingerprint["host_key"] = map[string]interface{}{
+					"algorithm": kex.ServerHostKey.Algorithm,
+					"sha256":    strings.ToLower(kex.ServerHostKey.FingerprintSHA256),
+				}
+			}
 		}
 
 	case "mysql":
@@ -568,6 +692,9 @@ func (z *ZgrabFingerprinter) extractTLSInfo(info *ServiceInfo, tls *TLSLog) {
 			if p.SignatureAlgorithm != "" {
 				certInfo["signature_algorithm"] = p.SignatureAlgorithm
 			}
+			if p.FingerprintSHA256 != "" {
+				certInfo["sha256"] = strings.ToLower(p.FingerprintSHA256)
+			}
 
 			tlsInfo["certificate"] = certInfo
 		}
@@ -0,0 +1,179 @@
+package scanner
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeZgrab puts a zgrab2 script on a fresh PATH that logs its arguments
+// to the returned file and answers --version
+func fakeZgrab(t *testing.T, dir string) string {
+	t.Helper()
+	calls := filepath.Join(dir, "calls")
+	script := "#!/bin/sh\necho \"$@\" >> " + calls + "\n[ \"$1\" = --version ] && echo 'zgrab2 v0.1.test'\nexit 0\n"
+	if err := os.WriteFile(filepath.Join(dir, "zgrab2"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return calls
+}
+
+func TestZgrabDetected(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("PATH", dir)
+	fakeZgrab(t, dir)
+
+	z := NewZgrabFingerprinter()
+	if !z.Available() || z.Version() != "zgrab2 v0.1.test" {
+		t.Errorf("Available() = %v, Version() = %q, want the fake zgrab2", z.Available(), z.Version())
+	}
+}
+
+func TestZgrabMissingFallsBackToNative(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("PATH", dir)
+	z := NewZgrabFingerprinter()
+	if z.Available() {
+		t.Fatal("zgrab2 reported available with an empty PATH")
+	}
+	z.Fallback.Timeout = testFingerprinter().Timeout
+
+	// zgrab2 appearing later is not picked up: availability is decided
+	// once, so no port pays for an exec attempt
+	calls := fakeZgrab(t, dir)
+
+	ip, port := stubServer(t, func(conn net.Conn) {
+		io.WriteString(conn, "SSH-2.0-OpenSSH_9.6\r\n")
+		io.Copy(io.Discard, conn)
+	})
+	closed := closedPort(t)
+	results := z.FingerprintHost(context.Background(), ip, []int{port, closed})
+
+	if !strings.HasPrefix(results[port].Banner, "SSH-2.0-OpenSSH_9.6") {
+		t.Errorf("port %d = %+v, want the native banner grab", port, results[port])
+	}
+	if _, ok := results[closed]; !ok {
+		t.Errorf("closed port %d has no result", closed)
+	}
+	if _, err := os.Stat(calls); !os.IsNotExist(err) {
+		out, _ := os.ReadFile(calls)
+		t.Errorf("zgrab2 was executed after being found missing: %q", out)
+	}
+}
+
+// cannedZgrabSSH is zgrab2's ssh module output for one host
+const cannedZgrabSSH = `{"ip":"127.0.0.1","data":{"ssh":{"status":"success","protocol":"ssh","result":` +
+	`{"server_id":{"raw":"SSH-2.0-OpenSSH_9.6","version":"2.0","software_version":"OpenSSH_9.6"},` +
+	`"algorithm_selection":{"kex_algorithm":"curve25519-sha256","host_key_algorithm":"ssh-ed25519"}},` +
+	`"timestamp":"2026-10-14T09:30:00Z"}}}`
+
+// cannedZgrab puts a zgrab2 on a fresh PATH that prints output for every
+// scan and logs its arguments to the returned file, and returns a
+// fingerprinter using it
+func cannedZgrab(t *testing.T, output string) (*ZgrabFingerprinter, string) {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("PATH", dir+":/usr/bin:/bin")
+	fixture := filepath.Join(dir, "output.json")
+	if err := os.WriteFile(fixture, []byte(output+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	calls := filepath.Join(dir, "calls")
+	script := "#!/bin/sh\n[ \"$1\" = --version ] && echo 'zgrab2 v0.1.test' && exit 0\necho \"$@\" >> " + calls + "\ncat " + fixture + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "zgrab2"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	z := NewZgrabFingerprinter()
+	if !z.Available() {
+		t.Fatal("canned zgrab2 not detected")
+	}
+	return z, calls
+}
+
+func TestStoreRawZgrab(t *testing.T) {
+	z, _ := cannedZgrab(t, cannedZgrabSSH)
+	z.StoreRaw = true
+	info := z.fingerprintPort(context.Background(), "127.0.0.1", 22)
+	if info.ServiceName != "ssh" || info.ServiceVersion != "OpenSSH_9.6" {
+		t.Errorf("service = %q %q, want the parsed ssh result", info.ServiceName, info.ServiceVersion)
+	}
+	raw, ok := info.Fingerprint[RawZgrabKey].(string)
+	if !ok || raw != cannedZgrabSSH {
+		t.Fatalf("%s = %q, want zgrab2's output line", RawZgrabKey, info.Fingerprint[RawZgrabKey])
+	}
+	// Kept raw, it re-parses to what zgrab2 reported
+	var reparsed ZgrabResult
+	if err := json.Unmarshal([]byte(raw), &reparsed); err != nil || reparsed.Data["ssh"] == nil || reparsed.Data["ssh"].Status != "success" {
+		t.Errorf("re-parsing the raw output: %+v, %v", reparsed, err)
+	}
+}
+
+func TestStoreRawZgrabDisabled(t *testing.T) {
+	z, _ := cannedZgrab(t, cannedZgrabSSH)
+	info := z.fingerprintPort(context.Background(), "127.0.0.1", 22)
+	if info.ServiceName != "ssh" {
+		t.Fatalf("service = %q, want ssh", info.ServiceName)
+	}
+	if _, ok := info.Fingerprint[RawZgrabKey]; ok {
+		t.Errorf("%s attached without store_raw_zgrab: %v", RawZgrabKey, info.Fingerprint)
+	}
+}
+
+func TestStoreRawZgrabCapped(t *testing.T) {
+	// A long banner pushes the output past the cap
+	long := strings.Replace(cannedZgrabSSH, "OpenSSH_9.6\",\"version", "OpenSSH_9.6 "+strings.Repeat("x", 2*maxRawZgrab)+"\",\"version", 1)
+	z, _ := cannedZgrab(t, long)
+	z.StoreRaw = true
+	info := z.fingerprintPort(context.Background(), "127.0.0.1", 22)
+	if raw, _ := info.Fingerprint[RawZgrabKey].(string); len(raw) != maxRawZgrab || !strings.HasPrefix(long, raw) {
+		t.Errorf("%s holds %d bytes, want the first %d", RawZgrabKey, len(raw), maxRawZgrab)
+	}
+
+	// It counts against the body budget like any response body
+	z.Fallback.BodyBudget = NewBodyBudget(100)
+	info = z.fingerprintPort(context.Background(), "127.0.0.1", 22)
+	if raw, _ := info.Fingerprint[RawZgrabKey].(string); len(raw) != 100 || z.Fallback.BodyBudget.Skipped() != 1 {
+		t.Errorf("%s holds %d bytes with %d skipped, want 100 and one skip", RawZgrabKey, len(raw), z.Fallback.BodyBudget.Skipped())
+	}
+}
+
+func TestZgrabIdentityDigests(t *testing.T) {
+	const digest = "3b0c1f6e9a7d4c2b8e5f0a1d6c9b2e7f4a8d1c5b9e2f6a0d3c7b1e4f8a2d5c9b"
+	upper := strings.ToUpper(digest)
+
+	// zgrab2's uppercase digests are lowercased to match the native probes
+	ssh := `{"ip":"127.0.0.1","data":{"ssh":{"status":"success","protocol":"ssh","result":` +
+		`{"server_id":{"raw":"SSH-2.0-OpenSSH_9.6","version":"2.0","software_version":"OpenSSH_9.6"},` +
+		`"key_exchange":{"server_host_key":{"algorithm":"ssh-ed25519","fingerprint_sha256":"` + upper + `"}}},` +
+		`"timestamp":"2026-10-14T09:30:00Z"}}}`
+	z, _ := cannedZgrab(t, ssh)
+	info := z.fingerprintPort(context.Background(), "127.0.0.1", 22)
+	want := map[string]interface{}{"algorithm": "ssh-ed25519", "sha256": digest}
+	if !reflect.DeepEqual(info.Fingerprint["host_key"], want) {
+		t.Errorf("host_key = %v, want %v", info.Fingerprint["host_key"], want)
+	}
+
+	ftp := `{"ip":"127.0.0.1","data":{"ftp":{"status":"success","protocol":"ftp","result":` +
+		`{"banner":"220 ProFTPD 1.3.8 Server","auth_tls":"234 AUTH TLS successful","tls":{"handshake_log":{"server_certificates":` +
+		`{"certificate":{"parsed":{"subject":{"common_name":["files.example.com"]},"fingerprint_sha256":"` + upper + `"}}}}}},` +
+		`"timestamp":"2026-10-14T09:30:00Z"}}}`
+	z, _ = cannedZgrab(t, ftp)
+	info = z.fingerprintPort(context.Background(), "127.0.0.1", 21)
+	tlsInfo, _ := info.Fingerprint["tls"].(map[string]interface{})
+	cert, _ := tlsInfo["certificate"].(map[string]interface{})
+	if cert["sha256"] != digest || cert["subject_cn"] != "files.example.com" {
+		t.Errorf("certificate = %v, want sha256 %s", cert, digest)
+	}
+
+	// Without a key exchange there is no host key
+	z, _ = cannedZgrab(t, cannedZgrabSSH)
+	if info := z.fingerprintPort(context.Background(), "127.0.0.1", 22); info.Fingerprint["host_key"] != nil {
+		t.Errorf("host_key = %v without a key exchange", info.Fingerprint["host_key"])
+	}
+}
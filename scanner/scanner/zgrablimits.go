@@ -0,0 +1,98 @@
+package scanner
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ZgrabLimits caps what one zgrab2 module's result keeps in the
+// fingerprint. Values derived from the full response (title, CDN, auth
+// scheme, server version) are extracted before the caps apply, and
+// body_sha256 is always kept.
+type ZgrabLimits struct {
+	// MaxHeaders keeps at most this many headers, by name order, after
+	// DropHeaders; 0 keeps all
+	MaxHeaders int
+
+	// MaxBodyBytes keeps up to this many bytes of the response body as
+	// Fingerprint["body"], within Fallback's BodyBudget; 0 keeps none
+	MaxBodyBytes int
+
+	// DropHeaders names headers never kept, e.g. set-cookie
+	DropHeaders []string
+}
+
+// zgrabLimitedModules are the modules whose results carry headers or a
+// body for ZgrabLimits to cap
+var zgrabLimitedModules = map[string]bool{
+	"http": true,
+}
+
+// CheckZgrabLimits reports the first module with limits that returns no
+// headers or body to cap
+func CheckZgrabLimits(limits map[string]ZgrabLimits) error {
+	modules := make([]string, 0, len(limits))
+	for module := range limits {
+		modules = append(modules, module)
+	}
+	sort.Strings(modules)
+	for _, module := range modules {
+		if !zgrabLimitedModules[module] {
+			known := make([]string, 0, len(zgrabLimitedModules))
+			for name := range zgrabLimitedModules {
+				known = append(known, name)
+			}
+			sort.Strings(known)
+			return fmt.Errorf("module %q has no headers or body to limit (supported: %s)", module, strings.Join(known, ", "))
+		}
+	}
+	return nil
+}
+
+// zgrabHeaderName normalizes a header name the way zgrab2 records it:
+// lowercased, with dashes as underscores
+func zgrabHeaderName(name string) string {
+	return strings.ReplaceAll(strings.ToLower(name), "-", "_")
+}
+
+// limitHeaders returns the headers l keeps and how many it left out.
+// headers itself is not modified.
+func (l ZgrabLimits) limitHeaders(headers map[string]string) (map[string]string, int) {
+	if l.MaxHeaders == 0 && len(l.DropHeaders) == 0 {
+		return headers, 0
+	}
+	drop := make(map[string]bool, len(l.DropHeaders))
+	for _, name := range l.DropHeaders {
+		drop[zgrabHeaderName(name)] = true
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		if !drop[zgrabHeaderName(name)] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	if l.MaxHeaders > 0 && len(names) > l.MaxHeaders {
+		names = names[:l.MaxHeaders]
+	}
+	kept := make(map[string]string, len(names))
+	for _, name := range names {
+		kept[name] = headers[name]
+	}
+	return kept, len(headers) - len(kept)
+}
+
+// limitBody returns the start of body l keeps and whether body was cut
+// short
+func (l ZgrabLimits) limitBody(body string, budget *BodyBudget) (string, bool) {
+	if l.MaxBodyBytes == 0 || body == "" {
+		return "", false
+	}
+	want := min(len(body), l.MaxBodyBytes)
+	n := budget.take(want)
+	if n < want {
+		budget.skip()
+	}
+	return body[:n], n < len(body)
+}
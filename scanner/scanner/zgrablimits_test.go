@@ -0,0 +1,210 @@
+package scanner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// bloatedHTTP is a zgrab2 http 401 with 200 headers, one of them a 4 KB
+// cookie, and a 1 MiB body
+func bloatedHTTP(t *testing.T) (output string, headers map[string]string, body string) {
+	t.Helper()
+	headers = map[string]string{
+		"server":           "nginx/1.25.4",
+		"set_cookie":       "session=" + strings.Repeat("s", 4000),
+		"www_authenticate": `Basic realm="admin"`,
+		"via":              "1.1 cloudfront",
+		"x_amz_cf_id":      "abc123",
+	}
+	for i := len(headers); i < 200; i++ {
+		headers[fmt.Sprintf("a_trace_%03d", i)] = strings.Repeat("t", 100)
+	}
+	body = "<html><head><title>Router admin</title></head><body>" + strings.Repeat("A", 1<<20) + "</body></html>"
+	result := map[string]interface{}{"ip": "127.0.0.1", "data": map[string]interface{}{"http": map[string]interface{}{
+		"status": "success", "protocol": "http", "timestamp": "2026-10-14T09:30:00Z",
+		"result": map[string]interface{}{"response": map[string]interface{}{
+			"status_code": 401,
+			"status_line": "401 Unauthorized",
+			"headers":     headers,
+			"body":        body,
+			"body_sha256": "E3B0C44298FC1C149AFBF4C8996FB92427AE41E4649B934CA495991B7852B855",
+		}},
+	}}}
+	raw, err := json.Marshal(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(raw), headers, body
+}
+
+// keptHeaders returns the headers map a fingerprint kept
+func keptHeaders(t *testing.T, info ServiceInfo) map[string]string {
+	t.Helper()
+	headers, _ := info.Fingerprint["headers"].(map[string]string)
+	return headers
+}
+
+func TestZgrabLimitsEnforced(t *testing.T) {
+	output, headers, body := bloatedHTTP(t)
+	z, _ := cannedZgrab(t, output)
+	z.Limits = map[string]ZgrabLimits{"http": {MaxHeaders: 10, MaxBodyBytes: 256, DropHeaders: []string{"Set-Cookie"}}}
+	info := z.fingerprintPort(context.Background(), "127.0.0.1", 80)
+
+	// The first 10 headers by name, which are all traces; the rest are
+	// counted
+	kept := keptHeaders(t, info)
+	want := make(map[string]string)
+	for i := 5; i < 15; i++ {
+		name := fmt.Sprintf("a_trace_%03d", i)
+		want[name] = headers[name]
+	}
+	if !reflect.DeepEqual(kept, want) {
+		t.Errorf("kept headers %v, want a_trace_005 to a_trace_014", headerNames(kept))
+	}
+	if got := info.Fingerprint["headers_omitted"]; got != len(headers)-10 {
+		t.Errorf("headers_omitted = %v, want %d", got, len(headers)-10)
+	}
+
+	// The body is cut to its cap, and its digest kept lowercased
+	if got, _ := info.Fingerprint["body"].(string); got != body[:256] {
+		t.Errorf("body is %d bytes, want the first 256", len(got))
+	}
+	if info.Fingerprint["body_truncated"] != true {
+		t.Error("body_truncated unset")
+	}
+	if got := info.Fingerprint["body_sha256"]; got != "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855" {
+		t.Errorf("body_sha256 = %v", got)
+	}
+
+	// What comes from the full response survives the caps, including the
+	// headers they left out
+	if info.ServiceVersion != "nginx/1.25.4" || info.Fingerprint["title"] != "Router admin" {
+		t.Errorf("version %q title %v, want them from the full response", info.ServiceVersion, info.Fingerprint["title"])
+	}
+	if info.Fingerprint["auth_scheme"] != "basic" || info.Fingerprint["cdn"] == nil {
+		t.Errorf("auth_scheme %v cdn %v, want them from the full headers", info.Fingerprint["auth_scheme"], info.Fingerprint["cdn"])
+	}
+
+	// The encoded fingerprint stays small
+	encoded, err := json.Marshal(info.Fingerprint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(encoded) > 4096 {
+		t.Errorf("fingerprint encodes to %d bytes", len(encoded))
+	}
+}
+
+// headerNames returns the names in headers, sorted
+func headerNames(headers map[string]string) []string {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestZgrabLimitsUnset(t *testing.T) {
+	output, headers, _ := bloatedHTTP(t)
+	z, _ := cannedZgrab(t, output)
+	// Limits for another module leave http alone
+	z.Limits = map[string]ZgrabLimits{"https": {MaxHeaders: 1}}
+	info := z.fingerprintPort(context.Background(), "127.0.0.1", 80)
+
+	if kept := keptHeaders(t, info); !reflect.DeepEqual(kept, headers) {
+		t.Errorf("%d headers kept, want all %d", len(kept), len(headers))
+	}
+	for _, key := range []string{"headers_omitted", "body", "body_truncated"} {
+		if _, ok := info.Fingerprint[key]; ok {
+			t.Errorf("%s set without limits", key)
+		}
+	}
+	if info.Fingerprint["body_sha256"] == nil {
+		t.Error("body_sha256 dropped without limits")
+	}
+}
+
+func TestZgrabLimitsBodyBudget(t *testing.T) {
+	output, _, body := bloatedHTTP(t)
+	z, _ := cannedZgrab(t, output)
+	z.Limits = map[string]ZgrabLimits{"http": {MaxBodyBytes: 1000}}
+	z.Fallback.BodyBudget = NewBodyBudget(300)
+
+	// The first response gets what is left of the budget, the next none
+	info := z.fingerprintPort(context.Background(), "127.0.0.1", 80)
+	if got, _ := info.Fingerprint["body"].(string); got != body[:300] || info.Fingerprint["body_truncated"] != true {
+		t.Errorf("body is %d bytes, want the 300 the budget allows", len(got))
+	}
+	info = z.fingerprintPort(context.Background(), "127.0.0.1", 80)
+	if _, ok := info.Fingerprint["body"]; ok || info.Fingerprint["body_sha256"] == nil {
+		t.Errorf("over budget: body %v, body_sha256 %v, want only the digest", ok, info.Fingerprint["body_sha256"])
+	}
+	if z.Fallback.BodyBudget.Used() != 300 || z.Fallback.BodyBudget.Skipped() != 2 {
+		t.Errorf("budget used %d skipped %d, want 300 and 2", z.Fallback.BodyBudget.Used(), z.Fallback.BodyBudget.Skipped())
+	}
+}
+
+func TestZgrabLimitsHeaders(t *testing.T) {
+	headers := map[string]string{"content_type": "text/html", "set_cookie": "a=1", "server": "x", "x_powered_by": "php"}
+	tests := []struct {
+		limits  ZgrabLimits
+		want    []string
+		omitted int
+	}{
+		{ZgrabLimits{}, []string{"content_type", "server", "set_cookie", "x_powered_by"}, 0},
+		{ZgrabLimits{MaxHeaders: 2}, []string{"content_type", "server"}, 2},
+		{ZgrabLimits{MaxHeaders: 10}, []string{"content_type", "server", "set_cookie", "x_powered_by"}, 0},
+		// Names match however they are written
+		{ZgrabLimits{DropHeaders: []string{"Set-Cookie", "X-POWERED-BY"}}, []string{"content_type", "server"}, 2},
+		{ZgrabLimits{MaxHeaders: 1, DropHeaders: []string{"content-type"}}, []string{"server"}, 3},
+	}
+	for _, tt := range tests {
+		kept, omitted := tt.limits.limitHeaders(headers)
+		names := headerNames(kept)
+		if !reflect.DeepEqual(names, tt.want) || omitted != tt.omitted {
+			t.Errorf("%+v kept %v omitting %d, want %v omitting %d", tt.limits, names, omitted, tt.want, tt.omitted)
+		}
+	}
+	if len(headers) != 4 {
+		t.Errorf("limitHeaders modified its input: %v", headers)
+	}
+}
+
+func TestZgrabLimitsBody(t *testing.T) {
+	for _, tt := range []struct {
+		max       int
+		body      string
+		want      string
+		truncated bool
+	}{
+		{0, "hello", "", false},
+		{3, "hello", "hel", true},
+		{5, "hello", "hello", false},
+		{100, "hello", "hello", false},
+		{100, "", "", false},
+	} {
+		got, truncated := ZgrabLimits{MaxBodyBytes: tt.max}.limitBody(tt.body, nil)
+		if got != tt.want || truncated != tt.truncated {
+			t.Errorf("max %d of %q = %q, %v, want %q, %v", tt.max, tt.body, got, truncated, tt.want, tt.truncated)
+		}
+	}
+}
+
+func TestCheckZgrabLimits(t *testing.T) {
+	if err := CheckZgrabLimits(map[string]ZgrabLimits{"http": {MaxHeaders: 5}}); err != nil {
+		t.Errorf("http limits: %v", err)
+	}
+	if err := CheckZgrabLimits(nil); err != nil {
+		t.Errorf("no limits: %v", err)
+	}
+	err := CheckZgrabLimits(map[string]ZgrabLimits{"http": {}, "ssh": {MaxHeaders: 5}})
+	if err == nil || !strings.Contains(err.Error(), `"ssh"`) || !strings.Contains(err.Error(), "supported: http") {
+		t.Errorf("ssh limits: %v", err)
+	}
+}
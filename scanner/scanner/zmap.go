@@ -1,11 +1,13 @@
 package scanner
 
 import (
+	"bufio"
 	"context"
 	"encoding/csv"
 	"fmt"
 	"io"
 	"log"
+	"os"
 	"os/exec"
 	"strconv"
 	"strings"
@@ -16,6 +18,15 @@ import (
 type ZmapResult struct {
 	IP   string
 	Port int
+
+	// ConnectTime is the TCP handshake duration (TCP mode only)
+	ConnectTime time.Duration
+
+	// Confidence is set when the port passed a Confirmer check
+	Confidence string
+
+	// Hostname is the target hostname IP was resolved from, if any
+	Hostname string
 }
 
 // ZmapScanner wraps zmap scanning functionality
@@ -24,6 +35,41 @@ type ZmapScanner struct {
 	Rate      int           // packets per second
 	Timeout   time.Duration // connection timeout for banner grabbing
 	Interface string        // network interface (optional)
+	SourceIP  string        // IPv4 source address for probes (optional, -S)
+
+	// SourcePorts, when set, is the port or min-max range probes are sent
+	// from (--source-port)
+	SourcePorts string
+
+	// Gate, when set, pauses the scan between zmap runs
+	Gate *PauseGate
+
+	// Scope optionally restricts which IPs are scanned; set via SetScope
+	Scope       *Scope
+	excludeFile string
+
+	// OutputDir, when set, makes zmap write results to a temp file in this
+	// directory that is tailed while zmap runs, instead of a stdout pipe.
+	// A slow consumer then never stalls zmap's send loop.
+	OutputDir string
+
+	// IPv6 scanning (zmap's ipv6_tcp_synscan module): the source address to
+	// send from, and the most addresses one IPv6 network may expand to
+	IPv6SourceIP   string
+	MaxIPv6Targets int
+
+	// FlushInterval, when set, passes results to scan callbacks every
+	// interval while zmap is still running, instead of once per port
+	FlushInterval time.Duration
+
+	// Retries re-runs each network and port scan up to this many more
+	// times, keeping hosts a lossy run missed. Retrying stops early once a
+	// run finds nothing new.
+	Retries int
+
+	// PrivilegeCheck is run before the scan starts; nil uses
+	// ZmapPrivileges. An error stops the scan before zmap is launched.
+	PrivilegeCheck func() error
 }
 
 // NewZmapScanner creates a new ZmapScanner instance
@@ -41,12 +87,48 @@ func NewZmapScanner(networks []string, rate int, timeoutSecs int) *ZmapScanner {
 	}
 }
 
+// SetScope restricts scanning to scope. Networks are intersected with the
+// allowlist and exclusions are handed to zmap as a blacklist file.
+func (z *ZmapScanner) SetScope(scope *Scope) error {
+	path, err := scope.ExcludeFile()
+	if err != nil {
+		return fmt.Errorf("failed to write zmap exclude file: %w", err)
+	}
+	z.Close()
+	z.Scope = scope
+	z.excludeFile = path
+	return nil
+}
+
+// Close removes any temp files created by SetScope
+func (z *ZmapScanner) Close() {
+	if z.excludeFile != "" {
+		os.Remove(z.excludeFile)
+		z.excludeFile = ""
+	}
+}
+
 // ScanPort scans a specific port across all configured networks using zmap
 func (z *ZmapScanner) ScanPort(ctx context.Context, port int) ([]ZmapResult, error) {
+	if err := z.checkPrivileges(); err != nil {
+		return nil, err
+	}
+	return z.scanPort(ctx, port, nil)
+}
+
+// scanPort is ScanPort that also feeds each result to stream, if set, as
+// soon as zmap reports it
+func (z *ZmapScanner) scanPort(ctx context.Context, port int, stream *portStream) ([]ZmapResult, error) {
 	var allResults []ZmapResult
 
-	for _, network := range z.Networks {
-		results, err := z.scanNetworkPort(ctx, network, port)
+	for _, network := range z.Scope.Networks(z.Networks) {
+		if err := z.Gate.Wait(ctx); err != nil {
+			return allResults, err
+		}
+		results, err := z.scanNetworkPortRetries(ctx, network, port, stream)
+		if fatalScanError(err) {
+			return allResults, err
+		}
 		if err != nil {
 			log.Printf("Warning: error scanning %s:%d: %v", network, port, err)
 			continue
@@ -57,32 +139,86 @@ func (z *ZmapScanner) ScanPort(ctx context.Context, port int) ([]ZmapResult, err
 	return allResults, nil
 }
 
+// scanNetworkPortRetries runs scanNetworkPort, then up to Retries more
+// times while each run still turns up new hosts, and returns the union.
+// stream sees each host once: retries pass it only hosts not yet found.
+func (z *ZmapScanner) scanNetworkPortRetries(ctx context.Context, network string, port int, stream *portStream) ([]ZmapResult, error) {
+	results, err := z.scanNetworkPort(ctx, network, port, stream)
+	if err != nil || z.Retries <= 0 {
+		return results, err
+	}
+
+	seen := make(map[string]bool, len(results))
+	union := results[:0]
+	for _, r := range results {
+		if !seen[r.IP] {
+			seen[r.IP] = true
+			union = append(union, r)
+		}
+	}
+
+	for retry := 1; retry <= z.Retries; retry++ {
+		if err := z.Gate.Wait(ctx); err != nil {
+			return union, err
+		}
+		more, err := z.scanNetworkPort(ctx, network, port, nil)
+		if ctx.Err() != nil {
+			// Aborted mid-retry, not a failed retry of a complete scan
+			return union, ctx.Err()
+		}
+		if err != nil {
+			log.Printf("Warning: zmap retry %d of %s:%d failed: %v", retry, network, port, err)
+			break
+		}
+		found := 0
+		for _, r := range more {
+			if seen[r.IP] {
+				continue
+			}
+			seen[r.IP] = true
+			union = append(union, r)
+			if stream != nil {
+				stream.add(r)
+			}
+			found++
+		}
+		log.Printf("Port %d on %s: zmap retry %d found %d new hosts", port, network, retry, found)
+		if found == 0 {
+			break
+		}
+	}
+	return union, nil
+}
+
 // scanNetworkPort scans a single network for a specific port using zmap
-func (z *ZmapScanner) scanNetworkPort(ctx context.Context, network string, port int) ([]ZmapResult, error) {
-	// Scan the target subnet directly. Using a whitelist file on this zmap
-	// version causes it to walk the entire IPv4 space and filter, which makes
-	// small private-network scans effectively never finish.
-	args := []string{
-		"-p", strconv.Itoa(port),
-		"-r", strconv.Itoa(z.Rate),
-		"-o", "-", // output to stdout
-		"-f", "saddr", // only output source address
-		"--output-module=csv",
-		"-q", // quiet mode
-		"--disable-syslog",
-		"--cooldown-time=3", // reduce wait time after sending
-		network,
+func (z *ZmapScanner) scanNetworkPort(ctx context.Context, network string, port int, stream *portStream) ([]ZmapResult, error) {
+	output := "-" // stdout
+	if z.OutputDir != "" {
+		f, err := os.CreateTemp(z.OutputDir, "zmap-*.csv")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create zmap output file: %w", err)
+		}
+		f.Close()
+		output = f.Name()
+		defer os.Remove(output)
 	}
 
-	if z.Interface != "" {
-		args = append(args, "-i", z.Interface)
+	args, cleanup, err := z.zmapArgs(network, port, output)
+	if err != nil {
+		return nil, err
 	}
+	defer cleanup()
 
 	cmd := exec.CommandContext(ctx, "zmap", args...)
 
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get stdout pipe: %w", err)
+	// In file mode stdout carries nothing and is left unconnected
+	var stdout io.Reader
+	if output == "-" {
+		pipe, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get stdout pipe: %w", err)
+		}
+		stdout = pipe
 	}
 
 	stderr, err := cmd.StderrPipe()
@@ -93,10 +229,13 @@ func (z *ZmapScanner) scanNetworkPort(ctx context.Context, network string, port
 	log.Printf("Running zmap command: zmap %s", strings.Join(args, " "))
 
 	if err := cmd.Start(); err != nil {
-		return nil, fmt.Errorf("failed to start zmap: %w", err)
+		return nil, newZmapError(err, "")
 	}
 
 	// Read results
+	if output != "-" {
+		return z.tailResults(ctx, cmd, output, stderr, port, stream)
+	}
 	var results []ZmapResult
 	reader := csv.NewReader(stdout)
 
@@ -115,17 +254,76 @@ func (z *ZmapScanner) scanNetworkPort(ctx context.Context, network string, port
 			continue
 		}
 		if len(record) > 0 && record[0] != "" {
-			results = append(results, ZmapResult{
-				IP:   strings.TrimSpace(record[0]),
-				Port: port,
-			})
+			result := ZmapResult{IP: strings.TrimSpace(record[0]), Port: port}
+			results = append(results, result)
+			if stream != nil {
+				stream.add(result)
+			}
 		}
 	}
 
 	// Read stderr for any errors
 	stderrBytes, _ := io.ReadAll(stderr)
-	stderrStr := string(stderrBytes)
+	return waitZmap(ctx, cmd, string(stderrBytes), results)
+}
+
+// zmapArgs builds the zmap command line for one network and port. IPv6
+// networks use the ipv6_tcp_synscan module, which takes its targets from a
+// file; the returned cleanup removes it.
+func (z *ZmapScanner) zmapArgs(network string, port int, output string) ([]string, func(), error) {
+	args := []string{
+		"-p", strconv.Itoa(port),
+		"-r", strconv.Itoa(z.Rate),
+		"-o", output,
+		"-f", "saddr", // only output source address
+		"--output-module=csv",
+		"-q", // quiet mode
+		"--disable-syslog",
+		"--cooldown-time=3", // reduce wait time after sending
+	}
+	cleanup := func() {}
+
+	if isIPv6CIDR(network) {
+		if z.IPv6SourceIP == "" {
+			return nil, cleanup, fmt.Errorf("IPv6 network %s needs ipv6_source_ip", network)
+		}
+		targetFile, err := z.ipv6TargetFile(network)
+		if err != nil {
+			return nil, cleanup, err
+		}
+		cleanup = func() { os.Remove(targetFile) }
+		args = append(args,
+			"-M", "ipv6_tcp_synscan",
+			"--ipv6-source-ip="+z.IPv6SourceIP,
+			"--ipv6-target-file="+targetFile,
+		)
+		// Scope exclusions were applied while writing the target file
+	} else {
+		// Scan the target subnet directly. Using a whitelist file on this zmap
+		// version causes it to walk the entire IPv4 space and filter, which makes
+		// small private-network scans effectively never finish.
+		args = append(args, network)
+		if z.excludeFile != "" {
+			args = append(args, "-b", z.excludeFile)
+		}
+		if z.SourceIP != "" {
+			args = append(args, "-S", z.SourceIP)
+		}
+	}
+
+	if z.Interface != "" {
+		args = append(args, "-i", z.Interface)
+	}
+	if z.SourcePorts != "" {
+		args = append(args, "--source-port="+z.SourcePorts)
+	}
 
+	return args, cleanup, nil
+}
+
+// waitZmap waits for zmap to exit and decides whether its exit status is
+// fatal given the results already read
+func waitZmap(ctx context.Context, cmd *exec.Cmd, stderrStr string, results []ZmapResult) ([]ZmapResult, error) {
 	if err := cmd.Wait(); err != nil {
 		log.Printf("zmap stderr: %s", stderrStr)
 		log.Printf("zmap error: %v", err)
@@ -135,13 +333,96 @@ func (z *ZmapScanner) scanNetworkPort(ctx context.Context, network string, port
 		}
 		// Log stderr but don't fail if we got some results
 		if len(results) == 0 && stderrStr != "" {
-			return nil, fmt.Errorf("zmap failed: %s", stderrStr)
+			return nil, newZmapError(err, stderrStr)
 		}
 	}
 
 	return results, nil
 }
 
+// tailResults follows zmap's output file while it runs, parsing each
+// complete line as it appears, and finishes the file once zmap exits
+func (z *ZmapScanner) tailResults(ctx context.Context, cmd *exec.Cmd, path string, stderr io.Reader, port int, stream *portStream) ([]ZmapResult, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, fmt.Errorf("failed to open zmap output file: %w", err)
+	}
+	defer f.Close()
+
+	// stderr closes when zmap exits, which tells the tail to finish up
+	var stderrStr string
+	exited := make(chan struct{})
+	go func() {
+		b, _ := io.ReadAll(stderr)
+		stderrStr = string(b)
+		close(exited)
+	}()
+
+	var results []ZmapResult
+	err = followLines(f, exited, func(line string) {
+		if ip := zmapOutputIP(line); ip != "" {
+			result := ZmapResult{IP: ip, Port: port}
+			results = append(results, result)
+			if stream != nil {
+				stream.add(result)
+			}
+		}
+	})
+	if err != nil {
+		log.Printf("Warning: reading zmap output file %s: %v", path, err)
+	}
+	<-exited
+
+	return waitZmap(ctx, cmd, stderrStr, results)
+}
+
+// followLines calls fn for each complete line of r, polling for more data
+// at EOF until done is closed. Anything written before done is read.
+func followLines(r io.Reader, done <-chan struct{}, fn func(line string)) error {
+	reader := bufio.NewReader(r)
+	var partial string
+
+	for {
+		chunk, err := reader.ReadString('\n')
+		partial += chunk
+		if err == nil {
+			fn(strings.TrimRight(partial, "\r\n"))
+			partial = ""
+			continue
+		}
+		if err != io.EOF {
+			return err
+		}
+
+		select {
+		case <-done:
+			// The writer is gone; one last read picks up its final flush
+			rest, err := io.ReadAll(reader)
+			partial += string(rest)
+			for _, line := range strings.Split(partial, "\n") {
+				if line = strings.TrimRight(line, "\r"); line != "" {
+					fn(line)
+				}
+			}
+			return err
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+}
+
+// zmapOutputIP returns the saddr field of one line of zmap CSV output, or
+// "" for the header and blank lines
+func zmapOutputIP(line string) string {
+	field, _, _ := strings.Cut(line, ",")
+	field = strings.TrimSpace(field)
+	if field == "" || field == "saddr" {
+		return ""
+	}
+	return field
+}
+
 // ScanPorts scans multiple ports across all configured networks
 func (z *ZmapScanner) ScanPorts(ctx context.Context, ports []int) (map[string][]int, error) {
 	return z.ScanPortsWithCallback(ctx, ports, nil)
@@ -150,6 +431,9 @@ func (z *ZmapScanner) ScanPorts(ctx context.Context, ports []int) (map[string][]
 // ScanPortsWithCallback scans ports and calls the callback after each port
 func (z *ZmapScanner) ScanPortsWithCallback(ctx context.Context, ports []int, callback PortScanCallback) (map[string][]int, error) {
 	results := make(map[string][]int)
+	if err := z.checkPrivileges(); err != nil {
+		return results, err
+	}
 
 	for _, port := range ports {
 		select {
@@ -159,7 +443,14 @@ func (z *ZmapScanner) ScanPortsWithCallback(ctx context.Context, ports []int, ca
 		}
 
 		log.Printf("Scanning port %d across %d networks...", port, len(z.Networks))
-		portResults, err := z.ScanPort(ctx, port)
+		stream := z.newStream(port, callback)
+		portResults, err := z.scanPort(ctx, port, stream)
+		if stream != nil {
+			stream.finish()
+		}
+		if fatalScanError(err) {
+			return results, err
+		}
 		if err != nil {
 			log.Printf("Error scanning port %d: %v", port, err)
 			continue
@@ -171,7 +462,7 @@ func (z *ZmapScanner) ScanPortsWithCallback(ctx context.Context, ports []int, ca
 			results[r.IP] = append(results[r.IP], r.Port)
 		}
 
-		if callback != nil && len(portResults) > 0 {
+		if stream == nil && callback != nil && len(portResults) > 0 {
 			callback(port, portResults)
 		}
 	}
@@ -179,6 +470,15 @@ func (z *ZmapScanner) ScanPortsWithCallback(ctx context.Context, ports []int, ca
 	return results, nil
 }
 
+// newStream returns a portStream for port when FlushInterval is set, or
+// nil to deliver the port's results in one callback
+func (z *ZmapScanner) newStream(port int, callback PortScanCallback) *portStream {
+	if z.FlushInterval <= 0 || callback == nil {
+		return nil
+	}
+	return newPortStream(port, z.FlushInterval, callback)
+}
+
 // ScanAllPorts scans all 65535 ports using zmap (much faster than per-port)
 func (z *ZmapScanner) ScanAllPorts(ctx context.Context) (map[string][]int, error) {
 	return z.ScanAllPortsWithCallback(ctx, nil)
@@ -187,10 +487,16 @@ func (z *ZmapScanner) ScanAllPorts(ctx context.Context) (map[string][]int, error
 // ScanAllPortsWithCallback scans all ports and calls the callback after each port
 func (z *ZmapScanner) ScanAllPortsWithCallback(ctx context.Context, callback PortScanCallback) (map[string][]int, error) {
 	results := make(map[string][]int)
+	if err := z.checkPrivileges(); err != nil {
+		return results, err
+	}
 
-	for _, network := range z.Networks {
+	for _, network := range z.Scope.Networks(z.Networks) {
 		log.Printf("Scanning all ports on %s...", network)
 		networkResults, err := z.scanNetworkAllPortsWithCallback(ctx, network, callback)
+		if fatalScanError(err) {
+			return results, err
+		}
 		if err != nil {
 			log.Printf("Warning: error scanning %s: %v", network, err)
 			continue
@@ -227,13 +533,18 @@ func (z *ZmapScanner) scanNetworkAllPortsWithCallback(ctx context.Context, netwo
 		log.Printf("Scanning ports %d-%d on %s...", batchStart, batchEnd, network)
 
 		for port := batchStart; port <= batchEnd; port++ {
-			select {
-			case <-ctx.Done():
-				return results, ctx.Err()
-			default:
+			if err := z.Gate.Wait(ctx); err != nil {
+				return results, err
 			}
 
-			portResults, err := z.scanNetworkPort(ctx, network, port)
+			stream := z.newStream(port, callback)
+			portResults, err := z.scanNetworkPortRetries(ctx, network, port, stream)
+			if stream != nil {
+				stream.finish()
+			}
+			if fatalScanError(err) {
+				return results, err
+			}
 			if err != nil {
 				continue
 			}
@@ -242,7 +553,7 @@ func (z *ZmapScanner) scanNetworkAllPortsWithCallback(ctx context.Context, netwo
 				results[r.IP] = append(results[r.IP], r.Port)
 			}
 
-			if callback != nil && len(portResults) > 0 {
+			if stream == nil && callback != nil && len(portResults) > 0 {
 				callback(port, portResults)
 			}
 		}
@@ -251,34 +562,6 @@ func (z *ZmapScanner) scanNetworkAllPortsWithCallback(ctx context.Context, netwo
 	return results, nil
 }
 
-// CommonPorts returns a list of commonly scanned ports
-func CommonPorts() []int {
-	return []int{
-		21,    // FTP
-		22,    // SSH
-		23,    // Telnet
-		25,    // SMTP
-		53,    // DNS
-		80,    // HTTP
-		110,   // POP3
-		143,   // IMAP
-		443,   // HTTPS
-		445,   // SMB
-		993,   // IMAPS
-		995,   // POP3S
-		1433,  // MSSQL
-		1521,  // Oracle
-		3306,  // MySQL
-		3389,  // RDP
-		5432,  // PostgreSQL
-		5900,  // VNC
-		6379,  // Redis
-		8080,  // HTTP Alt
-		8443,  // HTTPS Alt
-		27017, // MongoDB
-	}
-}
-
 // Zgrab2Scanner wraps zgrab2 for service fingerprinting
 type Zgrab2Scanner struct {
 	Timeout time.Duration
@@ -323,32 +606,8 @@ func (z *Zgrab2Scanner) GrabBanner(ctx context.Context, ip string, port int, mod
 	return string(output), nil
 }
 
-// GetModuleForPort returns the appropriate zgrab2 module for a port
+// GetModuleForPort returns the zgrab2 module ZgrabFingerprinter runs for
+// a port
 func GetModuleForPort(port int) string {
-	switch port {
-	case 22:
-		return "ssh"
-	case 80, 8080, 8000, 8888:
-		return "http"
-	case 443, 8443:
-		return "tls"
-	case 21:
-		return "ftp"
-	case 25, 465, 587:
-		return "smtp"
-	case 110:
-		return "pop3"
-	case 143:
-		return "imap"
-	case 3306:
-		return "mysql"
-	case 5432:
-		return "postgres"
-	case 6379:
-		return "redis"
-	case 27017:
-		return "mongodb"
-	default:
-		return "banner"
-	}
+	return getZgrabModule(port)
 }
@@ -0,0 +1,78 @@
+package scanner
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+)
+
+// DefaultMaxIPv6Targets caps IPv6 network expansion at a /112
+const DefaultMaxIPv6Targets = 1 << 16
+
+// isIPv6CIDR reports whether cidr is an IPv6 network
+func isIPv6CIDR(cidr string) bool {
+	ip, _, err := net.ParseCIDR(cidr)
+	return err == nil && ip.To4() == nil
+}
+
+// CIDRSize returns the number of addresses in cidr, saturating at 1<<62 so
+// huge IPv6 ranges don't overflow
+func CIDRSize(cidr string) (uint64, error) {
+	_, ipnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return 0, err
+	}
+	ones, bits := ipnet.Mask.Size()
+	hostBits := bits - ones
+	if hostBits >= 62 {
+		return 1 << 62, nil
+	}
+	return 1 << hostBits, nil
+}
+
+// ipv6TargetFile writes every in-scope address of network to a temp file,
+// one per line, for zmap's --ipv6-target-file. IPv6 zmap cannot take a CIDR
+// directly, so the range has to be within MaxIPv6Targets.
+func (z *ZmapScanner) ipv6TargetFile(network string) (string, error) {
+	limit := z.MaxIPv6Targets
+	if limit <= 0 {
+		limit = DefaultMaxIPv6Targets
+	}
+	size, err := CIDRSize(network)
+	if err != nil {
+		return "", err
+	}
+	if size > uint64(limit) {
+		return "", fmt.Errorf("IPv6 network %s has %d addresses, more than the limit of %d", network, size, limit)
+	}
+
+	ip, ipnet, _ := net.ParseCIDR(network)
+
+	f, err := os.CreateTemp(z.OutputDir, "zmap-targets-*.txt")
+	if err != nil {
+		return "", fmt.Errorf("failed to create IPv6 target file: %w", err)
+	}
+	w := bufio.NewWriter(f)
+	n := 0
+	for ip := ip.Mask(ipnet.Mask); ipnet.Contains(ip); inc(ip) {
+		if z.Scope.Contains(ip) {
+			fmt.Fprintln(w, ip.String())
+			n++
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", fmt.Errorf("failed to write IPv6 target file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("failed to write IPv6 target file: %w", err)
+	}
+	if n == 0 {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("no in-scope addresses in %s", network)
+	}
+	return f.Name(), nil
+}
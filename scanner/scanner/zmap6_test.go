@@ -0,0 +1,188 @@
+package scanner
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// argValue returns the value following flag in args, or "" if absent
+func argValue(args []string, flag string) string {
+	for i, arg := range args {
+		if arg == flag && i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+func TestZmapArgsIPv4(t *testing.T) {
+	z := testZmapScanner()
+	z.SourceIP = "192.168.1.10"
+	z.IPv6SourceIP = "2001:db8::10"
+	z.Interface = "eth1"
+
+	args, cleanup, err := z.zmapArgs("192.168.1.0/24", 443, "-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	want := []string{
+		"-p", "443", "-r", "10000", "-o", "-", "-f", "saddr", "--output-module=csv", "-q",
+		"--disable-syslog", "--cooldown-time=3", "192.168.1.0/24", "-S", "192.168.1.10", "-i", "eth1",
+	}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("args = %q\nwant %q", args, want)
+	}
+}
+
+func TestZmapArgsIPv6(t *testing.T) {
+	z := testZmapScanner()
+	z.SourceIP = "192.168.1.10"
+	z.IPv6SourceIP = "2001:db8::10"
+	z.OutputDir = t.TempDir()
+
+	args, cleanup, err := z.zmapArgs("2001:db8:1::/126", 22, "-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if argValue(args, "-M") != "ipv6_tcp_synscan" || argValue(args, "-p") != "22" {
+		t.Errorf("args = %q, want the ipv6_tcp_synscan module on port 22", args)
+	}
+	joined := strings.Join(args, " ")
+	if !strings.Contains(joined, "--ipv6-source-ip=2001:db8::10") {
+		t.Errorf("args = %q, want the IPv6 source address", args)
+	}
+	// The range goes through the target file, and the IPv4 source isn't used
+	if strings.Contains(joined, "2001:db8:1::/126") || argValue(args, "-S") != "" {
+		t.Errorf("args = %q, want neither the CIDR nor -S", args)
+	}
+
+	var targetFile string
+	for _, arg := range args {
+		if path, ok := strings.CutPrefix(arg, "--ipv6-target-file="); ok {
+			targetFile = path
+		}
+	}
+	contents, err := os.ReadFile(targetFile)
+	if err != nil {
+		t.Fatalf("target file %q: %v", targetFile, err)
+	}
+	if got := strings.Fields(string(contents)); !reflect.DeepEqual(got, []string{"2001:db8:1::", "2001:db8:1::1", "2001:db8:1::2", "2001:db8:1::3"}) {
+		t.Errorf("target file = %q", got)
+	}
+
+	cleanup()
+	if _, err := os.Stat(targetFile); !os.IsNotExist(err) {
+		t.Errorf("target file %s left after cleanup", targetFile)
+	}
+}
+
+func TestZmapArgsIPv6Errors(t *testing.T) {
+	z := testZmapScanner()
+	z.OutputDir = t.TempDir()
+	if _, _, err := z.zmapArgs("2001:db8::/120", 22, "-"); err == nil || !strings.Contains(err.Error(), "needs ipv6_source_ip") {
+		t.Errorf("without ipv6_source_ip: %v", err)
+	}
+
+	z.IPv6SourceIP = "2001:db8::10"
+	z.MaxIPv6Targets = 256
+	if _, cleanup, err := z.zmapArgs("2001:db8::/120", 22, "-"); err != nil {
+		t.Errorf("/120 within a 256 address cap: %v", err)
+	} else {
+		cleanup()
+	}
+	if _, _, err := z.zmapArgs("2001:db8::/119", 22, "-"); err == nil || !strings.Contains(err.Error(), "more than the limit of 256") {
+		t.Errorf("/119 over a 256 address cap: %v", err)
+	}
+	z.MaxIPv6Targets = 0
+	if _, _, err := z.zmapArgs("2001:db8::/64", 22, "-"); err == nil {
+		t.Error("/64 accepted under the default cap")
+	}
+
+	left, _ := os.ReadDir(z.OutputDir)
+	if len(left) != 0 {
+		t.Errorf("rejected ranges left %d target files", len(left))
+	}
+}
+
+func TestIPv6TargetFileAppliesScope(t *testing.T) {
+	scope, err := NewScope(nil, []string{"2001:db8::1/128", "2001:db8::2/127"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	z := testZmapScanner()
+	z.Scope = scope
+	z.OutputDir = t.TempDir()
+
+	path, err := z.ipv6TargetFile("2001:db8::/125")
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents, _ := os.ReadFile(path)
+	want := []string{"2001:db8::", "2001:db8::4", "2001:db8::5", "2001:db8::6", "2001:db8::7"}
+	if got := strings.Fields(string(contents)); !reflect.DeepEqual(got, want) {
+		t.Errorf("target file = %q, want %q", got, want)
+	}
+
+	scope, _ = NewScope(nil, []string{"2001:db8::/126"})
+	z.Scope = scope
+	if _, err := z.ipv6TargetFile("2001:db8::/126"); err == nil {
+		t.Error("fully excluded range produced a target file")
+	}
+}
+
+func TestZmapScansBothFamilies(t *testing.T) {
+	calls := fakeZmap(t, "saddr\n2001:db8::1\n")
+	z := testZmapScanner("10.0.0.0/24", "2001:db8::/126")
+	z.IPv6SourceIP = "2001:db8::10"
+	z.OutputDir = t.TempDir()
+
+	if _, err := z.ScanPort(context.Background(), 22); err != nil {
+		t.Fatal(err)
+	}
+	args, _ := os.ReadFile(calls)
+	runs := strings.Split(strings.TrimSpace(string(args)), "\n")
+	if len(runs) != 2 {
+		t.Fatalf("zmap ran %d times, want once per network: %q", len(runs), runs)
+	}
+	if !strings.Contains(runs[0], " 10.0.0.0/24") || strings.Contains(runs[0], "ipv6") {
+		t.Errorf("IPv4 run = %q", runs[0])
+	}
+	if !strings.Contains(runs[1], "-M ipv6_tcp_synscan") {
+		t.Errorf("IPv6 run = %q", runs[1])
+	}
+}
+
+func TestCIDRSize(t *testing.T) {
+	for cidr, want := range map[string]uint64{
+		"10.0.0.0/24":    256,
+		"10.0.0.1/32":    1,
+		"2001:db8::/120": 256,
+		"2001:db8::/64":  1 << 62,
+		"::/0":           1 << 62,
+	} {
+		if got, err := CIDRSize(cidr); err != nil || got != want {
+			t.Errorf("CIDRSize(%s) = %d, %v, want %d", cidr, got, err, want)
+		}
+	}
+	if _, err := CIDRSize("10.0.0.0"); err == nil {
+		t.Error("CIDRSize accepted an address without a prefix")
+	}
+}
+
+func TestIsIPv6CIDR(t *testing.T) {
+	for cidr, want := range map[string]bool{
+		"10.0.0.0/8":          false,
+		"::ffff:10.0.0.0/104": false,
+		"2001:db8::/32":       true,
+		"fe80::/10":           true,
+		"garbage":             false,
+	} {
+		if got := isIPv6CIDR(cidr); got != want {
+			t.Errorf("isIPv6CIDR(%s) = %v, want %v", cidr, got, want)
+		}
+	}
+}
@@ -0,0 +1,374 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// largeZmapOutput is the number of result rows in the large CSV tests
+const largeZmapOutput = 200000
+
+// zmapCSV returns zmap's csv output for n hosts, header included, and the
+// hosts in order
+func zmapCSV(n int) (string, []string) {
+	var b strings.Builder
+	b.WriteString("saddr\n")
+	hosts := make([]string, n)
+	for i := range hosts {
+		hosts[i] = fmt.Sprintf("10.%d.%d.%d", i>>16&0xff, i>>8&0xff, i&0xff)
+		b.WriteString(hosts[i] + "\n")
+	}
+	return b.String(), hosts
+}
+
+// fakeZmap puts a zmap script on a fresh PATH that logs its arguments to
+// the returned file and writes output to its -o target: the first half,
+// a pause, then the rest
+func fakeZmap(t *testing.T, output string) string {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("PATH", dir+":/usr/bin:/bin")
+	fixture := filepath.Join(dir, "output.csv")
+	if err := os.WriteFile(fixture, []byte(output), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	half := strings.Count(output, "\n") / 2
+	calls := filepath.Join(dir, "calls")
+	script := fmt.Sprintf(`#!/bin/sh
+echo "$@" >> %[1]s
+out=-
+while [ $# -gt 0 ]; do
+	[ "$1" = -o ] && out=$2
+	shift
+done
+if [ "$out" = - ]; then
+	cat %[2]s
+else
+	head -n %[3]d %[2]s >> "$out"
+	sleep 0.3
+	tail -n +%[4]d %[2]s >> "$out"
+fi
+`, calls, fixture, half, half+1)
+	if err := os.WriteFile(filepath.Join(dir, "zmap"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return calls
+}
+
+// testZmapScanner is a zmap scanner over networks that skips the
+// privilege check
+func testZmapScanner(networks ...string) *ZmapScanner {
+	z := NewZmapScanner(networks, 0, 1)
+	z.PrivilegeCheck = func() error { return nil }
+	return z
+}
+
+func TestZmapOutputFileStreamsLargeCSV(t *testing.T) {
+	output, hosts := zmapCSV(largeZmapOutput)
+	fakeZmap(t, output)
+	z := testZmapScanner("10.0.0.0/8")
+	z.OutputDir = t.TempDir()
+
+	var streamed int
+	found, err := z.ScanPortsWithCallback(context.Background(), []int{22}, func(port int, results []ZmapResult) {
+		streamed += len(results)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != len(hosts) || streamed != len(hosts) {
+		t.Fatalf("found %d hosts, streamed %d, want %d", len(found), streamed, len(hosts))
+	}
+	for _, ip := range []string{hosts[0], hosts[len(hosts)/2], hosts[len(hosts)-1]} {
+		if ports := found[ip]; len(ports) != 1 || ports[0] != 22 {
+			t.Errorf("%s = %v, want [22]", ip, ports)
+		}
+	}
+
+	// The output file is removed once parsed
+	left, _ := os.ReadDir(z.OutputDir)
+	if len(left) != 0 {
+		t.Errorf("output directory still holds %d files", len(left))
+	}
+}
+
+func TestZmapOutputFileMatchesStdout(t *testing.T) {
+	output, _ := zmapCSV(1000)
+	calls := fakeZmap(t, output)
+
+	piped, err := testZmapScanner("10.0.0.0/16").ScanPort(context.Background(), 80)
+	if err != nil {
+		t.Fatal(err)
+	}
+	z := testZmapScanner("10.0.0.0/16")
+	z.OutputDir = t.TempDir()
+	tailed, err := z.ScanPort(context.Background(), 80)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(piped) != 1000 || len(tailed) != len(piped) {
+		t.Fatalf("stdout gave %d results, output file %d, want 1000 each", len(piped), len(tailed))
+	}
+	for i := range piped {
+		if piped[i] != tailed[i] {
+			t.Fatalf("result %d: stdout %+v, output file %+v", i, piped[i], tailed[i])
+		}
+	}
+
+	args, err := os.ReadFile(calls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runs := strings.Split(strings.TrimSpace(string(args)), "\n")
+	if len(runs) != 2 || !strings.Contains(runs[0], "-o -") || !strings.Contains(runs[1], "-o "+z.OutputDir+"/zmap-") {
+		t.Errorf("zmap runs = %q, want stdout then an output file", runs)
+	}
+}
+
+func TestFollowLinesWhileWriting(t *testing.T) {
+	output, hosts := zmapCSV(largeZmapOutput)
+	path := filepath.Join(t.TempDir(), "zmap.csv")
+	w, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// Write in uneven chunks that split lines, with pauses the reader
+	// must wait through
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer w.Close()
+		for rest := output; rest != ""; {
+			n := min(len(rest), 64*1024+7)
+			w.WriteString(rest[:n])
+			rest = rest[n:]
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	var got []string
+	if err := followLines(r, done, func(line string) {
+		if ip := zmapOutputIP(line); ip != "" {
+			got = append(got, ip)
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(hosts) {
+		t.Fatalf("parsed %d hosts, want %d", len(got), len(hosts))
+	}
+	for i := range hosts {
+		if got[i] != hosts[i] {
+			t.Fatalf("line %d = %q, want %q", i, got[i], hosts[i])
+		}
+	}
+}
+
+func TestFollowLinesFinalPartialLine(t *testing.T) {
+	done := make(chan struct{})
+	close(done)
+	var got []string
+	followLines(strings.NewReader("saddr\n10.0.0.1\r\n10.0.0.2"), done, func(line string) {
+		got = append(got, line)
+	})
+	if strings.Join(got, ",") != "saddr,10.0.0.1,10.0.0.2" {
+		t.Errorf("lines = %q, want the unterminated last line included", got)
+	}
+}
+
+func TestZmapOutputIP(t *testing.T) {
+	for line, want := range map[string]string{
+		"saddr":          "",
+		"":               "",
+		"  ":             "",
+		"10.0.0.1":       "10.0.0.1",
+		" 10.0.0.2 ,x,y": "10.0.0.2",
+	} {
+		if got := zmapOutputIP(line); got != want {
+			t.Errorf("zmapOutputIP(%q) = %q, want %q", line, got, want)
+		}
+	}
+}
+
+// zmapRun is what one run of a successiveZmap prints before exiting:
+// hosts on stdout, or a failure, or nothing until it is killed
+type zmapRun struct {
+	hosts []string
+	fail  bool
+	hang  bool
+}
+
+// successiveZmap puts a zmap script on a fresh PATH whose nth run behaves
+// like runs[n-1], repeating the last run after that, and returns a count of
+// the runs so far
+func successiveZmap(t *testing.T, runs ...zmapRun) func() int {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("PATH", dir+":/usr/bin:/bin")
+	calls := filepath.Join(dir, "calls")
+	for i, run := range runs {
+		script := "echo saddr\n"
+		for _, host := range run.hosts {
+			script += "echo " + host + "\n"
+		}
+		switch {
+		case run.fail:
+			script = "echo 'zmap: failed to open socket' >&2\nexit 1\n"
+		case run.hang:
+			script = "exec sleep 30\n"
+		}
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("run.%d", i+1)), []byte(script), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	script := fmt.Sprintf(`#!/bin/sh
+echo "$@" >> %[1]s
+n=$(wc -l < %[1]s)
+[ "$n" -gt %[2]d ] && n=%[2]d
+. %[3]s/run.$n
+`, calls, len(runs), dir)
+	if err := os.WriteFile(filepath.Join(dir, "zmap"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return func() int {
+		data, _ := os.ReadFile(calls)
+		return strings.Count(string(data), "\n")
+	}
+}
+
+// resultIPs lists the IPs of results in order
+func resultIPs(results []ZmapResult) []string {
+	var ips []string
+	for _, r := range results {
+		ips = append(ips, r.IP)
+	}
+	return ips
+}
+
+func TestZmapRetriesUnion(t *testing.T) {
+	runs := successiveZmap(t,
+		zmapRun{hosts: []string{"10.0.0.1", "10.0.0.2", "10.0.0.1"}},
+		zmapRun{hosts: []string{"10.0.0.2", "10.0.0.3"}},
+		zmapRun{hosts: []string{"10.0.0.4", "10.0.0.1", "10.0.0.3"}},
+		zmapRun{hosts: []string{"10.0.0.4"}},
+	)
+	z := testZmapScanner("10.0.0.0/24")
+	z.Retries = 5
+
+	var streamed []string
+	found, err := z.ScanPortsWithCallback(context.Background(), []int{22}, func(port int, results []ZmapResult) {
+		streamed = append(streamed, resultIPs(results)...)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4"}
+	if len(found) != len(want) {
+		t.Errorf("found %v, want %v", found, want)
+	}
+	for _, ip := range want {
+		if ports := found[ip]; len(ports) != 1 || ports[0] != 22 {
+			t.Errorf("%s = %v, want [22]", ip, ports)
+		}
+	}
+	// Each host is streamed once, whichever run found it
+	if !reflect.DeepEqual(streamed, want) {
+		t.Errorf("streamed %v, want %v", streamed, want)
+	}
+	// The fourth run found nothing new, so no fifth ran
+	if n := runs(); n != 4 {
+		t.Errorf("zmap ran %d times, want 4", n)
+	}
+}
+
+func TestZmapRetriesLimit(t *testing.T) {
+	for retries, wantRuns := range map[int]int{0: 1, 1: 2, 2: 3} {
+		runs := successiveZmap(t,
+			zmapRun{hosts: []string{"10.0.0.1"}},
+			zmapRun{hosts: []string{"10.0.0.2"}},
+			zmapRun{hosts: []string{"10.0.0.3"}},
+			zmapRun{hosts: []string{"10.0.0.4"}},
+		)
+		z := testZmapScanner("10.0.0.0/24")
+		z.Retries = retries
+		results, err := z.ScanPort(context.Background(), 80)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := runs(); n != wantRuns || len(results) != wantRuns {
+			t.Errorf("retries %d: %d runs found %v, want %d runs", retries, n, resultIPs(results), wantRuns)
+		}
+	}
+}
+
+func TestZmapRetryFailureKeepsUnion(t *testing.T) {
+	runs := successiveZmap(t,
+		zmapRun{hosts: []string{"10.0.0.1", "10.0.0.2"}},
+		zmapRun{hosts: []string{"10.0.0.3"}},
+		zmapRun{fail: true},
+	)
+	z := testZmapScanner("10.0.0.0/24")
+	z.Retries = 3
+	results, err := z.ScanPort(context.Background(), 80)
+	if err != nil {
+		t.Fatalf("ScanPort = %v, want the failed retry only logged", err)
+	}
+	if got, want := resultIPs(results), []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}; !reflect.DeepEqual(got, want) || runs() != 3 {
+		t.Errorf("%d runs found %v, want %v from 3", runs(), got, want)
+	}
+}
+
+func TestZmapRetriesCancelled(t *testing.T) {
+	runs := successiveZmap(t,
+		zmapRun{hosts: []string{"10.0.0.1"}},
+		zmapRun{hang: true},
+	)
+	z := testZmapScanner("10.0.0.0/24")
+	z.Retries = 3
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		if eventually(func() bool { return runs() == 2 }) {
+			cancel()
+		}
+	}()
+	start := time.Now()
+	results, err := z.scanNetworkPortRetries(ctx, "10.0.0.0/24", 80, nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("scanNetworkPortRetries = %v, want context.Canceled rather than a partial success", err)
+	}
+	if got := resultIPs(results); !reflect.DeepEqual(got, []string{"10.0.0.1"}) {
+		t.Errorf("results = %v, want the first run's hosts", got)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("cancellation took %s to stop the hanging retry", elapsed)
+	}
+
+	// A scan cancelled while paused between retries stops there too
+	runs = successiveZmap(t, zmapRun{hosts: []string{"10.0.0.1"}}, zmapRun{hosts: []string{"10.0.0.2"}})
+	z.Gate = NewPauseGate()
+	z.Gate.Pause()
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		if eventually(func() bool { return runs() == 1 }) {
+			cancel()
+		}
+	}()
+	if _, err := z.scanNetworkPortRetries(ctx, "10.0.0.0/24", 80, nil); !errors.Is(err, context.Canceled) || runs() != 1 {
+		t.Errorf("paused retry: %v after %d runs, want context.Canceled after 1", err, runs())
+	}
+}
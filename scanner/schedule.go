@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+
+	"network-scanner/db"
+)
+
+// scheduler runs the scheduled scan job on a cron schedule that POST
+// /schedule can replace while running. With a state file the replacement is
+// saved beside it and outlives restarts; without one it lasts until exit.
+type scheduler struct {
+	mu    sync.Mutex
+	cron  *cron.Cron
+	job   func()
+	entry cron.EntryID
+	spec  string
+	next  cron.Schedule
+	state *db.StateFile // nil keeps runtime changes in memory only
+}
+
+// newScheduler schedules job on spec, or on the schedule saved in state by
+// an earlier POST /schedule if there is one
+func newScheduler(spec string, job func(), state *db.StateFile) (*scheduler, error) {
+	s := &scheduler{cron: cron.New(), job: job, state: state}
+	if state != nil {
+		saved, err := state.Schedule()
+		if err != nil {
+			log.Printf("Warning: %v; using the configured schedule", err)
+		} else if saved != "" {
+			if _, err := cron.ParseStandard(saved); err != nil {
+				log.Printf("Warning: ignoring saved schedule %q: %v", saved, err)
+			} else {
+				log.Printf("Using schedule %q set at runtime instead of the configured %q", saved, spec)
+				spec = saved
+			}
+		}
+	}
+	if err := s.replace(spec); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Start begins running scheduled scans
+func (s *scheduler) Start() {
+	s.cron.Start()
+}
+
+// Stop stops scheduling scans; a scan already started keeps running
+func (s *scheduler) Stop() {
+	s.cron.Stop()
+}
+
+// Spec returns the current cron schedule
+func (s *scheduler) Spec() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.spec
+}
+
+// Next returns when the next scheduled scan is due
+func (s *scheduler) Next() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.next.Next(time.Now())
+}
+
+// Set replaces the schedule, saving it when there is a state file. An
+// invalid spec leaves the current schedule in place.
+func (s *scheduler) Set(spec string) error {
+	if err := s.replace(spec); err != nil {
+		return err
+	}
+	if s.state != nil {
+		if err := s.state.SaveSchedule(spec); err != nil {
+			return fmt.Errorf("schedule changed but not saved: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *scheduler) replace(spec string) error {
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		return fmt.Errorf("invalid schedule %q: %w", spec, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.entry != 0 {
+		s.cron.Remove(s.entry)
+	}
+	s.entry = s.cron.Schedule(schedule, cron.FuncJob(s.job))
+	s.spec = spec
+	s.next = schedule
+	return nil
+}
+
+type scheduleRequest struct {
+	Schedule string `json:"schedule"` // standard 5-field cron expression
+}
+
+type scheduleResponse struct {
+	Schedule  string `json:"schedule"`
+	NextRun   string `json:"next_run"`
+	Persisted bool   `json:"persisted"` // false when the change lasts only until restart
+}
+
+// handleSchedule replaces the scan schedule without a restart and returns
+// when the next scheduled scan is due. A running scan is unaffected.
+func (s *controlServer) handleSchedule(w http.ResponseWriter, r *http.Request) {
+	var req scheduleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, actionResponse{Status: "invalid_request", Message: "invalid JSON body: " + err.Error()})
+		return
+	}
+	spec := strings.TrimSpace(req.Schedule)
+	if _, err := cron.ParseStandard(spec); err != nil {
+		writeJSON(w, http.StatusBadRequest, actionResponse{Status: "invalid_request", Message: fmt.Sprintf("invalid schedule %q: %v", spec, err)})
+		return
+	}
+
+	if err := s.scheduler.Set(spec); err != nil {
+		writeJSON(w, http.StatusInternalServerError, actionResponse{Status: "error", Message: err.Error()})
+		return
+	}
+	next := s.scheduler.Next()
+	log.Printf("Schedule changed to %q via POST /schedule; next scan at %s", spec, next.Format(time.RFC3339))
+
+	writeJSON(w, http.StatusOK, scheduleResponse{
+		Schedule:  spec,
+		NextRun:   next.Format(time.RFC3339),
+		Persisted: s.scheduler.state != nil,
+	})
+}
+
+// fullScanCycle alternates full sweeps with quick verify runs for
+// full_scan_interval, counting the quick runs since the last sweep in the
+// state file so the alternation survives restarts
+type fullScanCycle struct {
+	interval int // full_scan_interval; 0 or 1 sweeps every run
+	state    *db.StateFile
+}
+
+// quick reports whether the coming run should only verify known endpoints,
+// and the count of quick runs before it. A count that can't be read means
+// a full sweep.
+func (c fullScanCycle) quick() (bool, int) {
+	if c.interval <= 1 {
+		return false, 0
+	}
+	cycle, err := c.state.Cycle()
+	if err != nil {
+		log.Printf("Warning: %v; running a full scan", err)
+		return false, 0
+	}
+	if cycle >= c.interval-1 {
+		return false, cycle
+	}
+	log.Printf("Quick verify run (%d of %d before the next full scan)", cycle+1, c.interval-1)
+	return true, cycle
+}
+
+// record saves the count after a completed run: one more than cycle after
+// a quick run, and zero after a full sweep
+func (c fullScanCycle) record(quick bool, cycle int) {
+	if c.interval <= 1 {
+		return
+	}
+	next := 0
+	if quick {
+		next = cycle + 1
+	}
+	if err := c.state.SaveCycle(next); err != nil {
+		log.Printf("Failed to save scan cycle: %v", err)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"network-scanner/config"
+	"network-scanner/db"
+)
+
+// postSchedule sends body to POST /schedule with the control token and
+// returns the response code and body
+func postSchedule(t *testing.T, handler http.Handler, body, auth string) (int, []byte) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/schedule", strings.NewReader(body))
+	if auth != "" {
+		req.Header.Set("Authorization", "Bearer "+auth)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec.Code, rec.Body.Bytes()
+}
+
+// scheduleServer is a control server whose scheduler starts on spec and
+// saves runtime changes beside a fresh state file
+func scheduleServer(t *testing.T, spec string) (*controlServer, *db.StateFile) {
+	t.Helper()
+	healthy := true
+	s := newTestServer(t, &config.Config{ControlAuthToken: "secret"}, stubAPI(t, &healthy).URL)
+	state := &db.StateFile{Path: filepath.Join(t.TempDir(), "state.json")}
+	sched, err := newScheduler(spec, func() {}, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.scheduler = sched
+	return s, state
+}
+
+func TestScheduleEndpoint(t *testing.T) {
+	s, state := scheduleServer(t, "0 * * * *")
+	handler := s.routes()
+
+	if code, _ := postSchedule(t, handler, `{"schedule": "*/30 * * * *"}`, ""); code != http.StatusUnauthorized {
+		t.Fatalf("POST /schedule without a token = %d, want 401", code)
+	}
+	if s.scheduler.Spec() != "0 * * * *" {
+		t.Fatalf("unauthorized request changed the schedule to %q", s.scheduler.Spec())
+	}
+
+	before := time.Now()
+	code, raw := postSchedule(t, handler, `{"schedule": " */30 * * * * "}`, "secret")
+	if code != http.StatusOK {
+		t.Fatalf("POST /schedule = %d %s, want 200", code, raw)
+	}
+	var resp scheduleResponse
+	if err := json.Unmarshal(raw, &resp); err != nil {
+		t.Fatalf("decoding %q: %v", raw, err)
+	}
+	if resp.Schedule != "*/30 * * * *" || !resp.Persisted {
+		t.Errorf("response = %+v, want the trimmed schedule, persisted", resp)
+	}
+	next, err := time.Parse(time.RFC3339, resp.NextRun)
+	if err != nil {
+		t.Fatalf("next_run %q: %v", resp.NextRun, err)
+	}
+	if next.Before(before.Truncate(time.Second)) || next.After(before.Add(30*time.Minute)) || next.Minute()%30 != 0 {
+		t.Errorf("next_run = %s, want the next half hour after %s", next, before)
+	}
+	if s.scheduler.Spec() != "*/30 * * * *" || len(s.scheduler.cron.Entries()) != 1 {
+		t.Errorf("scheduler runs %q with %d entries, want only the new schedule", s.scheduler.Spec(), len(s.scheduler.cron.Entries()))
+	}
+
+	// A restart picks up the saved schedule over the configured one
+	restarted, err := newScheduler("0 * * * *", func() {}, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restarted.Spec() != "*/30 * * * *" {
+		t.Errorf("after restart schedule = %q, want the one set at runtime", restarted.Spec())
+	}
+}
+
+func TestScheduleEndpointInvalid(t *testing.T) {
+	s, state := scheduleServer(t, "0 * * * *")
+	handler := s.routes()
+	for _, body := range []string{
+		`not json`,
+		`{}`,
+		`{"schedule": "every half hour"}`,
+		`{"schedule": "*/30 * * *"}`,
+		`{"schedule": "61 * * * *"}`,
+	} {
+		code, raw := postSchedule(t, handler, body, "secret")
+		var resp actionResponse
+		if err := json.Unmarshal(raw, &resp); err != nil {
+			t.Fatalf("decoding %q: %v", raw, err)
+		}
+		if code != http.StatusBadRequest || resp.Status != "invalid_request" {
+			t.Errorf("POST /schedule %s = %d %+v, want 400", body, code, resp)
+		}
+	}
+	if s.scheduler.Spec() != "0 * * * *" || len(s.scheduler.cron.Entries()) != 1 {
+		t.Errorf("schedule = %q, want the original left in place", s.scheduler.Spec())
+	}
+	if saved, err := state.Schedule(); saved != "" || err != nil {
+		t.Errorf("saved schedule = %q, %v, want none", saved, err)
+	}
+}
+
+func TestSchedulerIgnoresBadSavedSchedule(t *testing.T) {
+	state := &db.StateFile{Path: filepath.Join(t.TempDir(), "state.json")}
+	if err := state.SaveSchedule("every half hour"); err != nil {
+		t.Fatal(err)
+	}
+	sched, err := newScheduler("0 * * * *", func() {}, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sched.Spec() != "0 * * * *" {
+		t.Errorf("schedule = %q, want the configured one", sched.Spec())
+	}
+	if _, err := newScheduler("every half hour", func() {}, nil); err == nil {
+		t.Error("newScheduler accepted an invalid configured schedule")
+	}
+}
+
+// runCycles runs n scans the way runScan does and returns them as F for a
+// full sweep and Q for a quick verify. The first run has no known
+// endpoints yet, so it is a full sweep whatever the count says.
+func runCycles(t *testing.T, interval int, state *db.StateFile, n int) string {
+	t.Helper()
+	var runs strings.Builder
+	known := false
+	for i := 0; i < n; i++ {
+		// A fresh cycle each run, as after a restart
+		cycles := fullScanCycle{interval: interval, state: state}
+		quick, cycle := cycles.quick()
+		if quick && known {
+			cycles.record(true, cycle)
+			runs.WriteString("Q")
+			continue
+		}
+		cycles.record(false, 0)
+		known = true
+		runs.WriteString("F")
+	}
+	return runs.String()
+}
+
+func TestFullScanCycleAlternates(t *testing.T) {
+	for interval, want := range map[int]string{
+		0: "FFFFFF",
+		1: "FFFFFF",
+		2: "FQFQFQ",
+		3: "FQQFQQ",
+		5: "FQQQQF",
+	} {
+		state := &db.StateFile{Path: filepath.Join(t.TempDir(), "state.json")}
+		if got := runCycles(t, interval, state, 6); got != want {
+			t.Errorf("full_scan_interval %d: runs %s, want %s", interval, got, want)
+		}
+		if _, err := os.Stat(state.Path + ".cycle"); interval <= 1 && err == nil {
+			t.Errorf("full_scan_interval %d wrote a cycle count", interval)
+		}
+	}
+}
+
+func TestFullScanCycleResumes(t *testing.T) {
+	state := &db.StateFile{Path: filepath.Join(t.TempDir(), "state.json")}
+	runCycles(t, 4, state, 2) // a sweep and one quick run
+	if cycle, err := state.Cycle(); err != nil || cycle != 1 {
+		t.Fatalf("Cycle() = %d, %v, want 1", cycle, err)
+	}
+
+	// The count carries on from the state file rather than restarting
+	cycles := fullScanCycle{interval: 4, state: state}
+	for i, want := range []bool{true, true, false} {
+		quick, cycle := cycles.quick()
+		if quick != want {
+			t.Fatalf("run %d: quick %v, want %v", i, quick, want)
+		}
+		cycles.record(quick, cycle)
+	}
+	if cycle, _ := state.Cycle(); cycle != 0 {
+		t.Errorf("Cycle() after the sweep = %d, want 0", cycle)
+	}
+}
+
+func TestFullScanCycleUnreadable(t *testing.T) {
+	state := &db.StateFile{Path: filepath.Join(t.TempDir(), "state.json")}
+	if err := os.WriteFile(state.Path+".cycle", []byte("soon\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if quick, _ := (fullScanCycle{interval: 3, state: state}).quick(); quick {
+		t.Error("an unreadable cycle count gave a quick run, want a full sweep")
+	}
+}
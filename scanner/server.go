@@ -0,0 +1,562 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"network-scanner/config"
+	"network-scanner/db"
+	"network-scanner/scanner"
+)
+
+// controlServer serves the scanner's HTTP control endpoints
+type controlServer struct {
+	cfg       *config.Config
+	apiClient *db.APIClient
+	runScan   func(tags map[string]string)
+	pauseGate *scanner.PauseGate
+	diag      *diagnostics
+	results   *lastResults
+
+	// Used by /fingerprint
+	fingerprinter scanner.HostFingerprinter
+	scope         *scanner.Scope
+
+	// Used by /metrics
+	probeStats *scanner.ProbeStats
+
+	// Used by /events
+	events *eventHub
+
+	// Used by /schedule
+	scheduler *scheduler
+
+	// Used by /trigger; nil allows scans at any time
+	window *config.TimeWindow
+
+	// Reported by /capabilities
+	scanMode        string
+	fingerprintMode string
+}
+
+// route describes one control endpoint. The same table registers handlers
+// and generates /openapi.json, so the published description cannot drift
+// from what the server actually serves.
+type route struct {
+	Method    string
+	Path      string
+	Summary   string
+	Auth      bool            // requires the control token when configured
+	Request   interface{}     // zero value of the JSON request body type, if any
+	Params    []routeParam    // documented query parameters
+	Responses []routeResponse // documented responses
+	handler   http.HandlerFunc
+}
+
+// routeParam documents one query parameter
+type routeParam struct {
+	Name        string
+	Description string
+	Type        string // OpenAPI schema type: "string" or "integer"
+}
+
+// routeResponse documents one status code a route can return
+type routeResponse struct {
+	Status      int
+	Description string
+	Body        interface{} // zero value of the JSON response body type
+	ContentType string      // defaults to application/json
+}
+
+// Response bodies
+
+type healthResponse struct {
+	Status string `json:"status"`
+}
+
+type readyResponse struct {
+	Ready       bool `json:"ready"`
+	ConfigValid bool `json:"config_valid"`
+	APIReady    bool `json:"api_ready"`
+}
+
+type actionResponse struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+type abortResponse struct {
+	Status  string   `json:"status"`
+	Message string   `json:"message"`
+	Aborted []string `json:"aborted"` // what was stopped, e.g. "running_scan"
+}
+
+type metricsResponse struct {
+	// Fingerprint outcomes per service name since the current or last scan
+	// started
+	Probes map[string]scanner.ProbeCounts `json:"probes"`
+}
+
+type statusResponse struct {
+	IsScanning   bool   `json:"is_scanning"`
+	IsPaused     bool   `json:"is_paused"`
+	LastScanTime string `json:"last_scan_time,omitempty"`
+
+	LastScanTiming *timingResponse `json:"last_scan_timing,omitempty"` // with scan_timing
+}
+
+// timingResponse is a scan's phase breakdown in seconds
+type timingResponse struct {
+	TotalSeconds float64            `json:"total_seconds"`
+	Phases       map[string]float64 `json:"phases"` // discovery, fingerprint, submit and other
+}
+
+// routeTable lists every control endpoint. Probe endpoints stay open so
+// orchestrators can reach them without credentials.
+func (s *controlServer) routeTable() []route {
+	ok := func(desc string, body interface{}) routeResponse {
+		return routeResponse{Status: http.StatusOK, Description: desc, Body: body}
+	}
+
+	return []route{
+		{
+			Method: http.MethodGet, Path: "/healthz",
+			Summary:   "Liveness probe; 200 while the process is up",
+			Responses: []routeResponse{ok("Process is up", healthResponse{})},
+			handler:   s.handleHealthz,
+		},
+		{
+			Method: http.MethodGet, Path: "/readyz",
+			Summary: "Readiness probe; 200 once config is valid and the API is reachable",
+			Responses: []routeResponse{
+				ok("Ready", readyResponse{}),
+				{Status: http.StatusServiceUnavailable, Description: "Not ready", Body: readyResponse{}},
+			},
+			handler: s.handleReadyz,
+		},
+		{
+			Method: http.MethodGet, Path: "/status",
+			Summary:   "Current scan state, last scan time and, with scan_timing, its phase breakdown",
+			Auth:      true,
+			Responses: []routeResponse{ok("Scan state", statusResponse{})},
+			handler:   s.handleStatus,
+		},
+		{
+			Method: http.MethodGet, Path: "/metrics",
+			Summary:   "Probe outcomes per service (attempts, banner, version, port-name fallback) for the current or last scan",
+			Auth:      true,
+			Responses: []routeResponse{ok("Probe counts by service name", metricsResponse{})},
+			handler:   s.handleMetrics,
+		},
+		{
+			Method: http.MethodGet, Path: "/events",
+			Summary: "Server-Sent Events stream of scan_started, port_completed, host_found and scan_completed as they happen",
+			Auth:    true,
+			Responses: []routeResponse{
+				{Status: http.StatusOK, Description: "Event stream; each event's data is one of the payloads", ContentType: "text/event-stream", Body: eventPayloads{}},
+				{Status: http.StatusServiceUnavailable, Description: "Too many clients connected", Body: actionResponse{}},
+			},
+			handler: s.handleEvents,
+		},
+		{
+			Method: http.MethodGet, Path: "/version",
+			Summary:   "Scanner build info and detected zmap/zgrab2/Go versions",
+			Auth:      true,
+			Responses: []routeResponse{ok("Version info", versionResponse{})},
+			handler:   s.handleVersion,
+		},
+		{
+			Method: http.MethodGet, Path: "/capabilities",
+			Summary:   "Services and ports the native and zgrab2 fingerprinters identify, and the active modes",
+			Auth:      true,
+			Responses: []routeResponse{ok("Fingerprinting capabilities", capabilitiesResponse{})},
+			handler:   s.handleCapabilities,
+		},
+		{
+			Method: http.MethodPost, Path: "/trigger",
+			Summary: "Start a scan immediately, optionally with tags overriding the configured ones",
+			Auth:    true,
+			Params: []routeParam{
+				{Name: "force", Description: "Scan even outside scan_window", Type: "boolean"},
+			},
+			Request: triggerRequest{},
+			Responses: []routeResponse{
+				ok("Scan started or already running", actionResponse{}),
+				{Status: http.StatusBadRequest, Description: "Invalid request body", Body: actionResponse{}},
+				{Status: http.StatusLocked, Description: "Outside scan_window and not forced", Body: actionResponse{}},
+			},
+			handler: s.handleTrigger,
+		},
+		{
+			Method: http.MethodPost, Path: "/fingerprint",
+			Summary: "Fingerprint ports of one IP now and return the results, without recording or submitting them",
+			Auth:    true,
+			Request: fingerprintRequest{},
+			Responses: []routeResponse{
+				ok("Service info per port", fingerprintResponse{}),
+				{Status: http.StatusBadRequest, Description: "Invalid IP or ports", Body: actionResponse{}},
+				{Status: http.StatusForbidden, Description: "IP is outside the scan scope", Body: actionResponse{}},
+			},
+			handler: s.handleFingerprint,
+		},
+		{
+			Method: http.MethodPost, Path: "/schedule",
+			Summary: "Replace the scan schedule without a restart; saved beside state_file when one is set",
+			Auth:    true,
+			Request: scheduleRequest{},
+			Responses: []routeResponse{
+				ok("New schedule and next run time", scheduleResponse{}),
+				{Status: http.StatusBadRequest, Description: "Invalid JSON or cron expression", Body: actionResponse{}},
+				{Status: http.StatusInternalServerError, Description: "Schedule changed but could not be saved", Body: actionResponse{}},
+			},
+			handler: s.handleSchedule,
+		},
+		{
+			Method: http.MethodPost, Path: "/pause",
+			Summary:   "Stop the running scan from starting new connections",
+			Auth:      true,
+			Responses: []routeResponse{ok("Scan paused", actionResponse{})},
+			handler:   s.handlePause,
+		},
+		{
+			Method: http.MethodPost, Path: "/resume",
+			Summary:   "Continue a paused scan",
+			Auth:      true,
+			Responses: []routeResponse{ok("Scan resumed", actionResponse{})},
+			handler:   s.handleResume,
+		},
+		{
+			Method: http.MethodPost, Path: "/scan/abort-all",
+			Summary:   "Cancel the running scan; results already submitted are kept",
+			Auth:      true,
+			Responses: []routeResponse{ok("What was aborted, if anything", abortResponse{})},
+			handler:   s.handleAbortAll,
+		},
+		{
+			Method: http.MethodGet, Path: "/diagnostics",
+			Summary:   "Check binaries, privileges, interface and API reachability",
+			Auth:      true,
+			Responses: []routeResponse{ok("Diagnostic report; ok is false if any check failed", diagnosticsReport{})},
+			handler:   s.handleDiagnostics,
+		},
+		{
+			Method: http.MethodGet, Path: "/results/last",
+			Summary: "Hosts and open ports from the last completed scan",
+			Auth:    true,
+			Params: []routeParam{
+				{Name: "service", Description: "Only ports with this service name", Type: "string"},
+				{Name: "port", Description: "Only this port number", Type: "integer"},
+				{Name: "limit", Description: "Hosts per page (default 100, max 1000)", Type: "integer"},
+				{Name: "offset", Description: "Hosts to skip", Type: "integer"},
+			},
+			Responses: []routeResponse{
+				ok("One page of matching hosts", resultsResponse{}),
+				{Status: http.StatusBadRequest, Description: "Invalid query parameter", Body: actionResponse{}},
+				{Status: http.StatusNotFound, Description: "No scan has completed yet", Body: actionResponse{}},
+			},
+			handler: s.handleLastResults,
+		},
+		{
+			Method: http.MethodGet, Path: "/violations",
+			Summary: "Open ports in the last completed scan that policy_file doesn't allow",
+			Auth:    true,
+			Responses: []routeResponse{
+				ok("Policy violations", violationsResponse{}),
+				{Status: http.StatusNotFound, Description: "No policy configured or no scan completed yet", Body: actionResponse{}},
+			},
+			handler: s.handleViolations,
+		},
+		{
+			Method: http.MethodGet, Path: "/export/stix",
+			Summary: "The last completed scan as a STIX 2.1 bundle of observed-data",
+			Auth:    true,
+			Responses: []routeResponse{
+				ok("STIX 2.1 bundle", db.STIXBundle{}),
+				{Status: http.StatusNotFound, Description: "No scan has completed yet", Body: actionResponse{}},
+			},
+			handler: s.handleExportSTIX,
+		},
+		{
+			Method: http.MethodGet, Path: "/openapi.json",
+			Summary:   "OpenAPI 3 description of the control API",
+			Auth:      true,
+			Responses: []routeResponse{ok("OpenAPI document", map[string]interface{}{})},
+			handler:   s.handleOpenAPI,
+		},
+	}
+}
+
+// routes registers the control endpoints from routeTable
+func (s *controlServer) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	for _, rt := range s.routeTable() {
+		handler := rt.handler
+		if rt.Auth {
+			handler = s.requireAuth(handler)
+		}
+		mux.HandleFunc(rt.Method+" "+rt.Path, handler)
+	}
+	return mux
+}
+
+// serve accepts connections on listener, using TLS when a certificate is configured
+func (s *controlServer) serve(listener net.Listener) error {
+	if s.cfg.ControlTLSCert != "" {
+		return http.ServeTLS(listener, s.routes(), s.cfg.ControlTLSCert, s.cfg.ControlTLSKey)
+	}
+	return http.Serve(listener, s.routes())
+}
+
+// requireAuth rejects requests without the configured control token. The token
+// may be sent as "Authorization: Bearer <token>" or as the basic-auth password.
+func (s *controlServer) requireAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := s.cfg.ControlAuthToken
+		if token == "" {
+			next(w, r)
+			return
+		}
+
+		var supplied string
+		if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+			supplied = strings.TrimPrefix(auth, "Bearer ")
+		} else if _, password, ok := r.BasicAuth(); ok {
+			supplied = password
+		}
+
+		if subtle.ConstantTimeCompare([]byte(supplied), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="scanner"`)
+			writeJSON(w, http.StatusUnauthorized, actionResponse{
+				Status:  "unauthorized",
+				Message: "Missing or invalid control token",
+			})
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// handleHealthz reports liveness: the process is up and serving
+func (s *controlServer) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, healthResponse{Status: "ok"})
+}
+
+// apiCheckInterval is how often checkAPI refreshes the health check /readyz
+// reports
+const apiCheckInterval = 10 * time.Second
+
+// checkAPI runs the API health check every interval until ctx is done, so
+// /readyz answers from the last result instead of waiting on the API
+func (s *controlServer) checkAPI(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		apiReady.Store(s.apiClient.HealthCheck() == nil)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// handleReadyz reports readiness: config is valid and the last API health
+// check passed, unless results go to S3 instead
+func (s *controlServer) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	resp := readyResponse{
+		ConfigValid: configValid.Load(),
+		APIReady:    apiReady.Load(),
+	}
+	resp.Ready = resp.ConfigValid && (resp.APIReady || s.cfg.S3Bucket != "")
+
+	status := http.StatusOK
+	if !resp.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, resp)
+}
+
+// triggerRequest is the optional body of POST /trigger
+type triggerRequest struct {
+	Tags map[string]string `json:"tags,omitempty"` // merged over the configured tags
+}
+
+// handleTrigger starts a scan in the background unless one is already
+// running or, without ?force=true, it is outside scan_window
+func (s *controlServer) handleTrigger(w http.ResponseWriter, r *http.Request) {
+	force := false
+	if raw := r.URL.Query().Get("force"); raw != "" {
+		var err error
+		if force, err = strconv.ParseBool(raw); err != nil {
+			writeJSON(w, http.StatusBadRequest, actionResponse{Status: "invalid_request", Message: "force must be true or false"})
+			return
+		}
+	}
+	if !force && !s.window.Contains(time.Now()) {
+		writeJSON(w, http.StatusLocked, actionResponse{
+			Status:  "outside_window",
+			Message: fmt.Sprintf("Outside scan_window %s; pass force=true to scan anyway", s.window),
+		})
+		return
+	}
+
+	var req triggerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeJSON(w, http.StatusBadRequest, actionResponse{Status: "invalid_request", Message: "invalid JSON body: " + err.Error()})
+		return
+	}
+	for key := range req.Tags {
+		if strings.TrimSpace(key) == "" {
+			writeJSON(w, http.StatusBadRequest, actionResponse{Status: "invalid_request", Message: "empty tag name"})
+			return
+		}
+	}
+
+	scanMutex.Lock()
+	scanning := isScanning
+	scanMutex.Unlock()
+
+	if scanning {
+		writeJSON(w, http.StatusOK, actionResponse{
+			Status:  "already_running",
+			Message: "Scan already in progress",
+		})
+		return
+	}
+
+	// Start scan in background
+	go s.runScan(req.Tags)
+
+	writeJSON(w, http.StatusOK, actionResponse{
+		Status:  "started",
+		Message: "Scan started",
+	})
+}
+
+// handlePause stops the running scan from starting new connections.
+// In-flight probes finish and scan state is kept until /resume.
+func (s *controlServer) handlePause(w http.ResponseWriter, r *http.Request) {
+	if !s.pauseGate.Pause() {
+		writeJSON(w, http.StatusOK, actionResponse{
+			Status:  "already_paused",
+			Message: "Scan already paused",
+		})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, actionResponse{
+		Status:  "paused",
+		Message: "Scan paused",
+	})
+}
+
+// handleResume lets a paused scan continue where it left off
+func (s *controlServer) handleResume(w http.ResponseWriter, r *http.Request) {
+	if !s.pauseGate.Resume() {
+		writeJSON(w, http.StatusOK, actionResponse{
+			Status:  "not_paused",
+			Message: "Scan is not paused",
+		})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, actionResponse{
+		Status:  "resumed",
+		Message: "Scan resumed",
+	})
+}
+
+// handleAbortAll cancels the running scan's context. It holds scanMutex
+// while cancelling, so a scan that has just finished is never reported as
+// aborted and one that is starting is either cancelled or not yet begun.
+// There is no scan queue to drain: a scan triggered while another runs is
+// refused rather than queued.
+func (s *controlServer) handleAbortAll(w http.ResponseWriter, r *http.Request) {
+	scanMutex.Lock()
+	cancel := cancelScan
+	if cancel != nil {
+		cancel()
+	}
+	scanMutex.Unlock()
+
+	if cancel == nil {
+		writeJSON(w, http.StatusOK, abortResponse{
+			Status:  "not_running",
+			Message: "No scan in progress",
+			Aborted: []string{},
+		})
+		return
+	}
+
+	log.Println("Scan aborted via /scan/abort-all")
+	writeJSON(w, http.StatusOK, abortResponse{
+		Status:  "aborted",
+		Message: "Running scan cancelled",
+		Aborted: []string{"running_scan"},
+	})
+}
+
+// handleMetrics reports probe outcome counts. They reset when a scan starts
+// and also count /fingerprint requests made since.
+func (s *controlServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	probes := s.probeStats.Snapshot()
+	if probes == nil {
+		probes = map[string]scanner.ProbeCounts{}
+	}
+	writeJSON(w, http.StatusOK, metricsResponse{Probes: probes})
+}
+
+// handleStatus reports whether a scan is running and when the last one finished
+func (s *controlServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	scanMutex.Lock()
+	scanning := isScanning
+	lastScan := lastScanTime
+	timing := lastScanTiming
+	scanMutex.Unlock()
+
+	resp := statusResponse{
+		IsScanning: scanning,
+		IsPaused:   s.pauseGate.Paused(),
+	}
+	if !lastScan.IsZero() {
+		resp.LastScanTime = lastScan.Format(time.RFC3339)
+	}
+	if timing != nil {
+		resp.LastScanTiming = &timingResponse{
+			TotalSeconds: timing.Total.Seconds(),
+			Phases:       make(map[string]float64, len(timing.Phases)),
+		}
+		for phase, d := range timing.Phases {
+			resp.LastScanTiming.Phases[phase] = d.Seconds()
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleDiagnostics runs the startup diagnostics again and reports the results
+func (s *controlServer) handleDiagnostics(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.diag.run())
+}
+
+// handleOpenAPI serves the OpenAPI description generated from routeTable
+func (s *controlServer) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, openAPISpec(s.routeTable()))
+}
+
+// writeJSON encodes v as the JSON response body with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,527 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"network-scanner/config"
+	"network-scanner/db"
+	"network-scanner/engine"
+	"network-scanner/scanner"
+)
+
+// stubAPI serves /api/health with the status healthy reports
+func stubAPI(t *testing.T, healthy *bool) *httptest.Server {
+	t.Helper()
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/health" && *healthy {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(api.Close)
+	return api
+}
+
+// newTestServer returns a control server for cfg submitting to apiURL,
+// with readiness state reset
+func newTestServer(t *testing.T, cfg *config.Config, apiURL string) *controlServer {
+	t.Helper()
+	configValid.Store(false)
+	apiReady.Store(false)
+	t.Cleanup(func() {
+		configValid.Store(false)
+		apiReady.Store(false)
+	})
+	return &controlServer{
+		cfg:       cfg,
+		apiClient: db.NewAPIClient(apiURL),
+		runScan:   func(map[string]string) {},
+		pauseGate: scanner.NewPauseGate(),
+	}
+}
+
+// httpGet requests path from handler
+func httpGet(t *testing.T, handler http.Handler, path string) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	return rec
+}
+
+// get requests path from handler and decodes the JSON body into v
+func get(t *testing.T, handler http.Handler, path string, v interface{}) int {
+	t.Helper()
+	rec := httpGet(t, handler, path)
+	if v != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatalf("GET %s: decoding %q: %v", path, rec.Body.String(), err)
+		}
+	}
+	return rec.Code
+}
+
+func TestHealthzAlwaysOK(t *testing.T) {
+	healthy := false
+	s := newTestServer(t, &config.Config{}, stubAPI(t, &healthy).URL)
+
+	var resp healthResponse
+	if code := get(t, s.routes(), "/healthz", &resp); code != http.StatusOK || resp.Status != "ok" {
+		t.Fatalf("GET /healthz = %d %+v, want 200 ok while not ready", code, resp)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	tests := []struct {
+		name        string
+		configValid bool
+		apiHealthy  bool
+		s3Bucket    string
+		wantStatus  int
+		want        readyResponse
+	}{
+		{"ready", true, true, "", http.StatusOK, readyResponse{Ready: true, ConfigValid: true, APIReady: true}},
+		{"api down", true, false, "", http.StatusServiceUnavailable, readyResponse{ConfigValid: true}},
+		{"config invalid", false, true, "", http.StatusServiceUnavailable, readyResponse{APIReady: true}},
+		{"api down with s3 sink", true, false, "results", http.StatusOK, readyResponse{Ready: true, ConfigValid: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, &config.Config{S3Bucket: tt.s3Bucket}, "http://127.0.0.1:1")
+			configValid.Store(tt.configValid)
+			apiReady.Store(tt.apiHealthy)
+
+			var resp readyResponse
+			if code := get(t, s.routes(), "/readyz", &resp); code != tt.wantStatus || resp != tt.want {
+				t.Errorf("GET /readyz = %d %+v, want %d %+v", code, resp, tt.wantStatus, tt.want)
+			}
+		})
+	}
+}
+
+// runCheckAPI runs s.checkAPI every interval in the background and returns
+// the func that stops it and waits for it to return
+func runCheckAPI(t *testing.T, s *controlServer, interval time.Duration) func() {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.checkAPI(ctx, interval)
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+func TestReadyzFollowsAPIHealth(t *testing.T) {
+	var healthy atomic.Bool
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer api.Close()
+	s := newTestServer(t, &config.Config{}, api.URL)
+	configValid.Store(true)
+	stopChecks := runCheckAPI(t, s, 10*time.Millisecond)
+	defer stopChecks()
+
+	// waitReady polls /readyz until it answers want
+	waitReady := func(want int) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for get(t, s.routes(), "/readyz", nil) != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("GET /readyz never answered %d", want)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	waitReady(http.StatusServiceUnavailable)
+	healthy.Store(true)
+	waitReady(http.StatusOK)
+	healthy.Store(false)
+	waitReady(http.StatusServiceUnavailable)
+}
+
+func TestReadyzDoesNotWaitOnAPI(t *testing.T) {
+	release := make(chan struct{})
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer api.Close()
+	s := newTestServer(t, &config.Config{}, api.URL)
+	configValid.Store(true)
+	apiReady.Store(true)
+	stopChecks := runCheckAPI(t, s, time.Hour)
+	defer stopChecks()
+	defer close(release)
+
+	// The health check hangs; /readyz reports the last result regardless
+	start := time.Now()
+	if code := get(t, s.routes(), "/readyz", nil); code != http.StatusOK {
+		t.Errorf("GET /readyz = %d, want 200 from the last check", code)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("GET /readyz took %s while the API hung", elapsed)
+	}
+}
+
+// serveTest serves s on an ephemeral loopback port and returns its base URL
+func serveTest(t *testing.T, s *controlServer) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go s.serve(listener)
+	scheme := "http"
+	if s.cfg.ControlTLSCert != "" {
+		scheme = "https"
+	}
+	return scheme + "://" + listener.Addr().String()
+}
+
+func TestServeOnEphemeralPort(t *testing.T) {
+	healthy := true
+	s := newTestServer(t, &config.Config{ListenAddr: "127.0.0.1:0"}, stubAPI(t, &healthy).URL)
+	if err := config.ValidateListenAddr(s.cfg.ListenAddr); err != nil {
+		t.Fatal(err)
+	}
+	base := serveTest(t, s)
+	if !strings.HasPrefix(base, "http://127.0.0.1:") || strings.HasSuffix(base, ":0") {
+		t.Fatalf("server bound to %s, want an ephemeral loopback port", base)
+	}
+
+	resp, err := http.Get(base + "/healthz")
+	if err != nil {
+		t.Fatalf("GET /healthz on %s: %v", base, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /healthz = %d, want 200", resp.StatusCode)
+	}
+}
+
+// writeTestCert writes a self-signed certificate for 127.0.0.1 and its key
+// to dir and returns their paths and a pool trusting the certificate
+func writeTestCert(t *testing.T, dir string) (certFile, keyFile string, pool *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "scanner-test"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool = x509.NewCertPool()
+	pool.AddCert(cert)
+	return certFile, keyFile, pool
+}
+
+func TestRequireAuth(t *testing.T) {
+	healthy := true
+	s := newTestServer(t, &config.Config{ControlAuthToken: "secret"}, stubAPI(t, &healthy).URL)
+	configValid.Store(true)
+	apiReady.Store(true)
+	handler := s.routes()
+
+	basic := func(r *http.Request) { r.SetBasicAuth("scanner", "secret") }
+	tests := []struct {
+		name   string
+		path   string
+		auth   func(r *http.Request)
+		status int
+	}{
+		{"status without token", "/status", nil, http.StatusUnauthorized},
+		{"status with wrong token", "/status", func(r *http.Request) { r.Header.Set("Authorization", "Bearer wrong") }, http.StatusUnauthorized},
+		{"status with bearer token", "/status", func(r *http.Request) { r.Header.Set("Authorization", "Bearer secret") }, http.StatusOK},
+		{"status with basic auth", "/status", basic, http.StatusOK},
+		{"metrics without token", "/metrics", nil, http.StatusUnauthorized},
+		{"version without token", "/version", nil, http.StatusUnauthorized},
+		{"capabilities without token", "/capabilities", nil, http.StatusUnauthorized},
+		{"openapi without token", "/openapi.json", nil, http.StatusUnauthorized},
+		{"healthz without token", "/healthz", nil, http.StatusOK},
+		{"readyz without token", "/readyz", nil, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.auth != nil {
+				tt.auth(req)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Fatalf("GET %s = %d, want %d", tt.path, rec.Code, tt.status)
+			}
+			if rec.Code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Errorf("401 without a WWW-Authenticate challenge")
+			}
+		})
+	}
+}
+
+func TestRequireAuthWithoutToken(t *testing.T) {
+	healthy := true
+	s := newTestServer(t, &config.Config{}, stubAPI(t, &healthy).URL)
+	if code := get(t, s.routes(), "/status", nil); code != http.StatusOK {
+		t.Fatalf("GET /status without control_auth_token set = %d, want 200", code)
+	}
+}
+
+func TestStatusScanTiming(t *testing.T) {
+	healthy := true
+	s := newTestServer(t, &config.Config{}, stubAPI(t, &healthy).URL)
+	handler := s.routes()
+
+	var resp statusResponse
+	if get(t, handler, "/status", &resp); resp.LastScanTiming != nil {
+		t.Fatalf("last_scan_timing = %+v before any timed scan", resp.LastScanTiming)
+	}
+
+	scanMutex.Lock()
+	lastScanTiming = &engine.Timing{Total: 90 * time.Second, Phases: map[string]time.Duration{
+		engine.PhaseDiscovery:   60 * time.Second,
+		engine.PhaseFingerprint: 25 * time.Second,
+		engine.PhaseSubmit:      4500 * time.Millisecond,
+		engine.PhaseOther:       500 * time.Millisecond,
+	}}
+	scanMutex.Unlock()
+	t.Cleanup(func() {
+		scanMutex.Lock()
+		lastScanTiming = nil
+		scanMutex.Unlock()
+	})
+
+	if code := get(t, handler, "/status", &resp); code != http.StatusOK {
+		t.Fatalf("GET /status = %d", code)
+	}
+	want := &timingResponse{TotalSeconds: 90, Phases: map[string]float64{"discovery": 60, "fingerprint": 25, "submit": 4.5, "other": 0.5}}
+	if !reflect.DeepEqual(resp.LastScanTiming, want) {
+		t.Errorf("last_scan_timing = %+v, want %+v", resp.LastScanTiming, want)
+	}
+}
+
+// windowAround is a UTC scan window from now+from to now+to
+func windowAround(t *testing.T, from, to time.Duration) *config.TimeWindow {
+	t.Helper()
+	now := time.Now().UTC()
+	window, err := config.ParseTimeWindow(now.Add(from).Format("15:04")+"-"+now.Add(to).Format("15:04"), "UTC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return window
+}
+
+func TestTriggerScanWindow(t *testing.T) {
+	tests := []struct {
+		name   string
+		window *config.TimeWindow
+		query  string
+		code   int
+		status string
+	}{
+		{"inside", windowAround(t, -time.Hour, time.Hour), "", http.StatusOK, "started"},
+		{"no window", nil, "", http.StatusOK, "started"},
+		{"outside", windowAround(t, time.Hour, 2*time.Hour), "", http.StatusLocked, "outside_window"},
+		{"outside, not forced", windowAround(t, time.Hour, 2*time.Hour), "?force=false", http.StatusLocked, "outside_window"},
+		{"outside, forced", windowAround(t, time.Hour, 2*time.Hour), "?force=true", http.StatusOK, "started"},
+		{"bad force", windowAround(t, time.Hour, 2*time.Hour), "?force=please", http.StatusBadRequest, "invalid_request"},
+	}
+	for _, tt := range tests {
+		healthy := true
+		s := newTestServer(t, &config.Config{}, stubAPI(t, &healthy).URL)
+		started := make(chan struct{}, 1)
+		s.runScan = func(map[string]string) { started <- struct{}{} }
+		s.window = tt.window
+
+		rec := httptest.NewRecorder()
+		s.routes().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/trigger"+tt.query, nil))
+		var resp actionResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s: decoding %q: %v", tt.name, rec.Body.String(), err)
+		}
+		if rec.Code != tt.code || resp.Status != tt.status {
+			t.Errorf("%s: POST /trigger%s = %d %+v, want %d %s", tt.name, tt.query, rec.Code, resp, tt.code, tt.status)
+		}
+
+		select {
+		case <-started:
+			if tt.status != "started" {
+				t.Errorf("%s: scan started", tt.name)
+			}
+		case <-time.After(200 * time.Millisecond):
+			if tt.status == "started" {
+				t.Errorf("%s: scan never started", tt.name)
+			}
+		}
+	}
+}
+
+func TestServeTLS(t *testing.T) {
+	certFile, keyFile, pool := writeTestCert(t, t.TempDir())
+	healthy := true
+	s := newTestServer(t, &config.Config{
+		ControlTLSCert:   certFile,
+		ControlTLSKey:    keyFile,
+		ControlAuthToken: "secret",
+	}, stubAPI(t, &healthy).URL)
+	base := serveTest(t, s)
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	req, _ := http.NewRequest(http.MethodGet, base+"/status", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("GET /status over TLS: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.TLS == nil {
+		t.Fatalf("GET /status over TLS = %d (tls %v), want 200 over TLS", resp.StatusCode, resp.TLS != nil)
+	}
+
+	// Plain HTTP to the TLS listener is refused
+	plain := "http" + strings.TrimPrefix(base, "https")
+	if resp, err := http.Get(plain + "/healthz"); err == nil {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			t.Fatalf("plain HTTP to the TLS listener succeeded")
+		}
+	}
+}
+
+// post sends an empty POST to path and decodes the JSON body into v
+func post(t *testing.T, handler http.Handler, path string, v interface{}) int {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, nil))
+	if v != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatalf("POST %s: decoding %q: %v", path, rec.Body.String(), err)
+		}
+	}
+	return rec.Code
+}
+
+func TestPauseResume(t *testing.T) {
+	healthy := true
+	s := newTestServer(t, &config.Config{}, stubAPI(t, &healthy).URL)
+	handler := s.routes()
+
+	steps := []struct {
+		path       string
+		wantStatus string
+		wantPaused bool
+	}{
+		{"/resume", "not_paused", false},
+		{"/pause", "paused", true},
+		{"/pause", "already_paused", true},
+		{"/resume", "resumed", false},
+	}
+	for _, step := range steps {
+		var resp actionResponse
+		if code := post(t, handler, step.path, &resp); code != http.StatusOK || resp.Status != step.wantStatus {
+			t.Fatalf("POST %s = %d %q, want 200 %q", step.path, code, resp.Status, step.wantStatus)
+		}
+		var status statusResponse
+		get(t, handler, "/status", &status)
+		if status.IsPaused != step.wantPaused {
+			t.Fatalf("after POST %s: is_paused = %v, want %v", step.path, status.IsPaused, step.wantPaused)
+		}
+	}
+}
+
+func TestMetricsProbeCounts(t *testing.T) {
+	healthy := true
+	s := newTestServer(t, &config.Config{}, stubAPI(t, &healthy).URL)
+	var resp metricsResponse
+	if code := get(t, s.routes(), "/metrics", &resp); code != http.StatusOK || resp.Probes == nil || len(resp.Probes) != 0 {
+		t.Fatalf("GET /metrics without stats = %d %+v, want no probes", code, resp)
+	}
+
+	// One SSH banner and one closed port
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte("SSH-2.0-OpenSSH_9.6\r\n"))
+			conn.Close()
+		}
+	}()
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+
+	s.probeStats = scanner.NewProbeStats()
+	f := scanner.NewFingerprinter()
+	f.Timeout = 300 * time.Millisecond
+	f.ProbeStats = s.probeStats
+	f.FingerprintHost(context.Background(), "127.0.0.1", []int{listener.Addr().(*net.TCPAddr).Port, closed.Addr().(*net.TCPAddr).Port})
+
+	if code := get(t, s.routes(), "/metrics", &resp); code != http.StatusOK {
+		t.Fatalf("GET /metrics = %d", code)
+	}
+	want := map[string]scanner.ProbeCounts{
+		"ssh":     {Attempts: 1, Banner: 1},
+		"unknown": {Attempts: 1, Fallback: 1},
+	}
+	if !reflect.DeepEqual(resp.Probes, want) {
+		t.Errorf("probes = %+v, want %+v", resp.Probes, want)
+	}
+}
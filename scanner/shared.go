@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"network-scanner/db"
+)
+
+// Bounds on the shared identities listed in the scan summary log
+const (
+	maxLoggedShared      = 20 // clusters
+	maxLoggedSharedHosts = 10 // hosts per cluster
+)
+
+// sharedIdentity is a TLS certificate or SSH host key presented by more
+// than one host, a sign of shared infrastructure or cloned images
+type sharedIdentity struct {
+	Kind   string   `json:"kind"` // "tls_certificate" or "ssh_host_key"
+	SHA256 string   `json:"sha256"`
+	Label  string   `json:"label,omitempty"` // certificate subject CN or host key algorithm
+	Hosts  []string `json:"hosts"`           // sorted
+}
+
+// kindLabel names the identity for the log and report
+func (s sharedIdentity) kindLabel() string {
+	if s.Kind == "ssh_host_key" {
+		return "SSH host key"
+	}
+	return "TLS certificate"
+}
+
+// findSharedIdentities groups spool's open ports by certificate and host
+// key SHA256 and returns the groups spanning more than one host, largest
+// first
+func findSharedIdentities(spool *db.Spool) ([]sharedIdentity, error) {
+	type identityKey struct{ kind, sha256 string }
+	groups := make(map[identityKey]*sharedIdentity)
+	seen := make(map[identityKey]map[string]bool)
+	add := func(kind, sha256, label, ip string) {
+		key := identityKey{kind, sha256}
+		group, ok := groups[key]
+		if !ok {
+			group = &sharedIdentity{Kind: kind, SHA256: sha256, Label: label}
+			groups[key] = group
+			seen[key] = make(map[string]bool)
+		}
+		if !seen[key][ip] {
+			seen[key][ip] = true
+			group.Hosts = append(group.Hosts, ip)
+		}
+	}
+
+	err := spool.Each(func(host db.ScanResultHost) error {
+		for _, port := range host.Ports {
+			if port.State != "open" {
+				continue
+			}
+			if cert := tlsCertificate(port.FingerprintData); cert != nil {
+				if sha256, _ := cert["sha256"].(string); sha256 != "" {
+					label, _ := cert["subject_cn"].(string)
+					add("tls_certificate", sha256, label, host.IPAddress)
+				}
+			}
+			if hostKey, ok := port.FingerprintData["host_key"].(map[string]interface{}); ok {
+				if sha256, _ := hostKey["sha256"].(string); sha256 != "" {
+					label, _ := hostKey["algorithm"].(string)
+					add("ssh_host_key", sha256, label, host.IPAddress)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	shared := []sharedIdentity{}
+	for _, group := range groups {
+		if len(group.Hosts) > 1 {
+			sort.Strings(group.Hosts)
+			shared = append(shared, *group)
+		}
+	}
+	sort.Slice(shared, func(i, j int) bool {
+		if len(shared[i].Hosts) != len(shared[j].Hosts) {
+			return len(shared[i].Hosts) > len(shared[j].Hosts)
+		}
+		if shared[i].Kind != shared[j].Kind {
+			return shared[i].Kind < shared[j].Kind
+		}
+		return shared[i].SHA256 < shared[j].SHA256
+	})
+	return shared, nil
+}
+
+// logSharedIdentities adds the shared certificates and host keys to the
+// scan summary
+func logSharedIdentities(shared []sharedIdentity) {
+	if len(shared) == 0 {
+		return
+	}
+	log.Printf("Shared identities: %d certificates or host keys are presented by more than one host", len(shared))
+	for i, s := range shared {
+		if i == maxLoggedShared {
+			log.Printf("  ... and %d more", len(shared)-i)
+			break
+		}
+		hosts := s.Hosts
+		more := ""
+		if len(hosts) > maxLoggedSharedHosts {
+			more = fmt.Sprintf(" and %d more", len(hosts)-maxLoggedSharedHosts)
+			hosts = hosts[:maxLoggedSharedHosts]
+		}
+		label := ""
+		if s.Label != "" {
+			label = " (" + s.Label + ")"
+		}
+		log.Printf("  %s %s%s on %d hosts: %s%s", s.kindLabel(), s.SHA256, label, len(s.Hosts), strings.Join(hosts, ", "), more)
+	}
+}
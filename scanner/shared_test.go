@@ -0,0 +1,143 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"network-scanner/db"
+)
+
+// Digests shared by the sharedHosts
+const (
+	wildcardCert = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	clonedKey    = "60303ae22b998861bce3b28f33eec1be758a213c86c93c076dbe9f558c11c752"
+)
+
+// tlsPort is an open port presenting the certificate with digest sha256
+func tlsPort(port int, sha256, cn string) db.ScanResultPort {
+	return db.ScanResultPort{PortNumber: port, Protocol: "tcp", State: "open", ServiceName: "https", FingerprintData: map[string]interface{}{
+		"tls": map[string]interface{}{"certificate": map[string]interface{}{"sha256": sha256, "subject_cn": cn}},
+	}}
+}
+
+// sshPort is an open SSH port presenting the host key with digest sha256
+func sshPort(sha256 string) db.ScanResultPort {
+	return db.ScanResultPort{PortNumber: 22, Protocol: "tcp", State: "open", ServiceName: "ssh", FingerprintData: map[string]interface{}{
+		"host_key": map[string]interface{}{"algorithm": "ssh-ed25519", "sha256": sha256},
+	}}
+}
+
+// sharedHosts has three web servers sharing a wildcard certificate, one of
+// them on two ports, and two hosts cloned from one image sharing an SSH
+// host key. The rest present identities of their own, or share one only
+// on a closed port.
+func sharedHosts() []db.ScanResultHost {
+	closed := tlsPort(8443, "c1o5ed", "old.example.com")
+	closed.State = "closed"
+	return []db.ScanResultHost{
+		{IPAddress: "10.0.0.3", Ports: []db.ScanResultPort{tlsPort(443, wildcardCert, "*.example.com"), tlsPort(8443, wildcardCert, "*.example.com")}},
+		{IPAddress: "10.0.0.1", Ports: []db.ScanResultPort{sshPort(clonedKey), tlsPort(443, wildcardCert, "*.example.com")}},
+		{IPAddress: "10.0.0.2", Ports: []db.ScanResultPort{sshPort(clonedKey), tlsPort(443, wildcardCert, "*.example.com"), closed}},
+		{IPAddress: "10.0.0.4", Ports: []db.ScanResultPort{sshPort("0dd"), tlsPort(443, "5e1f", "self-signed"), closed}},
+		{IPAddress: "10.0.0.5", Ports: []db.ScanResultPort{
+			{PortNumber: 80, Protocol: "tcp", State: "open", ServiceName: "http"},
+			// Empty digests identify nothing
+			tlsPort(443, "", "no digest"),
+			sshPort(""),
+		}},
+		{IPAddress: "10.0.0.6", Ports: []db.ScanResultPort{tlsPort(443, "", "no digest"), sshPort(""), closed}},
+	}
+}
+
+// wantShared is what findSharedIdentities finds in sharedHosts, the
+// largest cluster first
+var wantShared = []sharedIdentity{
+	{Kind: "tls_certificate", SHA256: wildcardCert, Label: "*.example.com", Hosts: []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}},
+	{Kind: "ssh_host_key", SHA256: clonedKey, Label: "ssh-ed25519", Hosts: []string{"10.0.0.1", "10.0.0.2"}},
+}
+
+// sharedSpool spools hosts, spilling to disk after spoolLimit
+func sharedSpool(t *testing.T, hosts []db.ScanResultHost, spoolLimit int) *db.Spool {
+	t.Helper()
+	spool := db.NewSpool(t.TempDir(), spoolLimit)
+	t.Cleanup(func() { spool.Close() })
+	for _, host := range hosts {
+		if err := spool.Add(host); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return spool
+}
+
+func TestFindSharedIdentities(t *testing.T) {
+	for _, spoolLimit := range []int{100, 2} {
+		shared, err := findSharedIdentities(sharedSpool(t, sharedHosts(), spoolLimit))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(shared, wantShared) {
+			t.Errorf("spool limit %d: shared = %+v, want %+v", spoolLimit, shared, wantShared)
+		}
+	}
+}
+
+func TestFindSharedIdentitiesOrder(t *testing.T) {
+	// Clusters of the same size order by kind, then digest
+	hosts := []db.ScanResultHost{
+		{IPAddress: "10.0.1.1", Ports: []db.ScanResultPort{sshPort("bb"), tlsPort(443, "dd", ""), tlsPort(8443, "cc", "")}},
+		{IPAddress: "10.0.1.2", Ports: []db.ScanResultPort{sshPort("bb"), tlsPort(443, "dd", ""), tlsPort(8443, "cc", "")}},
+		{IPAddress: "10.0.1.3", Ports: []db.ScanResultPort{sshPort("aa")}},
+		{IPAddress: "10.0.1.4", Ports: []db.ScanResultPort{sshPort("aa")}},
+	}
+	shared, err := findSharedIdentities(sharedSpool(t, hosts, 100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, s := range shared {
+		got = append(got, s.Kind+" "+s.SHA256)
+	}
+	if want := "ssh_host_key aa,ssh_host_key bb,tls_certificate cc,tls_certificate dd"; strings.Join(got, ",") != want {
+		t.Errorf("order = %v, want %s", got, want)
+	}
+}
+
+func TestFindSharedIdentitiesNone(t *testing.T) {
+	shared, err := findSharedIdentities(sharedSpool(t, sharedHosts()[3:], 100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Empty rather than nil, so it encodes as []
+	if shared == nil || len(shared) != 0 {
+		t.Errorf("shared = %#v, want none", shared)
+	}
+}
+
+func TestLastResultsSharedIdentities(t *testing.T) {
+	results := &lastResults{}
+	if shared := results.sharedIdentities(); shared != nil {
+		t.Errorf("shared before any scan = %v", shared)
+	}
+	results.set(sharedSpool(t, sharedHosts(), 100), reportScanTime)
+	if shared := results.sharedIdentities(); !reflect.DeepEqual(shared, wantShared) {
+		t.Errorf("shared = %+v, want %+v", shared, wantShared)
+	}
+
+	// The next scan replaces them
+	results.set(sharedSpool(t, sharedHosts()[3:], 100), reportScanTime)
+	if shared := results.sharedIdentities(); len(shared) != 0 {
+		t.Errorf("shared after a scan with none = %v", shared)
+	}
+}
+
+func TestReportSharedIdentities(t *testing.T) {
+	shared, err := findSharedIdentities(sharedSpool(t, sharedHosts(), 100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := scanReport{ScanTime: reportScanTime, Current: snapshotOf(sharedHosts()...), Shared: shared}
+	checkLines(t, "shared identities", section(t, r.Markdown(), "Shared certificates and host keys"),
+		"| TLS certificate | `"+wildcardCert+"` | *.example.com | 3: 10.0.0.1, 10.0.0.2, 10.0.0.3 |\n",
+		"| SSH host key | `"+clonedKey+"` | ssh-ed25519 | 2: 10.0.0.1, 10.0.0.2 |\n")
+}
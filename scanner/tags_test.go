@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"network-scanner/config"
+	"network-scanner/db"
+)
+
+func TestMergeTags(t *testing.T) {
+	defaults := map[string]string{"team": "netsec", "env": "prod"}
+	tests := []struct {
+		defaults, overrides, want map[string]string
+	}{
+		{defaults, nil, map[string]string{"team": "netsec", "env": "prod"}},
+		{defaults, map[string]string{"env": "staging"}, map[string]string{"team": "netsec", "env": "staging"}},
+		{defaults, map[string]string{"ticket": "SEC-1"}, map[string]string{"team": "netsec", "env": "prod", "ticket": "SEC-1"}},
+		{nil, map[string]string{"env": "dev"}, map[string]string{"env": "dev"}},
+		{nil, nil, nil},
+	}
+	for _, tt := range tests {
+		if got := mergeTags(tt.defaults, tt.overrides); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("mergeTags(%v, %v) = %v, want %v", tt.defaults, tt.overrides, got, tt.want)
+		}
+	}
+	if defaults["env"] != "prod" {
+		t.Error("mergeTags modified the defaults")
+	}
+}
+
+// postTrigger sends body to POST /trigger and returns the response
+func postTrigger(t *testing.T, handler http.Handler, body string) (int, actionResponse) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/trigger", strings.NewReader(body)))
+	var resp actionResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("POST /trigger: decoding %q: %v", rec.Body.String(), err)
+	}
+	return rec.Code, resp
+}
+
+func TestTriggerTags(t *testing.T) {
+	healthy := true
+	s := newTestServer(t, &config.Config{}, stubAPI(t, &healthy).URL)
+	started := make(chan map[string]string, 1)
+	s.runScan = func(tags map[string]string) { started <- tags }
+	handler := s.routes()
+
+	for body, want := range map[string]map[string]string{
+		"":   nil,
+		`{}`: nil,
+		`{"tags": {"env": "staging", "ticket": "SEC-1"}}`: {"env": "staging", "ticket": "SEC-1"},
+	} {
+		code, resp := postTrigger(t, handler, body)
+		if code != http.StatusOK || resp.Status != "started" {
+			t.Fatalf("POST /trigger %q = %d %+v, want started", body, code, resp)
+		}
+		select {
+		case got := <-started:
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("POST /trigger %q started a scan with tags %v, want %v", body, got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("POST /trigger %q started no scan", body)
+		}
+	}
+
+	for _, body := range []string{`{"tags": {" ": "x"}}`, `{"tags": ["env"]}`, `not json`} {
+		if code, resp := postTrigger(t, handler, body); code != http.StatusBadRequest || resp.Status != "invalid_request" {
+			t.Errorf("POST /trigger %q = %d %+v, want 400 invalid_request", body, code, resp)
+		}
+	}
+	select {
+	case tags := <-started:
+		t.Errorf("an invalid request started a scan with tags %v", tags)
+	default:
+	}
+}
+
+func TestTagsInSubmittedPayload(t *testing.T) {
+	received := make(chan []byte, 1)
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- body
+	}))
+	defer api.Close()
+
+	// Per-request tags override the configured ones key by key
+	cfg := &config.Config{Tags: map[string]string{"team": "netsec", "env": "prod"}}
+	results := &db.ScanResults{
+		Tags:  mergeTags(cfg.Tags, map[string]string{"env": "staging"}),
+		Hosts: []db.ScanResultHost{{IPAddress: "10.0.0.1", Ports: []db.ScanResultPort{{PortNumber: 22, Protocol: "tcp", State: "open"}}}},
+	}
+	if err := db.NewAPIClient(api.URL).SubmitResults(results); err != nil {
+		t.Fatal(err)
+	}
+
+	var payload map[string]interface{}
+	if err := json.Unmarshal(<-received, &payload); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"team": "netsec", "env": "staging"}
+	if !reflect.DeepEqual(payload["tags"], want) {
+		t.Errorf("payload tags = %v, want %v", payload["tags"], want)
+	}
+
+	// Without tags the field is left out
+	if data, _ := json.Marshal(db.ScanResults{}); strings.Contains(string(data), "tags") {
+		t.Errorf("untagged payload %s mentions tags", data)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"network-scanner/config"
+)
+
+// withStdin points os.Stdin at a file holding input for the test
+func withStdin(t *testing.T, input string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "stdin")
+	if err := os.WriteFile(path, []byte(input), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := os.Stdin
+	os.Stdin = f
+	t.Cleanup(func() {
+		os.Stdin = saved
+		f.Close()
+	})
+}
+
+func TestReadTargetsFromStdin(t *testing.T) {
+	withStdin(t, "10.0.0.0/24\n\n# lab\n192.168.1.77\nnot a target!\n2001:db8::/64\n10.0.0.0/24\n")
+	networks, err := readTargets("-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []config.Network{{CIDR: "10.0.0.0/24"}, {CIDR: "192.168.1.77/32"}, {CIDR: "2001:db8::/64"}}
+	if !reflect.DeepEqual(networks, want) {
+		t.Errorf("networks = %v, want %v", networks, want)
+	}
+}
+
+func TestReadTargetsEmptyStdin(t *testing.T) {
+	withStdin(t, "")
+	if networks, err := readTargets("-"); err != nil || len(networks) != 0 {
+		t.Errorf("readTargets(empty stdin) = %v, %v, want nothing", networks, err)
+	}
+}
+
+func TestReadTargetsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "targets.txt")
+	if err := os.WriteFile(path, []byte("10.9.0.1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	withStdin(t, "10.0.0.1\n")
+	networks, err := readTargets(path)
+	if err != nil || !reflect.DeepEqual(networks, []config.Network{{CIDR: "10.9.0.1/32"}}) {
+		t.Errorf("readTargets(file) = %v, %v, want the file's target, not stdin's", networks, err)
+	}
+	if _, err := readTargets(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("missing targets file read without error")
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"runtime"
+	"runtime/debug"
+)
+
+// Build info, set at link time:
+//
+//	go build -ldflags "-X main.version=1.2.0 -X main.commit=$(git rev-parse HEAD) -X main.buildDate=$(date -u +%FT%TZ)"
+//
+// Builds without them fall back to the VCS stamp Go embeds, if any.
+var (
+	version   = "dev"
+	commit    = ""
+	buildDate = ""
+)
+
+type versionResponse struct {
+	Version   string            `json:"version"`
+	Commit    string            `json:"commit,omitempty"`
+	BuildDate string            `json:"build_date,omitempty"`
+	GoVersion string            `json:"go_version"`
+	Tools     map[string]string `json:"tools"` // detected zmap/zgrab2 version, or "not found"
+}
+
+// buildInfo returns the scanner's own version fields
+func buildInfo() versionResponse {
+	info := versionResponse{
+		Version:   version,
+		Commit:    commit,
+		BuildDate: buildDate,
+		GoVersion: runtime.Version(),
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range bi.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = setting.Value
+				}
+			case "vcs.time":
+				if info.BuildDate == "" {
+					info.BuildDate = setting.Value
+				}
+			}
+		}
+	}
+	return info
+}
+
+// handleVersion reports build info and the external tool versions found
+// on PATH
+func (s *controlServer) handleVersion(w http.ResponseWriter, r *http.Request) {
+	info := buildInfo()
+	info.Tools = make(map[string]string)
+	for _, name := range []string{"zmap", "zgrab2"} {
+		info.Tools[name] = s.diag.toolVersion(name)
+	}
+	writeJSON(w, http.StatusOK, info)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"runtime"
+	"testing"
+)
+
+// setBuildInfo stands in for -ldflags -X, restoring the defaults after
+func setBuildInfo(t *testing.T, v, c, d string) {
+	t.Helper()
+	oldVersion, oldCommit, oldDate := version, commit, buildDate
+	version, commit, buildDate = v, c, d
+	t.Cleanup(func() { version, commit, buildDate = oldVersion, oldCommit, oldDate })
+}
+
+func TestVersionEndpoint(t *testing.T) {
+	setBuildInfo(t, "1.2.0", "0123456789abcdef", "2026-01-02T03:04:05Z")
+	healthy := true
+	s := newTestServer(t, zmapConfig("zmap"), stubAPI(t, &healthy).URL)
+	s.diag = stubDiagnostics(s.cfg)
+	missing("zgrab2")(s.diag)
+
+	var info versionResponse
+	if code := get(t, s.routes(), "/version", &info); code != http.StatusOK {
+		t.Fatalf("GET /version = %d", code)
+	}
+	if info.Version != "1.2.0" || info.Commit != "0123456789abcdef" || info.BuildDate != "2026-01-02T03:04:05Z" {
+		t.Errorf("build info = %+v, want the injected values", info)
+	}
+	if info.GoVersion != runtime.Version() {
+		t.Errorf("go_version = %q, want %q", info.GoVersion, runtime.Version())
+	}
+	if info.Tools["zmap"] != "v1.0" || info.Tools["zgrab2"] != "not found" {
+		t.Errorf("tools = %v, want zmap v1.0 and zgrab2 not found", info.Tools)
+	}
+}
+
+func TestBuildInfoDefaults(t *testing.T) {
+	setBuildInfo(t, "dev", "", "")
+	if info := buildInfo(); info.Version != "dev" || info.GoVersion == "" {
+		t.Errorf("buildInfo() = %+v, want version dev and the Go version", info)
+	}
+}
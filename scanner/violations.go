@@ -0,0 +1,103 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"network-scanner/config"
+	"network-scanner/db"
+)
+
+// maxLoggedViolations bounds the violations listed in the scan summary log
+const maxLoggedViolations = 50
+
+// violation is an open port the exposure policy doesn't allow
+type violation struct {
+	IPAddress   string `json:"ip_address"`
+	Port        int    `json:"port"`
+	Protocol    string `json:"protocol"`
+	ServiceName string `json:"service_name,omitempty"`
+	Rule        string `json:"rule"` // policy rule covering the host
+}
+
+type violationsResponse struct {
+	ScanTime   string      `json:"scan_time"`
+	Total      int         `json:"total"`
+	Violations []violation `json:"violations"`
+}
+
+// findViolations checks every open port in spool against policy
+func findViolations(policy *config.Policy, spool *db.Spool) ([]violation, error) {
+	violations := []violation{}
+	err := spool.Each(func(host db.ScanResultHost) error {
+		ip := net.ParseIP(host.IPAddress)
+		if ip == nil {
+			return nil
+		}
+		for _, port := range host.Ports {
+			if port.State != "open" {
+				continue
+			}
+			if rule, allowed := policy.Check(ip, port.PortNumber, port.Protocol); !allowed {
+				violations = append(violations, violation{
+					IPAddress:   host.IPAddress,
+					Port:        port.PortNumber,
+					Protocol:    port.Protocol,
+					ServiceName: port.ServiceName,
+					Rule:        rule,
+				})
+			}
+		}
+		return nil
+	})
+	return violations, err
+}
+
+// logViolations adds the policy check to the scan summary
+func logViolations(violations []violation) {
+	if len(violations) == 0 {
+		log.Println("Policy check: no violations")
+		return
+	}
+	log.Printf("Policy check: %d violations", len(violations))
+	for i, v := range violations {
+		if i == maxLoggedViolations {
+			log.Printf("  ... and %d more (see /violations)", len(violations)-i)
+			break
+		}
+		service := ""
+		if v.ServiceName != "" {
+			service = " (" + v.ServiceName + ")"
+		}
+		log.Printf("  %s %d/%s%s not allowed by %s", v.IPAddress, v.Port, v.Protocol, service, v.Rule)
+	}
+}
+
+// handleViolations serves the policy violations found in the last
+// completed scan
+func (s *controlServer) handleViolations(w http.ResponseWriter, r *http.Request) {
+	if s.results.policy == nil {
+		writeJSON(w, http.StatusNotFound, actionResponse{
+			Status:  "no_policy",
+			Message: "No policy_file is configured",
+		})
+		return
+	}
+
+	violations, scanTime, ok := s.results.policyViolations()
+	if !ok {
+		writeJSON(w, http.StatusNotFound, actionResponse{
+			Status:  "no_results",
+			Message: "No scan has completed yet",
+		})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, violationsResponse{
+		ScanTime:   scanTime.UTC().Format(time.RFC3339),
+		Total:      len(violations),
+		Violations: violations,
+	})
+}
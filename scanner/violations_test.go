@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"network-scanner/config"
+	"network-scanner/db"
+)
+
+// testPolicy allows only SSH on 10.0.0.0/29, plus 2222 on 10.0.0.5
+func testPolicy(t *testing.T) *config.Policy {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	yaml := `
+rules:
+  - name: servers
+    networks: [10.0.0.0/29]
+    allowed_ports: [22]
+  - name: bastion
+    networks: [10.0.0.5]
+    allowed_ports: [2222]
+`
+	if err := os.WriteFile(path, []byte(yaml), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	policy, err := config.LoadPolicy(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return policy
+}
+
+// policyHosts is knownHosts with a closed port that must not count
+func policyHosts() []db.ScanResultHost {
+	hosts := knownHosts()
+	hosts[2].Ports = append(hosts[2].Ports, db.ScanResultPort{PortNumber: 3306, Protocol: "tcp", State: "closed"})
+	return hosts
+}
+
+// wantViolations are the violations testPolicy finds in policyHosts: HTTPS
+// on the even hosts the servers rule covers. 10.0.0.8 and up are outside
+// every rule.
+var wantViolations = []violation{
+	{IPAddress: "10.0.0.2", Port: 443, Protocol: "tcp", ServiceName: "https", Rule: "servers"},
+	{IPAddress: "10.0.0.4", Port: 443, Protocol: "tcp", ServiceName: "https", Rule: "servers"},
+	{IPAddress: "10.0.0.6", Port: 443, Protocol: "tcp", ServiceName: "https", Rule: "servers"},
+}
+
+func TestFindViolations(t *testing.T) {
+	policy := testPolicy(t)
+	for _, spoolLimit := range []int{100, 3} {
+		spool := db.NewSpool(t.TempDir(), spoolLimit)
+		t.Cleanup(func() { spool.Close() })
+		for _, host := range policyHosts() {
+			if err := spool.Add(host); err != nil {
+				t.Fatal(err)
+			}
+		}
+		got, err := findViolations(policy, spool)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, wantViolations) {
+			t.Errorf("spool limit %d: violations = %+v, want %+v", spoolLimit, got, wantViolations)
+		}
+	}
+}
+
+func TestViolationsEndpoint(t *testing.T) {
+	healthy := true
+	s := newTestServer(t, &config.Config{}, stubAPI(t, &healthy).URL)
+	handler := s.routes()
+
+	// Without a policy there is nothing to report
+	s.results = &lastResults{}
+	if code := get(t, handler, "/violations", nil); code != http.StatusNotFound {
+		t.Errorf("GET /violations without a policy = %d, want 404", code)
+	}
+
+	// With a policy but before any scan completes
+	s.results = &lastResults{policy: testPolicy(t)}
+	var notYet actionResponse
+	if code := get(t, handler, "/violations", &notYet); code != http.StatusNotFound || notYet.Status != "no_results" {
+		t.Errorf("GET /violations before a scan = %d %+v, want 404 no_results", code, notYet)
+	}
+
+	spool := db.NewSpool(t.TempDir(), 100)
+	for _, host := range policyHosts() {
+		if err := spool.Add(host); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.results.set(spool, time.Date(2026, 10, 14, 9, 30, 0, 0, time.UTC))
+	t.Cleanup(func() { spool.Close() })
+
+	var resp violationsResponse
+	if code := get(t, handler, "/violations", &resp); code != http.StatusOK {
+		t.Fatalf("GET /violations = %d", code)
+	}
+	if resp.Total != len(wantViolations) || !reflect.DeepEqual(resp.Violations, wantViolations) {
+		t.Errorf("violations = %d %+v, want %+v", resp.Total, resp.Violations, wantViolations)
+	}
+	if resp.ScanTime != "2026-10-14T09:30:00Z" {
+		t.Errorf("scan_time = %q, want 2026-10-14T09:30:00Z", resp.ScanTime)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+
+	"network-scanner/config"
+	"network-scanner/db"
+	"network-scanner/scanner"
+)
+
+// watchEvent is the webhook body sent when watched ports change state
+type watchEvent struct {
+	Event   string        `json:"event"`
+	Time    time.Time     `json:"time"`
+	Changes []watchChange `json:"changes"`
+}
+
+// watchChange is one endpoint whose state flipped between watch runs
+type watchChange struct {
+	IP       string `json:"ip"`
+	Port     int    `json:"port"`
+	Protocol string `json:"protocol"`
+	Previous string `json:"previous"`
+	State    string `json:"state"`
+}
+
+// watcher re-checks the watch endpoints on their own cron, separate from
+// the full scan: it ignores pause, scan_window and the result spool, and
+// only reports open/closed flips to the webhook. The first run records a
+// baseline without posting.
+type watcher struct {
+	cron    *cron.Cron
+	checker *scanner.TCPScanner
+	targets []scanner.ZmapResult
+	webhook *db.Webhook
+	timeout time.Duration
+
+	running sync.Mutex
+	state   map[scanner.ZmapResult]string // nil until the baseline run
+}
+
+// newWatcher builds a watcher for the in-scope watch endpoints
+func newWatcher(cfg *config.Config, scope *scanner.Scope) (*watcher, error) {
+	watch := cfg.Watch
+	w := &watcher{
+		cron:    cron.New(),
+		checker: scanner.NewTCPScanner(nil, cfg.Rate, cfg.Timeout),
+		webhook: db.NewWebhook(watch.WebhookURL),
+		// A run must finish well before the next tick of a fast schedule
+		timeout: time.Duration(cfg.Timeout)*time.Second + 30*time.Second,
+	}
+	for _, ip := range watch.Targets {
+		if !scope.Contains(net.ParseIP(ip)) {
+			log.Printf("Watch: skipping out-of-scope target %s", ip)
+			continue
+		}
+		for _, port := range watch.Ports {
+			w.targets = append(w.targets, scanner.ZmapResult{IP: ip, Port: port})
+		}
+	}
+	if _, err := w.cron.AddFunc(watch.Schedule, w.run); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Start begins the watch schedule
+func (w *watcher) Start() {
+	w.cron.Start()
+}
+
+// Stop stops the watch schedule; a run in progress finishes
+func (w *watcher) Stop() {
+	w.cron.Stop()
+}
+
+// run checks every endpoint once and posts the flips since the last run. A
+// tick that lands while the previous run is still going is skipped.
+func (w *watcher) run() {
+	if !w.running.TryLock() {
+		log.Printf("Watch: previous run still in progress, skipping")
+		return
+	}
+	defer w.running.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), w.timeout)
+	defer cancel()
+
+	current := make(map[scanner.ZmapResult]string, len(w.targets))
+	err := w.checker.CheckEndpoints(ctx, w.targets, func(t scanner.ZmapResult, open bool) {
+		state := "closed"
+		if open {
+			state = "open"
+		}
+		current[scanner.ZmapResult{IP: t.IP, Port: t.Port}] = state
+	})
+	if err != nil {
+		// A partial run would report unchecked endpoints as unchanged at
+		// best, so keep the previous state and try again next tick
+		log.Printf("Watch run failed: %v", err)
+		return
+	}
+
+	if w.state == nil {
+		w.state = current
+		log.Printf("Watch: baseline recorded for %d endpoints", len(current))
+		return
+	}
+
+	var changes []watchChange
+	for _, t := range w.targets {
+		previous, state := w.state[t], current[t]
+		if previous == state {
+			continue
+		}
+		changes = append(changes, watchChange{
+			IP:       t.IP,
+			Port:     t.Port,
+			Protocol: "tcp",
+			Previous: previous,
+			State:    state,
+		})
+		log.Printf("Watch: %s:%d/tcp is now %s (was %s)", t.IP, t.Port, state, previous)
+	}
+	if len(changes) == 0 {
+		w.state = current
+		return
+	}
+
+	event := watchEvent{Event: "port_state_changed", Time: time.Now().UTC(), Changes: changes}
+	if err := w.webhook.Post(event); err != nil {
+		// Keep the old state so the same flips are reported next run
+		log.Printf("Watch: failed to send change event: %v", err)
+		return
+	}
+	w.state = current
+}
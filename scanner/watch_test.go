@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+
+	"network-scanner/config"
+	"network-scanner/scanner"
+)
+
+// watchHook records the events posted to it, answering each with the next
+// status in statuses and 200 once they run out
+type watchHook struct {
+	*httptest.Server
+	mu       sync.Mutex
+	events   []watchEvent
+	statuses []int
+}
+
+func newWatchHook(t *testing.T, statuses ...int) *watchHook {
+	t.Helper()
+	hook := &watchHook{statuses: statuses}
+	hook.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event watchEvent
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("decoding watch event: %v", err)
+		}
+		hook.mu.Lock()
+		defer hook.mu.Unlock()
+		hook.events = append(hook.events, event)
+		if len(hook.statuses) > 0 {
+			w.WriteHeader(hook.statuses[0])
+			hook.statuses = hook.statuses[1:]
+		}
+	}))
+	t.Cleanup(hook.Close)
+	return hook
+}
+
+// posted returns the events received so far
+func (h *watchHook) posted() []watchEvent {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]watchEvent(nil), h.events...)
+}
+
+// watchPort listens on 127.0.0.1:port (0 for any) until close is called,
+// and returns the port
+func watchPort(t *testing.T, port int) (int, func()) {
+	t.Helper()
+	listener, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	t.Cleanup(func() { listener.Close() })
+	return listener.Addr().(*net.TCPAddr).Port, func() { listener.Close() }
+}
+
+// testWatcher watches ports on 127.0.0.1, posting to hook
+func testWatcher(t *testing.T, hook *watchHook, scope *scanner.Scope, targets []string, ports ...int) *watcher {
+	t.Helper()
+	cfg := &config.Config{Rate: 10, Timeout: 1, Watch: config.Watch{
+		Schedule:   "* * * * *",
+		Targets:    targets,
+		Ports:      ports,
+		WebhookURL: hook.URL,
+	}}
+	w, err := newWatcher(cfg, scope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func TestWatchPostsFlips(t *testing.T) {
+	up, closeUp := watchPort(t, 0)
+	down, closeDown := watchPort(t, 0)
+	closeDown()
+	hook := newWatchHook(t)
+	w := testWatcher(t, hook, nil, []string{"127.0.0.1"}, up, down)
+
+	// The baseline, then a run with nothing changed
+	w.run()
+	w.run()
+	if events := hook.posted(); len(events) != 0 {
+		t.Fatalf("events %+v before any port flipped", events)
+	}
+
+	closeUp()
+	watchPort(t, down)
+	w.run()
+	events := hook.posted()
+	if len(events) != 1 {
+		t.Fatalf("%d events after two ports flipped, want 1", len(events))
+	}
+	want := []watchChange{
+		{IP: "127.0.0.1", Port: up, Protocol: "tcp", Previous: "open", State: "closed"},
+		{IP: "127.0.0.1", Port: down, Protocol: "tcp", Previous: "closed", State: "open"},
+	}
+	if events[0].Event != "port_state_changed" || events[0].Time.IsZero() || !reflect.DeepEqual(events[0].Changes, want) {
+		t.Errorf("event = %+v, want changes %+v", events[0], want)
+	}
+
+	// Flipped once, reported once
+	w.run()
+	if n := len(hook.posted()); n != 1 {
+		t.Errorf("%d events after a run with no flips, want still 1", n)
+	}
+}
+
+func TestWatchRepostsAfterWebhookFailure(t *testing.T) {
+	port, closePort := watchPort(t, 0)
+	hook := newWatchHook(t, http.StatusInternalServerError)
+	w := testWatcher(t, hook, nil, []string{"127.0.0.1"}, port)
+
+	w.run()
+	closePort()
+	w.run() // the webhook fails
+	w.run() // so the same flip is sent again
+	events := hook.posted()
+	if len(events) != 2 {
+		t.Fatalf("%d events, want the failed one and its retry", len(events))
+	}
+	if !reflect.DeepEqual(events[0].Changes, events[1].Changes) || events[1].Changes[0].State != "closed" {
+		t.Errorf("retried changes %+v, want %+v", events[1].Changes, events[0].Changes)
+	}
+}
+
+func TestWatchSkipsOutOfScopeTargets(t *testing.T) {
+	scope, err := scanner.NewScope(nil, []string{"127.0.0.2/32"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := testWatcher(t, newWatchHook(t), scope, []string{"127.0.0.1", "127.0.0.2"}, 22, 80)
+	want := []scanner.ZmapResult{{IP: "127.0.0.1", Port: 22}, {IP: "127.0.0.1", Port: 80}}
+	if !reflect.DeepEqual(w.targets, want) {
+		t.Errorf("targets = %v, want %v", w.targets, want)
+	}
+}